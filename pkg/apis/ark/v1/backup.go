@@ -170,8 +170,34 @@ type BackupStatus struct {
 	// ValidationErrors is a slice of all validation errors (if
 	// applicable).
 	ValidationErrors []string `json:"validationErrors"`
+
+	// PodVolumeBackups is a summary of the phases of the backup's
+	// PodVolumeBackups, if it has any.
+	PodVolumeBackups *PodVolumeBackupSummary `json:"podVolumeBackups,omitempty"`
+
+	// ResticPhase is the aggregate state of the backup's PodVolumeBackups,
+	// if it has any. It's kept up to date as they finish, including after
+	// the backup itself has completed.
+	ResticPhase BackupResticPhase `json:"resticPhase,omitempty"`
 }
 
+// BackupResticPhase is the aggregate state of a backup's PodVolumeBackups.
+type BackupResticPhase string
+
+const (
+	// BackupResticPhaseInProgress means some of the backup's
+	// PodVolumeBackups haven't finished.
+	BackupResticPhaseInProgress BackupResticPhase = "InProgress"
+
+	// BackupResticPhaseCompleted means all of the backup's PodVolumeBackups
+	// completed.
+	BackupResticPhaseCompleted BackupResticPhase = "Completed"
+
+	// BackupResticPhasePartiallyFailed means all of the backup's
+	// PodVolumeBackups finished, but some of them failed or were canceled.
+	BackupResticPhasePartiallyFailed BackupResticPhase = "PartiallyFailed"
+)
+
 // VolumeBackupInfo captures the required information about
 // a PersistentVolume at backup time to be able to restore
 // it later.
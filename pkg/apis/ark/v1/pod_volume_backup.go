@@ -33,15 +33,106 @@ type PodVolumeBackupSpec struct {
 	// up.
 	Volume string `json:"volume"`
 
+	// Volumes is a list of names of volumes within the Pod to be backed
+	// up. If Volume is also specified, it is treated as the first entry
+	// of this list.
+	Volumes []string `json:"volumes,omitempty"`
+
+	// VolumeSelector selects the volumes within the Pod to be backed up
+	// by the labels of the PersistentVolumeClaims backing them. It is
+	// ignored if Volume or Volumes is specified.
+	VolumeSelector *metav1.LabelSelector `json:"volumeSelector,omitempty"`
+
+	// PodVolumes are the definitions of the volumes to be backed up,
+	// copied from the Pod when the PodVolumeBackup is created. They're
+	// used to find the volumes on the node if the Pod has been deleted by
+	// the time the PodVolumeBackup is processed, e.g. because it belonged
+	// to a Job that completed.
+	PodVolumes []corev1api.Volume `json:"podVolumes,omitempty"`
+
 	// RepoPrefix is the restic repository prefix (i.e. not containing
-	// the repository name itself).
+	// the repository name itself). It may be a Go template referencing
+	// the pod's metadata, e.g. s3:s3.amazonaws.com/bucket/{{.Labels.team}},
+	// which is resolved when the backup starts.
 	RepoPrefix string `json:"repoPrefix"`
 
+	// MirrorRepoPrefixes are the prefixes of additional restic repositories,
+	// e.g. in another region for disaster recovery, that the volumes are
+	// backed up to once they've been backed up to RepoPrefix. The mirror
+	// repositories must use the same key as the primary repository.
+	MirrorRepoPrefixes []string `json:"mirrorRepoPrefixes,omitempty"`
+
+	// MirrorFailurePolicy is what to do when backing up to a mirror
+	// repository fails. Warn, the default, completes the backup and records
+	// the failure in its status; Fail fails the backup.
+	MirrorFailurePolicy PodVolumeBackupMirrorFailurePolicy `json:"mirrorFailurePolicy,omitempty"`
+
+	// CredentialsSecret references a secret holding the credentials to
+	// back up the volumes with, for repositories whose object store needs
+	// different credentials from the restic server's. The secret's
+	// ark-restic-credentials key holds the repository password, and each
+	// of its other keys is an environment variable, e.g. AWS_ACCESS_KEY_ID,
+	// set for restic. If the namespace is empty, it's the pod's namespace.
+	// If unset, the pod namespace's ark-restic-credentials secret is used.
+	CredentialsSecret *corev1api.SecretReference `json:"credentialsSecret,omitempty"`
+
 	// Tags are a map of key-value pairs that should be applied to the
-	// volume backup as tags.
+	// volume backup as tags. Values may be Go templates referencing the
+	// pod's metadata, e.g. {{.Labels.app}}; tags whose values resolve to
+	// empty strings are not applied.
 	Tags map[string]string `json:"tags"`
+
+	// Policy, if set, classifies the backup's snapshots, e.g. as daily or
+	// weekly, for retention policies that treat them differently. The
+	// snapshots are tagged policy=<Policy>, overriding any policy tag in
+	// Tags, and an unchanged volume's existing snapshot is only reused if
+	// it has the same policy.
+	Policy string `json:"policy,omitempty"`
+
+	// Force makes restic re-read all of the volume's files, rather than
+	// skipping those unchanged since the parent snapshot, e.g. after the
+	// repository is suspected to be corrupt. An unchanged volume's existing
+	// snapshot isn't reused.
+	Force bool `json:"force,omitempty"`
+
+	// SubPath, if set, is a directory, relative to the root of the volume,
+	// to back up instead of the whole volume, e.g. the subPath that the pod
+	// mounts of a PersistentVolumeClaim shared by several pods. It may only
+	// be set when a single, Filesystem mode volume is backed up.
+	SubPath string `json:"subPath,omitempty"`
+
+	// SubPathExpr, if set, is used like SubPath, but may reference the
+	// environment variables, as $(VAR_NAME), of the pod's container that
+	// mounts the volume, as a volume mount's subPathExpr does. It's
+	// resolved when the backup starts, from variables with a value or from
+	// a downward API field of the pod, and can't be set with SubPath.
+	SubPathExpr string `json:"subPathExpr,omitempty"`
+
+	// ExcludePatterns is a list of restic exclude patterns for files and
+	// directories within the volumes that should not be backed up.
+	ExcludePatterns []string `json:"excludePatterns,omitempty"`
+
+	// ExcludeLargerThan, if set, is the size, e.g. 500M, above which files
+	// within the volumes are not backed up. It's a number of bytes,
+	// optionally followed by one of the suffixes k, m, g or t, as accepted
+	// by restic's --exclude-larger-than flag. Block-mode volumes are backed
+	// up as a single file, so it doesn't apply to them.
+	ExcludeLargerThan string `json:"excludeLargerThan,omitempty"`
+
+	// Cancel indicates that the pod volume backup should be stopped. If
+	// it's in progress, the restic process running it is killed.
+	Cancel bool `json:"cancel,omitempty"`
 }
 
+// PodVolumeBackupMirrorFailurePolicy is what to do when backing up to a
+// mirror repository fails.
+type PodVolumeBackupMirrorFailurePolicy string
+
+const (
+	PodVolumeBackupMirrorFailurePolicyWarn PodVolumeBackupMirrorFailurePolicy = "Warn"
+	PodVolumeBackupMirrorFailurePolicyFail PodVolumeBackupMirrorFailurePolicy = "Fail"
+)
+
 // PodVolumeBackupPhase represents the lifecycle phase of a PodVolumeBackup.
 type PodVolumeBackupPhase string
 
@@ -50,6 +141,12 @@ const (
 	PodVolumeBackupPhaseInProgress PodVolumeBackupPhase = "InProgress"
 	PodVolumeBackupPhaseCompleted  PodVolumeBackupPhase = "Completed"
 	PodVolumeBackupPhaseFailed     PodVolumeBackupPhase = "Failed"
+	PodVolumeBackupPhaseCanceling  PodVolumeBackupPhase = "Canceling"
+	PodVolumeBackupPhaseCanceled   PodVolumeBackupPhase = "Canceled"
+
+	// PodVolumeBackupPhaseCompletedDryRun means the restic server was running
+	// in dry-run mode, so the backup was validated but restic was not run.
+	PodVolumeBackupPhaseCompletedDryRun PodVolumeBackupPhase = "CompletedDryRun"
 )
 
 // PodVolumeBackupStatus is the current status of a PodVolumeBackup.
@@ -60,11 +157,291 @@ type PodVolumeBackupStatus struct {
 	// Path is the full path within the controller pod being backed up.
 	Path string `json:"path"`
 
+	// SubPath is the directory, relative to the root of the volume, that
+	// was backed up, if the spec's SubPath or SubPathExpr is set.
+	SubPath string `json:"subPath,omitempty"`
+
+	// RepoPrefix is the restic repository prefix that the volumes were
+	// backed up to, if the spec's RepoPrefix is a template.
+	RepoPrefix string `json:"repoPrefix,omitempty"`
+
+	// Command is the restic backup command that was run, with its password
+	// file and any other secret values redacted. For a PodVolumeBackup of
+	// several volumes, it's the command for the most recently backed up one.
+	Command string `json:"command,omitempty"`
+
 	// SnapshotID is the identifier for the snapshot of the pod volume.
 	SnapshotID string `json:"snapshotID"`
 
+	// SnapshotIDs is a map of volume name to the identifier for the snapshot
+	// of that volume, for each volume that was successfully backed up.
+	SnapshotIDs map[string]string `json:"snapshotIDs,omitempty"`
+
+	// VolumeModes is a map of volume name to the mode, Filesystem or Block,
+	// in which each volume was backed up. A Block volume's snapshot holds
+	// the contents of its raw block device as a single file.
+	VolumeModes map[string]corev1api.PersistentVolumeMode `json:"volumeModes,omitempty"`
+
+	// SnapshotSize is the total size, in bytes, of the files in the pod
+	// volume backup's snapshots. It is zero if restic could not report it.
+	SnapshotSize int64 `json:"snapshotSize,omitempty"`
+
+	// SnapshotFileCount is the total number of files in the pod volume
+	// backup's snapshots. It is zero if restic could not report it.
+	SnapshotFileCount int64 `json:"snapshotFileCount,omitempty"`
+
 	// Message is a message about the pod volume backup's status.
 	Message string `json:"message"`
+
+	// FailureReason is a machine-readable category for why the pod volume
+	// backup failed. It is only set when Phase is Failed.
+	FailureReason PodVolumeBackupFailureReason `json:"failureReason,omitempty"`
+
+	// ExitCode is the exit code of the restic command that failed the pod
+	// volume backup, or 3 if restic couldn't read some of the volume's
+	// files. It's 0 if no restic command failed.
+	ExitCode int `json:"exitCode,omitempty"`
+
+	// Incomplete means restic couldn't read some of the volume's files, so
+	// the snapshot doesn't include them. Such backups complete, rather than
+	// fail, if the restic server's incomplete snapshot policy is warn.
+	Incomplete bool `json:"incomplete,omitempty"`
+
+	// LogsConfigMap is the name of the ConfigMap, in the pod volume
+	// backup's namespace, holding the output of its restic backups, if the
+	// restic server is configured to keep it. Each volume's output is held
+	// under the <volume>.stdout and <volume>.stderr keys.
+	LogsConfigMap string `json:"logsConfigMap,omitempty"`
+
+	// ForgottenSnapshots is the number of older snapshots of the pod
+	// volume backup's volumes that were forgotten once it completed,
+	// because the restic server's retention policy doesn't keep them.
+	ForgottenSnapshots int `json:"forgottenSnapshots,omitempty"`
+
+	// Progress holds the total number of bytes of the volume and the current
+	// number of backed up bytes. This can be used to display progress information
+	// about the backup operation.
+	Progress PodVolumeBackupProgress `json:"progress,omitempty"`
+
+	// Verification is the result of checking the restic repository's
+	// integrity after the backup completed, or, if the restic server
+	// periodically verifies completed backups, of the most recent check.
+	// It is empty if the restic server is not configured to verify backups.
+	Verification PodVolumeBackupVerification `json:"verification,omitempty"`
+
+	// LastVerified records the time the restic server last verified that
+	// the pod volume backup's snapshots are still restorable, after it
+	// completed. It is zero if it hasn't been verified since.
+	LastVerified metav1.Time `json:"lastVerified,omitempty"`
+
+	// Mirrors are the results of backing up to each of the mirror
+	// repositories in the spec.
+	Mirrors []PodVolumeBackupMirrorStatus `json:"mirrors,omitempty"`
+
+	// StartTimestamp records the time the restic server started the pod
+	// volume backup.
+	StartTimestamp metav1.Time `json:"startTimestamp"`
+
+	// CompletionTimestamp records the time the pod volume backup reached
+	// a terminal phase: Completed, CompletedDryRun, Failed or Canceled.
+	CompletionTimestamp metav1.Time `json:"completionTimestamp"`
+
+	// Restarts is the number of times the pod volume backup was found
+	// InProgress with no restic server running it, e.g. because the
+	// server restarted, and was reset to New to be retried.
+	Restarts int `json:"restarts,omitempty"`
+}
+
+// PodVolumeBackupFailureReason is a category of pod volume backup failure.
+type PodVolumeBackupFailureReason string
+
+const (
+	// PodVolumeBackupFailureReasonRepoNotFound means the restic repository
+	// has not been initialized.
+	PodVolumeBackupFailureReasonRepoNotFound PodVolumeBackupFailureReason = "RepoNotFound"
+
+	// PodVolumeBackupFailureReasonLockTimeout means restic could not lock
+	// the repository.
+	PodVolumeBackupFailureReasonLockTimeout PodVolumeBackupFailureReason = "LockTimeout"
+
+	// PodVolumeBackupFailureReasonPermissionDenied means restic was denied
+	// access to the volume's files or to the repository's storage.
+	PodVolumeBackupFailureReasonPermissionDenied PodVolumeBackupFailureReason = "PermissionDenied"
+
+	// PodVolumeBackupFailureReasonAuthFailed means the restic repository's
+	// password was wrong.
+	PodVolumeBackupFailureReasonAuthFailed PodVolumeBackupFailureReason = "AuthFailed"
+
+	// PodVolumeBackupFailureReasonRepoUnreachable means restic could not
+	// reach the repository's storage, e.g. because of a network failure,
+	// or listing the repository's snapshots timed out.
+	PodVolumeBackupFailureReasonRepoUnreachable PodVolumeBackupFailureReason = "RepoUnreachable"
+
+	// PodVolumeBackupFailureReasonSnapshotNotFound means restic backed up
+	// the volume, but its snapshot could not be found in the repository.
+	PodVolumeBackupFailureReasonSnapshotNotFound PodVolumeBackupFailureReason = "SnapshotNotFound"
+
+	// PodVolumeBackupFailureReasonVolumeNotFound means the volume could not
+	// be found in the pod or on the node.
+	PodVolumeBackupFailureReasonVolumeNotFound PodVolumeBackupFailureReason = "VolumeNotFound"
+
+	// PodVolumeBackupFailureReasonVolumeNotMounted means the pod is on the
+	// node but its volume's directory did not appear, e.g. because the
+	// volume was still being attached, within the restic server's volume
+	// mount timeout.
+	PodVolumeBackupFailureReasonVolumeNotMounted PodVolumeBackupFailureReason = "VolumeNotMounted"
+
+	// PodVolumeBackupFailureReasonHostPathNotAllowed means the volume is a
+	// hostPath volume whose path is not in the restic server's host path
+	// allow list.
+	PodVolumeBackupFailureReasonHostPathNotAllowed PodVolumeBackupFailureReason = "HostPathNotAllowed"
+
+	// PodVolumeBackupFailureReasonVolumeAccessDenied means the volume's
+	// directory was found on the node but the restic server was denied
+	// access to it, typically because SELinux is enforcing and the restic
+	// daemonset's pods aren't privileged or don't run with an SELinux type
+	// that can read pod volumes.
+	PodVolumeBackupFailureReasonVolumeAccessDenied PodVolumeBackupFailureReason = "VolumeAccessDenied"
+
+	// PodVolumeBackupFailureReasonBlockVolumeNotSupported means the volume
+	// is a block-mode volume and the restic server's version of restic
+	// can't back up block devices.
+	PodVolumeBackupFailureReasonBlockVolumeNotSupported PodVolumeBackupFailureReason = "BlockVolumeNotSupported"
+
+	// PodVolumeBackupFailureReasonTimeout means the restic backup did not
+	// complete within the restic server's backup timeout.
+	PodVolumeBackupFailureReasonTimeout PodVolumeBackupFailureReason = "Timeout"
+
+	// PodVolumeBackupFailureReasonInvalidSpec means the PodVolumeBackup's
+	// spec is invalid.
+	PodVolumeBackupFailureReasonInvalidSpec PodVolumeBackupFailureReason = "InvalidSpec"
+
+	// PodVolumeBackupFailureReasonNodeNotFound means the node the backup
+	// was assigned to no longer exists.
+	PodVolumeBackupFailureReasonNodeNotFound PodVolumeBackupFailureReason = "NodeNotFound"
+
+	// PodVolumeBackupFailureReasonVolumeTooLarge means the volume's contents
+	// exceeded the restic server's maximum volume size.
+	PodVolumeBackupFailureReasonVolumeTooLarge PodVolumeBackupFailureReason = "VolumeTooLarge"
+
+	// PodVolumeBackupFailureReasonIncompleteSnapshot means restic took the
+	// snapshot, but couldn't read some of the volume's files, and the
+	// restic server is configured to fail such backups.
+	PodVolumeBackupFailureReasonIncompleteSnapshot PodVolumeBackupFailureReason = "IncompleteSnapshot"
+
+	// PodVolumeBackupFailureReasonPasswordRotated means restic couldn't
+	// open the repository with the password from its credentials secret,
+	// which was changed shortly before, so the repository's keys probably
+	// weren't updated with the new password.
+	PodVolumeBackupFailureReasonPasswordRotated PodVolumeBackupFailureReason = "PasswordRotated"
+
+	// PodVolumeBackupFailureReasonVerificationFailed means the restic
+	// repository failed its integrity check after the backup, and the
+	// restic server is configured to fail backups when that happens.
+	PodVolumeBackupFailureReasonVerificationFailed PodVolumeBackupFailureReason = "VerificationFailed"
+
+	// PodVolumeBackupFailureReasonMirrorFailed means backing up to a mirror
+	// repository failed and the mirror failure policy is Fail.
+	PodVolumeBackupFailureReasonMirrorFailed PodVolumeBackupFailureReason = "MirrorFailed"
+
+	// PodVolumeBackupFailureReasonInterrupted means the backup was
+	// interrupted, e.g. by the restic server restarting, more times than
+	// it's retried.
+	PodVolumeBackupFailureReasonInterrupted PodVolumeBackupFailureReason = "Interrupted"
+
+	// PodVolumeBackupFailureReasonPostBackupHookFailed means the restic
+	// server's post-backup hook command failed, and the restic server is
+	// configured to fail backups when that happens.
+	PodVolumeBackupFailureReasonPostBackupHookFailed PodVolumeBackupFailureReason = "PostBackupHookFailed"
+
+	// PodVolumeBackupFailureReasonCircuitOpen means the backup was failed
+	// without running restic, because recent backups to the same
+	// repository failed and the restic server's circuit breaker is open
+	// for it.
+	PodVolumeBackupFailureReasonCircuitOpen PodVolumeBackupFailureReason = "CircuitOpen"
+
+	// PodVolumeBackupFailureReasonUnknown means the failure could not be
+	// categorized; see the message for details.
+	PodVolumeBackupFailureReasonUnknown PodVolumeBackupFailureReason = "Unknown"
+)
+
+// PodVolumeBackupSummary counts an Ark backup's PodVolumeBackups by phase.
+type PodVolumeBackupSummary struct {
+	// Total is the number of PodVolumeBackups.
+	Total int `json:"total"`
+
+	// New is the number of PodVolumeBackups that have not started.
+	New int `json:"new"`
+
+	// InProgress is the number of PodVolumeBackups that are running or
+	// being canceled.
+	InProgress int `json:"inProgress"`
+
+	// Completed is the number of PodVolumeBackups that completed,
+	// including dry runs.
+	Completed int `json:"completed"`
+
+	// Failed is the number of PodVolumeBackups that failed.
+	Failed int `json:"failed"`
+
+	// Canceled is the number of PodVolumeBackups that were canceled.
+	Canceled int `json:"canceled"`
+}
+
+// PodVolumeBackupProgress represents the progress of a restic backup of
+// a pod volume.
+type PodVolumeBackupProgress struct {
+	TotalBytes int64 `json:"totalBytes,omitempty"`
+	BytesDone  int64 `json:"bytesDone,omitempty"`
+}
+
+// PodVolumeBackupVerificationPhase is the result of verifying a pod volume
+// backup.
+type PodVolumeBackupVerificationPhase string
+
+const (
+	// PodVolumeBackupVerificationPhaseVerified means the repository passed
+	// an integrity check that read all of its data.
+	PodVolumeBackupVerificationPhaseVerified PodVolumeBackupVerificationPhase = "Verified"
+
+	// PodVolumeBackupVerificationPhasePartiallyVerified means the repository
+	// passed an integrity check that read a subset of its data.
+	PodVolumeBackupVerificationPhasePartiallyVerified PodVolumeBackupVerificationPhase = "PartiallyVerified"
+
+	// PodVolumeBackupVerificationPhaseFailed means the repository failed
+	// its integrity check.
+	PodVolumeBackupVerificationPhaseFailed PodVolumeBackupVerificationPhase = "VerificationFailed"
+)
+
+// PodVolumeBackupVerification is the result of running 'restic check' on
+// the repository after a pod volume backup completed.
+type PodVolumeBackupVerification struct {
+	Phase PodVolumeBackupVerificationPhase `json:"phase,omitempty"`
+
+	// ReadDataPercent is the percentage of the repository's data that
+	// was read and verified.
+	ReadDataPercent int `json:"readDataPercent,omitempty"`
+
+	// Message is a message about the verification's result.
+	Message string `json:"message,omitempty"`
+}
+
+// PodVolumeBackupMirrorStatus is the result of backing up a PodVolumeBackup's
+// volumes to one of its mirror repositories.
+type PodVolumeBackupMirrorStatus struct {
+	// RepoPrefix is the mirror repository's prefix.
+	RepoPrefix string `json:"repoPrefix"`
+
+	// Phase is Completed if all of the volumes were backed up to the
+	// mirror repository, and Failed otherwise.
+	Phase PodVolumeBackupPhase `json:"phase"`
+
+	// SnapshotIDs is a map of volume name to the identifier for the
+	// snapshot of that volume in the mirror repository.
+	SnapshotIDs map[string]string `json:"snapshotIDs,omitempty"`
+
+	// Message is a message about the mirror backup's result.
+	Message string `json:"message,omitempty"`
 }
 
 // +genclient
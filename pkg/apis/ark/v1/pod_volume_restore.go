@@ -30,11 +30,17 @@ type PodVolumeRestoreSpec struct {
 	Volume string `json:"volume"`
 
 	// RepoPrefix is the restic repository prefix (i.e. not containing
-	// the repository name itself).
+	// the repository name itself). Like a PodVolumeBackup's, it may be a Go
+	// template referencing the pod's metadata.
 	RepoPrefix string `json:"repoPrefix"`
 
 	// SnapshotID is the ID of the volume snapshot to be restored.
 	SnapshotID string `json:"snapshotID"`
+
+	// IncludePaths is a list of files and directories, relative to the
+	// root of the volume, to restore from the snapshot. If empty, the
+	// whole volume is restored.
+	IncludePaths []string `json:"includePaths,omitempty"`
 }
 
 // PodVolumeRestorePhase represents the lifecycle phase of a PodVolumeRestore.
@@ -21,6 +21,7 @@ limitations under the License.
 package v1
 
 import (
+	core_v1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
@@ -286,6 +287,15 @@ func (in *BackupStatus) DeepCopyInto(out *BackupStatus) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.PodVolumeBackups != nil {
+		in, out := &in.PodVolumeBackups, &out.PodVolumeBackups
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(PodVolumeBackupSummary)
+			**out = **in
+		}
+	}
 	return
 }
 
@@ -654,7 +664,7 @@ func (in *PodVolumeBackup) DeepCopyInto(out *PodVolumeBackup) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -709,10 +719,84 @@ func (in *PodVolumeBackupList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodVolumeBackupMirrorStatus) DeepCopyInto(out *PodVolumeBackupMirrorStatus) {
+	*out = *in
+	if in.SnapshotIDs != nil {
+		in, out := &in.SnapshotIDs, &out.SnapshotIDs
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodVolumeBackupMirrorStatus.
+func (in *PodVolumeBackupMirrorStatus) DeepCopy() *PodVolumeBackupMirrorStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PodVolumeBackupMirrorStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodVolumeBackupProgress) DeepCopyInto(out *PodVolumeBackupProgress) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodVolumeBackupProgress.
+func (in *PodVolumeBackupProgress) DeepCopy() *PodVolumeBackupProgress {
+	if in == nil {
+		return nil
+	}
+	out := new(PodVolumeBackupProgress)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PodVolumeBackupSpec) DeepCopyInto(out *PodVolumeBackupSpec) {
 	*out = *in
 	out.Pod = in.Pod
+	if in.Volumes != nil {
+		in, out := &in.Volumes, &out.Volumes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.VolumeSelector != nil {
+		in, out := &in.VolumeSelector, &out.VolumeSelector
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(meta_v1.LabelSelector)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	if in.PodVolumes != nil {
+		in, out := &in.PodVolumes, &out.PodVolumes
+		*out = make([]core_v1.Volume, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.MirrorRepoPrefixes != nil {
+		in, out := &in.MirrorRepoPrefixes, &out.MirrorRepoPrefixes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.CredentialsSecret != nil {
+		in, out := &in.CredentialsSecret, &out.CredentialsSecret
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(core_v1.SecretReference)
+			**out = **in
+		}
+	}
 	if in.Tags != nil {
 		in, out := &in.Tags, &out.Tags
 		*out = make(map[string]string, len(*in))
@@ -720,6 +804,11 @@ func (in *PodVolumeBackupSpec) DeepCopyInto(out *PodVolumeBackupSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.ExcludePatterns != nil {
+		in, out := &in.ExcludePatterns, &out.ExcludePatterns
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -736,6 +825,32 @@ func (in *PodVolumeBackupSpec) DeepCopy() *PodVolumeBackupSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PodVolumeBackupStatus) DeepCopyInto(out *PodVolumeBackupStatus) {
 	*out = *in
+	if in.SnapshotIDs != nil {
+		in, out := &in.SnapshotIDs, &out.SnapshotIDs
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.VolumeModes != nil {
+		in, out := &in.VolumeModes, &out.VolumeModes
+		*out = make(map[string]core_v1.PersistentVolumeMode, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	out.Progress = in.Progress
+	out.Verification = in.Verification
+	in.LastVerified.DeepCopyInto(&out.LastVerified)
+	if in.Mirrors != nil {
+		in, out := &in.Mirrors, &out.Mirrors
+		*out = make([]PodVolumeBackupMirrorStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.StartTimestamp.DeepCopyInto(&out.StartTimestamp)
+	in.CompletionTimestamp.DeepCopyInto(&out.CompletionTimestamp)
 	return
 }
 
@@ -749,12 +864,44 @@ func (in *PodVolumeBackupStatus) DeepCopy() *PodVolumeBackupStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodVolumeBackupSummary) DeepCopyInto(out *PodVolumeBackupSummary) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodVolumeBackupSummary.
+func (in *PodVolumeBackupSummary) DeepCopy() *PodVolumeBackupSummary {
+	if in == nil {
+		return nil
+	}
+	out := new(PodVolumeBackupSummary)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodVolumeBackupVerification) DeepCopyInto(out *PodVolumeBackupVerification) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodVolumeBackupVerification.
+func (in *PodVolumeBackupVerification) DeepCopy() *PodVolumeBackupVerification {
+	if in == nil {
+		return nil
+	}
+	out := new(PodVolumeBackupVerification)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PodVolumeRestore) DeepCopyInto(out *PodVolumeRestore) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	out.Status = in.Status
 	return
 }
@@ -814,6 +961,11 @@ func (in *PodVolumeRestoreList) DeepCopyObject() runtime.Object {
 func (in *PodVolumeRestoreSpec) DeepCopyInto(out *PodVolumeRestoreSpec) {
 	*out = *in
 	out.Pod = in.Pod
+	if in.IncludePaths != nil {
+		in, out := &in.IncludePaths, &out.IncludePaths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -0,0 +1,279 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/client"
+	"github.com/heptio/ark/pkg/cmd"
+	arkv1client "github.com/heptio/ark/pkg/generated/clientset/versioned/typed/ark/v1"
+	"github.com/heptio/ark/pkg/restic"
+)
+
+// manualBackupLabel marks PodVolumeBackups created by 'ark restic backup'
+// rather than by an Ark backup.
+const manualBackupLabel = "ark.heptio.com/manual-pod-volume-backup"
+
+func NewBackupCommand(f client.Factory) *cobra.Command {
+	o := NewBackupOptions()
+
+	c := &cobra.Command{
+		Use:   "backup NAMESPACE/POD VOLUME",
+		Short: "Back up a single pod volume with restic",
+		Long: `Back up a single pod volume with restic, without creating an Ark backup.
+
+A PodVolumeBackup is created for the volume and the command waits for the
+restic server on the pod's node to finish it, then prints the snapshot ID.
+This is intended for testing restic backups.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(c *cobra.Command, args []string) {
+			cmd.CheckError(o.Complete(args, f))
+			cmd.CheckError(o.Validate())
+			cmd.CheckError(o.Run(f))
+		},
+	}
+
+	o.BindFlags(c.Flags())
+
+	return c
+}
+
+type BackupOptions struct {
+	PodNamespace string
+	PodName      string
+	Volume       string
+	Node         string
+	RepoPrefix   string
+	Timeout      time.Duration
+
+	ExcludeLargerThan string
+	SubPath           string
+	Policy            string
+	Force             bool
+
+	namespace    string
+	pollInterval time.Duration
+}
+
+func NewBackupOptions() *BackupOptions {
+	return &BackupOptions{
+		Timeout:      time.Hour,
+		pollInterval: time.Second,
+	}
+}
+
+func (o *BackupOptions) BindFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&o.Node, "node", o.Node, "the node whose restic server runs the backup. Optional; defaults to the node the pod is scheduled on.")
+	flags.StringVar(&o.RepoPrefix, "repo-prefix", o.RepoPrefix, "the prefix of the restic repository to back up to, such as s3:s3.amazonaws.com/bucket/restic. May be a Go template referencing the pod's metadata, such as s3:s3.amazonaws.com/bucket/{{.Labels.team}}. Optional; defaults to the restic location in the Ark config.")
+	flags.DurationVar(&o.Timeout, "timeout", o.Timeout, "how long to wait for the backup to finish")
+	flags.StringVar(&o.ExcludeLargerThan, "exclude-larger-than", o.ExcludeLargerThan, "don't back up files in the volume larger than this size, e.g. 500M: a number of bytes, optionally followed by one of the suffixes k, m, g or t. Optional; by default, files of any size are backed up.")
+	flags.StringVar(&o.SubPath, "sub-path", o.SubPath, "the directory, relative to the root of the volume, to back up instead of the whole volume, e.g. the subPath the pod mounts. Optional; by default, the whole volume is backed up.")
+	flags.StringVar(&o.Policy, "policy", o.Policy, "the policy, e.g. daily or weekly, to tag the volume's snapshot with, so that retention policies can treat it differently from snapshots taken under other policies. Optional.")
+	flags.BoolVar(&o.Force, "force", o.Force, "have restic re-read all of the volume's files rather than skipping those unchanged since the parent snapshot, e.g. after the repository is suspected to be corrupt")
+}
+
+func (o *BackupOptions) Complete(args []string, f client.Factory) error {
+	parts := strings.Split(args[0], "/")
+	if len(parts) != 2 {
+		return errors.Errorf("pod must be specified as NAMESPACE/POD, got %q", args[0])
+	}
+
+	o.PodNamespace = parts[0]
+	o.PodName = parts[1]
+	o.Volume = args[1]
+	o.namespace = f.Namespace()
+
+	return nil
+}
+
+func (o *BackupOptions) Validate() error {
+	if o.PodNamespace == "" || o.PodName == "" {
+		return errors.New("pod namespace and name must not be empty")
+	}
+	if o.Volume == "" {
+		return errors.New("volume must not be empty")
+	}
+	if o.Timeout <= 0 {
+		return errors.Errorf("--timeout must be positive, got %s", o.Timeout)
+	}
+	if o.ExcludeLargerThan != "" {
+		if err := restic.ValidateExcludeLargerThan(o.ExcludeLargerThan); err != nil {
+			return errors.Wrap(err, "invalid --exclude-larger-than")
+		}
+	}
+	if o.SubPath != "" {
+		if _, err := restic.CleanSubPath(o.SubPath); err != nil {
+			return errors.Wrap(err, "invalid --sub-path")
+		}
+	}
+	if o.Policy != "" {
+		if err := restic.ValidatePolicy(o.Policy); err != nil {
+			return errors.Wrap(err, "invalid --policy")
+		}
+	}
+
+	return nil
+}
+
+func (o *BackupOptions) Run(f client.Factory) error {
+	kubeClient, err := f.KubeClient()
+	if err != nil {
+		return err
+	}
+
+	arkClient, err := f.Client()
+	if err != nil {
+		return err
+	}
+
+	pod, err := kubeClient.CoreV1().Pods(o.PodNamespace).Get(o.PodName, metav1.GetOptions{})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if o.RepoPrefix == "" {
+		config, err := arkClient.ArkV1().Configs(o.namespace).Get("default", metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrap(err, "error getting Ark config; set --repo-prefix to back up without it")
+		}
+		if config.BackupStorageProvider.ResticLocation == "" {
+			return errors.New("Ark config has no restic location; set --repo-prefix")
+		}
+		o.RepoPrefix = restic.RepoPrefix(config.BackupStorageProvider)
+	}
+
+	pvb, err := o.newPodVolumeBackup(pod)
+	if err != nil {
+		return err
+	}
+
+	pvb, err = arkClient.ArkV1().PodVolumeBackups(o.namespace).Create(pvb)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	fmt.Printf("PodVolumeBackup %q created, waiting for it to finish on node %s.\n", pvb.Name, pvb.Spec.Node)
+
+	pvb, err = waitForPodVolumeBackup(arkClient.ArkV1(), pvb.Namespace, pvb.Name, o.pollInterval, o.Timeout)
+	if err != nil {
+		return err
+	}
+
+	if pvb.Status.Phase != arkv1api.PodVolumeBackupPhaseCompleted {
+		return errors.Errorf("PodVolumeBackup %q finished with phase %s: %s", pvb.Name, pvb.Status.Phase, pvb.Status.Message)
+	}
+
+	fmt.Printf("PodVolumeBackup %q completed, snapshot ID: %s\n", pvb.Name, pvb.Status.SnapshotID)
+	return nil
+}
+
+// newPodVolumeBackup returns a PodVolumeBackup of the options' volume of
+// the given pod.
+func (o *BackupOptions) newPodVolumeBackup(pod *corev1api.Pod) (*arkv1api.PodVolumeBackup, error) {
+	node := o.Node
+	if node == "" {
+		node = pod.Spec.NodeName
+	}
+	if node == "" {
+		return nil, errors.Errorf("pod %s/%s is not scheduled to a node; set --node", pod.Namespace, pod.Name)
+	}
+
+	var found bool
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == o.Volume {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, errors.Errorf("pod %s/%s has no volume named %s", pod.Namespace, pod.Name, o.Volume)
+	}
+
+	return &arkv1api.PodVolumeBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:    o.namespace,
+			GenerateName: pod.Name + "-" + o.Volume + "-",
+			Labels: map[string]string{
+				manualBackupLabel: "true",
+			},
+		},
+		Spec: arkv1api.PodVolumeBackupSpec{
+			Node: node,
+			Pod: corev1api.ObjectReference{
+				Kind:      "Pod",
+				Namespace: pod.Namespace,
+				Name:      pod.Name,
+				UID:       pod.UID,
+			},
+			Volume: o.Volume,
+			Tags: map[string]string{
+				"pod":     pod.Name,
+				"pod-uid": string(pod.UID),
+				"ns":      pod.Namespace,
+				"volume":  o.Volume,
+			},
+			RepoPrefix:        o.RepoPrefix,
+			ExcludeLargerThan: o.ExcludeLargerThan,
+			SubPath:           o.SubPath,
+			Policy:            o.Policy,
+			Force:             o.Force,
+		},
+	}, nil
+}
+
+// waitForPodVolumeBackup polls the PodVolumeBackup until it reaches a
+// terminal phase or the timeout expires, and returns it.
+func waitForPodVolumeBackup(client arkv1client.PodVolumeBackupsGetter, namespace, name string, interval, timeout time.Duration) (*arkv1api.PodVolumeBackup, error) {
+	var pvb *arkv1api.PodVolumeBackup
+
+	err := wait.PollImmediate(interval, timeout, func() (bool, error) {
+		var err error
+		pvb, err = client.PodVolumeBackups(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+
+		switch pvb.Status.Phase {
+		case arkv1api.PodVolumeBackupPhaseCompleted,
+			arkv1api.PodVolumeBackupPhaseCompletedDryRun,
+			arkv1api.PodVolumeBackupPhaseFailed,
+			arkv1api.PodVolumeBackupPhaseCanceled:
+			return true, nil
+		default:
+			return false, nil
+		}
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil, errors.Errorf("timed out waiting for PodVolumeBackup %q to finish; it's in phase %s", name, pvb.Status.Phase)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return pvb, nil
+}
@@ -0,0 +1,306 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	core "k8s.io/client-go/testing"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/generated/clientset/versioned/fake"
+)
+
+func TestBackupOptionsCompleteAndValidate(t *testing.T) {
+	tests := []struct {
+		name                 string
+		args                 []string
+		timeout              time.Duration
+		excludeLargerThan    string
+		subPath              string
+		policy               string
+		expectedErr          string
+		expectedPodNamespace string
+		expectedPodName      string
+	}{
+		{
+			name:                 "valid args",
+			args:                 []string{"ns-1/pod-1", "vol-1"},
+			timeout:              time.Minute,
+			expectedPodNamespace: "ns-1",
+			expectedPodName:      "pod-1",
+		},
+		{
+			name:        "pod without a namespace",
+			args:        []string{"pod-1", "vol-1"},
+			timeout:     time.Minute,
+			expectedErr: `pod must be specified as NAMESPACE/POD, got "pod-1"`,
+		},
+		{
+			name:        "pod with too many parts",
+			args:        []string{"ns-1/pod-1/extra", "vol-1"},
+			timeout:     time.Minute,
+			expectedErr: `pod must be specified as NAMESPACE/POD, got "ns-1/pod-1/extra"`,
+		},
+		{
+			name:        "empty pod name",
+			args:        []string{"ns-1/", "vol-1"},
+			timeout:     time.Minute,
+			expectedErr: "pod namespace and name must not be empty",
+		},
+		{
+			name:        "empty volume",
+			args:        []string{"ns-1/pod-1", ""},
+			timeout:     time.Minute,
+			expectedErr: "volume must not be empty",
+		},
+		{
+			name:        "zero timeout",
+			args:        []string{"ns-1/pod-1", "vol-1"},
+			expectedErr: "--timeout must be positive, got 0s",
+		},
+		{
+			name:                 "valid exclude-larger-than size",
+			args:                 []string{"ns-1/pod-1", "vol-1"},
+			timeout:              time.Minute,
+			excludeLargerThan:    "500M",
+			expectedPodNamespace: "ns-1",
+			expectedPodName:      "pod-1",
+		},
+		{
+			name:              "invalid exclude-larger-than size",
+			args:              []string{"ns-1/pod-1", "vol-1"},
+			timeout:           time.Minute,
+			excludeLargerThan: "500MB",
+			expectedErr:       `invalid --exclude-larger-than: size "500MB" must be a number of bytes, optionally followed by one of the suffixes k, m, g or t`,
+		},
+		{
+			name:                 "valid sub-path",
+			args:                 []string{"ns-1/pod-1", "vol-1"},
+			timeout:              time.Minute,
+			subPath:              "data/app-1",
+			expectedPodNamespace: "ns-1",
+			expectedPodName:      "pod-1",
+		},
+		{
+			name:        "sub-path outside of the volume",
+			args:        []string{"ns-1/pod-1", "vol-1"},
+			timeout:     time.Minute,
+			subPath:     "../vol-2",
+			expectedErr: "invalid --sub-path: subPath ../vol-2 must not contain '..'",
+		},
+		{
+			name:                 "valid policy",
+			args:                 []string{"ns-1/pod-1", "vol-1"},
+			timeout:              time.Minute,
+			policy:               "weekly",
+			expectedPodNamespace: "ns-1",
+			expectedPodName:      "pod-1",
+		},
+		{
+			name:        "invalid policy",
+			args:        []string{"ns-1/pod-1", "vol-1"},
+			timeout:     time.Minute,
+			policy:      "daily,weekly",
+			expectedErr: `invalid --policy: policy "daily,weekly" must be 1-63 alphanumeric characters, '-', '_' or '.', and start and end with an alphanumeric character`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o := NewBackupOptions()
+			o.Timeout = test.timeout
+			o.ExcludeLargerThan = test.excludeLargerThan
+			o.SubPath = test.subPath
+			o.Policy = test.policy
+
+			err := o.Complete(test.args, &fakeFactory{})
+			if err == nil {
+				err = o.Validate()
+			}
+
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedPodNamespace, o.PodNamespace)
+			assert.Equal(t, test.expectedPodName, o.PodName)
+			assert.Equal(t, test.args[1], o.Volume)
+		})
+	}
+}
+
+func TestNewPodVolumeBackup(t *testing.T) {
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+		Spec: corev1api.PodSpec{
+			NodeName: "node-1",
+			Volumes: []corev1api.Volume{
+				{Name: "vol-1"},
+			},
+		},
+	}
+
+	o := &BackupOptions{
+		PodNamespace: "ns-1",
+		PodName:      "pod-1",
+		Volume:       "vol-1",
+		RepoPrefix:   "s3:s3.amazonaws.com/bucket/restic",
+		namespace:    "heptio-ark",
+	}
+
+	pvb, err := o.newPodVolumeBackup(pod)
+	require.NoError(t, err)
+
+	expected := &arkv1api.PodVolumeBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:    "heptio-ark",
+			GenerateName: "pod-1-vol-1-",
+			Labels: map[string]string{
+				manualBackupLabel: "true",
+			},
+		},
+		Spec: arkv1api.PodVolumeBackupSpec{
+			Node: "node-1",
+			Pod: corev1api.ObjectReference{
+				Kind:      "Pod",
+				Namespace: "ns-1",
+				Name:      "pod-1",
+				UID:       "pod-uid",
+			},
+			Volume: "vol-1",
+			Tags: map[string]string{
+				"pod":     "pod-1",
+				"pod-uid": "pod-uid",
+				"ns":      "ns-1",
+				"volume":  "vol-1",
+			},
+			RepoPrefix: "s3:s3.amazonaws.com/bucket/restic",
+		},
+	}
+	assert.Equal(t, expected, pvb)
+
+	// files above a size can be excluded
+	o.ExcludeLargerThan = "1G"
+	pvb, err = o.newPodVolumeBackup(pod)
+	require.NoError(t, err)
+	assert.Equal(t, "1G", pvb.Spec.ExcludeLargerThan)
+
+	// a subdirectory of the volume can be backed up
+	o.SubPath = "data/app-1"
+	pvb, err = o.newPodVolumeBackup(pod)
+	require.NoError(t, err)
+	assert.Equal(t, "data/app-1", pvb.Spec.SubPath)
+
+	// snapshots can be tagged with a policy
+	o.Policy = "weekly"
+	pvb, err = o.newPodVolumeBackup(pod)
+	require.NoError(t, err)
+	assert.Equal(t, "weekly", pvb.Spec.Policy)
+
+	// a full re-scan can be forced
+	o.Force = true
+	pvb, err = o.newPodVolumeBackup(pod)
+	require.NoError(t, err)
+	assert.True(t, pvb.Spec.Force)
+
+	// the node can be overridden
+	o.Node = "node-2"
+	pvb, err = o.newPodVolumeBackup(pod)
+	require.NoError(t, err)
+	assert.Equal(t, "node-2", pvb.Spec.Node)
+
+	// the volume must exist in the pod
+	o.Volume = "vol-2"
+	_, err = o.newPodVolumeBackup(pod)
+	assert.EqualError(t, err, "pod ns-1/pod-1 has no volume named vol-2")
+
+	// the pod must be scheduled if no node is specified
+	o.Volume = "vol-1"
+	o.Node = ""
+	pod.Spec.NodeName = ""
+	_, err = o.newPodVolumeBackup(pod)
+	assert.EqualError(t, err, "pod ns-1/pod-1 is not scheduled to a node; set --node")
+}
+
+func TestWaitForPodVolumeBackup(t *testing.T) {
+	tests := []struct {
+		name          string
+		phases        []arkv1api.PodVolumeBackupPhase
+		expectedPhase arkv1api.PodVolumeBackupPhase
+		expectedErr   bool
+	}{
+		{
+			name:          "completed",
+			phases:        []arkv1api.PodVolumeBackupPhase{arkv1api.PodVolumeBackupPhaseNew, arkv1api.PodVolumeBackupPhaseInProgress, arkv1api.PodVolumeBackupPhaseCompleted},
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+		{
+			name:          "failed",
+			phases:        []arkv1api.PodVolumeBackupPhase{arkv1api.PodVolumeBackupPhaseInProgress, arkv1api.PodVolumeBackupPhaseFailed},
+			expectedPhase: arkv1api.PodVolumeBackupPhaseFailed,
+		},
+		{
+			name:        "never finishes",
+			phases:      []arkv1api.PodVolumeBackupPhase{arkv1api.PodVolumeBackupPhaseInProgress},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+
+			var gets int
+			client.PrependReactor("get", "podvolumebackups", func(action core.Action) (bool, runtime.Object, error) {
+				phase := test.phases[len(test.phases)-1]
+				if gets < len(test.phases) {
+					phase = test.phases[gets]
+				}
+				gets++
+
+				return true, &arkv1api.PodVolumeBackup{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "heptio-ark", Name: "pvb-1"},
+					Status:     arkv1api.PodVolumeBackupStatus{Phase: phase},
+				}, nil
+			})
+
+			pvb, err := waitForPodVolumeBackup(client.ArkV1(), "heptio-ark", "pvb-1", time.Millisecond, 100*time.Millisecond)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedPhase, pvb.Status.Phase)
+			assert.Equal(t, len(test.phases), gets)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/clock"
+
+	"github.com/heptio/ark/pkg/controller"
+)
+
+// runningBackupStatus describes a running backup in the debug endpoint's
+// response.
+type runningBackupStatus struct {
+	Name       string `json:"name"`
+	Volume     string `json:"volume,omitempty"`
+	StartTime  string `json:"startTime"`
+	RunningFor string `json:"runningFor"`
+	Command    string `json:"command,omitempty"`
+}
+
+// runningBackupsStatus is the debug endpoint's response.
+type runningBackupsStatus struct {
+	Backups []runningBackupStatus `json:"backups"`
+}
+
+// runningBackupsHandler reports, as JSON, the PodVolumeBackups that the
+// restic server is currently processing, for live troubleshooting. The
+// restic commands it reports have their credentials redacted.
+type runningBackupsHandler struct {
+	runningBackups controller.RunningBackupsLister
+	clock          clock.Clock
+	logger         logrus.FieldLogger
+}
+
+func (h *runningBackupsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := runningBackupsStatus{
+		Backups: []runningBackupStatus{},
+	}
+
+	now := h.clock.Now()
+	for _, backup := range h.runningBackups.RunningBackups() {
+		status.Backups = append(status.Backups, runningBackupStatus{
+			Name:       backup.Key,
+			Volume:     backup.Volume,
+			StartTime:  backup.StartTime.UTC().Format(time.RFC3339),
+			RunningFor: now.Sub(backup.StartTime).Round(time.Second).String(),
+			Command:    backup.Command,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		h.logger.WithError(err).Error("Error writing running backups")
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/clock"
+
+	"github.com/heptio/ark/pkg/controller"
+	arktest "github.com/heptio/ark/pkg/util/test"
+)
+
+type fakeRunningBackupsLister []controller.RunningBackup
+
+func (l fakeRunningBackupsLister) RunningBackups() []controller.RunningBackup {
+	return l
+}
+
+func TestRunningBackupsHandler(t *testing.T) {
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		backups      []controller.RunningBackup
+		expectedBody string
+	}{
+		{
+			name:         "no running backups",
+			expectedBody: `{"backups":[]}`,
+		},
+		{
+			name: "running backups",
+			backups: []controller.RunningBackup{
+				{
+					Key:       "heptio-ark/pvb-1",
+					StartTime: now.Add(-90 * time.Second),
+					Volume:    "vol-1",
+					Command:   "restic backup --repo=s3:s3.amazonaws.com/bucket/ns-1 --password-file=<redacted> /host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1",
+				},
+				{
+					Key:       "heptio-ark/pvb-2",
+					StartTime: now.Add(-5 * time.Second),
+				},
+			},
+			expectedBody: `{"backups":[` +
+				`{"name":"heptio-ark/pvb-1","volume":"vol-1","startTime":"2018-06-01T11:58:30Z","runningFor":"1m30s","command":"restic backup --repo=s3:s3.amazonaws.com/bucket/ns-1 --password-file=<redacted> /host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1"},` +
+				`{"name":"heptio-ark/pvb-2","startTime":"2018-06-01T11:59:55Z","runningFor":"5s"}` +
+				`]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := &runningBackupsHandler{
+				runningBackups: fakeRunningBackupsLister(test.backups),
+				clock:          clock.NewFakeClock(now),
+				logger:         arktest.NewLogger(),
+			}
+
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/debug/backups", nil))
+
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, "application/json", res.Header().Get("Content-Type"))
+			assert.JSONEq(t, test.expectedBody, res.Body.String())
+		})
+	}
+}
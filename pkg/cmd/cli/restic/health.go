@@ -0,0 +1,88 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/heptio/ark/pkg/controller"
+)
+
+const (
+	// recentFailureWindow is how far back the health endpoint counts
+	// failed backups.
+	recentFailureWindow = time.Hour
+
+	// resticVersionTimeout is how long the health endpoint waits for
+	// 'restic version' to complete.
+	resticVersionTimeout = 5 * time.Second
+)
+
+// healthStatus is the health endpoint's response.
+type healthStatus struct {
+	Healthy              bool   `json:"healthy"`
+	CachesSynced         bool   `json:"cachesSynced"`
+	ResticVersion        string `json:"resticVersion,omitempty"`
+	ResticError          string `json:"resticError,omitempty"`
+	RecentBackupFailures int    `json:"recentBackupFailures"`
+	RecentFailureWindow  string `json:"recentFailureWindow"`
+}
+
+// healthHandler reports the health of the restic server as JSON. The
+// server is healthy if its informer caches have synced and the restic
+// binary can be run; recent backup failures are reported but don't make
+// it unhealthy, since they're usually caused by the backups themselves.
+type healthHandler struct {
+	cachesSynced   func() bool
+	resticVersion  func(context.Context) (string, error)
+	failureTracker controller.FailureTracker
+	logger         logrus.FieldLogger
+}
+
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := healthStatus{
+		CachesSynced:         h.cachesSynced(),
+		RecentBackupFailures: h.failureTracker.Count(),
+		RecentFailureWindow:  recentFailureWindow.String(),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), resticVersionTimeout)
+	defer cancel()
+
+	version, err := h.resticVersion(ctx)
+	if err != nil {
+		status.ResticError = err.Error()
+	} else {
+		status.ResticVersion = version
+	}
+
+	status.Healthy = status.CachesSynced && status.ResticError == ""
+
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		h.logger.WithError(err).Error("Error writing health status")
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/heptio/ark/pkg/controller"
+	arktest "github.com/heptio/ark/pkg/util/test"
+)
+
+func TestHealthHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		cachesSynced   bool
+		resticErr      error
+		failures       int
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "healthy",
+			cachesSynced:   true,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"healthy":true,"cachesSynced":true,"resticVersion":"restic 0.9.1 compiled with go1.10.3 on linux/amd64","recentBackupFailures":0,"recentFailureWindow":"1h0m0s"}`,
+		},
+		{
+			name:           "recent failures don't make the server unhealthy",
+			cachesSynced:   true,
+			failures:       2,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"healthy":true,"cachesSynced":true,"resticVersion":"restic 0.9.1 compiled with go1.10.3 on linux/amd64","recentBackupFailures":2,"recentFailureWindow":"1h0m0s"}`,
+		},
+		{
+			name:           "caches not synced",
+			cachesSynced:   false,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   `{"healthy":false,"cachesSynced":false,"resticVersion":"restic 0.9.1 compiled with go1.10.3 on linux/amd64","recentBackupFailures":0,"recentFailureWindow":"1h0m0s"}`,
+		},
+		{
+			name:           "restic can't be run",
+			cachesSynced:   true,
+			resticErr:      errors.New("error running restic version: fork/exec /restic: no such file or directory"),
+			failures:       1,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   `{"healthy":false,"cachesSynced":true,"resticError":"error running restic version: fork/exec /restic: no such file or directory","recentBackupFailures":1,"recentFailureWindow":"1h0m0s"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			failureTracker := controller.NewFailureTracker(time.Hour)
+			for i := 0; i < test.failures; i++ {
+				failureTracker.Add()
+			}
+
+			handler := &healthHandler{
+				cachesSynced: func() bool { return test.cachesSynced },
+				resticVersion: func(context.Context) (string, error) {
+					if test.resticErr != nil {
+						return "", test.resticErr
+					}
+					return "restic 0.9.1 compiled with go1.10.3 on linux/amd64", nil
+				},
+				failureTracker: failureTracker,
+				logger:         arktest.NewLogger(),
+			}
+
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, httptest.NewRequest("GET", "/healthz", nil))
+
+			assert.Equal(t, test.expectedStatus, res.Code)
+			assert.Equal(t, "application/json", res.Header().Get("Content-Type"))
+			assert.JSONEq(t, test.expectedBody, res.Body.String())
+		})
+	}
+}
@@ -31,6 +31,7 @@ func NewCommand(f client.Factory) *cobra.Command {
 
 	c.AddCommand(
 		NewInitRepositoryCommand(f),
+		NewBackupCommand(f),
 		NewServerCommand(f),
 	)
 
@@ -3,15 +3,25 @@ package restic
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"golang.org/x/time/rate"
 
+	corev1api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/sets"
 	kubeinformers "k8s.io/client-go/informers"
 	corev1informers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
@@ -20,15 +30,213 @@ import (
 	"github.com/heptio/ark/pkg/buildinfo"
 	"github.com/heptio/ark/pkg/client"
 	"github.com/heptio/ark/pkg/cmd"
+	"github.com/heptio/ark/pkg/cmd/util/flag"
 	"github.com/heptio/ark/pkg/cmd/util/signals"
 	"github.com/heptio/ark/pkg/controller"
 	clientset "github.com/heptio/ark/pkg/generated/clientset/versioned"
+	"github.com/heptio/ark/pkg/generated/clientset/versioned/scheme"
 	informers "github.com/heptio/ark/pkg/generated/informers/externalversions"
+	"github.com/heptio/ark/pkg/metrics"
+	"github.com/heptio/ark/pkg/restic"
+	"github.com/heptio/ark/pkg/util/filesystem"
+	"github.com/heptio/ark/pkg/util/kube"
 	"github.com/heptio/ark/pkg/util/logging"
 )
 
+const (
+	// defaultHostPodsPath is the path where the restic daemonset mounts the
+	// host's kubelet pods directory.
+	defaultHostPodsPath = "/host_pods"
+	defaultHostRootPath = "/host_root"
+
+	// the port where prometheus metrics are exposed
+	defaultMetricsAddress = ":8085"
+
+	// the port where the health endpoint is exposed
+	defaultHealthAddress = ":8086"
+
+	// defaultResticBinary is the path of the restic binary in the Ark image.
+	defaultResticBinary = "/restic"
+
+	// defaultShutdownGracePeriod leaves time to clean up after killed
+	// backups within Kubernetes' default 30s termination grace period.
+	defaultShutdownGracePeriod = 20 * time.Second
+
+	// defaultVolumeMountTimeout is how long to wait for a volume that isn't
+	// mounted yet before failing its backup.
+	defaultVolumeMountTimeout = time.Minute
+
+	// defaultPressureRetryDelay is how long to defer a backup for when the
+	// node is under resource pressure.
+	defaultPressureRetryDelay = time.Minute
+
+	// defaultQueueDepthRetryDelay is how long to defer a backup for when
+	// too many of the node's backups are queued. It's shorter than the
+	// node pressure delay since the queue drains as backups complete.
+	defaultQueueDepthRetryDelay = 10 * time.Second
+
+	// defaultStaleBackupThreshold is how long a pod volume backup left
+	// InProgress by a previous run of the server must have been started
+	// for before it's restarted.
+	defaultStaleBackupThreshold = time.Minute
+
+	// minRepoStatsInterval is the shortest allowed interval between getting
+	// the stats of restic repositories, each of which reads its index.
+	minRepoStatsInterval = time.Minute
+
+	// minVerificationInterval is the shortest allowed interval between
+	// verifying completed pod volume backups, each of which runs a restic
+	// check.
+	minVerificationInterval = time.Minute
+
+	// minOrphanGCInterval is the shortest allowed interval between
+	// checks for orphaned pod volume backups.
+	minOrphanGCInterval = time.Minute
+
+	// defaultPostBackupHookTimeout is how long the post-backup hook command
+	// may run by default.
+	defaultPostBackupHookTimeout = time.Minute
+
+	// defaultMaintenanceTimeout is how long, by default, a restic
+	// repository may be verified, have snapshots forgotten from it and be
+	// pruned once a backup to it completes.
+	defaultMaintenanceTimeout = time.Hour
+
+	// defaultSnapshotWaitTimeout is how long to wait, by default, for a
+	// snapshot that was just taken to be listed by restic.
+	defaultSnapshotWaitTimeout = 30 * time.Second
+
+	// defaultOrphanGracePeriod is how long after a pod volume backup is
+	// created that it's considered orphaned if its backup doesn't exist.
+	defaultOrphanGracePeriod = time.Hour
+
+	// defaultCircuitOpenDuration is how long, by default, backups to a
+	// repository whose circuit has opened are failed before it's tried
+	// again.
+	defaultCircuitOpenDuration = 5 * time.Minute
+
+	// defaultRotationWindow is how long, by default, after a restic
+	// credentials secret's password changes that backups failing to
+	// authenticate are reported as failing because it was rotated.
+	defaultRotationWindow = time.Hour
+
+	// minRepoLeaseDuration is the shortest allowed duration of restic
+	// repository leases, which are renewed three times per duration.
+	minRepoLeaseDuration = 15 * time.Second
+)
+
+type resticServerConfig struct {
+	maxConcurrentBackups  int
+	backupWorkers         int
+	maxBackupAttempts     int
+	hostPodsPath          string
+	hostRootPath          string
+	hostPathAllowList     []string
+	backupTimeout         time.Duration
+	maintenanceTimeout    time.Duration
+	volumeMountTimeout    time.Duration
+	metricsAddress        string
+	healthAddress         string
+	resticBinary          string
+	resticGlobalFlags     []string
+	resticEnv             []string
+	resticBackendOptions  []string
+	resticTempDir         string
+	resticCacheDir        string
+	resticCacheEnabled    bool
+	resticSharedCache     bool
+	retentionKeepLast     int
+	retentionKeepDaily    int
+	resticLimitUpload     int
+	resticOneFileSystem   bool
+	resticBackupIOClass   string
+	resticCompression     string
+	resticPackSize        int
+	resticReadConcurrency int
+	resticHost            string
+	resticPasswordFile    string
+	resticPasswordCommand string
+	resticUser            string
+	pruneAfterBackups     int
+	pruneInterval         time.Duration
+	skipUnchangedVolumes  bool
+	skipEmptyVolumes      bool
+	repoStatsInterval     time.Duration
+	dryRun                bool
+	shutdownGracePeriod   time.Duration
+	unlockStaleLocks      bool
+	maxVolumeSize         string
+	maxInFlightBytes      string
+	backupLogsMaxSize     string
+	verifyReadDataPercent int
+	verificationPolicy    string
+	verificationInterval  time.Duration
+	maxVerifications      int
+	orphanGCInterval      time.Duration
+	orphanGracePeriod     time.Duration
+	forgetOrphans         bool
+	postBackupHook        string
+	hookTimeout           time.Duration
+	hookFailurePolicy     string
+	incompletePolicy      string
+	snapshotWaitTimeout   time.Duration
+	maxQueueDepth         int
+	queueDepthRetryDelay  time.Duration
+	circuitThreshold      int
+	circuitOpenDuration   time.Duration
+	rotationWindow        time.Duration
+	repoLeaseDuration     time.Duration
+	deletionPolicy        string
+	pressureConditions    []string
+	pressureRetryDelay    time.Duration
+	staleBackupThreshold  time.Duration
+	patchQPS              float32
+	patchBurst            int
+	queueQPS              float32
+	queueBurst            int
+	skipImmutableErrors   bool
+	initRepositories      bool
+	maxConcurrentInits    int
+}
+
 func NewServerCommand(f client.Factory) *cobra.Command {
-	var logLevelFlag = logging.LogLevelFlag(logrus.InfoLevel)
+	var (
+		logLevelFlag           = logging.LogLevelFlag(logrus.InfoLevel)
+		logFormatFlag          = logging.NewFormatFlag()
+		verificationPolicies   = []string{string(controller.VerificationFailurePolicyWarn), string(controller.VerificationFailurePolicyFail)}
+		verificationPolicyFlag = flag.NewEnum(string(controller.VerificationFailurePolicyWarn), verificationPolicies...)
+		deletionPolicies       = []string{string(controller.SnapshotDeletionPolicyRetain), string(controller.SnapshotDeletionPolicyForget)}
+		deletionPolicyFlag     = flag.NewEnum(string(controller.SnapshotDeletionPolicyRetain), deletionPolicies...)
+		hookFailurePolicies    = []string{string(controller.PostBackupHookFailurePolicyWarn), string(controller.PostBackupHookFailurePolicyFail)}
+		hookFailurePolicyFlag  = flag.NewEnum(string(controller.PostBackupHookFailurePolicyWarn), hookFailurePolicies...)
+		incompletePolicies     = []string{string(controller.IncompleteSnapshotPolicyWarn), string(controller.IncompleteSnapshotPolicyFail)}
+		incompletePolicyFlag   = flag.NewEnum(string(controller.IncompleteSnapshotPolicyFail), incompletePolicies...)
+		config                 = resticServerConfig{
+			maxConcurrentBackups: 1,
+			maxBackupAttempts:    1,
+			hostPodsPath:         defaultHostPodsPath,
+			hostRootPath:         defaultHostRootPath,
+			metricsAddress:       defaultMetricsAddress,
+			healthAddress:        defaultHealthAddress,
+			resticBinary:         defaultResticBinary,
+			resticCacheEnabled:   true,
+			shutdownGracePeriod:  defaultShutdownGracePeriod,
+			volumeMountTimeout:   defaultVolumeMountTimeout,
+			maxConcurrentInits:   4,
+			pressureRetryDelay:   defaultPressureRetryDelay,
+			queueDepthRetryDelay: defaultQueueDepthRetryDelay,
+			staleBackupThreshold: defaultStaleBackupThreshold,
+			rotationWindow:       defaultRotationWindow,
+			patchBurst:           10,
+			queueBurst:           10,
+			maxVerifications:     10,
+			orphanGracePeriod:    defaultOrphanGracePeriod,
+			hookTimeout:          defaultPostBackupHookTimeout,
+			maintenanceTimeout:   defaultMaintenanceTimeout,
+			snapshotWaitTimeout:  defaultSnapshotWaitTimeout,
+			circuitOpenDuration:  defaultCircuitOpenDuration,
+		}
+	)
 
 	var command = &cobra.Command{
 		Use:   "server",
@@ -36,12 +244,20 @@ func NewServerCommand(f client.Factory) *cobra.Command {
 		Long:  "Run the ark restic server",
 		Run: func(c *cobra.Command, args []string) {
 			logLevel := logLevelFlag.Parse()
+			logFormat := logFormatFlag.Parse()
+			logging.SetFormat(logrus.StandardLogger(), logFormat)
 			logrus.Infof("Setting log-level to %s", strings.ToUpper(logLevel.String()))
 
 			logger := logging.DefaultLogger(logLevel)
+			logging.SetFormat(logger, logFormat)
 			logger.Infof("Starting Ark restic server %s", buildinfo.FormattedGitSHA())
 
-			s, err := newResticServer(logger, fmt.Sprintf("%s-%s", c.Parent().Name(), c.Name()))
+			config.verificationPolicy = verificationPolicyFlag.String()
+			config.deletionPolicy = deletionPolicyFlag.String()
+			config.hookFailurePolicy = hookFailurePolicyFlag.String()
+			config.incompletePolicy = incompletePolicyFlag.String()
+
+			s, err := newResticServer(logger, fmt.Sprintf("%s-%s", c.Parent().Name(), c.Name()), config)
 			cmd.CheckError(err)
 
 			s.run()
@@ -49,6 +265,78 @@ func NewServerCommand(f client.Factory) *cobra.Command {
 	}
 
 	command.Flags().Var(logLevelFlag, "log-level", fmt.Sprintf("the level at which to log. Valid values are %s.", strings.Join(logLevelFlag.AllowedValues(), ", ")))
+	command.Flags().Var(logFormatFlag, "log-format", fmt.Sprintf("the format in which to log. json writes each log entry, including its fields and any restic command output, as a JSON object, for log aggregation. Valid values are %s.", strings.Join(logFormatFlag.AllowedValues(), ", ")))
+	command.Flags().IntVar(&config.maxConcurrentBackups, "max-concurrent-backups", config.maxConcurrentBackups, "the maximum number of restic backups to run concurrently on this node")
+	command.Flags().IntVar(&config.backupWorkers, "backup-workers", config.backupWorkers, "the number of workers processing pod volume backups on this node. Running restic still requires one of the --max-concurrent-backups slots, so extra workers only resolve volumes and finish backups that don't need restic, such as skipped or failed ones, while other backups are running. A value of 0 uses the value of --max-concurrent-backups.")
+	command.Flags().IntVar(&config.maxBackupAttempts, "max-backup-attempts", config.maxBackupAttempts, "the maximum number of times to attempt a restic backup that fails with a transient error, such as a locked repository or a network failure")
+	command.Flags().StringVar(&config.hostPodsPath, "host-pods-path", config.hostPodsPath, "the path, within the restic pod, where the host's kubelet pods directory (typically /var/lib/kubelet/pods) is mounted")
+	command.Flags().StringVar(&config.hostRootPath, "host-root-path", config.hostRootPath, "the path, within the restic pod, where the host's root filesystem is mounted. Only used to back up hostPath volumes.")
+	command.Flags().StringSliceVar(&config.hostPathAllowList, "host-path-allow-list", config.hostPathAllowList, "host directories that hostPath volumes may be backed up from. A hostPath volume is backed up only if its path is one of these directories or under one of them. If empty, hostPath volumes are not backed up.")
+	command.Flags().DurationVar(&config.backupTimeout, "backup-timeout", config.backupTimeout, "how long to wait for restic to back up a pod's volumes before killing it and marking the backup as failed. A value of 0 means no timeout.")
+	command.Flags().DurationVar(&config.maintenanceTimeout, "maintenance-timeout", config.maintenanceTimeout, "how long restic may spend verifying a repository, forgetting snapshots outside the retention policy from it and pruning it once a backup to it completes, while holding the backup's --max-concurrent-backups slot, before it's killed. Maintenance is also stopped when the server shuts down. A value of 0 means no timeout.")
+	command.Flags().DurationVar(&config.volumeMountTimeout, "volume-mount-timeout", config.volumeMountTimeout, "how long to wait for a pod volume's directory to appear on the node, e.g. while the volume is still being attached to a pod that was just scheduled, before failing its backup. A value of 0 means don't wait.")
+	command.Flags().DurationVar(&config.snapshotWaitTimeout, "snapshot-wait-timeout", config.snapshotWaitTimeout, "how long to wait for a volume's snapshot to be listed by restic once it's been taken, for object stores that list new objects eventually rather than immediately, before failing its backup. A value of 0 means don't wait.")
+	command.Flags().StringVar(&config.metricsAddress, "metrics-address", config.metricsAddress, "the address to expose prometheus metrics")
+	command.Flags().StringVar(&config.healthAddress, "health-address", config.healthAddress, "the address to expose the health endpoint, /healthz, which reports whether the server's caches have synced, whether restic can be run, and the number of recent backup failures, and the debug endpoint, /debug/backups, which reports the backups being run, their start times, and their restic commands with credentials redacted")
+	command.Flags().StringVar(&config.resticBinary, "restic-binary", config.resticBinary, "the path to the restic binary to run")
+	command.Flags().StringArrayVar(&config.resticGlobalFlags, "restic-global-flags", config.resticGlobalFlags, "an additional global flag to pass to every restic command, e.g. --restic-global-flags=--cache-dir=/scratch/restic. May be specified multiple times.")
+	command.Flags().StringVar(&config.resticTempDir, "restic-temp-dir", config.resticTempDir, "the directory that restic writes temporary files to, via TMPDIR, and that restic credentials files are created in. Set it to a volume with enough space, e.g. an emptyDir, on nodes whose root filesystem is small. If empty, the default temp directory is used.")
+	command.Flags().StringArrayVar(&config.resticBackendOptions, "restic-backend-option", config.resticBackendOptions, "an extended option, of the form <backend>.<name>=<value>, to run restic with when the repository's backend, the scheme of its prefix, matches, e.g. --restic-backend-option=gs.connections=4. No options are set by default, so restic's own defaults are used. May be specified multiple times.")
+	command.Flags().StringArrayVar(&config.resticEnv, "restic-env", config.resticEnv, "an additional environment variable, of the form KEY=VALUE, to run every restic command with, e.g. --restic-env=HTTPS_PROXY=http://proxy:3128 or --restic-env=SSL_CERT_FILE=/certs/ca.pem. The variables are added to the server's own environment, which holds the object store credentials. May be specified multiple times.")
+	command.Flags().StringVar(&config.resticCacheDir, "restic-cache-dir", config.resticCacheDir, "directory, such as a mounted persistent volume, in which restic caches repository metadata for backups. Each repository is cached in its own subdirectory. If empty, restic's default cache location is used.")
+	command.Flags().BoolVar(&config.resticCacheEnabled, "restic-cache", config.resticCacheEnabled, "whether restic caches repository metadata for backups. If false, restic is run with --no-cache.")
+	command.Flags().IntVar(&config.retentionKeepLast, "retention-keep-last", config.retentionKeepLast, "once a pod volume backup completes, forget all but this many of the most recent snapshots of each of its volumes' paths, with the same policy tag, as restic forget --keep-last does. Snapshots still referenced by Ark backups may be forgotten. Forgotten snapshots' data is freed when the repository is next pruned. A value of 0, with --retention-keep-daily also 0, disables it.")
+	command.Flags().IntVar(&config.retentionKeepDaily, "retention-keep-daily", config.retentionKeepDaily, "once a pod volume backup completes, also keep the last snapshot of each of this many days of each of its volumes' paths when forgetting snapshots, as restic forget --keep-daily does. A value of 0, with --retention-keep-last also 0, disables it.")
+	command.Flags().BoolVar(&config.resticSharedCache, "restic-shared-cache", config.resticSharedCache, "cache each repository in a subdirectory of --restic-cache-dir named after a hash of the repository's URL, without any credentials in it, rather than after its namespace, so that every backup to a repository shares one cache and no two repositories share a directory. Requires --restic-cache-dir, which must not contain --restic-temp-dir, where credentials files are created.")
+	command.Flags().IntVar(&config.resticLimitUpload, "restic-limit-upload", config.resticLimitUpload, "the maximum rate, in KiB/s, at which each restic backup uploads data. A value of 0 means no limit.")
+	command.Flags().BoolVar(&config.resticOneFileSystem, "restic-one-file-system", config.resticOneFileSystem, "whether restic backups stay within each volume's own filesystem, with --one-file-system, rather than also backing up filesystems mounted inside the volume")
+	command.Flags().StringVar(&config.resticBackupIOClass, "restic-backup-io-class", config.resticBackupIOClass, "the I/O scheduling class, set via ionice, to run restic backups under so that reading volumes doesn't saturate the node's disks. Valid values are best-effort and idle. If empty, restic runs at the default I/O priority.")
+	command.Flags().StringVar(&config.resticCompression, "restic-compression", config.resticCompression, fmt.Sprintf("the compression level restic uses for backups, trading CPU for storage, and for repositories initialized by --init-repositories. Valid values are %s. Requires restic 0.14.0 or later; with older versions, compression is disabled with a warning. If empty, restic's default is used.", strings.Join(restic.CompressionLevels, ", ")))
+	command.Flags().IntVar(&config.resticPackSize, "restic-pack-size", config.resticPackSize, fmt.Sprintf("the target size, in MiB, of the pack files restic writes to repositories. Larger packs mean fewer, larger requests to object storage, which can speed up backups of very large volumes to high-latency storage, at the cost of more memory and more data rewritten by prune. Must be between %d and %d. Requires restic 0.14.0 or later; with older versions, it's ignored with a warning. A value of 0 uses restic's default.", restic.MinPackSize, restic.MaxPackSize))
+	command.Flags().StringVar(&config.resticHost, "restic-host", config.resticHost, "the host to record in restic snapshots, which restic uses to group them and to find a volume's previous snapshot. Setting it to a stable value, such as the cluster's or node's name, keeps snapshots grouped across restarts of the restic daemonset's pods. If empty, restic uses the pod's hostname.")
+	command.Flags().IntVar(&config.resticReadConcurrency, "restic-read-concurrency", config.resticReadConcurrency, "the number of files each restic backup reads concurrently. Raising it can speed up backups of volumes on fast storage, such as NVMe, at the cost of more load on the node. Requires restic 0.15.0 or later; with older versions, it's ignored with a warning. A value of 0 uses restic's default.")
+	command.Flags().StringVar(&config.resticPasswordFile, "restic-password-file", config.resticPasswordFile, "path to a file, such as one mounted from an external secret store, containing the password of every restic repository. If set, namespaces' restic credentials secrets aren't used.")
+	command.Flags().StringVar(&config.resticUser, "restic-user", config.resticUser, "the numeric user and group, as UID:GID or UID, to run restic backups as rather than the restic server's own user. Volume directories, the restic cache directory and the temp directory must be accessible to them. If empty, restic runs as the restic server's user.")
+	command.Flags().StringVar(&config.resticPasswordCommand, "restic-password-command", config.resticPasswordCommand, "a command, run by restic, that prints the password of every restic repository. If set, namespaces' restic credentials secrets aren't used. Only one of --restic-password-file and --restic-password-command may be set.")
+	command.Flags().IntVar(&config.pruneAfterBackups, "prune-after-backups", config.pruneAfterBackups, "prune a namespace's restic repository, to free the space used by forgotten snapshots, after this many backups to it by this server. A value of 0 disables it.")
+	command.Flags().DurationVar(&config.pruneInterval, "prune-interval", config.pruneInterval, "prune a namespace's restic repository on the first backup to it by this server after this much time has passed since it was last pruned. A value of 0 disables it.")
+	command.Flags().BoolVar(&config.skipUnchangedVolumes, "skip-unchanged-volumes", config.skipUnchangedVolumes, "skip the restic backup of a volume whose number of files, total size and latest modification time are the same as when a previous snapshot of it was taken, reusing that snapshot. This avoids restic re-scanning volumes that rarely change, but is a heuristic: changes that preserve file sizes and modification times aren't detected.")
+	command.Flags().BoolVar(&config.skipEmptyVolumes, "skip-empty-volumes", config.skipEmptyVolumes, "skip the restic backup of a volume that contains no files, only, at most, empty directories, rather than adding an empty snapshot to the repository. The pod volume backup is completed without a snapshot ID and with a note that the volume was skipped, and the volume is restored empty.")
+	command.Flags().DurationVar(&config.repoStatsInterval, "repository-stats-interval", config.repoStatsInterval, fmt.Sprintf("how often to get the size and number of snapshots of the restic repositories of the namespaces that this node has backed up, and expose them as metrics. Repositories are checked one at a time, when a backup slot is free. Must be at least %s; a value of 0 disables it.", minRepoStatsInterval))
+	command.Flags().BoolVar(&config.dryRun, "dry-run", config.dryRun, "resolve pod volume paths and log the restic backup commands that would be run, without running them")
+	command.Flags().BoolVar(&config.unlockStaleLocks, "unlock-stale-locks", config.unlockStaleLocks, "remove stale locks, left by restic processes that are no longer running, from a repository before backing up to it. Locks held by running restic processes are never removed.")
+	command.Flags().StringVar(&config.maxVolumeSize, "max-volume-size", config.maxVolumeSize, "the maximum size, as a quantity such as 500Gi, of a volume's contents that will be backed up. Backups of larger volumes fail without running restic. If empty, volumes of any size are backed up.")
+	command.Flags().IntVar(&config.verifyReadDataPercent, "verify-read-data-percent", config.verifyReadDataPercent, "the percentage of a restic repository's data to read and verify, using restic check, after each backup to it, and when verifying completed backups every --backup-verification-interval. A value of 0 disables verification after each backup; completed backups are then verified without reading any data.")
+	command.Flags().Var(verificationPolicyFlag, "verification-failure-policy", fmt.Sprintf("what to do with a backup whose repository fails verification. warn completes the backup and records the failure in its status; fail fails the backup. Valid values are %s.", strings.Join(verificationPolicies, ", ")))
+	command.Flags().DurationVar(&config.verificationInterval, "backup-verification-interval", config.verificationInterval, fmt.Sprintf("how often to verify that the snapshots of the pod volume backups that this node has completed are still restorable, by checking that they're still in their restic repository and running restic check on it. Each backup is verified at most once per interval, and the result is recorded in its status. Must be at least %s; a value of 0 disables it.", minVerificationInterval))
+	command.Flags().IntVar(&config.maxVerifications, "max-backup-verifications", config.maxVerifications, "the maximum number of completed pod volume backups to verify every --backup-verification-interval. Backups are verified one at a time, when a backup slot is free, least recently verified first.")
+	command.Flags().DurationVar(&config.orphanGCInterval, "orphaned-backup-gc-interval", config.orphanGCInterval, fmt.Sprintf("how often to delete the pod volume backups run by this node whose backup, as given by their owner reference or backup name label, no longer exists. Must be at least %s; a value of 0 disables it.", minOrphanGCInterval))
+	command.Flags().DurationVar(&config.orphanGracePeriod, "orphaned-backup-grace-period", config.orphanGracePeriod, "how long after a pod volume backup is created before it's deleted by --orphaned-backup-gc-interval if its backup doesn't exist")
+	command.Flags().BoolVar(&config.forgetOrphans, "forget-orphaned-backup-snapshots", config.forgetOrphans, "forget the restic snapshots of orphaned pod volume backups before deleting them, except snapshots shared with other pod volume backups. Snapshots of pod volume backups with the --snapshot-deletion-policy=forget finalizer are forgotten when they're deleted regardless. Forgotten snapshots' data is freed when the repository is next pruned.")
+	command.Flags().StringVar(&config.postBackupHook, "post-backup-hook", config.postBackupHook, "a command, run by /bin/sh in the restic server's container, after each volume's snapshot is taken, e.g. to notify an external system. The snapshot's ID is passed as its first argument, and the snapshot's details in the ARK_SNAPSHOT_ID, ARK_REPO_PREFIX, ARK_REPO, ARK_BACKUP, ARK_POD_VOLUME_BACKUP, ARK_POD_NAMESPACE, ARK_POD_NAME, ARK_POD_UID, ARK_VOLUME, ARK_VOLUME_PATH and ARK_NODE_NAME environment variables. If empty, no hook is run.")
+	command.Flags().DurationVar(&config.hookTimeout, "post-backup-hook-timeout", config.hookTimeout, "how long the --post-backup-hook command may run before it's killed and considered to have failed. A value of 0 means no timeout.")
+	command.Flags().Var(hookFailurePolicyFlag, "post-backup-hook-failure-policy", fmt.Sprintf("what to do with a backup whose --post-backup-hook command fails. warn completes the backup, logging the failure and recording it in an event; fail fails the backup. Valid values are %s.", strings.Join(hookFailurePolicies, ", ")))
+	command.Flags().Var(incompletePolicyFlag, "incomplete-snapshot-policy", fmt.Sprintf("what to do with a backup whose restic snapshot is incomplete because restic couldn't read some of the volume's files, i.e. restic exits with code 3. warn completes the backup, marking it incomplete in its status and recording an event; fail fails the backup. Valid values are %s.", strings.Join(incompletePolicies, ", ")))
+	command.Flags().DurationVar(&config.repoLeaseDuration, "repository-lease-duration", config.repoLeaseDuration, fmt.Sprintf("coordinate access to restic repositories with the restic servers on other nodes using leases, recorded in ConfigMaps in the Ark namespace, that expire if they're not renewed within this duration. Backups share a repository's lease, while prunes and verifications need it exclusively, so they're skipped while other nodes are backing up to the repository, and backups wait for them to finish. Must be at least %s; a value of 0 disables it.", minRepoLeaseDuration))
+	command.Flags().Var(deletionPolicyFlag, "snapshot-deletion-policy", fmt.Sprintf("what to do with the restic snapshots of a pod volume backup when it's deleted. retain leaves them in the repository; forget adds a finalizer to pod volume backups that this node runs, which forgets their snapshots before they're removed. Forgotten snapshots' data is freed when the repository is next pruned. Valid values are %s.", strings.Join(deletionPolicies, ", ")))
+	command.Flags().StringSliceVar(&config.pressureConditions, "defer-backups-on-node-conditions", config.pressureConditions, fmt.Sprintf("node conditions, such as MemoryPressure, under which this node's restic backups are deferred rather than started, so restic doesn't add to the load of a node that's already under pressure. Valid values are %s. If empty, backups are never deferred.", strings.Join(pressureConditionTypes(), ", ")))
+	command.Flags().DurationVar(&config.pressureRetryDelay, "node-pressure-retry-delay", config.pressureRetryDelay, "how long to defer a backup for when the node reports one of the --defer-backups-on-node-conditions conditions, before checking them again")
+	command.Flags().DurationVar(&config.staleBackupThreshold, "stale-backup-threshold", config.staleBackupThreshold, fmt.Sprintf("how long a pod volume backup left InProgress by a previous run of this server, e.g. because it crashed, must have been started for before it's reset to New and retried. Backups that are interrupted %d times are failed. A value of 0 disables it, leaving such backups InProgress.", controller.MaxStaleBackupRestarts))
+	command.Flags().Float32Var(&config.patchQPS, "patch-qps", config.patchQPS, "the maximum number of pod volume backup status updates per second that this server sends to the API server, to protect it when large backups create many pod volume backups at once. A value of 0 disables the limit.")
+	command.Flags().IntVar(&config.patchBurst, "patch-burst", config.patchBurst, "the number of pod volume backup status updates that can be sent at once, above --patch-qps, before it's enforced")
+	command.Flags().Float32Var(&config.queueQPS, "queue-qps", config.queueQPS, "the maximum number of this node's pod volume backups that this server starts per second. A value of 0 disables the limit.")
+	command.Flags().IntVar(&config.circuitThreshold, "circuit-breaker-threshold", config.circuitThreshold, "the number of consecutive backups to a restic repository that may fail because the repository is broken, e.g. not initialized, unreachable, or its password is wrong, before further backups to it are failed without running restic. A value of 0 disables it.")
+	command.Flags().DurationVar(&config.circuitOpenDuration, "circuit-open-duration", config.circuitOpenDuration, "how long backups to a restic repository are failed without running restic, once --circuit-breaker-threshold is reached, before a single backup is run to try the repository again")
+	command.Flags().StringVar(&config.maxInFlightBytes, "max-in-flight-bytes", config.maxInFlightBytes, "the total size, as a quantity such as 100Gi, of the volumes being backed up on this node at which new backups are deferred, for --node-pressure-retry-delay, rather than started. Volume sizes are measured before they're backed up, and reported by the ark_pod_volume_backup_in_flight_bytes metric. If empty, there's no limit.")
+	command.Flags().DurationVar(&config.rotationWindow, "password-rotation-window", config.rotationWindow, "how long after the repository password in a restic credentials secret changes that backups whose password the repository rejects fail with the PasswordRotated reason, which explains that the repository's keys must be updated with restic key, rather than AuthFailed. A value of 0 disables it.")
+	command.Flags().StringVar(&config.backupLogsMaxSize, "backup-logs-max-size", config.backupLogsMaxSize, "keep the output of each pod volume backup's restic backups in a ConfigMap named <pod volume backup>-restic-logs, referenced by its status.logsConfigMap, keeping at most this much, as a quantity such as 64Ki, of each volume's stdout and stderr. Longer output is truncated from the start. If empty, restic's output isn't kept.")
+	command.Flags().DurationVar(&config.queueDepthRetryDelay, "queue-depth-retry-delay", config.queueDepthRetryDelay, "how long to defer a backup for when --max-queue-depth of this node's pod volume backups are waiting to be processed, before queueing it again")
+	command.Flags().IntVar(&config.maxQueueDepth, "max-queue-depth", config.maxQueueDepth, "the number of this node's pod volume backups that may be waiting to be processed before the lowest priority new backups are deferred, for --queue-depth-retry-delay, rather than queued. The queue depth is reported by the ark_pod_volume_backup_queue_depth metric. A value of 0 disables the limit.")
+	command.Flags().IntVar(&config.queueBurst, "queue-burst", config.queueBurst, "the number of this node's pod volume backups that can be started at once, above --queue-qps, before it's enforced")
+	command.Flags().BoolVar(&config.skipImmutableErrors, "skip-immutable-storage-errors", config.skipImmutableErrors, "skip forgetting snapshots and pruning restic repositories whose storage is immutable, e.g. S3 buckets with Object Lock, rather than failing. Pod volume backups whose snapshots can't be forgotten are then deleted with their snapshots left in the repository.")
+	command.Flags().BoolVar(&config.initRepositories, "init-repositories", config.initRepositories, "when the server starts, initialize the restic repositories of the namespaces of pods on this node that have restic credentials, so that their first backups don't have to wait for it")
+	command.Flags().IntVar(&config.maxConcurrentInits, "max-concurrent-repository-inits", config.maxConcurrentInits, "the maximum number of restic repositories to initialize concurrently when --init-repositories is set")
+	command.Flags().DurationVar(&config.shutdownGracePeriod, "shutdown-grace-period", config.shutdownGracePeriod, "how long to wait for in-progress restic backups to finish when the server is shut down before killing them. This should be less than the daemonset's terminationGracePeriodSeconds.")
 
 	return command
 }
@@ -60,11 +348,184 @@ type resticServer struct {
 	kubeInformerFactory kubeinformers.SharedInformerFactory
 	podInformer         cache.SharedIndexInformer
 	logger              logrus.FieldLogger
+	config              resticServerConfig
+	maxVolumeSize       int64
+	maxInFlightBytes    int64
+	backupLogsMaxSize   int64
+	backendOptions      restic.BackendOptions
+	resticRunAs         *restic.RunAs
+	pressureConditions  []corev1api.NodeConditionType
+	patchLimiter        *rate.Limiter
+	queueLimiter        *rate.Limiter
+	resticFeatures      resticFeatures
+	featureGates        restic.FeatureGates
+	metrics             *metrics.ServerMetrics
 	ctx                 context.Context
 	cancelFunc          context.CancelFunc
 }
 
-func newResticServer(logger logrus.FieldLogger, baseName string) (*resticServer, error) {
+func newResticServer(logger logrus.FieldLogger, baseName string, config resticServerConfig) (*resticServer, error) {
+	if config.maxConcurrentBackups < 1 {
+		return nil, errors.Errorf("max-concurrent-backups must be at least 1, got %d", config.maxConcurrentBackups)
+	}
+	if config.backupWorkers < 0 {
+		return nil, errors.Errorf("backup-workers must not be negative, got %d", config.backupWorkers)
+	}
+	if config.backupWorkers == 0 {
+		config.backupWorkers = config.maxConcurrentBackups
+	}
+	if config.backupWorkers < config.maxConcurrentBackups {
+		logger.Warnf("backup-workers (%d) is less than max-concurrent-backups (%d), so at most %d restic backups will run concurrently", config.backupWorkers, config.maxConcurrentBackups, config.backupWorkers)
+	}
+	if config.maxBackupAttempts < 1 {
+		return nil, errors.Errorf("max-backup-attempts must be at least 1, got %d", config.maxBackupAttempts)
+	}
+	if config.maxConcurrentInits < 1 {
+		return nil, errors.Errorf("max-concurrent-repository-inits must be at least 1, got %d", config.maxConcurrentInits)
+	}
+	if config.backupTimeout < 0 {
+		return nil, errors.Errorf("backup-timeout must not be negative, got %s", config.backupTimeout)
+	}
+	if config.maintenanceTimeout < 0 {
+		return nil, errors.Errorf("maintenance-timeout must not be negative, got %s", config.maintenanceTimeout)
+	}
+	if config.pruneAfterBackups < 0 {
+		return nil, errors.Errorf("prune-after-backups must not be negative, got %d", config.pruneAfterBackups)
+	}
+	if config.pruneInterval < 0 {
+		return nil, errors.Errorf("prune-interval must not be negative, got %s", config.pruneInterval)
+	}
+	if config.repoStatsInterval < 0 || (config.repoStatsInterval > 0 && config.repoStatsInterval < minRepoStatsInterval) {
+		return nil, errors.Errorf("repository-stats-interval must be 0 or at least %s, got %s", minRepoStatsInterval, config.repoStatsInterval)
+	}
+	if config.volumeMountTimeout < 0 {
+		return nil, errors.Errorf("volume-mount-timeout must not be negative, got %s", config.volumeMountTimeout)
+	}
+	if config.shutdownGracePeriod < 0 {
+		return nil, errors.Errorf("shutdown-grace-period must not be negative, got %s", config.shutdownGracePeriod)
+	}
+	if err := validateHostPodsPath(config.hostPodsPath, filesystem.NewFileSystem()); err != nil {
+		return nil, err
+	}
+	if err := validateHostPathAllowList(config.hostPathAllowList, config.hostRootPath, filesystem.NewFileSystem()); err != nil {
+		return nil, err
+	}
+	if err := validateResticBinary(config.resticBinary); err != nil {
+		return nil, err
+	}
+	if err := restic.ValidateEnv(config.resticEnv); err != nil {
+		return nil, errors.Wrap(err, "invalid restic-env")
+	}
+	if err := restic.ValidateTempDir(config.resticTempDir); err != nil {
+		return nil, errors.Wrap(err, "invalid restic-temp-dir")
+	}
+	config.resticEnv = resticEnvWithTempDir(config.resticEnv, config.resticTempDir)
+	if err := validateSharedCache(config.resticSharedCache, config.resticCacheEnabled, config.resticCacheDir, config.resticTempDir); err != nil {
+		return nil, err
+	}
+	if err := validateBackupThrottling(config.resticLimitUpload, config.resticBackupIOClass); err != nil {
+		return nil, err
+	}
+	if err := restic.ValidateKeepPolicy(restic.KeepPolicy{Last: config.retentionKeepLast, Daily: config.retentionKeepDaily}); err != nil {
+		return nil, errors.Wrap(err, "invalid retention policy")
+	}
+	backendOptions, err := restic.NewBackendOptions(config.resticBackendOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid restic-backend-option")
+	}
+	if err := restic.ValidateCompression(config.resticCompression); err != nil {
+		return nil, errors.Wrap(err, "invalid restic-compression")
+	}
+	if err := restic.ValidatePackSize(config.resticPackSize); err != nil {
+		return nil, errors.Wrap(err, "invalid restic-pack-size")
+	}
+	if err := restic.ValidateReadConcurrency(config.resticReadConcurrency); err != nil {
+		return nil, errors.Wrap(err, "invalid restic-read-concurrency")
+	}
+	if err := validatePasswordSource(config.resticPasswordFile, config.resticPasswordCommand, filesystem.NewFileSystem()); err != nil {
+		return nil, err
+	}
+	if config.verifyReadDataPercent < 0 || config.verifyReadDataPercent > 100 {
+		return nil, errors.Errorf("verify-read-data-percent must be between 0 and 100, got %d", config.verifyReadDataPercent)
+	}
+	if config.verificationInterval < 0 || (config.verificationInterval > 0 && config.verificationInterval < minVerificationInterval) {
+		return nil, errors.Errorf("backup-verification-interval must be 0 or at least %s, got %s", minVerificationInterval, config.verificationInterval)
+	}
+	if config.maxVerifications < 1 {
+		return nil, errors.Errorf("max-backup-verifications must be at least 1, got %d", config.maxVerifications)
+	}
+	if config.orphanGCInterval < 0 || (config.orphanGCInterval > 0 && config.orphanGCInterval < minOrphanGCInterval) {
+		return nil, errors.Errorf("orphaned-backup-gc-interval must be 0 or at least %s, got %s", minOrphanGCInterval, config.orphanGCInterval)
+	}
+	if config.hookTimeout < 0 {
+		return nil, errors.Errorf("post-backup-hook-timeout must not be negative, got %s", config.hookTimeout)
+	}
+	if config.maxQueueDepth < 0 {
+		return nil, errors.Errorf("max-queue-depth must not be negative, got %d", config.maxQueueDepth)
+	}
+	if config.queueDepthRetryDelay <= 0 {
+		return nil, errors.Errorf("queue-depth-retry-delay must be positive, got %s", config.queueDepthRetryDelay)
+	}
+	if config.circuitThreshold < 0 {
+		return nil, errors.Errorf("circuit-breaker-threshold must not be negative, got %d", config.circuitThreshold)
+	}
+	if config.circuitThreshold > 0 && config.circuitOpenDuration <= 0 {
+		return nil, errors.Errorf("circuit-open-duration must be positive, got %s", config.circuitOpenDuration)
+	}
+	if config.snapshotWaitTimeout < 0 {
+		return nil, errors.Errorf("snapshot-wait-timeout must not be negative, got %s", config.snapshotWaitTimeout)
+	}
+	if config.orphanGracePeriod < 0 {
+		return nil, errors.Errorf("orphaned-backup-grace-period must not be negative, got %s", config.orphanGracePeriod)
+	}
+	if config.repoLeaseDuration < 0 || (config.repoLeaseDuration > 0 && config.repoLeaseDuration < minRepoLeaseDuration) {
+		return nil, errors.Errorf("repository-lease-duration must be 0 or at least %s, got %s", minRepoLeaseDuration, config.repoLeaseDuration)
+	}
+	maxVolumeSize, err := parseByteLimit("max-volume-size", config.maxVolumeSize)
+	if err != nil {
+		return nil, err
+	}
+	maxInFlightBytes, err := parseByteLimit("max-in-flight-bytes", config.maxInFlightBytes)
+	if err != nil {
+		return nil, err
+	}
+	backupLogsMaxSize, err := parseByteLimit("backup-logs-max-size", config.backupLogsMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	var resticRunAs *restic.RunAs
+	if config.resticUser != "" {
+		if resticRunAs, err = restic.ParseRunAs(config.resticUser); err != nil {
+			return nil, errors.Wrap(err, "invalid restic-user")
+		}
+	}
+	pressureConditions, err := parsePressureConditions(config.pressureConditions)
+	if err != nil {
+		return nil, err
+	}
+	if config.pressureRetryDelay <= 0 {
+		return nil, errors.Errorf("node-pressure-retry-delay must be positive, got %s", config.pressureRetryDelay)
+	}
+	if config.staleBackupThreshold < 0 {
+		return nil, errors.Errorf("stale-backup-threshold must not be negative, got %s", config.staleBackupThreshold)
+	}
+	patchLimiter, err := newRateLimiter("patch", config.patchQPS, config.patchBurst)
+	if err != nil {
+		return nil, err
+	}
+	queueLimiter, err := newRateLimiter("queue", config.queueQPS, config.queueBurst)
+	if err != nil {
+		return nil, err
+	}
+
+	featureGates := detectResticFeatures(func() (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), resticVersionTimeout)
+		defer cancel()
+		return restic.GetVersion(ctx, config.resticBinary)
+	}, logger)
+	features := resticFeatures{compression: config.resticCompression, packSize: config.resticPackSize, readConcurrency: config.resticReadConcurrency}
+	features = resolveResticFeatures(features, featureGates, logger)
+
 	clientConfig, err := client.Config("", "", baseName)
 	if err != nil {
 		return nil, err
@@ -101,31 +562,529 @@ func newResticServer(logger logrus.FieldLogger, baseName string) (*resticServer,
 		kubeInformerFactory: kubeinformers.NewSharedInformerFactory(kubeClient, 0),
 		podInformer:         podInformer,
 		logger:              logger,
+		config:              config,
+		maxVolumeSize:       maxVolumeSize,
+		maxInFlightBytes:    maxInFlightBytes,
+		backupLogsMaxSize:   backupLogsMaxSize,
+		backendOptions:      backendOptions,
+		resticRunAs:         resticRunAs,
+		pressureConditions:  pressureConditions,
+		patchLimiter:        patchLimiter,
+		queueLimiter:        queueLimiter,
+		resticFeatures:      features,
+		featureGates:        featureGates,
+		metrics:             metrics.NewPodVolumeMetrics(),
 		ctx:                 ctx,
 		cancelFunc:          cancelFunc,
 	}, nil
 }
 
+// validateHostPodsPath returns an error if the host pods path is not an
+// existing directory, or is on the restic pod's own root filesystem rather
+// than a mounted volume, either of which usually means the restic daemonset
+// does not have the kubelet pods directory mounted where it's expected.
+func validateHostPodsPath(path string, fileSystem filesystem.Interface) error {
+	if path == "" {
+		return errors.New("host-pods-path must not be empty")
+	}
+
+	exists, err := fileSystem.DirExists(path)
+	if err != nil {
+		return errors.Wrapf(err, "error checking host pods path %s", path)
+	}
+	if !exists {
+		return errors.Errorf("host pods path %s does not exist; ensure the host's kubelet pods directory is mounted there or set --host-pods-path", path)
+	}
+
+	// if the mounts can't be read, e.g. because /proc isn't mounted, assume
+	// the path is mounted, and let backups report any problem with it.
+	mountInfo, err := fileSystem.ReadFile(mountInfoPath)
+	if err != nil {
+		return nil
+	}
+	if mountPoint := containingMountPoint(path, mountInfo); mountPoint == "/" {
+		return errors.Errorf("host pods path %s is not a mounted volume; ensure the restic daemonset mounts the host's kubelet pods directory (typically /var/lib/kubelet/pods) there with a hostPath volume, or set --host-pods-path", path)
+	}
+
+	return nil
+}
+
+// mountInfoPath is the file listing the mounts in the restic pod's mount
+// namespace.
+const mountInfoPath = "/proc/self/mountinfo"
+
+// mountPointUnescaper reverses the octal escaping of whitespace and
+// backslashes in mountinfo's mount points.
+var mountPointUnescaper = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+// containingMountPoint returns the mount point, from the given contents of
+// a mountinfo file, of the filesystem that path is on: the longest mount
+// point that is path or one of its parent directories.
+func containingMountPoint(path string, mountInfo []byte) string {
+	path = filepath.Clean(path)
+
+	var longest string
+	for _, line := range strings.Split(string(mountInfo), "\n") {
+		// the fifth field is the mount point, relative to the process's root.
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		mountPoint := filepath.Clean(mountPointUnescaper.Replace(fields[4]))
+
+		contains := mountPoint == "/" || path == mountPoint || strings.HasPrefix(path, mountPoint+"/")
+		if contains && len(mountPoint) > len(longest) {
+			longest = mountPoint
+		}
+	}
+
+	return longest
+}
+
+// validateHostPathAllowList returns an error if any of the allowed host
+// paths isn't absolute, or if there are any and the host's root filesystem
+// isn't mounted at hostRootPath.
+func validateHostPathAllowList(allowList []string, hostRootPath string, fileSystem filesystem.Interface) error {
+	if len(allowList) == 0 {
+		return nil
+	}
+
+	for _, path := range allowList {
+		if !filepath.IsAbs(path) {
+			return errors.Errorf("host-path-allow-list entries must be absolute paths, got %q", path)
+		}
+	}
+
+	if hostRootPath == "" {
+		return errors.New("host-root-path must not be empty when host-path-allow-list is set")
+	}
+
+	exists, err := fileSystem.DirExists(hostRootPath)
+	if err != nil {
+		return errors.Wrapf(err, "error checking host root path %s", hostRootPath)
+	}
+	if !exists {
+		return errors.Errorf("host root path %s does not exist; ensure the host's root filesystem is mounted there or set --host-root-path", hostRootPath)
+	}
+
+	return nil
+}
+
+// validateResticBinary returns an error if the restic binary does not
+// exist or is not executable.
+func validateResticBinary(path string) error {
+	if _, err := exec.LookPath(path); err != nil {
+		return errors.Wrapf(err, "restic binary %s is not executable; set --restic-binary to the path of the restic binary", path)
+	}
+
+	return nil
+}
+
+// validateBackupThrottling returns an error if the restic backup upload
+// rate limit or I/O class is invalid, or if ionice is needed but can't
+// be found.
+func validateBackupThrottling(limitUpload int, ioClass string) error {
+	if limitUpload < 0 {
+		return errors.Errorf("restic-limit-upload must not be negative, got %d", limitUpload)
+	}
+
+	wrapper, err := restic.IONiceWrapper(ioClass)
+	if err != nil {
+		return errors.Wrap(err, "invalid restic-backup-io-class")
+	}
+	if len(wrapper) > 0 {
+		if _, err := exec.LookPath(wrapper[0]); err != nil {
+			return errors.Wrapf(err, "%s is required to set restic-backup-io-class", wrapper[0])
+		}
+	}
+
+	return nil
+}
+
+// validatePasswordSource returns an error if both an external restic
+// password file and command are configured, or if the password file can't
+// be read.
+func validatePasswordSource(passwordFile, passwordCommand string, fileSystem filesystem.Interface) error {
+	if passwordFile != "" && passwordCommand != "" {
+		return errors.New("only one of restic-password-file and restic-password-command may be set")
+	}
+
+	if passwordFile != "" {
+		if _, err := fileSystem.ReadFile(passwordFile); err != nil {
+			return errors.Wrapf(err, "unable to read restic-password-file %s", passwordFile)
+		}
+	}
+
+	return nil
+}
+
+// validateSharedCache returns an error if the shared restic cache is
+// enabled without a cache directory to hold it, or with the directory that
+// credentials files are created in inside it, where they'd be shared with
+// the caches.
+func validateSharedCache(sharedCache, cacheEnabled bool, cacheDir, tempDir string) error {
+	if !sharedCache {
+		return nil
+	}
+	if !cacheEnabled {
+		return errors.New("restic-shared-cache can't be set when restic-cache is false")
+	}
+	if cacheDir == "" {
+		return errors.New("restic-shared-cache requires restic-cache-dir")
+	}
+
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	rel, err := filepath.Rel(filepath.Clean(cacheDir), filepath.Clean(tempDir))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))) {
+		return errors.Errorf("restic-shared-cache can't be set when restic-cache-dir %s contains the directory that credentials files are created in, %s; set restic-temp-dir to a directory outside it", cacheDir, tempDir)
+	}
+
+	return nil
+}
+
+// resticEnvWithTempDir returns the environment variables to run restic
+// commands with: TMPDIR, if tempDir is set, followed by env, so that a
+// TMPDIR set with restic-env takes precedence.
+func resticEnvWithTempDir(env []string, tempDir string) []string {
+	if tempDir == "" {
+		return env
+	}
+
+	return append([]string{"TMPDIR=" + tempDir}, env...)
+}
+
+// parseByteLimit returns the number of bytes represented by the value of a
+// flag that limits a size, such as max-volume-size, or 0 if it's empty.
+func parseByteLimit(flag, value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid %s", flag)
+	}
+	if quantity.Sign() <= 0 {
+		return 0, errors.Errorf("%s must be positive, got %s", flag, value)
+	}
+
+	return quantity.Value(), nil
+}
+
+// pressureConditionTypes returns the node conditions that backups can be
+// deferred under.
+func pressureConditionTypes() []string {
+	return []string{
+		string(corev1api.NodeMemoryPressure),
+		string(corev1api.NodeDiskPressure),
+		string(corev1api.NodePIDPressure),
+	}
+}
+
+// parsePressureConditions returns the node conditions in the
+// defer-backups-on-node-conditions flag, or an error if any of them isn't a
+// pressure condition.
+func parsePressureConditions(values []string) ([]corev1api.NodeConditionType, error) {
+	valid := sets.NewString(pressureConditionTypes()...)
+
+	var conditions []corev1api.NodeConditionType
+	for _, value := range values {
+		if !valid.Has(value) {
+			return nil, errors.Errorf("invalid defer-backups-on-node-conditions value %q, must be one of %s", value, strings.Join(pressureConditionTypes(), ", "))
+		}
+		conditions = append(conditions, corev1api.NodeConditionType(value))
+	}
+
+	return conditions, nil
+}
+
+// newRateLimiter returns a rate limiter for the <name>-qps and <name>-burst
+// flags. A qps of 0 disables the limit.
+func newRateLimiter(name string, qps float32, burst int) (*rate.Limiter, error) {
+	if qps < 0 {
+		return nil, errors.Errorf("%s-qps must not be negative, got %v", name, qps)
+	}
+	if qps == 0 {
+		return rate.NewLimiter(rate.Inf, 0), nil
+	}
+	if burst < 1 {
+		return nil, errors.Errorf("%s-burst must be at least 1, got %d", name, burst)
+	}
+
+	return rate.NewLimiter(rate.Limit(qps), burst), nil
+}
+
+// resticFeatures are the optional restic features, which not all restic
+// versions support, that the server is configured to use.
+type resticFeatures struct {
+	compression     string
+	packSize        int
+	readConcurrency int
+}
+
+// detectResticFeatures returns the feature gates for the restic version, as
+// returned by getVersion, and logs which optional features are available.
+// If the version can't be determined, it logs a warning and returns nil.
+func detectResticFeatures(getVersion func() (string, error), logger logrus.FieldLogger) restic.FeatureGates {
+	output, err := getVersion()
+	if err != nil {
+		logger.WithError(err).Warn("Error getting restic version, optional restic features can't be checked")
+		return nil
+	}
+
+	version, err := restic.ParseVersion(output)
+	if err != nil {
+		logger.WithError(err).Warn("Error parsing restic version, optional restic features can't be checked")
+		return nil
+	}
+
+	gates := restic.NewFeatureGates(version)
+
+	var available, unavailable []string
+	for _, feature := range restic.Features() {
+		if gates.Enabled(feature) {
+			available = append(available, string(feature))
+		} else {
+			unavailable = append(unavailable, fmt.Sprintf("%s (requires %s)", feature, feature.MinVersion()))
+		}
+	}
+	logger.WithFields(logrus.Fields{
+		"version":     version.String(),
+		"available":   strings.Join(available, ", "),
+		"unavailable": strings.Join(unavailable, ", "),
+	}).Info("Detected restic version")
+
+	return gates
+}
+
+// resolveResticFeatures returns the configured features that the restic
+// version, as recorded by gates, supports. Each unsupported feature is
+// disabled with a warning rather than failing every restic command. If the
+// version is unknown, i.e. gates is nil, all of them are disabled.
+func resolveResticFeatures(features resticFeatures, gates restic.FeatureGates, logger logrus.FieldLogger) resticFeatures {
+	if features == (resticFeatures{}) {
+		return features
+	}
+
+	if gates == nil {
+		logger.Warn("restic version is unknown, disabling restic compression, pack size and read concurrency")
+		return resticFeatures{}
+	}
+
+	if features.compression != "" && !supportsFeature(gates, restic.FeatureCompression, logger) {
+		features.compression = ""
+	}
+	if features.packSize != 0 && !supportsFeature(gates, restic.FeaturePackSize, logger) {
+		features.packSize = 0
+	}
+	if features.readConcurrency != 0 && !supportsFeature(gates, restic.FeatureReadConcurrency, logger) {
+		features.readConcurrency = 0
+	}
+
+	return features
+}
+
+// supportsFeature returns true if gates enable a feature, and logs a warning
+// otherwise.
+func supportsFeature(gates restic.FeatureGates, feature restic.Feature, logger logrus.FieldLogger) bool {
+	if !gates.Enabled(feature) {
+		logger.Warnf("restic version does not support %s, which requires restic %s or later, disabling it", feature, feature.MinVersion())
+		return false
+	}
+
+	return true
+}
+
+// repoInitPrefix returns the prefix of the restic repositories to initialize
+// at startup, from the Ark config, or an empty string if they shouldn't be.
+func (s *resticServer) repoInitPrefix() string {
+	if !s.config.initRepositories {
+		return ""
+	}
+
+	config, err := s.arkClient.ArkV1().Configs(os.Getenv("HEPTIO_ARK_NAMESPACE")).Get("default", metav1.GetOptions{})
+	if err != nil {
+		s.logger.WithError(err).Warn("Error getting Ark config, not initializing restic repositories")
+		return ""
+	}
+	if config.BackupStorageProvider.ResticLocation == "" {
+		s.logger.Warn("Ark config has no restic location, not initializing restic repositories")
+		return ""
+	}
+
+	return restic.RepoPrefix(config.BackupStorageProvider)
+}
+
+// serveHealth serves the health endpoint, and the debug endpoint that
+// reports the backups being run, if runningBackups is not nil. It must be
+// called after the controllers have been created, so that all of their
+// informers exist.
+func (s *resticServer) serveHealth(failureTracker controller.FailureTracker, runningBackups controller.RunningBackupsLister) {
+	cacheSyncWaiters := []cache.InformerSynced{
+		s.podInformer.HasSynced,
+		s.arkInformerFactory.Ark().V1().PodVolumeBackups().Informer().HasSynced,
+		s.arkInformerFactory.Ark().V1().PodVolumeRestores().Informer().HasSynced,
+		s.arkInformerFactory.Ark().V1().Backups().Informer().HasSynced,
+		s.kubeInformerFactory.Core().V1().Secrets().Informer().HasSynced,
+		s.kubeInformerFactory.Core().V1().PersistentVolumeClaims().Informer().HasSynced,
+		s.kubeInformerFactory.Core().V1().PersistentVolumes().Informer().HasSynced,
+		s.kubeInformerFactory.Core().V1().Nodes().Informer().HasSynced,
+	}
+
+	healthMux := http.NewServeMux()
+	healthMux.Handle("/healthz", &healthHandler{
+		cachesSynced: func() bool {
+			for _, synced := range cacheSyncWaiters {
+				if !synced() {
+					return false
+				}
+			}
+			return true
+		},
+		resticVersion: func(ctx context.Context) (string, error) {
+			return restic.GetVersion(ctx, s.config.resticBinary)
+		},
+		failureTracker: failureTracker,
+		logger:         s.logger,
+	})
+	if runningBackups != nil {
+		healthMux.Handle("/debug/backups", &runningBackupsHandler{
+			runningBackups: runningBackups,
+			clock:          clock.RealClock{},
+			logger:         s.logger,
+		})
+	}
+
+	s.logger.Infof("Starting health server at address [%s]", s.config.healthAddress)
+	if err := http.ListenAndServe(s.config.healthAddress, healthMux); err != nil {
+		s.logger.Fatalf("Failed to start health server at [%s]: %v", s.config.healthAddress, err)
+	}
+}
+
 func (s *resticServer) run() {
 	signals.CancelOnShutdown(s.cancelFunc, s.logger)
 
+	s.metrics.RegisterAllMetrics()
+	go func() {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", promhttp.Handler())
+		s.logger.Infof("Starting metric server at address [%s]", s.config.metricsAddress)
+		if err := http.ListenAndServe(s.config.metricsAddress, metricsMux); err != nil {
+			s.logger.Fatalf("Failed to start metric server at [%s]: %v", s.config.metricsAddress, err)
+		}
+	}()
+
 	s.logger.Info("Starting controllers")
 
 	var wg sync.WaitGroup
 
-	backupController := controller.NewPodVolumeBackupController(
-		s.logger,
-		s.arkInformerFactory.Ark().V1().PodVolumeBackups(),
-		s.arkClient.ArkV1(),
-		s.podInformer,
-		s.kubeInformerFactory.Core().V1().Secrets(),
-		s.kubeInformerFactory.Core().V1().PersistentVolumeClaims(),
-		os.Getenv("NODE_NAME"),
-	)
+	failureTracker := controller.NewFailureTracker(recentFailureWindow)
+
+	var pruneTrigger controller.PruneTrigger
+	if s.config.pruneAfterBackups > 0 || s.config.pruneInterval > 0 {
+		pruneTrigger = controller.NewPruneTrigger(s.config.pruneAfterBackups, s.config.pruneInterval)
+	}
+
+	var circuitBreaker controller.CircuitBreaker
+	if s.config.circuitThreshold > 0 {
+		circuitBreaker = controller.NewCircuitBreaker(s.config.circuitThreshold, s.config.circuitOpenDuration)
+	}
+
+	var repoLeaser *restic.RepoLeaser
+	if s.config.repoLeaseDuration > 0 {
+		repoLeaser = restic.NewRepoLeaser(s.kubeClient.CoreV1().ConfigMaps(os.Getenv("HEPTIO_ARK_NAMESPACE")), os.Getenv("NODE_NAME"), s.config.repoLeaseDuration, s.logger)
+	}
+
+	backupController := controller.NewPodVolumeBackupController(controller.PodVolumeBackupControllerConfig{
+		Logger:                  s.logger,
+		PodVolumeBackupInformer: s.arkInformerFactory.Ark().V1().PodVolumeBackups(),
+		PodVolumeBackupClient:   s.arkClient.ArkV1(),
+		BackupInformer:          s.arkInformerFactory.Ark().V1().Backups(),
+		PodInformer:             s.podInformer,
+		SecretInformer:          s.kubeInformerFactory.Core().V1().Secrets(),
+		PVCInformer:             s.kubeInformerFactory.Core().V1().PersistentVolumeClaims(),
+		PVInformer:              s.kubeInformerFactory.Core().V1().PersistentVolumes(),
+		NodeInformer:            s.kubeInformerFactory.Core().V1().Nodes(),
+		ConfigMaps:              s.kubeClient.CoreV1(),
+		Metrics:                 s.metrics,
+		EventRecorder:           kube.NewEventRecorder(s.kubeClient.CoreV1(), scheme.Scheme, "ark-restic", os.Getenv("NODE_NAME"), s.logger),
+		FailureTracker:          failureTracker,
+		CircuitBreaker:          circuitBreaker,
+		RepoLeaser:              repoLeaser,
+		PruneTrigger:            pruneTrigger,
+		PatchLimiter:            s.patchLimiter,
+		QueueLimiter:            s.queueLimiter,
+
+		NodeName:              os.Getenv("NODE_NAME"),
+		MaxConcurrentBackups:  s.config.maxConcurrentBackups,
+		MaxBackupAttempts:     s.config.maxBackupAttempts,
+		HostPodsPath:          s.config.hostPodsPath,
+		BackupTimeout:         s.config.backupTimeout,
+		MaintenanceTimeout:    s.config.maintenanceTimeout,
+		ResticBinary:          s.config.resticBinary,
+		ResticGlobalFlags:     s.config.resticGlobalFlags,
+		ResticCacheDir:        s.config.resticCacheDir,
+		ResticCacheEnabled:    s.config.resticCacheEnabled,
+		ResticLimitUpload:     s.config.resticLimitUpload,
+		ResticBackupIOClass:   s.config.resticBackupIOClass,
+		DryRun:                s.config.dryRun,
+		ShutdownGracePeriod:   s.config.shutdownGracePeriod,
+		UnlockStaleLocks:      s.config.unlockStaleLocks,
+		MaxVolumeSize:         s.maxVolumeSize,
+		VerifyReadDataPercent: s.config.verifyReadDataPercent,
+		VerificationPolicy:    controller.VerificationFailurePolicy(s.config.verificationPolicy),
+		RepoInitPrefix:        s.repoInitPrefix(),
+		MaxConcurrentInits:    s.config.maxConcurrentInits,
+		ResticCompression:     s.resticFeatures.compression,
+		ResticPackSize:        s.resticFeatures.packSize,
+		VolumeMountTimeout:    s.config.volumeMountTimeout,
+		ResticPasswordFile:    s.config.resticPasswordFile,
+		ResticPasswordCommand: s.config.resticPasswordCommand,
+		HostRootPath:          s.config.hostRootPath,
+		HostPathAllowList:     s.config.hostPathAllowList,
+		SkipUnchangedVolumes:  s.config.skipUnchangedVolumes,
+		ResticOneFileSystem:   s.config.resticOneFileSystem,
+		RepoStatsInterval:     s.config.repoStatsInterval,
+		ResticEnv:             s.config.resticEnv,
+		DeletionPolicy:        controller.SnapshotDeletionPolicy(s.config.deletionPolicy),
+		PressureConditions:    s.pressureConditions,
+		PressureRetryDelay:    s.config.pressureRetryDelay,
+		ResticReadConcurrency: s.resticFeatures.readConcurrency,
+		StaleBackupThreshold:  s.config.staleBackupThreshold,
+		ResticHost:            s.config.resticHost,
+		SkipImmutableErrors:   s.config.skipImmutableErrors,
+		ResticTempDir:         s.config.resticTempDir,
+		VerifyInterval:        s.config.verificationInterval,
+		MaxVerifications:      s.config.maxVerifications,
+		SkipEmptyVolumes:      s.config.skipEmptyVolumes,
+		OrphanGCInterval:      s.config.orphanGCInterval,
+		OrphanGracePeriod:     s.config.orphanGracePeriod,
+		ForgetOrphans:         s.config.forgetOrphans,
+		PostBackupHook:        s.config.postBackupHook,
+		PostBackupHookTimeout: s.config.hookTimeout,
+		HookFailurePolicy:     controller.PostBackupHookFailurePolicy(s.config.hookFailurePolicy),
+		FeatureGates:          s.featureGates,
+		SnapshotWaitTimeout:   s.config.snapshotWaitTimeout,
+		MaxQueueDepth:         s.config.maxQueueDepth,
+		QueueDepthRetryDelay:  s.config.queueDepthRetryDelay,
+		ResticRunAs:           s.resticRunAs,
+		MaxInFlightBytes:      s.maxInFlightBytes,
+		IncompletePolicy:      controller.IncompleteSnapshotPolicy(s.config.incompletePolicy),
+		BackupLogsMaxSize:     s.backupLogsMaxSize,
+		RotationWindow:        s.config.rotationWindow,
+		ResticSharedCache:     s.config.resticSharedCache,
+		Retention:             restic.KeepPolicy{Last: s.config.retentionKeepLast, Daily: s.config.retentionKeepDaily},
+		BackendOptions:        s.backendOptions,
+	})
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		backupController.Run(s.ctx, 1)
+		backupController.Run(s.ctx, s.config.backupWorkers)
 	}()
 
 	restoreController := controller.NewPodVolumeRestoreController(
@@ -135,7 +1094,14 @@ func (s *resticServer) run() {
 		s.podInformer,
 		s.kubeInformerFactory.Core().V1().Secrets(),
 		s.kubeInformerFactory.Core().V1().PersistentVolumeClaims(),
+		s.kubeInformerFactory.Core().V1().PersistentVolumes(),
 		os.Getenv("NODE_NAME"),
+		s.config.hostPodsPath,
+		s.config.resticBinary,
+		s.config.resticGlobalFlags,
+		s.config.resticEnv,
+		s.config.resticTempDir,
+		s.backendOptions,
 	)
 	wg.Add(1)
 	go func() {
@@ -143,6 +1109,9 @@ func (s *resticServer) run() {
 		restoreController.Run(s.ctx, 1)
 	}()
 
+	runningBackups, _ := backupController.(controller.RunningBackupsLister)
+	go s.serveHealth(failureTracker, runningBackups)
+
 	go s.arkInformerFactory.Start(s.ctx.Done())
 	go s.kubeInformerFactory.Start(s.ctx.Done())
 	go s.podInformer.Run(s.ctx.Done())
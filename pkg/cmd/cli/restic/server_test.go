@@ -0,0 +1,304 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+	corev1api "k8s.io/api/core/v1"
+
+	"github.com/heptio/ark/pkg/restic"
+	arktest "github.com/heptio/ark/pkg/util/test"
+)
+
+func TestValidateHostPodsPath(t *testing.T) {
+	fileSystem := arktest.NewFakeFileSystem().WithDirectory("/host_pods")
+
+	assert.NoError(t, validateHostPodsPath("/host_pods", fileSystem))
+	assert.EqualError(t, validateHostPodsPath("/var/lib/kubelet/pods", fileSystem), "host pods path /var/lib/kubelet/pods does not exist; ensure the host's kubelet pods directory is mounted there or set --host-pods-path")
+	assert.EqualError(t, validateHostPodsPath("", fileSystem), "host-pods-path must not be empty")
+
+	// the host pods path must be a mounted volume, not a directory on the
+	// pod's root filesystem.
+	fileSystem = arktest.NewFakeFileSystem().WithDirectory("/host_pods").WithFile("/proc/self/mountinfo", []byte(testMountInfo))
+	assert.NoError(t, validateHostPodsPath("/host_pods", fileSystem))
+
+	fileSystem = arktest.NewFakeFileSystem().WithDirectory("/host_pods").WithFile("/proc/self/mountinfo", []byte(strings.Replace(testMountInfo, " /host_pods ", " /mnt/pods ", 1)))
+	assert.EqualError(t, validateHostPodsPath("/host_pods", fileSystem), "host pods path /host_pods is not a mounted volume; ensure the restic daemonset mounts the host's kubelet pods directory (typically /var/lib/kubelet/pods) there with a hostPath volume, or set --host-pods-path")
+}
+
+// testMountInfo is the mountinfo of a restic pod with the host's kubelet
+// pods directory mounted at /host_pods, and its root filesystem at
+// /host root.
+const testMountInfo = `1180 1100 0:120 / / rw,relatime master:400 - overlay overlay rw,lowerdir=/var/lib/docker/overlay2/l/A
+1181 1180 0:122 / /proc rw,nosuid,nodev,noexec,relatime - proc proc rw
+1190 1180 8:1 /var/lib/kubelet/pods/pod-uid/volumes/kubernetes.io~secret/token /var/run/secrets/kubernetes.io/serviceaccount ro,relatime - ext4 /dev/sda1 rw
+1195 1180 8:1 / /host\040root rw,relatime master:1 - ext4 /dev/sda1 rw
+1200 1180 8:1 /var/lib/kubelet/pods /host_pods rw,relatime master:1 - ext4 /dev/sda1 rw
+`
+
+func TestContainingMountPoint(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{path: "/host_pods", expected: "/host_pods"},
+		{path: "/host_pods/", expected: "/host_pods"},
+		{path: "/host_pods/pod-uid/volumes", expected: "/host_pods"},
+		{path: "/host root/var/lib/kubelet/pods", expected: "/host root"},
+		{path: "/host_pods_2", expected: "/"},
+		{path: "/var/lib/kubelet/pods", expected: "/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			assert.Equal(t, test.expected, containingMountPoint(test.path, []byte(testMountInfo)))
+		})
+	}
+}
+
+func TestValidateHostPathAllowList(t *testing.T) {
+	fileSystem := arktest.NewFakeFileSystem().WithDirectory("/host_root")
+
+	assert.NoError(t, validateHostPathAllowList(nil, "", fileSystem))
+	assert.NoError(t, validateHostPathAllowList([]string{"/var/log", "/data"}, "/host_root", fileSystem))
+	assert.EqualError(t, validateHostPathAllowList([]string{"var/log"}, "/host_root", fileSystem), `host-path-allow-list entries must be absolute paths, got "var/log"`)
+	assert.EqualError(t, validateHostPathAllowList([]string{"/var/log"}, "", fileSystem), "host-root-path must not be empty when host-path-allow-list is set")
+	assert.EqualError(t, validateHostPathAllowList([]string{"/var/log"}, "/rootfs", fileSystem), "host root path /rootfs does not exist; ensure the host's root filesystem is mounted there or set --host-root-path")
+}
+
+func TestValidateResticBinary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restic-binary")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	executable := filepath.Join(dir, "restic")
+	require.NoError(t, ioutil.WriteFile(executable, []byte("#!/bin/sh\n"), 0755))
+
+	notExecutable := filepath.Join(dir, "restic-not-executable")
+	require.NoError(t, ioutil.WriteFile(notExecutable, []byte("#!/bin/sh\n"), 0644))
+
+	assert.NoError(t, validateResticBinary(executable))
+	assert.Error(t, validateResticBinary(notExecutable))
+	assert.Error(t, validateResticBinary(filepath.Join(dir, "missing")))
+}
+
+func TestValidateBackupThrottling(t *testing.T) {
+	assert.NoError(t, validateBackupThrottling(0, ""))
+	assert.NoError(t, validateBackupThrottling(1024, ""))
+	assert.EqualError(t, validateBackupThrottling(-1, ""), "restic-limit-upload must not be negative, got -1")
+	assert.EqualError(t, validateBackupThrottling(0, "realtime"), `invalid restic-backup-io-class: unsupported I/O class "realtime", must be one of best-effort, idle`)
+}
+
+func TestValidateSharedCache(t *testing.T) {
+	assert.NoError(t, validateSharedCache(false, false, "", ""))
+	assert.NoError(t, validateSharedCache(true, true, "/scratch/restic-cache", "/scratch/restic-tmp"))
+	assert.NoError(t, validateSharedCache(true, true, "/scratch/restic-cache", "/scratch/restic-cache-tmp"))
+	assert.EqualError(t, validateSharedCache(true, false, "/scratch/restic-cache", "/scratch/restic-tmp"), "restic-shared-cache can't be set when restic-cache is false")
+	assert.EqualError(t, validateSharedCache(true, true, "", "/scratch/restic-tmp"), "restic-shared-cache requires restic-cache-dir")
+	assert.EqualError(t, validateSharedCache(true, true, "/scratch/restic-cache", "/scratch/restic-cache/tmp"), "restic-shared-cache can't be set when restic-cache-dir /scratch/restic-cache contains the directory that credentials files are created in, /scratch/restic-cache/tmp; set restic-temp-dir to a directory outside it")
+	assert.Error(t, validateSharedCache(true, true, "/scratch/restic-cache/", "/scratch/restic-cache"))
+}
+
+func TestValidatePasswordSource(t *testing.T) {
+	fileSystem := arktest.NewFakeFileSystem().WithFile("/credentials/password", []byte("password"))
+
+	assert.NoError(t, validatePasswordSource("", "", fileSystem))
+	assert.NoError(t, validatePasswordSource("/credentials/password", "", fileSystem))
+	assert.NoError(t, validatePasswordSource("", "cat /credentials/password", fileSystem))
+	assert.EqualError(t, validatePasswordSource("/credentials/password", "cat /credentials/password", fileSystem), "only one of restic-password-file and restic-password-command may be set")
+	assert.Error(t, validatePasswordSource("/credentials/missing", "", fileSystem))
+}
+
+func TestParseByteLimit(t *testing.T) {
+	size, err := parseByteLimit("max-volume-size", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), size)
+
+	size, err = parseByteLimit("max-volume-size", "500Gi")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(500*1024*1024*1024), size)
+
+	size, err = parseByteLimit("max-volume-size", "1000")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), size)
+
+	_, err = parseByteLimit("max-volume-size", "-1Gi")
+	assert.EqualError(t, err, "max-volume-size must be positive, got -1Gi")
+
+	_, err = parseByteLimit("max-in-flight-bytes", "0")
+	assert.EqualError(t, err, "max-in-flight-bytes must be positive, got 0")
+
+	_, err = parseByteLimit("max-volume-size", "lots")
+	assert.Error(t, err)
+}
+
+func TestDetectResticFeatures(t *testing.T) {
+	tests := []struct {
+		name        string
+		version     string
+		versionErr  error
+		expectedNil bool
+		expected    []restic.Feature
+	}{
+		{
+			name:    "old version",
+			version: "restic 0.9.1 compiled with go1.10.3 on linux/amd64",
+		},
+		{
+			name:     "version with some features",
+			version:  "restic 0.14.0 compiled with go1.19 on linux/amd64",
+			expected: []restic.Feature{restic.FeatureCompression, restic.FeatureExcludeLargerThan, restic.FeaturePackSize},
+		},
+		{
+			name:     "version with all features",
+			version:  "restic 0.17.3 compiled with go1.23.3 on linux/amd64",
+			expected: restic.Features(),
+		},
+		{
+			name:        "unparseable version",
+			version:     "something else",
+			expectedNil: true,
+		},
+		{
+			name:        "error getting version",
+			versionErr:  errors.New("exec: not found"),
+			expectedNil: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gates := detectResticFeatures(func() (string, error) { return test.version, test.versionErr }, arktest.NewLogger())
+			if test.expectedNil {
+				assert.Nil(t, gates)
+				return
+			}
+			require.NotNil(t, gates)
+
+			var enabled []restic.Feature
+			for _, feature := range restic.Features() {
+				if gates.Enabled(feature) {
+					enabled = append(enabled, feature)
+				}
+			}
+			assert.Equal(t, test.expected, enabled)
+		})
+	}
+}
+
+func TestResolveResticFeatures(t *testing.T) {
+	tests := []struct {
+		name     string
+		features resticFeatures
+		version  restic.Version
+		unknown  bool
+		expected resticFeatures
+	}{
+		{
+			name:     "no features set",
+			unknown:  true,
+			expected: resticFeatures{},
+		},
+		{
+			name:     "supported version",
+			features: resticFeatures{compression: "max", packSize: 64},
+			version:  restic.Version{Major: 0, Minor: 14, Patch: 0},
+			expected: resticFeatures{compression: "max", packSize: 64},
+		},
+		{
+			name:     "read concurrency requires a later version than the other features",
+			features: resticFeatures{compression: "max", packSize: 64, readConcurrency: 8},
+			version:  restic.Version{Major: 0, Minor: 14, Patch: 0},
+			expected: resticFeatures{compression: "max", packSize: 64},
+		},
+		{
+			name:     "read concurrency supported",
+			features: resticFeatures{readConcurrency: 8},
+			version:  restic.Version{Major: 0, Minor: 15, Patch: 0},
+			expected: resticFeatures{readConcurrency: 8},
+		},
+		{
+			name:     "unsupported version",
+			features: resticFeatures{compression: "auto", packSize: 64},
+			version:  restic.Version{Major: 0, Minor: 9, Patch: 1},
+			expected: resticFeatures{},
+		},
+		{
+			name:     "unknown version",
+			features: resticFeatures{compression: "off", packSize: 16, readConcurrency: 4},
+			unknown:  true,
+			expected: resticFeatures{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var gates restic.FeatureGates
+			if !test.unknown {
+				gates = restic.NewFeatureGates(test.version)
+			}
+
+			assert.Equal(t, test.expected, resolveResticFeatures(test.features, gates, arktest.NewLogger()))
+		})
+	}
+}
+
+func TestParsePressureConditions(t *testing.T) {
+	conditions, err := parsePressureConditions(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, conditions)
+
+	conditions, err = parsePressureConditions([]string{"MemoryPressure", "DiskPressure"})
+	assert.NoError(t, err)
+	assert.Equal(t, []corev1api.NodeConditionType{corev1api.NodeMemoryPressure, corev1api.NodeDiskPressure}, conditions)
+
+	_, err = parsePressureConditions([]string{"MemoryPressure", "Ready"})
+	assert.EqualError(t, err, `invalid defer-backups-on-node-conditions value "Ready", must be one of MemoryPressure, DiskPressure, PIDPressure`)
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	limiter, err := newRateLimiter("patch", 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, rate.Inf, limiter.Limit())
+
+	limiter, err = newRateLimiter("patch", 5, 10)
+	require.NoError(t, err)
+	assert.Equal(t, rate.Limit(5), limiter.Limit())
+	assert.Equal(t, 10, limiter.Burst())
+
+	_, err = newRateLimiter("patch", -1, 10)
+	assert.EqualError(t, err, "patch-qps must not be negative, got -1")
+
+	_, err = newRateLimiter("queue", 5, 0)
+	assert.EqualError(t, err, "queue-burst must be at least 1, got 0")
+}
+
+func TestResticEnvWithTempDir(t *testing.T) {
+	assert.Nil(t, resticEnvWithTempDir(nil, ""))
+	assert.Equal(t, []string{"HTTPS_PROXY=http://proxy:3128"}, resticEnvWithTempDir([]string{"HTTPS_PROXY=http://proxy:3128"}, ""))
+	assert.Equal(t, []string{"TMPDIR=/scratch"}, resticEnvWithTempDir(nil, "/scratch"))
+	assert.Equal(t, []string{"TMPDIR=/scratch", "HTTPS_PROXY=http://proxy:3128"}, resticEnvWithTempDir([]string{"HTTPS_PROXY=http://proxy:3128"}, "/scratch"))
+}
@@ -554,6 +554,7 @@ func (s *server) runControllers(config *api.Config) error {
 			s.logger,
 			s.pluginManager,
 			backupTracker,
+			s.sharedInformerFactory.Ark().V1().PodVolumeBackups().Lister(),
 		)
 		wg.Add(1)
 		go func() {
@@ -607,6 +608,18 @@ func (s *server) runControllers(config *api.Config) error {
 			wg.Done()
 		}()
 
+		backupResticSummaryController := controller.NewBackupResticSummaryController(
+			s.logger,
+			s.arkClient.ArkV1(),
+			s.sharedInformerFactory.Ark().V1().Backups(),
+			s.sharedInformerFactory.Ark().V1().PodVolumeBackups(),
+		)
+		wg.Add(1)
+		go func() {
+			backupResticSummaryController.Run(ctx, 1)
+			wg.Done()
+		}()
+
 	}
 
 	restorer, err := restore.NewKubernetesRestorer(
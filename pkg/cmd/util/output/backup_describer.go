@@ -191,6 +191,22 @@ func DescribeBackupStatus(d *Describer, status v1.BackupStatus) {
 			d.Printf("\t\tIOPS:\t%s\n", iops)
 		}
 	}
+
+	d.Println()
+	if status.PodVolumeBackups == nil {
+		d.Printf("Pod Volume Backups: <none included>\n")
+	} else {
+		summary := status.PodVolumeBackups
+		d.Printf("Pod Volume Backups:\t%d total\n", summary.Total)
+		if status.ResticPhase != "" {
+			d.Printf("\tPhase:\t%s\n", status.ResticPhase)
+		}
+		d.Printf("\tCompleted:\t%d\n", summary.Completed)
+		d.Printf("\tFailed:\t%d\n", summary.Failed)
+		d.Printf("\tCanceled:\t%d\n", summary.Canceled)
+		d.Printf("\tIn Progress:\t%d\n", summary.InProgress)
+		d.Printf("\tNew:\t%d\n", summary.New)
+	}
 }
 
 // DescribeDeleteBackupRequests describes delete backup requests in human-readable format.
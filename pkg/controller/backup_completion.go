@@ -0,0 +1,225 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/restic"
+)
+
+// canceledMessage returns the status message of a backup that was canceled
+// while it was running.
+func (c *podVolumeBackupController) canceledMessage() string {
+	if c.isAbortingBackups() {
+		return "backup canceled because the restic server shut down before it completed"
+	}
+	return "backup canceled"
+}
+
+// finishCanceledBackup sets the phase of a backup that was canceled while
+// its volumes were being backed up to Canceled, recording the snapshots of
+// any volumes that were backed up before then.
+func (c *podVolumeBackupController) finishCanceledBackup(req *arkv1api.PodVolumeBackup, credsFile string, results *volumeBackupResults, log logrus.FieldLogger) error {
+	log.Info("PodVolumeBackup was canceled")
+
+	// killing restic may have left a stale lock in the repository.
+	if c.isAbortingBackups() {
+		unlockCmd := c.resticCommand(restic.UnlockCommand(podVolumeBackupRepoPrefix(req), req.Spec.Pod.Namespace, credsFile, false))
+		if err := c.unlockRepoFunc(unlockCmd); err != nil {
+			log.WithError(err).Warn("Error removing stale restic locks")
+		}
+	}
+
+	msg := c.canceledMessage()
+	if _, err := c.patchPodVolumeBackup(req, func(r *arkv1api.PodVolumeBackup) {
+		r.Status.SnapshotIDs = results.snapshotIDs
+		r.Status.LogsConfigMap = results.logsConfigMap
+		r.Status.Phase = arkv1api.PodVolumeBackupPhaseCanceled
+		r.Status.CompletionTimestamp = metav1.NewTime(c.clock.Now())
+		r.Status.Message = msg
+	}); err != nil {
+		log.WithError(err).Error("Error setting phase to Canceled")
+		return err
+	}
+	return nil
+}
+
+// finishFailedBackup sets the phase of a backup some of whose volumes
+// failed to be backed up to Failed, recording the snapshots of the volumes
+// that were backed up. If several volumes failed, the first one's failure
+// reason is reported.
+func (c *podVolumeBackupController) finishFailedBackup(req *arkv1api.PodVolumeBackup, repo string, results *volumeBackupResults, log logrus.FieldLogger) error {
+	reason := failureReason(results.errs[0])
+	exitCode := resticExitCode(results.errs[0])
+	msg := kerrors.NewAggregate(results.errs).Error()
+
+	if reason == arkv1api.PodVolumeBackupFailureReasonAuthFailed {
+		reason, msg = c.passwordRotationFailure(req, reason, msg, log)
+	}
+
+	c.recordRepositoryResult(repo, reason)
+	msg = c.withRemediationHint(req, reason, msg)
+	if _, err := c.patchPodVolumeBackup(req, func(r *arkv1api.PodVolumeBackup) {
+		r.Status.SnapshotIDs = results.snapshotIDs
+		r.Status.LogsConfigMap = results.logsConfigMap
+		r.Status.Phase = arkv1api.PodVolumeBackupPhaseFailed
+		r.Status.CompletionTimestamp = metav1.NewTime(c.clock.Now())
+		r.Status.Message = msg
+		r.Status.FailureReason = reason
+		r.Status.ExitCode = exitCode
+	}); err != nil {
+		log.WithError(err).Error("Error setting phase to Failed")
+		return err
+	}
+	c.recordFailedEvent(req, reason, msg)
+	c.registerFailure(exitCode)
+	return nil
+}
+
+// finishDryRunBackup sets the phase of a dry run to CompletedDryRun. No
+// snapshots are taken in a dry run, so only the path that would have been
+// backed up is recorded.
+func (c *podVolumeBackupController) finishDryRunBackup(req *arkv1api.PodVolumeBackup, volumes []string, subPath string, results *volumeBackupResults, log logrus.FieldLogger) error {
+	if _, err := c.patchPodVolumeBackup(req, func(r *arkv1api.PodVolumeBackup) {
+		if len(volumes) == 1 {
+			r.Status.Path = results.paths[volumes[0]]
+		}
+		r.Status.SubPath = subPath
+		r.Status.Message = "dry run: restic backup was not run"
+		r.Status.Phase = arkv1api.PodVolumeBackupPhaseCompletedDryRun
+		r.Status.CompletionTimestamp = metav1.NewTime(c.clock.Now())
+	}); err != nil {
+		log.WithError(err).Error("Error setting phase to CompletedDryRun")
+		return err
+	}
+	return nil
+}
+
+// completeBackup runs the post-backup steps of a backup whose volumes were
+// all backed up, i.e. it verifies the repository, applies the retention
+// policy and gets the snapshots' stats, and then sets its phase to
+// Completed, or to Failed if a mirror or the verification failed and the
+// backup's policy says to fail it. Once it's completed, the repository is
+// pruned if the prune trigger says so.
+func (c *podVolumeBackupController) completeBackup(ctx context.Context, req *arkv1api.PodVolumeBackup, volumes []string, subPath, credsFile string, results *volumeBackupResults, log logrus.FieldLogger) error {
+	// maintaining the repository, i.e. verifying, forgetting snapshots
+	// from and pruning it, isn't subject to the backup's timeout, but it's
+	// stopped when the controller shuts down, and bounded by its own
+	// timeout so that it can't hold the backup's slot indefinitely.
+	maintenanceCtx, cancel := c.maintenanceContext()
+	defer cancel()
+
+	if failed := failedMirrors(results.mirrors); len(failed) > 0 {
+		msg := "backup to mirror repositories failed: " + strings.Join(failed, "; ")
+		if req.Spec.MirrorFailurePolicy == arkv1api.PodVolumeBackupMirrorFailurePolicyFail {
+			return c.failBackedUpBackup(req, arkv1api.PodVolumeBackupFailureReasonMirrorFailed, msg, results, log)
+		}
+
+		c.eventRecorder.Eventf(req, corev1api.EventTypeWarning, eventReasonBackupMirrorFailed, "Backup to mirror repositories failed: %s", strings.Join(failed, "; "))
+		results.messages = append(results.messages, msg)
+	}
+
+	results.verification = c.verifyBackup(maintenanceCtx, req, credsFile, log)
+	if results.verification.Phase == arkv1api.PodVolumeBackupVerificationPhaseFailed {
+		if c.verificationPolicy == VerificationFailurePolicyFail {
+			return c.failBackedUpBackup(req, arkv1api.PodVolumeBackupFailureReasonVerificationFailed, "backup verification failed: "+results.verification.Message, results, log)
+		}
+
+		c.eventRecorder.Eventf(req, corev1api.EventTypeWarning, eventReasonBackupVerificationFailed, "Backup verification failed: %s", results.verification.Message)
+	}
+
+	forgotten := c.applyRetention(maintenanceCtx, req, results.paths, results.snapshotIDs, results.volumeModes, credsFile, log)
+
+	stats := c.snapshotStats(ctx, req, credsFile, results.snapshotIDs, log)
+
+	// update status to Completed with path, snapshot id & stats
+	req, err := c.patchPodVolumeBackup(req, func(r *arkv1api.PodVolumeBackup) {
+		if len(volumes) == 1 {
+			r.Status.Path = results.paths[volumes[0]]
+			r.Status.SnapshotID = results.snapshotIDs[volumes[0]]
+		}
+		r.Status.SubPath = subPath
+		r.Status.SnapshotIDs = results.snapshotIDs
+		r.Status.VolumeModes = results.volumeModes
+		r.Status.Mirrors = results.mirrors
+		r.Status.SnapshotSize = stats.TotalSize
+		r.Status.SnapshotFileCount = stats.TotalFileCount
+		r.Status.Verification = results.verification
+		r.Status.LogsConfigMap = results.logsConfigMap
+		r.Status.ForgottenSnapshots = forgotten
+		r.Status.Message = strings.Join(results.messages, "; ")
+		if results.incomplete {
+			r.Status.Incomplete = true
+			r.Status.ExitCode = restic.ExitCodeIncompleteSnapshot
+		}
+		r.Status.Phase = arkv1api.PodVolumeBackupPhaseCompleted
+		r.Status.CompletionTimestamp = metav1.NewTime(c.clock.Now())
+	})
+	if err != nil {
+		log.WithError(err).Error("Error setting phase to Completed")
+		return err
+	}
+	c.eventRecorder.Eventf(req, corev1api.EventTypeNormal, eventReasonBackupCompleted, "Backed up volumes of pod %s/%s, snapshot IDs: %s", req.Spec.Pod.Namespace, req.Spec.Pod.Name, formatSnapshotIDs(results.snapshotIDs))
+	c.metrics.RegisterPodVolumeBackupSuccess(c.nodeName)
+
+	if c.pruneTrigger != nil && c.pruneTrigger.BackupCompleted(req.Spec.Pod.Namespace) {
+		c.pruneRepository(maintenanceCtx, podVolumeBackupRepoPrefix(req), req.Spec.Pod.Namespace, credsFile, log)
+	}
+
+	return nil
+}
+
+// failBackedUpBackup sets the phase of a backup whose volumes were all
+// backed up to Failed because a post-backup step failed, recording its
+// snapshots, mirrors and verification result.
+func (c *podVolumeBackupController) failBackedUpBackup(req *arkv1api.PodVolumeBackup, reason arkv1api.PodVolumeBackupFailureReason, msg string, results *volumeBackupResults, log logrus.FieldLogger) error {
+	if _, err := c.patchPodVolumeBackup(req, func(r *arkv1api.PodVolumeBackup) {
+		r.Status.SnapshotIDs = results.snapshotIDs
+		r.Status.Mirrors = results.mirrors
+		r.Status.Verification = results.verification
+		r.Status.LogsConfigMap = results.logsConfigMap
+		r.Status.Phase = arkv1api.PodVolumeBackupPhaseFailed
+		r.Status.CompletionTimestamp = metav1.NewTime(c.clock.Now())
+		r.Status.Message = msg
+		r.Status.FailureReason = reason
+	}); err != nil {
+		log.WithError(err).Error("Error setting phase to Failed")
+		return err
+	}
+	c.recordFailedEvent(req, reason, msg)
+	c.registerFailure(0)
+	return nil
+}
+
+// maintenanceContext returns the context that a backup's repository is
+// maintained with once its volumes are backed up, which is done when the
+// controller shuts down or maintenanceTimeout, if set, has passed.
+func (c *podVolumeBackupController) maintenanceContext() (context.Context, context.CancelFunc) {
+	if c.maintenanceTimeout > 0 {
+		return context.WithTimeout(c.runCtx, c.maintenanceTimeout)
+	}
+	return context.WithCancel(c.runCtx)
+}
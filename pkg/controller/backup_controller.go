@@ -46,6 +46,7 @@ import (
 	informers "github.com/heptio/ark/pkg/generated/informers/externalversions/ark/v1"
 	listers "github.com/heptio/ark/pkg/generated/listers/ark/v1"
 	"github.com/heptio/ark/pkg/plugin"
+	"github.com/heptio/ark/pkg/restic"
 	"github.com/heptio/ark/pkg/util/collections"
 	"github.com/heptio/ark/pkg/util/encode"
 	kubeutil "github.com/heptio/ark/pkg/util/kube"
@@ -54,19 +55,20 @@ import (
 const backupVersion = 1
 
 type backupController struct {
-	backupper        backup.Backupper
-	backupService    cloudprovider.BackupService
-	bucket           string
-	pvProviderExists bool
-	lister           listers.BackupLister
-	listerSynced     cache.InformerSynced
-	client           arkv1client.BackupsGetter
-	syncHandler      func(backupName string) error
-	queue            workqueue.RateLimitingInterface
-	clock            clock.Clock
-	logger           logrus.FieldLogger
-	pluginManager    plugin.Manager
-	backupTracker    BackupTracker
+	backupper             backup.Backupper
+	backupService         cloudprovider.BackupService
+	bucket                string
+	pvProviderExists      bool
+	lister                listers.BackupLister
+	listerSynced          cache.InformerSynced
+	client                arkv1client.BackupsGetter
+	syncHandler           func(backupName string) error
+	queue                 workqueue.RateLimitingInterface
+	clock                 clock.Clock
+	logger                logrus.FieldLogger
+	pluginManager         plugin.Manager
+	backupTracker         BackupTracker
+	podVolumeBackupLister listers.PodVolumeBackupLister
 }
 
 func NewBackupController(
@@ -79,20 +81,22 @@ func NewBackupController(
 	logger logrus.FieldLogger,
 	pluginManager plugin.Manager,
 	backupTracker BackupTracker,
+	podVolumeBackupLister listers.PodVolumeBackupLister,
 ) Interface {
 	c := &backupController{
-		backupper:        backupper,
-		backupService:    backupService,
-		bucket:           bucket,
-		pvProviderExists: pvProviderExists,
-		lister:           backupInformer.Lister(),
-		listerSynced:     backupInformer.Informer().HasSynced,
-		client:           client,
-		queue:            workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "backup"),
-		clock:            &clock.RealClock{},
-		logger:           logger,
-		pluginManager:    pluginManager,
-		backupTracker:    backupTracker,
+		backupper:             backupper,
+		backupService:         backupService,
+		bucket:                bucket,
+		pvProviderExists:      pvProviderExists,
+		lister:                backupInformer.Lister(),
+		listerSynced:          backupInformer.Informer().HasSynced,
+		client:                client,
+		queue:                 workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "backup"),
+		clock:                 &clock.RealClock{},
+		logger:                logger,
+		pluginManager:         pluginManager,
+		backupTracker:         backupTracker,
+		podVolumeBackupLister: podVolumeBackupLister,
 	}
 
 	c.syncHandler = c.processBackup
@@ -359,6 +363,8 @@ func (controller *backupController) runBackup(backup *api.Backup, bucket string)
 		backup.Status.Phase = api.BackupPhaseCompleted
 	}
 
+	controller.setPodVolumeBackupSummary(backup, log)
+
 	backupJson := new(bytes.Buffer)
 	if err := encode.EncodeTo(backup, "json", backupJson); err != nil {
 		errs = append(errs, errors.Wrap(err, "error encoding backup"))
@@ -377,6 +383,21 @@ func (controller *backupController) runBackup(backup *api.Backup, bucket string)
 	return kerrors.NewAggregate(errs)
 }
 
+// setPodVolumeBackupSummary records a summary of the backup's PodVolumeBackups,
+// and their aggregate phase, if it has any, in its status.
+func (controller *backupController) setPodVolumeBackupSummary(backup *api.Backup, log logrus.FieldLogger) {
+	summary, err := restic.GetPodVolumeBackupSummary(backup, controller.podVolumeBackupLister)
+	if err != nil {
+		log.WithError(err).Warn("Error summarizing pod volume backups")
+		return
+	}
+
+	if summary.Total > 0 {
+		backup.Status.PodVolumeBackups = &summary
+		backup.Status.ResticPhase = backupResticPhase(summary)
+	}
+}
+
 func closeAndRemoveFile(file *os.File, log logrus.FieldLogger) {
 	if err := file.Close(); err != nil {
 		log.WithError(err).WithField("file", file.Name()).Error("error closing file")
@@ -22,6 +22,7 @@ import (
 	"testing"
 	"time"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/clock"
 	core "k8s.io/client-go/testing"
@@ -169,6 +170,7 @@ func TestProcessBackup(t *testing.T) {
 				logger,
 				pluginManager,
 				NewBackupTracker(),
+				sharedInformers.Ark().V1().PodVolumeBackups().Lister(),
 			).(*backupController)
 
 			c.clock = clock.NewFakeClock(clockTime)
@@ -291,6 +293,40 @@ func TestProcessBackup(t *testing.T) {
 	}
 }
 
+func TestSetPodVolumeBackupSummary(t *testing.T) {
+	var (
+		client          = fake.NewSimpleClientset()
+		sharedInformers = informers.NewSharedInformerFactory(client, 0)
+		c               = &backupController{podVolumeBackupLister: sharedInformers.Ark().V1().PodVolumeBackups().Lister()}
+	)
+
+	for name, phase := range map[string]v1.PodVolumeBackupPhase{
+		"pvb-1": v1.PodVolumeBackupPhaseCompleted,
+		"pvb-2": v1.PodVolumeBackupPhaseCompleted,
+		"pvb-3": v1.PodVolumeBackupPhaseFailed,
+	} {
+		require.NoError(t, sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore().Add(&v1.PodVolumeBackup{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: v1.DefaultNamespace,
+				Name:      name,
+				Labels:    map[string]string{v1.BackupNameLabel: "backup-1"},
+			},
+			Status: v1.PodVolumeBackupStatus{Phase: phase},
+		}))
+	}
+
+	backup := arktest.NewTestBackup().WithName("backup-1").Backup
+	c.setPodVolumeBackupSummary(backup, arktest.NewLogger())
+	assert.Equal(t, &v1.PodVolumeBackupSummary{Total: 3, Completed: 2, Failed: 1}, backup.Status.PodVolumeBackups)
+	assert.Equal(t, v1.BackupResticPhasePartiallyFailed, backup.Status.ResticPhase)
+
+	// backups without PodVolumeBackups don't get a summary
+	backup = arktest.NewTestBackup().WithName("backup-2").Backup
+	c.setPodVolumeBackupSummary(backup, arktest.NewLogger())
+	assert.Nil(t, backup.Status.PodVolumeBackups)
+	assert.Empty(t, backup.Status.ResticPhase)
+}
+
 // MockManager is an autogenerated mock type for the Manager type
 type MockManager struct {
 	mock.Mock
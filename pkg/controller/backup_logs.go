@@ -0,0 +1,143 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+)
+
+// backupLogs collects the output of the restic backups of a
+// PodVolumeBackup's volumes, so that it can be kept for debugging after the
+// backup has finished. Each volume's stdout and stderr are kept separately,
+// truncated to at most maxSize bytes. A nil *backupLogs discards all output.
+type backupLogs struct {
+	maxSize int64
+	data    map[string]string
+}
+
+// newBackupLogs returns a backupLogs that keeps at most maxSize bytes of
+// each output, or nil if maxSize isn't positive.
+func newBackupLogs(maxSize int64) *backupLogs {
+	if maxSize <= 0 {
+		return nil
+	}
+
+	return &backupLogs{
+		maxSize: maxSize,
+		data:    make(map[string]string),
+	}
+}
+
+// Add records the output of the restic backup of volume. Empty outputs are
+// not recorded.
+func (l *backupLogs) Add(volume, stdout, stderr string) {
+	if l == nil {
+		return
+	}
+
+	if stdout != "" {
+		l.data[volume+".stdout"] = truncateLog(stdout, l.maxSize)
+	}
+	if stderr != "" {
+		l.data[volume+".stderr"] = truncateLog(stderr, l.maxSize)
+	}
+}
+
+// Data returns the recorded outputs, keyed by <volume>.stdout and
+// <volume>.stderr.
+func (l *backupLogs) Data() map[string]string {
+	if l == nil {
+		return nil
+	}
+
+	return l.data
+}
+
+// truncateLog returns output if it's at most maxSize bytes long. Otherwise,
+// it returns the end of output, where restic reports errors and its
+// summary, preceded by a note of how much was removed, within maxSize bytes.
+func truncateLog(output string, maxSize int64) string {
+	if int64(len(output)) <= maxSize {
+		return output
+	}
+
+	keep := int(maxSize)
+	for {
+		note := fmt.Sprintf("[truncated %d bytes]\n", len(output)-keep)
+		if len(note)+keep <= int(maxSize) || keep == 0 {
+			return note + output[len(output)-keep:]
+		}
+		keep--
+	}
+}
+
+// storeBackupLogs stores the restic output recorded in logs in a ConfigMap,
+// named after the PodVolumeBackup and owned by it so that it's deleted along
+// with it, and returns the ConfigMap's name. It returns an empty name if
+// there's no output to store, or if it can't be stored, since that doesn't
+// affect the backup itself.
+func (c *podVolumeBackupController) storeBackupLogs(req *arkv1api.PodVolumeBackup, logs *backupLogs, log logrus.FieldLogger) string {
+	if len(logs.Data()) == 0 || c.configMaps == nil {
+		return ""
+	}
+
+	configMap := &corev1api.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: req.Namespace,
+			Name:      req.Name + backupLogsConfigMapSuffix,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: arkv1api.SchemeGroupVersion.String(),
+					Kind:       "PodVolumeBackup",
+					Name:       req.Name,
+					UID:        req.UID,
+				},
+			},
+			Labels: map[string]string{
+				arkv1api.BackupNameLabel: req.Labels[arkv1api.BackupNameLabel],
+			},
+		},
+		Data: logs.Data(),
+	}
+
+	// a backup that's run again, e.g. after the restic server restarted,
+	// replaces the previous run's logs.
+	client := c.configMaps.ConfigMaps(req.Namespace)
+	_, err := client.Create(configMap)
+	if apierrors.IsAlreadyExists(err) {
+		var existing *corev1api.ConfigMap
+		if existing, err = client.Get(configMap.Name, metav1.GetOptions{}); err == nil {
+			existing.Data = configMap.Data
+			_, err = client.Update(existing)
+		}
+	}
+	if err != nil {
+		log.WithError(errors.WithStack(err)).Warn("Error storing restic output in ConfigMap")
+		return ""
+	}
+
+	return configMap.Name
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateLog(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		maxSize  int64
+		expected string
+	}{
+		{
+			name:     "output shorter than the limit is kept",
+			output:   "abc",
+			maxSize:  10,
+			expected: "abc",
+		},
+		{
+			name:     "output as long as the limit is kept",
+			output:   "abcdefghij",
+			maxSize:  10,
+			expected: "abcdefghij",
+		},
+		{
+			name:     "the end of longer output is kept, with a note",
+			output:   strings.Repeat("x", 100) + "Fatal: unable to save snapshot",
+			maxSize:  52,
+			expected: "[truncated 99 bytes]\nxFatal: unable to save snapshot",
+		},
+		{
+			name:     "only the note is kept if the limit is too small for any output",
+			output:   strings.Repeat("x", 100),
+			maxSize:  5,
+			expected: "[truncated 100 bytes]\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := truncateLog(test.output, test.maxSize)
+			assert.Equal(t, test.expected, actual)
+			if len(test.output) > int(test.maxSize) && int(test.maxSize) > len("[truncated 100 bytes]\n") {
+				assert.Len(t, actual, int(test.maxSize))
+			}
+		})
+	}
+}
+
+func TestBackupLogs(t *testing.T) {
+	logs := newBackupLogs(42)
+	logs.Add("vol-1", "snapshot abc saved", "")
+	logs.Add("vol-2", "", strings.Repeat("x", 50)+"Fatal: wrong password")
+
+	// empty outputs aren't recorded.
+	assert.Equal(t, map[string]string{
+		"vol-1.stdout": "snapshot abc saved",
+		"vol-2.stderr": "[truncated 50 bytes]\nFatal: wrong password",
+	}, logs.Data())
+
+	// output is discarded when no limit is set.
+	logs = newBackupLogs(0)
+	assert.Nil(t, logs)
+	logs.Add("vol-1", "snapshot abc saved", "")
+	assert.Empty(t, logs.Data())
+}
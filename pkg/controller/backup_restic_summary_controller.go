@@ -0,0 +1,154 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/cache"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+	arkv1client "github.com/heptio/ark/pkg/generated/clientset/versioned/typed/ark/v1"
+	informers "github.com/heptio/ark/pkg/generated/informers/externalversions/ark/v1"
+	listers "github.com/heptio/ark/pkg/generated/listers/ark/v1"
+	"github.com/heptio/ark/pkg/restic"
+)
+
+// backupResticSummaryController keeps each backup's summary of its
+// PodVolumeBackups, and the aggregate phase of its restic backups, up to
+// date as the PodVolumeBackups change phase.
+type backupResticSummaryController struct {
+	*genericController
+
+	backupClient          arkv1client.BackupsGetter
+	backupLister          listers.BackupLister
+	podVolumeBackupLister listers.PodVolumeBackupLister
+}
+
+// NewBackupResticSummaryController creates a new backup restic summary
+// controller.
+func NewBackupResticSummaryController(
+	logger logrus.FieldLogger,
+	backupClient arkv1client.BackupsGetter,
+	backupInformer informers.BackupInformer,
+	podVolumeBackupInformer informers.PodVolumeBackupInformer,
+) Interface {
+	c := &backupResticSummaryController{
+		genericController:     newGenericController("backup-restic-summary", logger),
+		backupClient:          backupClient,
+		backupLister:          backupInformer.Lister(),
+		podVolumeBackupLister: podVolumeBackupInformer.Lister(),
+	}
+
+	c.syncHandler = c.processBackup
+	c.cacheSyncWaiters = append(c.cacheSyncWaiters,
+		backupInformer.Informer().HasSynced,
+		podVolumeBackupInformer.Informer().HasSynced,
+	)
+
+	podVolumeBackupInformer.Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.enqueueBackup,
+			UpdateFunc: func(_, obj interface{}) { c.enqueueBackup(obj) },
+			DeleteFunc: c.enqueueBackup,
+		},
+	)
+
+	return c
+}
+
+// enqueueBackup enqueues the backup that a PodVolumeBackup belongs to, as
+// given by its backup name label.
+func (c *backupResticSummaryController) enqueueBackup(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	pvb, ok := obj.(*arkv1api.PodVolumeBackup)
+	if !ok {
+		return
+	}
+
+	backupName := pvb.Labels[arkv1api.BackupNameLabel]
+	if backupName == "" {
+		return
+	}
+
+	c.queue.Add(pvb.Namespace + "/" + backupName)
+}
+
+func (c *backupResticSummaryController) processBackup(key string) error {
+	log := c.logger.WithField("key", key)
+
+	ns, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		log.WithError(err).Error("Error splitting queue key")
+		return nil
+	}
+
+	backup, err := c.backupLister.Backups(ns).Get(name)
+	if apierrors.IsNotFound(err) {
+		log.Debug("Unable to find backup")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "error getting backup")
+	}
+
+	summary, err := restic.GetPodVolumeBackupSummary(backup, c.podVolumeBackupLister)
+	if err != nil {
+		return err
+	}
+	// a backup whose PodVolumeBackups have all been deleted keeps its
+	// last summary.
+	if summary.Total == 0 {
+		return nil
+	}
+
+	phase := backupResticPhase(summary)
+	if backup.Status.PodVolumeBackups != nil && *backup.Status.PodVolumeBackups == summary && backup.Status.ResticPhase == phase {
+		return nil
+	}
+
+	updated := backup.DeepCopy()
+	updated.Status.PodVolumeBackups = &summary
+	updated.Status.ResticPhase = phase
+	if _, err := patchBackup(backup, updated, c.backupClient); err != nil {
+		return err
+	}
+
+	if phase != arkv1api.BackupResticPhaseInProgress && backup.Status.ResticPhase != phase {
+		log.Infof("Pod volume backups finished: %d of %d completed, %d failed, %d canceled", summary.Completed, summary.Total, summary.Failed, summary.Canceled)
+	}
+
+	return nil
+}
+
+// backupResticPhase returns the aggregate phase of the PodVolumeBackups
+// counted by summary.
+func backupResticPhase(summary arkv1api.PodVolumeBackupSummary) arkv1api.BackupResticPhase {
+	switch {
+	case summary.New > 0 || summary.InProgress > 0:
+		return arkv1api.BackupResticPhaseInProgress
+	case summary.Failed > 0 || summary.Canceled > 0:
+		return arkv1api.BackupResticPhasePartiallyFailed
+	default:
+		return arkv1api.BackupResticPhaseCompleted
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	core "k8s.io/client-go/testing"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/generated/clientset/versioned/fake"
+	informers "github.com/heptio/ark/pkg/generated/informers/externalversions"
+	arktest "github.com/heptio/ark/pkg/util/test"
+)
+
+func TestBackupResticSummaryControllerProcessBackup(t *testing.T) {
+	tests := []struct {
+		name            string
+		backupMissing   bool
+		existingSummary *arkv1api.PodVolumeBackupSummary
+		existingPhase   arkv1api.BackupResticPhase
+		phases          []arkv1api.PodVolumeBackupPhase
+		expectedSummary *arkv1api.PodVolumeBackupSummary
+		expectedPhase   arkv1api.BackupResticPhase
+	}{
+		{
+			name:            "all pod volume backups completed",
+			phases:          []arkv1api.PodVolumeBackupPhase{arkv1api.PodVolumeBackupPhaseCompleted, arkv1api.PodVolumeBackupPhaseCompleted},
+			expectedSummary: &arkv1api.PodVolumeBackupSummary{Total: 2, Completed: 2},
+			expectedPhase:   arkv1api.BackupResticPhaseCompleted,
+		},
+		{
+			name:            "some pod volume backups failed",
+			phases:          []arkv1api.PodVolumeBackupPhase{arkv1api.PodVolumeBackupPhaseCompleted, arkv1api.PodVolumeBackupPhaseFailed},
+			expectedSummary: &arkv1api.PodVolumeBackupSummary{Total: 2, Completed: 1, Failed: 1},
+			expectedPhase:   arkv1api.BackupResticPhasePartiallyFailed,
+		},
+		{
+			name:            "some pod volume backups were canceled",
+			phases:          []arkv1api.PodVolumeBackupPhase{arkv1api.PodVolumeBackupPhaseCanceled, arkv1api.PodVolumeBackupPhaseCompleted},
+			expectedSummary: &arkv1api.PodVolumeBackupSummary{Total: 2, Completed: 1, Canceled: 1},
+			expectedPhase:   arkv1api.BackupResticPhasePartiallyFailed,
+		},
+		{
+			name:            "some pod volume backups haven't finished",
+			phases:          []arkv1api.PodVolumeBackupPhase{arkv1api.PodVolumeBackupPhaseFailed, arkv1api.PodVolumeBackupPhaseInProgress, arkv1api.PodVolumeBackupPhaseNew},
+			expectedSummary: &arkv1api.PodVolumeBackupSummary{Total: 3, New: 1, InProgress: 1, Failed: 1},
+			expectedPhase:   arkv1api.BackupResticPhaseInProgress,
+		},
+		{
+			name:            "summary is updated when a pod volume backup finishes",
+			existingSummary: &arkv1api.PodVolumeBackupSummary{Total: 2, InProgress: 1, Completed: 1},
+			existingPhase:   arkv1api.BackupResticPhaseInProgress,
+			phases:          []arkv1api.PodVolumeBackupPhase{arkv1api.PodVolumeBackupPhaseCompleted, arkv1api.PodVolumeBackupPhaseCompleted},
+			expectedSummary: &arkv1api.PodVolumeBackupSummary{Total: 2, Completed: 2},
+			expectedPhase:   arkv1api.BackupResticPhaseCompleted,
+		},
+		{
+			name:            "unchanged summary isn't patched",
+			existingSummary: &arkv1api.PodVolumeBackupSummary{Total: 1, Completed: 1},
+			existingPhase:   arkv1api.BackupResticPhaseCompleted,
+			phases:          []arkv1api.PodVolumeBackupPhase{arkv1api.PodVolumeBackupPhaseCompleted},
+		},
+		{
+			name: "backup without pod volume backups isn't patched",
+		},
+		{
+			name:          "missing backup is ignored",
+			backupMissing: true,
+			phases:        []arkv1api.PodVolumeBackupPhase{arkv1api.PodVolumeBackupPhaseCompleted},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				client          = fake.NewSimpleClientset()
+				sharedInformers = informers.NewSharedInformerFactory(client, 0)
+				controller      = NewBackupResticSummaryController(
+					arktest.NewLogger(),
+					client.ArkV1(),
+					sharedInformers.Ark().V1().Backups(),
+					sharedInformers.Ark().V1().PodVolumeBackups(),
+				).(*backupResticSummaryController)
+			)
+
+			backup := arktest.NewTestBackup().WithName("backup-1").Backup
+			backup.Status.PodVolumeBackups = test.existingSummary
+			backup.Status.ResticPhase = test.existingPhase
+			if !test.backupMissing {
+				require.NoError(t, sharedInformers.Ark().V1().Backups().Informer().GetStore().Add(backup))
+			}
+
+			for i, phase := range test.phases {
+				require.NoError(t, sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore().Add(&arkv1api.PodVolumeBackup{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace: arkv1api.DefaultNamespace,
+						Name:      fmt.Sprintf("pvb-%d", i),
+						Labels:    map[string]string{arkv1api.BackupNameLabel: "backup-1"},
+					},
+					Status: arkv1api.PodVolumeBackupStatus{Phase: phase},
+				}))
+			}
+
+			// another backup's pod volume backups aren't counted.
+			require.NoError(t, sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore().Add(&arkv1api.PodVolumeBackup{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: arkv1api.DefaultNamespace,
+					Name:      "other",
+					Labels:    map[string]string{arkv1api.BackupNameLabel: "backup-2"},
+				},
+				Status: arkv1api.PodVolumeBackupStatus{Phase: arkv1api.PodVolumeBackupPhaseFailed},
+			}))
+
+			require.NoError(t, controller.processBackup(arkv1api.DefaultNamespace+"/backup-1"))
+
+			if test.expectedSummary == nil {
+				assert.Empty(t, client.Actions())
+				return
+			}
+
+			require.Len(t, client.Actions(), 1)
+			patchAction, ok := client.Actions()[0].(core.PatchAction)
+			require.True(t, ok)
+			assert.Equal(t, "backup-1", patchAction.GetName())
+
+			original, err := json.Marshal(backup)
+			require.NoError(t, err)
+			patched, err := jsonpatch.MergePatch(original, patchAction.GetPatch())
+			require.NoError(t, err)
+
+			res := new(arkv1api.Backup)
+			require.NoError(t, json.Unmarshal(patched, res))
+			assert.Equal(t, test.expectedSummary, res.Status.PodVolumeBackups)
+			assert.Equal(t, test.expectedPhase, res.Status.ResticPhase)
+		})
+	}
+}
+
+func TestBackupResticSummaryControllerEnqueueBackup(t *testing.T) {
+	var (
+		client          = fake.NewSimpleClientset()
+		sharedInformers = informers.NewSharedInformerFactory(client, 0)
+		controller      = NewBackupResticSummaryController(
+			arktest.NewLogger(),
+			client.ArkV1(),
+			sharedInformers.Ark().V1().Backups(),
+			sharedInformers.Ark().V1().PodVolumeBackups(),
+		).(*backupResticSummaryController)
+	)
+
+	controller.enqueueBackup(&arkv1api.PodVolumeBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: arkv1api.DefaultNamespace,
+			Name:      "pvb-1",
+			Labels:    map[string]string{arkv1api.BackupNameLabel: "backup-1"},
+		},
+	})
+
+	// pod volume backups that don't belong to a backup are ignored.
+	controller.enqueueBackup(&arkv1api.PodVolumeBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: arkv1api.DefaultNamespace,
+			Name:      "pvb-2",
+		},
+	})
+
+	require.Equal(t, 1, controller.queue.Len())
+	key, _ := controller.queue.Get()
+	assert.Equal(t, arkv1api.DefaultNamespace+"/backup-1", key)
+}
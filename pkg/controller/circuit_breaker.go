@@ -0,0 +1,159 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// CircuitState is the state of a repository's circuit in a CircuitBreaker.
+type CircuitState string
+
+const (
+	// CircuitClosed means backups to the repository are run.
+	CircuitClosed CircuitState = "Closed"
+
+	// CircuitOpen means backups to the repository have failed repeatedly,
+	// so they're failed without being run.
+	CircuitOpen CircuitState = "Open"
+
+	// CircuitHalfOpen means a repository's circuit has been open for long
+	// enough that a single backup is run to try the repository again.
+	CircuitHalfOpen CircuitState = "HalfOpen"
+)
+
+// CircuitBreaker stops backups from being run against a restic repository
+// that's broken, e.g. because its password is wrong or its bucket is gone.
+// After a number of consecutive failed backups to a repository, its circuit
+// opens, and backups to it are failed without running restic. Once it's been
+// open for a while, it's half-open: one backup at a time is allowed through
+// to try the repository again. If that backup succeeds, the circuit closes;
+// if it fails, the circuit opens again.
+type CircuitBreaker interface {
+	// Allow returns true if a backup to the repository may be run. If it
+	// may not, it also returns when the repository will next be tried.
+	Allow(repo string) (bool, time.Time)
+	// Success informs the breaker that a backup to the repository
+	// succeeded.
+	Success(repo string)
+	// Failure informs the breaker that a backup to the repository failed
+	// because the repository is broken.
+	Failure(repo string)
+	// State returns the state of the repository's circuit.
+	State(repo string) CircuitState
+}
+
+type circuitBreaker struct {
+	lock         sync.Mutex
+	threshold    int
+	openDuration time.Duration
+	clock        clock.Clock
+	circuits     map[string]*circuit
+}
+
+// circuit is a repository's failure count and, if its circuit isn't
+// closed, when it opened and when the last backup was let through to try
+// the repository again.
+type circuit struct {
+	failures int
+	openedAt time.Time
+	trialAt  time.Time
+}
+
+// NewCircuitBreaker returns a new CircuitBreaker that opens a repository's
+// circuit after threshold consecutive failures, and half-opens it after
+// openDuration. A half-open circuit lets a backup through to try the
+// repository again at most once every openDuration.
+func NewCircuitBreaker(threshold int, openDuration time.Duration) CircuitBreaker {
+	return &circuitBreaker{
+		threshold:    threshold,
+		openDuration: openDuration,
+		clock:        clock.RealClock{},
+		circuits:     make(map[string]*circuit),
+	}
+}
+
+func (cb *circuitBreaker) Allow(repo string) (bool, time.Time) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	c, ok := cb.circuits[repo]
+	switch cb.state(c, ok) {
+	case CircuitClosed:
+		return true, time.Time{}
+	case CircuitOpen:
+		return false, c.openedAt.Add(cb.openDuration)
+	}
+
+	// half-open: let a single backup through to try the repository, and
+	// another if its result isn't known once the open duration has passed
+	// again, e.g. because it was canceled.
+	now := cb.clock.Now()
+	if !c.trialAt.IsZero() && now.Before(c.trialAt.Add(cb.openDuration)) {
+		return false, c.trialAt.Add(cb.openDuration)
+	}
+	c.trialAt = now
+	return true, time.Time{}
+}
+
+func (cb *circuitBreaker) Success(repo string) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	delete(cb.circuits, repo)
+}
+
+func (cb *circuitBreaker) Failure(repo string) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	c, ok := cb.circuits[repo]
+	if !ok {
+		c = new(circuit)
+		cb.circuits[repo] = c
+	}
+
+	c.failures++
+	if c.failures >= cb.threshold || !c.openedAt.IsZero() {
+		c.openedAt = cb.clock.Now()
+		c.trialAt = time.Time{}
+	}
+}
+
+func (cb *circuitBreaker) State(repo string) CircuitState {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	c, ok := cb.circuits[repo]
+	return cb.state(c, ok)
+}
+
+// state returns the state of a repository's circuit, if it has one. It must
+// be called with the lock held.
+func (cb *circuitBreaker) state(c *circuit, ok bool) CircuitState {
+	switch {
+	case !ok || c.openedAt.IsZero():
+		return CircuitClosed
+	case cb.clock.Since(c.openedAt) < cb.openDuration:
+		return CircuitOpen
+	default:
+		return CircuitHalfOpen
+	}
+}
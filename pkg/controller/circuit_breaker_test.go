@@ -0,0 +1,145 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func newTestCircuitBreaker(threshold int, openDuration time.Duration) (*circuitBreaker, *clock.FakeClock) {
+	fakeClock := clock.NewFakeClock(time.Now())
+
+	cb := NewCircuitBreaker(threshold, openDuration).(*circuitBreaker)
+	cb.clock = fakeClock
+
+	return cb, fakeClock
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	cb, fakeClock := newTestCircuitBreaker(3, time.Minute)
+
+	allowed, _ := cb.Allow("repo-1")
+	assert.True(t, allowed)
+
+	cb.Failure("repo-1")
+	cb.Failure("repo-1")
+	assert.Equal(t, CircuitClosed, cb.State("repo-1"))
+	allowed, _ = cb.Allow("repo-1")
+	assert.True(t, allowed)
+
+	// repositories are counted separately
+	cb.Failure("repo-2")
+	assert.Equal(t, CircuitClosed, cb.State("repo-2"))
+
+	cb.Failure("repo-1")
+	assert.Equal(t, CircuitOpen, cb.State("repo-1"))
+	allowed, retryAt := cb.Allow("repo-1")
+	assert.False(t, allowed)
+	assert.Equal(t, fakeClock.Now().Add(time.Minute), retryAt)
+
+	fakeClock.Step(30 * time.Second)
+	allowed, _ = cb.Allow("repo-1")
+	assert.False(t, allowed)
+
+	allowed, _ = cb.Allow("repo-2")
+	assert.True(t, allowed)
+}
+
+func TestCircuitBreakerSuccessResetsFailures(t *testing.T) {
+	cb, _ := newTestCircuitBreaker(2, time.Minute)
+
+	cb.Failure("repo-1")
+	cb.Success("repo-1")
+	cb.Failure("repo-1")
+	assert.Equal(t, CircuitClosed, cb.State("repo-1"))
+
+	cb.Failure("repo-1")
+	assert.Equal(t, CircuitOpen, cb.State("repo-1"))
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	cb, fakeClock := newTestCircuitBreaker(1, time.Minute)
+
+	cb.Failure("repo-1")
+	assert.Equal(t, CircuitOpen, cb.State("repo-1"))
+
+	fakeClock.Step(time.Minute)
+	assert.Equal(t, CircuitHalfOpen, cb.State("repo-1"))
+
+	// a single backup is let through to try the repository
+	allowed, _ := cb.Allow("repo-1")
+	assert.True(t, allowed)
+	allowed, retryAt := cb.Allow("repo-1")
+	assert.False(t, allowed)
+	assert.Equal(t, fakeClock.Now().Add(time.Minute), retryAt)
+
+	// if its result isn't known within the open duration, another is
+	fakeClock.Step(time.Minute)
+	allowed, _ = cb.Allow("repo-1")
+	assert.True(t, allowed)
+	allowed, _ = cb.Allow("repo-1")
+	assert.False(t, allowed)
+}
+
+func TestCircuitBreakerHalfOpenFailureReopens(t *testing.T) {
+	cb, fakeClock := newTestCircuitBreaker(3, time.Minute)
+
+	cb.Failure("repo-1")
+	cb.Failure("repo-1")
+	cb.Failure("repo-1")
+
+	fakeClock.Step(time.Minute)
+	allowed, _ := cb.Allow("repo-1")
+	assert.True(t, allowed)
+
+	// a failed trial reopens the circuit without reaching the threshold
+	// again
+	cb.Failure("repo-1")
+	assert.Equal(t, CircuitOpen, cb.State("repo-1"))
+	allowed, retryAt := cb.Allow("repo-1")
+	assert.False(t, allowed)
+	assert.Equal(t, fakeClock.Now().Add(time.Minute), retryAt)
+
+	fakeClock.Step(time.Minute)
+	assert.Equal(t, CircuitHalfOpen, cb.State("repo-1"))
+	allowed, _ = cb.Allow("repo-1")
+	assert.True(t, allowed)
+}
+
+func TestCircuitBreakerHalfOpenSuccessCloses(t *testing.T) {
+	cb, fakeClock := newTestCircuitBreaker(1, time.Minute)
+
+	cb.Failure("repo-1")
+	fakeClock.Step(time.Minute)
+	allowed, _ := cb.Allow("repo-1")
+	assert.True(t, allowed)
+
+	cb.Success("repo-1")
+	assert.Equal(t, CircuitClosed, cb.State("repo-1"))
+	for i := 0; i < 3; i++ {
+		allowed, _ = cb.Allow("repo-1")
+		assert.True(t, allowed)
+	}
+
+	// the failures are counted from zero again
+	cb.Failure("repo-1")
+	assert.Equal(t, CircuitOpen, cb.State("repo-1"))
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// FailureTracker counts failures that happened within a recent window
+// of time.
+type FailureTracker interface {
+	// Add informs the tracker that a failure happened.
+	Add()
+	// Count returns the number of failures within the window.
+	Count() int
+}
+
+type failureTracker struct {
+	lock     sync.Mutex
+	window   time.Duration
+	clock    clock.Clock
+	failures []time.Time
+}
+
+// NewFailureTracker returns a new FailureTracker that counts failures
+// within the given window.
+func NewFailureTracker(window time.Duration) FailureTracker {
+	return &failureTracker{
+		window: window,
+		clock:  clock.RealClock{},
+	}
+}
+
+func (ft *failureTracker) Add() {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	ft.prune()
+	ft.failures = append(ft.failures, ft.clock.Now())
+}
+
+func (ft *failureTracker) Count() int {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	ft.prune()
+	return len(ft.failures)
+}
+
+// prune forgets failures that are older than the window. It must be
+// called with the lock held.
+func (ft *failureTracker) prune() {
+	cutoff := ft.clock.Now().Add(-ft.window)
+
+	i := 0
+	for i < len(ft.failures) && !ft.failures[i].After(cutoff) {
+		i++
+	}
+	ft.failures = ft.failures[i:]
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestFailureTracker(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+
+	ft := NewFailureTracker(time.Hour).(*failureTracker)
+	ft.clock = fakeClock
+
+	assert.Equal(t, 0, ft.Count())
+
+	ft.Add()
+	fakeClock.Step(30 * time.Minute)
+	ft.Add()
+	ft.Add()
+	assert.Equal(t, 3, ft.Count())
+
+	// the first failure falls out of the window
+	fakeClock.Step(30 * time.Minute)
+	assert.Equal(t, 2, ft.Count())
+
+	fakeClock.Step(30 * time.Minute)
+	assert.Equal(t, 0, ft.Count())
+
+	ft.Add()
+	assert.Equal(t, 1, ft.Count())
+}
@@ -51,7 +51,9 @@ func newGenericController(name string, logger logrus.FieldLogger) *genericContro
 
 // Run is a blocking function that runs the specified number of worker goroutines
 // to process items in the work queue. It will return when it receives on the
-// ctx.Done() channel.
+// ctx.Done() channel. The work queue never hands the same key to more than one
+// worker at a time: a key that's re-added while it's being processed is only
+// processed again once its current sync has finished.
 func (c *genericController) Run(ctx context.Context, numWorkers int) error {
 	if c.syncHandler == nil {
 		// programmer error
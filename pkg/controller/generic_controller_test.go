@@ -0,0 +1,141 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	arktest "github.com/heptio/ark/pkg/util/test"
+)
+
+func TestGenericControllerRunWorkers(t *testing.T) {
+	tests := []struct {
+		name       string
+		numWorkers int
+	}{
+		{
+			name:       "single worker",
+			numWorkers: 1,
+		},
+		{
+			name:       "multiple workers",
+			numWorkers: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newGenericController("test", arktest.NewLogger())
+
+			var (
+				lock       sync.Mutex
+				active     = make(map[string]bool)
+				maxActive  int
+				processed  int
+				duplicates []string
+				started    = make(chan struct{}, 10)
+				release    = make(chan struct{})
+			)
+
+			c.syncHandler = func(key string) error {
+				lock.Lock()
+				if active[key] {
+					duplicates = append(duplicates, key)
+				}
+				active[key] = true
+				if len(active) > maxActive {
+					maxActive = len(active)
+				}
+				lock.Unlock()
+
+				started <- struct{}{}
+				<-release
+
+				lock.Lock()
+				delete(active, key)
+				processed++
+				lock.Unlock()
+
+				return nil
+			}
+
+			for i := 0; i < 5; i++ {
+				c.queue.Add(fmt.Sprintf("ns/key-%d", i))
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				c.Run(ctx, test.numWorkers)
+				close(done)
+			}()
+
+			// wait for every worker to pick up a key
+			for i := 0; i < test.numWorkers; i++ {
+				select {
+				case <-started:
+				case <-time.After(5 * time.Second):
+					require.FailNow(t, "timed out waiting for workers to start")
+				}
+			}
+
+			// re-adding keys that are being processed must not hand them
+			// to another worker
+			for i := 0; i < 5; i++ {
+				c.queue.Add(fmt.Sprintf("ns/key-%d", i))
+			}
+
+			// give any extra workers the chance to pick up a key
+			time.Sleep(100 * time.Millisecond)
+
+			lock.Lock()
+			assert.Equal(t, test.numWorkers, len(active))
+			lock.Unlock()
+
+			close(release)
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				lock.Lock()
+				n := processed
+				lock.Unlock()
+
+				// every key, plus each key that was re-added while it was being
+				// processed, is processed once
+				if n == 5+test.numWorkers {
+					break
+				}
+				if time.Now().After(deadline) {
+					require.FailNow(t, "timed out waiting for keys to be processed")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			cancel()
+			<-done
+
+			assert.Equal(t, test.numWorkers, maxActive)
+			assert.Empty(t, duplicates)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import "sync"
+
+// inFlightBytes accounts for the total size of the volumes that are being
+// backed up on a node at any given time, so that new backups can be
+// deferred while it exceeds a budget. Backups that are already running are
+// never stopped, so the total may go over the budget by the size of the
+// volumes admitted last.
+type inFlightBytes struct {
+	lock  sync.Mutex
+	max   int64
+	bytes int64
+
+	// observer, if set, is called with the total whenever it changes.
+	observer func(bytes int64)
+}
+
+// newInFlightBytes returns an inFlightBytes with a budget of max bytes.
+func newInFlightBytes(max int64) *inFlightBytes {
+	return &inFlightBytes{max: max}
+}
+
+// Add adds the size of a volume that's starting to be backed up to the
+// total.
+func (b *inFlightBytes) Add(size int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.bytes += size
+	b.observe()
+}
+
+// Release subtracts the size of a volume that's no longer being backed up
+// from the total.
+func (b *inFlightBytes) Release(size int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.bytes -= size
+	b.observe()
+}
+
+// Exceeded returns the total, and true if it's at least the budget, in
+// which case new backups should be deferred.
+func (b *inFlightBytes) Exceeded() (int64, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.bytes, b.bytes >= b.max
+}
+
+// observe calls the observer, if any, with the total. It must be called
+// with the lock held.
+func (b *inFlightBytes) observe() {
+	if b.observer != nil {
+		b.observer(b.bytes)
+	}
+}
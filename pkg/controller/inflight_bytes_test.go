@@ -0,0 +1,60 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInFlightBytes(t *testing.T) {
+	b := newInFlightBytes(100)
+
+	var observed []int64
+	b.observer = func(bytes int64) {
+		observed = append(observed, bytes)
+	}
+
+	bytes, exceeded := b.Exceeded()
+	assert.Equal(t, int64(0), bytes)
+	assert.False(t, exceeded)
+
+	b.Add(60)
+	bytes, exceeded = b.Exceeded()
+	assert.Equal(t, int64(60), bytes)
+	assert.False(t, exceeded)
+
+	// a backup admitted below the budget may take the total over it
+	b.Add(70)
+	bytes, exceeded = b.Exceeded()
+	assert.Equal(t, int64(130), bytes)
+	assert.True(t, exceeded)
+
+	b.Release(30)
+	bytes, exceeded = b.Exceeded()
+	assert.Equal(t, int64(100), bytes)
+	assert.True(t, exceeded)
+
+	b.Release(60)
+	b.Release(40)
+	bytes, exceeded = b.Exceeded()
+	assert.Equal(t, int64(0), bytes)
+	assert.False(t, exceeded)
+
+	assert.Equal(t, []int64{60, 130, 100, 40, 0}, observed)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	corev1api "k8s.io/api/core/v1"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/restic"
+	"github.com/heptio/ark/pkg/util/kube"
+)
+
+// passwordChanges records when the repository passwords held by restic
+// credentials secrets last changed, so that backups that then fail to
+// authenticate can be reported as failing because the password was
+// rotated without the repository's keys being updated.
+type passwordChanges struct {
+	mu      sync.Mutex
+	changed map[string]time.Time
+}
+
+func newPasswordChanges() *passwordChanges {
+	return &passwordChanges{changed: make(map[string]time.Time)}
+}
+
+// Record records that the password in the named secret changed at the
+// given time.
+func (p *passwordChanges) Record(namespace, name string, at time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.changed[namespace+"/"+name] = at
+}
+
+// ChangedSince returns the time the password in the named secret last
+// changed, and true if that's after since.
+func (p *passwordChanges) ChangedSince(namespace, name string, since time.Time) (time.Time, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	at, ok := p.changed[namespace+"/"+name]
+	if !ok || !at.After(since) {
+		return time.Time{}, false
+	}
+
+	return at, true
+}
+
+// recordPasswordChange records when the repository password held by a
+// secret changes, so that backups that then fail to authenticate can be
+// reported as failing because of it.
+func (c *podVolumeBackupController) recordPasswordChange(oldObj, newObj interface{}) {
+	oldSecret, ok := oldObj.(*corev1api.Secret)
+	if !ok {
+		return
+	}
+	newSecret, ok := newObj.(*corev1api.Secret)
+	if !ok {
+		return
+	}
+
+	if bytes.Equal(oldSecret.Data[restic.CredentialsKey], newSecret.Data[restic.CredentialsKey]) {
+		return
+	}
+
+	c.logger.WithField("secret", kube.NamespaceAndName(newSecret)).Info("Restic repository password changed")
+	c.passwordChanges.Record(newSecret.Namespace, newSecret.Name, c.clock.Now())
+}
+
+// passwordRotation returns the namespace and name of a PodVolumeBackup's
+// credentials secret and when its password changed, and true, if it
+// changed within the password rotation window.
+func (c *podVolumeBackupController) passwordRotation(req *arkv1api.PodVolumeBackup) (string, string, time.Time, bool) {
+	if c.rotationWindow <= 0 {
+		return "", "", time.Time{}, false
+	}
+
+	namespace, name, ok := c.credentialsSecret(req)
+	if !ok {
+		return "", "", time.Time{}, false
+	}
+
+	changedAt, ok := c.passwordChanges.ChangedSince(namespace, name, c.clock.Now().Add(-c.rotationWindow))
+	if !ok {
+		return "", "", time.Time{}, false
+	}
+
+	return namespace, name, changedAt, true
+}
+
+// passwordRotationFailure returns the failure reason and message of a
+// backup that failed with reason because the repository rejected its
+// password. A wrong password soon after the credentials secret's password
+// changed most likely means the repository's keys weren't updated, so it's
+// reported as PasswordRotated.
+func (c *podVolumeBackupController) passwordRotationFailure(req *arkv1api.PodVolumeBackup, reason arkv1api.PodVolumeBackupFailureReason, msg string, log logrus.FieldLogger) (arkv1api.PodVolumeBackupFailureReason, string) {
+	namespace, name, changedAt, ok := c.passwordRotation(req)
+	if !ok {
+		return reason, msg
+	}
+
+	log.Warnf("Restic repository rejected the password from secret %s/%s, which changed at %s", namespace, name, changedAt.UTC().Format(time.RFC3339))
+	return arkv1api.PodVolumeBackupFailureReasonPasswordRotated, fmt.Sprintf("password rotated: the repository password in secret %s/%s changed at %s, but the restic repository doesn't accept it: %s", namespace, name, changedAt.UTC().Format(time.RFC3339), msg)
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordChanges(t *testing.T) {
+	var (
+		changes = newPasswordChanges()
+		now     = time.Now()
+	)
+
+	_, changed := changes.ChangedSince("ns-1", "ark-restic-credentials", now.Add(-time.Hour))
+	assert.False(t, changed)
+
+	changes.Record("ns-1", "ark-restic-credentials", now.Add(-time.Minute))
+
+	at, changed := changes.ChangedSince("ns-1", "ark-restic-credentials", now.Add(-time.Hour))
+	assert.True(t, changed)
+	assert.Equal(t, now.Add(-time.Minute), at)
+
+	// changes before the given time aren't reported.
+	_, changed = changes.ChangedSince("ns-1", "ark-restic-credentials", now.Add(-time.Second))
+	assert.False(t, changed)
+
+	// secrets are tracked separately.
+	_, changed = changes.ChangedSince("ns-2", "ark-restic-credentials", now.Add(-time.Hour))
+	assert.False(t, changed)
+	_, changed = changes.ChangedSince("ns-1", "offsite", now.Add(-time.Hour))
+	assert.False(t, changed)
+}
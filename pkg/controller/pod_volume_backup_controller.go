@@ -18,29 +18,172 @@ package controller
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
 
+	corev1api "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
 	corev1informers "k8s.io/client-go/informers/core/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	corev1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
 
 	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
 	arkv1client "github.com/heptio/ark/pkg/generated/clientset/versioned/typed/ark/v1"
 	informers "github.com/heptio/ark/pkg/generated/informers/externalversions/ark/v1"
 	listers "github.com/heptio/ark/pkg/generated/listers/ark/v1"
+	"github.com/heptio/ark/pkg/metrics"
 	"github.com/heptio/ark/pkg/restic"
+	"github.com/heptio/ark/pkg/util/filesystem"
 	"github.com/heptio/ark/pkg/util/kube"
+	"github.com/heptio/ark/pkg/util/stringslice"
+)
+
+const (
+	// backupProgressUpdateInterval is the minimum amount of time between
+	// progress updates to a PodVolumeBackup's status.
+	backupProgressUpdateInterval = 10 * time.Second
+
+	// orphanedBackupCheckPeriod is how often to check for PodVolumeBackups
+	// whose node no longer exists.
+	orphanedBackupCheckPeriod = time.Minute
+
+	// defaultBackupRetryDelay is the amount of time to wait before the first
+	// retry of a restic backup that failed with a transient error. The delay
+	// doubles for each subsequent retry.
+	defaultBackupRetryDelay = 5 * time.Second
+
+	// defaultRepoInitRetryDelay is the amount of time to wait before the
+	// first retry of a restic repository initialization that failed with a
+	// transient error. The delay doubles for each subsequent retry, and up to
+	// the same again is added at random.
+	defaultRepoInitRetryDelay = 2 * time.Second
+
+	// maxRepoInitAttempts is the number of times a restic repository
+	// initialization that fails with a transient error is attempted.
+	maxRepoInitAttempts = 5
+
+	// defaultMountPollInterval is how often to check whether a volume that
+	// isn't mounted yet has been.
+	defaultMountPollInterval = time.Second
+
+	// defaultSnapshotPollInterval is how often to check whether a snapshot
+	// that was just taken, but isn't listed by restic yet, has been.
+	defaultSnapshotPollInterval = 2 * time.Second
+
+	// defaultSnapshotIDTimeout is how long to wait for restic to list a
+	// repository's snapshots when looking up the ID of a volume's snapshot.
+	defaultSnapshotIDTimeout = 2 * time.Minute
+
+	// MaxStaleBackupRestarts is the number of times a PodVolumeBackup that
+	// was interrupted while InProgress is reset to New before it's failed.
+	MaxStaleBackupRestarts = 3
+
+	// reasons for the events recorded on PodVolumeBackups as they
+	// change phase.
+	eventReasonBackupStarted   = "BackupStarted"
+	eventReasonBackupCompleted = "BackupCompleted"
+	eventReasonBackupFailed    = "BackupFailed"
+	eventReasonBackupRestarted = "BackupRestarted"
+
+	eventReasonBackupVerificationFailed = "BackupVerificationFailed"
+	eventReasonBackupMirrorFailed       = "BackupMirrorFailed"
+	eventReasonPostBackupHookFailed     = "PostBackupHookFailed"
+	eventReasonIncompleteSnapshot       = "IncompleteSnapshot"
+
+	// forgetSnapshotsFinalizer is added to PodVolumeBackups when they're
+	// started if the snapshot deletion policy is forget, so that their
+	// snapshots are forgotten before they're deleted.
+	forgetSnapshotsFinalizer = "restic.ark.heptio.com/forget-snapshots"
+
+	// immutableStorageMessage explains why restic commands that delete data
+	// fail on a repository whose storage is immutable.
+	immutableStorageMessage = "the restic repository's storage is immutable, e.g. an S3 bucket with Object Lock, so data can't be removed from it until its retention period has passed"
+
+	// backupLogsConfigMapSuffix is appended to a PodVolumeBackup's name to
+	// name the ConfigMap its restic output is stored in.
+	backupLogsConfigMapSuffix = "-restic-logs"
+)
+
+// VerificationFailurePolicy determines what happens to a PodVolumeBackup
+// whose repository fails its post-backup integrity check.
+type VerificationFailurePolicy string
+
+const (
+	// VerificationFailurePolicyWarn completes the backup, recording the
+	// failed verification in its status.
+	VerificationFailurePolicyWarn VerificationFailurePolicy = "warn"
+
+	// VerificationFailurePolicyFail fails the backup.
+	VerificationFailurePolicyFail VerificationFailurePolicy = "fail"
+)
+
+// PostBackupHookFailurePolicy determines what happens to a PodVolumeBackup
+// whose post-backup hook command fails.
+type PostBackupHookFailurePolicy string
+
+const (
+	// PostBackupHookFailurePolicyWarn completes the backup, logging the
+	// failure and recording it in an event.
+	PostBackupHookFailurePolicyWarn PostBackupHookFailurePolicy = "warn"
+
+	// PostBackupHookFailurePolicyFail fails the backup.
+	PostBackupHookFailurePolicyFail PostBackupHookFailurePolicy = "fail"
+)
+
+// IncompleteSnapshotPolicy determines what happens to a PodVolumeBackup
+// whose restic backup exits with code 3 because restic couldn't read some
+// of the volume's files.
+type IncompleteSnapshotPolicy string
+
+const (
+	// IncompleteSnapshotPolicyWarn completes the backup with the incomplete
+	// snapshot, marking it incomplete in its status and recording an
+	// event.
+	IncompleteSnapshotPolicyWarn IncompleteSnapshotPolicy = "warn"
+
+	// IncompleteSnapshotPolicyFail fails the backup.
+	IncompleteSnapshotPolicyFail IncompleteSnapshotPolicy = "fail"
+)
+
+// SnapshotDeletionPolicy determines what happens to the restic snapshots of
+// a PodVolumeBackup when it's deleted.
+type SnapshotDeletionPolicy string
+
+const (
+	// SnapshotDeletionPolicyRetain leaves the snapshots in the repository.
+	SnapshotDeletionPolicyRetain SnapshotDeletionPolicy = "retain"
+
+	// SnapshotDeletionPolicyForget forgets the snapshots, using a finalizer
+	// on the PodVolumeBackup, before it's removed. Their data is removed
+	// from the repository when it's next pruned.
+	SnapshotDeletionPolicyForget SnapshotDeletionPolicy = "forget"
 )
 
 type podVolumeBackupController struct {
@@ -48,252 +191,3262 @@ type podVolumeBackupController struct {
 
 	podVolumeBackupClient arkv1client.PodVolumeBackupsGetter
 	podVolumeBackupLister listers.PodVolumeBackupLister
+	backupLister          listers.BackupLister
 	secretLister          corev1listers.SecretLister
+	credentialsFiles      *restic.CredentialsFileCache
 	podLister             corev1listers.PodLister
 	pvcLister             corev1listers.PersistentVolumeClaimLister
+	pvLister              corev1listers.PersistentVolumeLister
+	nodeLister            corev1listers.NodeLister
 	nodeName              string
+	hostPodsPath          string
+	resticBinary          string
+	resticGlobalFlags     []string
+	resticEnv             []string
+	resticCacheDir        string
+	resticCacheEnabled    bool
+	resticSharedCache     bool
+	retention             restic.KeepPolicy
+	backendOptions        restic.BackendOptions
+	resticLimitUpload     int
+	resticOneFileSystem   bool
+	resticBackupIOClass   string
+	dryRun                bool
+	shutdownGracePeriod   time.Duration
+	unlockStaleLocks      bool
+	maxVolumeSize         int64
+	verifyReadDataPercent int
+	verificationPolicy    VerificationFailurePolicy
+	verifyInterval        time.Duration
+	maxVerifications      int
+	orphanGCInterval      time.Duration
+	orphanGracePeriod     time.Duration
+	forgetOrphans         bool
+	repoInitPrefix        string
+	maxConcurrentInits    int
+	resticCompression     string
+	resticPackSize        int
+	resticReadConcurrency int
+	resticHost            string
+	patchLimiter          *rate.Limiter
+	queueLimiter          *rate.Limiter
+	skipImmutableErrors   bool
+	volumeMountTimeout    time.Duration
+	resticPasswordFile    string
+	resticPasswordCommand string
+	pruneTrigger          PruneTrigger
+	repoLeaser            *restic.RepoLeaser
+	hostRootPath          string
+	hostPathAllowList     []string
+	skipUnchangedVolumes  bool
+	skipEmptyVolumes      bool
+	postBackupHook        string
+	postBackupHookTimeout time.Duration
+	hookFailurePolicy     PostBackupHookFailurePolicy
+	featureGates          restic.FeatureGates
+	repoStatsInterval     time.Duration
+	deletionPolicy        SnapshotDeletionPolicy
+	pressureConditions    []corev1api.NodeConditionType
+	pressureRetryDelay    time.Duration
+	staleBackupThreshold  time.Duration
+	backupTimeout         time.Duration
+	maintenanceTimeout    time.Duration
+	maxConcurrentBackups  int
+	backupSemaphore       *semaphore.Weighted
+	maxBackupAttempts     int
+	backupRetryDelay      time.Duration
+	repoInitRetryDelay    time.Duration
+	mountPollInterval     time.Duration
+	snapshotWaitTimeout   time.Duration
+	circuitBreaker        CircuitBreaker
+	resticRunAs           *restic.RunAs
+	inFlightBytes         *inFlightBytes
+	incompletePolicy      IncompleteSnapshotPolicy
+	configMaps            corev1client.ConfigMapsGetter
+	backupLogsMaxSize     int64
+	passwordChanges       *passwordChanges
+	rotationWindow        time.Duration
+	snapshotPollInterval  time.Duration
+	snapshotIDTimeout     time.Duration
+	clock                 clock.Clock
+	startTime             time.Time
+	fileSystem            filesystem.Interface
+	metrics               *metrics.ServerMetrics
+	eventRecorder         kube.EventRecorder
+	failureTracker        FailureTracker
+
+	// runCtx is the context the controller is run with, which is done once
+	// it's told to stop. It's Background until Run is called.
+	runCtx context.Context
 
-	processBackupFunc func(*arkv1api.PodVolumeBackup) error
+	// runningBackups holds the state, including a function to cancel it,
+	// of each PodVolumeBackup currently being processed, keyed by
+	// namespace/name.
+	runningBackups     map[string]*runningBackup
+	runningBackupsLock sync.Mutex
+
+	// shuttingDown is set once the controller has been told to stop, after
+	// which no new backups are started, and abortingBackups once the
+	// shutdown grace period has expired and running backups are being
+	// killed. Both are guarded by runningBackupsLock.
+	shuttingDown    bool
+	abortingBackups bool
+	inFlightBackups sync.WaitGroup
+
+	processBackupFunc       func(context.Context, *arkv1api.PodVolumeBackup) error
+	validateFunc            func(*arkv1api.PodVolumeBackup) []string
+	runCommandFunc          func(*exec.Cmd) (string, string, error)
+	getSnapshotIDFunc       func(context.Context, *restic.Command) (string, error)
+	getLatestSnapshotIDFunc func(context.Context, *restic.Command) (string, error)
+	listSnapshotsFunc       func(*restic.Command) ([]restic.Snapshot, error)
+	getSnapshotStatsFunc    func(context.Context, *restic.Command) (restic.SnapshotStats, error)
+	repositoryExistsFunc    func(context.Context, *restic.Command) (bool, error)
+	unlockRepoFunc          func(*restic.Command) error
+	verifyRepoFunc          func(context.Context, *restic.Command) error
+	initRepoFunc            func(context.Context, *restic.Command) error
+	pruneRepoFunc           func(context.Context, *restic.Command) error
+	getRepoStatsFunc        func(context.Context, *restic.Command) (restic.RepoStats, error)
+	forgetByPolicyFunc      func(context.Context, *restic.Command) (int, error)
+	forgetSnapshotFunc      func(context.Context, *restic.Command) error
+	checkAccessFunc         func(path string) error
+	evalSymlinksFunc        func(path string) (string, error)
+	resticVersionFunc       func(context.Context) (string, error)
+	runHookFunc             func(*exec.Cmd) (string, string, error)
+	jitterFunc              func() float64
+}
+
+// PodVolumeBackupControllerConfig holds the dependencies and settings of a
+// pod volume backup controller. The settings mostly correspond to the
+// restic server's flags.
+type PodVolumeBackupControllerConfig struct {
+	Logger                  logrus.FieldLogger
+	PodVolumeBackupInformer informers.PodVolumeBackupInformer
+	PodVolumeBackupClient   arkv1client.PodVolumeBackupsGetter
+	BackupInformer          informers.BackupInformer
+	PodInformer             cache.SharedIndexInformer
+	SecretInformer          corev1informers.SecretInformer
+	PVCInformer             corev1informers.PersistentVolumeClaimInformer
+	PVInformer              corev1informers.PersistentVolumeInformer
+	NodeInformer            corev1informers.NodeInformer
+	ConfigMaps              corev1client.ConfigMapsGetter
+	Metrics                 *metrics.ServerMetrics
+	EventRecorder           kube.EventRecorder
+	FailureTracker          FailureTracker
+	CircuitBreaker          CircuitBreaker
+	RepoLeaser              *restic.RepoLeaser
+	PruneTrigger            PruneTrigger
+	PatchLimiter            *rate.Limiter
+	QueueLimiter            *rate.Limiter
+
+	NodeName              string
+	MaxConcurrentBackups  int
+	MaxBackupAttempts     int
+	HostPodsPath          string
+	BackupTimeout         time.Duration
+	MaintenanceTimeout    time.Duration
+	ResticBinary          string
+	ResticGlobalFlags     []string
+	ResticCacheDir        string
+	ResticCacheEnabled    bool
+	ResticLimitUpload     int
+	ResticBackupIOClass   string
+	DryRun                bool
+	ShutdownGracePeriod   time.Duration
+	UnlockStaleLocks      bool
+	MaxVolumeSize         int64
+	VerifyReadDataPercent int
+	VerificationPolicy    VerificationFailurePolicy
+	RepoInitPrefix        string
+	MaxConcurrentInits    int
+	ResticCompression     string
+	ResticPackSize        int
+	VolumeMountTimeout    time.Duration
+	ResticPasswordFile    string
+	ResticPasswordCommand string
+	HostRootPath          string
+	HostPathAllowList     []string
+	SkipUnchangedVolumes  bool
+	ResticOneFileSystem   bool
+	RepoStatsInterval     time.Duration
+	ResticEnv             []string
+	DeletionPolicy        SnapshotDeletionPolicy
+	PressureConditions    []corev1api.NodeConditionType
+	PressureRetryDelay    time.Duration
+	ResticReadConcurrency int
+	StaleBackupThreshold  time.Duration
+	ResticHost            string
+	SkipImmutableErrors   bool
+	ResticTempDir         string
+	VerifyInterval        time.Duration
+	MaxVerifications      int
+	SkipEmptyVolumes      bool
+	OrphanGCInterval      time.Duration
+	OrphanGracePeriod     time.Duration
+	ForgetOrphans         bool
+	PostBackupHook        string
+	PostBackupHookTimeout time.Duration
+	HookFailurePolicy     PostBackupHookFailurePolicy
+	FeatureGates          restic.FeatureGates
+	SnapshotWaitTimeout   time.Duration
+	MaxQueueDepth         int
+	QueueDepthRetryDelay  time.Duration
+	ResticRunAs           *restic.RunAs
+	MaxInFlightBytes      int64
+	IncompletePolicy      IncompleteSnapshotPolicy
+	BackupLogsMaxSize     int64
+	RotationWindow        time.Duration
+	ResticSharedCache     bool
+	Retention             restic.KeepPolicy
+	BackendOptions        restic.BackendOptions
 }
 
 // NewPodVolumeBackupController creates a new pod volume backup controller.
-func NewPodVolumeBackupController(
-	logger logrus.FieldLogger,
-	podVolumeBackupInformer informers.PodVolumeBackupInformer,
-	podVolumeBackupClient arkv1client.PodVolumeBackupsGetter,
-	podInformer cache.SharedIndexInformer,
-	secretInformer corev1informers.SecretInformer,
-	pvcInformer corev1informers.PersistentVolumeClaimInformer,
-	nodeName string,
-) Interface {
+func NewPodVolumeBackupController(config PodVolumeBackupControllerConfig) Interface {
 	c := &podVolumeBackupController{
-		genericController:     newGenericController("pod-volume-backup", logger),
-		podVolumeBackupClient: podVolumeBackupClient,
-		podVolumeBackupLister: podVolumeBackupInformer.Lister(),
-		podLister:             corev1listers.NewPodLister(podInformer.GetIndexer()),
-		secretLister:          secretInformer.Lister(),
-		pvcLister:             pvcInformer.Lister(),
-		nodeName:              nodeName,
+		genericController:     newGenericController("pod-volume-backup", config.Logger),
+		podVolumeBackupClient: config.PodVolumeBackupClient,
+		podVolumeBackupLister: config.PodVolumeBackupInformer.Lister(),
+		backupLister:          config.BackupInformer.Lister(),
+		podLister:             corev1listers.NewPodLister(config.PodInformer.GetIndexer()),
+		secretLister:          config.SecretInformer.Lister(),
+		credentialsFiles:      newCredentialsFileCache(config.SecretInformer.Lister(), config.ResticTempDir, config.ResticRunAs),
+		pvcLister:             config.PVCInformer.Lister(),
+		pvLister:              config.PVInformer.Lister(),
+		nodeLister:            config.NodeInformer.Lister(),
+		nodeName:              config.NodeName,
+		hostPodsPath:          config.HostPodsPath,
+		resticBinary:          config.ResticBinary,
+		resticGlobalFlags:     config.ResticGlobalFlags,
+		resticCacheDir:        config.ResticCacheDir,
+		resticCacheEnabled:    config.ResticCacheEnabled,
+		resticSharedCache:     config.ResticSharedCache,
+		retention:             config.Retention,
+		backendOptions:        config.BackendOptions,
+		resticLimitUpload:     config.ResticLimitUpload,
+		resticBackupIOClass:   config.ResticBackupIOClass,
+		dryRun:                config.DryRun,
+		shutdownGracePeriod:   config.ShutdownGracePeriod,
+		unlockStaleLocks:      config.UnlockStaleLocks,
+		maxVolumeSize:         config.MaxVolumeSize,
+		verifyReadDataPercent: config.VerifyReadDataPercent,
+		verificationPolicy:    config.VerificationPolicy,
+		repoInitPrefix:        config.RepoInitPrefix,
+		maxConcurrentInits:    config.MaxConcurrentInits,
+		resticCompression:     config.ResticCompression,
+		resticPackSize:        config.ResticPackSize,
+		volumeMountTimeout:    config.VolumeMountTimeout,
+		resticPasswordFile:    config.ResticPasswordFile,
+		resticPasswordCommand: config.ResticPasswordCommand,
+		pruneTrigger:          config.PruneTrigger,
+		repoLeaser:            config.RepoLeaser,
+		skipEmptyVolumes:      config.SkipEmptyVolumes,
+		hostRootPath:          config.HostRootPath,
+		hostPathAllowList:     config.HostPathAllowList,
+		skipUnchangedVolumes:  config.SkipUnchangedVolumes,
+		resticOneFileSystem:   config.ResticOneFileSystem,
+		repoStatsInterval:     config.RepoStatsInterval,
+		resticEnv:             config.ResticEnv,
+		deletionPolicy:        config.DeletionPolicy,
+		pressureConditions:    config.PressureConditions,
+		pressureRetryDelay:    config.PressureRetryDelay,
+		resticReadConcurrency: config.ResticReadConcurrency,
+		staleBackupThreshold:  config.StaleBackupThreshold,
+		resticHost:            config.ResticHost,
+		patchLimiter:          config.PatchLimiter,
+		queueLimiter:          config.QueueLimiter,
+		skipImmutableErrors:   config.SkipImmutableErrors,
+		verifyInterval:        config.VerifyInterval,
+		maxVerifications:      config.MaxVerifications,
+		orphanGCInterval:      config.OrphanGCInterval,
+		orphanGracePeriod:     config.OrphanGracePeriod,
+		forgetOrphans:         config.ForgetOrphans,
+		postBackupHook:        config.PostBackupHook,
+		postBackupHookTimeout: config.PostBackupHookTimeout,
+		hookFailurePolicy:     config.HookFailurePolicy,
+		featureGates:          config.FeatureGates,
+		backupTimeout:         config.BackupTimeout,
+		maintenanceTimeout:    config.MaintenanceTimeout,
+		maxConcurrentBackups:  config.MaxConcurrentBackups,
+		backupSemaphore:       semaphore.NewWeighted(int64(config.MaxConcurrentBackups)),
+		maxBackupAttempts:     config.MaxBackupAttempts,
+		backupRetryDelay:      defaultBackupRetryDelay,
+		repoInitRetryDelay:    defaultRepoInitRetryDelay,
+		mountPollInterval:     defaultMountPollInterval,
+		snapshotWaitTimeout:   config.SnapshotWaitTimeout,
+		circuitBreaker:        config.CircuitBreaker,
+		resticRunAs:           config.ResticRunAs,
+		incompletePolicy:      config.IncompletePolicy,
+		configMaps:            config.ConfigMaps,
+		backupLogsMaxSize:     config.BackupLogsMaxSize,
+		passwordChanges:       newPasswordChanges(),
+		rotationWindow:        config.RotationWindow,
+		snapshotPollInterval:  defaultSnapshotPollInterval,
+		snapshotIDTimeout:     defaultSnapshotIDTimeout,
+		clock:                 &clock.RealClock{},
+		startTime:             time.Now(),
+		fileSystem:            filesystem.NewFileSystem(),
+		metrics:               config.Metrics,
+		eventRecorder:         config.EventRecorder,
+		failureTracker:        config.FailureTracker,
+		runCtx:                context.Background(),
+		runningBackups:        make(map[string]*runningBackup),
+	}
+
+	if c.patchLimiter == nil {
+		c.patchLimiter = rate.NewLimiter(rate.Inf, 0)
+	}
+	if c.queueLimiter == nil {
+		c.queueLimiter = rate.NewLimiter(rate.Inf, 0)
 	}
 
+	// apply backpressure: once the maximum queue depth of PodVolumeBackups
+	// are waiting, the lowest priority new ones are deferred rather than
+	// queued, so a large burst of backups is spread out over time without
+	// delaying the highest priority ones. Deferred backups have their own
+	// retry delay, rather than the node pressure one, since the queue
+	// drains as backups complete, which is usually much sooner than a node
+	// recovers from resource pressure.
+	queue := newPriorityQueue(c.backupPriority)
+	queue.clock = c.clock
+	queue.maxLen = config.MaxQueueDepth
+	queue.deferrable = c.isDeferrableBackup
+	queue.deferDelay = config.QueueDepthRetryDelay
+	queue.lenObserver = func(n int) {
+		c.metrics.SetPodVolumeBackupQueueDepth(c.nodeName, n)
+	}
+	queue.deferObserver = func(item interface{}, n int) {
+		c.logger.WithField("key", item).Infof("%d pod volume backups are queued on this node, the maximum, deferring lowest priority backup for %s", n, config.QueueDepthRetryDelay)
+	}
+	c.queue = queue
+
+	if config.MaxInFlightBytes > 0 {
+		c.inFlightBytes = newInFlightBytes(config.MaxInFlightBytes)
+		c.inFlightBytes.observer = func(bytes int64) {
+			c.metrics.SetPodVolumeBackupInFlightBytes(c.nodeName, bytes)
+		}
+	}
 	c.syncHandler = c.processQueueItem
 	c.cacheSyncWaiters = append(
 		c.cacheSyncWaiters,
-		podVolumeBackupInformer.Informer().HasSynced,
-		secretInformer.Informer().HasSynced,
-		podInformer.HasSynced,
-		pvcInformer.Informer().HasSynced,
+		config.PodVolumeBackupInformer.Informer().HasSynced,
+		config.SecretInformer.Informer().HasSynced,
+		config.PodInformer.HasSynced,
+		config.PVCInformer.Informer().HasSynced,
+		config.PVInformer.Informer().HasSynced,
+		config.NodeInformer.Informer().HasSynced,
+		config.BackupInformer.Informer().HasSynced,
 	)
+	c.resyncPeriod = orphanedBackupCheckPeriod
+	c.resyncFunc = c.failOrphanedBackups
 	c.processBackupFunc = c.processBackup
+	c.validateFunc = func(pvb *arkv1api.PodVolumeBackup) []string {
+		return restic.ValidatePodVolumeBackup(pvb, c.podLister)
+	}
+	c.runCommandFunc = runCommand
+	c.getSnapshotIDFunc = restic.GetSnapshotID
+	c.getLatestSnapshotIDFunc = restic.GetLatestSnapshotID
+	c.listSnapshotsFunc = restic.ListSnapshots
+	c.getSnapshotStatsFunc = restic.GetSnapshotStats
+	c.repositoryExistsFunc = restic.RepositoryExists
+	c.unlockRepoFunc = restic.UnlockRepo
+	c.verifyRepoFunc = restic.VerifyRepo
+	c.initRepoFunc = restic.InitRepo
+	c.pruneRepoFunc = restic.PruneRepo
+	c.getRepoStatsFunc = restic.GetRepoStats
+	c.forgetByPolicyFunc = restic.ForgetByPolicy
+	c.forgetSnapshotFunc = restic.ForgetSnapshot
+	c.checkAccessFunc = func(path string) error {
+		if err := checkDirReadable(path); err != nil {
+			return err
+		}
+		return checkDirReadableBy(path, c.resticRunAs)
+	}
+	c.evalSymlinksFunc = filepath.EvalSymlinks
+	c.runHookFunc = runCommand
+	c.jitterFunc = rand.Float64
+	c.resticVersionFunc = func(ctx context.Context) (string, error) {
+		return restic.GetVersion(ctx, c.resticBinary)
+	}
+
+	config.PodVolumeBackupInformer.Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.pvbHandler,
+			UpdateFunc: func(_, obj interface{}) { c.pvbHandler(obj) },
+			DeleteFunc: c.pvbDeleteHandler,
+		},
+	)
+
+	config.SecretInformer.Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(oldObj, obj interface{}) {
+				c.recordPasswordChange(oldObj, obj)
+				c.secretHandler(obj)
+			},
+			DeleteFunc: c.secretHandler,
+		},
+	)
 
-	podVolumeBackupInformer.Informer().AddEventHandler(
+	config.NodeInformer.Informer().AddEventHandler(
 		cache.ResourceEventHandlerFuncs{
-			AddFunc:    c.enqueue,
-			UpdateFunc: func(_, obj interface{}) { c.enqueue(obj) },
+			UpdateFunc: c.nodeHandler,
 		},
 	)
 
 	return c
 }
 
-func (c *podVolumeBackupController) processQueueItem(key string) error {
-	log := c.logger.WithField("key", key)
-	log.Debug("Running processItem")
+// Run runs the controller's workers until ctx is done. In-flight backups are
+// then given up to the shutdown grace period to finish before they're killed.
+// Any cached restic credentials files are removed once all workers have
+// finished.
+func (c *podVolumeBackupController) Run(ctx context.Context, numWorkers int) error {
+	defer c.credentialsFiles.Clear()
 
-	ns, name, err := cache.SplitMetaNamespaceKey(key)
-	if err != nil {
-		log.WithError(err).Error("error splitting queue key")
-		return nil
+	c.runCtx = ctx
+
+	go func() {
+		<-ctx.Done()
+		c.waitForInFlightBackups()
+	}()
+
+	if c.repoInitPrefix != "" {
+		go c.initRepositories(ctx)
 	}
 
-	req, err := c.podVolumeBackupLister.PodVolumeBackups(ns).Get(name)
-	if apierrors.IsNotFound(err) {
-		log.Debug("Unable to find PodVolumeBackup")
-		return nil
+	if c.repoStatsInterval > 0 {
+		go c.runRepositoryStats(ctx)
+	}
+
+	if c.verifyInterval > 0 {
+		go c.runSnapshotVerification(ctx)
+	}
+
+	if c.orphanGCInterval > 0 {
+		go c.runOrphanedBackupGC(ctx)
 	}
+
+	return c.genericController.Run(ctx, numWorkers)
+}
+
+// initRepositories initializes the restic repositories, under repoInitPrefix,
+// of the namespaces of the pods on this node, so that the first backups of
+// pod volumes in those namespaces don't have to wait for them to be
+// initialized. Up to maxConcurrentInits repositories are initialized at once.
+// Repositories that already exist, and those of namespaces without restic
+// credentials, are skipped.
+func (c *podVolumeBackupController) initRepositories(ctx context.Context) {
+	if !cache.WaitForCacheSync(ctx.Done(), c.cacheSyncWaiters...) {
+		return
+	}
+
+	// the pod lister only contains pods on this node.
+	pods, err := c.podLister.List(labels.Everything())
 	if err != nil {
-		return errors.Wrap(err, "error getting PodVolumeBackup")
+		c.logger.WithError(err).Error("Error listing pods to initialize restic repositories")
+		return
 	}
 
-	// only process new items
-	switch req.Status.Phase {
-	case "", arkv1api.PodVolumeBackupPhaseNew:
-	default:
-		return nil
+	namespaces := sets.NewString()
+	for _, pod := range pods {
+		namespaces.Insert(pod.Namespace)
 	}
 
-	// only process items for this node
-	if req.Spec.Node != c.nodeName {
-		return nil
+	var (
+		wg    sync.WaitGroup
+		inits = semaphore.NewWeighted(int64(c.maxConcurrentInits))
+	)
+	for _, namespace := range namespaces.List() {
+		log := c.logger.WithFields(logrus.Fields{
+			"repoPrefix": c.repoInitPrefix,
+			"namespace":  namespace,
+		})
+
+		if !c.externalPasswordSource() {
+			if _, err := c.secretLister.Secrets(namespace).Get(restic.CredentialsSecretName); err != nil {
+				log.WithError(err).Debug("Not initializing restic repository for namespace without restic credentials")
+				continue
+			}
+		}
+
+		// Acquire only fails if the context is done, i.e. the server is
+		// shutting down.
+		if err := inits.Acquire(ctx, 1); err != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(namespace string) {
+			defer wg.Done()
+			defer inits.Release(1)
+
+			if err := c.initRepository(ctx, namespace, log); err != nil {
+				log.WithError(err).Warn("Error initializing restic repository")
+			}
+		}(namespace)
 	}
 
-	// Don't mutate the shared cache
-	reqCopy := req.DeepCopy()
-	return c.processBackupFunc(reqCopy)
+	wg.Wait()
 }
 
-func (c *podVolumeBackupController) processBackup(req *arkv1api.PodVolumeBackup) error {
-	log := c.logger.WithFields(logrus.Fields{
-		"namespace": req.Namespace,
-		"name":      req.Name,
-	})
+// runOrphanedBackupGC deletes this node's orphaned PodVolumeBackups every
+// orphanGCInterval until ctx is done.
+func (c *podVolumeBackupController) runOrphanedBackupGC(ctx context.Context) {
+	if !cache.WaitForCacheSync(ctx.Done(), c.cacheSyncWaiters...) {
+		return
+	}
 
-	var err error
+	wait.Until(func() { c.deleteOrphanedBackups(ctx) }, c.orphanGCInterval, ctx.Done())
+}
 
-	// update status to InProgress
-	req, err = c.patchPodVolumeBackup(req, updatePhaseFunc(arkv1api.PodVolumeBackupPhaseInProgress))
+// deleteOrphanedBackups deletes the PodVolumeBackups returned by
+// orphanedBackups. If forgetOrphans is set, their snapshots are forgotten
+// first, unless they have the finalizer that forgets them on deletion.
+func (c *podVolumeBackupController) deleteOrphanedBackups(ctx context.Context) {
+	orphans, err := c.orphanedBackups()
 	if err != nil {
-		log.WithError(err).Error("Error setting phase to InProgress")
-		return errors.WithStack(err)
+		c.logger.WithError(err).Error("Error finding orphaned PodVolumeBackups")
+		return
 	}
 
-	pod, err := c.podLister.Pods(req.Spec.Pod.Namespace).Get(req.Spec.Pod.Name)
-	if err != nil {
-		log.WithError(err).Errorf("Error getting pod %s/%s", req.Spec.Pod.Namespace, req.Spec.Pod.Name)
-		return c.fail(req, errors.Wrap(err, "error getting pod").Error(), log)
+	for _, pvb := range orphans {
+		if ctx.Err() != nil {
+			return
+		}
+
+		log := c.logger.WithField("key", kube.NamespaceAndName(pvb))
+
+		if c.forgetOrphans && !stringslice.Has(pvb.Finalizers, forgetSnapshotsFinalizer) {
+			if err := c.forgetSnapshots(pvb, log); err != nil {
+				log.WithError(err).Error("Error forgetting snapshots of orphaned PodVolumeBackup, not deleting it")
+				continue
+			}
+		}
+
+		// the UID precondition keeps a PodVolumeBackup that was recreated
+		// with the same name from being deleted.
+		log.Info("Deleting orphaned PodVolumeBackup whose backup no longer exists")
+		uid := pvb.UID
+		err := c.podVolumeBackupClient.PodVolumeBackups(pvb.Namespace).Delete(pvb.Name, &metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{UID: &uid},
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.WithError(err).Error("Error deleting orphaned PodVolumeBackup")
+		}
 	}
+}
 
-	volumeDir, err := kube.GetVolumeDirectory(pod, req.Spec.Volume, c.pvcLister)
+// orphanedBackups returns, sorted by key, the PodVolumeBackups run by this
+// node, and not currently running, that were created by a Backup, according
+// to their controller owner reference or backup name label, that no longer
+// exists. PodVolumeBackups created within orphanGracePeriod are excluded,
+// so that ones whose Backup isn't in the cache yet aren't deleted.
+func (c *podVolumeBackupController) orphanedBackups() ([]*arkv1api.PodVolumeBackup, error) {
+	pvbs, err := c.podVolumeBackupLister.List(labels.Everything())
 	if err != nil {
-		log.WithError(err).Error("Error getting volume directory name")
-		return c.fail(req, errors.Wrap(err, "error getting volume directory name").Error(), log)
+		return nil, errors.Wrap(err, "error listing PodVolumeBackups")
 	}
 
-	path, err := singlePathMatch(fmt.Sprintf("/host_pods/%s/volumes/*/%s", string(req.Spec.Pod.UID), volumeDir))
-	if err != nil {
-		log.WithError(err).Error("Error uniquely identifying volume path")
-		return c.fail(req, errors.Wrap(err, "error getting volume path on host").Error(), log)
+	cutoff := c.clock.Now().Add(-c.orphanGracePeriod)
+
+	var orphans []*arkv1api.PodVolumeBackup
+	for _, pvb := range pvbs {
+		if pvb.Spec.Node != c.nodeName || pvb.DeletionTimestamp != nil || pvb.CreationTimestamp.Time.After(cutoff) {
+			continue
+		}
+		if c.isRunning(kube.NamespaceAndName(pvb)) {
+			continue
+		}
+
+		name, uid, ok := ownerBackup(pvb)
+		if !ok {
+			continue
+		}
+
+		exists, err := c.backupExists(pvb.Namespace, name, uid)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			orphans = append(orphans, pvb)
+		}
 	}
 
-	// temp creds
-	file, err := restic.TempCredentialsFile(c.secretLister, req.Spec.Pod.Namespace)
-	if err != nil {
-		log.WithError(err).Error("Error creating temp restic credentials file")
-		return c.fail(req, errors.Wrap(err, "error creating temp restic credentials file").Error(), log)
+	sort.Slice(orphans, func(i, j int) bool {
+		return kube.NamespaceAndName(orphans[i]) < kube.NamespaceAndName(orphans[j])
+	})
+
+	return orphans, nil
+}
+
+// ownerBackup returns the name of the Backup that created a PodVolumeBackup,
+// and its UID if known, from the PodVolumeBackup's controller owner
+// reference or, failing that, its backup name label. It returns false if the
+// PodVolumeBackup wasn't created by a Backup.
+func ownerBackup(pvb *arkv1api.PodVolumeBackup) (string, types.UID, bool) {
+	if ref := metav1.GetControllerOf(pvb); ref != nil && ref.Kind == "Backup" {
+		return ref.Name, ref.UID, true
 	}
-	// ignore error since there's nothing we can do and it's a temp file.
-	defer os.Remove(file)
 
-	resticCmd := restic.BackupCommand(
-		req.Spec.RepoPrefix,
-		req.Spec.Pod.Namespace,
-		file,
-		path,
-		req.Spec.Tags,
-	)
+	if name := pvb.Labels[arkv1api.BackupNameLabel]; name != "" {
+		return name, "", true
+	}
 
-	var stdout, stderr string
+	return "", "", false
+}
 
-	if stdout, stderr, err = runCommand(resticCmd.Cmd()); err != nil {
-		log.WithError(errors.WithStack(err)).Errorf("Error running command=%s, stdout=%s, stderr=%s", resticCmd.String(), stdout, stderr)
-		return c.fail(req, fmt.Sprintf("error running restic backup, stderr=%s: %s", stderr, err.Error()), log)
+// backupExists returns true if the named Backup exists and, if uid isn't
+// empty, has that UID.
+func (c *podVolumeBackupController) backupExists(namespace, name string, uid types.UID) (bool, error) {
+	backup, err := c.backupLister.Backups(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "error getting backup %s/%s", namespace, name)
 	}
-	log.Debugf("Ran command=%s, stdout=%s, stderr=%s", resticCmd.String(), stdout, stderr)
 
-	snapshotID, err := restic.GetSnapshotID(req.Spec.RepoPrefix, req.Spec.Pod.Namespace, file, req.Spec.Tags)
+	return uid == "" || backup.UID == uid, nil
+}
+
+// initRepository initializes the restic repository for the given namespace
+// if it doesn't already exist.
+func (c *podVolumeBackupController) initRepository(ctx context.Context, namespace string, log logrus.FieldLogger) error {
+	file, err := c.credentialsFile(namespace)
 	if err != nil {
-		log.WithError(err).Error("Error getting SnapshotID")
-		return c.fail(req, errors.Wrap(err, "error getting snapshot id").Error(), log)
+		return errors.Wrap(err, "error getting restic credentials")
 	}
 
-	// update status to Completed with path & snapshot id
-	req, err = c.patchPodVolumeBackup(req, func(r *arkv1api.PodVolumeBackup) {
-		r.Status.Path = path
-		r.Status.SnapshotID = snapshotID
-		r.Status.Phase = arkv1api.PodVolumeBackupPhaseCompleted
-	})
+	exists, err := c.repositoryExistsFunc(ctx, c.resticCommand(restic.CatConfigCommand(c.repoInitPrefix, namespace, file)))
 	if err != nil {
-		log.WithError(err).Error("Error setting phase to Completed")
-		return err
+		return errors.Wrap(err, "error checking whether restic repository exists")
+	}
+	if exists {
+		log.Debug("Restic repository already exists")
+		return nil
 	}
 
-	return nil
+	initCmd := restic.InitCommand(c.repoInitPrefix, namespace)
+	initCmd.PasswordFile = file
+	if c.resticCompression != "" {
+		// compression is only supported by version 2 repositories.
+		initCmd.ExtraFlags = append(initCmd.ExtraFlags, "--repository-version=2")
+	}
+
+	// every restic server initializes its namespaces' repositories when it
+	// starts, so initializations of the same repository by several servers
+	// may collide. Transient failures are retried with exponential backoff,
+	// with jitter so that the servers' retries are spread out.
+	delay := c.repoInitRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = c.initRepoFunc(ctx, c.resticCommand(initCmd))
+		if err == nil {
+			log.Info("Initialized restic repository")
+			return nil
+		}
+
+		// another restic server, or the Ark server, may have initialized
+		// the repository since it was checked.
+		if restic.ErrorKind(err) == restic.ErrRepoExists {
+			log.Debug("Restic repository was initialized concurrently")
+			return nil
+		}
+		if exists, existsErr := c.repositoryExistsFunc(ctx, c.resticCommand(restic.CatConfigCommand(c.repoInitPrefix, namespace, file))); existsErr == nil && exists {
+			log.Debug("Restic repository was initialized concurrently")
+			return nil
+		}
+
+		if ctx.Err() != nil || attempt >= maxRepoInitAttempts || !restic.IsRetryable(err) {
+			return errors.Wrapf(err, "error initializing restic repository (attempt %d of %d)", attempt, maxRepoInitAttempts)
+		}
+
+		retryDelay := jitter(delay, c.jitterFunc())
+		log.WithError(err).Warnf("Retryable error initializing restic repository (attempt %d of %d), retrying in %s", attempt, maxRepoInitAttempts, retryDelay)
+		select {
+		case <-ctx.Done():
+		case <-c.clock.After(retryDelay):
+		}
+		delay *= 2
+	}
 }
 
-// runCommand runs a command and returns its stdout, stderr, and its returned
-// error (if any). If there are errors reading stdout or stderr, their return
-// value(s) will contain the error as a string.
-func runCommand(cmd *exec.Cmd) (string, string, error) {
-	stdoutBuf := new(bytes.Buffer)
-	stderrBuf := new(bytes.Buffer)
+// jitter returns delay plus random, which is between 0 and 1, times delay.
+func jitter(delay time.Duration, random float64) time.Duration {
+	return delay + time.Duration(random*float64(delay))
+}
 
-	cmd.Stdout = stdoutBuf
-	cmd.Stderr = stderrBuf
+// waitForInFlightBackups stops new backups from being started and waits up
+// to the shutdown grace period for running ones to finish, then kills any
+// that are still running.
+func (c *podVolumeBackupController) waitForInFlightBackups() {
+	c.runningBackupsLock.Lock()
+	c.shuttingDown = true
+	c.runningBackupsLock.Unlock()
 
-	runErr := cmd.Run()
+	done := make(chan struct{})
+	go func() {
+		c.inFlightBackups.Wait()
+		close(done)
+	}()
 
-	var stdout, stderr string
+	select {
+	case <-done:
+	case <-c.clock.After(c.shutdownGracePeriod):
+		c.abortRunningBackups()
+	}
+}
 
-	if res, readErr := ioutil.ReadAll(stdoutBuf); readErr != nil {
-		stdout = errors.Wrap(readErr, "error reading command's stdout").Error()
-	} else {
-		stdout = string(res)
+// abortRunningBackups kills the restic processes of all running backups,
+// and of any that start tracking after this is called.
+func (c *podVolumeBackupController) abortRunningBackups() {
+	c.runningBackupsLock.Lock()
+	defer c.runningBackupsLock.Unlock()
+
+	c.abortingBackups = true
+	for key, running := range c.runningBackups {
+		c.logger.WithField("key", key).Warn("Shutdown grace period expired, killing restic backup")
+		running.cancel()
 	}
+}
 
-	if res, readErr := ioutil.ReadAll(stderrBuf); readErr != nil {
-		stderr = errors.Wrap(readErr, "error reading command's stderr").Error()
-	} else {
-		stderr = string(res)
+// startBackup records that a backup is in flight, or returns false if the
+// controller is shutting down and the backup should not be started.
+func (c *podVolumeBackupController) startBackup() bool {
+	c.runningBackupsLock.Lock()
+	defer c.runningBackupsLock.Unlock()
+
+	if c.shuttingDown {
+		return false
 	}
+	c.inFlightBackups.Add(1)
 
-	return stdout, stderr, runErr
+	return true
 }
 
-func (c *podVolumeBackupController) patchPodVolumeBackup(req *arkv1api.PodVolumeBackup, mutate func(*arkv1api.PodVolumeBackup)) (*arkv1api.PodVolumeBackup, error) {
-	// Record original json
-	oldData, err := json.Marshal(req)
+// isRunning returns true if the PodVolumeBackup with the given key is
+// being processed.
+func (c *podVolumeBackupController) isRunning(key string) bool {
+	c.runningBackupsLock.Lock()
+	defer c.runningBackupsLock.Unlock()
+
+	_, running := c.runningBackups[key]
+	return running
+}
+
+// isAbortingBackups returns true if running backups are being killed
+// because the controller is shutting down.
+func (c *podVolumeBackupController) isAbortingBackups() bool {
+	c.runningBackupsLock.Lock()
+	defer c.runningBackupsLock.Unlock()
+
+	return c.abortingBackups
+}
+
+// failOrphanedBackups marks PodVolumeBackups that haven't started and are
+// assigned to a node that no longer exists as Failed, since no restic server
+// will ever process them. Every node's controller runs this check, so an
+// orphaned backup may be patched more than once.
+func (c *podVolumeBackupController) failOrphanedBackups() {
+	// Our shared informer factory filters on a single namespace, so asking for all is ok here.
+	pvbs, err := c.podVolumeBackupLister.List(labels.Everything())
 	if err != nil {
-		return nil, errors.Wrap(err, "error marshalling original PodVolumeBackup")
+		c.logger.WithError(err).Error("Error listing PodVolumeBackups to check for orphaned backups")
+		return
 	}
 
-	// Mutate
-	mutate(req)
+	for _, pvb := range pvbs {
+		if pvb.Status.Phase != "" && pvb.Status.Phase != arkv1api.PodVolumeBackupPhaseNew {
+			continue
+		}
 
-	// Record new json
-	newData, err := json.Marshal(req)
+		// this node obviously still exists
+		if pvb.Spec.Node == c.nodeName {
+			continue
+		}
+
+		log := c.logger.WithFields(logrus.Fields{
+			"key":  kube.NamespaceAndName(pvb),
+			"node": pvb.Spec.Node,
+		})
+
+		_, err := c.nodeLister.Get(pvb.Spec.Node)
+		if err == nil {
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			log.WithError(err).Error("Error getting PodVolumeBackup's node")
+			continue
+		}
+
+		log.Info("Failing PodVolumeBackup whose node no longer exists")
+		msg := fmt.Sprintf("node %s no longer exists, so no restic server is available to run the backup", pvb.Spec.Node)
+		if _, err := c.patchPodVolumeBackup(pvb.DeepCopy(), func(r *arkv1api.PodVolumeBackup) {
+			r.Status.Phase = arkv1api.PodVolumeBackupPhaseFailed
+			r.Status.CompletionTimestamp = metav1.NewTime(c.clock.Now())
+			r.Status.Message = msg
+			r.Status.FailureReason = arkv1api.PodVolumeBackupFailureReasonNodeNotFound
+		}); err != nil {
+			log.WithError(err).Error("Error setting phase to Failed")
+			continue
+		}
+		c.recordFailedEvent(pvb, arkv1api.PodVolumeBackupFailureReasonNodeNotFound, msg)
+	}
+}
+
+// secretHandler invalidates the cached credentials file for a namespace
+// when its restic credentials secret is updated or deleted.
+func (c *podVolumeBackupController) secretHandler(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	secret, ok := obj.(*corev1api.Secret)
+	if !ok {
+		return
+	}
+
+	// any secret may be referenced by PodVolumeBackups, and invalidating
+	// one that isn't cached is a no-op.
+	c.credentialsFiles.InvalidateSecret(secret.Namespace, secret.Name)
+
+	if secret.Name != restic.CredentialsSecretName {
+		return
+	}
+
+	c.logger.WithField("namespace", secret.Namespace).Debug("Restic credentials secret changed, invalidating cached credentials file")
+	c.credentialsFiles.Invalidate(secret.Namespace)
+}
+
+// nodeHandler logs when restic backups on this node are paused or resumed
+// using its restic-backups-paused annotation, and when they're resumed,
+// enqueues the node's PodVolumeBackups so that those requested while they
+// were paused are started.
+func (c *podVolumeBackupController) nodeHandler(oldObj, newObj interface{}) {
+	oldNode := oldObj.(*corev1api.Node)
+	newNode := newObj.(*corev1api.Node)
+
+	if newNode.Name != c.nodeName {
+		return
+	}
+
+	wasPaused, paused := restic.BackupsPaused(oldNode), restic.BackupsPaused(newNode)
+	switch {
+	case !wasPaused && paused:
+		c.logger.Info("Restic backups paused on this node, running backups will complete but no new ones will be started")
+	case wasPaused && !paused:
+		c.logger.Info("Restic backups resumed on this node")
+
+		pvbs, err := c.podVolumeBackupLister.List(labels.Everything())
+		if err != nil {
+			c.logger.WithError(errors.WithStack(err)).Error("Error listing PodVolumeBackups to resume")
+			return
+		}
+
+		for _, pvb := range pvbs {
+			if pvb.Spec.Node == c.nodeName {
+				c.enqueue(pvb)
+			}
+		}
+	}
+}
+
+// backupsPaused returns true if restic backups on this node have been
+// paused using its restic-backups-paused annotation.
+func (c *podVolumeBackupController) backupsPaused() bool {
+	node, err := c.nodeLister.Get(c.nodeName)
 	if err != nil {
-		return nil, errors.Wrap(err, "error marshalling updated PodVolumeBackup")
+		return false
 	}
 
-	patchBytes, err := jsonpatch.CreateMergePatch(oldData, newData)
+	return restic.BackupsPaused(node)
+}
+
+// nodePressure returns the conditions, of those that backups are deferred
+// under, that this node currently reports.
+func (c *podVolumeBackupController) nodePressure() []string {
+	if len(c.pressureConditions) == 0 {
+		return nil
+	}
+
+	node, err := c.nodeLister.Get(c.nodeName)
 	if err != nil {
-		return nil, errors.Wrap(err, "error creating json merge patch for PodVolumeBackup")
+		c.logger.WithError(errors.WithStack(err)).Warn("Error getting node to check for resource pressure")
+		return nil
+	}
+
+	var conditions []string
+	for _, condition := range node.Status.Conditions {
+		if condition.Status != corev1api.ConditionTrue {
+			continue
+		}
+		for _, pressureCondition := range c.pressureConditions {
+			if condition.Type == pressureCondition {
+				conditions = append(conditions, string(condition.Type))
+			}
+		}
 	}
 
-	req, err = c.podVolumeBackupClient.PodVolumeBackups(req.Namespace).Patch(req.Name, types.MergePatchType, patchBytes)
+	return conditions
+}
+
+func (c *podVolumeBackupController) pvbHandler(obj interface{}) {
+	pvb := obj.(*arkv1api.PodVolumeBackup)
+
+	if cancelRequested(pvb) {
+		c.cancelBackup(kube.NamespaceAndName(pvb), pvb)
+	}
+
+	c.enqueue(obj)
+}
+
+func (c *podVolumeBackupController) pvbDeleteHandler(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
 	if err != nil {
-		return nil, errors.Wrap(err, "error patching PodVolumeBackup")
+		c.logger.WithError(errors.WithStack(err)).Error("Error creating queue key, item not canceled")
+		return
 	}
 
-	return req, nil
+	// the PodVolumeBackup no longer exists so there's no status to update.
+	c.cancelBackup(key, nil)
 }
 
-func (c *podVolumeBackupController) fail(req *arkv1api.PodVolumeBackup, msg string, log logrus.FieldLogger) error {
-	if _, err := c.patchPodVolumeBackup(req, func(r *arkv1api.PodVolumeBackup) {
-		r.Status.Phase = arkv1api.PodVolumeBackupPhaseFailed
-		r.Status.Message = msg
-	}); err != nil {
-		log.WithError(err).Error("Error setting phase to Failed")
-		return err
+// cancelRequested returns true if the PodVolumeBackup has been marked for
+// cancellation or deletion.
+func cancelRequested(pvb *arkv1api.PodVolumeBackup) bool {
+	return pvb.Spec.Cancel || pvb.DeletionTimestamp != nil
+}
+
+// trackBackup records the function to cancel a PodVolumeBackup that is
+// being processed.
+func (c *podVolumeBackupController) trackBackup(key string, cancel context.CancelFunc) {
+	c.runningBackupsLock.Lock()
+	defer c.runningBackupsLock.Unlock()
+
+	c.runningBackups[key] = &runningBackup{
+		cancel:    cancel,
+		startTime: c.clock.Now(),
+	}
+
+	if c.abortingBackups {
+		cancel()
 	}
-	return nil
 }
 
-func updatePhaseFunc(phase arkv1api.PodVolumeBackupPhase) func(r *arkv1api.PodVolumeBackup) {
-	return func(r *arkv1api.PodVolumeBackup) {
-		r.Status.Phase = phase
+// untrackBackup stops tracking a PodVolumeBackup so that it can no longer
+// be canceled.
+func (c *podVolumeBackupController) untrackBackup(key string) {
+	c.runningBackupsLock.Lock()
+	defer c.runningBackupsLock.Unlock()
+
+	delete(c.runningBackups, key)
+}
+
+// setRunningCommand records the volume of a tracked PodVolumeBackup that's
+// being backed up, and the restic command, without credentials, that's
+// backing it up.
+func (c *podVolumeBackupController) setRunningCommand(key, volume, command string) {
+	c.runningBackupsLock.Lock()
+	defer c.runningBackupsLock.Unlock()
+
+	if running, ok := c.runningBackups[key]; ok {
+		running.volume = volume
+		running.command = command
+	}
+}
+
+// RunningBackups returns the PodVolumeBackups currently being processed,
+// ordered by key.
+func (c *podVolumeBackupController) RunningBackups() []RunningBackup {
+	c.runningBackupsLock.Lock()
+	defer c.runningBackupsLock.Unlock()
+
+	backups := make([]RunningBackup, 0, len(c.runningBackups))
+	for key, running := range c.runningBackups {
+		backups = append(backups, RunningBackup{
+			Key:       key,
+			StartTime: running.startTime,
+			Volume:    running.volume,
+			Command:   running.command,
+		})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Key < backups[j].Key })
+
+	return backups
+}
+
+// cancelBackup kills the restic process running the PodVolumeBackup with
+// the given key, if there is one. If pvb is not nil, its phase is set to
+// Canceling first; the worker processing it sets the phase to Canceled
+// once restic has exited. The lock of the running backups is only held to
+// remove the backup from them, not while patching, so that a slow patch
+// doesn't block the workers.
+func (c *podVolumeBackupController) cancelBackup(key string, pvb *arkv1api.PodVolumeBackup) {
+	c.runningBackupsLock.Lock()
+	running, ok := c.runningBackups[key]
+	if ok {
+		delete(c.runningBackups, key)
+	}
+	c.runningBackupsLock.Unlock()
+
+	if !ok {
+		return
+	}
+
+	log := c.logger.WithField("key", key)
+	log.Info("Canceling PodVolumeBackup")
+
+	if pvb != nil {
+		// don't mutate the shared cache. The backup may finish before the
+		// patch is made, in which case its final phase is kept.
+		if _, err := c.patchPodVolumeBackup(pvb.DeepCopy(), func(r *arkv1api.PodVolumeBackup) {
+			switch r.Status.Phase {
+			case "", arkv1api.PodVolumeBackupPhaseNew, arkv1api.PodVolumeBackupPhaseInProgress:
+				r.Status.Phase = arkv1api.PodVolumeBackupPhaseCanceling
+			}
+		}); err != nil {
+			log.WithError(err).Error("Error setting phase to Canceling")
+		}
 	}
+
+	running.cancel()
 }
 
-func singlePathMatch(path string) (string, error) {
-	matches, err := filepath.Glob(path)
+// backupPriority returns the priority, from its backup-priority annotation,
+// of the PodVolumeBackup with the provided queue key. PodVolumeBackups that
+// can't be found or have an invalid priority get the default priority of 0,
+// so they're processed in the order they were queued.
+func (c *podVolumeBackupController) backupPriority(item interface{}) int {
+	key := item.(string)
+
+	ns, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
-		return "", errors.WithStack(err)
+		return 0
 	}
 
-	if len(matches) != 1 {
-		return "", errors.Errorf("expected one matching path, got %d", len(matches))
+	req, err := c.podVolumeBackupLister.PodVolumeBackups(ns).Get(name)
+	if err != nil {
+		return 0
 	}
 
-	return matches[0], nil
+	priority, err := restic.GetBackupPriority(req)
+	if err != nil {
+		c.logger.WithError(err).WithField("key", key).Warn("Ignoring invalid PodVolumeBackup priority")
+		return 0
+	}
+
+	return priority
+}
+
+func (c *podVolumeBackupController) processQueueItem(key string) error {
+	log := c.logger.WithField("key", key)
+	log.Debug("Running processItem")
+
+	ns, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		log.WithError(err).Error("error splitting queue key")
+		return nil
+	}
+
+	req, err := c.podVolumeBackupLister.PodVolumeBackups(ns).Get(name)
+	if apierrors.IsNotFound(err) {
+		log.Debug("Unable to find PodVolumeBackup")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "error getting PodVolumeBackup")
+	}
+
+	// only process items for this node
+	if req.Spec.Node != c.nodeName {
+		return nil
+	}
+
+	switch req.Status.Phase {
+	case "", arkv1api.PodVolumeBackupPhaseNew:
+		// don't start a backup that's already been canceled
+		if cancelRequested(req) {
+			return c.markCanceled(req.DeepCopy(), "backup canceled before it started", log)
+		}
+		// fail a malformed backup right away, rather than once it's been
+		// queued behind other backups and throttled.
+		if validationErrors := c.validateFunc(req); len(validationErrors) > 0 {
+			msg := fmt.Sprintf("invalid pod volume backup: %s", strings.Join(validationErrors, "; "))
+			log.Error(msg)
+			return c.fail(req.DeepCopy(), arkv1api.PodVolumeBackupFailureReasonInvalidSpec, msg, log)
+		}
+	case arkv1api.PodVolumeBackupPhaseCanceling:
+		// a PodVolumeBackup left as Canceling with no restic process running
+		// (e.g. because the server restarted while canceling it) can be
+		// marked as Canceled.
+		if !c.isRunning(key) {
+			return c.markCanceled(req.DeepCopy(), "backup canceled", log)
+		}
+		return nil
+	case arkv1api.PodVolumeBackupPhaseInProgress:
+		if c.isRunning(key) {
+			return nil
+		}
+		// a PodVolumeBackup left as InProgress by a previous run of the
+		// server that's since been canceled can be marked as Canceled.
+		// Otherwise, it's retried.
+		if cancelRequested(req) && req.Status.StartTimestamp.Time.Before(c.startTime) {
+			return c.markCanceled(req.DeepCopy(), "backup canceled", log)
+		}
+		return c.recoverStaleBackup(req.DeepCopy(), key, log)
+	default:
+		// a PodVolumeBackup that's being deleted is finalized once it's no
+		// longer being processed. Otherwise, only process new items.
+		if req.DeletionTimestamp != nil && stringslice.Has(req.Finalizers, forgetSnapshotsFinalizer) && !c.isRunning(key) {
+			return c.finalize(req.DeepCopy(), log)
+		}
+		return nil
+	}
+
+	// the backup will be started when backups are resumed, at which point
+	// the node's PodVolumeBackups are enqueued again.
+	if c.backupsPaused() {
+		log.Debug("Restic backups are paused on this node, not starting backup")
+		return nil
+	}
+
+	// don't add restic's load to a node that's already under pressure.
+	if conditions := c.nodePressure(); len(conditions) > 0 {
+		log.Infof("Node is under pressure (%s), deferring backup for %s", strings.Join(conditions, ", "), c.pressureRetryDelay)
+		c.queue.AddAfter(key, c.pressureRetryDelay)
+		return nil
+	}
+
+	// likewise, while the volumes being backed up on this node add up to
+	// the maximum in-flight bytes, defer starting more backups.
+	if c.inFlightBytes != nil {
+		if bytes, exceeded := c.inFlightBytes.Exceeded(); exceeded {
+			log.Infof("Volumes totaling %d bytes are being backed up on this node, at least the maximum of %d, deferring backup for %s", bytes, c.inFlightBytes.max, c.pressureRetryDelay)
+			c.queue.AddAfter(key, c.pressureRetryDelay)
+			return nil
+		}
+	}
+
+	// throttle starting backups so that a burst of PodVolumeBackups, e.g.
+	// from a large Backup, doesn't turn into a burst of API calls. Only
+	// backups that are about to start are throttled, not items that need
+	// no work, and waiting stops when the controller is told to stop.
+	if err := c.queueLimiter.Wait(c.runCtx); err != nil {
+		if c.runCtx.Err() != nil {
+			log.Debug("Controller is shutting down, not starting backup")
+			return nil
+		}
+		return errors.Wrap(err, "error waiting for queue rate limiter")
+	}
+
+	// the backup will be started when the server next runs
+	if !c.startBackup() {
+		log.Debug("Controller is shutting down, not starting backup")
+		return nil
+	}
+	defer c.inFlightBackups.Done()
+
+	// Don't mutate the shared cache
+	reqCopy := req.DeepCopy()
+	return c.processBackupFunc(context.Background(), reqCopy)
+}
+
+// recoverStaleBackup resets a PodVolumeBackup that was left InProgress by a
+// previous run of the server, e.g. because it crashed or was killed while
+// the backup was running, to New so that it's retried. Backups are only
+// reset once they've been InProgress for the stale backup threshold, and
+// are failed once they've been reset MaxStaleBackupRestarts times.
+// Backups started by this run of the server are never reset, since they
+// may just not have been observed completing yet.
+func (c *podVolumeBackupController) recoverStaleBackup(req *arkv1api.PodVolumeBackup, key string, log logrus.FieldLogger) error {
+	if c.staleBackupThreshold <= 0 || !req.Status.StartTimestamp.Time.Before(c.startTime) {
+		return nil
+	}
+
+	if elapsed := c.clock.Since(req.Status.StartTimestamp.Time); elapsed < c.staleBackupThreshold {
+		c.queue.AddAfter(key, c.staleBackupThreshold-elapsed)
+		return nil
+	}
+
+	if req.Status.Restarts >= MaxStaleBackupRestarts {
+		log.Warnf("Backup was interrupted after being restarted %d times, failing it", req.Status.Restarts)
+		msg := fmt.Sprintf("backup was interrupted after being restarted %d times", req.Status.Restarts)
+		return c.fail(req, arkv1api.PodVolumeBackupFailureReasonInterrupted, msg, log)
+	}
+
+	log.Info("Resetting PodVolumeBackup interrupted while InProgress to New")
+	startTimestamp := req.Status.StartTimestamp
+	req, err := c.patchPodVolumeBackup(req, func(r *arkv1api.PodVolumeBackup) {
+		r.Status.Phase = arkv1api.PodVolumeBackupPhaseNew
+		r.Status.StartTimestamp = metav1.Time{}
+		r.Status.Progress = arkv1api.PodVolumeBackupProgress{}
+		r.Status.Restarts++
+		r.Status.Message = fmt.Sprintf("backup started at %s was interrupted, restarting it", startTimestamp.UTC().Format(time.RFC3339))
+	})
+	if err != nil {
+		log.WithError(err).Error("Error setting phase to New")
+		return err
+	}
+	c.eventRecorder.Eventf(req, corev1api.EventTypeNormal, eventReasonBackupRestarted, "Restarting backup interrupted while InProgress (restart %d of %d)", req.Status.Restarts, MaxStaleBackupRestarts)
+
+	return nil
+}
+
+// isNewBackup returns whether a PodVolumeBackup is waiting to be started,
+// i.e. it's new and hasn't been canceled.
+func isNewBackup(req *arkv1api.PodVolumeBackup) bool {
+	return (req.Status.Phase == "" || req.Status.Phase == arkv1api.PodVolumeBackupPhaseNew) && !cancelRequested(req)
+}
+
+// isDeferrableBackup returns whether the PodVolumeBackup with the provided
+// queue key is a new backup for this node, which can be deferred while the
+// queue is at its maximum depth. Other items, e.g. backups to finalize,
+// need little work, so they're always queued.
+func (c *podVolumeBackupController) isDeferrableBackup(item interface{}) bool {
+	ns, name, err := cache.SplitMetaNamespaceKey(item.(string))
+	if err != nil {
+		return false
+	}
+
+	req, err := c.podVolumeBackupLister.PodVolumeBackups(ns).Get(name)
+	if err != nil {
+		return false
+	}
+
+	return req.Spec.Node == c.nodeName && isNewBackup(req)
+}
+
+func (c *podVolumeBackupController) processBackup(ctx context.Context, req *arkv1api.PodVolumeBackup) error {
+	log := c.logger.WithFields(logrus.Fields{
+		"namespace": req.Namespace,
+		"name":      req.Name,
+	})
+
+	var err error
+
+	// update status to InProgress, adding the finalizer that forgets the
+	// backup's snapshots when it's deleted before any are taken. Dry runs
+	// don't take snapshots.
+	req, err = c.patchPodVolumeBackup(req, func(r *arkv1api.PodVolumeBackup) {
+		r.Status.Phase = arkv1api.PodVolumeBackupPhaseInProgress
+		r.Status.StartTimestamp = metav1.NewTime(c.clock.Now())
+		if c.deletionPolicy == SnapshotDeletionPolicyForget && !c.dryRun && !stringslice.Has(r.Finalizers, forgetSnapshotsFinalizer) {
+			r.Finalizers = append(r.Finalizers, forgetSnapshotsFinalizer)
+		}
+	})
+	if err != nil {
+		log.WithError(err).Error("Error setting phase to InProgress")
+		return errors.WithStack(err)
+	}
+	c.eventRecorder.Eventf(req, corev1api.EventTypeNormal, eventReasonBackupStarted, "Backing up volumes of pod %s/%s", req.Spec.Pod.Namespace, req.Spec.Pod.Name)
+
+	c.metrics.PodVolumeBackupStarted(c.nodeName)
+	defer c.metrics.PodVolumeBackupFinished(c.nodeName)
+
+	// track the backup so that it can be canceled while it's running, and
+	// stop it immediately if cancellation was requested before now.
+	key := kube.NamespaceAndName(req)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c.trackBackup(key, cancel)
+	defer c.untrackBackup(key)
+
+	if cancelRequested(req) {
+		cancel()
+	}
+
+	pod, err := c.podLister.Pods(req.Spec.Pod.Namespace).Get(req.Spec.Pod.Name)
+	switch {
+	case apierrors.IsNotFound(err) && len(req.Spec.PodVolumes) > 0:
+		// short-lived pods, e.g. a Job's, may be deleted before their backups
+		// are processed. Their volumes' directories remain on the node until
+		// the kubelet cleans them up, so back them up from there.
+		log.Infof("Pod %s/%s no longer exists, backing up its volumes using the volume definitions recorded in the PodVolumeBackup", req.Spec.Pod.Namespace, req.Spec.Pod.Name)
+		pod = podFromPodVolumeBackup(req)
+	case err != nil:
+		log.WithError(err).Errorf("Error getting pod %s/%s", req.Spec.Pod.Namespace, req.Spec.Pod.Name)
+		return c.fail(req, arkv1api.PodVolumeBackupFailureReasonUnknown, errors.Wrap(err, "error getting pod").Error(), log)
+	}
+
+	if err := restic.ValidateExcludePatterns(req.Spec.ExcludePatterns); err != nil {
+		log.WithError(err).Error("Invalid exclude patterns")
+		return c.fail(req, arkv1api.PodVolumeBackupFailureReasonInvalidSpec, errors.Wrap(err, "invalid exclude patterns").Error(), log)
+	}
+
+	if req.Spec.ExcludeLargerThan != "" {
+		if err := restic.ValidateExcludeLargerThan(req.Spec.ExcludeLargerThan); err != nil {
+			log.WithError(err).Error("Invalid exclude-larger-than size")
+			return c.fail(req, arkv1api.PodVolumeBackupFailureReasonInvalidSpec, errors.Wrap(err, "invalid excludeLargerThan").Error(), log)
+		}
+		if !c.featureGates.Enabled(restic.FeatureExcludeLargerThan) {
+			log.Error("restic version doesn't support excludeLargerThan")
+			return c.fail(req, arkv1api.PodVolumeBackupFailureReasonInvalidSpec, fmt.Sprintf("excludeLargerThan requires restic %s or later", restic.FeatureExcludeLargerThan.MinVersion()), log)
+		}
+	}
+
+	var subPath string
+	if req.Spec.SubPath != "" {
+		if subPath, err = restic.CleanSubPath(req.Spec.SubPath); err != nil {
+			log.WithError(err).Error("Invalid subPath")
+			return c.fail(req, arkv1api.PodVolumeBackupFailureReasonInvalidSpec, errors.Wrap(err, "invalid subPath").Error(), log)
+		}
+	}
+
+	if req.Spec.SubPathExpr != "" && req.Spec.SubPath != "" {
+		log.Error("Both subPath and subPathExpr are set")
+		return c.fail(req, arkv1api.PodVolumeBackupFailureReasonInvalidSpec, "subPath and subPathExpr can't both be set", log)
+	}
+
+	if req.Spec.CredentialsSecret != nil && req.Spec.CredentialsSecret.Name == "" {
+		log.Error("Credentials secret reference has no name")
+		return c.fail(req, arkv1api.PodVolumeBackupFailureReasonInvalidSpec, "invalid credentials secret reference: name is required", log)
+	}
+
+	switch req.Spec.MirrorFailurePolicy {
+	case "", arkv1api.PodVolumeBackupMirrorFailurePolicyWarn, arkv1api.PodVolumeBackupMirrorFailurePolicyFail:
+	default:
+		log.Errorf("Invalid mirror failure policy %q", req.Spec.MirrorFailurePolicy)
+		return c.fail(req, arkv1api.PodVolumeBackupFailureReasonInvalidSpec, fmt.Sprintf("invalid mirror failure policy %q, must be one of %s, %s", req.Spec.MirrorFailurePolicy, arkv1api.PodVolumeBackupMirrorFailurePolicyWarn, arkv1api.PodVolumeBackupMirrorFailurePolicyFail), log)
+	}
+
+	tags, err := restic.ResolveTags(req.Spec.Tags, pod)
+	if err != nil {
+		log.WithError(err).Error("Invalid tags")
+		return c.fail(req, arkv1api.PodVolumeBackupFailureReasonInvalidSpec, errors.Wrap(err, "invalid tags").Error(), log)
+	}
+
+	if req.Spec.Policy != "" {
+		if err := restic.ValidatePolicy(req.Spec.Policy); err != nil {
+			log.WithError(err).Error("Invalid policy")
+			return c.fail(req, arkv1api.PodVolumeBackupFailureReasonInvalidSpec, errors.Wrap(err, "invalid policy").Error(), log)
+		}
+		tags = restic.WithPolicyTag(tags, req.Spec.Policy)
+	}
+
+	// record the repository prefix resolved from a template, so that the
+	// backup's snapshots can be found in the same repository later, e.g.
+	// to forget them, even if the pod's metadata changes.
+	if restic.IsRepoPrefixTemplate(req.Spec.RepoPrefix) {
+		repoPrefix, err := restic.ResolveRepoPrefix(req.Spec.RepoPrefix, pod)
+		if err != nil {
+			log.WithError(err).Error("Invalid repoPrefix")
+			return c.fail(req, arkv1api.PodVolumeBackupFailureReasonInvalidSpec, errors.Wrap(err, "invalid repoPrefix").Error(), log)
+		}
+
+		req, err = c.patchPodVolumeBackup(req, func(r *arkv1api.PodVolumeBackup) {
+			r.Status.RepoPrefix = repoPrefix
+		})
+		if err != nil {
+			log.WithError(err).Error("Error recording resolved repoPrefix")
+			return errors.WithStack(err)
+		}
+		log = log.WithField("repoPrefix", repoPrefix)
+	}
+
+	volumes, err := c.podVolumesToBackUp(req, pod)
+	if err != nil {
+		log.WithError(err).Error("Error getting volumes to back up")
+		return c.fail(req, failureReason(err), errors.Wrap(err, "error getting volumes to back up").Error(), log)
+	}
+
+	if subPath != "" && len(volumes) != 1 {
+		log.Errorf("subPath is set, but %d volumes are selected to back up", len(volumes))
+		return c.fail(req, arkv1api.PodVolumeBackupFailureReasonInvalidSpec, fmt.Sprintf("subPath can only be set when a single volume is backed up, but %d are selected", len(volumes)), log)
+	}
+
+	// record the subPath resolved from a subPathExpr, using the environment
+	// of the pod's container that mounts the volume, so that the backup's
+	// snapshots can be found by it later, even if the pod changes.
+	if req.Spec.SubPathExpr != "" {
+		if len(volumes) != 1 {
+			log.Errorf("subPathExpr is set, but %d volumes are selected to back up", len(volumes))
+			return c.fail(req, arkv1api.PodVolumeBackupFailureReasonInvalidSpec, fmt.Sprintf("subPathExpr can only be set when a single volume is backed up, but %d are selected", len(volumes)), log)
+		}
+
+		if subPath, err = restic.ResolveSubPathExpr(req.Spec.SubPathExpr, pod, volumes[0]); err != nil {
+			log.WithError(err).Error("Invalid subPathExpr")
+			return c.fail(req, arkv1api.PodVolumeBackupFailureReasonInvalidSpec, errors.Wrap(err, "invalid subPathExpr").Error(), log)
+		}
+
+		req, err = c.patchPodVolumeBackup(req, func(r *arkv1api.PodVolumeBackup) {
+			r.Status.SubPath = subPath
+		})
+		if err != nil {
+			log.WithError(err).Error("Error recording resolved subPath")
+			return errors.WithStack(err)
+		}
+		log = log.WithField("subPath", subPath)
+	}
+
+	// creds, shared with other backups using the same secret and removed
+	// when the secret changes or the controller shuts down.
+	file, err := c.podVolumeBackupCredentialsFile(req)
+	if err != nil {
+		log.WithError(err).Error("Error creating temp restic credentials file")
+		return c.fail(req, arkv1api.PodVolumeBackupFailureReasonUnknown, errors.Wrap(err, "error creating temp restic credentials file").Error(), log)
+	}
+
+	// bound the time spent running restic for this PodVolumeBackup so that a
+	// hung restic process can't block a worker indefinitely.
+	if c.backupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.backupTimeout)
+		defer cancel()
+	}
+
+	// hold a backup slot for the whole restic sequence, from checking the
+	// repository through pruning it, so that at most maxConcurrentBackups
+	// backups run restic on this node at any given time.
+	if err := c.backupSemaphore.Acquire(ctx, 1); err != nil {
+		if ctx.Err() == context.Canceled {
+			log.Info("PodVolumeBackup was canceled while waiting for a backup slot")
+			return c.markCanceled(req, c.canceledMessage(), log)
+		}
+
+		log.WithError(err).Error("Timed out waiting for a restic backup slot")
+		return c.fail(req, arkv1api.PodVolumeBackupFailureReasonTimeout, fmt.Sprintf("restic backup timed out after %s waiting for a backup slot", c.backupTimeout), log)
+	}
+	defer c.backupSemaphore.Release(1)
+
+	// fail without running restic while the repository's circuit is open
+	// because recent backups to it failed, e.g. because its password is
+	// wrong or its bucket is gone.
+	repo := repoLeaseID(podVolumeBackupRepoPrefix(req), req.Spec.Pod.Namespace)
+	if c.circuitBreaker != nil && !c.dryRun {
+		if allowed, retryAt := c.circuitBreaker.Allow(repo); !allowed {
+			log.Info("Circuit is open for restic repository, failing backup")
+			return c.fail(req, arkv1api.PodVolumeBackupFailureReasonCircuitOpen, fmt.Sprintf("circuit open: recent backups to restic repository %s failed, so backups to it are failed without running restic until %s", repo, retryAt.UTC().Format(time.RFC3339)), log)
+		}
+	}
+
+	// fail with a clear message if the repository hasn't been initialized,
+	// rather than with restic's error from the backup command. If existence
+	// can't be determined, go ahead with the backup and let it report any
+	// error. This runs restic, so it's skipped for dry runs.
+	if !c.dryRun {
+		catConfigCmd := c.resticCommand(restic.CatConfigCommand(podVolumeBackupRepoPrefix(req), req.Spec.Pod.Namespace, file))
+		exists, err := c.repositoryExistsFunc(ctx, catConfigCmd)
+		if err != nil {
+			log.WithError(err).Warn("Error checking whether restic repository exists")
+		} else if !exists {
+			log.Error("Restic repository is not initialized")
+			c.recordRepositoryResult(repo, arkv1api.PodVolumeBackupFailureReasonRepoNotFound)
+			return c.fail(req, arkv1api.PodVolumeBackupFailureReasonRepoNotFound, fmt.Sprintf("restic repository %s/%s is not initialized; repositories are initialized by the Ark server when a backup of a pod volume in their namespace is started", podVolumeBackupRepoPrefix(req), req.Spec.Pod.Namespace), log)
+		}
+	}
+
+	// remove locks left behind by restic processes that were killed, so
+	// they don't block this backup. Only stale locks are removed (not
+	// --remove-all), so backups of the same repository that are running
+	// elsewhere are unaffected.
+	if c.unlockStaleLocks && !c.dryRun {
+		unlockCmd := c.resticCommand(restic.UnlockCommand(podVolumeBackupRepoPrefix(req), req.Spec.Pod.Namespace, file, false))
+		if err := c.unlockRepoFunc(unlockCmd); err != nil {
+			log.WithError(err).Warn("Error removing stale restic locks")
+		}
+	}
+
+	// wait for any maintenance of the repository by another node, e.g. a
+	// prune, to finish, and keep it from starting until the volumes are
+	// backed up. If the backup is canceled while waiting, it's reported as
+	// canceled below.
+	releaseLease := func() {}
+	if c.repoLeaser != nil && !c.dryRun {
+		release, err := c.repoLeaser.AcquireShared(ctx, repoLeaseID(podVolumeBackupRepoPrefix(req), req.Spec.Pod.Namespace))
+		if err != nil && ctx.Err() != context.Canceled {
+			log.WithError(err).Error("Error acquiring restic repository lease")
+			return c.fail(req, arkv1api.PodVolumeBackupFailureReasonUnknown, errors.Wrap(err, "error acquiring restic repository lease").Error(), log)
+		}
+		if err == nil {
+			releaseLease = release
+		}
+	}
+
+	logs := newBackupLogs(c.backupLogsMaxSize)
+	start := c.clock.Now()
+
+	results := c.backupVolumes(ctx, req, pod, volumes, tags, file, logs, log)
+
+	// once the volumes are in the primary repository, back them up to
+	// any mirror repositories. This happens while the backup is still
+	// tracked so it can be canceled.
+	if len(results.errs) == 0 && ctx.Err() == nil && !c.dryRun {
+		results.mirrors = c.backupToMirrors(ctx, req, pod, volumes, tags, file, log)
+	}
+
+	// the repository's lease is released before it's verified or pruned,
+	// which need exclusive access to it.
+	releaseLease()
+
+	// stop tracking the backup before updating its final status so that a
+	// cancellation can't race with the update.
+	c.untrackBackup(key)
+
+	c.metrics.ObservePodVolumeBackupDuration(c.nodeName, c.clock.Since(start).Seconds())
+
+	results.logsConfigMap = c.storeBackupLogs(req, logs, log)
+
+	switch {
+	case ctx.Err() == context.Canceled:
+		return c.finishCanceledBackup(req, file, results, log)
+	case len(results.errs) > 0:
+		return c.finishFailedBackup(req, repo, results, log)
+	case c.dryRun:
+		return c.finishDryRunBackup(req, volumes, subPath, results, log)
+	}
+
+	c.recordRepositoryResult(repo, "")
+
+	return c.completeBackup(ctx, req, volumes, subPath, file, results, log)
+}
+
+// volumeBackupResults are the results of backing up a PodVolumeBackup's
+// volumes, which are recorded in its status once it's finished.
+type volumeBackupResults struct {
+	paths         map[string]string
+	snapshotIDs   map[string]string
+	volumeModes   map[string]corev1api.PersistentVolumeMode
+	mirrors       []arkv1api.PodVolumeBackupMirrorStatus
+	verification  arkv1api.PodVolumeBackupVerification
+	logsConfigMap string
+	messages      []string
+	errs          []error
+	incomplete    bool
+}
+
+// backupVolumes backs up each of the given volumes of the pod in turn,
+// until ctx is canceled, and returns the results. A volume's failure is
+// recorded in the results' errors rather than stopping the others from
+// being backed up.
+func (c *podVolumeBackupController) backupVolumes(ctx context.Context, req *arkv1api.PodVolumeBackup, pod *corev1api.Pod, volumes []string, tags map[string]string, credsFile string, logs *backupLogs, log logrus.FieldLogger) *volumeBackupResults {
+	results := &volumeBackupResults{
+		paths:       make(map[string]string),
+		snapshotIDs: make(map[string]string),
+		volumeModes: make(map[string]corev1api.PersistentVolumeMode),
+	}
+
+	for _, volume := range volumes {
+		if ctx.Err() == context.Canceled {
+			break
+		}
+
+		volumeLog := log.WithField("volume", volume)
+
+		// backupVolume reports any error getting the volume's mode.
+		mode, _ := c.volumeMode(pod, volume)
+
+		// if the volume looks unchanged since a previous snapshot of it,
+		// reuse that snapshot rather than having restic re-scan it, unless
+		// a full re-scan is forced. Otherwise, tag the new snapshot with the volume's fingerprint
+		// so later backups can be compared against it. Block devices
+		// can't be fingerprinted, so they're always backed up.
+		volumeTags := tags
+		if c.skipUnchangedVolumes && !c.dryRun && mode != corev1api.PersistentVolumeBlock {
+			fingerprint, path, snapshotID := c.unchangedSnapshot(ctx, req, pod, volume, credsFile, volumeLog)
+			if snapshotID != "" && req.Spec.Force {
+				volumeLog.Infof("Volume is unchanged since snapshot %s, but a full re-scan is forced, backing it up", snapshotID)
+			} else if snapshotID != "" {
+				volumeLog.Infof("Volume is unchanged since snapshot %s, not backing it up", snapshotID)
+				results.paths[volume] = path
+				results.snapshotIDs[volume] = snapshotID
+				results.volumeModes[volume] = mode
+				results.messages = append(results.messages, fmt.Sprintf("volume %s: unchanged since snapshot %s, restic backup skipped", volume, snapshotID))
+				continue
+			}
+			if fingerprint != "" {
+				volumeTags = make(map[string]string, len(tags)+1)
+				for k, v := range tags {
+					volumeTags[k] = v
+				}
+				volumeTags[volumeFingerprintTag] = fingerprint
+			}
+		}
+
+		path, snapshotID, attempts, err := c.backupVolume(ctx, req, pod, volume, volumeTags, credsFile, logs, volumeLog)
+		if isUnresolvableVolume(err) {
+			volumeLog.WithError(err).Warn("Skipping volume whose directory on the host can't be found")
+			results.messages = append(results.messages, fmt.Sprintf("volume %s: skipped, %v", volume, err))
+			continue
+		}
+		if isIncompleteSnapshot(err) {
+			results.incomplete = true
+			results.messages = append(results.messages, fmt.Sprintf("volume %s: snapshot is incomplete, restic couldn't read some of its files", volume))
+			c.eventRecorder.Eventf(req, corev1api.EventTypeWarning, eventReasonIncompleteSnapshot, "Snapshot of volume %s is incomplete, restic couldn't read some of its files", volume)
+			err = nil
+		}
+		if err != nil {
+			volumeLog.WithError(err).Error("Error backing up volume")
+			results.errs = append(results.errs, errors.Wrapf(err, "volume %s", volume))
+			continue
+		}
+
+		results.paths[volume] = path
+		results.volumeModes[volume] = mode
+
+		// backupVolume returns no snapshot ID for an empty volume that it
+		// skipped.
+		if snapshotID == "" && !c.dryRun {
+			results.messages = append(results.messages, fmt.Sprintf("volume %s: skipped empty volume, no snapshot taken", volume))
+			continue
+		}
+		results.snapshotIDs[volume] = snapshotID
+
+		if attempts > 1 {
+			results.messages = append(results.messages, fmt.Sprintf("volume %s: restic backup succeeded after %d attempts", volume, attempts))
+		}
+	}
+
+	return results
+}
+
+// recordRepositoryResult informs the circuit breaker, if there is one, of
+// the result of a backup to a repository: success if reason is empty, or
+// failure if reason means the repository itself is broken. Other failures,
+// e.g. of a volume that couldn't be found, say nothing about the repository,
+// so they're not recorded.
+func (c *podVolumeBackupController) recordRepositoryResult(repo string, reason arkv1api.PodVolumeBackupFailureReason) {
+	if c.circuitBreaker == nil || c.dryRun {
+		return
+	}
+
+	switch reason {
+	case "":
+		c.circuitBreaker.Success(repo)
+	case arkv1api.PodVolumeBackupFailureReasonRepoNotFound,
+		arkv1api.PodVolumeBackupFailureReasonAuthFailed,
+		arkv1api.PodVolumeBackupFailureReasonPasswordRotated,
+		arkv1api.PodVolumeBackupFailureReasonRepoUnreachable:
+		c.circuitBreaker.Failure(repo)
+	}
+}
+
+// finalize forgets the snapshots of a PodVolumeBackup that's being deleted,
+// if the snapshot deletion policy is forget, then removes its finalizer so
+// that it can be removed. Snapshots that are shared with PodVolumeBackups
+// that aren't being deleted, e.g. because the volume was unchanged, are
+// kept, and ones that no longer exist are ignored. If a snapshot can't be
+// forgotten, an error is returned so that it's retried.
+func (c *podVolumeBackupController) finalize(req *arkv1api.PodVolumeBackup, log logrus.FieldLogger) error {
+	if c.deletionPolicy == SnapshotDeletionPolicyForget {
+		if err := c.forgetSnapshots(req, log); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.patchPodVolumeBackup(req, func(r *arkv1api.PodVolumeBackup) {
+		r.Finalizers = stringslice.Except(r.Finalizers, forgetSnapshotsFinalizer)
+	}); err != nil {
+		log.WithError(err).Error("Error removing finalizer")
+		return err
+	}
+
+	return nil
+}
+
+// forgetSnapshots forgets the snapshots of a PodVolumeBackup that's being
+// deleted, except those returned by snapshotsToForget as shared with other
+// PodVolumeBackups.
+func (c *podVolumeBackupController) forgetSnapshots(req *arkv1api.PodVolumeBackup, log logrus.FieldLogger) error {
+	snapshotIDs, err := c.snapshotsToForget(req)
+	if err != nil {
+		return err
+	}
+	if len(snapshotIDs) == 0 {
+		return nil
+	}
+
+	file, err := c.podVolumeBackupCredentialsFile(req)
+	if err != nil {
+		return errors.Wrap(err, "error getting restic credentials")
+	}
+
+	for _, snapshotID := range snapshotIDs {
+		forgetCmd := restic.ForgetCommand(podVolumeBackupRepoPrefix(req), req.Spec.Pod.Namespace, snapshotID)
+		forgetCmd.PasswordFile = file
+
+		snapshotLog := log.WithField("snapshotID", snapshotID)
+		snapshotLog.Info("Forgetting restic snapshot of deleted PodVolumeBackup")
+		if err := c.forgetSnapshotFunc(context.Background(), c.resticCommand(forgetCmd)); err != nil {
+			if restic.ErrorKind(err) != restic.ErrImmutableStorage {
+				return errors.Wrapf(err, "error forgetting restic snapshot %s", snapshotID)
+			}
+			if !c.skipImmutableErrors {
+				return errors.Wrapf(err, "error forgetting restic snapshot %s: %s", snapshotID, immutableStorageMessage)
+			}
+			snapshotLog.WithError(err).Warnf("Not forgetting restic snapshot: %s", immutableStorageMessage)
+		}
+	}
+
+	return nil
+}
+
+// snapshotsToForget returns the sorted IDs of the snapshots of a
+// PodVolumeBackup that's being deleted that aren't also recorded by another
+// PodVolumeBackup, not being deleted, of the same repository.
+func (c *podVolumeBackupController) snapshotsToForget(req *arkv1api.PodVolumeBackup) ([]string, error) {
+	snapshotIDs := podVolumeBackupSnapshotIDs(req)
+	if snapshotIDs.Len() == 0 {
+		return nil, nil
+	}
+
+	pvbs, err := c.podVolumeBackupLister.List(labels.Everything())
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing PodVolumeBackups")
+	}
+
+	for _, pvb := range pvbs {
+		if (pvb.Namespace == req.Namespace && pvb.Name == req.Name) || pvb.DeletionTimestamp != nil {
+			continue
+		}
+		if podVolumeBackupRepoPrefix(pvb) != podVolumeBackupRepoPrefix(req) || pvb.Spec.Pod.Namespace != req.Spec.Pod.Namespace {
+			continue
+		}
+
+		snapshotIDs.Delete(podVolumeBackupSnapshotIDs(pvb).UnsortedList()...)
+	}
+
+	return snapshotIDs.List(), nil
+}
+
+// podVolumeBackupSnapshotIDs returns the IDs of the snapshots recorded in a
+// PodVolumeBackup's status.
+func podVolumeBackupSnapshotIDs(pvb *arkv1api.PodVolumeBackup) sets.String {
+	snapshotIDs := sets.NewString()
+	if pvb.Status.SnapshotID != "" {
+		snapshotIDs.Insert(pvb.Status.SnapshotID)
+	}
+	for _, snapshotID := range pvb.Status.SnapshotIDs {
+		snapshotIDs.Insert(snapshotID)
+	}
+
+	return snapshotIDs
+}
+
+// podVolumeBackupRepoPrefix returns the prefix of the restic repository that
+// a PodVolumeBackup's volumes are backed up to: the prefix resolved from its
+// spec's template when the backup started, or its spec's prefix.
+func podVolumeBackupRepoPrefix(pvb *arkv1api.PodVolumeBackup) string {
+	if pvb.Status.RepoPrefix != "" {
+		return pvb.Status.RepoPrefix
+	}
+	return pvb.Spec.RepoPrefix
+}
+
+// podVolumeBackupSubPath returns the directory, relative to the root of
+// the volume, that a PodVolumeBackup backs up, or an empty string if it
+// backs up the whole volume: the subPath resolved from its spec's
+// subPathExpr when the backup started, or its spec's subPath, cleaned.
+// processBackup has validated both.
+func podVolumeBackupSubPath(pvb *arkv1api.PodVolumeBackup) string {
+	if pvb.Spec.SubPathExpr != "" {
+		return pvb.Status.SubPath
+	}
+
+	subPath, _ := restic.CleanSubPath(pvb.Spec.SubPath)
+	return subPath
+}
+
+// backupVolume runs a restic backup of a single volume within the pod, returning
+// the path that was backed up, the ID of the resulting snapshot, and the number
+// of times the restic backup command was attempted. backupTags are the
+// PodVolumeBackup's tags, resolved against the pod's metadata. restic's
+// output is recorded in logs. Block-mode volumes are backed up by having
+// restic read their device.
+func (c *podVolumeBackupController) backupVolume(ctx context.Context, req *arkv1api.PodVolumeBackup, pod *corev1api.Pod, volume string, backupTags map[string]string, credsFile string, logs *backupLogs, log logrus.FieldLogger) (string, string, int, error) {
+	path, err := c.backupPath(ctx, req, pod, volume, log)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	mode, err := c.volumeMode(pod, volume)
+	if err != nil {
+		return "", "", 0, err
+	}
+	block := mode == corev1api.PersistentVolumeBlock
+	if block {
+		if err := c.checkBlockBackupSupported(ctx); err != nil {
+			return "", "", 0, err
+		}
+	}
+
+	// make sure the volume's directory can be read before running restic,
+	// whose errors for SELinux denials are indistinguishable from any other
+	// unreadable file. A block device is read by restic in its entirety, so
+	// it's neither checked nor sized here.
+	if !block {
+		if err := c.checkAccessFunc(path); err != nil {
+			if os.IsPermission(errors.Cause(err)) && c.resticRunAs != nil {
+				return "", "", 0, newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonVolumeAccessDenied, errors.Wrapf(err, "permission denied reading volume directory %s as user %s, which restic runs as; the directory and its parents must be readable by that user or group, and if SELinux is enforcing on the node, the restic daemonset's pods must be privileged or run with an SELinux type, such as spc_t, that can read pod volumes", path, c.resticRunAs))
+			}
+			if os.IsPermission(errors.Cause(err)) {
+				return "", "", 0, newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonVolumeAccessDenied, errors.Wrapf(err, "permission denied reading volume directory %s; if SELinux is enforcing on the node, the restic daemonset's pods must be privileged or run with an SELinux type, such as spc_t, that can read pod volumes", path))
+			}
+			return "", "", 0, errors.Wrapf(err, "error checking access to volume directory %s", path)
+		}
+	}
+
+	if c.maxVolumeSize > 0 && !block {
+		exceeded, err := dirSizeExceeds(c.fileSystem, path, c.maxVolumeSize)
+		if err != nil {
+			return "", "", 0, errors.Wrap(err, "error getting volume size")
+		}
+		if exceeded {
+			return "", "", 0, newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonVolumeTooLarge, errors.Errorf("volume size exceeds the maximum of %d bytes, not backing it up", c.maxVolumeSize))
+		}
+	}
+
+	// backing up a volume with no files, e.g. one that was just provisioned,
+	// only adds an empty snapshot to the repository, so it's skipped if
+	// configured to. Such a volume may still hold empty directories, like a
+	// new filesystem's lost+found.
+	if c.skipEmptyVolumes && !block {
+		empty, err := dirHasNoFiles(c.fileSystem, path)
+		if err != nil {
+			return "", "", 0, errors.Wrap(err, "error checking whether volume is empty")
+		}
+		if empty {
+			log.Warn("Volume contains no files, not backing it up")
+			return path, "", 0, nil
+		}
+	}
+
+	// count the volume's size towards the node's in-flight bytes while it's
+	// being backed up. A block device's size isn't known, so it's not
+	// counted.
+	if c.inFlightBytes != nil && !block {
+		size, err := dirSize(c.fileSystem, path)
+		if err != nil {
+			return "", "", 0, errors.Wrap(err, "error getting volume size")
+		}
+		c.inFlightBytes.Add(size)
+		defer c.inFlightBytes.Release(size)
+	}
+
+	// tag each volume's snapshot with its own volume name, and with the
+	// PodVolumeBackup's UID, so its ID can be looked up once the backup
+	// completes without picking up a snapshot from another backup of the
+	// same volume.
+	tags := make(map[string]string, len(backupTags)+2)
+	for k, v := range backupTags {
+		tags[k] = v
+	}
+	tags["volume"] = volume
+	if subPath := podVolumeBackupSubPath(req); subPath != "" {
+		tags[subPathTag] = subPath
+	}
+	if req.UID != "" {
+		tags[restic.PodVolumeBackupUIDTag] = string(req.UID)
+	}
+
+	var backupCmd *restic.Command
+	if block {
+		backupCmd = restic.BlockBackupCommand(
+			podVolumeBackupRepoPrefix(req),
+			req.Spec.Pod.Namespace,
+			credsFile,
+			path,
+			volume+".img",
+			tags,
+			true,
+			c.resticLimitUpload,
+			c.resticHost,
+		)
+	} else {
+		backupCmd = restic.BackupCommand(
+			podVolumeBackupRepoPrefix(req),
+			req.Spec.Pod.Namespace,
+			credsFile,
+			path,
+			tags,
+			req.Spec.ExcludePatterns,
+			req.Spec.ExcludeLargerThan,
+			true,
+			c.resticLimitUpload,
+			c.resticOneFileSystem,
+			c.resticReadConcurrency,
+			c.resticHost,
+			req.Spec.Force,
+		)
+	}
+	resticCmd := c.resticCommand(backupCmd)
+
+	// run restic at a lower I/O priority, if configured, so that reading
+	// the volume doesn't starve other workloads on the node.
+	resticCmd.Wrapper, err = restic.IONiceWrapper(c.resticBackupIOClass)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	// record the command, without credentials, for auditing before it's run.
+	command := resticCmd.RedactedString()
+	if _, err := c.patchPodVolumeBackup(req, func(r *arkv1api.PodVolumeBackup) {
+		r.Status.Command = command
+	}); err != nil {
+		log.WithError(err).Warn("Error recording restic backup command")
+	}
+
+	c.setRunningCommand(kube.NamespaceAndName(req), volume, command)
+
+	if c.dryRun {
+		log.WithField("command", command).Info("Dry run: not running restic backup")
+		return path, "", 0, nil
+	}
+
+	// periodically record restic's progress in the PodVolumeBackup's status,
+	// throttled to avoid excessive calls to the API server.
+	var lastProgressUpdate time.Time
+	updateProgress := func(status restic.BackupStatus) {
+		now := c.clock.Now()
+		if now.Sub(lastProgressUpdate) < backupProgressUpdateInterval {
+			return
+		}
+		lastProgressUpdate = now
+
+		if _, err := c.patchPodVolumeBackup(req, func(r *arkv1api.PodVolumeBackup) {
+			r.Status.Progress = arkv1api.PodVolumeBackupProgress{
+				TotalBytes: status.TotalBytes,
+				BytesDone:  status.BytesDone,
+			}
+		}); err != nil {
+			log.WithError(err).Warn("Error updating backup progress")
+		}
+	}
+
+	// retry transient failures (e.g. lock contention or network errors) with
+	// exponential backoff, up to maxBackupAttempts times.
+	var (
+		stdout, stderr string
+		attempt        int
+		delay          = c.backupRetryDelay
+	)
+	for attempt = 1; ; attempt++ {
+		cmd := resticCmd.CmdContext(ctx)
+		cmd.Stdout = restic.NewProgressWriter(updateProgress)
+
+		stdout, stderr, err = c.runCommandFunc(cmd)
+		if err != nil {
+			err = restic.NewError(err, stderr)
+		}
+		if err == nil || ctx.Err() != nil || attempt >= c.maxBackupAttempts || !restic.IsRetryable(err) {
+			break
+		}
+
+		log.WithError(err).Warnf("Retryable error running restic backup (attempt %d of %d), retrying in %s", attempt, c.maxBackupAttempts, delay)
+		select {
+		case <-ctx.Done():
+		case <-c.clock.After(delay):
+		}
+		delay *= 2
+	}
+	logs.Add(volume, stdout, stderr)
+
+	// restic's output is logged in fields, rather than in the message, so
+	// that it's kept intact, and can be extracted, in any log format.
+	cmdLog := log.WithFields(logrus.Fields{
+		"command": command,
+		"stdout":  stdout,
+		"stderr":  stderr,
+	})
+	if ctx.Err() == context.DeadlineExceeded {
+		cmdLog.WithError(errors.WithStack(err)).Error("Timed out running restic backup")
+		return "", "", attempt, newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonTimeout, errors.Errorf("restic backup timed out after %s", c.backupTimeout))
+	}
+	// restic exits with code 3 when it took the snapshot but couldn't read
+	// some of the files, which may be tolerated.
+	var incomplete error
+	if restic.ErrorKind(err) == restic.ErrIncompleteSnapshot && c.incompletePolicy == IncompleteSnapshotPolicyWarn {
+		cmdLog.WithError(errors.WithStack(err)).Warn("Restic couldn't read some of the volume's files, snapshot is incomplete")
+		incomplete = &incompleteSnapshotError{error: err}
+		err = nil
+	}
+	if err != nil {
+		cmdLog.WithError(errors.WithStack(err)).Error("Error running restic backup")
+		return "", "", attempt, newVolumeBackupError(resticFailureReason(err), errors.Wrapf(err, "error running restic backup (attempt %d of %d)", attempt, c.maxBackupAttempts))
+	}
+	cmdLog.Debug("Ran restic backup")
+
+	if summary, ok := restic.ParseBackupSummary(stdout); ok {
+		log.WithFields(logrus.Fields{
+			"filesNew":            summary.FilesNew,
+			"filesChanged":        summary.FilesChanged,
+			"filesUnmodified":     summary.FilesUnmodified,
+			"dirsNew":             summary.DirsNew,
+			"dirsChanged":         summary.DirsChanged,
+			"dirsUnmodified":      summary.DirsUnmodified,
+			"dataAddedBytes":      summary.DataAdded,
+			"totalFilesProcessed": summary.TotalFilesProcessed,
+			"totalBytesProcessed": summary.TotalBytesProcessed,
+			"durationSeconds":     summary.TotalDuration,
+		}).Info("Restic backup completed")
+	}
+
+	// a block device's snapshot records the name it's stored under rather
+	// than the device's path, so it isn't looked up by path; its UID tag
+	// identifies it.
+	var group *restic.SnapshotGroupKey
+	if !block {
+		group = c.snapshotGroup(path)
+	}
+	snapshotIDCmd := c.resticCommand(restic.GetSnapshotCommand(podVolumeBackupRepoPrefix(req), req.Spec.Pod.Namespace, credsFile, tags, group))
+	snapshotID, err := c.waitForSnapshotID(ctx, snapshotIDCmd, log)
+	if err != nil {
+		return "", "", attempt, newVolumeBackupError(snapshotIDFailureReason(err), errors.Wrap(err, "error getting snapshot id"))
+	}
+
+	if err := c.runPostBackupHook(ctx, req, volume, path, snapshotID, log); err != nil {
+		if c.hookFailurePolicy == PostBackupHookFailurePolicyFail {
+			return "", "", attempt, newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonPostBackupHookFailed, err)
+		}
+		log.WithError(err).Warn("Post-backup hook failed, not failing the backup")
+		c.eventRecorder.Eventf(req, corev1api.EventTypeWarning, eventReasonPostBackupHookFailed, "Post-backup hook failed for volume %s: %v", volume, err)
+	}
+
+	return path, snapshotID, attempt, incomplete
+}
+
+// snapshotGroup returns the group of snapshots that restic backups of the
+// directory at path are in: those taken from the same path, and, if the
+// server records snapshots under a fixed host rather than its pod's
+// hostname, which changes when the pod is replaced, by the same host. In
+// repositories shared by several hosts or paths, looking snapshots up in
+// their group keeps another host's or path's snapshots with the same tags
+// from being picked up.
+func (c *podVolumeBackupController) snapshotGroup(path string) *restic.SnapshotGroupKey {
+	return &restic.SnapshotGroupKey{
+		Hostname: c.resticHost,
+		Paths:    []string{path},
+	}
+}
+
+// waitForSnapshotID returns the ID of the snapshot matching the tags of a
+// 'restic snapshots' command. On eventually consistent object stores, a
+// snapshot that was just taken may not be listed right away, so while none
+// matches, it's checked for again until the snapshot wait timeout expires.
+func (c *podVolumeBackupController) waitForSnapshotID(ctx context.Context, snapshotIDCmd *restic.Command, log logrus.FieldLogger) (string, error) {
+	deadline := c.clock.Now().Add(c.snapshotWaitTimeout)
+
+	for {
+		snapshotID, err := c.getSnapshotID(ctx, snapshotIDCmd)
+		if !restic.IsSnapshotNotFound(err) {
+			return snapshotID, err
+		}
+
+		if !c.clock.Now().Before(deadline) {
+			if c.snapshotWaitTimeout > 0 {
+				return "", errors.Wrapf(err, "snapshot was not listed by restic within %s", c.snapshotWaitTimeout)
+			}
+			return "", err
+		}
+
+		log.Debugf("Snapshot is not listed by restic yet, checking again in %s", c.snapshotPollInterval)
+		select {
+		case <-ctx.Done():
+			return "", errors.Wrap(ctx.Err(), "error waiting for snapshot to be listed")
+		case <-c.clock.After(c.snapshotPollInterval):
+		}
+	}
+}
+
+// getSnapshotID returns the ID of the snapshot matching the tags of a
+// 'restic snapshots' command, failing if restic doesn't finish within the
+// snapshot ID timeout, e.g. because the repository's storage is unreachable.
+func (c *podVolumeBackupController) getSnapshotID(ctx context.Context, snapshotIDCmd *restic.Command) (string, error) {
+	return c.runSnapshotIDCommand(ctx, c.getSnapshotIDFunc, snapshotIDCmd)
+}
+
+// getLatestSnapshotID is like getSnapshotID, but returns the ID of the
+// latest snapshot if more than one matches.
+func (c *podVolumeBackupController) getLatestSnapshotID(ctx context.Context, snapshotIDCmd *restic.Command) (string, error) {
+	return c.runSnapshotIDCommand(ctx, c.getLatestSnapshotIDFunc, snapshotIDCmd)
+}
+
+// runSnapshotIDCommand gets a snapshot ID with getFunc, failing if restic
+// doesn't finish within the snapshot ID timeout.
+func (c *podVolumeBackupController) runSnapshotIDCommand(ctx context.Context, getFunc func(context.Context, *restic.Command) (string, error), snapshotIDCmd *restic.Command) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.snapshotIDTimeout)
+	defer cancel()
+
+	snapshotID, err := getFunc(ctx, snapshotIDCmd)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return "", errors.Wrapf(err, "restic snapshots did not finish within %s", c.snapshotIDTimeout)
+	}
+
+	return snapshotID, err
+}
+
+// runPostBackupHook runs the post-backup hook command, if one is configured,
+// once a volume's snapshot has been taken. The command is run by /bin/sh,
+// with the snapshot's ID as its first argument, and the snapshot's details in
+// the environment variables returned by postBackupHookEnv.
+func (c *podVolumeBackupController) runPostBackupHook(ctx context.Context, req *arkv1api.PodVolumeBackup, volume, path, snapshotID string, log logrus.FieldLogger) error {
+	if c.postBackupHook == "" {
+		return nil
+	}
+
+	if c.postBackupHookTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.postBackupHookTimeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", c.postBackupHook, "post-backup-hook", snapshotID)
+	cmd.Env = append(os.Environ(), postBackupHookEnv(req, c.nodeName, volume, path, snapshotID)...)
+
+	stdout, stderr, err := c.runHookFunc(cmd)
+	hookLog := log.WithFields(logrus.Fields{
+		"command": c.postBackupHook,
+		"stdout":  stdout,
+		"stderr":  stderr,
+	})
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.Errorf("post-backup hook timed out after %s", c.postBackupHookTimeout)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error running post-backup hook: %s", strings.TrimSpace(stderr))
+	}
+	hookLog.Debug("Ran post-backup hook")
+
+	return nil
+}
+
+// postBackupHookEnv returns the environment variables, in KEY=value form,
+// describing a volume's snapshot to the post-backup hook command.
+func postBackupHookEnv(req *arkv1api.PodVolumeBackup, nodeName, volume, path, snapshotID string) []string {
+	return []string{
+		"ARK_SNAPSHOT_ID=" + snapshotID,
+		"ARK_REPO_PREFIX=" + podVolumeBackupRepoPrefix(req),
+		"ARK_REPO=" + req.Spec.Pod.Namespace,
+		"ARK_BACKUP=" + req.Labels[arkv1api.BackupNameLabel],
+		"ARK_POD_VOLUME_BACKUP=" + kube.NamespaceAndName(req),
+		"ARK_POD_NAMESPACE=" + req.Spec.Pod.Namespace,
+		"ARK_POD_NAME=" + req.Spec.Pod.Name,
+		"ARK_POD_UID=" + string(req.Spec.Pod.UID),
+		"ARK_VOLUME=" + volume,
+		"ARK_VOLUME_PATH=" + path,
+		"ARK_NODE_NAME=" + nodeName,
+	}
+}
+
+// backupToMirrors backs up the pod's volumes to each of the PodVolumeBackup's
+// mirror repositories in turn and returns the result for each. A failure to
+// back up to one mirror doesn't stop the others from being tried.
+func (c *podVolumeBackupController) backupToMirrors(ctx context.Context, req *arkv1api.PodVolumeBackup, pod *corev1api.Pod, volumes []string, tags map[string]string, credsFile string, log logrus.FieldLogger) []arkv1api.PodVolumeBackupMirrorStatus {
+	var mirrors []arkv1api.PodVolumeBackupMirrorStatus
+
+	for _, repoPrefix := range req.Spec.MirrorRepoPrefixes {
+		mirrorReq := req.DeepCopy()
+		mirrorReq.Spec.RepoPrefix = repoPrefix
+		mirrorReq.Status.RepoPrefix = ""
+		mirrorLog := log.WithField("repoPrefix", repoPrefix)
+
+		mirror := arkv1api.PodVolumeBackupMirrorStatus{
+			RepoPrefix:  repoPrefix,
+			Phase:       arkv1api.PodVolumeBackupPhaseCompleted,
+			SnapshotIDs: make(map[string]string),
+		}
+
+		var errs []error
+		for _, volume := range volumes {
+			volumeLog := mirrorLog.WithField("volume", volume)
+
+			_, snapshotID, _, err := c.backupVolume(ctx, mirrorReq, pod, volume, tags, credsFile, nil, volumeLog)
+			if isUnresolvableVolume(err) {
+				// it was skipped in the primary repository too.
+				continue
+			}
+			if isIncompleteSnapshot(err) {
+				// the incomplete snapshot is kept, as it is in the primary
+				// repository.
+				err = nil
+			}
+			if err != nil {
+				volumeLog.WithError(err).Error("Error backing up volume to mirror repository")
+				errs = append(errs, errors.Wrapf(err, "volume %s", volume))
+				continue
+			}
+
+			// skipped empty volumes have no snapshot.
+			if snapshotID != "" {
+				mirror.SnapshotIDs[volume] = snapshotID
+			}
+		}
+
+		if len(errs) > 0 {
+			mirror.Phase = arkv1api.PodVolumeBackupPhaseFailed
+			mirror.Message = kerrors.NewAggregate(errs).Error()
+		}
+
+		mirrors = append(mirrors, mirror)
+	}
+
+	return mirrors
+}
+
+// failedMirrors returns a description of each of the mirror backups that
+// failed.
+func failedMirrors(mirrors []arkv1api.PodVolumeBackupMirrorStatus) []string {
+	var failed []string
+	for _, mirror := range mirrors {
+		if mirror.Phase == arkv1api.PodVolumeBackupPhaseFailed {
+			failed = append(failed, fmt.Sprintf("%s: %s", mirror.RepoPrefix, mirror.Message))
+		}
+	}
+	return failed
+}
+
+// volumeBackupError is an error backing up a pod's volumes, along with the
+// category of failure to report in the PodVolumeBackup's status.
+type volumeBackupError struct {
+	error
+	reason arkv1api.PodVolumeBackupFailureReason
+}
+
+func newVolumeBackupError(reason arkv1api.PodVolumeBackupFailureReason, err error) error {
+	return &volumeBackupError{error: err, reason: reason}
+}
+
+// unresolvableVolumeError is returned by backupVolume for a volume whose
+// directory on the host can't be found from the pod spec, e.g. a volume of a
+// type that this version of the Kubernetes API doesn't know and that isn't a
+// mounted CSI ephemeral volume. Such volumes are skipped rather than failing
+// the backup.
+type unresolvableVolumeError struct {
+	error
+}
+
+// isUnresolvableVolume returns true if err is an unresolvableVolumeError.
+func isUnresolvableVolume(err error) bool {
+	_, ok := errors.Cause(err).(*unresolvableVolumeError)
+	return ok
+}
+
+// incompleteSnapshotError is returned by backupVolume, along with the
+// snapshot's ID, for a volume whose restic backup exited with code 3
+// because some of its files couldn't be read, when the incomplete snapshot
+// policy is warn. Such volumes are backed up, but their snapshots are
+// incomplete.
+type incompleteSnapshotError struct {
+	error
+}
+
+// isIncompleteSnapshot returns true if err is an incompleteSnapshotError.
+func isIncompleteSnapshot(err error) bool {
+	_, ok := errors.Cause(err).(*incompleteSnapshotError)
+	return ok
+}
+
+// resticExitCode returns the exit code of the restic command whose failure
+// caused an error returned by backupVolume, or 0 if the error wasn't caused
+// by restic exiting with an error.
+func resticExitCode(err error) int {
+	for err != nil {
+		switch e := err.(type) {
+		case *restic.Error:
+			if e.ExitCode > 0 {
+				return e.ExitCode
+			}
+			return 0
+		case *volumeBackupError:
+			err = e.error
+		case *incompleteSnapshotError:
+			err = e.error
+		case interface{ Cause() error }:
+			err = e.Cause()
+		default:
+			return 0
+		}
+	}
+
+	return 0
+}
+
+// failureReason returns the category of failure for an error returned by
+// backupVolume or podVolumesToBackUp, or Unknown if it wasn't categorized.
+func failureReason(err error) arkv1api.PodVolumeBackupFailureReason {
+	if vbErr, ok := errors.Cause(err).(*volumeBackupError); ok {
+		return vbErr.reason
+	}
+
+	return arkv1api.PodVolumeBackupFailureReasonUnknown
+}
+
+// resticFailureReason returns the category of failure to report for an
+// error from a restic command, as returned by restic.NewError.
+func resticFailureReason(err error) arkv1api.PodVolumeBackupFailureReason {
+	switch restic.ErrorKind(err) {
+	case restic.ErrRepoNotFound:
+		return arkv1api.PodVolumeBackupFailureReasonRepoNotFound
+	case restic.ErrRepoLocked:
+		return arkv1api.PodVolumeBackupFailureReasonLockTimeout
+	case restic.ErrAuth:
+		return arkv1api.PodVolumeBackupFailureReasonAuthFailed
+	case restic.ErrPermissionDenied:
+		return arkv1api.PodVolumeBackupFailureReasonPermissionDenied
+	case restic.ErrNetwork:
+		return arkv1api.PodVolumeBackupFailureReasonRepoUnreachable
+	case restic.ErrIncompleteSnapshot:
+		return arkv1api.PodVolumeBackupFailureReasonIncompleteSnapshot
+	default:
+		return arkv1api.PodVolumeBackupFailureReasonUnknown
+	}
+}
+
+// snapshotIDFailureReason returns the category of failure to report for an
+// error getting the ID of a volume's snapshot once it's backed up.
+func snapshotIDFailureReason(err error) arkv1api.PodVolumeBackupFailureReason {
+	if restic.IsSnapshotNotFound(err) {
+		return arkv1api.PodVolumeBackupFailureReasonSnapshotNotFound
+	}
+
+	return resticFailureReason(err)
+}
+
+// podVolumeBackupVolumes returns the names of all volumes to be backed up
+// by the PodVolumeBackup.
+func podVolumeBackupVolumes(req *arkv1api.PodVolumeBackup) []string {
+	var volumes []string
+	if req.Spec.Volume != "" {
+		volumes = append(volumes, req.Spec.Volume)
+	}
+
+	for _, volume := range req.Spec.Volumes {
+		if volume != req.Spec.Volume {
+			volumes = append(volumes, volume)
+		}
+	}
+
+	return volumes
+}
+
+// podFromPodVolumeBackup returns a stand-in for a PodVolumeBackup's pod that
+// no longer exists, with the pod's identity and the volume definitions
+// recorded in the PodVolumeBackup's spec.
+func podFromPodVolumeBackup(req *arkv1api.PodVolumeBackup) *corev1api.Pod {
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: req.Spec.Pod.Namespace,
+			Name:      req.Spec.Pod.Name,
+			UID:       req.Spec.Pod.UID,
+		},
+	}
+
+	for _, volume := range req.Spec.PodVolumes {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, *volume.DeepCopy())
+	}
+
+	return pod
+}
+
+// podVolumesToBackUp returns the names of the pod's volumes to be backed up
+// by the PodVolumeBackup. Volumes named in the spec take precedence; if there
+// are none and a volume selector is specified, the pod's volumes whose
+// PersistentVolumeClaims match the selector are returned, except for those
+// listed in the pod's volumes-to-exclude annotation.
+func (c *podVolumeBackupController) podVolumesToBackUp(req *arkv1api.PodVolumeBackup, pod *corev1api.Pod) ([]string, error) {
+	volumes := podVolumeBackupVolumes(req)
+	if len(volumes) > 0 || req.Spec.VolumeSelector == nil {
+		return volumes, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(req.Spec.VolumeSelector)
+	if err != nil {
+		return nil, newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonInvalidSpec, errors.Wrap(err, "error parsing volume selector"))
+	}
+
+	excluded := restic.GetVolumesToExclude(pod)
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil || excluded.Has(volume.Name) {
+			continue
+		}
+
+		pvc, err := c.pvcLister.PersistentVolumeClaims(pod.Namespace).Get(volume.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting persistent volume claim %s for volume %s", volume.PersistentVolumeClaim.ClaimName, volume.Name)
+		}
+
+		if selector.Matches(labels.Set(pvc.Labels)) {
+			volumes = append(volumes, volume.Name)
+		}
+	}
+
+	if len(volumes) == 0 {
+		return nil, newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonVolumeNotFound, errors.Errorf("no volumes in the pod match the volume selector %s", selector))
+	}
+
+	return volumes, nil
+}
+
+// resticCommand applies the controller's restic binary, global flags,
+// environment and cache settings to a restic command.
+func (c *podVolumeBackupController) resticCommand(cmd *restic.Command) *restic.Command {
+	cmd = withResticConfig(cmd, c.resticBinary, c.resticGlobalFlags, c.resticEnv)
+	cmd.CacheDir = c.resticCacheDir
+	cmd.NoCache = !c.resticCacheEnabled
+	cmd.SharedCache = c.resticSharedCache
+	cmd.Compression = c.resticCompression
+	cmd.PackSize = c.resticPackSize
+	cmd.Options = c.backendOptions.For(cmd.RepoPrefix)
+	cmd.RunAs = c.resticRunAs
+
+	// a credentials file created from a secret referenced by a
+	// PodVolumeBackup comes with the secret's environment variables, which
+	// override the server's, and its password is used even if there's an
+	// external password source.
+	if env, ok := c.credentialsFiles.Env(cmd.PasswordFile); ok {
+		cmd.Env = append(cmd.Env, env...)
+		return cmd
+	}
+
+	// an external password source replaces the credentials file created
+	// from the namespace's secret.
+	switch {
+	case c.resticPasswordFile != "":
+		cmd.PasswordFile = c.resticPasswordFile
+	case c.resticPasswordCommand != "":
+		cmd.PasswordFile = ""
+		cmd.PasswordCommand = c.resticPasswordCommand
+	}
+
+	return cmd
+}
+
+// externalPasswordSource returns true if restic repository passwords come
+// from a configured file or command rather than from each namespace's
+// restic credentials secret.
+func (c *podVolumeBackupController) externalPasswordSource() bool {
+	return c.resticPasswordFile != "" || c.resticPasswordCommand != ""
+}
+
+// credentialsFile returns the path to the restic credentials file for the
+// namespace's repository, created from its restic credentials secret, or
+// an empty string if there's an external password source, in which case
+// the secret isn't needed.
+func (c *podVolumeBackupController) credentialsFile(namespace string) (string, error) {
+	if c.externalPasswordSource() {
+		return "", nil
+	}
+
+	return c.credentialsFiles.Get(namespace)
+}
+
+// podVolumeBackupCredentialsFile returns the path to the restic credentials
+// file for a PodVolumeBackup: the one created from the secret it references,
+// if any, or else the one returned by credentialsFile for its pod's
+// namespace.
+func (c *podVolumeBackupController) podVolumeBackupCredentialsFile(req *arkv1api.PodVolumeBackup) (string, error) {
+	ref := req.Spec.CredentialsSecret
+	if ref == nil {
+		return c.credentialsFile(req.Spec.Pod.Namespace)
+	}
+
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = req.Spec.Pod.Namespace
+	}
+
+	return c.credentialsFiles.GetForSecret(namespace, ref.Name)
+}
+
+// credentialsSecret returns the namespace and name of the secret holding a
+// PodVolumeBackup's repository password: the one it references, if any, or
+// else its pod's namespace's restic credentials secret. It returns false
+// if the password is instead given by the restic server's password file or
+// command.
+func (c *podVolumeBackupController) credentialsSecret(req *arkv1api.PodVolumeBackup) (string, string, bool) {
+	ref := req.Spec.CredentialsSecret
+	if ref == nil {
+		if c.externalPasswordSource() {
+			return "", "", false
+		}
+		return req.Spec.Pod.Namespace, restic.CredentialsSecretName, true
+	}
+
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = req.Spec.Pod.Namespace
+	}
+
+	return namespace, ref.Name, true
+}
+
+// newCredentialsFileCache returns a restic.CredentialsFileCache whose files
+// are owned by the user that restic runs as, if any.
+func newCredentialsFileCache(secretLister corev1listers.SecretLister, dir string, runAs *restic.RunAs) *restic.CredentialsFileCache {
+	files := restic.NewCredentialsFileCache(secretLister, dir)
+	files.Owner = runAs
+	return files
+}
+
+// withResticConfig sets the restic binary to run, if specified, and any
+// additional global flags and environment variables on a restic command.
+func withResticConfig(cmd *restic.Command, resticBinary string, globalFlags, env []string) *restic.Command {
+	if resticBinary != "" {
+		cmd.BaseName = resticBinary
+	}
+	cmd.GlobalFlags = append(cmd.GlobalFlags, globalFlags...)
+	cmd.Env = append(cmd.Env, env...)
+
+	return cmd
+}
+
+// runCommand runs a command and returns its stdout, stderr, and its returned
+// error (if any). If there are errors reading stdout or stderr, their return
+// value(s) will contain the error as a string. If cmd.Stdout is already set,
+// stdout is written to it as well as being returned.
+func runCommand(cmd *exec.Cmd) (string, string, error) {
+	stdoutBuf := new(bytes.Buffer)
+	stderrBuf := new(bytes.Buffer)
+
+	if cmd.Stdout != nil {
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, stdoutBuf)
+	} else {
+		cmd.Stdout = stdoutBuf
+	}
+	cmd.Stderr = stderrBuf
+
+	runErr := cmd.Run()
+
+	var stdout, stderr string
+
+	if res, readErr := ioutil.ReadAll(stdoutBuf); readErr != nil {
+		stdout = errors.Wrap(readErr, "error reading command's stdout").Error()
+	} else {
+		stdout = string(res)
+	}
+
+	if res, readErr := ioutil.ReadAll(stderrBuf); readErr != nil {
+		stderr = errors.Wrap(readErr, "error reading command's stderr").Error()
+	} else {
+		stderr = string(res)
+	}
+
+	return stdout, stderr, runErr
+}
+
+// patchPodVolumeBackup applies mutate to req and patches the PodVolumeBackup
+// with the result. If the patch fails with a conflict because the
+// PodVolumeBackup was updated concurrently, the latest version is fetched and
+// the patch is retried, with exponential backoff, with mutate applied to it.
+// Once the patch succeeds, req is updated in place to the patched
+// PodVolumeBackup, so that later patches of it carry the current
+// resourceVersion rather than conflicting.
+func (c *podVolumeBackupController) patchPodVolumeBackup(req *arkv1api.PodVolumeBackup, mutate func(*arkv1api.PodVolumeBackup)) (*arkv1api.PodVolumeBackup, error) {
+	var (
+		target  = req
+		patched *arkv1api.PodVolumeBackup
+	)
+
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		var err error
+		patched, err = c.tryPatchPodVolumeBackup(req, mutate)
+		if !apierrors.IsConflict(err) {
+			return err
+		}
+
+		latest, getErr := c.podVolumeBackupClient.PodVolumeBackups(req.Namespace).Get(req.Name, metav1.GetOptions{})
+		if getErr != nil {
+			return errors.Wrap(getErr, "error getting latest PodVolumeBackup")
+		}
+		req = latest
+
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error patching PodVolumeBackup")
+	}
+
+	patched.DeepCopyInto(target)
+	return patched, nil
+}
+
+// tryPatchPodVolumeBackup makes a single attempt to patch the PodVolumeBackup.
+// Errors from the API server are returned unwrapped so conflicts can be
+// detected.
+func (c *podVolumeBackupController) tryPatchPodVolumeBackup(req *arkv1api.PodVolumeBackup, mutate func(*arkv1api.PodVolumeBackup)) (*arkv1api.PodVolumeBackup, error) {
+	// Record original json, without its resourceVersion so that the patch
+	// includes it. The API server then rejects the patch with a conflict if
+	// the PodVolumeBackup has been updated since req was read, rather than
+	// merging it into the newer version.
+	original := req.DeepCopy()
+	original.ResourceVersion = ""
+	oldData, err := json.Marshal(original)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshalling original PodVolumeBackup")
+	}
+
+	// Mutate
+	mutate(req)
+
+	// Record new json
+	newData, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshalling updated PodVolumeBackup")
+	}
+
+	patchBytes, err := jsonpatch.CreateMergePatch(oldData, newData)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating json merge patch for PodVolumeBackup")
+	}
+
+	// once the controller is told to stop, patches aren't throttled, so
+	// that the final statuses of the backups that are still running are
+	// recorded without holding up its shutdown.
+	if err := c.patchLimiter.Wait(c.runCtx); err != nil && c.runCtx.Err() == nil {
+		return nil, errors.Wrap(err, "error waiting for patch rate limiter")
+	}
+
+	return c.podVolumeBackupClient.PodVolumeBackups(req.Namespace).Patch(req.Name, types.MergePatchType, patchBytes)
+}
+
+func (c *podVolumeBackupController) fail(req *arkv1api.PodVolumeBackup, reason arkv1api.PodVolumeBackupFailureReason, msg string, log logrus.FieldLogger) error {
+	msg = c.withRemediationHint(req, reason, msg)
+	if _, err := c.patchPodVolumeBackup(req, func(r *arkv1api.PodVolumeBackup) {
+		r.Status.Phase = arkv1api.PodVolumeBackupPhaseFailed
+		r.Status.CompletionTimestamp = metav1.NewTime(c.clock.Now())
+		r.Status.Message = msg
+		r.Status.FailureReason = reason
+	}); err != nil {
+		log.WithError(err).Error("Error setting phase to Failed")
+		return err
+	}
+	c.recordFailedEvent(req, reason, msg)
+	c.registerFailure(0)
+	return nil
+}
+
+// withRemediationHint returns the message of a backup that failed for the
+// given reason, followed by a short hint of how to fix it if there is one.
+func (c *podVolumeBackupController) withRemediationHint(req *arkv1api.PodVolumeBackup, reason arkv1api.PodVolumeBackupFailureReason, msg string) string {
+	hint := c.remediationHint(req, reason)
+	if hint == "" {
+		return msg
+	}
+
+	return fmt.Sprintf("%s (hint: %s)", msg, hint)
+}
+
+// remediationHint returns a short hint of how to fix a backup that failed
+// for the given reason, or an empty string if there's no general fix.
+func (c *podVolumeBackupController) remediationHint(req *arkv1api.PodVolumeBackup, reason arkv1api.PodVolumeBackupFailureReason) string {
+	switch reason {
+	case arkv1api.PodVolumeBackupFailureReasonRepoNotFound:
+		return "initialize the restic repository, or run the restic server with --init-repositories"
+	case arkv1api.PodVolumeBackupFailureReasonLockTimeout:
+		return "if no other restic command is using the repository, run restic unlock on it, or run the restic server with --unlock-stale-locks"
+	case arkv1api.PodVolumeBackupFailureReasonAuthFailed:
+		namespace, name, ok := c.credentialsSecret(req)
+		if !ok {
+			return "verify the repository password given by the restic server's --restic-password-file or --restic-password-command"
+		}
+		return fmt.Sprintf("verify that secret %s/%s exists and its %s key holds the repository's password", namespace, name, restic.CredentialsKey)
+	case arkv1api.PodVolumeBackupFailureReasonPasswordRotated:
+		namespace, name, _ := c.credentialsSecret(req)
+		return fmt.Sprintf("restic repositories keep their passwords when the secret changes, so add the new password to the repository with restic key add and remove the old one with restic key remove, or restore the previous password to secret %s/%s", namespace, name)
+	case arkv1api.PodVolumeBackupFailureReasonPermissionDenied:
+		if c.resticRunAs != nil {
+			return fmt.Sprintf("verify that user %s, which restic runs as, can read the volume's files and write the restic cache directory, and that the restic server's object store credentials can read and write the repository", c.resticRunAs)
+		}
+		return "verify that the restic server's object store credentials can read and write the repository"
+	case arkv1api.PodVolumeBackupFailureReasonRepoUnreachable:
+		return "verify that the restic server can reach the repository's object store"
+	case arkv1api.PodVolumeBackupFailureReasonSnapshotNotFound:
+		return "if the repository's object store is eventually consistent, increase the restic server's --snapshot-wait-timeout"
+	case arkv1api.PodVolumeBackupFailureReasonVolumeNotMounted:
+		return "verify that the volume is attached to the pod, or increase the restic server's --volume-mount-timeout"
+	case arkv1api.PodVolumeBackupFailureReasonHostPathNotAllowed:
+		return "add the volume's path to the restic server's --host-path-allow-list"
+	case arkv1api.PodVolumeBackupFailureReasonVolumeAccessDenied:
+		if c.resticRunAs != nil {
+			return fmt.Sprintf("make the volume readable by group %d, e.g. with the pod's fsGroup, or change the restic server's --restic-user", c.resticRunAs.GID)
+		}
+		return "run the restic daemonset's pods privileged, or with an SELinux type that can read pod volumes, e.g. spc_t"
+	case arkv1api.PodVolumeBackupFailureReasonTimeout:
+		return "increase the restic server's --backup-timeout"
+	case arkv1api.PodVolumeBackupFailureReasonCircuitOpen:
+		return "fix the earlier failures of backups to the repository; backups to it are tried again after the restic server's --circuit-open-duration"
+	case arkv1api.PodVolumeBackupFailureReasonVolumeTooLarge:
+		return "increase the restic server's --max-volume-size, or exclude files with spec.excludePatterns"
+	case arkv1api.PodVolumeBackupFailureReasonIncompleteSnapshot:
+		return "make sure the files in the volume are readable, or run the restic server with --incomplete-snapshot-policy=warn to keep incomplete snapshots"
+	default:
+		return ""
+	}
+}
+
+// registerFailure records a failed backup in the controller's metrics,
+// labeled with the exit code of the restic command that failed it if it's
+// not 0, and in its failure tracker.
+func (c *podVolumeBackupController) registerFailure(exitCode int) {
+	label := ""
+	if exitCode != 0 {
+		label = strconv.Itoa(exitCode)
+	}
+	c.metrics.RegisterPodVolumeBackupFailure(c.nodeName, label)
+	c.failureTracker.Add()
+}
+
+func (c *podVolumeBackupController) recordFailedEvent(req *arkv1api.PodVolumeBackup, reason arkv1api.PodVolumeBackupFailureReason, msg string) {
+	c.eventRecorder.Eventf(req, corev1api.EventTypeWarning, eventReasonBackupFailed, "Backup failed (%s): %s", reason, msg)
+}
+
+// formatSnapshotIDs returns the given snapshot IDs as a comma-separated
+// list of <volume>=<snapshot ID> pairs, sorted by volume.
+func formatSnapshotIDs(snapshotIDs map[string]string) string {
+	pairs := make([]string, 0, len(snapshotIDs))
+	for volume, snapshotID := range snapshotIDs {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", volume, snapshotID))
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ", ")
+}
+
+func (c *podVolumeBackupController) markCanceled(req *arkv1api.PodVolumeBackup, msg string, log logrus.FieldLogger) error {
+	if _, err := c.patchPodVolumeBackup(req, func(r *arkv1api.PodVolumeBackup) {
+		r.Status.Phase = arkv1api.PodVolumeBackupPhaseCanceled
+		r.Status.CompletionTimestamp = metav1.NewTime(c.clock.Now())
+		r.Status.Message = msg
+	}); err != nil {
+		log.WithError(err).Error("Error setting phase to Canceled")
+		return err
+	}
+	return nil
+}
+
+func updatePhaseFunc(phase arkv1api.PodVolumeBackupPhase) func(r *arkv1api.PodVolumeBackup) {
+	return func(r *arkv1api.PodVolumeBackup) {
+		r.Status.Phase = phase
+	}
+}
+
+// errSizeLimitExceeded is returned from dirSizeExceeds's walk function to
+// stop walking once the limit has been crossed.
+var errSizeLimitExceeded = errors.New("size limit exceeded")
+
+// errFileFound is returned from dirHasNoFiles's walk function to stop
+// walking once a file has been found.
+var errFileFound = errors.New("file found")
+
+// dirHasNoFiles returns true if the directory at path, and any directories
+// under it, contain nothing but directories.
+func dirHasNoFiles(fileSystem filesystem.Interface, path string) (bool, error) {
+	err := fileSystem.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return errFileFound
+		}
+
+		return nil
+	})
+
+	switch {
+	case err == errFileFound:
+		return false, nil
+	case err != nil:
+		return false, errors.WithStack(err)
+	default:
+		return true, nil
+	}
+}
+
+// checkDirReadable returns an error if the directory at path can't be
+// opened and listed.
+func checkDirReadable(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer dir.Close()
+
+	if _, err := dir.Readdirnames(1); err != nil && err != io.EOF {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// checkDirReadableBy returns an error if, according to their permission
+// bits, the directory at path can't be listed by runAs, or its parents
+// can't be traversed. It returns nil if runAs is nil.
+func checkDirReadableBy(path string, runAs *restic.RunAs) error {
+	if runAs == nil {
+		return nil
+	}
+
+	perm := os.FileMode(05)
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if !runAs.HasPermission(info, perm) {
+			return errors.WithStack(&os.PathError{Op: "access", Path: dir, Err: os.ErrPermission})
+		}
+
+		if filepath.Dir(dir) == dir {
+			return nil
+		}
+		// parents only need to be traversed
+		perm = 01
+	}
+}
+
+// dirSizeExceeds returns true if the total size of the files under path is
+// greater than limit. It stops walking the directory as soon as the limit is
+// crossed, so large volumes aren't walked in their entirety.
+func dirSizeExceeds(fileSystem filesystem.Interface, path string, limit int64) (bool, error) {
+	var size int64
+
+	err := fileSystem.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		size += info.Size()
+		if size > limit {
+			return errSizeLimitExceeded
+		}
+
+		return nil
+	})
+
+	switch {
+	case err == errSizeLimitExceeded:
+		return true, nil
+	case err != nil:
+		return false, errors.WithStack(err)
+	default:
+		return false, nil
+	}
+}
+
+// dirSize returns the total size of the files under path.
+func dirSize(fileSystem filesystem.Interface, path string) (int64, error) {
+	var size int64
+
+	err := fileSystem.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return size, nil
+}
+
+// volumeFingerprintTag is the restic snapshot tag recording the fingerprint,
+// as returned by volumeFingerprint, of the volume that was backed up.
+const volumeFingerprintTag = "volume-fingerprint"
+
+// subPathTag is the restic snapshot tag recording the subdirectory of the
+// volume that was backed up, for PodVolumeBackups with a subPath.
+const subPathTag = "sub-path"
+
+// unchangedSnapshot returns the fingerprint and path of the pod's volume and,
+// if a previous snapshot of the volume was tagged with the same fingerprint,
+// that snapshot's ID. Errors are logged and result in an empty fingerprint or
+// snapshot ID, so that the volume is backed up as usual.
+func (c *podVolumeBackupController) unchangedSnapshot(ctx context.Context, req *arkv1api.PodVolumeBackup, pod *corev1api.Pod, volume, credsFile string, log logrus.FieldLogger) (string, string, string) {
+	path, err := c.backupPath(ctx, req, pod, volume, log)
+	if err != nil {
+		// backupVolume reports the error.
+		return "", "", ""
+	}
+
+	fingerprint, err := volumeFingerprint(c.fileSystem, path)
+	if err != nil {
+		log.WithError(err).Warn("Error getting volume fingerprint, not checking whether it's unchanged")
+		return "", "", ""
+	}
+
+	tags := map[string]string{
+		"pod-uid":            string(req.Spec.Pod.UID),
+		"volume":             volume,
+		volumeFingerprintTag: fingerprint,
+	}
+	if subPath := podVolumeBackupSubPath(req); subPath != "" {
+		tags[subPathTag] = subPath
+	}
+	tags = restic.WithPolicyTag(tags, req.Spec.Policy)
+	snapshotIDCmd := c.resticCommand(restic.GetSnapshotCommand(podVolumeBackupRepoPrefix(req), req.Spec.Pod.Namespace, credsFile, tags, c.snapshotGroup(path)))
+	// a volume can have more than one snapshot with the same fingerprint,
+	// e.g. after a forced full re-scan, so reuse the latest of them.
+	snapshotID, err := c.getLatestSnapshotID(ctx, snapshotIDCmd)
+	if restic.IsSnapshotNotFound(err) {
+		log.Debug("No snapshot of the volume with the same fingerprint")
+		return fingerprint, path, ""
+	}
+	if err != nil {
+		log.WithError(err).Warn("Error getting snapshot of the volume with the same fingerprint, not checking whether it's unchanged")
+		return fingerprint, path, ""
+	}
+
+	return fingerprint, path, snapshotID
+}
+
+// volumeFingerprint returns a string summarizing the number of files and
+// directories under path, their total size, and the latest modification
+// time of any of them. If it's unchanged, it's assumed that the contents
+// of the directory are too. This is a heuristic: it doesn't detect changes
+// that preserve sizes and modification times.
+func volumeFingerprint(fileSystem filesystem.Interface, path string) (string, error) {
+	var (
+		count, size int64
+		latest      time.Time
+	)
+
+	err := fileSystem.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		count++
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return fmt.Sprintf("%d-%d-%d", count, size, latest.UnixNano()), nil
+}
+
+// waitForVolumePath returns the path of the volume's directory, or of its
+// device if it's a block-mode volume, on the host. If the pod has only just
+// been scheduled to this node, the volume may not be mounted yet, so this
+// waits up to volumeMountTimeout for the directory to appear. If a remount
+// leaves more than one path matching the volume's, the one preferred
+// accepts is returned.
+func (c *podVolumeBackupController) waitForVolumePath(ctx context.Context, podUID types.UID, volumeDir string, block bool, preferred func(string) bool, log logrus.FieldLogger) (string, error) {
+	// the volume's directory, as mounted in the daemonset pod, will look like:
+	//		<host-pods-path>/<pod-uid>/volumes/<volume-plugin-name>/<volume-dir>
+	// and a block-mode volume's device like:
+	//		<host-pods-path>/<pod-uid>/volumeDevices/<volume-plugin-name>/<volume-dir>
+	volumesDir := "volumes"
+	if block {
+		volumesDir = "volumeDevices"
+	}
+	pattern := filepath.Join(c.hostPodsPath, string(podUID), volumesDir, "*", volumeDir)
+	deadline := c.clock.Now().Add(c.volumeMountTimeout)
+
+	for {
+		matches, err := c.fileSystem.Glob(pattern)
+		if err != nil {
+			return "", newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonVolumeNotFound, errors.Wrap(errors.WithStack(err), "error getting volume path on host"))
+		}
+
+		if len(matches) > 0 {
+			match, err := preferredPathMatch(pattern, matches, preferred)
+			if err != nil {
+				return "", newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonVolumeNotFound, errors.Wrap(err, "error getting volume path on host"))
+			}
+			return match, nil
+		}
+
+		if !c.clock.Now().Before(deadline) {
+			break
+		}
+
+		log.Debugf("No path found matching %s, waiting for the volume to be mounted", pattern)
+		select {
+		case <-ctx.Done():
+			return "", errors.Wrap(ctx.Err(), "error waiting for volume to be mounted")
+		case <-c.clock.After(c.mountPollInterval):
+		}
+	}
+
+	// if the pod's directory exists, the pod is on this node, so its volume
+	// just hasn't been mounted. Otherwise, the pod isn't here at all.
+	podDirExists, err := c.fileSystem.DirExists(filepath.Join(c.hostPodsPath, string(podUID)))
+	if err != nil {
+		return "", errors.Wrap(err, "error checking whether pod directory exists on host")
+	}
+	if podDirExists {
+		return "", newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonVolumeNotMounted, errors.Errorf("volume directory matching %s was not mounted within %s", pattern, c.volumeMountTimeout))
+	}
+
+	return "", newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonVolumeNotFound, errors.Errorf("error getting volume path on host: no path found matching %s", pattern))
+}
+
+// volumePath returns the path, within the restic pod, of the directory of
+// the pod's volume to back up. hostPath volumes are found under the host's
+// root filesystem; all other volumes are found under the pod's directory
+// in the host's kubelet pods directory. CSI ephemeral volumes have no PVC, so
+// their directory is resolved from the pod spec alone; a volume of unknown
+// type that isn't a mounted CSI ephemeral volume can't be resolved, and an
+// unresolvableVolumeError is returned for it.
+func (c *podVolumeBackupController) volumePath(ctx context.Context, req *arkv1api.PodVolumeBackup, pod *corev1api.Pod, volume string, log logrus.FieldLogger) (string, error) {
+	hostPath, isHostPath, err := kube.GetHostPathVolume(pod, volume)
+	if err != nil {
+		return "", newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonVolumeNotFound, errors.Wrap(err, "error getting volume directory name"))
+	}
+	if isHostPath {
+		return c.hostPathVolumePath(hostPath)
+	}
+
+	csiDir, isCSI, err := kube.GetCSIEphemeralVolumeDirectory(pod, volume)
+	if err != nil {
+		return "", newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonVolumeNotFound, errors.Wrap(err, "error getting volume directory name"))
+	}
+	if isCSI {
+		path, err := c.waitForVolumePath(ctx, req.Spec.Pod.UID, csiDir, false, nil, log)
+		// the pod is on this node, but the volume isn't mounted where
+		// a CSI ephemeral volume would be.
+		if failureReason(err) == arkv1api.PodVolumeBackupFailureReasonVolumeNotMounted {
+			return "", &unresolvableVolumeError{errors.Wrap(err, "volume's type is unknown and it isn't a mounted CSI ephemeral volume")}
+		}
+		return path, err
+	}
+
+	volumeDir, err := kube.GetVolumeDirectory(pod, volume, c.pvcLister)
+	if err != nil {
+		return "", newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonVolumeNotFound, errors.Wrap(err, "error getting volume directory name"))
+	}
+
+	mode, err := c.volumeMode(pod, volume)
+	if err != nil {
+		return "", err
+	}
+
+	// if a remount leaves more than one directory matching the volume's,
+	// the one mounted by the volume's plugin, if it's known, is backed up.
+	preferred, err := volumePluginPreference(pod, volume, c.pvcLister, c.pvLister)
+	if err != nil {
+		return "", newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonVolumeNotFound, errors.Wrap(err, "error getting volume plugin directory name"))
+	}
+
+	return c.waitForVolumePath(ctx, req.Spec.Pod.UID, volumeDir, mode == corev1api.PersistentVolumeBlock, preferred, log)
+}
+
+// backupPath returns the path, within the restic pod, of the directory to
+// back up for the pod's volume: the volume's directory or, if the spec has
+// a subPath, that subdirectory of it. Snapshots record the absolute path
+// that was backed up, so a subPath is restored to the same subdirectory of
+// the restored volume. A subPath that leads outside of the volume through
+// a symlink is rejected, so that a pod can't have the node's files backed
+// up.
+func (c *podVolumeBackupController) backupPath(ctx context.Context, req *arkv1api.PodVolumeBackup, pod *corev1api.Pod, volume string, log logrus.FieldLogger) (string, error) {
+	path, err := c.volumePath(ctx, req, pod, volume, log)
+	subPath := podVolumeBackupSubPath(req)
+	if err != nil || subPath == "" {
+		return path, err
+	}
+
+	mode, err := c.volumeMode(pod, volume)
+	if err != nil {
+		return "", err
+	}
+	if mode == corev1api.PersistentVolumeBlock {
+		return "", newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonInvalidSpec, errors.New("subPath can't be set for a Block mode volume"))
+	}
+
+	subPathDir := filepath.Join(path, subPath)
+	exists, err := c.fileSystem.DirExists(subPathDir)
+	if err != nil {
+		return "", errors.Wrapf(err, "error checking whether subPath directory %s exists", subPathDir)
+	}
+	if !exists {
+		return "", newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonVolumeNotFound, errors.Errorf("subPath %s not found in volume directory %s", subPath, path))
+	}
+
+	resolvedVolumeDir, err := c.evalSymlinksFunc(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "error resolving volume directory %s", path)
+	}
+	resolvedSubPathDir, err := c.evalSymlinksFunc(subPathDir)
+	if err != nil {
+		return "", errors.Wrapf(err, "error resolving subPath directory %s", subPathDir)
+	}
+	if !strings.HasPrefix(resolvedSubPathDir, resolvedVolumeDir+"/") {
+		return "", newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonInvalidSpec, errors.Errorf("subPath %s resolves to %s, which is outside of the volume", subPath, resolvedSubPathDir))
+	}
+
+	return subPathDir, nil
+}
+
+// volumeMode returns the mode in which the pod's volume is backed up: Block
+// for volumes whose PVC's volume mode is Block, and Filesystem for all other
+// volumes.
+func (c *podVolumeBackupController) volumeMode(pod *corev1api.Pod, volume string) (corev1api.PersistentVolumeMode, error) {
+	block, err := kube.IsBlockVolume(pod, volume, c.pvcLister)
+	if err != nil {
+		return "", newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonVolumeNotFound, errors.Wrap(err, "error getting volume mode"))
+	}
+	if block {
+		return corev1api.PersistentVolumeBlock, nil
+	}
+
+	return corev1api.PersistentVolumeFilesystem, nil
+}
+
+// checkBlockBackupSupported returns an error if the restic binary can't back
+// up block devices.
+func (c *podVolumeBackupController) checkBlockBackupSupported(ctx context.Context) error {
+	version, err := c.resticVersionFunc(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error getting restic version to check whether it can back up block-mode volumes")
+	}
+
+	supported, err := restic.SupportsBlockBackup(version)
+	if err != nil {
+		return errors.Wrap(err, "error checking whether restic can back up block-mode volumes")
+	}
+	if !supported {
+		return newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonBlockVolumeNotSupported, errors.Errorf("volume is a block-mode volume, which requires restic 0.17.0 or later to back up, but the restic server is running %s", strings.TrimSpace(version)))
+	}
+
+	return nil
+}
+
+// hostPathVolumePath returns the path, within the restic pod, of a hostPath
+// volume's directory on the host. Since a hostPath volume can refer to any of
+// the host's files, only directories under an entry in the host path allow
+// list are backed up. A host path that leads outside of the allow list
+// through a symlink is rejected too.
+func (c *podVolumeBackupController) hostPathVolumePath(hostPath string) (string, error) {
+	hostPath = filepath.Clean(hostPath)
+	if !hostPathAllowed(hostPath, c.hostPathAllowList) {
+		return "", newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonHostPathNotAllowed, errors.Errorf("host path %s is not in the restic server's host path allow list", hostPath))
+	}
+
+	path := filepath.Join(c.hostRootPath, hostPath)
+	exists, err := c.fileSystem.DirExists(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "error checking for host path %s", hostPath)
+	}
+	if !exists {
+		return "", newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonVolumeNotFound, errors.Errorf("host path %s does not exist or is not a directory", hostPath))
+	}
+
+	resolvedPath, err := c.evalSymlinksFunc(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "error resolving host path %s", hostPath)
+	}
+	allowed, err := c.resolvedHostPathAllowed(resolvedPath)
+	if err != nil {
+		return "", err
+	}
+	if !allowed {
+		return "", newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonHostPathNotAllowed, errors.Errorf("host path %s resolves to %s, which is not in the restic server's host path allow list", hostPath, resolvedPath))
+	}
+
+	return path, nil
+}
+
+// resolvedHostPathAllowed returns true if the provided path, within the
+// restic pod, with its symlinks resolved, is one of the allowed host paths
+// or under one of them, once their symlinks are resolved too. Allowed host
+// paths that don't exist are skipped.
+func (c *podVolumeBackupController) resolvedHostPathAllowed(resolvedPath string) (bool, error) {
+	for _, allowed := range c.hostPathAllowList {
+		allowedPath := filepath.Join(c.hostRootPath, filepath.Clean(allowed))
+		resolvedAllowedPath, err := c.evalSymlinksFunc(allowedPath)
+		if os.IsNotExist(errors.Cause(err)) {
+			continue
+		}
+		if err != nil {
+			return false, errors.Wrapf(err, "error resolving allowed host path %s", allowed)
+		}
+
+		if resolvedPath == resolvedAllowedPath || strings.HasPrefix(resolvedPath, strings.TrimSuffix(resolvedAllowedPath, "/")+"/") {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// hostPathAllowed returns true if the provided clean host path is absolute
+// and is one of the allowed paths or under one of them.
+func hostPathAllowed(hostPath string, allowList []string) bool {
+	if !filepath.IsAbs(hostPath) {
+		return false
+	}
+
+	for _, allowed := range allowList {
+		allowed = filepath.Clean(allowed)
+		if hostPath == allowed || strings.HasPrefix(hostPath, strings.TrimSuffix(allowed, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// singlePathMatch returns the single path matching the provided glob
+// pattern. If there's more than one, e.g. because a volume is being
+// remounted, and preferred isn't nil, the single match it accepts is
+// returned; if it accepts none or several of them, an error is returned.
+func singlePathMatch(path string, fileSystem filesystem.Interface, preferred func(string) bool) (string, error) {
+	matches, err := fileSystem.Glob(path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if len(matches) == 0 {
+		return "", errors.Errorf("no path found matching %s", path)
+	}
+
+	return preferredPathMatch(path, matches, preferred)
+}
+
+// preferredPathMatch returns the single one of the paths matching the
+// provided glob pattern. If there's more than one and preferred isn't nil,
+// the single match it accepts is returned; if it accepts none or several of
+// them, an error is returned.
+func preferredPathMatch(path string, matches []string, preferred func(string) bool) (string, error) {
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+
+	if preferred != nil {
+		var candidates []string
+		for _, match := range matches {
+			if preferred(match) {
+				candidates = append(candidates, match)
+			}
+		}
+		if len(candidates) == 1 {
+			return candidates[0], nil
+		}
+	}
+
+	return "", errors.Errorf("expected one path matching %s, got %d: %s", path, len(matches), strings.Join(matches, ", "))
+}
+
+// volumePluginPreference returns a function that accepts the paths of
+// volume directories mounted by the pod's volume's plugin, or nil if its
+// plugin isn't known. A PVC-backed volume's plugin is that of its
+// persistent volume, whose directory is named after the persistent volume.
+func volumePluginPreference(pod *corev1api.Pod, volume string, pvcLister corev1listers.PersistentVolumeClaimLister, pvLister corev1listers.PersistentVolumeLister) (func(string) bool, error) {
+	pluginDir, ok, err := kube.GetVolumePluginDirectory(pod, volume, pvcLister, pvLister)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	return inVolumePluginDirectory(pluginDir), nil
+}
+
+// inVolumePluginDirectory returns a function that accepts the paths of
+// volume directories mounted by the plugin with the provided directory
+// name, i.e. paths of the form .../volumes/<pluginDir>/<volume-dir>.
+func inVolumePluginDirectory(pluginDir string) func(string) bool {
+	return func(path string) bool {
+		return filepath.Base(filepath.Dir(path)) == pluginDir
+	}
 }
@@ -0,0 +1,7617 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	kubeinformers "k8s.io/client-go/informers"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	core "k8s.io/client-go/testing"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/generated/clientset/versioned/fake"
+	informers "github.com/heptio/ark/pkg/generated/informers/externalversions"
+	"github.com/heptio/ark/pkg/metrics"
+	"github.com/heptio/ark/pkg/restic"
+	"github.com/heptio/ark/pkg/util/filesystem"
+	"github.com/heptio/ark/pkg/util/kube"
+	"github.com/heptio/ark/pkg/util/stringslice"
+	arktest "github.com/heptio/ark/pkg/util/test"
+)
+
+type podVolumeBackupControllerTestData struct {
+	client          *fake.Clientset
+	sharedInformers informers.SharedInformerFactory
+	kubeInformers   kubeinformers.SharedInformerFactory
+	fileSystem      *arktest.FakeFileSystem
+	eventRecorder   *arktest.FakeEventRecorder
+	controller      *podVolumeBackupController
+
+	// pvb is the server-side state of the PodVolumeBackup being
+	// patched by the controller.
+	pvb *arkv1api.PodVolumeBackup
+}
+
+func setupPodVolumeBackupControllerTest(maxConcurrentBackups int) *podVolumeBackupControllerTestData {
+	var (
+		client          = fake.NewSimpleClientset()
+		sharedInformers = informers.NewSharedInformerFactory(client, 0)
+		kubeInformers   = kubeinformers.NewSharedInformerFactory(nil, 0)
+		fileSystem      = arktest.NewFakeFileSystem()
+		eventRecorder   = arktest.NewFakeEventRecorder()
+	)
+
+	td := &podVolumeBackupControllerTestData{
+		client:          client,
+		sharedInformers: sharedInformers,
+		kubeInformers:   kubeInformers,
+		fileSystem:      fileSystem,
+		eventRecorder:   eventRecorder,
+		controller: NewPodVolumeBackupController(PodVolumeBackupControllerConfig{
+			Logger:                  arktest.NewLogger(),
+			PodVolumeBackupInformer: sharedInformers.Ark().V1().PodVolumeBackups(),
+			PodVolumeBackupClient:   client.ArkV1(),
+			BackupInformer:          sharedInformers.Ark().V1().Backups(),
+			PodInformer:             kubeInformers.Core().V1().Pods().Informer(),
+			SecretInformer:          kubeInformers.Core().V1().Secrets(),
+			PVCInformer:             kubeInformers.Core().V1().PersistentVolumeClaims(),
+			PVInformer:              kubeInformers.Core().V1().PersistentVolumes(),
+			NodeInformer:            kubeInformers.Core().V1().Nodes(),
+			Metrics:                 metrics.NewPodVolumeMetrics(),
+			EventRecorder:           eventRecorder,
+			FailureTracker:          NewFailureTracker(time.Hour),
+
+			NodeName:             "node-1",
+			MaxConcurrentBackups: maxConcurrentBackups,
+			MaxBackupAttempts:    1,
+			HostPodsPath:         "/host_pods",
+			ResticBinary:         "/restic",
+			ResticCacheEnabled:   true,
+			VerificationPolicy:   VerificationFailurePolicyWarn,
+			MaxConcurrentInits:   1,
+			DeletionPolicy:       SnapshotDeletionPolicyRetain,
+			HookFailurePolicy:    PostBackupHookFailurePolicyWarn,
+		}).(*podVolumeBackupController),
+	}
+	td.controller.fileSystem = fileSystem
+	td.controller.validateFunc = func(*arkv1api.PodVolumeBackup) []string {
+		return nil
+	}
+	td.controller.repositoryExistsFunc = func(context.Context, *restic.Command) (bool, error) {
+		return true, nil
+	}
+	td.controller.getSnapshotStatsFunc = func(context.Context, *restic.Command) (restic.SnapshotStats, error) {
+		return restic.SnapshotStats{}, nil
+	}
+	td.controller.checkAccessFunc = func(string) error {
+		return nil
+	}
+	td.controller.evalSymlinksFunc = func(path string) (string, error) {
+		return path, nil
+	}
+
+	// the fake client doesn't support patches, so apply them to
+	// td.pvb and return the result. Like the API server, a patch that
+	// includes a resourceVersion other than td.pvb's is rejected with a
+	// conflict, and each patch bumps the resourceVersion.
+	client.PrependReactor("patch", "podvolumebackups", func(action core.Action) (bool, runtime.Object, error) {
+		if td.pvb == nil {
+			return true, nil, errors.New("no PodVolumeBackup to patch")
+		}
+
+		var patch struct {
+			metav1.ObjectMeta `json:"metadata"`
+		}
+		if err := json.Unmarshal(action.(core.PatchAction).GetPatch(), &patch); err != nil {
+			return true, nil, err
+		}
+		if patch.ResourceVersion != "" && patch.ResourceVersion != td.pvb.ResourceVersion {
+			return true, nil, apierrors.NewConflict(arkv1api.Resource("podvolumebackups"), td.pvb.Name, errors.New("resourceVersion mismatch"))
+		}
+
+		original, err := json.Marshal(td.pvb)
+		if err != nil {
+			return true, nil, err
+		}
+
+		patched, err := jsonpatch.MergePatch(original, action.(core.PatchAction).GetPatch())
+		if err != nil {
+			return true, nil, err
+		}
+
+		res := new(arkv1api.PodVolumeBackup)
+		if err := json.Unmarshal(patched, res); err != nil {
+			return true, nil, err
+		}
+		version, _ := strconv.Atoi(td.pvb.ResourceVersion)
+		res.ResourceVersion = strconv.Itoa(version + 1)
+		td.pvb = res
+
+		return true, res.DeepCopy(), nil
+	})
+
+	// a conflicting patch is retried against the latest version, so get
+	// returns td.pvb too.
+	client.PrependReactor("get", "podvolumebackups", func(action core.Action) (bool, runtime.Object, error) {
+		if td.pvb == nil || action.(core.GetAction).GetName() != td.pvb.Name {
+			return false, nil, nil
+		}
+
+		return true, td.pvb.DeepCopy(), nil
+	})
+
+	return td
+}
+
+// withBackupPrerequisites adds a pod with the provided emptyDir volumes, its
+// volume directories on the host, and the namespace's restic credentials to
+// the test data.
+func (td *podVolumeBackupControllerTestData) withBackupPrerequisites(pod *corev1api.Pod, volumes ...string) {
+	for _, volume := range volumes {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1api.Volume{
+			Name: volume,
+			VolumeSource: corev1api.VolumeSource{
+				EmptyDir: &corev1api.EmptyDirVolumeSource{},
+			},
+		})
+
+		td.fileSystem.WithDirectory(fmt.Sprintf("%s/%s/volumes/kubernetes.io~empty-dir/%s", td.controller.hostPodsPath, pod.UID, volume))
+	}
+
+	td.kubeInformers.Core().V1().Pods().Informer().GetStore().Add(pod)
+	td.kubeInformers.Core().V1().Secrets().Informer().GetStore().Add(&corev1api.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: pod.Namespace,
+			Name:      restic.CredentialsSecretName,
+		},
+		Data: map[string][]byte{
+			restic.CredentialsKey: []byte("password"),
+		},
+	})
+}
+
+// fakeVolumeSnapshotID returns a snapshot ID of "snapshot-<volume>" for a
+// 'restic snapshots' command that filters on a volume tag.
+func fakeVolumeSnapshotID(_ context.Context, cmd *restic.Command) (string, error) {
+	for _, flag := range cmd.ExtraFlags {
+		if !strings.HasPrefix(flag, "--tag=") {
+			continue
+		}
+
+		for _, tag := range strings.Split(strings.TrimPrefix(flag, "--tag="), ",") {
+			if strings.HasPrefix(tag, "volume=") {
+				return "snapshot-" + strings.TrimPrefix(tag, "volume="), nil
+			}
+		}
+	}
+
+	return "", errors.New("no volume tag found")
+}
+
+func newTestPodVolumeBackup(name, node string) *arkv1api.PodVolumeBackup {
+	return &arkv1api.PodVolumeBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: arkv1api.DefaultNamespace,
+			Name:      name,
+		},
+		Spec: arkv1api.PodVolumeBackupSpec{
+			Node: node,
+		},
+	}
+}
+
+func TestPodVolumeBackupConcurrencyIsBounded(t *testing.T) {
+	tests := []struct {
+		name                 string
+		maxConcurrentBackups int
+		numBackups           int
+	}{
+		{
+			name:                 "one at a time",
+			maxConcurrentBackups: 1,
+			numBackups:           4,
+		},
+		{
+			name:                 "two at a time",
+			maxConcurrentBackups: 2,
+			numBackups:           6,
+		},
+		{
+			name:                 "limit higher than number of backups",
+			maxConcurrentBackups: 10,
+			numBackups:           3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(test.maxConcurrentBackups)
+			c := td.controller
+			c.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			var (
+				lock          sync.Mutex
+				running       int
+				maxRunning    int
+				inSequence    int
+				maxInSequence int
+				pvbs          = make(map[string]*arkv1api.PodVolumeBackup)
+				wg            sync.WaitGroup
+			)
+
+			// a backup's restic sequence starts with checking that its
+			// repository exists, and ends with it being marked Completed.
+			c.repositoryExistsFunc = func(context.Context, *restic.Command) (bool, error) {
+				lock.Lock()
+				defer lock.Unlock()
+
+				inSequence++
+				if inSequence > maxInSequence {
+					maxInSequence = inSequence
+				}
+				return true, nil
+			}
+
+			c.runCommandFunc = func(*exec.Cmd) (string, string, error) {
+				lock.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				lock.Unlock()
+
+				time.Sleep(20 * time.Millisecond)
+
+				lock.Lock()
+				running--
+				lock.Unlock()
+
+				return "", "", nil
+			}
+
+			// apply patches to each backup, rather than to td.pvb, since
+			// several backups are processed at once.
+			td.client.PrependReactor("patch", "podvolumebackups", func(action core.Action) (bool, runtime.Object, error) {
+				lock.Lock()
+				defer lock.Unlock()
+
+				name := action.(core.PatchAction).GetName()
+				original, err := json.Marshal(pvbs[name])
+				if err != nil {
+					return true, nil, err
+				}
+
+				patched, err := jsonpatch.MergePatch(original, action.(core.PatchAction).GetPatch())
+				if err != nil {
+					return true, nil, err
+				}
+
+				res := new(arkv1api.PodVolumeBackup)
+				if err := json.Unmarshal(patched, res); err != nil {
+					return true, nil, err
+				}
+				if res.Status.Phase == arkv1api.PodVolumeBackupPhaseCompleted && pvbs[name].Status.Phase != arkv1api.PodVolumeBackupPhaseCompleted {
+					inSequence--
+				}
+				pvbs[name] = res
+
+				return true, res.DeepCopy(), nil
+			})
+
+			var reqs []*arkv1api.PodVolumeBackup
+			for i := 0; i < test.numBackups; i++ {
+				pod := &corev1api.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace: "ns-1",
+						Name:      fmt.Sprintf("pod-%d", i),
+						UID:       types.UID(fmt.Sprintf("pod-uid-%d", i)),
+					},
+				}
+				td.withBackupPrerequisites(pod, "vol-1")
+
+				pvb := newTestPodVolumeBackup(fmt.Sprintf("pvb-%d", i), "node-1")
+				pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+				pvb.Spec.Volume = "vol-1"
+				pvbs[pvb.Name] = pvb.DeepCopy()
+				reqs = append(reqs, pvb)
+			}
+
+			wg.Add(len(reqs))
+			for _, req := range reqs {
+				go func(req *arkv1api.PodVolumeBackup) {
+					defer wg.Done()
+					assert.NoError(t, c.processBackup(context.Background(), req))
+				}(req)
+			}
+			wg.Wait()
+
+			for name, pvb := range pvbs {
+				assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, pvb.Status.Phase, name)
+			}
+
+			expectedMax := test.maxConcurrentBackups
+			if test.numBackups < expectedMax {
+				expectedMax = test.numBackups
+			}
+			assert.True(t, maxRunning <= expectedMax, "expected at most %d concurrent restic backups, got %d", expectedMax, maxRunning)
+			assert.True(t, maxInSequence <= expectedMax, "expected at most %d concurrent restic sequences, got %d", expectedMax, maxInSequence)
+			assert.True(t, maxRunning >= 1)
+		})
+	}
+}
+
+func TestProcessBackupMultipleVolumes(t *testing.T) {
+	tests := []struct {
+		name                string
+		volume              string
+		volumes             []string
+		failVolumes         []string
+		expectedPhase       arkv1api.PodVolumeBackupPhase
+		expectedSnapshotIDs map[string]string
+		expectedSnapshotID  string
+		expectedMessage     string
+	}{
+		{
+			name:                "single volume from spec.volume",
+			volume:              "vol-1",
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedSnapshotIDs: map[string]string{"vol-1": "snapshot-vol-1"},
+			expectedSnapshotID:  "snapshot-vol-1",
+		},
+		{
+			name:                "spec.volume is treated as part of spec.volumes",
+			volume:              "vol-1",
+			volumes:             []string{"vol-1", "vol-2"},
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedSnapshotIDs: map[string]string{"vol-1": "snapshot-vol-1", "vol-2": "snapshot-vol-2"},
+		},
+		{
+			name:                "all volumes succeed",
+			volumes:             []string{"vol-1", "vol-2", "vol-3"},
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedSnapshotIDs: map[string]string{"vol-1": "snapshot-vol-1", "vol-2": "snapshot-vol-2", "vol-3": "snapshot-vol-3"},
+		},
+		{
+			name:                "one of several volumes fails",
+			volumes:             []string{"vol-1", "vol-2", "vol-3"},
+			failVolumes:         []string{"vol-2"},
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseFailed,
+			expectedSnapshotIDs: map[string]string{"vol-1": "snapshot-vol-1", "vol-3": "snapshot-vol-3"},
+			expectedMessage:     "volume vol-2: error running restic backup (attempt 1 of 1): stderr=restic failed: exit status 1",
+		},
+		{
+			name:                "volume missing from pod fails",
+			volumes:             []string{"vol-1", "missing"},
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseFailed,
+			expectedMessage:     "volume missing: error getting volume directory name: volume not found in pod",
+			expectedSnapshotIDs: map[string]string{"vol-1": "snapshot-vol-1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1", "vol-2", "vol-3")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = test.volume
+			td.pvb.Spec.Volumes = test.volumes
+
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				for _, volume := range test.failVolumes {
+					for _, arg := range cmd.Args {
+						if strings.HasSuffix(arg, "/"+volume) {
+							return "", "restic failed", errors.New("exit status 1")
+						}
+					}
+				}
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedSnapshotIDs, td.pvb.Status.SnapshotIDs)
+			assert.Equal(t, test.expectedSnapshotID, td.pvb.Status.SnapshotID)
+			assert.Equal(t, test.expectedMessage, td.pvb.Status.Message)
+		})
+	}
+}
+
+func TestProcessBackupRetriesTransientFailures(t *testing.T) {
+	tests := []struct {
+		name              string
+		stderrs           []string
+		expectedAttempts  int
+		expectedWait      time.Duration
+		expectedPhase     arkv1api.PodVolumeBackupPhase
+		expectedMessage   string
+		expectedSnapshots map[string]string
+	}{
+		{
+			name:              "transient failure followed by success",
+			stderrs:           []string{"unable to create lock in backend: repository is already locked", ""},
+			expectedAttempts:  2,
+			expectedWait:      time.Second,
+			expectedPhase:     arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedMessage:   "volume vol-1: restic backup succeeded after 2 attempts",
+			expectedSnapshots: map[string]string{"vol-1": "snapshot-vol-1"},
+		},
+		{
+			name:             "fatal failure is not retried",
+			stderrs:          []string{"Fatal: wrong password or no key found", ""},
+			expectedAttempts: 1,
+			expectedPhase:    arkv1api.PodVolumeBackupPhaseFailed,
+			expectedMessage:  "volume vol-1: error running restic backup (attempt 1 of 3): stderr=Fatal: wrong password or no key found: exit status 1 (hint: verify that secret ns-1/ark-restic-credentials exists and its ark-restic-credentials key holds the repository's password)",
+		},
+		{
+			name:             "transient failures exhaust all attempts",
+			stderrs:          []string{"connection reset by peer", "connection reset by peer", "connection reset by peer", ""},
+			expectedAttempts: 3,
+			expectedWait:     3 * time.Second,
+			expectedPhase:    arkv1api.PodVolumeBackupPhaseFailed,
+			expectedMessage:  "volume vol-1: error running restic backup (attempt 3 of 3): stderr=connection reset by peer: exit status 1 (hint: verify that the restic server can reach the repository's object store)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.maxBackupAttempts = 3
+			td.controller.backupRetryDelay = time.Second
+			now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+			fakeClock := clock.NewFakeClock(now)
+			td.controller.clock = fakeClock
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+
+			attempts := 0
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				stderr := test.stderrs[attempts]
+				attempts++
+
+				if stderr != "" {
+					return "", stderr, errors.New("exit status 1")
+				}
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			// advance the clock past each retry delay as it's waited on.
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				assert.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+			}()
+		wait:
+			for {
+				select {
+				case <-done:
+					break wait
+				case <-time.After(time.Millisecond):
+					if fakeClock.HasWaiters() {
+						fakeClock.Step(time.Second)
+					}
+				}
+			}
+
+			assert.Equal(t, test.expectedAttempts, attempts)
+			assert.Equal(t, test.expectedWait, fakeClock.Since(now))
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedMessage, td.pvb.Status.Message)
+			assert.Equal(t, test.expectedSnapshots, td.pvb.Status.SnapshotIDs)
+		})
+	}
+}
+
+func TestProcessBackupCustomHostPodsPath(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+	td.controller.hostPodsPath = "/var/lib/kubelet/pods"
+
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+	}
+	td.withBackupPrerequisites(pod, "vol-1")
+
+	td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+	td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+	td.pvb.Spec.Volume = "vol-1"
+
+	var args []string
+	td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+		args = cmd.Args
+		return "", "", nil
+	}
+	td.controller.getSnapshotIDFunc = func(context.Context, *restic.Command) (string, error) {
+		return "snapshot-1", nil
+	}
+
+	require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+	assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+	assert.Equal(t, "/var/lib/kubelet/pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1", td.pvb.Status.Path)
+	assert.Contains(t, args, td.pvb.Status.Path)
+}
+
+func TestProcessBackupSnapshotGroup(t *testing.T) {
+	tests := []struct {
+		name            string
+		host            string
+		expectedGroupBy string
+	}{
+		{
+			name:            "snapshots are looked up among those of the same path",
+			expectedGroupBy: "--group-by=paths",
+		},
+		{
+			name:            "snapshots are looked up among those of the same host and path",
+			host:            "cluster-1",
+			expectedGroupBy: "--group-by=host,paths",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.resticHost = test.host
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				return "", "", nil
+			}
+			var snapshotIDCmd *restic.Command
+			td.controller.getSnapshotIDFunc = func(_ context.Context, cmd *restic.Command) (string, error) {
+				snapshotIDCmd = cmd
+				return "snapshot-1", nil
+			}
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+			require.NotNil(t, snapshotIDCmd)
+			assert.Contains(t, snapshotIDCmd.ExtraFlags, test.expectedGroupBy)
+			assert.Equal(t, &restic.SnapshotGroupKey{Hostname: test.host, Paths: []string{td.pvb.Status.Path}}, snapshotIDCmd.SnapshotGroup)
+		})
+	}
+}
+
+func TestSinglePathMatch(t *testing.T) {
+	tests := []struct {
+		name          string
+		dirs          []string
+		pattern       string
+		pluginDir     string
+		expected      string
+		expectedError string
+	}{
+		{
+			name:     "single match",
+			dirs:     []string{"/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1"},
+			pattern:  "/host_pods/pod-uid/volumes/*/vol-1",
+			expected: "/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1",
+		},
+		{
+			name:     "custom prefix",
+			dirs:     []string{"/var/lib/kubelet/pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1"},
+			pattern:  "/var/lib/kubelet/pods/pod-uid/volumes/*/vol-1",
+			expected: "/var/lib/kubelet/pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1",
+		},
+		{
+			name:          "zero matches",
+			dirs:          []string{"/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1"},
+			pattern:       "/var/lib/kubelet/pods/pod-uid/volumes/*/vol-1",
+			expectedError: "no path found matching /var/lib/kubelet/pods/pod-uid/volumes/*/vol-1",
+		},
+		{
+			name: "multiple matches",
+			dirs: []string{
+				"/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1",
+				"/host_pods/pod-uid/volumes/kubernetes.io~nfs/vol-1",
+			},
+			pattern:       "/host_pods/pod-uid/volumes/*/vol-1",
+			expectedError: "expected one path matching /host_pods/pod-uid/volumes/*/vol-1, got 2: /host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1, /host_pods/pod-uid/volumes/kubernetes.io~nfs/vol-1",
+		},
+		{
+			name: "multiple matches, one in the volume's plugin directory",
+			dirs: []string{
+				"/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1",
+				"/host_pods/pod-uid/volumes/kubernetes.io~nfs/vol-1",
+			},
+			pattern:   "/host_pods/pod-uid/volumes/*/vol-1",
+			pluginDir: "kubernetes.io~nfs",
+			expected:  "/host_pods/pod-uid/volumes/kubernetes.io~nfs/vol-1",
+		},
+		{
+			name: "multiple matches, none in the volume's plugin directory",
+			dirs: []string{
+				"/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1",
+				"/host_pods/pod-uid/volumes/kubernetes.io~nfs/vol-1",
+			},
+			pattern:       "/host_pods/pod-uid/volumes/*/vol-1",
+			pluginDir:     "kubernetes.io~secret",
+			expectedError: "expected one path matching /host_pods/pod-uid/volumes/*/vol-1, got 2: /host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1, /host_pods/pod-uid/volumes/kubernetes.io~nfs/vol-1",
+		},
+		{
+			name: "multiple matches in the volume's plugin directory",
+			dirs: []string{
+				"/restores/new-pod-uid/host_pods/pod-uid-1/volumes/kubernetes.io~empty-dir/vol-1",
+				"/restores/new-pod-uid/host_pods/pod-uid-2/volumes/kubernetes.io~empty-dir/vol-1",
+			},
+			pattern:       "/restores/new-pod-uid/host_pods/*/volumes/*/vol-1",
+			pluginDir:     "kubernetes.io~empty-dir",
+			expectedError: "expected one path matching /restores/new-pod-uid/host_pods/*/volumes/*/vol-1, got 2: /restores/new-pod-uid/host_pods/pod-uid-1/volumes/kubernetes.io~empty-dir/vol-1, /restores/new-pod-uid/host_pods/pod-uid-2/volumes/kubernetes.io~empty-dir/vol-1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileSystem := arktest.NewFakeFileSystem()
+			for _, dir := range test.dirs {
+				fileSystem.WithDirectory(dir)
+			}
+
+			var preferred func(string) bool
+			if test.pluginDir != "" {
+				preferred = inVolumePluginDirectory(test.pluginDir)
+			}
+
+			res, err := singlePathMatch(test.pattern, fileSystem, preferred)
+			if test.expectedError != "" {
+				require.EqualError(t, err, test.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
+
+func TestProcessBackupTimeout(t *testing.T) {
+	sleepPath, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep command not available")
+	}
+
+	td := setupPodVolumeBackupControllerTest(1)
+	td.controller.backupTimeout = 100 * time.Millisecond
+	td.controller.maxBackupAttempts = 3
+
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+	}
+	td.withBackupPrerequisites(pod, "vol-1")
+
+	td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+	td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+	td.pvb.Spec.Volume = "vol-1"
+
+	// replace the restic command with a long-running one, keeping the
+	// context that the controller created it with.
+	attempts := 0
+	td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+		attempts++
+		cmd.Path = sleepPath
+		cmd.Args = []string{"sleep", "30"}
+		return runCommand(cmd)
+	}
+	td.controller.getSnapshotIDFunc = func(context.Context, *restic.Command) (string, error) {
+		return "", errors.New("unexpected call to get snapshot ID")
+	}
+
+	start := time.Now()
+	require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+	assert.True(t, time.Since(start) < 10*time.Second, "restic process was not killed when the timeout fired")
+	assert.Equal(t, 1, attempts)
+	assert.Equal(t, arkv1api.PodVolumeBackupPhaseFailed, td.pvb.Status.Phase)
+	assert.Equal(t, "volume vol-1: restic backup timed out after 100ms (hint: increase the restic server's --backup-timeout)", td.pvb.Status.Message)
+}
+
+func TestCancelBackupDoesNotBlockRunningBackupsWhilePatching(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+
+	td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+	td.pvb.Status.Phase = arkv1api.PodVolumeBackupPhaseInProgress
+	key := kube.NamespaceAndName(td.pvb)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	td.controller.trackBackup(key, cancel)
+
+	// block the Canceling patch until the running backups have been
+	// checked.
+	patching := make(chan struct{})
+	unblock := make(chan struct{})
+	td.client.PrependReactor("patch", "podvolumebackups", func(action core.Action) (bool, runtime.Object, error) {
+		close(patching)
+		<-unblock
+		return false, nil, nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		td.controller.cancelBackup(key, td.pvb.DeepCopy())
+		close(done)
+	}()
+
+	<-patching
+	running := make(chan bool)
+	go func() {
+		running <- td.controller.isRunning(key)
+	}()
+
+	select {
+	case isRunning := <-running:
+		assert.False(t, isRunning)
+	case <-time.After(10 * time.Second):
+		t.Fatal("running backups were locked while patching")
+	}
+
+	close(unblock)
+	<-done
+
+	assert.Equal(t, arkv1api.PodVolumeBackupPhaseCanceling, td.pvb.Status.Phase)
+	assert.Equal(t, context.Canceled, ctx.Err())
+}
+
+func TestCancelBackupKeepsFinalPhase(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+
+	td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+	td.pvb.Status.Phase = arkv1api.PodVolumeBackupPhaseInProgress
+	key := kube.NamespaceAndName(td.pvb)
+	pvb := td.pvb.DeepCopy()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	td.controller.trackBackup(key, cancel)
+
+	// the backup completes after it's removed from the running backups,
+	// but before its phase is set to Canceling.
+	td.pvb.ResourceVersion = "1"
+	td.pvb.Status.Phase = arkv1api.PodVolumeBackupPhaseCompleted
+	pvb.ResourceVersion = "0"
+
+	td.controller.cancelBackup(key, pvb)
+
+	assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+	assert.Equal(t, context.Canceled, ctx.Err())
+}
+
+func TestProcessBackupCancellation(t *testing.T) {
+	sleepPath, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep command not available")
+	}
+
+	tests := []struct {
+		name              string
+		cancel            func(c *podVolumeBackupController, pvb *arkv1api.PodVolumeBackup)
+		expectedCanceling bool
+	}{
+		{
+			name: "spec.cancel is set",
+			cancel: func(c *podVolumeBackupController, pvb *arkv1api.PodVolumeBackup) {
+				pvb.Spec.Cancel = true
+				c.pvbHandler(pvb)
+			},
+			expectedCanceling: true,
+		},
+		{
+			name: "deletion timestamp is set",
+			cancel: func(c *podVolumeBackupController, pvb *arkv1api.PodVolumeBackup) {
+				now := metav1.Now()
+				pvb.DeletionTimestamp = &now
+				c.pvbHandler(pvb)
+			},
+			expectedCanceling: true,
+		},
+		{
+			name: "PodVolumeBackup is deleted",
+			cancel: func(c *podVolumeBackupController, pvb *arkv1api.PodVolumeBackup) {
+				c.pvbDeleteHandler(pvb)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1", "vol-2")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volumes = []string{"vol-1", "vol-2"}
+			pvb := td.pvb.DeepCopy()
+
+			// replace the restic command with a long-running one that's
+			// killed when the backup is canceled.
+			started := make(chan struct{})
+			attempts := 0
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				attempts++
+				close(started)
+				cmd.Path = sleepPath
+				cmd.Args = []string{"sleep", "30"}
+				return runCommand(cmd)
+			}
+			td.controller.getSnapshotIDFunc = func(context.Context, *restic.Command) (string, error) {
+				return "", errors.New("unexpected call to get snapshot ID")
+			}
+
+			done := make(chan error)
+			go func() {
+				done <- td.controller.processBackup(context.Background(), pvb.DeepCopy())
+			}()
+
+			<-started
+			test.cancel(td.controller, pvb)
+
+			select {
+			case err := <-done:
+				require.NoError(t, err)
+			case <-time.After(10 * time.Second):
+				t.Fatal("restic process was not killed when the backup was canceled")
+			}
+
+			var canceling bool
+			for _, action := range td.client.Actions() {
+				if patch, ok := action.(core.PatchAction); ok && strings.Contains(string(patch.GetPatch()), `"phase":"Canceling"`) {
+					canceling = true
+				}
+			}
+
+			assert.Equal(t, test.expectedCanceling, canceling)
+			assert.Equal(t, 1, attempts, "remaining volumes should not be backed up once canceled")
+			assert.Equal(t, arkv1api.PodVolumeBackupPhaseCanceled, td.pvb.Status.Phase)
+			assert.Equal(t, "backup canceled", td.pvb.Status.Message)
+			assert.Empty(t, td.controller.runningBackups)
+		})
+	}
+}
+
+func TestProcessQueueItemCancellation(t *testing.T) {
+	tests := []struct {
+		name            string
+		phase           arkv1api.PodVolumeBackupPhase
+		cancel          bool
+		running         bool
+		expectedPhase   arkv1api.PodVolumeBackupPhase
+		expectedMessage string
+		expectProcessed bool
+	}{
+		{
+			name:            "new PodVolumeBackup is processed",
+			phase:           arkv1api.PodVolumeBackupPhaseNew,
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseNew,
+			expectProcessed: true,
+		},
+		{
+			name:            "new PodVolumeBackup with spec.cancel set is canceled without running",
+			phase:           arkv1api.PodVolumeBackupPhaseNew,
+			cancel:          true,
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseCanceled,
+			expectedMessage: "backup canceled before it started",
+		},
+		{
+			name:            "canceling PodVolumeBackup that isn't running is marked canceled",
+			phase:           arkv1api.PodVolumeBackupPhaseCanceling,
+			cancel:          true,
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseCanceled,
+			expectedMessage: "backup canceled",
+		},
+		{
+			name:          "canceling PodVolumeBackup that's still running is left alone",
+			phase:         arkv1api.PodVolumeBackupPhaseCanceling,
+			cancel:        true,
+			running:       true,
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCanceling,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Cancel = test.cancel
+			td.pvb.Status.Phase = test.phase
+			td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore().Add(td.pvb.DeepCopy())
+
+			key := kube.NamespaceAndName(td.pvb)
+			if test.running {
+				td.controller.trackBackup(key, func() {})
+			}
+
+			processed := false
+			td.controller.processBackupFunc = func(context.Context, *arkv1api.PodVolumeBackup) error {
+				processed = true
+				return nil
+			}
+
+			require.NoError(t, td.controller.processQueueItem(key))
+
+			assert.Equal(t, test.expectProcessed, processed)
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedMessage, td.pvb.Status.Message)
+		})
+	}
+}
+
+func TestProcessBackupMetrics(t *testing.T) {
+	tests := []struct {
+		name             string
+		backupErr        error
+		expectedSuccess  float64
+		expectedFailures float64
+	}{
+		{
+			name:            "successful backup",
+			expectedSuccess: 1,
+		},
+		{
+			name:             "failed backup",
+			backupErr:        errors.New("exit status 1"),
+			expectedFailures: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+
+			var inProgress float64
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				inProgress = metricValue(t, td.controller.metrics, "ark_pod_volume_backups_in_progress")
+				return "", "", test.backupErr
+			}
+			td.controller.getSnapshotIDFunc = func(context.Context, *restic.Command) (string, error) {
+				return "snapshot-1", nil
+			}
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, float64(1), inProgress)
+			assert.Equal(t, float64(0), metricValue(t, td.controller.metrics, "ark_pod_volume_backups_in_progress"))
+			assert.Equal(t, test.expectedSuccess, metricValue(t, td.controller.metrics, "ark_pod_volume_backup_success_total"))
+			assert.Equal(t, test.expectedFailures, metricValue(t, td.controller.metrics, "ark_pod_volume_backup_failure_total"))
+			assert.Equal(t, float64(1), metricValue(t, td.controller.metrics, "ark_pod_volume_backup_duration_seconds"))
+		})
+	}
+}
+
+// labeledMetricValue returns the value of the named counter for node-1 whose
+// given label has the given value.
+func labeledMetricValue(t *testing.T, m *metrics.ServerMetrics, name, label, value string) float64 {
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(m))
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range metric.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+
+			if labels["node"] == "node-1" && labels[label] == value {
+				return metric.Counter.GetValue()
+			}
+		}
+	}
+
+	return 0
+}
+
+// metricValue returns the value of the named metric for node-1. For
+// histograms, the number of observations is returned.
+func metricValue(t *testing.T, m *metrics.ServerMetrics, name string) float64 {
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(m))
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() != "node" || label.GetValue() != "node-1" {
+					continue
+				}
+
+				switch {
+				case metric.Counter != nil:
+					return metric.Counter.GetValue()
+				case metric.Gauge != nil:
+					return metric.Gauge.GetValue()
+				case metric.Histogram != nil:
+					return float64(metric.Histogram.GetSampleCount())
+				}
+			}
+		}
+	}
+
+	return 0
+}
+
+func TestProcessBackupRepositoryNotInitialized(t *testing.T) {
+	tests := []struct {
+		name            string
+		exists          bool
+		existsErr       error
+		expectedPhase   arkv1api.PodVolumeBackupPhase
+		expectedMessage string
+		expectBackup    bool
+	}{
+		{
+			name:          "repository exists",
+			exists:        true,
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+			expectBackup:  true,
+		},
+		{
+			name:            "repository is not initialized",
+			exists:          false,
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedMessage: "restic repository s3:s3.amazonaws.com/bucket/ns-1 is not initialized; repositories are initialized by the Ark server when a backup of a pod volume in their namespace is started (hint: initialize the restic repository, or run the restic server with --init-repositories)",
+		},
+		{
+			name:          "error checking repository goes ahead with backup",
+			existsErr:     errors.New("error running command"),
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+			expectBackup:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+			td.pvb.Spec.RepoPrefix = "s3:s3.amazonaws.com/bucket"
+
+			td.controller.repositoryExistsFunc = func(_ context.Context, cmd *restic.Command) (bool, error) {
+				assert.Equal(t, "cat", cmd.Command)
+				assert.Equal(t, "s3:s3.amazonaws.com/bucket", cmd.RepoPrefix)
+				assert.Equal(t, "ns-1", cmd.Repo)
+				return test.exists, test.existsErr
+			}
+
+			backedUp := false
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				backedUp = true
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = func(context.Context, *restic.Command) (string, error) {
+				return "snapshot-1", nil
+			}
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, test.expectBackup, backedUp)
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedMessage, td.pvb.Status.Message)
+		})
+	}
+}
+
+func TestProcessBackupResticConfig(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+	td.controller.resticBinary = "/usr/local/bin/restic"
+	td.controller.resticGlobalFlags = []string{"--cache-dir=/scratch/restic", "--cleanup-cache"}
+
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+	}
+	td.withBackupPrerequisites(pod, "vol-1")
+
+	td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+	td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+	td.pvb.Spec.Volume = "vol-1"
+
+	var (
+		backupArgs     []string
+		catConfigArgs  []string
+		snapshotIDArgs []string
+		expectedPrefix = []string{"/usr/local/bin/restic", "--cache-dir=/scratch/restic", "--cleanup-cache"}
+		prefix         = func(args []string) []string { return args[:len(expectedPrefix)] }
+	)
+	td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+		backupArgs = cmd.Args
+		return "", "", nil
+	}
+	td.controller.repositoryExistsFunc = func(_ context.Context, cmd *restic.Command) (bool, error) {
+		catConfigArgs = cmd.StringSlice()
+		return true, nil
+	}
+	td.controller.getSnapshotIDFunc = func(_ context.Context, cmd *restic.Command) (string, error) {
+		snapshotIDArgs = cmd.StringSlice()
+		return "snapshot-1", nil
+	}
+
+	require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+	assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+
+	assert.Equal(t, expectedPrefix, prefix(backupArgs))
+	assert.Equal(t, "backup", backupArgs[len(expectedPrefix)])
+	assert.Equal(t, expectedPrefix, prefix(catConfigArgs))
+	assert.Equal(t, "cat", catConfigArgs[len(expectedPrefix)])
+	assert.Equal(t, expectedPrefix, prefix(snapshotIDArgs))
+	assert.Equal(t, "snapshots", snapshotIDArgs[len(expectedPrefix)])
+}
+
+func TestProcessBackupResticEnv(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+	td.controller.resticEnv = []string{"HTTPS_PROXY=http://proxy:3128", "SSL_CERT_FILE=/certs/ca.pem"}
+
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+	}
+	td.withBackupPrerequisites(pod, "vol-1")
+
+	td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+	td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+	td.pvb.Spec.Volume = "vol-1"
+
+	var backupEnv, catConfigEnv, snapshotIDEnv []string
+	td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+		backupEnv = cmd.Env
+		return "", "", nil
+	}
+	td.controller.repositoryExistsFunc = func(ctx context.Context, cmd *restic.Command) (bool, error) {
+		catConfigEnv = cmd.CmdContext(ctx).Env
+		return true, nil
+	}
+	td.controller.getSnapshotIDFunc = func(_ context.Context, cmd *restic.Command) (string, error) {
+		snapshotIDEnv = cmd.Cmd().Env
+		return "snapshot-1", nil
+	}
+
+	require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+	assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+
+	// the variables are added to, rather than replacing, the server's
+	// environment.
+	for _, env := range [][]string{backupEnv, catConfigEnv, snapshotIDEnv} {
+		assert.Equal(t, os.Environ(), env[:len(os.Environ())])
+		assert.Equal(t, td.controller.resticEnv, env[len(os.Environ()):])
+	}
+}
+
+func TestProcessBackupResticCache(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheDir     string
+		cacheEnabled bool
+		sharedCache  bool
+		expectedFlag string
+	}{
+		{
+			name:         "cache dir is scoped to the namespace's repo",
+			cacheDir:     "/scratch/restic-cache",
+			cacheEnabled: true,
+			expectedFlag: "--cache-dir=/scratch/restic-cache/ns-1",
+		},
+		{
+			name:         "disabling the cache passes --no-cache",
+			cacheDir:     "/scratch/restic-cache",
+			expectedFlag: "--no-cache",
+		},
+		{
+			name:         "shared cache dir is keyed by the repository's URL",
+			cacheDir:     "/scratch/restic-cache",
+			cacheEnabled: true,
+			sharedCache:  true,
+			expectedFlag: "--cache-dir=/scratch/restic-cache/shared/" + restic.SharedCacheKey("", "ns-1"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.resticCacheDir = test.cacheDir
+			td.controller.resticCacheEnabled = test.cacheEnabled
+			td.controller.resticSharedCache = test.sharedCache
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+
+			var backupArgs, snapshotIDArgs []string
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				backupArgs = cmd.Args
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = func(_ context.Context, cmd *restic.Command) (string, error) {
+				snapshotIDArgs = cmd.StringSlice()
+				return "snapshot-1", nil
+			}
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+			assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+
+			assert.Contains(t, backupArgs, test.expectedFlag)
+			assert.Contains(t, snapshotIDArgs, test.expectedFlag)
+		})
+	}
+}
+
+func TestProcessBackupThrottling(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+	td.controller.resticLimitUpload = 1024
+	td.controller.resticBackupIOClass = "idle"
+
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+	}
+	td.withBackupPrerequisites(pod, "vol-1")
+
+	td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+	td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+	td.pvb.Spec.Volume = "vol-1"
+
+	var backupArgs, snapshotIDArgs []string
+	td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+		backupArgs = cmd.Args
+		return "", "", nil
+	}
+	td.controller.getSnapshotIDFunc = func(_ context.Context, cmd *restic.Command) (string, error) {
+		snapshotIDArgs = cmd.StringSlice()
+		return "snapshot-1", nil
+	}
+
+	require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+	assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+
+	// only the backup itself is throttled
+	require.True(t, len(backupArgs) > 3)
+	assert.Equal(t, []string{"ionice", "-c3", "/restic", "backup"}, backupArgs[:4])
+	assert.Contains(t, backupArgs, "--limit-upload=1024")
+
+	assert.Equal(t, "/restic", snapshotIDArgs[0])
+	assert.NotContains(t, snapshotIDArgs, "--limit-upload=1024")
+}
+
+func TestProcessBackupOneFileSystem(t *testing.T) {
+	for _, oneFileSystem := range []bool{false, true} {
+		t.Run(fmt.Sprintf("oneFileSystem=%t", oneFileSystem), func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.resticOneFileSystem = oneFileSystem
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+
+			var backupArgs []string
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				backupArgs = cmd.Args
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+			assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+
+			if oneFileSystem {
+				assert.Contains(t, backupArgs, "--one-file-system")
+			} else {
+				assert.NotContains(t, backupArgs, "--one-file-system")
+			}
+		})
+	}
+}
+
+func TestProcessBackupReadConcurrency(t *testing.T) {
+	for _, readConcurrency := range []int{0, 8} {
+		t.Run(fmt.Sprintf("readConcurrency=%d", readConcurrency), func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.resticReadConcurrency = readConcurrency
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+
+			var backupArgs []string
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				backupArgs = cmd.Args
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+			assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+
+			if readConcurrency > 0 {
+				assert.Contains(t, backupArgs, "--read-concurrency=8")
+			} else {
+				for _, arg := range backupArgs {
+					assert.False(t, strings.HasPrefix(arg, "--read-concurrency"), "unexpected flag %s", arg)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessBackupHost(t *testing.T) {
+	for _, host := range []string{"", "cluster-1"} {
+		t.Run(fmt.Sprintf("host=%q", host), func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.resticHost = host
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+
+			var backupArgs []string
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				backupArgs = cmd.Args
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+			assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+
+			if host != "" {
+				assert.Contains(t, backupArgs, "--host=cluster-1")
+			} else {
+				for _, arg := range backupArgs {
+					assert.False(t, strings.HasPrefix(arg, "--host"), "unexpected flag %s", arg)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessBackupDryRun(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+	td.controller.dryRun = true
+
+	logger := logrus.New()
+	logs := new(bytes.Buffer)
+	logger.Out = logs
+	td.controller.logger = logger
+
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+	}
+	td.withBackupPrerequisites(pod, "vol-1")
+
+	td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+	td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+	td.pvb.Spec.Volume = "vol-1"
+	td.pvb.Spec.RepoPrefix = "s3:s3.amazonaws.com/bucket"
+
+	td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+		t.Error("unexpected call to run command")
+		return "", "", nil
+	}
+	td.controller.repositoryExistsFunc = func(context.Context, *restic.Command) (bool, error) {
+		t.Error("unexpected call to check repository existence")
+		return true, nil
+	}
+	td.controller.getSnapshotIDFunc = func(context.Context, *restic.Command) (string, error) {
+		t.Error("unexpected call to get snapshot ID")
+		return "", nil
+	}
+
+	require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+	assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompletedDryRun, td.pvb.Status.Phase)
+	assert.Equal(t, "/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1", td.pvb.Status.Path)
+	assert.Empty(t, td.pvb.Status.SnapshotID)
+	assert.Contains(t, logs.String(), "Dry run: not running restic backup")
+	assert.Contains(t, logs.String(), "/restic backup --repo=s3:s3.amazonaws.com/bucket/ns-1")
+	assert.Contains(t, logs.String(), "/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1")
+}
+
+func TestProcessBackupReusesCredentialsFile(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+	defer td.controller.credentialsFiles.Clear()
+
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+	}
+	td.withBackupPrerequisites(pod, "vol-1")
+
+	var passwordFiles []string
+	td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+		for _, arg := range cmd.Args {
+			if strings.HasPrefix(arg, "--password-file=") {
+				passwordFiles = append(passwordFiles, strings.TrimPrefix(arg, "--password-file="))
+			}
+		}
+		return "", "", nil
+	}
+	td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+	runBackup := func(name string) {
+		td.pvb = newTestPodVolumeBackup(name, "node-1")
+		td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+		td.pvb.Spec.Volume = "vol-1"
+
+		require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+		require.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+	}
+
+	// the second backup in the namespace reuses the first's file
+	runBackup("pvb-1")
+	runBackup("pvb-2")
+	require.Len(t, passwordFiles, 2)
+	assert.Equal(t, passwordFiles[0], passwordFiles[1])
+
+	// changes to other secrets don't affect the cache
+	td.controller.secretHandler(&corev1api.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "some-other-secret"},
+	})
+	runBackup("pvb-3")
+	require.Len(t, passwordFiles, 3)
+	assert.Equal(t, passwordFiles[0], passwordFiles[2])
+
+	// a change to the credentials secret removes the file, and the next
+	// backup writes the updated key to a new one
+	updated := &corev1api.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: restic.CredentialsSecretName},
+		Data:       map[string][]byte{restic.CredentialsKey: []byte("new-password")},
+	}
+	require.NoError(t, td.kubeInformers.Core().V1().Secrets().Informer().GetStore().Update(updated))
+	td.controller.secretHandler(updated)
+
+	_, err := os.Stat(passwordFiles[0])
+	assert.True(t, os.IsNotExist(err))
+
+	runBackup("pvb-4")
+	require.Len(t, passwordFiles, 4)
+	assert.NotEqual(t, passwordFiles[0], passwordFiles[3])
+
+	data, err := ioutil.ReadFile(passwordFiles[3])
+	require.NoError(t, err)
+	assert.Equal(t, "new-password", string(data))
+
+	// a change to a secret referenced by a backup removes its file too
+	storeSecret := &corev1api.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "store"},
+		Data:       map[string][]byte{restic.CredentialsKey: []byte("store-password")},
+	}
+	require.NoError(t, td.kubeInformers.Core().V1().Secrets().Informer().GetStore().Add(storeSecret))
+
+	storeFile, err := td.controller.credentialsFiles.GetForSecret("ns-1", "store")
+	require.NoError(t, err)
+
+	td.controller.secretHandler(storeSecret)
+	_, err = os.Stat(storeFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestProcessBackupSnapshotStats(t *testing.T) {
+	tests := []struct {
+		name                      string
+		volumes                   []string
+		statsErr                  error
+		expectedSnapshotSize      int64
+		expectedSnapshotFileCount int64
+	}{
+		{
+			name:                      "single volume",
+			volumes:                   []string{"vol-1"},
+			expectedSnapshotSize:      1024,
+			expectedSnapshotFileCount: 10,
+		},
+		{
+			name:                      "stats are summed across volumes",
+			volumes:                   []string{"vol-1", "vol-2"},
+			expectedSnapshotSize:      2048,
+			expectedSnapshotFileCount: 20,
+		},
+		{
+			name:     "stats errors leave fields empty",
+			volumes:  []string{"vol-1", "vol-2"},
+			statsErr: errors.New("restic stats failed"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, test.volumes...)
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volumes = test.volumes
+
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			var statsSnapshots []string
+			td.controller.getSnapshotStatsFunc = func(_ context.Context, cmd *restic.Command) (restic.SnapshotStats, error) {
+				statsSnapshots = append(statsSnapshots, cmd.Args...)
+				if test.statsErr != nil {
+					return restic.SnapshotStats{}, test.statsErr
+				}
+				return restic.SnapshotStats{TotalSize: 1024, TotalFileCount: 10}, nil
+			}
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedSnapshotSize, td.pvb.Status.SnapshotSize)
+			assert.Equal(t, test.expectedSnapshotFileCount, td.pvb.Status.SnapshotFileCount)
+
+			if test.statsErr == nil {
+				var expectedSnapshots []string
+				for _, volume := range test.volumes {
+					expectedSnapshots = append(expectedSnapshots, "snapshot-"+volume)
+				}
+				sort.Strings(statsSnapshots)
+				assert.Equal(t, expectedSnapshots, statsSnapshots)
+			}
+		})
+	}
+}
+
+func TestSnapshotStatsStopsWhenContextIsDone(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+
+	td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+	td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: "ns-1", Name: "pod-1"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the context is done once the first snapshot's stats are retrieved,
+	// so the second snapshot's aren't.
+	calls := 0
+	td.controller.getSnapshotStatsFunc = func(context.Context, *restic.Command) (restic.SnapshotStats, error) {
+		calls++
+		cancel()
+		return restic.SnapshotStats{TotalSize: 1024, TotalFileCount: 10}, nil
+	}
+
+	snapshotIDs := map[string]string{"vol-1": "snapshot-vol-1", "vol-2": "snapshot-vol-2"}
+	stats := td.controller.snapshotStats(ctx, td.pvb, "creds", snapshotIDs, td.controller.logger)
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, restic.SnapshotStats{}, stats)
+}
+
+func TestFailOrphanedBackups(t *testing.T) {
+	tests := []struct {
+		name            string
+		node            string
+		phase           arkv1api.PodVolumeBackupPhase
+		expectedPhase   arkv1api.PodVolumeBackupPhase
+		expectedMessage string
+	}{
+		{
+			name:            "new backup for a nonexistent node is failed",
+			node:            "node-gone",
+			phase:           arkv1api.PodVolumeBackupPhaseNew,
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedMessage: "node node-gone no longer exists, so no restic server is available to run the backup",
+		},
+		{
+			name:            "backup with no phase for a nonexistent node is failed",
+			node:            "node-gone",
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedMessage: "node node-gone no longer exists, so no restic server is available to run the backup",
+		},
+		{
+			name:          "new backup for an existing node is left alone",
+			node:          "node-2",
+			phase:         arkv1api.PodVolumeBackupPhaseNew,
+			expectedPhase: arkv1api.PodVolumeBackupPhaseNew,
+		},
+		{
+			name:          "in-progress backup for a nonexistent node is left alone",
+			node:          "node-gone",
+			phase:         arkv1api.PodVolumeBackupPhaseInProgress,
+			expectedPhase: arkv1api.PodVolumeBackupPhaseInProgress,
+		},
+		{
+			name:          "completed backup for a nonexistent node is left alone",
+			node:          "node-gone",
+			phase:         arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+
+			for _, name := range []string{"node-1", "node-2"} {
+				require.NoError(t, td.kubeInformers.Core().V1().Nodes().Informer().GetStore().Add(&corev1api.Node{
+					ObjectMeta: metav1.ObjectMeta{Name: name},
+				}))
+			}
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", test.node)
+			td.pvb.Status.Phase = test.phase
+			require.NoError(t, td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore().Add(td.pvb.DeepCopy()))
+
+			td.controller.failOrphanedBackups()
+
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedMessage, td.pvb.Status.Message)
+			if test.expectedPhase == arkv1api.PodVolumeBackupPhaseFailed {
+				assert.Equal(t, arkv1api.PodVolumeBackupFailureReasonNodeNotFound, td.pvb.Status.FailureReason)
+				assert.Equal(t, []string{"Warning BackupFailed Backup failed (NodeNotFound): " + test.expectedMessage}, td.eventRecorder.Events)
+			} else {
+				assert.Empty(t, td.pvb.Status.FailureReason)
+				assert.Empty(t, td.eventRecorder.Events)
+			}
+		})
+	}
+}
+
+func TestProcessBackupFailureReason(t *testing.T) {
+	tests := []struct {
+		name           string
+		volume         string
+		repoMissing    bool
+		stderr         string
+		expectedReason arkv1api.PodVolumeBackupFailureReason
+		expectedHint   string
+	}{
+		{
+			name:           "repository not initialized",
+			volume:         "vol-1",
+			repoMissing:    true,
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonRepoNotFound,
+			expectedHint:   " (hint: initialize the restic repository, or run the restic server with --init-repositories)",
+		},
+		{
+			name:           "volume missing from pod",
+			volume:         "missing",
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonVolumeNotFound,
+		},
+		{
+			name:           "repository locked",
+			volume:         "vol-1",
+			stderr:         "unable to create lock in backend: repository is already locked by PID 42 on host-1 by root (UID 0, GID 0)",
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonLockTimeout,
+			expectedHint:   " (hint: if no other restic command is using the repository, run restic unlock on it, or run the restic server with --unlock-stale-locks)",
+		},
+		{
+			name:           "unreadable file in volume",
+			volume:         "vol-1",
+			stderr:         "error: open /host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1/data.db: permission denied",
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonPermissionDenied,
+			expectedHint:   " (hint: verify that the restic server's object store credentials can read and write the repository)",
+		},
+		{
+			name:           "wrong password",
+			volume:         "vol-1",
+			stderr:         "Fatal: wrong password or no key found",
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonAuthFailed,
+			expectedHint:   " (hint: verify that secret ns-1/ark-restic-credentials exists and its ark-restic-credentials key holds the repository's password)",
+		},
+		{
+			name:           "unrecognized restic error",
+			volume:         "vol-1",
+			stderr:         "Fatal: invalid id \"abc\": no matching ID found",
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonUnknown,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = test.volume
+
+			td.controller.repositoryExistsFunc = func(context.Context, *restic.Command) (bool, error) {
+				return !test.repoMissing, nil
+			}
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				return "", test.stderr, errors.New("exit status 1")
+			}
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, arkv1api.PodVolumeBackupPhaseFailed, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedReason, td.pvb.Status.FailureReason)
+			assert.NotEmpty(t, td.pvb.Status.Message)
+
+			// only recognized failures have a remediation hint.
+			if test.expectedHint != "" {
+				assert.True(t, strings.HasSuffix(td.pvb.Status.Message, test.expectedHint), td.pvb.Status.Message)
+			} else {
+				assert.NotContains(t, td.pvb.Status.Message, "(hint: ")
+			}
+		})
+	}
+}
+
+func TestProcessBackupResticExitCode(t *testing.T) {
+	tests := []struct {
+		name               string
+		exitCode           int
+		policy             IncompleteSnapshotPolicy
+		expectedPhase      arkv1api.PodVolumeBackupPhase
+		expectedReason     arkv1api.PodVolumeBackupFailureReason
+		expectedIncomplete bool
+		expectedFailures   float64
+	}{
+		{
+			name:               "incomplete snapshot is kept with the warn policy",
+			exitCode:           3,
+			policy:             IncompleteSnapshotPolicyWarn,
+			expectedPhase:      arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedIncomplete: true,
+		},
+		{
+			name:             "incomplete snapshot fails with the fail policy",
+			exitCode:         3,
+			policy:           IncompleteSnapshotPolicyFail,
+			expectedPhase:    arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason:   arkv1api.PodVolumeBackupFailureReasonIncompleteSnapshot,
+			expectedFailures: 1,
+		},
+		{
+			name:             "fatal error",
+			exitCode:         1,
+			policy:           IncompleteSnapshotPolicyWarn,
+			expectedPhase:    arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason:   arkv1api.PodVolumeBackupFailureReasonUnknown,
+			expectedFailures: 1,
+		},
+		{
+			name:             "repository does not exist",
+			exitCode:         10,
+			policy:           IncompleteSnapshotPolicyWarn,
+			expectedPhase:    arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason:   arkv1api.PodVolumeBackupFailureReasonRepoNotFound,
+			expectedFailures: 1,
+		},
+		{
+			name:             "wrong password",
+			exitCode:         12,
+			policy:           IncompleteSnapshotPolicyWarn,
+			expectedPhase:    arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason:   arkv1api.PodVolumeBackupFailureReasonAuthFailed,
+			expectedFailures: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.incompletePolicy = test.policy
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				err := exec.Command("sh", "-c", fmt.Sprintf("exit %d", test.exitCode)).Run()
+				return "", "", err
+			}
+			td.controller.getSnapshotIDFunc = func(context.Context, *restic.Command) (string, error) {
+				return "snapshot-1", nil
+			}
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedReason, td.pvb.Status.FailureReason)
+			assert.Equal(t, test.exitCode, td.pvb.Status.ExitCode)
+			assert.Equal(t, test.expectedIncomplete, td.pvb.Status.Incomplete)
+			assert.Equal(t, test.expectedFailures, labeledMetricValue(t, td.controller.metrics, "ark_pod_volume_backup_failure_total", "exit_code", strconv.Itoa(test.exitCode)))
+
+			if test.expectedIncomplete {
+				assert.Equal(t, "snapshot-1", td.pvb.Status.SnapshotID)
+				assert.Contains(t, td.pvb.Status.Message, "volume vol-1: snapshot is incomplete")
+			}
+		})
+	}
+}
+
+func TestResticExitCode(t *testing.T) {
+	resticErr := &restic.Error{Kind: restic.ErrAuth, ExitCode: 12, Err: errors.New("exit status 12")}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{
+			name:     "restic error",
+			err:      resticErr,
+			expected: 12,
+		},
+		{
+			name:     "wrapped volume backup error",
+			err:      errors.Wrap(newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonAuthFailed, errors.Wrap(resticErr, "error running restic backup")), "volume vol-1"),
+			expected: 12,
+		},
+		{
+			name:     "incomplete snapshot",
+			err:      &incompleteSnapshotError{error: &restic.Error{Kind: restic.ErrIncompleteSnapshot, ExitCode: 3}},
+			expected: 3,
+		},
+		{
+			name:     "restic didn't exit",
+			err:      &restic.Error{Kind: restic.ErrNetwork, ExitCode: -1},
+			expected: 0,
+		},
+		{
+			name:     "not a restic error",
+			err:      newVolumeBackupError(arkv1api.PodVolumeBackupFailureReasonVolumeNotFound, errors.New("volume not found")),
+			expected: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, resticExitCode(test.err))
+		})
+	}
+}
+
+// configMapsGetter returns the same ConfigMaps client for every namespace.
+type configMapsGetter struct {
+	configMaps corev1client.ConfigMapInterface
+}
+
+func (g configMapsGetter) ConfigMaps(string) corev1client.ConfigMapInterface {
+	return g.configMaps
+}
+
+func TestProcessBackupRetention(t *testing.T) {
+	const volumeDir = "/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1"
+
+	tests := []struct {
+		name              string
+		retention         restic.KeepPolicy
+		policy            string
+		backupErr         error
+		forgetErr         error
+		expectedPhase     arkv1api.PodVolumeBackupPhase
+		expectedFlags     []string
+		expectedForgotten int
+	}{
+		{
+			name:          "retention is disabled by default",
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+		{
+			name:              "snapshots of the volume's path are forgotten",
+			retention:         restic.KeepPolicy{Last: 3},
+			expectedPhase:     arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedFlags:     []string{"--json", "--keep-last=3", "--path=" + volumeDir, "--group-by=paths"},
+			expectedForgotten: 2,
+		},
+		{
+			name:              "only snapshots with the backup's policy are forgotten",
+			retention:         restic.KeepPolicy{Last: 3, Daily: 7},
+			policy:            "weekly",
+			expectedPhase:     arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedFlags:     []string{"--json", "--keep-last=3", "--keep-daily=7", "--path=" + volumeDir, "--tag=policy=weekly", "--group-by=paths"},
+			expectedForgotten: 2,
+		},
+		{
+			name:          "an error forgetting snapshots doesn't fail the backup",
+			retention:     restic.KeepPolicy{Last: 3},
+			forgetErr:     errors.New("exit status 1"),
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedFlags: []string{"--json", "--keep-last=3", "--path=" + volumeDir, "--group-by=paths"},
+		},
+		{
+			name:          "snapshots aren't forgotten when the backup fails",
+			retention:     restic.KeepPolicy{Last: 3},
+			backupErr:     errors.New("exit status 1"),
+			expectedPhase: arkv1api.PodVolumeBackupPhaseFailed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.retention = test.retention
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+			td.pvb.Spec.Policy = test.policy
+
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				return "", "", test.backupErr
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			var forgetCmd *restic.Command
+			td.controller.forgetByPolicyFunc = func(_ context.Context, cmd *restic.Command) (int, error) {
+				forgetCmd = cmd
+				if test.forgetErr != nil {
+					return 0, test.forgetErr
+				}
+				return 2, nil
+			}
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedForgotten, td.pvb.Status.ForgottenSnapshots)
+			if test.expectedFlags == nil {
+				assert.Nil(t, forgetCmd)
+				return
+			}
+			require.NotNil(t, forgetCmd)
+			assert.Equal(t, "forget", forgetCmd.Command)
+			assert.Equal(t, "ns-1", forgetCmd.Repo)
+			assert.NotEmpty(t, forgetCmd.PasswordFile)
+			assert.Equal(t, test.expectedFlags, forgetCmd.ExtraFlags)
+		})
+	}
+}
+
+func TestProcessBackupStoresLogs(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxSize       int64
+		existing      bool
+		backupErr     error
+		expectedPhase arkv1api.PodVolumeBackupPhase
+		expectedData  map[string]string
+	}{
+		{
+			name:          "output of a successful backup is stored",
+			maxSize:       1024,
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedData: map[string]string{
+				"vol-1.stdout": "snapshot abc saved",
+				"vol-1.stderr": "warning: file changed during backup",
+			},
+		},
+		{
+			name:          "output of a failed backup is stored",
+			maxSize:       1024,
+			backupErr:     errors.New("exit status 1"),
+			expectedPhase: arkv1api.PodVolumeBackupPhaseFailed,
+			expectedData: map[string]string{
+				"vol-1.stdout": "snapshot abc saved",
+				"vol-1.stderr": "warning: file changed during backup",
+			},
+		},
+		{
+			name:          "long output is truncated",
+			maxSize:       30,
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedData: map[string]string{
+				"vol-1.stdout": "snapshot abc saved",
+				"vol-1.stderr": "[truncated 26 bytes]\nng backup",
+			},
+		},
+		{
+			name:          "a previous run's output is replaced",
+			maxSize:       1024,
+			existing:      true,
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedData: map[string]string{
+				"vol-1.stdout": "snapshot abc saved",
+				"vol-1.stderr": "warning: file changed during backup",
+			},
+		},
+		{
+			name:          "output isn't stored without a size limit",
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+
+			configMaps := arktest.NewFakeConfigMaps()
+			td.controller.configMaps = configMapsGetter{configMaps}
+			td.controller.backupLogsMaxSize = test.maxSize
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.UID = "pvb-uid"
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+
+			if test.existing {
+				_, err := configMaps.Create(&corev1api.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Namespace: td.pvb.Namespace, Name: "pvb-1-restic-logs"},
+					Data:       map[string]string{"vol-1.stderr": "Fatal: unable to create lock"},
+				})
+				require.NoError(t, err)
+			}
+
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				return "snapshot abc saved", "warning: file changed during backup", test.backupErr
+			}
+			td.controller.getSnapshotIDFunc = func(context.Context, *restic.Command) (string, error) {
+				return "snapshot-1", nil
+			}
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+
+			configMap, err := configMaps.Get("pvb-1-restic-logs", metav1.GetOptions{})
+			if test.expectedData == nil {
+				assert.True(t, apierrors.IsNotFound(err))
+				assert.Empty(t, td.pvb.Status.LogsConfigMap)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, "pvb-1-restic-logs", td.pvb.Status.LogsConfigMap)
+			assert.Equal(t, test.expectedData, configMap.Data)
+			if !test.existing {
+				require.Len(t, configMap.OwnerReferences, 1)
+				assert.Equal(t, "PodVolumeBackup", configMap.OwnerReferences[0].Kind)
+				assert.Equal(t, "pvb-1", configMap.OwnerReferences[0].Name)
+				assert.Equal(t, types.UID("pvb-uid"), configMap.OwnerReferences[0].UID)
+			}
+		})
+	}
+}
+
+func TestProcessBackupPasswordRotated(t *testing.T) {
+	tests := []struct {
+		name              string
+		rotationWindow    time.Duration
+		credentialsSecret *corev1api.SecretReference
+		changedSecret     string
+		changedKey        string
+		changedAgo        time.Duration
+		expectedReason    arkv1api.PodVolumeBackupFailureReason
+		expectedMessage   string
+	}{
+		{
+			name:            "password changed within the rotation window",
+			rotationWindow:  time.Hour,
+			changedSecret:   restic.CredentialsSecretName,
+			changedKey:      restic.CredentialsKey,
+			changedAgo:      10 * time.Minute,
+			expectedReason:  arkv1api.PodVolumeBackupFailureReasonPasswordRotated,
+			expectedMessage: "password rotated: the repository password in secret ns-1/ark-restic-credentials changed at 2018-06-01T11:50:00Z, but the restic repository doesn't accept it",
+		},
+		{
+			name:              "referenced secret's password changed within the rotation window",
+			rotationWindow:    time.Hour,
+			credentialsSecret: &corev1api.SecretReference{Name: "offsite"},
+			changedSecret:     "offsite",
+			changedKey:        restic.CredentialsKey,
+			changedAgo:        10 * time.Minute,
+			expectedReason:    arkv1api.PodVolumeBackupFailureReasonPasswordRotated,
+			expectedMessage:   "password rotated: the repository password in secret ns-1/offsite changed at 2018-06-01T11:50:00Z",
+		},
+		{
+			name:           "password changed before the rotation window",
+			rotationWindow: time.Hour,
+			changedSecret:  restic.CredentialsSecretName,
+			changedKey:     restic.CredentialsKey,
+			changedAgo:     2 * time.Hour,
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonAuthFailed,
+		},
+		{
+			name:           "password unchanged",
+			rotationWindow: time.Hour,
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonAuthFailed,
+		},
+		{
+			name:           "other key of the secret changed",
+			rotationWindow: time.Hour,
+			changedSecret:  restic.CredentialsSecretName,
+			changedKey:     "AWS_ACCESS_KEY_ID",
+			changedAgo:     10 * time.Minute,
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonAuthFailed,
+		},
+		{
+			name:              "another secret's password changed",
+			rotationWindow:    time.Hour,
+			credentialsSecret: &corev1api.SecretReference{Name: "offsite"},
+			changedSecret:     restic.CredentialsSecretName,
+			changedKey:        restic.CredentialsKey,
+			changedAgo:        10 * time.Minute,
+			expectedReason:    arkv1api.PodVolumeBackupFailureReasonAuthFailed,
+		},
+		{
+			name:           "rotation detection disabled",
+			changedSecret:  restic.CredentialsSecretName,
+			changedKey:     restic.CredentialsKey,
+			changedAgo:     10 * time.Minute,
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonAuthFailed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.rotationWindow = test.rotationWindow
+
+			now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+			fakeClock := clock.NewFakeClock(now.Add(-test.changedAgo))
+			td.controller.clock = fakeClock
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+			td.kubeInformers.Core().V1().Secrets().Informer().GetStore().Add(&corev1api.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "offsite"},
+				Data:       map[string][]byte{restic.CredentialsKey: []byte("password")},
+			})
+
+			if test.changedSecret != "" {
+				oldSecret := &corev1api.Secret{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: test.changedSecret},
+					Data:       map[string][]byte{restic.CredentialsKey: []byte("password")},
+				}
+				newSecret := oldSecret.DeepCopy()
+				newSecret.Data[test.changedKey] = []byte("new-value")
+				td.controller.recordPasswordChange(oldSecret, newSecret)
+			}
+			fakeClock.SetTime(now)
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+			td.pvb.Spec.CredentialsSecret = test.credentialsSecret
+
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				return "", "Fatal: wrong password or no key found", errors.New("exit status 1")
+			}
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, arkv1api.PodVolumeBackupPhaseFailed, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedReason, td.pvb.Status.FailureReason)
+			if test.expectedMessage != "" {
+				assert.True(t, strings.HasPrefix(td.pvb.Status.Message, test.expectedMessage), td.pvb.Status.Message)
+				assert.Contains(t, td.pvb.Status.Message, "(hint: restic repositories keep their passwords when the secret changes")
+			} else {
+				assert.NotContains(t, td.pvb.Status.Message, "password rotated")
+			}
+		})
+	}
+}
+
+func TestRemediationHint(t *testing.T) {
+	tests := []struct {
+		name              string
+		reason            arkv1api.PodVolumeBackupFailureReason
+		credentialsSecret *corev1api.SecretReference
+		passwordFile      string
+		expected          string
+	}{
+		{
+			name:     "wrong password from the namespace's credentials secret",
+			reason:   arkv1api.PodVolumeBackupFailureReasonAuthFailed,
+			expected: "verify that secret ns-1/ark-restic-credentials exists and its ark-restic-credentials key holds the repository's password",
+		},
+		{
+			name:              "wrong password from the pod volume backup's credentials secret",
+			reason:            arkv1api.PodVolumeBackupFailureReasonAuthFailed,
+			credentialsSecret: &corev1api.SecretReference{Namespace: "creds", Name: "offsite"},
+			expected:          "verify that secret creds/offsite exists and its ark-restic-credentials key holds the repository's password",
+		},
+		{
+			name:              "credentials secret defaults to the pod's namespace",
+			reason:            arkv1api.PodVolumeBackupFailureReasonAuthFailed,
+			credentialsSecret: &corev1api.SecretReference{Name: "offsite"},
+			expected:          "verify that secret ns-1/offsite exists and its ark-restic-credentials key holds the repository's password",
+		},
+		{
+			name:         "wrong password from a password file",
+			reason:       arkv1api.PodVolumeBackupFailureReasonAuthFailed,
+			passwordFile: "/credentials/restic-password",
+			expected:     "verify the repository password given by the restic server's --restic-password-file or --restic-password-command",
+		},
+		{
+			name:     "password rotated",
+			reason:   arkv1api.PodVolumeBackupFailureReasonPasswordRotated,
+			expected: "restic repositories keep their passwords when the secret changes, so add the new password to the repository with restic key add and remove the old one with restic key remove, or restore the previous password to secret ns-1/ark-restic-credentials",
+		},
+		{
+			name:     "volume not mounted",
+			reason:   arkv1api.PodVolumeBackupFailureReasonVolumeNotMounted,
+			expected: "verify that the volume is attached to the pod, or increase the restic server's --volume-mount-timeout",
+		},
+		{
+			name:     "host path not allowed",
+			reason:   arkv1api.PodVolumeBackupFailureReasonHostPathNotAllowed,
+			expected: "add the volume's path to the restic server's --host-path-allow-list",
+		},
+		{
+			name:   "no hint for an invalid spec",
+			reason: arkv1api.PodVolumeBackupFailureReasonInvalidSpec,
+		},
+		{
+			name:   "no hint for an unknown failure",
+			reason: arkv1api.PodVolumeBackupFailureReasonUnknown,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.resticPasswordFile = test.passwordFile
+
+			req := newTestPodVolumeBackup("pvb-1", "node-1")
+			req.Spec.Pod = corev1api.ObjectReference{Namespace: "ns-1", Name: "pod-1"}
+			req.Spec.CredentialsSecret = test.credentialsSecret
+
+			assert.Equal(t, test.expected, td.controller.remediationHint(req, test.reason))
+
+			msg := td.controller.withRemediationHint(req, test.reason, "backup failed")
+			if test.expected == "" {
+				assert.Equal(t, "backup failed", msg)
+			} else {
+				assert.Equal(t, "backup failed (hint: "+test.expected+")", msg)
+			}
+		})
+	}
+}
+
+func TestProcessBackupExcludePatterns(t *testing.T) {
+	tests := []struct {
+		name                 string
+		excludePatterns      []string
+		excludeLargerThan    string
+		featureGates         restic.FeatureGates
+		expectedPhase        arkv1api.PodVolumeBackupPhase
+		expectedExcludeFlags []string
+		expectedMessage      string
+	}{
+		{
+			name:          "no exclude patterns",
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+		{
+			name:                 "exclude patterns are passed to restic",
+			excludePatterns:      []string{"/data/cache", "*.tmp"},
+			expectedPhase:        arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedExcludeFlags: []string{"--exclude=/data/cache", "--exclude=*.tmp"},
+		},
+		{
+			name:            "empty exclude pattern fails the backup",
+			excludePatterns: []string{"*.tmp", ""},
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedMessage: "invalid exclude patterns: exclude pattern 1 is empty",
+		},
+		{
+			name:                 "exclude-larger-than size is passed to restic",
+			excludePatterns:      []string{"*.tmp"},
+			excludeLargerThan:    "500M",
+			expectedPhase:        arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedExcludeFlags: []string{"--exclude=*.tmp", "--exclude-larger-than=500M"},
+		},
+		{
+			name:              "invalid exclude-larger-than size fails the backup",
+			excludeLargerThan: "500 MB",
+			expectedPhase:     arkv1api.PodVolumeBackupPhaseFailed,
+			expectedMessage:   `invalid excludeLargerThan: size "500 MB" must be a number of bytes, optionally followed by one of the suffixes k, m, g or t`,
+		},
+		{
+			name:                 "exclude-larger-than size is passed to a restic version that supports it",
+			excludeLargerThan:    "1G",
+			featureGates:         restic.FeatureGates{restic.FeatureExcludeLargerThan: true},
+			expectedPhase:        arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedExcludeFlags: []string{"--exclude-larger-than=1G"},
+		},
+		{
+			name:              "exclude-larger-than size fails the backup if restic doesn't support it",
+			excludeLargerThan: "1G",
+			featureGates:      restic.FeatureGates{restic.FeatureExcludeLargerThan: false},
+			expectedPhase:     arkv1api.PodVolumeBackupPhaseFailed,
+			expectedMessage:   "excludeLargerThan requires restic 0.9.6 or later",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+			td.pvb.Spec.ExcludePatterns = test.excludePatterns
+			td.pvb.Spec.ExcludeLargerThan = test.excludeLargerThan
+			td.controller.featureGates = test.featureGates
+
+			var (
+				ran          bool
+				excludeFlags []string
+			)
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				ran = true
+				for _, arg := range cmd.Args {
+					if strings.HasPrefix(arg, "--exclude") {
+						excludeFlags = append(excludeFlags, arg)
+					}
+				}
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedMessage, td.pvb.Status.Message)
+			assert.Equal(t, test.expectedExcludeFlags, excludeFlags)
+
+			if test.expectedPhase == arkv1api.PodVolumeBackupPhaseFailed {
+				assert.False(t, ran, "restic should not be run")
+				assert.Equal(t, arkv1api.PodVolumeBackupFailureReasonInvalidSpec, td.pvb.Status.FailureReason)
+			}
+		})
+	}
+}
+
+func TestProcessBackupSubPath(t *testing.T) {
+	const volumeDir = "/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1"
+
+	tests := []struct {
+		name            string
+		subPath         string
+		volumes         []string
+		directories     []string
+		symlinks        map[string]string
+		expectedPhase   arkv1api.PodVolumeBackupPhase
+		expectedReason  arkv1api.PodVolumeBackupFailureReason
+		expectedMessage string
+		expectedPath    string
+		expectedSubPath string
+	}{
+		{
+			name:            "subPath of the volume is backed up",
+			subPath:         "data/app-1",
+			directories:     []string{volumeDir + "/data/app-1"},
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedPath:    volumeDir + "/data/app-1",
+			expectedSubPath: "data/app-1",
+		},
+		{
+			name:            "subPath is cleaned",
+			subPath:         "/data//app-1/",
+			directories:     []string{volumeDir + "/data/app-1"},
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedPath:    volumeDir + "/data/app-1",
+			expectedSubPath: "data/app-1",
+		},
+		{
+			name:            "subPath outside of the volume fails the backup",
+			subPath:         "../vol-2",
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason:  arkv1api.PodVolumeBackupFailureReasonInvalidSpec,
+			expectedMessage: "invalid subPath: subPath ../vol-2 must not contain '..'",
+		},
+		{
+			name:            "subPath with several volumes fails the backup",
+			subPath:         "data",
+			volumes:         []string{"vol-1", "vol-2"},
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason:  arkv1api.PodVolumeBackupFailureReasonInvalidSpec,
+			expectedMessage: "subPath can only be set when a single volume is backed up, but 2 are selected",
+		},
+		{
+			name:            "missing subPath fails the backup",
+			subPath:         "data/app-2",
+			directories:     []string{volumeDir + "/data/app-1"},
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason:  arkv1api.PodVolumeBackupFailureReasonVolumeNotFound,
+			expectedMessage: "volume vol-1: subPath data/app-2 not found in volume directory " + volumeDir,
+		},
+		{
+			name:            "subPath that's a symlink out of the volume fails the backup",
+			subPath:         "data/app-1",
+			directories:     []string{volumeDir + "/data/app-1"},
+			symlinks:        map[string]string{volumeDir + "/data/app-1": "/etc"},
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason:  arkv1api.PodVolumeBackupFailureReasonInvalidSpec,
+			expectedMessage: "volume vol-1: subPath data/app-1 resolves to /etc, which is outside of the volume",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1", "vol-2")
+			for _, dir := range test.directories {
+				td.fileSystem.WithDirectory(dir)
+			}
+			td.controller.evalSymlinksFunc = func(path string) (string, error) {
+				if target, ok := test.symlinks[path]; ok {
+					return target, nil
+				}
+				return path, nil
+			}
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			if test.volumes != nil {
+				td.pvb.Spec.Volumes = test.volumes
+			} else {
+				td.pvb.Spec.Volume = "vol-1"
+			}
+			td.pvb.Spec.SubPath = test.subPath
+
+			var backupArgs []string
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				backupArgs = cmd.Args
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedReason, td.pvb.Status.FailureReason)
+			if test.expectedMessage != "" {
+				assert.True(t, strings.HasPrefix(td.pvb.Status.Message, test.expectedMessage), td.pvb.Status.Message)
+			}
+			assert.Equal(t, test.expectedPath, td.pvb.Status.Path)
+			assert.Equal(t, test.expectedSubPath, td.pvb.Status.SubPath)
+
+			if test.expectedPhase != arkv1api.PodVolumeBackupPhaseCompleted {
+				assert.Nil(t, backupArgs, "restic should not be run")
+				return
+			}
+			assert.Contains(t, backupArgs, test.expectedPath)
+			assert.Contains(t, backupArgs, "--tag=sub-path="+test.expectedSubPath)
+		})
+	}
+}
+
+func TestProcessBackupSubPathExpr(t *testing.T) {
+	const volumeDir = "/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1"
+
+	tests := []struct {
+		name            string
+		subPath         string
+		subPathExpr     string
+		volumes         []string
+		expectedPhase   arkv1api.PodVolumeBackupPhase
+		expectedMessage string
+		expectedSubPath string
+	}{
+		{
+			name:            "subPathExpr is resolved from the container's downward API environment and recorded",
+			subPathExpr:     "logs/$(POD_NAME)",
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedSubPath: "logs/app-0",
+		},
+		{
+			name:            "subPathExpr referencing a variable that can't be resolved fails the backup",
+			subPathExpr:     "logs/$(TENANT)",
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedMessage: "invalid subPathExpr: subPathExpr logs/$(TENANT) references environment variables of container app whose values can't be resolved: TENANT",
+		},
+		{
+			name:            "subPathExpr with subPath fails the backup",
+			subPath:         "logs/app-0",
+			subPathExpr:     "logs/$(POD_NAME)",
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedMessage: "subPath and subPathExpr can't both be set",
+		},
+		{
+			name:            "subPathExpr with several volumes fails the backup",
+			subPathExpr:     "logs/$(POD_NAME)",
+			volumes:         []string{"vol-1", "vol-2"},
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedMessage: "subPathExpr can only be set when a single volume is backed up, but 2 are selected",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "app-0",
+					UID:       "pod-uid",
+				},
+				Spec: corev1api.PodSpec{
+					Containers: []corev1api.Container{
+						{
+							Name: "app",
+							Env: []corev1api.EnvVar{
+								{
+									Name:      "POD_NAME",
+									ValueFrom: &corev1api.EnvVarSource{FieldRef: &corev1api.ObjectFieldSelector{FieldPath: "metadata.name"}},
+								},
+								{
+									Name:      "TENANT",
+									ValueFrom: &corev1api.EnvVarSource{SecretKeyRef: &corev1api.SecretKeySelector{Key: "tenant"}},
+								},
+							},
+							VolumeMounts: []corev1api.VolumeMount{{Name: "vol-1", MountPath: "/logs"}},
+						},
+					},
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1", "vol-2")
+			td.fileSystem.WithDirectory(volumeDir + "/logs/app-0")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			if test.volumes != nil {
+				td.pvb.Spec.Volumes = test.volumes
+			} else {
+				td.pvb.Spec.Volume = "vol-1"
+			}
+			td.pvb.Spec.SubPath = test.subPath
+			td.pvb.Spec.SubPathExpr = test.subPathExpr
+
+			var backupArgs []string
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				backupArgs = cmd.Args
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedSubPath, td.pvb.Status.SubPath)
+
+			if test.expectedPhase != arkv1api.PodVolumeBackupPhaseCompleted {
+				assert.Equal(t, arkv1api.PodVolumeBackupFailureReasonInvalidSpec, td.pvb.Status.FailureReason)
+				assert.True(t, strings.HasPrefix(td.pvb.Status.Message, test.expectedMessage), td.pvb.Status.Message)
+				assert.Nil(t, backupArgs, "restic should not be run")
+				return
+			}
+			assert.Equal(t, volumeDir+"/"+test.expectedSubPath, td.pvb.Status.Path)
+			assert.Contains(t, backupArgs, volumeDir+"/"+test.expectedSubPath)
+			assert.Contains(t, backupArgs, "--tag=sub-path="+test.expectedSubPath)
+		})
+	}
+}
+
+func TestProcessBackupRepoPrefixTemplate(t *testing.T) {
+	tests := []struct {
+		name               string
+		repoPrefix         string
+		expectedPhase      arkv1api.PodVolumeBackupPhase
+		expectedMessage    string
+		expectedRepo       string
+		expectedRepoPrefix string
+	}{
+		{
+			name:          "plain prefix is used as-is",
+			repoPrefix:    "s3:s3.amazonaws.com/bucket/restic",
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedRepo:  "--repo=s3:s3.amazonaws.com/bucket/restic/ns-1",
+		},
+		{
+			name:               "template is resolved from the pod's metadata and recorded",
+			repoPrefix:         `s3:s3.amazonaws.com/bucket/{{index .Annotations "example.com/cluster"}}/{{.Labels.team}}`,
+			expectedPhase:      arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedRepo:       "--repo=s3:s3.amazonaws.com/bucket/prod-east/payments/ns-1",
+			expectedRepoPrefix: "s3:s3.amazonaws.com/bucket/prod-east/payments",
+		},
+		{
+			name:            "template that resolves to an invalid prefix fails the backup",
+			repoPrefix:      "s3:s3.amazonaws.com/bucket/{{.Labels.tier}}/restic",
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedMessage: "invalid repoPrefix: repository prefix template s3:s3.amazonaws.com/bucket/{{.Labels.tier}}/restic resolved to an invalid prefix: prefix s3:s3.amazonaws.com/bucket//restic must not contain empty path segments",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "ns-1",
+					Name:        "pod-1",
+					UID:         "pod-uid",
+					Labels:      map[string]string{"team": "payments"},
+					Annotations: map[string]string{"example.com/cluster": "prod-east"},
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+			td.pvb.Spec.RepoPrefix = test.repoPrefix
+
+			var backupArgs []string
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				backupArgs = cmd.Args
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedRepoPrefix, td.pvb.Status.RepoPrefix)
+			assert.Equal(t, test.repoPrefix, td.pvb.Spec.RepoPrefix)
+
+			if test.expectedPhase != arkv1api.PodVolumeBackupPhaseCompleted {
+				assert.Equal(t, arkv1api.PodVolumeBackupFailureReasonInvalidSpec, td.pvb.Status.FailureReason)
+				assert.True(t, strings.HasPrefix(td.pvb.Status.Message, test.expectedMessage), td.pvb.Status.Message)
+				assert.Nil(t, backupArgs, "restic should not be run")
+				return
+			}
+			assert.Contains(t, backupArgs, test.expectedRepo)
+		})
+	}
+}
+
+func TestProcessBackupPolicy(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          string
+		tags            map[string]string
+		expectedTag     string
+		expectedMessage string
+	}{
+		{
+			name: "no policy",
+		},
+		{
+			name:        "snapshot is tagged with its policy",
+			policy:      "weekly",
+			expectedTag: "policy=weekly",
+		},
+		{
+			name:        "policy overrides a policy tag",
+			policy:      "weekly",
+			tags:        map[string]string{"policy": "daily"},
+			expectedTag: "policy=weekly",
+		},
+		{
+			name:            "invalid policy fails the backup",
+			policy:          "daily,weekly",
+			expectedMessage: `invalid policy: policy "daily,weekly" must be 1-63 alphanumeric characters, '-', '_' or '.', and start and end with an alphanumeric character`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.skipUnchangedVolumes = true
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+			td.pvb.Spec.Tags = test.tags
+			td.pvb.Spec.Policy = test.policy
+
+			var backupArgs []string
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				backupArgs = cmd.Args
+				return "", "", nil
+			}
+
+			// the first lookup is for an unchanged volume's existing
+			// snapshot, which isn't found, and the second is for the new
+			// snapshot.
+			var lookups [][]string
+			lookup := func(ctx context.Context, cmd *restic.Command) (string, error) {
+				for _, flag := range cmd.ExtraFlags {
+					if strings.HasPrefix(flag, "--tag=") {
+						lookups = append(lookups, strings.Split(strings.TrimPrefix(flag, "--tag="), ","))
+					}
+				}
+				if len(lookups) == 1 {
+					return "", &restic.SnapshotCountError{Count: 0}
+				}
+				return fakeVolumeSnapshotID(ctx, cmd)
+			}
+			td.controller.getLatestSnapshotIDFunc = lookup
+			td.controller.getSnapshotIDFunc = lookup
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			if test.expectedMessage != "" {
+				assert.Equal(t, arkv1api.PodVolumeBackupPhaseFailed, td.pvb.Status.Phase)
+				assert.Equal(t, arkv1api.PodVolumeBackupFailureReasonInvalidSpec, td.pvb.Status.FailureReason)
+				assert.Equal(t, test.expectedMessage, td.pvb.Status.Message)
+				assert.Nil(t, backupArgs, "restic should not be run")
+				return
+			}
+
+			assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+			require.Len(t, lookups, 2)
+
+			// both the backup and the lookups use the policy tag, if any.
+			policyTags := func(tags []string, prefix string) []string {
+				var res []string
+				for _, tag := range tags {
+					if strings.HasPrefix(tag, prefix+"policy=") {
+						res = append(res, strings.TrimPrefix(tag, prefix))
+					}
+				}
+				return res
+			}
+			var expected []string
+			if test.expectedTag != "" {
+				expected = []string{test.expectedTag}
+			}
+			assert.Equal(t, expected, policyTags(backupArgs, "--tag="))
+			for _, lookup := range lookups {
+				assert.Equal(t, expected, policyTags(lookup, ""))
+			}
+		})
+	}
+}
+
+func TestProcessBackupWaitsForSnapshot(t *testing.T) {
+	tests := []struct {
+		name                string
+		snapshotWaitTimeout time.Duration
+		listedAfter         int
+		expectedPhase       arkv1api.PodVolumeBackupPhase
+		expectedMessage     string
+		expectedCalls       int
+	}{
+		{
+			name:                "snapshot that's listed immediately",
+			snapshotWaitTimeout: time.Minute,
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedCalls:       1,
+		},
+		{
+			name:                "snapshot that's listed after a delay",
+			snapshotWaitTimeout: time.Minute,
+			listedAfter:         2,
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedCalls:       3,
+		},
+		{
+			name:            "snapshot that isn't listed fails the backup without waiting if the timeout is zero",
+			listedAfter:     2,
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedMessage: "volume vol-1: error getting snapshot id: expected one matching snapshot, got 0 (hint: if the repository's object store is eventually consistent, increase the restic server's --snapshot-wait-timeout)",
+			expectedCalls:   1,
+		},
+		{
+			name:                "snapshot that isn't listed within the timeout fails the backup",
+			snapshotWaitTimeout: 50 * time.Millisecond,
+			listedAfter:         1000000,
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseFailed,
+			expectedMessage:     "volume vol-1: error getting snapshot id: snapshot was not listed by restic within 50ms: expected one matching snapshot, got 0 (hint: if the repository's object store is eventually consistent, increase the restic server's --snapshot-wait-timeout)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.snapshotWaitTimeout = test.snapshotWaitTimeout
+			td.controller.snapshotPollInterval = time.Millisecond
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				return "", "", nil
+			}
+
+			// the snapshot isn't listed until it's been looked up
+			// listedAfter times.
+			var calls int
+			td.controller.getSnapshotIDFunc = func(ctx context.Context, cmd *restic.Command) (string, error) {
+				calls++
+				if calls <= test.listedAfter {
+					return "", &restic.SnapshotCountError{Count: 0}
+				}
+				return fakeVolumeSnapshotID(ctx, cmd)
+			}
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			if test.expectedCalls > 0 {
+				assert.Equal(t, test.expectedCalls, calls)
+			}
+			if test.expectedPhase == arkv1api.PodVolumeBackupPhaseCompleted {
+				assert.Equal(t, "snapshot-vol-1", td.pvb.Status.SnapshotID)
+			} else {
+				assert.Equal(t, arkv1api.PodVolumeBackupFailureReasonSnapshotNotFound, td.pvb.Status.FailureReason)
+				assert.Equal(t, test.expectedMessage, td.pvb.Status.Message)
+			}
+		})
+	}
+}
+
+func TestProcessBackupSnapshotIDFailures(t *testing.T) {
+	tests := []struct {
+		name            string
+		getSnapshotID   func(context.Context, *restic.Command) (string, error)
+		expectedReason  arkv1api.PodVolumeBackupFailureReason
+		expectedMessage string
+	}{
+		{
+			name: "restic that doesn't finish within the timeout",
+			getSnapshotID: func(ctx context.Context, _ *restic.Command) (string, error) {
+				<-ctx.Done()
+				return "", errors.Wrap(&restic.Error{Kind: restic.ErrNetwork, ExitCode: -1, Err: ctx.Err()}, "error running command")
+			},
+			expectedReason:  arkv1api.PodVolumeBackupFailureReasonRepoUnreachable,
+			expectedMessage: "volume vol-1: error getting snapshot id: restic snapshots did not finish within 50ms: error running command: stderr=: context deadline exceeded (hint: verify that the restic server can reach the repository's object store)",
+		},
+		{
+			name: "unreachable repository",
+			getSnapshotID: func(context.Context, *restic.Command) (string, error) {
+				return "", errors.Wrap(restic.NewError(errors.New("exit status 1"), "Fatal: dial tcp 10.0.0.1:443: connect: connection refused"), "error running command")
+			},
+			expectedReason:  arkv1api.PodVolumeBackupFailureReasonRepoUnreachable,
+			expectedMessage: "volume vol-1: error getting snapshot id: error running command: stderr=Fatal: dial tcp 10.0.0.1:443: connect: connection refused: exit status 1 (hint: verify that the restic server can reach the repository's object store)",
+		},
+		{
+			name: "no matching snapshot",
+			getSnapshotID: func(context.Context, *restic.Command) (string, error) {
+				return "", errors.WithStack(&restic.SnapshotCountError{Count: 0})
+			},
+			expectedReason:  arkv1api.PodVolumeBackupFailureReasonSnapshotNotFound,
+			expectedMessage: "volume vol-1: error getting snapshot id: expected one matching snapshot, got 0 (hint: if the repository's object store is eventually consistent, increase the restic server's --snapshot-wait-timeout)",
+		},
+		{
+			name: "several matching snapshots",
+			getSnapshotID: func(context.Context, *restic.Command) (string, error) {
+				return "", errors.WithStack(&restic.SnapshotCountError{Count: 2})
+			},
+			expectedReason:  arkv1api.PodVolumeBackupFailureReasonUnknown,
+			expectedMessage: "volume vol-1: error getting snapshot id: expected one matching snapshot, got 2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.snapshotIDTimeout = 50 * time.Millisecond
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = test.getSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, arkv1api.PodVolumeBackupPhaseFailed, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedReason, td.pvb.Status.FailureReason)
+			assert.Equal(t, test.expectedMessage, td.pvb.Status.Message)
+		})
+	}
+}
+
+func TestProcessBackupCircuitBreaker(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+
+	fakeClock := clock.NewFakeClock(time.Now())
+	cb := NewCircuitBreaker(2, time.Minute).(*circuitBreaker)
+	cb.clock = fakeClock
+	td.controller.circuitBreaker = cb
+
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+	}
+	td.withBackupPrerequisites(pod, "vol-1")
+
+	var (
+		repoExists bool
+		ranRestic  bool
+	)
+	td.controller.repositoryExistsFunc = func(context.Context, *restic.Command) (bool, error) {
+		ranRestic = true
+		return repoExists, nil
+	}
+	td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+		return "", "", nil
+	}
+	td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+	processBackup := func() {
+		ranRestic = false
+		td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+		td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+		td.pvb.Spec.Volume = "vol-1"
+		td.pvb.Spec.RepoPrefix = "s3:s3.amazonaws.com/bucket"
+
+		require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+	}
+
+	// the circuit opens after two failures because the repository is broken
+	processBackup()
+	assert.Equal(t, arkv1api.PodVolumeBackupFailureReasonRepoNotFound, td.pvb.Status.FailureReason)
+	processBackup()
+	assert.Equal(t, arkv1api.PodVolumeBackupFailureReasonRepoNotFound, td.pvb.Status.FailureReason)
+	assert.Equal(t, CircuitOpen, cb.State("s3:s3.amazonaws.com/bucket/ns-1"))
+
+	// while it's open, backups fail without running restic
+	processBackup()
+	assert.False(t, ranRestic)
+	assert.Equal(t, arkv1api.PodVolumeBackupPhaseFailed, td.pvb.Status.Phase)
+	assert.Equal(t, arkv1api.PodVolumeBackupFailureReasonCircuitOpen, td.pvb.Status.FailureReason)
+	assert.Contains(t, td.pvb.Status.Message, "circuit open: recent backups to restic repository s3:s3.amazonaws.com/bucket/ns-1 failed")
+	assert.Contains(t, td.pvb.Status.Message, fakeClock.Now().Add(time.Minute).UTC().Format(time.RFC3339))
+
+	// once it's half-open, a failed backup reopens it
+	fakeClock.Step(time.Minute)
+	processBackup()
+	assert.True(t, ranRestic)
+	assert.Equal(t, arkv1api.PodVolumeBackupFailureReasonRepoNotFound, td.pvb.Status.FailureReason)
+	assert.Equal(t, CircuitOpen, cb.State("s3:s3.amazonaws.com/bucket/ns-1"))
+
+	// and a successful one closes it
+	fakeClock.Step(time.Minute)
+	repoExists = true
+	processBackup()
+	assert.True(t, ranRestic)
+	assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+	assert.Equal(t, CircuitClosed, cb.State("s3:s3.amazonaws.com/bucket/ns-1"))
+}
+
+func TestRunWaitsForInFlightBackupsOnShutdown(t *testing.T) {
+	sleepPath, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep command not available")
+	}
+
+	tests := []struct {
+		name                string
+		shutdownGracePeriod time.Duration
+		backupDuration      time.Duration
+		expectedPhase       arkv1api.PodVolumeBackupPhase
+		expectedMessage     string
+		expectUnlock        bool
+	}{
+		{
+			name:                "backup that finishes within the grace period completes",
+			shutdownGracePeriod: 10 * time.Second,
+			backupDuration:      200 * time.Millisecond,
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+		{
+			name:                "backup still running after the grace period is killed and the repo unlocked",
+			shutdownGracePeriod: 100 * time.Millisecond,
+			backupDuration:      30 * time.Second,
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseCanceled,
+			expectedMessage:     "backup canceled because the restic server shut down before it completed",
+			expectUnlock:        true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.shutdownGracePeriod = test.shutdownGracePeriod
+			// the informers aren't running in this test
+			td.controller.cacheSyncWaiters = nil
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+			require.NoError(t, td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore().Add(td.pvb.DeepCopy()))
+
+			started := make(chan struct{})
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				close(started)
+				cmd.Path = sleepPath
+				cmd.Args = []string{"sleep", fmt.Sprintf("%.1f", test.backupDuration.Seconds())}
+				return runCommand(cmd)
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			var unlockArgs []string
+			td.controller.unlockRepoFunc = func(cmd *restic.Command) error {
+				unlockArgs = cmd.StringSlice()
+				return nil
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			runErr := make(chan error)
+			go func() {
+				runErr <- td.controller.Run(ctx, 1)
+			}()
+
+			td.controller.queue.Add(kube.NamespaceAndName(td.pvb))
+
+			select {
+			case <-started:
+			case <-time.After(10 * time.Second):
+				t.Fatal("backup was not started")
+			}
+			cancel()
+
+			select {
+			case err := <-runErr:
+				require.NoError(t, err)
+			case <-time.After(10 * time.Second):
+				t.Fatal("Run did not return after shutdown")
+			}
+
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedMessage, td.pvb.Status.Message)
+			if test.expectUnlock {
+				require.NotEmpty(t, unlockArgs)
+				assert.Equal(t, "unlock", unlockArgs[1])
+			} else {
+				assert.Empty(t, unlockArgs)
+			}
+		})
+	}
+}
+
+func TestProcessQueueItemDuringShutdown(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+
+	pvb := newTestPodVolumeBackup("pvb-1", "node-1")
+	require.NoError(t, td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore().Add(pvb))
+
+	processed := false
+	td.controller.processBackupFunc = func(context.Context, *arkv1api.PodVolumeBackup) error {
+		processed = true
+		return nil
+	}
+
+	// no backups are running, so this returns immediately
+	td.controller.waitForInFlightBackups()
+
+	require.NoError(t, td.controller.processQueueItem(kube.NamespaceAndName(pvb)))
+	assert.False(t, processed, "backup should not be started while shutting down")
+}
+
+func TestProcessBackupUnlockStaleLocks(t *testing.T) {
+	tests := []struct {
+		name             string
+		unlockStaleLocks bool
+		unlockErr        error
+		expectedCalls    []string
+	}{
+		{
+			name:          "locks are left alone by default",
+			expectedCalls: []string{"backup"},
+		},
+		{
+			name:             "stale locks are removed before the backup",
+			unlockStaleLocks: true,
+			expectedCalls:    []string{"unlock", "backup"},
+		},
+		{
+			name:             "an error removing stale locks doesn't fail the backup",
+			unlockStaleLocks: true,
+			unlockErr:        errors.New("restic unlock failed"),
+			expectedCalls:    []string{"unlock", "backup"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.unlockStaleLocks = test.unlockStaleLocks
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+
+			var calls []string
+			td.controller.unlockRepoFunc = func(cmd *restic.Command) error {
+				calls = append(calls, cmd.Command)
+				// only stale locks may be removed
+				assert.NotContains(t, cmd.ExtraFlags, "--remove-all")
+				return test.unlockErr
+			}
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				calls = append(calls, "backup")
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedCalls, calls)
+		})
+	}
+}
+
+func TestProcessBackupVolumeSelector(t *testing.T) {
+	tests := []struct {
+		name                string
+		volume              string
+		volumeSelector      *metav1.LabelSelector
+		excludedVolumes     string
+		expectedPhase       arkv1api.PodVolumeBackupPhase
+		expectedSnapshotIDs map[string]string
+		expectedReason      arkv1api.PodVolumeBackupFailureReason
+		expectedMessage     string
+	}{
+		{
+			name:                "volumes whose PVCs match the selector are backed up",
+			volumeSelector:      &metav1.LabelSelector{MatchLabels: map[string]string{"backup": "true"}},
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedSnapshotIDs: map[string]string{"data": "snapshot-data", "logs": "snapshot-logs"},
+		},
+		{
+			name:                "selector with expressions",
+			volumeSelector:      &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"db"}}}},
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedSnapshotIDs: map[string]string{"data": "snapshot-data"},
+		},
+		{
+			name:                "excluded volumes are not backed up",
+			volumeSelector:      &metav1.LabelSelector{MatchLabels: map[string]string{"backup": "true"}},
+			excludedVolumes:     "logs,cache",
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedSnapshotIDs: map[string]string{"data": "snapshot-data"},
+		},
+		{
+			name:            "excluding all matching volumes fails the backup",
+			volumeSelector:  &metav1.LabelSelector{MatchLabels: map[string]string{"backup": "true"}},
+			excludedVolumes: "data,logs",
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason:  arkv1api.PodVolumeBackupFailureReasonVolumeNotFound,
+			expectedMessage: "error getting volumes to back up: no volumes in the pod match the volume selector backup=true",
+		},
+		{
+			name:                "explicit volume takes precedence over the selector",
+			volume:              "scratch",
+			volumeSelector:      &metav1.LabelSelector{MatchLabels: map[string]string{"backup": "true"}},
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedSnapshotIDs: map[string]string{"scratch": "snapshot-scratch"},
+		},
+		{
+			name:            "no matching volumes fails the backup",
+			volumeSelector:  &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason:  arkv1api.PodVolumeBackupFailureReasonVolumeNotFound,
+			expectedMessage: "error getting volumes to back up: no volumes in the pod match the volume selector app=web",
+		},
+		{
+			name:           "invalid selector fails the backup",
+			volumeSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Bogus"}}},
+			expectedPhase:  arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonInvalidSpec,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			if test.excludedVolumes != "" {
+				pod.Annotations = map[string]string{restic.VolumesToExcludeAnnotation: test.excludedVolumes}
+			}
+
+			// data and logs are backed by labeled PVCs, scratch and cache
+			// aren't.
+			pvcs := []struct {
+				volume string
+				labels map[string]string
+			}{
+				{volume: "data", labels: map[string]string{"backup": "true", "app": "db"}},
+				{volume: "logs", labels: map[string]string{"backup": "true"}},
+				{volume: "cache", labels: map[string]string{"app": "cache"}},
+			}
+			for _, pvc := range pvcs {
+				pod.Spec.Volumes = append(pod.Spec.Volumes, corev1api.Volume{
+					Name: pvc.volume,
+					VolumeSource: corev1api.VolumeSource{
+						PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "pvc-" + pvc.volume},
+					},
+				})
+
+				require.NoError(t, td.kubeInformers.Core().V1().PersistentVolumeClaims().Informer().GetStore().Add(&corev1api.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace: pod.Namespace,
+						Name:      "pvc-" + pvc.volume,
+						Labels:    pvc.labels,
+					},
+					Spec: corev1api.PersistentVolumeClaimSpec{VolumeName: "pv-" + pvc.volume},
+				}))
+
+				td.fileSystem.WithDirectory(fmt.Sprintf("/host_pods/pod-uid/volumes/kubernetes.io~aws-ebs/pv-%s", pvc.volume))
+			}
+			td.withBackupPrerequisites(pod, "scratch")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = test.volume
+			td.pvb.Spec.VolumeSelector = test.volumeSelector
+
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedSnapshotIDs, td.pvb.Status.SnapshotIDs)
+			assert.Equal(t, test.expectedReason, td.pvb.Status.FailureReason)
+			if test.expectedMessage != "" {
+				assert.Equal(t, test.expectedMessage, td.pvb.Status.Message)
+			}
+		})
+	}
+}
+
+func TestDirSizeExceeds(t *testing.T) {
+	fileSystem := arktest.NewFakeFileSystem().
+		WithDirectories("/volume/dir", "/volume/empty").
+		WithFile("/volume/a", make([]byte, 100)).
+		WithFile("/volume/dir/b", make([]byte, 50))
+
+	tests := []struct {
+		name     string
+		limit    int64
+		expected bool
+	}{
+		{name: "under the limit", limit: 1000, expected: false},
+		{name: "exactly the limit", limit: 150, expected: false},
+		{name: "over the limit", limit: 149, expected: true},
+		{name: "a single file over the limit", limit: 10, expected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exceeded, err := dirSizeExceeds(fileSystem, "/volume", test.limit)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, exceeded)
+		})
+	}
+
+	_, err := dirSizeExceeds(fileSystem, "/missing", 10)
+	assert.Error(t, err)
+}
+
+func TestDirHasNoFiles(t *testing.T) {
+	fileSystem := arktest.NewFakeFileSystem().
+		WithDirectories("/empty", "/dirs-only/lost+found", "/dirs-only/a/b", "/nested/dir").
+		WithFile("/nested/dir/file", []byte("data"))
+
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{path: "/empty", expected: true},
+		{path: "/dirs-only", expected: true},
+		{path: "/nested", expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			empty, err := dirHasNoFiles(fileSystem, test.path)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, empty)
+		})
+	}
+
+	_, err := dirHasNoFiles(fileSystem, "/missing")
+	assert.Error(t, err)
+}
+
+func TestProcessBackupSkipEmptyVolumes(t *testing.T) {
+	const volumesDir = "/host_pods/pod-uid/volumes/kubernetes.io~empty-dir"
+
+	tests := []struct {
+		name                string
+		skipEmptyVolumes    bool
+		files               []string
+		directories         []string
+		expectedSnapshotIDs map[string]string
+		expectedMessage     string
+	}{
+		{
+			name:                "empty volumes are backed up when disabled",
+			expectedSnapshotIDs: map[string]string{"vol-1": "snapshot-vol-1", "vol-2": "snapshot-vol-2"},
+		},
+		{
+			name:                "empty volume is skipped",
+			skipEmptyVolumes:    true,
+			files:               []string{volumesDir + "/vol-2/data"},
+			expectedSnapshotIDs: map[string]string{"vol-2": "snapshot-vol-2"},
+			expectedMessage:     "volume vol-1: skipped empty volume, no snapshot taken",
+		},
+		{
+			name:                "volume with only empty directories is skipped",
+			skipEmptyVolumes:    true,
+			files:               []string{volumesDir + "/vol-2/data"},
+			directories:         []string{volumesDir + "/vol-1/lost+found"},
+			expectedSnapshotIDs: map[string]string{"vol-2": "snapshot-vol-2"},
+			expectedMessage:     "volume vol-1: skipped empty volume, no snapshot taken",
+		},
+		{
+			name:                "volumes with files are backed up",
+			skipEmptyVolumes:    true,
+			files:               []string{volumesDir + "/vol-1/dir/data", volumesDir + "/vol-2/data"},
+			expectedSnapshotIDs: map[string]string{"vol-1": "snapshot-vol-1", "vol-2": "snapshot-vol-2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			defer td.controller.credentialsFiles.Clear()
+			td.controller.skipEmptyVolumes = test.skipEmptyVolumes
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1", "vol-2")
+			td.fileSystem.WithDirectories(test.directories...)
+			for _, file := range test.files {
+				td.fileSystem.WithFile(file, []byte("data"))
+			}
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volumes = []string{"vol-1", "vol-2"}
+
+			var resticBackups int
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				resticBackups++
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedSnapshotIDs, td.pvb.Status.SnapshotIDs)
+			assert.Equal(t, test.expectedMessage, td.pvb.Status.Message)
+			assert.Equal(t, len(test.expectedSnapshotIDs), resticBackups)
+		})
+	}
+}
+
+func TestProcessBackupCSIEphemeralVolume(t *testing.T) {
+	const (
+		csiVolumeDir      = "/host_pods/pod-uid/volumes/kubernetes.io~csi/inline/mount"
+		emptyDirVolumeDir = "/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1"
+	)
+
+	tests := []struct {
+		name                string
+		directories         []string
+		expectedPaths       []string
+		expectedSnapshotIDs map[string]string
+		expectedMessage     string
+	}{
+		{
+			name:                "mounted CSI ephemeral volume is backed up from its mount directory",
+			directories:         []string{csiVolumeDir},
+			expectedPaths:       []string{csiVolumeDir, emptyDirVolumeDir},
+			expectedSnapshotIDs: map[string]string{"inline": "snapshot-inline", "vol-1": "snapshot-vol-1"},
+		},
+		{
+			name:                "volume that can't be resolved is skipped",
+			directories:         []string{"/host_pods/pod-uid/volumes/example.com~unknown/inline"},
+			expectedPaths:       []string{emptyDirVolumeDir},
+			expectedSnapshotIDs: map[string]string{"vol-1": "snapshot-vol-1"},
+			expectedMessage:     "volume inline: skipped, volume's type is unknown and it isn't a mounted CSI ephemeral volume: volume directory matching /host_pods/pod-uid/volumes/*/inline/mount was not mounted within 0s",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			defer td.controller.credentialsFiles.Clear()
+
+			// a CSI ephemeral volume's csi volume source isn't known to
+			// this version of the API, so it has no volume source.
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+				Spec: corev1api.PodSpec{
+					Volumes: []corev1api.Volume{{Name: "inline"}},
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+			td.fileSystem.WithDirectories(test.directories...)
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volumes = []string{"inline", "vol-1"}
+
+			var paths []string
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				for _, arg := range cmd.Args {
+					if strings.HasPrefix(arg, "/host_pods/") {
+						paths = append(paths, arg)
+					}
+				}
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedPaths, paths)
+			assert.Equal(t, test.expectedSnapshotIDs, td.pvb.Status.SnapshotIDs)
+			assert.Equal(t, test.expectedMessage, td.pvb.Status.Message)
+		})
+	}
+}
+
+func TestProcessBackupPostBackupHook(t *testing.T) {
+	tests := []struct {
+		name              string
+		hook              string
+		failurePolicy     PostBackupHookFailurePolicy
+		hookErr           error
+		expectHook        bool
+		expectedPhase     arkv1api.PodVolumeBackupPhase
+		expectedReason    arkv1api.PodVolumeBackupFailureReason
+		expectedHookEvent string
+	}{
+		{
+			name:          "no hook is run if none is configured",
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+		{
+			name:          "hook is run after the snapshot is taken",
+			hook:          "notify",
+			failurePolicy: PostBackupHookFailurePolicyWarn,
+			expectHook:    true,
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+		{
+			name:              "hook failure doesn't fail the backup by default",
+			hook:              "notify",
+			failurePolicy:     PostBackupHookFailurePolicyWarn,
+			hookErr:           errors.New("exit status 1"),
+			expectHook:        true,
+			expectedPhase:     arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedHookEvent: "Warning PostBackupHookFailed Post-backup hook failed for volume vol-1: error running post-backup hook: notification failed: exit status 1",
+		},
+		{
+			name:           "hook failure fails the backup with the fail policy",
+			hook:           "notify",
+			failurePolicy:  PostBackupHookFailurePolicyFail,
+			hookErr:        errors.New("exit status 1"),
+			expectHook:     true,
+			expectedPhase:  arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonPostBackupHookFailed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			defer td.controller.credentialsFiles.Clear()
+			td.controller.postBackupHook = test.hook
+			td.controller.hookFailurePolicy = test.failurePolicy
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Labels = map[string]string{arkv1api.BackupNameLabel: "backup-1"}
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.RepoPrefix = "s3:bucket"
+			td.pvb.Spec.Volume = "vol-1"
+
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				return "", "", nil
+			}
+			var gotSnapshotID bool
+			td.controller.getSnapshotIDFunc = func(ctx context.Context, cmd *restic.Command) (string, error) {
+				gotSnapshotID = true
+				return fakeVolumeSnapshotID(ctx, cmd)
+			}
+
+			var hookCmds []*exec.Cmd
+			td.controller.runHookFunc = func(cmd *exec.Cmd) (string, string, error) {
+				// the hook is only run once the snapshot's been taken.
+				assert.True(t, gotSnapshotID)
+				hookCmds = append(hookCmds, cmd)
+				if test.hookErr != nil {
+					return "", "notification failed\n", test.hookErr
+				}
+				return "", "", nil
+			}
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedReason, td.pvb.Status.FailureReason)
+
+			if !test.expectHook {
+				assert.Empty(t, hookCmds)
+				return
+			}
+
+			require.Len(t, hookCmds, 1)
+			assert.Equal(t, []string{"/bin/sh", "-c", "notify", "post-backup-hook", "snapshot-vol-1"}, hookCmds[0].Args)
+
+			env := hookCmds[0].Env[len(hookCmds[0].Env)-11:]
+			assert.Equal(t, []string{
+				"ARK_SNAPSHOT_ID=snapshot-vol-1",
+				"ARK_REPO_PREFIX=s3:bucket",
+				"ARK_REPO=ns-1",
+				"ARK_BACKUP=backup-1",
+				"ARK_POD_VOLUME_BACKUP=" + arkv1api.DefaultNamespace + "/pvb-1",
+				"ARK_POD_NAMESPACE=ns-1",
+				"ARK_POD_NAME=pod-1",
+				"ARK_POD_UID=pod-uid",
+				"ARK_VOLUME=vol-1",
+				"ARK_VOLUME_PATH=/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1",
+				"ARK_NODE_NAME=node-1",
+			}, env)
+
+			var hookEvents []string
+			for _, event := range td.eventRecorder.Events {
+				if strings.HasPrefix(event, "Warning "+eventReasonPostBackupHookFailed+" ") {
+					hookEvents = append(hookEvents, event)
+				}
+			}
+			if test.expectedHookEvent != "" {
+				assert.Equal(t, []string{test.expectedHookEvent}, hookEvents)
+			} else {
+				assert.Empty(t, hookEvents)
+			}
+		})
+	}
+}
+
+func TestProcessBackupMaxVolumeSize(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxVolumeSize   int64
+		expectedPhase   arkv1api.PodVolumeBackupPhase
+		expectedReason  arkv1api.PodVolumeBackupFailureReason
+		expectedMessage string
+		expectRestic    bool
+	}{
+		{
+			name:          "no limit",
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+			expectRestic:  true,
+		},
+		{
+			name:          "volume under the limit is backed up",
+			maxVolumeSize: 2048,
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+			expectRestic:  true,
+		},
+		{
+			name:            "volume over the limit fails without running restic",
+			maxVolumeSize:   1024,
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason:  arkv1api.PodVolumeBackupFailureReasonVolumeTooLarge,
+			expectedMessage: "volume size exceeds the maximum of 1024 bytes, not backing it up",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.maxVolumeSize = test.maxVolumeSize
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+			td.fileSystem.WithFile("/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1/data", make([]byte, 1536))
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+
+			var ranRestic bool
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				ranRestic = true
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = func(context.Context, *restic.Command) (string, error) {
+				return "snapshot-1", nil
+			}
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedReason, td.pvb.Status.FailureReason)
+			if test.expectedMessage != "" {
+				assert.Contains(t, td.pvb.Status.Message, test.expectedMessage)
+			}
+			assert.Equal(t, test.expectRestic, ranRestic)
+		})
+	}
+}
+
+func TestProcessBackupEvents(t *testing.T) {
+	tests := []struct {
+		name           string
+		volumes        []string
+		withPod        bool
+		resticStderr   string
+		resticErr      error
+		expectedEvents []string
+	}{
+		{
+			name:    "completed backup",
+			volumes: []string{"vol-2", "vol-1"},
+			withPod: true,
+			expectedEvents: []string{
+				"Normal BackupStarted Backing up volumes of pod ns-1/pod-1",
+				"Normal BackupCompleted Backed up volumes of pod ns-1/pod-1, snapshot IDs: vol-1=snapshot-vol-1, vol-2=snapshot-vol-2",
+			},
+		},
+		{
+			name:         "failed restic backup",
+			volumes:      []string{"vol-1"},
+			withPod:      true,
+			resticStderr: "Fatal: unable to open config file: Stat: The specified key does not exist.",
+			resticErr:    errors.New("exit status 1"),
+			expectedEvents: []string{
+				"Normal BackupStarted Backing up volumes of pod ns-1/pod-1",
+				"Warning BackupFailed Backup failed (RepoNotFound): volume vol-1: error running restic backup (attempt 1 of 1): stderr=Fatal: unable to open config file: Stat: The specified key does not exist.: exit status 1 (hint: initialize the restic repository, or run the restic server with --init-repositories)",
+			},
+		},
+		{
+			name:    "missing pod",
+			volumes: []string{"vol-1"},
+			expectedEvents: []string{
+				"Normal BackupStarted Backing up volumes of pod ns-1/pod-1",
+				"Warning BackupFailed Backup failed (Unknown): error getting pod: pod \"pod-1\" not found",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.maxBackupAttempts = 1
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			if test.withPod {
+				td.withBackupPrerequisites(pod, test.volumes...)
+			}
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volumes = test.volumes
+
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				return "", test.resticStderr, test.resticErr
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, test.expectedEvents, td.eventRecorder.Events)
+		})
+	}
+}
+
+func TestProcessBackupVerification(t *testing.T) {
+	tests := []struct {
+		name                 string
+		readDataPercent      int
+		policy               VerificationFailurePolicy
+		verifyErr            error
+		expectedVerifyFlags  []string
+		expectedPhase        arkv1api.PodVolumeBackupPhase
+		expectedReason       arkv1api.PodVolumeBackupFailureReason
+		expectedVerification arkv1api.PodVolumeBackupVerification
+		expectedLastEvent    string
+	}{
+		{
+			name:              "verification disabled",
+			policy:            VerificationFailurePolicyWarn,
+			expectedPhase:     arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedLastEvent: "Normal BackupCompleted Backed up volumes of pod ns-1/pod-1, snapshot IDs: vol-1=snapshot-1",
+		},
+		{
+			name:                "data subset verified",
+			readDataPercent:     10,
+			policy:              VerificationFailurePolicyWarn,
+			expectedVerifyFlags: []string{"--no-lock", "--read-data-subset=10%"},
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedVerification: arkv1api.PodVolumeBackupVerification{
+				Phase:           arkv1api.PodVolumeBackupVerificationPhasePartiallyVerified,
+				ReadDataPercent: 10,
+			},
+			expectedLastEvent: "Normal BackupCompleted Backed up volumes of pod ns-1/pod-1, snapshot IDs: vol-1=snapshot-1",
+		},
+		{
+			name:                "all data verified",
+			readDataPercent:     100,
+			policy:              VerificationFailurePolicyWarn,
+			expectedVerifyFlags: []string{"--no-lock", "--read-data"},
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedVerification: arkv1api.PodVolumeBackupVerification{
+				Phase:           arkv1api.PodVolumeBackupVerificationPhaseVerified,
+				ReadDataPercent: 100,
+			},
+			expectedLastEvent: "Normal BackupCompleted Backed up volumes of pod ns-1/pod-1, snapshot IDs: vol-1=snapshot-1",
+		},
+		{
+			name:                "failed verification with warn policy completes the backup",
+			readDataPercent:     10,
+			policy:              VerificationFailurePolicyWarn,
+			verifyErr:           errors.New("Fatal: repository contains errors"),
+			expectedVerifyFlags: []string{"--no-lock", "--read-data-subset=10%"},
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedVerification: arkv1api.PodVolumeBackupVerification{
+				Phase:           arkv1api.PodVolumeBackupVerificationPhaseFailed,
+				ReadDataPercent: 10,
+				Message:         "Fatal: repository contains errors",
+			},
+			expectedLastEvent: "Normal BackupCompleted Backed up volumes of pod ns-1/pod-1, snapshot IDs: vol-1=snapshot-1",
+		},
+		{
+			name:                "failed verification with fail policy fails the backup",
+			readDataPercent:     10,
+			policy:              VerificationFailurePolicyFail,
+			verifyErr:           errors.New("Fatal: repository contains errors"),
+			expectedVerifyFlags: []string{"--no-lock", "--read-data-subset=10%"},
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason:      arkv1api.PodVolumeBackupFailureReasonVerificationFailed,
+			expectedVerification: arkv1api.PodVolumeBackupVerification{
+				Phase:           arkv1api.PodVolumeBackupVerificationPhaseFailed,
+				ReadDataPercent: 10,
+				Message:         "Fatal: repository contains errors",
+			},
+			expectedLastEvent: "Warning BackupFailed Backup failed (VerificationFailed): backup verification failed: Fatal: repository contains errors",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.verifyReadDataPercent = test.readDataPercent
+			td.controller.verificationPolicy = test.policy
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = func(context.Context, *restic.Command) (string, error) {
+				return "snapshot-1", nil
+			}
+
+			var verifyCmds []*restic.Command
+			td.controller.verifyRepoFunc = func(_ context.Context, cmd *restic.Command) error {
+				verifyCmds = append(verifyCmds, cmd)
+				return test.verifyErr
+			}
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			if test.expectedVerifyFlags == nil {
+				assert.Empty(t, verifyCmds)
+			} else {
+				require.Len(t, verifyCmds, 1)
+				assert.Equal(t, "check", verifyCmds[0].Command)
+				assert.Equal(t, test.expectedVerifyFlags, verifyCmds[0].ExtraFlags)
+			}
+
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedReason, td.pvb.Status.FailureReason)
+			assert.Equal(t, test.expectedVerification, td.pvb.Status.Verification)
+			assert.Equal(t, map[string]string{"vol-1": "snapshot-1"}, td.pvb.Status.SnapshotIDs)
+			assert.Equal(t, test.expectedLastEvent, td.eventRecorder.Events[len(td.eventRecorder.Events)-1])
+		})
+	}
+}
+
+func TestProcessBackupMaintenanceContext(t *testing.T) {
+	tests := []struct {
+		name                 string
+		maintenanceTimeout   time.Duration
+		shutDown             bool
+		expectedDeadline     bool
+		expectedVerification arkv1api.PodVolumeBackupVerification
+	}{
+		{
+			name:               "maintenance is bounded by the maintenance timeout",
+			maintenanceTimeout: time.Hour,
+			expectedDeadline:   true,
+			expectedVerification: arkv1api.PodVolumeBackupVerification{
+				Phase:           arkv1api.PodVolumeBackupVerificationPhasePartiallyVerified,
+				ReadDataPercent: 10,
+			},
+		},
+		{
+			name: "maintenance has no deadline without a maintenance timeout",
+			expectedVerification: arkv1api.PodVolumeBackupVerification{
+				Phase:           arkv1api.PodVolumeBackupVerificationPhasePartiallyVerified,
+				ReadDataPercent: 10,
+			},
+		},
+		{
+			name:     "verification stopped by shutdown leaves the backup unverified rather than failed",
+			shutDown: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.verifyReadDataPercent = 10
+			td.controller.verificationPolicy = VerificationFailurePolicyFail
+			td.controller.maintenanceTimeout = test.maintenanceTimeout
+
+			runCtx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			td.controller.runCtx = runCtx
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				// the controller is told to stop once the volume is backed
+				// up, while the backup still holds its slot.
+				if test.shutDown {
+					cancel()
+				}
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = func(context.Context, *restic.Command) (string, error) {
+				return "snapshot-1", nil
+			}
+			td.controller.verifyRepoFunc = func(ctx context.Context, cmd *restic.Command) error {
+				_, hasDeadline := ctx.Deadline()
+				assert.Equal(t, test.expectedDeadline, hasDeadline)
+
+				// a killed restic check fails.
+				if ctx.Err() != nil {
+					return errors.New("signal: killed")
+				}
+				return nil
+			}
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedVerification, td.pvb.Status.Verification)
+		})
+	}
+}
+
+func TestInitRepositories(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+	defer td.controller.credentialsFiles.Clear()
+
+	td.controller.cacheSyncWaiters = nil
+	td.controller.repoInitPrefix = "s3:s3.amazonaws.com/bucket"
+	td.controller.maxConcurrentInits = 2
+
+	// ns-2's repository already exists and ns-5 has no restic credentials,
+	// so only ns-1, ns-3 and ns-4 should be initialized.
+	for _, ns := range []string{"ns-1", "ns-2", "ns-3", "ns-4", "ns-5"} {
+		pod := &corev1api.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: ns,
+				Name:      "pod-1",
+				UID:       types.UID(ns + "-pod-uid"),
+			},
+		}
+
+		if ns == "ns-5" {
+			require.NoError(t, td.kubeInformers.Core().V1().Pods().Informer().GetStore().Add(pod))
+			continue
+		}
+		td.withBackupPrerequisites(pod)
+	}
+
+	td.controller.repositoryExistsFunc = func(_ context.Context, cmd *restic.Command) (bool, error) {
+		return cmd.Repo == "ns-2", nil
+	}
+
+	var (
+		started = make(chan string, 5)
+		release = make(chan struct{})
+	)
+	td.controller.initRepoFunc = func(_ context.Context, cmd *restic.Command) error {
+		assert.Equal(t, "init", cmd.Command)
+		assert.Equal(t, "s3:s3.amazonaws.com/bucket", cmd.RepoPrefix)
+		assert.NotEmpty(t, cmd.PasswordFile)
+
+		started <- cmd.Repo
+		<-release
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		td.controller.initRepositories(context.Background())
+		close(done)
+	}()
+
+	// the first two inits run concurrently, and the third doesn't start
+	// until one of them finishes.
+	var initialized []string
+	for i := 0; i < 2; i++ {
+		select {
+		case repo := <-started:
+			initialized = append(initialized, repo)
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for repository init to start")
+		}
+	}
+
+	select {
+	case repo := <-started:
+		t.Fatalf("init of %s started while %d inits were running", repo, len(initialized))
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for repository inits to finish")
+	}
+
+	close(started)
+	for repo := range started {
+		initialized = append(initialized, repo)
+	}
+
+	sort.Strings(initialized)
+	assert.Equal(t, []string{"ns-1", "ns-3", "ns-4"}, initialized)
+}
+
+func TestInitRepositoryRetries(t *testing.T) {
+	networkErr := restic.NewError(errors.New("exit status 1"), "Fatal: create repository failed: dial tcp: i/o timeout")
+	existsErr := restic.NewError(errors.New("exit status 1"), "Fatal: create repository at s3:s3.amazonaws.com/bucket/ns-1 failed: Fatal: config file already exists")
+	authErr := restic.NewError(errors.New("exit status 1"), "Fatal: wrong password or no key found")
+
+	tests := []struct {
+		name             string
+		initErrs         []error
+		existsAfterInit  bool
+		expectedAttempts int
+		expectedWait     time.Duration
+		expectedErr      string
+	}{
+		{
+			name:             "init succeeds",
+			expectedAttempts: 1,
+		},
+		{
+			name:             "transient errors are retried",
+			initErrs:         []error{networkErr, networkErr},
+			expectedAttempts: 3,
+			expectedWait:     1500*time.Millisecond + 3*time.Second,
+		},
+		{
+			name:             "transient errors are retried up to the maximum attempts",
+			initErrs:         []error{networkErr, networkErr, networkErr, networkErr, networkErr},
+			expectedAttempts: maxRepoInitAttempts,
+			expectedWait:     1500*time.Millisecond + 3*time.Second + 6*time.Second + 12*time.Second,
+			expectedErr:      "error initializing restic repository (attempt 5 of 5): error running command: stderr=Fatal: create repository failed: dial tcp: i/o timeout: exit status 1",
+		},
+		{
+			name:             "repository initialized concurrently isn't an error",
+			initErrs:         []error{existsErr},
+			expectedAttempts: 1,
+		},
+		{
+			name:             "repository initialized concurrently while retrying isn't an error",
+			initErrs:         []error{networkErr, existsErr},
+			expectedAttempts: 2,
+			expectedWait:     1500 * time.Millisecond,
+		},
+		{
+			name:             "repository found after a failed init isn't an error",
+			initErrs:         []error{networkErr},
+			existsAfterInit:  true,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "other errors aren't retried",
+			initErrs:         []error{authErr},
+			expectedAttempts: 1,
+			expectedErr:      "error initializing restic repository (attempt 1 of 5): error running command: stderr=Fatal: wrong password or no key found: exit status 1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			defer td.controller.credentialsFiles.Clear()
+
+			td.controller.repoInitPrefix = "s3:s3.amazonaws.com/bucket"
+			td.controller.repoInitRetryDelay = time.Second
+			now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+			fakeClock := clock.NewFakeClock(now)
+			td.controller.clock = fakeClock
+			td.controller.jitterFunc = func() float64 {
+				return 0.5
+			}
+			td.withBackupPrerequisites(&corev1api.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "pod-1"}})
+
+			attempts := 0
+			td.controller.repositoryExistsFunc = func(context.Context, *restic.Command) (bool, error) {
+				return attempts > 0 && test.existsAfterInit, nil
+			}
+			td.controller.initRepoFunc = func(context.Context, *restic.Command) error {
+				attempts++
+				if attempts <= len(test.initErrs) {
+					return errors.Wrap(test.initErrs[attempts-1], "error running command")
+				}
+				return nil
+			}
+
+			// advance the clock past each retry delay as it's waited on.
+			var err error
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				err = td.controller.initRepository(context.Background(), "ns-1", arktest.NewLogger())
+			}()
+		wait:
+			for {
+				select {
+				case <-done:
+					break wait
+				case <-time.After(time.Millisecond):
+					if fakeClock.HasWaiters() {
+						fakeClock.Step(500 * time.Millisecond)
+					}
+				}
+			}
+
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.expectedAttempts, attempts)
+			assert.Equal(t, test.expectedWait, fakeClock.Since(now))
+		})
+	}
+}
+
+func TestJitter(t *testing.T) {
+	assert.Equal(t, 2*time.Second, jitter(2*time.Second, 0))
+	assert.Equal(t, 3*time.Second, jitter(2*time.Second, 0.5))
+	assert.Equal(t, 4*time.Second, jitter(2*time.Second, 1))
+}
+
+func TestInitRepositoryResticFeatures(t *testing.T) {
+	tests := []struct {
+		name                string
+		compression         string
+		packSize            int
+		expectedCompression string
+		expectedPackSize    int
+		expectedExtraFlags  []string
+	}{
+		{
+			name: "no compression",
+		},
+		{
+			name:                "compression creates a version 2 repository",
+			compression:         "max",
+			expectedCompression: "max",
+			expectedExtraFlags:  []string{"--repository-version=2"},
+		},
+		{
+			name:             "pack size",
+			packSize:         64,
+			expectedPackSize: 64,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			defer td.controller.credentialsFiles.Clear()
+
+			td.controller.repoInitPrefix = "s3:s3.amazonaws.com/bucket"
+			td.controller.resticCompression = test.compression
+			td.controller.resticPackSize = test.packSize
+			td.withBackupPrerequisites(&corev1api.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "pod-1"}})
+
+			td.controller.repositoryExistsFunc = func(_ context.Context, cmd *restic.Command) (bool, error) {
+				assert.Equal(t, test.expectedCompression, cmd.Compression)
+				return false, nil
+			}
+
+			var initCmd *restic.Command
+			td.controller.initRepoFunc = func(_ context.Context, cmd *restic.Command) error {
+				initCmd = cmd
+				return nil
+			}
+
+			require.NoError(t, td.controller.initRepository(context.Background(), "ns-1", arktest.NewLogger()))
+			require.NotNil(t, initCmd)
+			assert.Equal(t, test.expectedCompression, initCmd.Compression)
+			assert.Equal(t, test.expectedPackSize, initCmd.PackSize)
+			assert.Equal(t, test.expectedExtraFlags, initCmd.ExtraFlags)
+		})
+	}
+}
+
+func TestProcessBackupTagTemplates(t *testing.T) {
+	tests := []struct {
+		name            string
+		tags            map[string]string
+		expectedTags    []string
+		expectedPhase   arkv1api.PodVolumeBackupPhase
+		expectedMessage string
+	}{
+		{
+			name: "templates are resolved from the pod's metadata",
+			tags: map[string]string{
+				"backup":  "backup-1",
+				"app":     "{{.Labels.app}}",
+				"version": "{{.Annotations.version}}",
+				"tier":    "{{.Labels.tier}}",
+			},
+			expectedTags:  []string{"--tag=app=db", "--tag=backup=backup-1", "--tag=version=10.4", "--tag=volume=vol-1"},
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+		{
+			name:            "invalid template fails the backup",
+			tags:            map[string]string{"app": "{{.Labels.app"},
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedMessage: "invalid tags: error parsing template for tag app",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "ns-1",
+					Name:        "pod-1",
+					UID:         "pod-uid",
+					Labels:      map[string]string{"app": "db"},
+					Annotations: map[string]string{"version": "10.4"},
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+			td.pvb.Spec.Tags = test.tags
+
+			var tagFlags []string
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				for _, arg := range cmd.Args {
+					if strings.HasPrefix(arg, "--tag=") {
+						tagFlags = append(tagFlags, arg)
+					}
+				}
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = func(context.Context, *restic.Command) (string, error) {
+				return "snapshot-1", nil
+			}
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			if test.expectedMessage != "" {
+				assert.Contains(t, td.pvb.Status.Message, test.expectedMessage)
+			}
+
+			sort.Strings(tagFlags)
+			assert.Equal(t, test.expectedTags, tagFlags)
+		})
+	}
+}
+
+func TestPatchPodVolumeBackupConflicts(t *testing.T) {
+	tests := []struct {
+		name             string
+		updated          bool
+		conflicts        int
+		patchErr         error
+		expectedErr      bool
+		expectedPatches  int
+		expectedGets     int
+		expectedPhase    arkv1api.PodVolumeBackupPhase
+		expectedSnapshot string
+	}{
+		{
+			name:            "no conflict",
+			expectedPatches: 1,
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+		{
+			name:             "conflict on first patch is retried against the latest version",
+			updated:          true,
+			expectedPatches:  2,
+			expectedGets:     1,
+			expectedPhase:    arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedSnapshot: "updated-concurrently",
+		},
+		{
+			name:            "persistent conflicts fail once retries are exhausted",
+			conflicts:       100,
+			expectedErr:     true,
+			expectedPatches: 4,
+			expectedGets:    4,
+		},
+		{
+			name:            "other errors are not retried",
+			patchErr:        errors.New("connection refused"),
+			expectedErr:     true,
+			expectedPatches: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+
+			req := &arkv1api.PodVolumeBackup{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:       "ns-1",
+					Name:            "pvb-1",
+					ResourceVersion: "1",
+				},
+				Status: arkv1api.PodVolumeBackupStatus{
+					Phase: arkv1api.PodVolumeBackupPhaseInProgress,
+				},
+			}
+			td.pvb = req.DeepCopy()
+			if test.updated {
+				// the server-side object has been updated since req was read.
+				td.pvb.ResourceVersion = "2"
+				td.pvb.Status.SnapshotID = "updated-concurrently"
+			}
+
+			var patches, gets int
+			td.client.PrependReactor("patch", "podvolumebackups", func(action core.Action) (bool, runtime.Object, error) {
+				patches++
+				if test.patchErr != nil {
+					return true, nil, test.patchErr
+				}
+				if patches <= test.conflicts {
+					return true, nil, apierrors.NewConflict(arkv1api.Resource("podvolumebackups"), "pvb-1", errors.New("the object has been modified"))
+				}
+
+				// like the API server, reject a patch made against a version
+				// other than the latest.
+				patch := new(arkv1api.PodVolumeBackup)
+				require.NoError(t, json.Unmarshal(action.(core.PatchAction).GetPatch(), patch))
+				if patch.ResourceVersion != td.pvb.ResourceVersion {
+					return true, nil, apierrors.NewConflict(arkv1api.Resource("podvolumebackups"), "pvb-1", errors.New("the object has been modified"))
+				}
+				return false, nil, nil
+			})
+			td.client.PrependReactor("get", "podvolumebackups", func(action core.Action) (bool, runtime.Object, error) {
+				gets++
+				return true, td.pvb.DeepCopy(), nil
+			})
+
+			res, err := td.controller.patchPodVolumeBackup(req, updatePhaseFunc(arkv1api.PodVolumeBackupPhaseCompleted))
+
+			assert.Equal(t, test.expectedPatches, patches)
+			assert.Equal(t, test.expectedGets, gets)
+
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedPhase, res.Status.Phase)
+			assert.Equal(t, test.expectedSnapshot, res.Status.SnapshotID)
+
+			// req is updated in place to the patched PodVolumeBackup.
+			assert.Equal(t, res, req)
+		})
+	}
+}
+
+func TestPatchPodVolumeBackupReusesPatchedVersion(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+
+	td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+	td.pvb.ResourceVersion = "1"
+	req := td.pvb.DeepCopy()
+
+	var patches int
+	td.client.PrependReactor("patch", "podvolumebackups", func(action core.Action) (bool, runtime.Object, error) {
+		patches++
+		return false, nil, nil
+	})
+
+	// a patch whose result is discarded still leaves req at the patched
+	// resourceVersion, so the next patch of it doesn't conflict.
+	_, err := td.controller.patchPodVolumeBackup(req, updatePhaseFunc(arkv1api.PodVolumeBackupPhaseInProgress))
+	require.NoError(t, err)
+	_, err = td.controller.patchPodVolumeBackup(req, updatePhaseFunc(arkv1api.PodVolumeBackupPhaseCompleted))
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, patches)
+	assert.Equal(t, "3", req.ResourceVersion)
+	assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+}
+
+func TestPatchPodVolumeBackupRateLimited(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+
+	// a limiter with a single token that's never replenished during the test
+	td.controller.patchLimiter = rate.NewLimiter(rate.Every(time.Hour), 1)
+
+	td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+	_, err := td.controller.patchPodVolumeBackup(td.pvb.DeepCopy(), updatePhaseFunc(arkv1api.PodVolumeBackupPhaseCompleted))
+	require.NoError(t, err)
+	assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+
+	// the patch used the limiter's token
+	assert.False(t, td.controller.patchLimiter.Allow())
+
+	// patches that could never be allowed fail rather than blocking forever
+	td.controller.patchLimiter = rate.NewLimiter(rate.Every(time.Hour), 0)
+	_, err = td.controller.patchPodVolumeBackup(td.pvb.DeepCopy(), updatePhaseFunc(arkv1api.PodVolumeBackupPhaseFailed))
+	assert.Error(t, err)
+	assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+
+	// once the controller is told to stop, patches aren't throttled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	td.controller.runCtx = ctx
+	_, err = td.controller.patchPodVolumeBackup(td.pvb.DeepCopy(), updatePhaseFunc(arkv1api.PodVolumeBackupPhaseCanceled))
+	require.NoError(t, err)
+	assert.Equal(t, arkv1api.PodVolumeBackupPhaseCanceled, td.pvb.Status.Phase)
+}
+
+func TestProcessQueueItemRateLimited(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+	td.controller.queueLimiter = rate.NewLimiter(rate.Every(time.Hour), 1)
+
+	var processed []string
+	td.controller.processBackupFunc = func(_ context.Context, req *arkv1api.PodVolumeBackup) error {
+		processed = append(processed, req.Name)
+		return nil
+	}
+
+	pvbStore := td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore()
+	require.NoError(t, pvbStore.Add(newTestPodVolumeBackup("pvb-1", "node-2")))
+	require.NoError(t, pvbStore.Add(newTestPodVolumeBackup("pvb-2", "node-1")))
+	require.NoError(t, pvbStore.Add(newTestPodVolumeBackup("pvb-3", "node-1")))
+	completed := newTestPodVolumeBackup("pvb-completed", "node-1")
+	completed.Status.Phase = arkv1api.PodVolumeBackupPhaseCompleted
+	require.NoError(t, pvbStore.Add(completed))
+
+	// other nodes' items, and items that need no work, don't use the
+	// limiter's token
+	require.NoError(t, td.controller.processQueueItem("heptio-ark/pvb-1"))
+	require.NoError(t, td.controller.processQueueItem("heptio-ark/pvb-completed"))
+	assert.Empty(t, processed)
+
+	require.NoError(t, td.controller.processQueueItem("heptio-ark/pvb-2"))
+	assert.Equal(t, []string{"pvb-2"}, processed)
+	assert.False(t, td.controller.queueLimiter.Allow())
+
+	// waiting for a token stops, without starting the backup, once the
+	// controller is told to stop.
+	ctx, cancel := context.WithCancel(context.Background())
+	td.controller.runCtx = ctx
+	done := make(chan error)
+	go func() {
+		done <- td.controller.processQueueItem("heptio-ark/pvb-3")
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("waiting for the queue rate limiter wasn't stopped")
+	}
+	assert.Equal(t, []string{"pvb-2"}, processed)
+}
+
+func TestProcessBackupTimestamps(t *testing.T) {
+	start := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name               string
+		withPod            bool
+		resticErr          error
+		expectedPhase      arkv1api.PodVolumeBackupPhase
+		expectedCompletion time.Time
+	}{
+		{
+			name:               "completed backup",
+			withPod:            true,
+			expectedPhase:      arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedCompletion: start.Add(time.Minute),
+		},
+		{
+			name:               "failed restic backup",
+			withPod:            true,
+			resticErr:          errors.New("exit status 1"),
+			expectedPhase:      arkv1api.PodVolumeBackupPhaseFailed,
+			expectedCompletion: start.Add(time.Minute),
+		},
+		{
+			name:               "failed before running restic",
+			expectedPhase:      arkv1api.PodVolumeBackupPhaseFailed,
+			expectedCompletion: start,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.maxBackupAttempts = 1
+
+			fakeClock := clock.NewFakeClock(start)
+			td.controller.clock = fakeClock
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			if test.withPod {
+				td.withBackupPrerequisites(pod, "vol-1")
+			}
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volumes = []string{"vol-1"}
+
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				// while restic runs, the backup has started but not completed.
+				assert.Equal(t, arkv1api.PodVolumeBackupPhaseInProgress, td.pvb.Status.Phase)
+				assert.Equal(t, start.Unix(), td.pvb.Status.StartTimestamp.Unix())
+				assert.True(t, td.pvb.Status.CompletionTimestamp.IsZero())
+
+				fakeClock.Step(time.Minute)
+				return "", "", test.resticErr
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, start.Unix(), td.pvb.Status.StartTimestamp.Unix())
+			assert.Equal(t, test.expectedCompletion.Unix(), td.pvb.Status.CompletionTimestamp.Unix())
+		})
+	}
+}
+
+func TestProcessBackupMirrors(t *testing.T) {
+	tests := []struct {
+		name                  string
+		policy                arkv1api.PodVolumeBackupMirrorFailurePolicy
+		failingRepo           string
+		expectedRepos         []string
+		expectedPhase         arkv1api.PodVolumeBackupPhase
+		expectedFailureReason arkv1api.PodVolumeBackupFailureReason
+		expectedMessage       string
+		expectedMirrors       []arkv1api.PodVolumeBackupMirrorStatus
+		expectedEvent         string
+	}{
+		{
+			name:          "primary and mirror succeed",
+			expectedRepos: []string{"s3:primary/ns-1", "s3:mirror/ns-1"},
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedMirrors: []arkv1api.PodVolumeBackupMirrorStatus{
+				{
+					RepoPrefix:  "s3:mirror",
+					Phase:       arkv1api.PodVolumeBackupPhaseCompleted,
+					SnapshotIDs: map[string]string{"vol-1": "snapshot-vol-1"},
+				},
+			},
+			expectedEvent: "Normal BackupCompleted Backed up volumes of pod ns-1/pod-1, snapshot IDs: vol-1=snapshot-vol-1",
+		},
+		{
+			name:                  "primary fails",
+			failingRepo:           "s3:primary/ns-1",
+			expectedRepos:         []string{"s3:primary/ns-1"},
+			expectedPhase:         arkv1api.PodVolumeBackupPhaseFailed,
+			expectedFailureReason: arkv1api.PodVolumeBackupFailureReasonUnknown,
+			expectedMessage:       "volume vol-1: error running restic backup (attempt 1 of 1): stderr=: exit status 1",
+			expectedEvent:         "Warning BackupFailed Backup failed (Unknown): volume vol-1: error running restic backup (attempt 1 of 1): stderr=: exit status 1",
+		},
+		{
+			name:            "mirror fails with the warn policy",
+			failingRepo:     "s3:mirror/ns-1",
+			expectedRepos:   []string{"s3:primary/ns-1", "s3:mirror/ns-1"},
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedMessage: "backup to mirror repositories failed: s3:mirror: volume vol-1: error running restic backup (attempt 1 of 1): stderr=: exit status 1",
+			expectedMirrors: []arkv1api.PodVolumeBackupMirrorStatus{
+				{
+					RepoPrefix: "s3:mirror",
+					Phase:      arkv1api.PodVolumeBackupPhaseFailed,
+					Message:    "volume vol-1: error running restic backup (attempt 1 of 1): stderr=: exit status 1",
+				},
+			},
+			expectedEvent: "Warning BackupMirrorFailed Backup to mirror repositories failed: s3:mirror: volume vol-1: error running restic backup (attempt 1 of 1): stderr=: exit status 1",
+		},
+		{
+			name:                  "mirror fails with the fail policy",
+			policy:                arkv1api.PodVolumeBackupMirrorFailurePolicyFail,
+			failingRepo:           "s3:mirror/ns-1",
+			expectedRepos:         []string{"s3:primary/ns-1", "s3:mirror/ns-1"},
+			expectedPhase:         arkv1api.PodVolumeBackupPhaseFailed,
+			expectedFailureReason: arkv1api.PodVolumeBackupFailureReasonMirrorFailed,
+			expectedMessage:       "backup to mirror repositories failed: s3:mirror: volume vol-1: error running restic backup (attempt 1 of 1): stderr=: exit status 1",
+			expectedMirrors: []arkv1api.PodVolumeBackupMirrorStatus{
+				{
+					RepoPrefix: "s3:mirror",
+					Phase:      arkv1api.PodVolumeBackupPhaseFailed,
+					Message:    "volume vol-1: error running restic backup (attempt 1 of 1): stderr=: exit status 1",
+				},
+			},
+			expectedEvent: "Warning BackupFailed Backup failed (MirrorFailed): backup to mirror repositories failed: s3:mirror: volume vol-1: error running restic backup (attempt 1 of 1): stderr=: exit status 1",
+		},
+		{
+			name:                  "invalid policy",
+			policy:                "Ignore",
+			expectedPhase:         arkv1api.PodVolumeBackupPhaseFailed,
+			expectedFailureReason: arkv1api.PodVolumeBackupFailureReasonInvalidSpec,
+			expectedMessage:       `invalid mirror failure policy "Ignore", must be one of Warn, Fail`,
+			expectedEvent:         `Warning BackupFailed Backup failed (InvalidSpec): invalid mirror failure policy "Ignore", must be one of Warn, Fail`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.maxBackupAttempts = 1
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+			td.pvb.Spec.RepoPrefix = "s3:primary"
+			td.pvb.Spec.MirrorRepoPrefixes = []string{"s3:mirror"}
+			td.pvb.Spec.MirrorFailurePolicy = test.policy
+
+			var repos []string
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				var repo string
+				for _, arg := range cmd.Args {
+					if strings.HasPrefix(arg, "--repo=") {
+						repo = strings.TrimPrefix(arg, "--repo=")
+					}
+				}
+				repos = append(repos, repo)
+
+				if repo == test.failingRepo {
+					return "", "", errors.New("exit status 1")
+				}
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, test.expectedRepos, repos)
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedFailureReason, td.pvb.Status.FailureReason)
+			assert.Equal(t, test.expectedMessage, td.pvb.Status.Message)
+			assert.Equal(t, test.expectedMirrors, td.pvb.Status.Mirrors)
+			assert.Contains(t, td.eventRecorder.Events, test.expectedEvent)
+		})
+	}
+}
+
+func TestWaitForVolumePath(t *testing.T) {
+	tests := []struct {
+		name           string
+		podDir         bool
+		volumeDirs     []string
+		delay          time.Duration
+		timeout        time.Duration
+		expectedPath   string
+		expectedReason arkv1api.PodVolumeBackupFailureReason
+	}{
+		{
+			name:         "volume already mounted",
+			podDir:       true,
+			volumeDirs:   []string{"kubernetes.io~empty-dir/vol-1"},
+			timeout:      time.Second,
+			expectedPath: "pod-uid/volumes/kubernetes.io~empty-dir/vol-1",
+		},
+		{
+			name:         "volume mounted after a delay",
+			podDir:       true,
+			volumeDirs:   []string{"kubernetes.io~empty-dir/vol-1"},
+			delay:        100 * time.Millisecond,
+			timeout:      10 * time.Second,
+			expectedPath: "pod-uid/volumes/kubernetes.io~empty-dir/vol-1",
+		},
+		{
+			name:           "pod on the node but volume never mounted",
+			podDir:         true,
+			timeout:        100 * time.Millisecond,
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonVolumeNotMounted,
+		},
+		{
+			name:           "pod not on the node",
+			timeout:        100 * time.Millisecond,
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonVolumeNotFound,
+		},
+		{
+			name:           "multiple matching volume directories",
+			podDir:         true,
+			volumeDirs:     []string{"kubernetes.io~empty-dir/vol-1", "kubernetes.io~nfs/vol-1"},
+			timeout:        10 * time.Second,
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonVolumeNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hostPodsPath, err := ioutil.TempDir("", "host-pods")
+			require.NoError(t, err)
+			defer os.RemoveAll(hostPodsPath)
+
+			if test.podDir {
+				require.NoError(t, os.MkdirAll(filepath.Join(hostPodsPath, "pod-uid", "volumes"), 0755))
+			}
+
+			mount := func() {
+				for _, dir := range test.volumeDirs {
+					require.NoError(t, os.MkdirAll(filepath.Join(hostPodsPath, "pod-uid", "volumes", dir), 0755))
+				}
+			}
+
+			mounted := make(chan struct{})
+			if test.delay > 0 {
+				go func() {
+					defer close(mounted)
+					time.Sleep(test.delay)
+					mount()
+				}()
+			} else {
+				mount()
+				close(mounted)
+			}
+
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.fileSystem = filesystem.NewFileSystem()
+			td.controller.hostPodsPath = hostPodsPath
+			td.controller.volumeMountTimeout = test.timeout
+			td.controller.mountPollInterval = 10 * time.Millisecond
+
+			path, err := td.controller.waitForVolumePath(context.Background(), "pod-uid", "vol-1", false, nil, arktest.NewLogger())
+			<-mounted
+
+			if test.expectedReason != "" {
+				require.Error(t, err)
+				assert.Equal(t, test.expectedReason, failureReason(err))
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, filepath.Join(hostPodsPath, test.expectedPath), path)
+		})
+	}
+}
+
+func TestVolumePath(t *testing.T) {
+	hostPathVolume := func(name, path string) corev1api.Volume {
+		return corev1api.Volume{
+			Name:         name,
+			VolumeSource: corev1api.VolumeSource{HostPath: &corev1api.HostPathVolumeSource{Path: path}},
+		}
+	}
+
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+		Spec: corev1api.PodSpec{
+			Volumes: []corev1api.Volume{
+				{
+					Name:         "scratch",
+					VolumeSource: corev1api.VolumeSource{EmptyDir: &corev1api.EmptyDirVolumeSource{}},
+				},
+				hostPathVolume("app-logs", "/var/log/app"),
+				hostPathVolume("all-logs", "/var/log/"),
+				hostPathVolume("missing-logs", "/var/log/missing"),
+				hostPathVolume("etc", "/etc"),
+				hostPathVolume("escaped", "/var/log/../../etc"),
+				hostPathVolume("prefix", "/var/logs"),
+				hostPathVolume("symlink-escaped", "/var/log/escaped"),
+				hostPathVolume("symlink-absolute", "/var/log/absolute"),
+				hostPathVolume("symlink-within", "/var/log/current"),
+				hostPathVolume("symlinked-allowed", "/data/app"),
+			},
+		},
+	}
+
+	tests := []struct {
+		volume         string
+		expectedPath   string
+		expectedReason arkv1api.PodVolumeBackupFailureReason
+	}{
+		{
+			volume:       "scratch",
+			expectedPath: "/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/scratch",
+		},
+		{
+			volume:       "app-logs",
+			expectedPath: "/host_root/var/log/app",
+		},
+		{
+			volume:       "all-logs",
+			expectedPath: "/host_root/var/log",
+		},
+		{
+			volume:         "missing-logs",
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonVolumeNotFound,
+		},
+		{
+			volume:         "etc",
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonHostPathNotAllowed,
+		},
+		{
+			volume:         "escaped",
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonHostPathNotAllowed,
+		},
+		{
+			volume:         "prefix",
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonHostPathNotAllowed,
+		},
+		{
+			volume:         "symlink-escaped",
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonHostPathNotAllowed,
+		},
+		{
+			volume:         "symlink-absolute",
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonHostPathNotAllowed,
+		},
+		{
+			volume:       "symlink-within",
+			expectedPath: "/host_root/var/log/current",
+		},
+		{
+			volume:       "symlinked-allowed",
+			expectedPath: "/host_root/data/app",
+		},
+		{
+			volume:         "missing",
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonVolumeNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.volume, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.hostRootPath = "/host_root"
+			td.controller.hostPathAllowList = []string{"/var/log", "/data", "/missing-allowed"}
+			td.fileSystem.WithDirectories(
+				"/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/scratch",
+				"/host_root/etc",
+				"/host_root/var/log/app",
+				"/host_root/var/logs",
+				"/host_root/var/log/escaped",
+				"/host_root/var/log/absolute",
+				"/host_root/var/log/current",
+				"/host_root/data/app",
+			)
+			// a relative symlink out of the allow list, /var/log/escaped ->
+			// ../../etc, resolves to the host's /etc, and an absolute one,
+			// /var/log/absolute -> /etc, to the restic pod's own /etc.
+			symlinks := map[string]string{
+				"/host_root/var/log/escaped":  "/host_root/etc",
+				"/host_root/var/log/absolute": "/etc",
+				"/host_root/var/log/current":  "/host_root/var/log/app",
+				"/host_root/data":             "/host_root/mnt/disk-1",
+				"/host_root/data/app":         "/host_root/mnt/disk-1/app",
+			}
+			td.controller.evalSymlinksFunc = func(path string) (string, error) {
+				if path == "/host_root/missing-allowed" {
+					return "", &os.PathError{Op: "lstat", Path: path, Err: os.ErrNotExist}
+				}
+				if target, ok := symlinks[path]; ok {
+					return target, nil
+				}
+				return path, nil
+			}
+
+			req := newTestPodVolumeBackup("pvb-1", "node-1")
+			req.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+
+			path, err := td.controller.volumePath(context.Background(), req, pod, test.volume, arktest.NewLogger())
+
+			if test.expectedReason != "" {
+				require.Error(t, err)
+				assert.Equal(t, test.expectedReason, failureReason(err))
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedPath, path)
+		})
+	}
+}
+
+func TestVolumePathMultipleMatches(t *testing.T) {
+	pvcVolume := func(name, claimName string) corev1api.Volume {
+		return corev1api.Volume{
+			Name:         name,
+			VolumeSource: corev1api.VolumeSource{PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: claimName}},
+		}
+	}
+
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+		Spec: corev1api.PodSpec{
+			Volumes: []corev1api.Volume{
+				{
+					Name:         "scratch",
+					VolumeSource: corev1api.VolumeSource{EmptyDir: &corev1api.EmptyDirVolumeSource{}},
+				},
+				pvcVolume("ebs", "claim-1"),
+				pvcVolume("gce", "claim-2"),
+				pvcVolume("unknown", "claim-3"),
+			},
+		},
+	}
+
+	tests := []struct {
+		volume        string
+		expectedPath  string
+		expectedError string
+	}{
+		{
+			volume:       "scratch",
+			expectedPath: "/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/scratch",
+		},
+		{
+			volume:       "ebs",
+			expectedPath: "/host_pods/pod-uid/volumes/kubernetes.io~aws-ebs/pv-1",
+		},
+		{
+			volume:        "gce",
+			expectedError: "error getting volume path on host: expected one path matching /host_pods/pod-uid/volumes/*/pv-2, got 2: /host_pods/pod-uid/volumes/kubernetes.io~aws-ebs/pv-2, /host_pods/pod-uid/volumes/kubernetes.io~csi/pv-2",
+		},
+		{
+			volume:        "unknown",
+			expectedError: "error getting volume path on host: expected one path matching /host_pods/pod-uid/volumes/*/pv-3, got 2: /host_pods/pod-uid/volumes/kubernetes.io~aws-ebs/pv-3, /host_pods/pod-uid/volumes/kubernetes.io~csi/pv-3",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.volume, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+
+			// each volume's directory is left behind by a remount under
+			// another plugin's directory as well as its own.
+			td.fileSystem.WithDirectories(
+				"/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/scratch",
+				"/host_pods/pod-uid/volumes/kubernetes.io~nfs/scratch",
+				"/host_pods/pod-uid/volumes/kubernetes.io~aws-ebs/pv-1",
+				"/host_pods/pod-uid/volumes/kubernetes.io~csi/pv-1",
+				"/host_pods/pod-uid/volumes/kubernetes.io~aws-ebs/pv-2",
+				"/host_pods/pod-uid/volumes/kubernetes.io~csi/pv-2",
+				"/host_pods/pod-uid/volumes/kubernetes.io~aws-ebs/pv-3",
+				"/host_pods/pod-uid/volumes/kubernetes.io~csi/pv-3",
+			)
+
+			for claim, pv := range map[string]string{"claim-1": "pv-1", "claim-2": "pv-2", "claim-3": "pv-3"} {
+				require.NoError(t, td.kubeInformers.Core().V1().PersistentVolumeClaims().Informer().GetStore().Add(&corev1api.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: claim},
+					Spec:       corev1api.PersistentVolumeClaimSpec{VolumeName: pv},
+				}))
+			}
+			for name, source := range map[string]corev1api.PersistentVolumeSource{
+				"pv-1": {AWSElasticBlockStore: &corev1api.AWSElasticBlockStoreVolumeSource{}},
+				"pv-2": {GCEPersistentDisk: &corev1api.GCEPersistentDiskVolumeSource{}},
+				"pv-3": {},
+			} {
+				require.NoError(t, td.kubeInformers.Core().V1().PersistentVolumes().Informer().GetStore().Add(&corev1api.PersistentVolume{
+					ObjectMeta: metav1.ObjectMeta{Name: name},
+					Spec:       corev1api.PersistentVolumeSpec{PersistentVolumeSource: source},
+				}))
+			}
+
+			req := newTestPodVolumeBackup("pvb-1", "node-1")
+			req.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+
+			path, err := td.controller.volumePath(context.Background(), req, pod, test.volume, arktest.NewLogger())
+
+			if test.expectedError != "" {
+				assert.EqualError(t, err, test.expectedError)
+				assert.Equal(t, arkv1api.PodVolumeBackupFailureReasonVolumeNotFound, failureReason(err))
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedPath, path)
+		})
+	}
+}
+
+func TestProcessBackupPasswordSources(t *testing.T) {
+	tests := []struct {
+		name                    string
+		passwordFile            string
+		passwordCommand         string
+		withSecret              bool
+		expectedPasswordFlag    string
+		expectedTempCredentials bool
+		expectedPhase           arkv1api.PodVolumeBackupPhase
+	}{
+		{
+			name:                    "credentials secret",
+			withSecret:              true,
+			expectedTempCredentials: true,
+			expectedPhase:           arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+		{
+			name:          "missing credentials secret",
+			expectedPhase: arkv1api.PodVolumeBackupPhaseFailed,
+		},
+		{
+			name:                 "password file",
+			passwordFile:         "/credentials/restic-password",
+			expectedPasswordFlag: "--password-file=/credentials/restic-password",
+			expectedPhase:        arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+		{
+			name:                 "password command",
+			passwordCommand:      "cat /credentials/restic-password",
+			expectedPasswordFlag: "--password-command=cat /credentials/restic-password",
+			expectedPhase:        arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			defer td.controller.credentialsFiles.Clear()
+
+			td.controller.resticPasswordFile = test.passwordFile
+			td.controller.resticPasswordCommand = test.passwordCommand
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+			if !test.withSecret {
+				secrets := td.kubeInformers.Core().V1().Secrets().Informer().GetStore()
+				for _, secret := range secrets.List() {
+					require.NoError(t, secrets.Delete(secret))
+				}
+			}
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+
+			var passwordFlags []string
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				for _, arg := range cmd.Args {
+					if strings.HasPrefix(arg, "--password-") {
+						passwordFlags = append(passwordFlags, arg)
+					}
+				}
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+
+			if test.expectedPhase != arkv1api.PodVolumeBackupPhaseCompleted {
+				assert.Empty(t, passwordFlags)
+				return
+			}
+
+			require.Len(t, passwordFlags, 1)
+			if test.expectedTempCredentials {
+				file, err := td.controller.credentialsFiles.Get("ns-1")
+				require.NoError(t, err)
+				assert.Equal(t, "--password-file="+file, passwordFlags[0])
+			} else {
+				assert.Equal(t, test.expectedPasswordFlag, passwordFlags[0])
+			}
+		})
+	}
+}
+
+func TestProcessBackupCredentialsSecret(t *testing.T) {
+	tests := []struct {
+		name             string
+		secretRef        *corev1api.SecretReference
+		passwordFile     string
+		expectedPassword string
+		expectedEnv      []string
+		expectedPhase    arkv1api.PodVolumeBackupPhase
+		expectedMessage  string
+	}{
+		{
+			name:             "no reference uses the namespace's secret",
+			expectedPassword: "password",
+			expectedPhase:    arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+		{
+			name:             "secret in another namespace",
+			secretRef:        &corev1api.SecretReference{Namespace: "creds", Name: "other-store"},
+			expectedPassword: "other-password",
+			expectedEnv:      []string{"AWS_ACCESS_KEY_ID=other-id", "AWS_SECRET_ACCESS_KEY=other-secret"},
+			expectedPhase:    arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+		{
+			name:             "secret in the pod's namespace",
+			secretRef:        &corev1api.SecretReference{Name: "pod-store"},
+			expectedPassword: "pod-password",
+			expectedEnv:      []string{"RESTIC_REPOSITORY_CACHE=off"},
+			expectedPhase:    arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+		{
+			name:             "secret takes precedence over a password file",
+			secretRef:        &corev1api.SecretReference{Namespace: "creds", Name: "other-store"},
+			passwordFile:     "/credentials/restic-password",
+			expectedPassword: "other-password",
+			expectedEnv:      []string{"AWS_ACCESS_KEY_ID=other-id", "AWS_SECRET_ACCESS_KEY=other-secret"},
+			expectedPhase:    arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+		{
+			name:            "missing secret",
+			secretRef:       &corev1api.SecretReference{Namespace: "creds", Name: "missing"},
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedMessage: "error creating temp restic credentials file",
+		},
+		{
+			name:            "reference without a name",
+			secretRef:       &corev1api.SecretReference{Namespace: "creds"},
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedMessage: "invalid credentials secret reference: name is required",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			defer td.controller.credentialsFiles.Clear()
+			td.controller.resticPasswordFile = test.passwordFile
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			secrets := td.kubeInformers.Core().V1().Secrets().Informer().GetStore()
+			require.NoError(t, secrets.Add(&corev1api.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "creds", Name: "other-store"},
+				Data: map[string][]byte{
+					restic.CredentialsKey:   []byte("other-password"),
+					"AWS_ACCESS_KEY_ID":     []byte("other-id"),
+					"AWS_SECRET_ACCESS_KEY": []byte("other-secret"),
+				},
+			}))
+			require.NoError(t, secrets.Add(&corev1api.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "pod-store"},
+				Data: map[string][]byte{
+					restic.CredentialsKey:     []byte("pod-password"),
+					"RESTIC_REPOSITORY_CACHE": []byte("off"),
+				},
+			}))
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+			td.pvb.Spec.CredentialsSecret = test.secretRef
+
+			var (
+				passwords []string
+				envs      [][]string
+			)
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				for _, arg := range cmd.Args {
+					if strings.HasPrefix(arg, "--password-file=") {
+						data, err := ioutil.ReadFile(strings.TrimPrefix(arg, "--password-file="))
+						require.NoError(t, err)
+						passwords = append(passwords, string(data))
+					}
+				}
+				envs = append(envs, cmd.Env)
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = func(ctx context.Context, cmd *restic.Command) (string, error) {
+				// commands other than the backup get the secret's environment too.
+				for _, env := range test.expectedEnv {
+					assert.Contains(t, cmd.Env, env)
+				}
+				return fakeVolumeSnapshotID(ctx, cmd)
+			}
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+
+			if test.expectedPhase != arkv1api.PodVolumeBackupPhaseCompleted {
+				assert.Contains(t, td.pvb.Status.Message, test.expectedMessage)
+				assert.Empty(t, passwords)
+				return
+			}
+
+			assert.Equal(t, []string{test.expectedPassword}, passwords)
+			require.Len(t, envs, 1)
+			for _, env := range test.expectedEnv {
+				assert.Contains(t, envs[0], env)
+			}
+			if len(test.expectedEnv) == 0 {
+				assert.Empty(t, envs[0])
+			}
+		})
+	}
+}
+
+func TestProcessBackupPrune(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+	defer td.controller.credentialsFiles.Clear()
+
+	td.controller.pruneTrigger = NewPruneTrigger(2, 0)
+
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+	}
+	td.withBackupPrerequisites(pod, "vol-1")
+
+	td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+		return "", "", nil
+	}
+	td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+	var pruneCmds []*restic.Command
+	td.controller.pruneRepoFunc = func(_ context.Context, cmd *restic.Command) error {
+		pruneCmds = append(pruneCmds, cmd)
+		return nil
+	}
+
+	for i, expectedPrunes := range []int{0, 1, 1, 2} {
+		td.pvb = newTestPodVolumeBackup(fmt.Sprintf("pvb-%d", i), "node-1")
+		td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+		td.pvb.Spec.Volume = "vol-1"
+
+		require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+		assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+		assert.Len(t, pruneCmds, expectedPrunes, "backup %d", i)
+	}
+
+	credsFile, err := td.controller.credentialsFiles.Get("ns-1")
+	require.NoError(t, err)
+
+	for _, cmd := range pruneCmds {
+		assert.Equal(t, "prune", cmd.Command)
+		assert.Equal(t, "ns-1", cmd.Repo)
+		assert.Equal(t, credsFile, cmd.PasswordFile)
+	}
+}
+
+func TestProcessBackupPruneImmutableStorage(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		t.Run(fmt.Sprintf("skipImmutableErrors=%t", skip), func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			defer td.controller.credentialsFiles.Clear()
+
+			td.controller.pruneTrigger = NewPruneTrigger(2, 0)
+			td.controller.skipImmutableErrors = skip
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			var prunes int
+			td.controller.pruneRepoFunc = func(context.Context, *restic.Command) error {
+				prunes++
+				return restic.NewError(errors.New("exit status 1"), "AccessDenied: Access Denied because object protected by object lock.")
+			}
+
+			for i := 0; i < 3; i++ {
+				td.pvb = newTestPodVolumeBackup(fmt.Sprintf("pvb-%d", i), "node-1")
+				td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+				td.pvb.Spec.Volume = "vol-1"
+
+				require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+				assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+			}
+
+			// a failed prune is retried after the next backup, but a skipped
+			// one isn't retried until it's next due.
+			if skip {
+				assert.Equal(t, 1, prunes)
+			} else {
+				assert.Equal(t, 2, prunes)
+			}
+		})
+	}
+}
+
+func TestProcessBackupPruneRepositoryInUse(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+	defer td.controller.credentialsFiles.Clear()
+
+	configMaps := arktest.NewFakeConfigMaps()
+	td.controller.repoLeaser = restic.NewRepoLeaser(configMaps, "node-1", time.Minute, arktest.NewLogger())
+	td.controller.pruneTrigger = NewPruneTrigger(1, 0)
+
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+	}
+	td.withBackupPrerequisites(pod, "vol-1")
+
+	td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+		return "", "", nil
+	}
+	td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+	var prunes int
+	td.controller.pruneRepoFunc = func(context.Context, *restic.Command) error {
+		prunes++
+		return nil
+	}
+
+	// another node is backing up to the repository.
+	otherNode := restic.NewRepoLeaser(configMaps, "node-2", time.Minute, arktest.NewLogger())
+	release, err := otherNode.AcquireShared(context.Background(), repoLeaseID("", "ns-1"))
+	require.NoError(t, err)
+
+	for i, expectedPrunes := range []int{0, 1} {
+		if i == 1 {
+			release()
+		}
+
+		td.pvb = newTestPodVolumeBackup(fmt.Sprintf("pvb-%d", i), "node-1")
+		td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+		td.pvb.Spec.Volume = "vol-1"
+
+		require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+		assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+		assert.Equal(t, expectedPrunes, prunes, "backup %d", i)
+	}
+
+	// all of node-1's leases have been released.
+	_, acquired, err := otherNode.TryAcquireExclusive(repoLeaseID("", "ns-1"))
+	require.NoError(t, err)
+	assert.True(t, acquired)
+}
+
+func TestProcessBackupWaitsForRepositoryLease(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+	defer td.controller.credentialsFiles.Clear()
+
+	configMaps := arktest.NewFakeConfigMaps()
+	td.controller.repoLeaser = restic.NewRepoLeaser(configMaps, "node-1", time.Minute, arktest.NewLogger())
+	td.controller.backupTimeout = 50 * time.Millisecond
+
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+	}
+	td.withBackupPrerequisites(pod, "vol-1")
+
+	var resticRun bool
+	td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+		resticRun = true
+		return "", "", nil
+	}
+	td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+	// another node is pruning the repository.
+	otherNode := restic.NewRepoLeaser(configMaps, "node-2", time.Minute, arktest.NewLogger())
+	release, acquired, err := otherNode.TryAcquireExclusive(repoLeaseID("", "ns-1"))
+	require.NoError(t, err)
+	require.True(t, acquired)
+	defer release()
+
+	td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+	td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+	td.pvb.Spec.Volume = "vol-1"
+
+	require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+	assert.Equal(t, arkv1api.PodVolumeBackupPhaseFailed, td.pvb.Status.Phase)
+	assert.Contains(t, td.pvb.Status.Message, "error acquiring restic repository lease")
+	assert.False(t, resticRun)
+}
+
+func TestVolumeFingerprint(t *testing.T) {
+	fileSystem := arktest.NewFakeFileSystem().
+		WithDirectories("/volume/dir").
+		WithFile("/volume/a", make([]byte, 100)).
+		WithFile("/volume/dir/b", make([]byte, 50))
+
+	fingerprint, err := volumeFingerprint(fileSystem, "/volume")
+	require.NoError(t, err)
+	assert.Regexp(t, `^4-150-[0-9]+$`, fingerprint)
+
+	// unchanged contents have the same fingerprint
+	again, err := volumeFingerprint(fileSystem, "/volume")
+	require.NoError(t, err)
+	assert.Equal(t, fingerprint, again)
+
+	// adding a file changes it
+	fileSystem.WithFile("/volume/dir/c", make([]byte, 10))
+	changed, err := volumeFingerprint(fileSystem, "/volume")
+	require.NoError(t, err)
+	assert.NotEqual(t, fingerprint, changed)
+	assert.Regexp(t, `^5-160-[0-9]+$`, changed)
+
+	_, err = volumeFingerprint(fileSystem, "/missing")
+	assert.Error(t, err)
+}
+
+func TestProcessBackupSkipUnchangedVolumes(t *testing.T) {
+	tests := []struct {
+		name               string
+		skipUnchanged      bool
+		force              bool
+		previousSnapshot   bool
+		lookupErr          error
+		expectRestic       bool
+		expectedSnapshotID string
+		expectedMessage    string
+	}{
+		{
+			name:               "disabled",
+			previousSnapshot:   true,
+			expectRestic:       true,
+			expectedSnapshotID: "snapshot-vol-1",
+		},
+		{
+			name:               "unchanged volume reuses the previous snapshot",
+			skipUnchanged:      true,
+			previousSnapshot:   true,
+			expectedSnapshotID: "previous-snapshot",
+			expectedMessage:    "volume vol-1: unchanged since snapshot previous-snapshot, restic backup skipped",
+		},
+		{
+			name:               "changed volume is backed up",
+			skipUnchanged:      true,
+			expectRestic:       true,
+			expectedSnapshotID: "snapshot-vol-1",
+		},
+		{
+			name:               "volume is backed up when previous snapshots can't be listed",
+			skipUnchanged:      true,
+			previousSnapshot:   true,
+			lookupErr:          errors.New("error running command"),
+			expectRestic:       true,
+			expectedSnapshotID: "snapshot-vol-1",
+		},
+		{
+			name:               "unchanged volume is backed up when a full re-scan is forced",
+			skipUnchanged:      true,
+			force:              true,
+			previousSnapshot:   true,
+			expectRestic:       true,
+			expectedSnapshotID: "snapshot-vol-1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.skipUnchangedVolumes = test.skipUnchanged
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+			td.fileSystem.WithFile("/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1/data", make([]byte, 1024))
+
+			fingerprint, err := volumeFingerprint(td.fileSystem, "/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1")
+			require.NoError(t, err)
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+			td.pvb.Spec.Force = test.force
+
+			var backupArgs []string
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				backupArgs = cmd.Args
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+			td.controller.getLatestSnapshotIDFunc = func(ctx context.Context, cmd *restic.Command) (string, error) {
+				assert.Contains(t, strings.Join(cmd.ExtraFlags, " "), "pod-uid=pod-uid")
+				assert.Contains(t, strings.Join(cmd.ExtraFlags, " "), "volume-fingerprint="+fingerprint)
+				if test.lookupErr != nil {
+					return "", test.lookupErr
+				}
+				if !test.previousSnapshot {
+					return "", errors.WithStack(&restic.SnapshotCountError{Count: 0})
+				}
+				return "previous-snapshot", nil
+			}
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedSnapshotID, td.pvb.Status.SnapshotID)
+			assert.Equal(t, test.expectedMessage, td.pvb.Status.Message)
+
+			if !test.expectRestic {
+				assert.Nil(t, backupArgs)
+				return
+			}
+			require.NotNil(t, backupArgs)
+			if test.force {
+				assert.Contains(t, backupArgs, "--force")
+			} else {
+				assert.NotContains(t, backupArgs, "--force")
+			}
+			if test.skipUnchanged {
+				assert.Contains(t, backupArgs, "--tag=volume-fingerprint="+fingerprint)
+			} else {
+				assert.NotContains(t, strings.Join(backupArgs, " "), "volume-fingerprint")
+			}
+		})
+	}
+}
+
+func TestBackupPriority(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+
+	for name, priority := range map[string]string{
+		"logs":    "",
+		"db":      "100",
+		"cache":   "-10",
+		"invalid": "high",
+		"web":     "",
+	} {
+		pvb := newTestPodVolumeBackup(name, "node-1")
+		if priority != "" {
+			pvb.Annotations = map[string]string{restic.BackupPriorityAnnotation: priority}
+		}
+		require.NoError(t, td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore().Add(pvb))
+	}
+
+	for _, name := range []string{"logs", "cache", "invalid", "db", "web", "deleted"} {
+		td.controller.enqueue(newTestPodVolumeBackup(name, "node-1"))
+	}
+
+	var processed []string
+	for td.controller.queue.Len() > 0 {
+		key, _ := td.controller.queue.Get()
+		td.controller.queue.Done(key)
+		processed = append(processed, key.(string))
+	}
+
+	// PodVolumeBackups with an invalid priority, and those that no longer
+	// exist, get the default priority
+	assert.Equal(t, []string{"heptio-ark/db", "heptio-ark/logs", "heptio-ark/invalid", "heptio-ark/web", "heptio-ark/deleted", "heptio-ark/cache"}, processed)
+}
+
+func TestProcessBackupRecordsCommand(t *testing.T) {
+	tests := []struct {
+		name            string
+		passwordCommand string
+		dryRun          bool
+	}{
+		{
+			name: "credentials secret",
+		},
+		{
+			name:            "password command",
+			passwordCommand: "cat /credentials/restic-password",
+		},
+		{
+			name:   "dry run",
+			dryRun: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			defer td.controller.credentialsFiles.Clear()
+
+			td.controller.resticPasswordCommand = test.passwordCommand
+			td.controller.dryRun = test.dryRun
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+			td.pvb.Spec.RepoPrefix = "s3:s3.amazonaws.com/bucket"
+
+			var ran []string
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				ran = cmd.Args
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			command := td.pvb.Status.Command
+			assert.Contains(t, command, " backup ")
+			assert.Contains(t, command, "--repo=s3:s3.amazonaws.com/bucket/ns-1")
+			assert.Contains(t, command, "/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1")
+			assert.Contains(t, command, "volume=vol-1")
+
+			if test.passwordCommand != "" {
+				assert.Contains(t, command, "--password-command=<redacted>")
+				assert.NotContains(t, command, test.passwordCommand)
+			} else {
+				file, err := td.controller.credentialsFiles.Get("ns-1")
+				require.NoError(t, err)
+				assert.Contains(t, command, "--password-file=<redacted>")
+				assert.NotContains(t, command, file)
+			}
+
+			if test.dryRun {
+				assert.Empty(t, ran)
+			} else {
+				assert.NotEmpty(t, ran)
+			}
+		})
+	}
+}
+
+func TestProcessBackupDeletedPod(t *testing.T) {
+	emptyDirVolume := corev1api.Volume{
+		Name:         "vol-1",
+		VolumeSource: corev1api.VolumeSource{EmptyDir: &corev1api.EmptyDirVolumeSource{}},
+	}
+	pvcVolume := corev1api.Volume{
+		Name:         "vol-1",
+		VolumeSource: corev1api.VolumeSource{PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "pvc-1"}},
+	}
+
+	tests := []struct {
+		name           string
+		podVolumes     []corev1api.Volume
+		dirs           []string
+		expectedPhase  arkv1api.PodVolumeBackupPhase
+		expectedReason arkv1api.PodVolumeBackupFailureReason
+		expectedPath   string
+	}{
+		{
+			name:          "recorded emptyDir volume whose directory still exists is backed up",
+			podVolumes:    []corev1api.Volume{emptyDirVolume},
+			dirs:          []string{"/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1"},
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedPath:  "/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1",
+		},
+		{
+			name:          "recorded PVC volume whose directory still exists is backed up",
+			podVolumes:    []corev1api.Volume{pvcVolume},
+			dirs:          []string{"/host_pods/pod-uid/volumes/kubernetes.io~csi/pv-1"},
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedPath:  "/host_pods/pod-uid/volumes/kubernetes.io~csi/pv-1",
+		},
+		{
+			name:           "recorded volume whose directory has been removed fails",
+			podVolumes:     []corev1api.Volume{emptyDirVolume},
+			expectedPhase:  arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonVolumeNotFound,
+		},
+		{
+			name:           "no recorded volumes fails",
+			dirs:           []string{"/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1"},
+			expectedPhase:  arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonUnknown,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			defer td.controller.credentialsFiles.Clear()
+
+			// the namespace's credentials, but no pod
+			td.withBackupPrerequisites(&corev1api.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "other-pod", UID: "other-uid"}})
+			td.kubeInformers.Core().V1().PersistentVolumeClaims().Informer().GetStore().Add(&corev1api.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "pvc-1"},
+				Spec:       corev1api.PersistentVolumeClaimSpec{VolumeName: "pv-1"},
+			})
+			for _, dir := range test.dirs {
+				td.fileSystem.WithDirectory(dir)
+			}
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: "ns-1", Name: "pod-1", UID: "pod-uid"}
+			td.pvb.Spec.Volume = "vol-1"
+			td.pvb.Spec.PodVolumes = test.podVolumes
+
+			var backedUp []string
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				backedUp = append(backedUp, cmd.Args...)
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedReason, td.pvb.Status.FailureReason)
+
+			if test.expectedPath != "" {
+				assert.Equal(t, test.expectedPath, td.pvb.Status.Path)
+				assert.Contains(t, backedUp, test.expectedPath)
+				assert.Equal(t, "snapshot-vol-1", td.pvb.Status.SnapshotID)
+			} else {
+				assert.Empty(t, backedUp)
+			}
+		})
+	}
+}
+
+func TestProcessBackupSnapshotIDFiltersOnPodVolumeBackupUID(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+	}
+	td.withBackupPrerequisites(pod, "vol-1")
+
+	td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+	td.pvb.UID = "pvb-uid-1"
+	td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+	td.pvb.Spec.Volume = "vol-1"
+	td.pvb.Spec.Tags = map[string]string{"pod-uid": "pod-uid"}
+
+	var backupArgs []string
+	td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+		backupArgs = cmd.Args
+		return "", "", nil
+	}
+
+	// another backup of the same volume may have taken a more recent
+	// snapshot, so only a snapshot tagged with this PodVolumeBackup's UID
+	// is this backup's.
+	var snapshotTagFilter string
+	td.controller.getSnapshotIDFunc = func(_ context.Context, cmd *restic.Command) (string, error) {
+		for _, flag := range cmd.ExtraFlags {
+			if strings.HasPrefix(flag, "--tag=") {
+				snapshotTagFilter = flag
+			}
+		}
+
+		for _, tag := range strings.Split(strings.TrimPrefix(snapshotTagFilter, "--tag="), ",") {
+			if tag == restic.PodVolumeBackupUIDTag+"=pvb-uid-1" {
+				return "this-backups-snapshot", nil
+			}
+		}
+		return "other-backups-snapshot", nil
+	}
+
+	require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+	assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+
+	assert.Contains(t, backupArgs, "--tag="+restic.PodVolumeBackupUIDTag+"=pvb-uid-1")
+	assert.Contains(t, strings.Split(strings.TrimPrefix(snapshotTagFilter, "--tag="), ","), restic.PodVolumeBackupUIDTag+"=pvb-uid-1")
+	assert.Equal(t, "this-backups-snapshot", td.pvb.Status.SnapshotID)
+}
+
+// repositoryMetricValue returns the value of the named restic repository
+// metric for the namespace, as reported by node-1.
+func repositoryMetricValue(t *testing.T, m *metrics.ServerMetrics, name, namespace string) (float64, bool) {
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(m))
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+
+			if labels["node"] == "node-1" && labels["namespace"] == namespace {
+				return metric.Gauge.GetValue(), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func TestRecordRepositoryStats(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+	td.controller.resticPasswordFile = "/credentials/restic-password"
+
+	now := time.Now()
+	for _, pvb := range []struct {
+		name       string
+		node       string
+		namespace  string
+		repoPrefix string
+		phase      arkv1api.PodVolumeBackupPhase
+		completed  time.Time
+	}{
+		{"ns-1-old", "node-1", "ns-1", "s3:old-bucket", arkv1api.PodVolumeBackupPhaseCompleted, now.Add(-time.Hour)},
+		{"ns-1-new", "node-1", "ns-1", "s3:bucket", arkv1api.PodVolumeBackupPhaseCompleted, now},
+		{"ns-2-failed", "node-1", "ns-2", "s3:bucket", arkv1api.PodVolumeBackupPhaseFailed, now},
+		{"ns-3-other-node", "node-2", "ns-3", "s3:bucket", arkv1api.PodVolumeBackupPhaseCompleted, now},
+		{"ns-4-error", "node-1", "ns-4", "s3:bucket", arkv1api.PodVolumeBackupPhaseCompleted, now},
+		{"ns-5", "node-1", "ns-5", "s3:bucket", arkv1api.PodVolumeBackupPhaseCompleted, now},
+	} {
+		obj := newTestPodVolumeBackup(pvb.name, pvb.node)
+		obj.Spec.Pod = corev1api.ObjectReference{Namespace: pvb.namespace, Name: "pod-1"}
+		obj.Spec.RepoPrefix = pvb.repoPrefix
+		obj.Status.Phase = pvb.phase
+		obj.Status.CompletionTimestamp = metav1.NewTime(pvb.completed)
+		require.NoError(t, td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore().Add(obj))
+	}
+
+	var repos []string
+	td.controller.getRepoStatsFunc = func(_ context.Context, cmd *restic.Command) (restic.RepoStats, error) {
+		// each check holds a backup slot, so none are left.
+		assert.False(t, td.controller.backupSemaphore.TryAcquire(1))
+
+		repos = append(repos, cmd.RepoPrefix+"/"+cmd.Repo)
+		assert.Equal(t, []string{"--json", "--mode=raw-data"}, cmd.ExtraFlags)
+
+		switch cmd.Repo {
+		case "ns-1":
+			return restic.RepoStats{TotalSize: 1024, SnapshotsCount: 3}, nil
+		case "ns-5":
+			return restic.RepoStats{TotalSize: 2048, SnapshotsCount: 1}, nil
+		default:
+			return restic.RepoStats{}, errors.New("stats failed")
+		}
+	}
+
+	td.controller.recordRepositoryStats(context.Background())
+
+	// only repositories with completed backups from this node are checked,
+	// using the prefix of the namespace's most recent backup.
+	assert.Equal(t, []string{"s3:bucket/ns-1", "s3:bucket/ns-4", "s3:bucket/ns-5"}, repos)
+
+	for _, test := range []struct {
+		namespace         string
+		expectedSize      float64
+		expectedSnapshots float64
+		expectedOK        bool
+	}{
+		{namespace: "ns-1", expectedSize: 1024, expectedSnapshots: 3, expectedOK: true},
+		{namespace: "ns-2"},
+		{namespace: "ns-4"},
+		{namespace: "ns-5", expectedSize: 2048, expectedSnapshots: 1, expectedOK: true},
+	} {
+		size, ok := repositoryMetricValue(t, td.controller.metrics, "ark_restic_repository_size_bytes", test.namespace)
+		assert.Equal(t, test.expectedOK, ok, test.namespace)
+		assert.Equal(t, test.expectedSize, size, test.namespace)
+
+		snapshots, ok := repositoryMetricValue(t, td.controller.metrics, "ark_restic_repository_snapshots", test.namespace)
+		assert.Equal(t, test.expectedOK, ok, test.namespace)
+		assert.Equal(t, test.expectedSnapshots, snapshots, test.namespace)
+	}
+}
+
+func TestProcessBackupAddsForgetSnapshotsFinalizer(t *testing.T) {
+	tests := []struct {
+		name              string
+		deletionPolicy    SnapshotDeletionPolicy
+		dryRun            bool
+		finalizers        []string
+		expectedFinalizer []string
+	}{
+		{
+			name:           "retain policy doesn't add the finalizer",
+			deletionPolicy: SnapshotDeletionPolicyRetain,
+		},
+		{
+			name:              "forget policy adds the finalizer",
+			deletionPolicy:    SnapshotDeletionPolicyForget,
+			expectedFinalizer: []string{forgetSnapshotsFinalizer},
+		},
+		{
+			name:              "forget policy keeps existing finalizers",
+			deletionPolicy:    SnapshotDeletionPolicyForget,
+			finalizers:        []string{"foo"},
+			expectedFinalizer: []string{"foo", forgetSnapshotsFinalizer},
+		},
+		{
+			name:              "finalizer isn't added twice",
+			deletionPolicy:    SnapshotDeletionPolicyForget,
+			finalizers:        []string{forgetSnapshotsFinalizer},
+			expectedFinalizer: []string{forgetSnapshotsFinalizer},
+		},
+		{
+			name:           "forget policy doesn't add the finalizer in a dry run",
+			deletionPolicy: SnapshotDeletionPolicyForget,
+			dryRun:         true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			defer td.controller.credentialsFiles.Clear()
+
+			td.controller.deletionPolicy = test.deletionPolicy
+			td.controller.dryRun = test.dryRun
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Finalizers = test.finalizers
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volume = "vol-1"
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+			assert.Equal(t, test.expectedFinalizer, td.pvb.Finalizers)
+		})
+	}
+}
+
+func TestProcessQueueItemForgetsSnapshotsOnDeletion(t *testing.T) {
+	tests := []struct {
+		name               string
+		deletionPolicy     SnapshotDeletionPolicy
+		deleted            bool
+		finalizers         []string
+		phase              arkv1api.PodVolumeBackupPhase
+		running            bool
+		others             []*arkv1api.PodVolumeBackup
+		forgetErr          error
+		skipImmutable      bool
+		expectErr          bool
+		expectedForgotten  []string
+		expectedFinalizers []string
+	}{
+		{
+			name:               "snapshots of a deleted PodVolumeBackup are forgotten and the finalizer is removed",
+			deletionPolicy:     SnapshotDeletionPolicyForget,
+			deleted:            true,
+			finalizers:         []string{"foo", forgetSnapshotsFinalizer},
+			phase:              arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedForgotten:  []string{"snapshot-vol-1", "snapshot-vol-2"},
+			expectedFinalizers: []string{"foo"},
+		},
+		{
+			name:              "snapshots of a failed PodVolumeBackup are forgotten",
+			deletionPolicy:    SnapshotDeletionPolicyForget,
+			deleted:           true,
+			finalizers:        []string{forgetSnapshotsFinalizer},
+			phase:             arkv1api.PodVolumeBackupPhaseFailed,
+			expectedForgotten: []string{"snapshot-vol-1", "snapshot-vol-2"},
+		},
+		{
+			name:           "retain policy removes the finalizer without forgetting snapshots",
+			deletionPolicy: SnapshotDeletionPolicyRetain,
+			deleted:        true,
+			finalizers:     []string{forgetSnapshotsFinalizer},
+			phase:          arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+		{
+			name:           "snapshots recorded by another PodVolumeBackup aren't forgotten",
+			deletionPolicy: SnapshotDeletionPolicyForget,
+			deleted:        true,
+			finalizers:     []string{forgetSnapshotsFinalizer},
+			phase:          arkv1api.PodVolumeBackupPhaseCompleted,
+			others: []*arkv1api.PodVolumeBackup{
+				{
+					ObjectMeta: metav1.ObjectMeta{Namespace: arkv1api.DefaultNamespace, Name: "pvb-2"},
+					Spec:       arkv1api.PodVolumeBackupSpec{Pod: corev1api.ObjectReference{Namespace: "ns-1"}},
+					Status:     arkv1api.PodVolumeBackupStatus{SnapshotIDs: map[string]string{"vol-1": "snapshot-vol-1"}},
+				},
+			},
+			expectedForgotten: []string{"snapshot-vol-2"},
+		},
+		{
+			name:           "snapshots recorded by another PodVolumeBackup that's being deleted are forgotten",
+			deletionPolicy: SnapshotDeletionPolicyForget,
+			deleted:        true,
+			finalizers:     []string{forgetSnapshotsFinalizer},
+			phase:          arkv1api.PodVolumeBackupPhaseCompleted,
+			others: []*arkv1api.PodVolumeBackup{
+				{
+					ObjectMeta: metav1.ObjectMeta{Namespace: arkv1api.DefaultNamespace, Name: "pvb-2", DeletionTimestamp: &metav1.Time{Time: time.Now()}},
+					Spec:       arkv1api.PodVolumeBackupSpec{Pod: corev1api.ObjectReference{Namespace: "ns-1"}},
+					Status:     arkv1api.PodVolumeBackupStatus{SnapshotIDs: map[string]string{"vol-1": "snapshot-vol-1"}},
+				},
+			},
+			expectedForgotten: []string{"snapshot-vol-1", "snapshot-vol-2"},
+		},
+		{
+			name:               "finalizer is kept if a snapshot can't be forgotten",
+			deletionPolicy:     SnapshotDeletionPolicyForget,
+			deleted:            true,
+			finalizers:         []string{forgetSnapshotsFinalizer},
+			phase:              arkv1api.PodVolumeBackupPhaseCompleted,
+			forgetErr:          errors.New("repository is already locked"),
+			expectErr:          true,
+			expectedForgotten:  []string{"snapshot-vol-1"},
+			expectedFinalizers: []string{forgetSnapshotsFinalizer},
+		},
+		{
+			name:               "finalizer is kept if the repository's storage is immutable",
+			deletionPolicy:     SnapshotDeletionPolicyForget,
+			deleted:            true,
+			finalizers:         []string{forgetSnapshotsFinalizer},
+			phase:              arkv1api.PodVolumeBackupPhaseCompleted,
+			forgetErr:          restic.NewError(errors.New("exit status 1"), "AccessDenied: Access Denied because object protected by object lock."),
+			expectErr:          true,
+			expectedForgotten:  []string{"snapshot-vol-1"},
+			expectedFinalizers: []string{forgetSnapshotsFinalizer},
+		},
+		{
+			name:              "snapshots in immutable storage are skipped when configured",
+			deletionPolicy:    SnapshotDeletionPolicyForget,
+			deleted:           true,
+			finalizers:        []string{forgetSnapshotsFinalizer},
+			phase:             arkv1api.PodVolumeBackupPhaseCompleted,
+			forgetErr:         restic.NewError(errors.New("exit status 1"), "AccessDenied: Access Denied because object protected by object lock."),
+			skipImmutable:     true,
+			expectedForgotten: []string{"snapshot-vol-1", "snapshot-vol-2"},
+		},
+		{
+			name:               "PodVolumeBackup that's still running isn't finalized",
+			deletionPolicy:     SnapshotDeletionPolicyForget,
+			deleted:            true,
+			finalizers:         []string{forgetSnapshotsFinalizer},
+			phase:              arkv1api.PodVolumeBackupPhaseInProgress,
+			running:            true,
+			expectedFinalizers: []string{forgetSnapshotsFinalizer},
+		},
+		{
+			name:               "PodVolumeBackup that isn't being deleted isn't finalized",
+			deletionPolicy:     SnapshotDeletionPolicyForget,
+			finalizers:         []string{forgetSnapshotsFinalizer},
+			phase:              arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedFinalizers: []string{forgetSnapshotsFinalizer},
+		},
+		{
+			name:           "PodVolumeBackup without the finalizer isn't finalized",
+			deletionPolicy: SnapshotDeletionPolicyForget,
+			deleted:        true,
+			phase:          arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			defer td.controller.credentialsFiles.Clear()
+
+			td.controller.deletionPolicy = test.deletionPolicy
+			td.controller.skipImmutableErrors = test.skipImmutable
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+			}
+			td.withBackupPrerequisites(pod)
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Finalizers = test.finalizers
+			if test.deleted {
+				td.pvb.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			}
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Status.Phase = test.phase
+			td.pvb.Status.SnapshotIDs = map[string]string{"vol-1": "snapshot-vol-1", "vol-2": "snapshot-vol-2"}
+
+			store := td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore()
+			require.NoError(t, store.Add(td.pvb.DeepCopy()))
+			for _, other := range test.others {
+				require.NoError(t, store.Add(other))
+			}
+
+			key := kube.NamespaceAndName(td.pvb)
+			if test.running {
+				td.controller.trackBackup(key, func() {})
+			}
+
+			var forgotten []string
+			td.controller.forgetSnapshotFunc = func(_ context.Context, cmd *restic.Command) error {
+				assert.Equal(t, "forget", cmd.Command)
+				assert.Equal(t, "ns-1", cmd.Repo)
+				assert.NotEmpty(t, cmd.PasswordFile)
+				require.Len(t, cmd.Args, 1)
+
+				forgotten = append(forgotten, cmd.Args[0])
+				return test.forgetErr
+			}
+
+			err := td.controller.processQueueItem(key)
+			if test.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, test.expectedForgotten, forgotten)
+			assert.Equal(t, test.expectedFinalizers, td.pvb.Finalizers)
+		})
+	}
+}
+
+func TestProcessQueueItemBackupsPaused(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+
+	node := &corev1api.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	nodeStore := td.kubeInformers.Core().V1().Nodes().Informer().GetStore()
+	require.NoError(t, nodeStore.Add(node))
+
+	pvbStore := td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore()
+	td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+	require.NoError(t, pvbStore.Add(td.pvb.DeepCopy()))
+	require.NoError(t, pvbStore.Add(newTestPodVolumeBackup("pvb-2", "node-2")))
+
+	var processed []string
+	td.controller.processBackupFunc = func(_ context.Context, req *arkv1api.PodVolumeBackup) error {
+		processed = append(processed, req.Name)
+		return nil
+	}
+
+	// a backup that's running when backups are paused isn't canceled
+	canceled := false
+	td.controller.trackBackup("heptio-ark/pvb-running", func() { canceled = true })
+
+	// pause backups
+	pausedNode := node.DeepCopy()
+	pausedNode.Annotations = map[string]string{restic.BackupsPausedAnnotation: "true"}
+	require.NoError(t, nodeStore.Update(pausedNode))
+	td.controller.nodeHandler(node, pausedNode)
+	assert.Equal(t, 0, td.controller.queue.Len())
+
+	key := kube.NamespaceAndName(td.pvb)
+	require.NoError(t, td.controller.processQueueItem(key))
+	assert.Empty(t, processed)
+	assert.Equal(t, arkv1api.PodVolumeBackupPhase(""), td.pvb.Status.Phase)
+	assert.False(t, canceled)
+
+	// resuming backups enqueues this node's PodVolumeBackups, and the
+	// ones that were requested while paused are started
+	require.NoError(t, nodeStore.Update(node))
+	td.controller.nodeHandler(pausedNode, node)
+	require.Equal(t, 1, td.controller.queue.Len())
+
+	item, _ := td.controller.queue.Get()
+	assert.Equal(t, kube.NamespaceAndName(td.pvb), item)
+	require.NoError(t, td.controller.processQueueItem(item.(string)))
+	td.controller.queue.Done(item)
+
+	assert.Equal(t, []string{"pvb-1"}, processed)
+	assert.False(t, canceled)
+}
+
+func TestProcessQueueItemInvalidPodVolumeBackup(t *testing.T) {
+	tests := []struct {
+		name            string
+		repoPrefix      string
+		podName         string
+		expectProcessed bool
+		expectedMessage string
+	}{
+		{
+			name:            "valid backup is processed",
+			repoPrefix:      "s3:s3.amazonaws.com/bucket",
+			podName:         "pod-1",
+			expectProcessed: true,
+		},
+		{
+			name:            "backup without a repoPrefix is failed",
+			podName:         "pod-1",
+			expectedMessage: "invalid pod volume backup: repoPrefix is required",
+		},
+		{
+			name:            "backup of a pod that doesn't exist is failed",
+			repoPrefix:      "s3:s3.amazonaws.com/bucket",
+			podName:         "pod-2",
+			expectedMessage: "invalid pod volume backup: pod ns-1/pod-2 not found",
+		},
+		{
+			name:            "every problem is reported",
+			podName:         "pod-2",
+			expectedMessage: "invalid pod volume backup: repoPrefix is required; pod ns-1/pod-2 not found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.validateFunc = func(pvb *arkv1api.PodVolumeBackup) []string {
+				return restic.ValidatePodVolumeBackup(pvb, td.controller.podLister)
+			}
+
+			pod := &corev1api.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "pod-1", UID: "pod-uid"}}
+			td.withBackupPrerequisites(pod, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: "ns-1", Name: test.podName}
+			td.pvb.Spec.Volume = "vol-1"
+			td.pvb.Spec.RepoPrefix = test.repoPrefix
+			require.NoError(t, td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore().Add(td.pvb.DeepCopy()))
+
+			processed := false
+			td.controller.processBackupFunc = func(context.Context, *arkv1api.PodVolumeBackup) error {
+				processed = true
+				return nil
+			}
+
+			require.NoError(t, td.controller.processQueueItem(kube.NamespaceAndName(td.pvb)))
+
+			assert.Equal(t, test.expectProcessed, processed)
+			if !test.expectProcessed {
+				assert.Equal(t, arkv1api.PodVolumeBackupPhaseFailed, td.pvb.Status.Phase)
+				assert.Equal(t, arkv1api.PodVolumeBackupFailureReasonInvalidSpec, td.pvb.Status.FailureReason)
+				assert.Equal(t, test.expectedMessage, td.pvb.Status.Message)
+			}
+		})
+	}
+}
+
+func TestNodeHandlerIgnoresOtherNodes(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+
+	require.NoError(t, td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore().Add(newTestPodVolumeBackup("pvb-1", "node-1")))
+
+	paused := &corev1api.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2", Annotations: map[string]string{restic.BackupsPausedAnnotation: "true"}}}
+	resumed := &corev1api.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}}
+	td.controller.nodeHandler(paused, resumed)
+
+	assert.Equal(t, 0, td.controller.queue.Len())
+}
+
+func TestProcessQueueItemNodePressure(t *testing.T) {
+	tests := []struct {
+		name               string
+		pressureConditions []corev1api.NodeConditionType
+		nodeConditions     []corev1api.NodeCondition
+		expectProcessed    bool
+	}{
+		{
+			name:               "backup is started on a node without pressure",
+			pressureConditions: []corev1api.NodeConditionType{corev1api.NodeMemoryPressure, corev1api.NodeDiskPressure},
+			nodeConditions: []corev1api.NodeCondition{
+				{Type: corev1api.NodeReady, Status: corev1api.ConditionTrue},
+				{Type: corev1api.NodeMemoryPressure, Status: corev1api.ConditionFalse},
+				{Type: corev1api.NodeDiskPressure, Status: corev1api.ConditionFalse},
+			},
+			expectProcessed: true,
+		},
+		{
+			name:               "backup is deferred on a node under memory pressure",
+			pressureConditions: []corev1api.NodeConditionType{corev1api.NodeMemoryPressure, corev1api.NodeDiskPressure},
+			nodeConditions: []corev1api.NodeCondition{
+				{Type: corev1api.NodeReady, Status: corev1api.ConditionTrue},
+				{Type: corev1api.NodeMemoryPressure, Status: corev1api.ConditionTrue},
+			},
+		},
+		{
+			name:               "backup is deferred on a node under disk pressure",
+			pressureConditions: []corev1api.NodeConditionType{corev1api.NodeMemoryPressure, corev1api.NodeDiskPressure},
+			nodeConditions: []corev1api.NodeCondition{
+				{Type: corev1api.NodeDiskPressure, Status: corev1api.ConditionTrue},
+			},
+		},
+		{
+			name:               "backup is started under pressure that isn't configured",
+			pressureConditions: []corev1api.NodeConditionType{corev1api.NodeMemoryPressure},
+			nodeConditions: []corev1api.NodeCondition{
+				{Type: corev1api.NodeDiskPressure, Status: corev1api.ConditionTrue},
+			},
+			expectProcessed: true,
+		},
+		{
+			name: "backup is started under pressure when deferral is disabled",
+			nodeConditions: []corev1api.NodeCondition{
+				{Type: corev1api.NodeMemoryPressure, Status: corev1api.ConditionTrue},
+			},
+			expectProcessed: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.pressureConditions = test.pressureConditions
+
+			node := &corev1api.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+				Status:     corev1api.NodeStatus{Conditions: test.nodeConditions},
+			}
+			require.NoError(t, td.kubeInformers.Core().V1().Nodes().Informer().GetStore().Add(node))
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			require.NoError(t, td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore().Add(td.pvb.DeepCopy()))
+
+			processed := false
+			td.controller.processBackupFunc = func(context.Context, *arkv1api.PodVolumeBackup) error {
+				processed = true
+				return nil
+			}
+
+			key := kube.NamespaceAndName(td.pvb)
+			require.NoError(t, td.controller.processQueueItem(key))
+			assert.Equal(t, test.expectProcessed, processed)
+
+			// a deferred backup is requeued after the retry delay, which
+			// is 0 in tests.
+			if test.expectProcessed {
+				assert.Equal(t, 0, td.controller.queue.Len())
+			} else {
+				require.Equal(t, 1, td.controller.queue.Len())
+				item, _ := td.controller.queue.Get()
+				assert.Equal(t, key, item)
+			}
+		})
+	}
+}
+
+func TestQueueDepthMetric(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+
+	td.controller.queue.Add("ns-1/pvb-1")
+	td.controller.queue.Add("ns-1/pvb-2")
+	td.controller.queue.Add("ns-1/pvb-3")
+	assert.Equal(t, float64(3), metricValue(t, td.controller.metrics, "ark_pod_volume_backup_queue_depth"))
+
+	item, _ := td.controller.queue.Get()
+	assert.Equal(t, float64(2), metricValue(t, td.controller.metrics, "ark_pod_volume_backup_queue_depth"))
+
+	// an item that's being processed isn't waiting, so it isn't counted
+	// again when it's re-added until it's done.
+	td.controller.queue.Add(item)
+	assert.Equal(t, float64(2), metricValue(t, td.controller.metrics, "ark_pod_volume_backup_queue_depth"))
+	td.controller.queue.Done(item)
+	assert.Equal(t, float64(3), metricValue(t, td.controller.metrics, "ark_pod_volume_backup_queue_depth"))
+}
+
+func TestQueueMaxDepthDefersLowestPriorityBackups(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+	fakeClock := clock.NewFakeClock(time.Now())
+	queue := td.controller.queue.(*priorityQueue)
+	queue.clock = fakeClock
+	queue.maxLen = 2
+	queue.deferDelay = time.Minute
+
+	store := td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore()
+	add := func(name, node, priority string, phase arkv1api.PodVolumeBackupPhase) {
+		pvb := newTestPodVolumeBackup(name, node)
+		pvb.Annotations = map[string]string{restic.BackupPriorityAnnotation: priority}
+		pvb.Status.Phase = phase
+		require.NoError(t, store.Add(pvb))
+		td.controller.queue.Add(kube.NamespaceAndName(pvb))
+	}
+
+	// the queue fills up with low priority backups, which are deferred
+	// as higher priority ones are added, lowest priority first.
+	add("low", "node-1", "1", arkv1api.PodVolumeBackupPhaseNew)
+	add("lowest", "node-1", "0", arkv1api.PodVolumeBackupPhaseNew)
+	add("high", "node-1", "10", arkv1api.PodVolumeBackupPhaseNew)
+	add("mid", "node-1", "5", arkv1api.PodVolumeBackupPhaseNew)
+	// a new backup that isn't higher priority than any queued one is
+	// deferred itself.
+	add("late", "node-1", "1", arkv1api.PodVolumeBackupPhaseNew)
+	// backups that aren't new, or that are for other nodes, are always
+	// queued.
+	add("completed", "node-1", "0", arkv1api.PodVolumeBackupPhaseCompleted)
+	add("other-node", "node-2", "0", arkv1api.PodVolumeBackupPhaseNew)
+
+	assert.Equal(t, []interface{}{
+		"heptio-ark/high",
+		"heptio-ark/mid",
+		"heptio-ark/completed",
+		"heptio-ark/other-node",
+	}, drain(queue))
+
+	// deferred backups are added again after the retry delay, and the
+	// lowest priority one is deferred again since the queue is full.
+	fakeClock.Step(time.Minute)
+	deadline := time.Now().Add(5 * time.Second)
+	for queue.Len() < 2 || !fakeClock.HasWaiters() {
+		if time.Now().After(deadline) {
+			require.FailNow(t, "timed out waiting for deferred backups to be queued")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	queued := drain(queue)
+	assert.Len(t, queued, 2)
+	assert.Contains(t, queued, "heptio-ark/low")
+	assert.Contains(t, queued, "heptio-ark/late")
+}
+
+func TestProcessQueueItemMaxInFlightBytes(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxBytes        int64
+		inFlight        int64
+		expectProcessed bool
+	}{
+		{
+			name:            "backup is started when the limit is disabled",
+			inFlight:        1000,
+			expectProcessed: true,
+		},
+		{
+			name:            "backup is started when the in-flight bytes are within the limit",
+			maxBytes:        1000,
+			inFlight:        999,
+			expectProcessed: true,
+		},
+		{
+			name:     "backup is deferred when the in-flight bytes reach the limit",
+			maxBytes: 1000,
+			inFlight: 1000,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			if test.maxBytes > 0 {
+				td.controller.inFlightBytes = newInFlightBytes(test.maxBytes)
+				td.controller.inFlightBytes.Add(test.inFlight)
+			}
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			require.NoError(t, td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore().Add(td.pvb.DeepCopy()))
+
+			processed := false
+			td.controller.processBackupFunc = func(context.Context, *arkv1api.PodVolumeBackup) error {
+				processed = true
+				return nil
+			}
+
+			key := kube.NamespaceAndName(td.pvb)
+			require.NoError(t, td.controller.processQueueItem(key))
+			assert.Equal(t, test.expectProcessed, processed)
+
+			// a deferred backup is requeued after the retry delay, which
+			// is 0 in tests.
+			if test.expectProcessed {
+				assert.Equal(t, 0, td.controller.queue.Len())
+			} else {
+				assert.Equal(t, 1, td.controller.queue.Len())
+			}
+		})
+	}
+}
+
+func TestProcessBackupInFlightBytes(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(2)
+	td.controller.inFlightBytes = newInFlightBytes(1 << 30)
+	td.controller.inFlightBytes.observer = func(bytes int64) {
+		td.controller.metrics.SetPodVolumeBackupInFlightBytes(td.controller.nodeName, bytes)
+	}
+
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+	}
+	td.withBackupPrerequisites(pod, "vol-1", "vol-2")
+	volumeDir := fmt.Sprintf("%s/%s/volumes/kubernetes.io~empty-dir", td.controller.hostPodsPath, pod.UID)
+	td.fileSystem.WithFile(volumeDir+"/vol-1/file", make([]byte, 100))
+	td.fileSystem.WithFile(volumeDir+"/vol-1/dir/file", make([]byte, 20))
+	td.fileSystem.WithFile(volumeDir+"/vol-2/file", make([]byte, 300))
+
+	td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+	td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+	td.pvb.Spec.Volumes = []string{"vol-1", "vol-2"}
+
+	// each volume's size counts towards the in-flight bytes while it's
+	// being backed up.
+	var inFlight []int64
+	td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+		bytes, _ := td.controller.inFlightBytes.Exceeded()
+		inFlight = append(inFlight, bytes)
+		return "", "", nil
+	}
+	td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+	require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+	assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+
+	assert.Equal(t, []int64{120, 300}, inFlight)
+	bytes, _ := td.controller.inFlightBytes.Exceeded()
+	assert.Equal(t, int64(0), bytes)
+	assert.Equal(t, float64(0), metricValue(t, td.controller.metrics, "ark_pod_volume_backup_in_flight_bytes"))
+}
+
+func TestProcessBackupRunningBackups(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(2)
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	td.controller.clock = clock.NewFakeClock(now)
+
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+	}
+	td.withBackupPrerequisites(pod, "vol-1", "vol-2")
+
+	td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+	td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+	td.pvb.Spec.Volumes = []string{"vol-1", "vol-2"}
+
+	// the volume being backed up, and the command backing it up, are
+	// reported while restic runs.
+	var running [][]RunningBackup
+	td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+		running = append(running, td.controller.RunningBackups())
+		return "", "", nil
+	}
+	td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+	require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+	assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+
+	require.Len(t, running, 2)
+	for i, volume := range td.pvb.Spec.Volumes {
+		require.Len(t, running[i], 1)
+		assert.Equal(t, "heptio-ark/pvb-1", running[i][0].Key)
+		assert.Equal(t, now, running[i][0].StartTime)
+		assert.Equal(t, volume, running[i][0].Volume)
+		assert.Contains(t, running[i][0].Command, " backup ")
+		assert.Contains(t, running[i][0].Command, "/"+volume)
+	}
+
+	assert.Empty(t, td.controller.RunningBackups())
+}
+
+func TestProcessQueueItemRecoversStaleBackups(t *testing.T) {
+	var (
+		serverStart = time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+		now         = serverStart.Add(30 * time.Second)
+		threshold   = time.Minute
+	)
+
+	tests := []struct {
+		name           string
+		startTimestamp time.Time
+		restarts       int
+		cancel         bool
+		running        bool
+		threshold      time.Duration
+		expectedPhase  arkv1api.PodVolumeBackupPhase
+		expectedReason arkv1api.PodVolumeBackupFailureReason
+		expectedEvents []string
+	}{
+		{
+			name:           "stale backup started by a previous run of the server is reset to New",
+			startTimestamp: now.Add(-2 * time.Minute),
+			threshold:      threshold,
+			expectedPhase:  arkv1api.PodVolumeBackupPhaseNew,
+			expectedEvents: []string{"Normal BackupRestarted Restarting backup interrupted while InProgress (restart 1 of 3)"},
+		},
+		{
+			name:           "backup started by a previous run of the server that isn't stale yet is requeued",
+			startTimestamp: now.Add(-20 * time.Second),
+			threshold:      threshold,
+			expectedPhase:  arkv1api.PodVolumeBackupPhaseInProgress,
+		},
+		{
+			name:           "stale backup that's been restarted too many times is failed",
+			startTimestamp: now.Add(-2 * time.Minute),
+			restarts:       MaxStaleBackupRestarts,
+			threshold:      threshold,
+			expectedPhase:  arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason: arkv1api.PodVolumeBackupFailureReasonInterrupted,
+			expectedEvents: []string{"Warning BackupFailed Backup failed (Interrupted): backup was interrupted after being restarted 3 times"},
+		},
+		{
+			name:           "stale backup that's been canceled is marked as Canceled",
+			startTimestamp: now.Add(-2 * time.Minute),
+			cancel:         true,
+			threshold:      threshold,
+			expectedPhase:  arkv1api.PodVolumeBackupPhaseCanceled,
+		},
+		{
+			name:           "backup started by this run of the server is left alone",
+			startTimestamp: serverStart.Add(time.Second),
+			threshold:      threshold,
+			expectedPhase:  arkv1api.PodVolumeBackupPhaseInProgress,
+		},
+		{
+			name:           "running backup is left alone",
+			startTimestamp: now.Add(-2 * time.Minute),
+			running:        true,
+			threshold:      threshold,
+			expectedPhase:  arkv1api.PodVolumeBackupPhaseInProgress,
+		},
+		{
+			name:           "stale backup is left alone when recovery is disabled",
+			startTimestamp: now.Add(-2 * time.Minute),
+			expectedPhase:  arkv1api.PodVolumeBackupPhaseInProgress,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.clock = clock.NewFakeClock(now)
+			td.controller.startTime = serverStart
+			td.controller.staleBackupThreshold = test.threshold
+
+			var processed []string
+			td.controller.processBackupFunc = func(_ context.Context, req *arkv1api.PodVolumeBackup) error {
+				processed = append(processed, req.Name)
+				return nil
+			}
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Cancel = test.cancel
+			td.pvb.Status.Phase = arkv1api.PodVolumeBackupPhaseInProgress
+			td.pvb.Status.StartTimestamp = metav1.NewTime(test.startTimestamp)
+			td.pvb.Status.Restarts = test.restarts
+			td.pvb.Status.Progress = arkv1api.PodVolumeBackupProgress{TotalBytes: 100, BytesDone: 50}
+			require.NoError(t, td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore().Add(td.pvb.DeepCopy()))
+
+			key := kube.NamespaceAndName(td.pvb)
+			if test.running {
+				td.controller.trackBackup(key, func() {})
+			}
+
+			require.NoError(t, td.controller.processQueueItem(key))
+
+			assert.Empty(t, processed)
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedReason, td.pvb.Status.FailureReason)
+			assert.Equal(t, test.expectedEvents, td.eventRecorder.Events)
+
+			if test.expectedPhase == arkv1api.PodVolumeBackupPhaseNew {
+				assert.Equal(t, test.restarts+1, td.pvb.Status.Restarts)
+				assert.True(t, td.pvb.Status.StartTimestamp.IsZero())
+				assert.Equal(t, arkv1api.PodVolumeBackupProgress{}, td.pvb.Status.Progress)
+				assert.Contains(t, td.pvb.Status.Message, "was interrupted")
+			}
+		})
+	}
+}
+
+func TestProcessBackupVolumeAccessCheck(t *testing.T) {
+	const volumeDir = "/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1"
+
+	tests := []struct {
+		name            string
+		accessErr       error
+		expectedPhase   arkv1api.PodVolumeBackupPhase
+		expectedReason  arkv1api.PodVolumeBackupFailureReason
+		expectedMessage string
+	}{
+		{
+			name:          "readable volume directory is backed up",
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+		{
+			name:            "permission denied suggests SELinux",
+			accessErr:       errors.WithStack(&os.PathError{Op: "open", Path: volumeDir, Err: os.ErrPermission}),
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason:  arkv1api.PodVolumeBackupFailureReasonVolumeAccessDenied,
+			expectedMessage: "SELinux",
+		},
+		{
+			name:            "other errors aren't categorized",
+			accessErr:       errors.New("input/output error"),
+			expectedPhase:   arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason:  arkv1api.PodVolumeBackupFailureReasonUnknown,
+			expectedMessage: "error checking access to volume directory " + volumeDir,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			defer td.controller.credentialsFiles.Clear()
+
+			td.withBackupPrerequisites(&corev1api.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "pod-1", UID: "pod-uid"}}, "vol-1")
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: "ns-1", Name: "pod-1", UID: "pod-uid"}
+			td.pvb.Spec.Volume = "vol-1"
+
+			var checked []string
+			td.controller.checkAccessFunc = func(path string) error {
+				checked = append(checked, path)
+				return test.accessErr
+			}
+
+			var resticRuns int
+			td.controller.runCommandFunc = func(*exec.Cmd) (string, string, error) {
+				resticRuns++
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+			assert.Equal(t, []string{volumeDir}, checked)
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedReason, td.pvb.Status.FailureReason)
+			assert.Contains(t, td.pvb.Status.Message, test.expectedMessage)
+
+			if test.accessErr != nil {
+				assert.Equal(t, 0, resticRuns)
+			} else {
+				assert.Equal(t, 1, resticRuns)
+			}
+		})
+	}
+}
+
+func TestCheckDirReadable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// empty and non-empty directories are readable
+	assert.NoError(t, checkDirReadable(dir))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644))
+	assert.NoError(t, checkDirReadable(dir))
+
+	err = checkDirReadable(filepath.Join(dir, "missing"))
+	assert.True(t, os.IsNotExist(errors.Cause(err)))
+
+	// root can read any directory regardless of its permissions
+	if os.Geteuid() == 0 {
+		return
+	}
+
+	unreadable := filepath.Join(dir, "unreadable")
+	require.NoError(t, os.Mkdir(unreadable, 0))
+	err = checkDirReadable(unreadable)
+	assert.True(t, os.IsPermission(errors.Cause(err)))
+}
+
+func TestCheckDirReadableBy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	volume := filepath.Join(dir, "volume")
+	require.NoError(t, os.Mkdir(volume, 0750))
+	require.NoError(t, os.Chmod(dir, 0711))
+	runAs := &restic.RunAs{UID: 65534, GID: 65534}
+
+	assert.NoError(t, checkDirReadableBy(volume, nil))
+
+	// the volume directory must be listable
+	err = checkDirReadableBy(volume, runAs)
+	assert.True(t, os.IsPermission(errors.Cause(err)))
+	assert.EqualError(t, err, fmt.Sprintf("access %s: permission denied", volume))
+
+	require.NoError(t, os.Chmod(volume, 0755))
+	assert.NoError(t, checkDirReadableBy(volume, runAs))
+
+	// and its parents traversable
+	require.NoError(t, os.Chmod(dir, 0750))
+	err = checkDirReadableBy(volume, runAs)
+	assert.True(t, os.IsPermission(errors.Cause(err)))
+	assert.EqualError(t, err, fmt.Sprintf("access %s: permission denied", dir))
+}
+
+func TestResticCommandRunAs(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+
+	cmd := td.controller.resticCommand(&restic.Command{Command: "backup"})
+	assert.Nil(t, cmd.RunAs)
+
+	td.controller.resticRunAs = &restic.RunAs{UID: 1000, GID: 2000}
+	cmd = td.controller.resticCommand(&restic.Command{Command: "backup"})
+	assert.Equal(t, &restic.RunAs{UID: 1000, GID: 2000}, cmd.RunAs)
+}
+
+func TestResticCommandBackendOptions(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+
+	cmd := td.controller.resticCommand(&restic.Command{Command: "backup", RepoPrefix: "s3:s3.amazonaws.com/bucket"})
+	assert.Empty(t, cmd.Options)
+
+	backendOptions, err := restic.NewBackendOptions([]string{"gs.connections=2"})
+	require.NoError(t, err)
+	td.controller.backendOptions = backendOptions
+
+	cmd = td.controller.resticCommand(&restic.Command{Command: "backup", RepoPrefix: "s3:s3.amazonaws.com/bucket"})
+	assert.Empty(t, cmd.Options)
+
+	cmd = td.controller.resticCommand(&restic.Command{Command: "backup", RepoPrefix: "gs:bucket:"})
+	assert.Equal(t, []string{"gs.connections=2"}, cmd.Options)
+}
+
+func TestVerificationCandidates(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	td.controller.clock = clock.NewFakeClock(now)
+	td.controller.verifyInterval = time.Hour
+	td.controller.maxVerifications = 3
+
+	for _, pvb := range []struct {
+		name         string
+		node         string
+		phase        arkv1api.PodVolumeBackupPhase
+		snapshotID   string
+		completed    time.Time
+		lastVerified time.Time
+		deleting     bool
+	}{
+		{name: "verified-recently", node: "node-1", phase: arkv1api.PodVolumeBackupPhaseCompleted, snapshotID: "snap-1", completed: now.Add(-5 * time.Hour), lastVerified: now.Add(-30 * time.Minute)},
+		{name: "verified-long-ago", node: "node-1", phase: arkv1api.PodVolumeBackupPhaseCompleted, snapshotID: "snap-2", completed: now.Add(-5 * time.Hour), lastVerified: now.Add(-2 * time.Hour)},
+		{name: "never-verified-new", node: "node-1", phase: arkv1api.PodVolumeBackupPhaseCompleted, snapshotID: "snap-3", completed: now.Add(-time.Hour)},
+		{name: "never-verified-old", node: "node-1", phase: arkv1api.PodVolumeBackupPhaseCompleted, snapshotID: "snap-4", completed: now.Add(-3 * time.Hour)},
+		{name: "verified-longest-ago", node: "node-1", phase: arkv1api.PodVolumeBackupPhaseCompleted, snapshotID: "snap-5", completed: now.Add(-5 * time.Hour), lastVerified: now.Add(-4 * time.Hour)},
+		{name: "other-node", node: "node-2", phase: arkv1api.PodVolumeBackupPhaseCompleted, snapshotID: "snap-6", completed: now.Add(-5 * time.Hour)},
+		{name: "failed", node: "node-1", phase: arkv1api.PodVolumeBackupPhaseFailed, completed: now.Add(-5 * time.Hour)},
+		{name: "dry-run", node: "node-1", phase: arkv1api.PodVolumeBackupPhaseCompletedDryRun, completed: now.Add(-5 * time.Hour)},
+		{name: "no-snapshots", node: "node-1", phase: arkv1api.PodVolumeBackupPhaseCompleted, completed: now.Add(-5 * time.Hour)},
+		{name: "being-deleted", node: "node-1", phase: arkv1api.PodVolumeBackupPhaseCompleted, snapshotID: "snap-7", completed: now.Add(-5 * time.Hour), deleting: true},
+	} {
+		obj := newTestPodVolumeBackup(pvb.name, pvb.node)
+		obj.Status.Phase = pvb.phase
+		obj.Status.SnapshotID = pvb.snapshotID
+		obj.Status.CompletionTimestamp = metav1.NewTime(pvb.completed)
+		obj.Status.LastVerified = metav1.NewTime(pvb.lastVerified)
+		if pvb.deleting {
+			obj.DeletionTimestamp = &metav1.Time{Time: now}
+		}
+		require.NoError(t, td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore().Add(obj))
+	}
+
+	candidates, err := td.controller.verificationCandidates()
+	require.NoError(t, err)
+
+	var names []string
+	for _, pvb := range candidates {
+		names = append(names, pvb.Name)
+	}
+	// never verified backups come first, oldest first, then the least
+	// recently verified, limited to maxVerifications.
+	assert.Equal(t, []string{"never-verified-old", "never-verified-new", "verified-longest-ago"}, names)
+
+	td.controller.maxVerifications = 0
+	candidates, err = td.controller.verificationCandidates()
+	require.NoError(t, err)
+	assert.Len(t, candidates, 4)
+}
+
+func TestVerifySnapshots(t *testing.T) {
+	tests := []struct {
+		name                 string
+		readDataPercent      int
+		snapshots            []restic.Snapshot
+		listErr              error
+		verifyErr            error
+		expectedVerifyFlags  []string
+		expectedVerification arkv1api.PodVolumeBackupVerification
+		expectedEvents       []string
+	}{
+		{
+			name:                "snapshots found and repository checked without reading data",
+			snapshots:           []restic.Snapshot{{ID: "snapshot-1-full-id", ShortID: "snapshot-1"}, {ID: "snapshot-2-full-id", ShortID: "snapshot-2"}},
+			expectedVerifyFlags: []string{"--no-lock"},
+			expectedVerification: arkv1api.PodVolumeBackupVerification{
+				Phase: arkv1api.PodVolumeBackupVerificationPhasePartiallyVerified,
+			},
+		},
+		{
+			name:                "snapshots found and all data verified",
+			readDataPercent:     100,
+			snapshots:           []restic.Snapshot{{ID: "snapshot-1-full-id", ShortID: "snapshot-1"}, {ID: "snapshot-2-full-id", ShortID: "snapshot-2"}},
+			expectedVerifyFlags: []string{"--no-lock", "--read-data"},
+			expectedVerification: arkv1api.PodVolumeBackupVerification{
+				Phase:           arkv1api.PodVolumeBackupVerificationPhaseVerified,
+				ReadDataPercent: 100,
+			},
+		},
+		{
+			name:      "missing snapshot fails verification without checking the repository",
+			snapshots: []restic.Snapshot{{ID: "snapshot-1-full-id", ShortID: "snapshot-1"}},
+			expectedVerification: arkv1api.PodVolumeBackupVerification{
+				Phase:   arkv1api.PodVolumeBackupVerificationPhaseFailed,
+				Message: "snapshots not found in the restic repository: snapshot-2",
+			},
+			expectedEvents: []string{"Warning BackupVerificationFailed Backup verification failed: snapshots not found in the restic repository: snapshot-2"},
+		},
+		{
+			name:    "error listing snapshots fails verification",
+			listErr: errors.New("Fatal: unable to open repository"),
+			expectedVerification: arkv1api.PodVolumeBackupVerification{
+				Phase:   arkv1api.PodVolumeBackupVerificationPhaseFailed,
+				Message: "error listing snapshots: Fatal: unable to open repository",
+			},
+			expectedEvents: []string{"Warning BackupVerificationFailed Backup verification failed: error listing snapshots: Fatal: unable to open repository"},
+		},
+		{
+			name:                "failed repository check fails verification",
+			readDataPercent:     10,
+			snapshots:           []restic.Snapshot{{ID: "snapshot-1-full-id", ShortID: "snapshot-1"}, {ID: "snapshot-2-full-id", ShortID: "snapshot-2"}},
+			verifyErr:           errors.New("Fatal: repository contains errors"),
+			expectedVerifyFlags: []string{"--no-lock", "--read-data-subset=10%"},
+			expectedVerification: arkv1api.PodVolumeBackupVerification{
+				Phase:           arkv1api.PodVolumeBackupVerificationPhaseFailed,
+				ReadDataPercent: 10,
+				Message:         "Fatal: repository contains errors",
+			},
+			expectedEvents: []string{"Warning BackupVerificationFailed Backup verification failed: Fatal: repository contains errors"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.resticPasswordFile = "/credentials/restic-password"
+			td.controller.verifyReadDataPercent = test.readDataPercent
+			td.controller.verifyInterval = time.Hour
+			now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+			td.controller.clock = clock.NewFakeClock(now)
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: "ns-1", Name: "pod-1"}
+			td.pvb.Spec.RepoPrefix = "s3:bucket"
+			td.pvb.Status.Phase = arkv1api.PodVolumeBackupPhaseCompleted
+			td.pvb.Status.SnapshotIDs = map[string]string{"vol-1": "snapshot-1", "vol-2": "snapshot-2"}
+			require.NoError(t, td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore().Add(td.pvb.DeepCopy()))
+
+			td.controller.listSnapshotsFunc = func(cmd *restic.Command) ([]restic.Snapshot, error) {
+				// each verification holds a backup slot.
+				assert.False(t, td.controller.backupSemaphore.TryAcquire(1))
+
+				assert.Equal(t, "snapshots", cmd.Command)
+				assert.Equal(t, "s3:bucket", cmd.RepoPrefix)
+				assert.Equal(t, "ns-1", cmd.Repo)
+				assert.Equal(t, []string{"snapshot-1", "snapshot-2"}, cmd.Args)
+				return test.snapshots, test.listErr
+			}
+
+			var verifyCmds []*restic.Command
+			td.controller.verifyRepoFunc = func(_ context.Context, cmd *restic.Command) error {
+				verifyCmds = append(verifyCmds, cmd)
+				return test.verifyErr
+			}
+
+			td.controller.verifySnapshots(context.Background())
+
+			if test.expectedVerifyFlags == nil {
+				assert.Empty(t, verifyCmds)
+			} else {
+				require.Len(t, verifyCmds, 1)
+				assert.Equal(t, "check", verifyCmds[0].Command)
+				assert.Equal(t, test.expectedVerifyFlags, verifyCmds[0].ExtraFlags)
+			}
+
+			assert.Equal(t, test.expectedVerification, td.pvb.Status.Verification)
+			assert.True(t, td.pvb.Status.LastVerified.Time.Equal(now))
+			assert.Equal(t, arkv1api.PodVolumeBackupPhaseCompleted, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedEvents, td.eventRecorder.Events)
+		})
+	}
+}
+
+func TestVerifySnapshotChecksEachRepositoryOnce(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+	td.controller.resticPasswordFile = "/credentials/restic-password"
+	td.controller.listSnapshotsFunc = func(cmd *restic.Command) ([]restic.Snapshot, error) {
+		var snapshots []restic.Snapshot
+		for _, id := range cmd.Args {
+			snapshots = append(snapshots, restic.Snapshot{ID: id + "-full-id", ShortID: id})
+		}
+		return snapshots, nil
+	}
+
+	var checked []string
+	td.controller.verifyRepoFunc = func(_ context.Context, cmd *restic.Command) error {
+		checked = append(checked, cmd.RepoPrefix+"/"+cmd.Repo)
+		return nil
+	}
+
+	repoResults := make(map[string]arkv1api.PodVolumeBackupVerification)
+	for i, namespace := range []string{"ns-1", "ns-1", "ns-2"} {
+		pvb := newTestPodVolumeBackup(fmt.Sprintf("pvb-%d", i), "node-1")
+		pvb.Spec.Pod = corev1api.ObjectReference{Namespace: namespace, Name: "pod-1"}
+		pvb.Spec.RepoPrefix = "s3:bucket"
+		pvb.Status.SnapshotID = fmt.Sprintf("snapshot-%d", i)
+
+		verification, err := td.controller.verifySnapshot(context.Background(), pvb, repoResults, arktest.NewLogger())
+		require.NoError(t, err)
+		assert.Equal(t, arkv1api.PodVolumeBackupVerificationPhasePartiallyVerified, verification.Phase)
+	}
+
+	assert.Equal(t, []string{"s3:bucket/ns-1", "s3:bucket/ns-2"}, checked)
+}
+
+func TestProcessBackupBlockVolume(t *testing.T) {
+	tests := []struct {
+		name                string
+		resticVersion       string
+		expectedPhase       arkv1api.PodVolumeBackupPhase
+		expectedReason      arkv1api.PodVolumeBackupFailureReason
+		expectedMessage     string
+		expectedArgs        []string
+		expectedVolumeModes map[string]corev1api.PersistentVolumeMode
+		expectedSnapshotIDs map[string]string
+		expectedRuns        int
+	}{
+		{
+			name:          "block volume is backed up from its device",
+			resticVersion: "restic 0.17.0 compiled with go1.22.5 on linux/amd64",
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedArgs:  []string{"cat", "/host_pods/pod-uid/volumeDevices/kubernetes.io~csi/pv-1", "--stdin-from-command", "--stdin-filename=data.img"},
+			expectedVolumeModes: map[string]corev1api.PersistentVolumeMode{
+				"data":    corev1api.PersistentVolumeBlock,
+				"scratch": corev1api.PersistentVolumeFilesystem,
+			},
+			expectedSnapshotIDs: map[string]string{"data": "snapshot-data", "scratch": "snapshot-scratch"},
+			expectedRuns:        2,
+		},
+		{
+			name:                "block volume fails if restic is too old",
+			resticVersion:       "restic 0.16.4 compiled with go1.21.6 on linux/amd64",
+			expectedPhase:       arkv1api.PodVolumeBackupPhaseFailed,
+			expectedReason:      arkv1api.PodVolumeBackupFailureReasonBlockVolumeNotSupported,
+			expectedMessage:     "volume data: volume is a block-mode volume, which requires restic 0.17.0 or later to back up, but the restic server is running restic 0.16.4 compiled with go1.21.6 on linux/amd64",
+			expectedSnapshotIDs: map[string]string{"scratch": "snapshot-scratch"},
+			expectedRuns:        1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			td.controller.resticVersionFunc = func(context.Context) (string, error) {
+				return test.resticVersion + "\n", nil
+			}
+
+			pod := &corev1api.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns-1",
+					Name:      "pod-1",
+					UID:       "pod-uid",
+				},
+				Spec: corev1api.PodSpec{
+					Volumes: []corev1api.Volume{
+						{
+							Name:         "data",
+							VolumeSource: corev1api.VolumeSource{PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "pvc-1"}},
+						},
+					},
+				},
+			}
+			td.withBackupPrerequisites(pod, "scratch")
+
+			block := corev1api.PersistentVolumeBlock
+			require.NoError(t, td.kubeInformers.Core().V1().PersistentVolumeClaims().Informer().GetStore().Add(&corev1api.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "pvc-1"},
+				Spec:       corev1api.PersistentVolumeClaimSpec{VolumeName: "pv-1", VolumeMode: &block},
+			}))
+			td.fileSystem.WithFile("/host_pods/pod-uid/volumeDevices/kubernetes.io~csi/pv-1", nil)
+
+			// the device isn't a directory, so it mustn't be checked as one.
+			td.controller.checkAccessFunc = func(path string) error {
+				assert.NotContains(t, path, "volumeDevices")
+				return nil
+			}
+
+			td.pvb = newTestPodVolumeBackup("pvb-1", "node-1")
+			td.pvb.Spec.Pod = corev1api.ObjectReference{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+			td.pvb.Spec.Volumes = []string{"data", "scratch"}
+
+			var blockArgs []string
+			var runs int
+			td.controller.runCommandFunc = func(cmd *exec.Cmd) (string, string, error) {
+				runs++
+				if stringslice.Has(cmd.Args, "--stdin-from-command") {
+					blockArgs = cmd.Args
+				}
+				return "", "", nil
+			}
+			td.controller.getSnapshotIDFunc = fakeVolumeSnapshotID
+
+			require.NoError(t, td.controller.processBackup(context.Background(), td.pvb.DeepCopy()))
+
+			assert.Equal(t, test.expectedPhase, td.pvb.Status.Phase)
+			assert.Equal(t, test.expectedReason, td.pvb.Status.FailureReason)
+			assert.Equal(t, test.expectedSnapshotIDs, td.pvb.Status.SnapshotIDs)
+			assert.Equal(t, test.expectedVolumeModes, td.pvb.Status.VolumeModes)
+			assert.Equal(t, test.expectedRuns, runs)
+			if test.expectedMessage != "" {
+				assert.Equal(t, test.expectedMessage, td.pvb.Status.Message)
+			}
+
+			if test.expectedArgs == nil {
+				assert.Nil(t, blockArgs)
+			} else {
+				for _, arg := range test.expectedArgs {
+					assert.Contains(t, blockArgs, arg)
+				}
+			}
+		})
+	}
+}
+
+// newTestOwnedPodVolumeBackup returns a PodVolumeBackup, run by node-1 and
+// created at the given time, that's owned by the given Backup.
+func newTestOwnedPodVolumeBackup(name, backupName string, backupUID types.UID, created time.Time) *arkv1api.PodVolumeBackup {
+	pvb := newTestPodVolumeBackup(name, "node-1")
+	pvb.UID = types.UID(name + "-uid")
+	pvb.CreationTimestamp = metav1.NewTime(created)
+	pvb.Labels = map[string]string{arkv1api.BackupNameLabel: backupName}
+	controller := true
+	pvb.OwnerReferences = []metav1.OwnerReference{
+		{
+			APIVersion: arkv1api.SchemeGroupVersion.String(),
+			Kind:       "Backup",
+			Name:       backupName,
+			UID:        backupUID,
+			Controller: &controller,
+		},
+	}
+	pvb.Status.Phase = arkv1api.PodVolumeBackupPhaseCompleted
+	return pvb
+}
+
+func newTestBackupWithUID(name string, uid types.UID) *arkv1api.Backup {
+	backup := arktest.NewTestBackup().WithName(name).Backup
+	backup.UID = uid
+	return backup
+}
+
+func TestOrphanedBackups(t *testing.T) {
+	td := setupPodVolumeBackupControllerTest(1)
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	td.controller.clock = clock.NewFakeClock(now)
+	td.controller.orphanGracePeriod = time.Hour
+
+	backups := td.sharedInformers.Ark().V1().Backups().Informer().GetStore()
+	require.NoError(t, backups.Add(newTestBackupWithUID("live", "live-uid")))
+
+	var (
+		old  = now.Add(-2 * time.Hour)
+		pvbs = []*arkv1api.PodVolumeBackup{
+			newTestOwnedPodVolumeBackup("live-owner", "live", "live-uid", old),
+			newTestOwnedPodVolumeBackup("deleted-owner", "deleted", "deleted-uid", old),
+			newTestOwnedPodVolumeBackup("recreated-owner", "live", "previous-live-uid", old),
+			newTestOwnedPodVolumeBackup("within-grace-period", "deleted", "deleted-uid", now.Add(-30*time.Minute)),
+			newTestOwnedPodVolumeBackup("other-node", "deleted", "deleted-uid", old),
+			newTestOwnedPodVolumeBackup("being-deleted", "deleted", "deleted-uid", old),
+			newTestOwnedPodVolumeBackup("running", "deleted", "deleted-uid", old),
+			newTestOwnedPodVolumeBackup("label-only-live", "live", "", old),
+			newTestOwnedPodVolumeBackup("label-only-deleted", "deleted", "", old),
+			newTestOwnedPodVolumeBackup("unowned", "", "", old),
+		}
+	)
+	pvbs[4].Spec.Node = "node-2"
+	pvbs[5].DeletionTimestamp = &metav1.Time{Time: now}
+	pvbs[7].OwnerReferences = nil
+	pvbs[8].OwnerReferences = nil
+	pvbs[9].OwnerReferences = nil
+	pvbs[9].Labels = nil
+
+	for _, pvb := range pvbs {
+		require.NoError(t, td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore().Add(pvb))
+	}
+
+	td.controller.trackBackup(kube.NamespaceAndName(pvbs[6]), func() {})
+
+	orphans, err := td.controller.orphanedBackups()
+	require.NoError(t, err)
+
+	var names []string
+	for _, pvb := range orphans {
+		names = append(names, pvb.Name)
+	}
+	assert.Equal(t, []string{"deleted-owner", "label-only-deleted", "recreated-owner"}, names)
+}
+
+func TestDeleteOrphanedBackups(t *testing.T) {
+	tests := []struct {
+		name            string
+		forgetOrphans   bool
+		withFinalizer   bool
+		forgetErr       error
+		expectedForgets []string
+		expectDelete    bool
+	}{
+		{
+			name:         "orphan is deleted",
+			expectDelete: true,
+		},
+		{
+			name:            "orphan's snapshots are forgotten before it's deleted",
+			forgetOrphans:   true,
+			expectedForgets: []string{"snapshot-1"},
+			expectDelete:    true,
+		},
+		{
+			name:          "orphan with the forget finalizer is left to forget its snapshots when it's deleted",
+			forgetOrphans: true,
+			withFinalizer: true,
+			expectDelete:  true,
+		},
+		{
+			name:            "orphan isn't deleted if its snapshots can't be forgotten",
+			forgetOrphans:   true,
+			forgetErr:       errors.New("exit status 1"),
+			expectedForgets: []string{"snapshot-1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			td := setupPodVolumeBackupControllerTest(1)
+			defer td.controller.credentialsFiles.Clear()
+			now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+			td.controller.clock = clock.NewFakeClock(now)
+			td.controller.orphanGracePeriod = time.Hour
+			td.controller.forgetOrphans = test.forgetOrphans
+			td.controller.resticPasswordFile = "/credentials/restic-password"
+
+			backups := td.sharedInformers.Ark().V1().Backups().Informer().GetStore()
+			require.NoError(t, backups.Add(newTestBackupWithUID("live", "live-uid")))
+
+			orphan := newTestOwnedPodVolumeBackup("orphan", "deleted", "deleted-uid", now.Add(-2*time.Hour))
+			orphan.Spec.Pod = corev1api.ObjectReference{Namespace: "ns-1", Name: "pod-1"}
+			orphan.Status.SnapshotID = "snapshot-1"
+			if test.withFinalizer {
+				orphan.Finalizers = []string{forgetSnapshotsFinalizer}
+			}
+			livePVB := newTestOwnedPodVolumeBackup("live", "live", "live-uid", now.Add(-2*time.Hour))
+			livePVB.Spec.Pod = corev1api.ObjectReference{Namespace: "ns-1", Name: "pod-1"}
+			livePVB.Status.SnapshotID = "snapshot-2"
+
+			for _, pvb := range []*arkv1api.PodVolumeBackup{orphan, livePVB} {
+				require.NoError(t, td.sharedInformers.Ark().V1().PodVolumeBackups().Informer().GetStore().Add(pvb))
+				_, err := td.client.ArkV1().PodVolumeBackups(pvb.Namespace).Create(pvb)
+				require.NoError(t, err)
+			}
+			td.client.ClearActions()
+
+			var forgets []string
+			td.controller.forgetSnapshotFunc = func(_ context.Context, cmd *restic.Command) error {
+				forgets = append(forgets, cmd.Args...)
+				return test.forgetErr
+			}
+
+			td.controller.deleteOrphanedBackups(context.Background())
+
+			assert.Equal(t, test.expectedForgets, forgets)
+
+			var deleted []string
+			for _, action := range td.client.Actions() {
+				if action.GetVerb() == "delete" {
+					deleted = append(deleted, action.(core.DeleteAction).GetName())
+				}
+			}
+			if test.expectDelete {
+				assert.Equal(t, []string{"orphan"}, deleted)
+			} else {
+				assert.Empty(t, deleted)
+			}
+
+			// the backup with a live owner is kept.
+			_, err := td.client.ArkV1().PodVolumeBackups(livePVB.Namespace).Get(livePVB.Name, metav1.GetOptions{})
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -23,7 +23,6 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 
 	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/pkg/errors"
@@ -43,6 +42,7 @@ import (
 	listers "github.com/heptio/ark/pkg/generated/listers/ark/v1"
 	"github.com/heptio/ark/pkg/restic"
 	"github.com/heptio/ark/pkg/util/boolptr"
+	"github.com/heptio/ark/pkg/util/filesystem"
 	"github.com/heptio/ark/pkg/util/kube"
 )
 
@@ -54,7 +54,15 @@ type podVolumeRestoreController struct {
 	secretLister           corev1listers.SecretLister
 	podLister              corev1listers.PodLister
 	pvcLister              corev1listers.PersistentVolumeClaimLister
+	pvLister               corev1listers.PersistentVolumeLister
 	nodeName               string
+	hostPodsPath           string
+	resticBinary           string
+	resticGlobalFlags      []string
+	resticEnv              []string
+	resticTempDir          string
+	backendOptions         restic.BackendOptions
+	fileSystem             filesystem.Interface
 
 	processRestoreFunc func(*arkv1api.PodVolumeRestore) error
 }
@@ -67,7 +75,14 @@ func NewPodVolumeRestoreController(
 	podInformer cache.SharedIndexInformer,
 	secretInformer corev1informers.SecretInformer,
 	pvcInformer corev1informers.PersistentVolumeClaimInformer,
+	pvInformer corev1informers.PersistentVolumeInformer,
 	nodeName string,
+	hostPodsPath string,
+	resticBinary string,
+	resticGlobalFlags []string,
+	resticEnv []string,
+	resticTempDir string,
+	backendOptions restic.BackendOptions,
 ) Interface {
 	c := &podVolumeRestoreController{
 		genericController:      newGenericController("pod-volume-restore", logger),
@@ -76,7 +91,15 @@ func NewPodVolumeRestoreController(
 		podLister:              corev1listers.NewPodLister(podInformer.GetIndexer()),
 		secretLister:           secretInformer.Lister(),
 		pvcLister:              pvcInformer.Lister(),
+		pvLister:               pvInformer.Lister(),
 		nodeName:               nodeName,
+		hostPodsPath:           hostPodsPath,
+		resticBinary:           resticBinary,
+		resticGlobalFlags:      resticGlobalFlags,
+		resticEnv:              resticEnv,
+		resticTempDir:          resticTempDir,
+		backendOptions:         backendOptions,
+		fileSystem:             filesystem.NewFileSystem(),
 	}
 
 	c.syncHandler = c.processQueueItem
@@ -86,6 +109,7 @@ func NewPodVolumeRestoreController(
 		secretInformer.Informer().HasSynced,
 		podInformer.HasSynced,
 		pvcInformer.Informer().HasSynced,
+		pvInformer.Informer().HasSynced,
 	)
 	c.processRestoreFunc = c.processRestore
 
@@ -261,7 +285,29 @@ func (c *podVolumeRestoreController) processRestore(req *arkv1api.PodVolumeResto
 		return c.failRestore(req, errors.Wrap(err, "error getting volume directory name").Error(), log)
 	}
 
-	credsFile, err := restic.TempCredentialsFile(c.secretLister, req.Spec.Pod.Namespace)
+	// if a remount leaves more than one directory matching the volume's,
+	// the one mounted by the volume's plugin, if it's known, is restored.
+	preferred, err := volumePluginPreference(pod, req.Spec.Volume, c.pvcLister, c.pvLister)
+	if err != nil {
+		log.WithError(err).Error("Error getting volume plugin directory name")
+		return c.failRestore(req, errors.Wrap(err, "error getting volume plugin directory name").Error(), log)
+	}
+
+	includes, err := restic.RestoreIncludePatterns(volumeDir, req.Spec.IncludePaths)
+	if err != nil {
+		log.WithError(err).Error("Invalid include paths")
+		return c.failRestore(req, errors.Wrap(err, "invalid include paths").Error(), log)
+	}
+
+	// a repository prefix template is resolved against the restored pod's
+	// metadata, the same way it was for the backed-up pod.
+	repoPrefix, err := restic.ResolveRepoPrefix(req.Spec.RepoPrefix, pod)
+	if err != nil {
+		log.WithError(err).Error("Invalid repoPrefix")
+		return c.failRestore(req, errors.Wrap(err, "invalid repoPrefix").Error(), log)
+	}
+
+	credsFile, err := restic.TempCredentialsFile(c.secretLister, req.Spec.Pod.Namespace, c.resticTempDir)
 	if err != nil {
 		log.WithError(err).Error("Error creating temp restic credentials file")
 		return c.failRestore(req, errors.Wrap(err, "error creating temp restic credentials file").Error(), log)
@@ -270,9 +316,9 @@ func (c *podVolumeRestoreController) processRestore(req *arkv1api.PodVolumeResto
 	defer os.Remove(credsFile)
 
 	// execute the restore process
-	if err := restorePodVolume(req, credsFile, volumeDir, log); err != nil {
+	if err := c.restorePodVolume(req, repoPrefix, credsFile, volumeDir, includes, preferred, log); err != nil {
 		log.WithError(err).Error("Error restoring volume")
-		return c.failRestore(req, errors.Wrap(err, "error restoring volume").Error(), log)
+		return c.failRestore(req, restoreFailureMessage(err), log)
 	}
 
 	// update status to Completed
@@ -284,14 +330,21 @@ func (c *podVolumeRestoreController) processRestore(req *arkv1api.PodVolumeResto
 	return nil
 }
 
-func restorePodVolume(req *arkv1api.PodVolumeRestore, credsFile, volumeDir string, log logrus.FieldLogger) error {
-	resticCmd := restic.RestoreCommand(
-		req.Spec.RepoPrefix,
-		req.Spec.Pod.Namespace,
-		credsFile,
-		string(req.Spec.Pod.UID),
-		req.Spec.SnapshotID,
+func (c *podVolumeRestoreController) restorePodVolume(req *arkv1api.PodVolumeRestore, repoPrefix, credsFile, volumeDir string, includes []string, preferred func(string) bool, log logrus.FieldLogger) error {
+	resticCmd := withResticConfig(
+		restic.RestoreCommand(
+			repoPrefix,
+			req.Spec.Pod.Namespace,
+			credsFile,
+			string(req.Spec.Pod.UID),
+			req.Spec.SnapshotID,
+			includes,
+		),
+		c.resticBinary,
+		c.resticGlobalFlags,
+		c.resticEnv,
 	)
+	resticCmd.Options = c.backendOptions.For(resticCmd.RepoPrefix)
 
 	var (
 		stdout, stderr string
@@ -305,37 +358,36 @@ func restorePodVolume(req *arkv1api.PodVolumeRestore, credsFile, volumeDir strin
 	// all this is that we can't restore directly into the new volume's directory, because the path is entirely different
 	// than the backed-up one.
 	if stdout, stderr, err = runCommand(resticCmd.Cmd()); err != nil {
-		return errors.Wrapf(err, "error running restic restore, cmd=%s, stdout=%s, stderr=%s", resticCmd.String(), stdout, stderr)
+		return errors.Wrapf(restic.NewError(err, stderr), "error running restic restore, cmd=%s, stdout=%s", resticCmd.RedactedString(), stdout)
 	}
-	log.Debugf("Ran command=%s, stdout=%s, stderr=%s", resticCmd.String(), stdout, stderr)
+	log.WithFields(logrus.Fields{
+		"command": resticCmd.RedactedString(),
+		"stdout":  stdout,
+		"stderr":  stderr,
+	}).Debug("Ran restic restore")
 
 	// Now, get the full path of the restored volume in the staging directory, which will
 	// look like:
-	// 		/restores/<new-pod-uid>/host_pods/<backed-up-pod-uid>/volumes/<volume-plugin-name>/<volume-dir>
-	restorePath, err := singlePathMatch(fmt.Sprintf("/restores/%s/host_pods/*/volumes/*/%s", string(req.Spec.Pod.UID), volumeDir))
+	// 		/restores/<new-pod-uid>/<host-pods-path>/<backed-up-pod-uid>/volumes/<volume-plugin-name>/<volume-dir>
+	restorePath, err := singlePathMatch(filepath.Join("/restores", string(req.Spec.Pod.UID), c.hostPodsPath, "*", "volumes", "*", volumeDir), c.fileSystem, preferred)
 	if err != nil {
 		return errors.Wrap(err, "error identifying path of restore staging directory")
 	}
 
 	// Also get the full path of the new volume's directory (as mounted in the daemonset pod), which
 	// will look like:
-	// 		/host_pods/<new-pod-uid>/volumes/<volume-plugin-name>/<volume-dir>
-	volumePath, err := singlePathMatch(fmt.Sprintf("/host_pods/%s/volumes/*/%s", string(req.Spec.Pod.UID), volumeDir))
+	// 		<host-pods-path>/<new-pod-uid>/volumes/<volume-plugin-name>/<volume-dir>
+	volumePath, err := singlePathMatch(filepath.Join(c.hostPodsPath, string(req.Spec.Pod.UID), "volumes", "*", volumeDir), c.fileSystem, preferred)
 	if err != nil {
 		return errors.Wrap(err, "error identifying path of volume")
 	}
 
 	// Move the contents of the staging directory into the new volume directory to finalize the restore. This
-	// is being executed through a shell because attempting to do the same thing in go (via os.Rename()) is
+	// is being executed with mv because attempting to do the same thing in go (via os.Rename()) is
 	// giving errors about renames not being allowed across filesystem layers in a container. This is occurring
 	// whether /restores is part of the writeable container layer, or is an emptyDir volume mount. This may
-	// be solvable but using the shell works so not investigating further.
-	//
-	// Glob patterns:
-	// 	[^.]*  : any non-dot character followed by anything (regular files)
-	// 	.[^.]* : a dot followed by a non-dot and anything else (dotfiles starting with a single dot, excluding '.')
-	// 	..?*   : two dots followed by any single character and anything else (dotfiles starting with two dots, excluding '..')
-	if err := moveMatchingFiles(restorePath, volumePath, "[^.]*", ".[^.]*", "..?*"); err != nil {
+	// be solvable but using mv works so not investigating further.
+	if err := moveDirContents(restorePath, volumePath); err != nil {
 		return errors.Wrapf(err, "error moving files from restore staging directory into volume")
 	}
 
@@ -372,35 +424,41 @@ func restorePodVolume(req *arkv1api.PodVolumeRestore, credsFile, volumeDir strin
 	return nil
 }
 
-func moveMatchingFiles(sourceDir, destinationDir string, patterns ...string) error {
-	// find the patterns that match at least one file
-	var matchingPatterns []string
+// moveDirContents moves the files and directories in sourceDir, including
+// dotfiles, into destinationDir. A directory that's already in
+// destinationDir is merged with the one being moved rather than replaced,
+// so that a snapshot of a subPath of a volume, which includes the subPath's
+// parent directories, can be restored into a volume shared with other pods
+// whose subPaths have already been restored.
+func moveDirContents(sourceDir, destinationDir string) error {
+	files, err := ioutil.ReadDir(sourceDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
 
-	for _, pattern := range patterns {
-		fullPattern := fmt.Sprintf("%s/%s", sourceDir, pattern)
-		files, err := filepath.Glob(fullPattern)
-		if err != nil {
-			return errors.Wrapf(err, "error finding matches for pattern %s", pattern)
+	for _, file := range files {
+		source := filepath.Join(sourceDir, file.Name())
+		destination := filepath.Join(destinationDir, file.Name())
+
+		if file.IsDir() {
+			info, err := os.Lstat(destination)
+			if err != nil && !os.IsNotExist(err) {
+				return errors.WithStack(err)
+			}
+			if err == nil && info.IsDir() {
+				if err := moveDirContents(source, destination); err != nil {
+					return err
+				}
+				continue
+			}
 		}
 
-		if len(files) > 0 {
-			matchingPatterns = append(matchingPatterns, fullPattern)
+		cmd := exec.Command("mv", source, destinationDir+"/")
+		if _, stderr, err := runCommand(cmd); err != nil {
+			return errors.Wrapf(err, "error moving %s from restore staging directory into volume, stderr=%s", source, stderr)
 		}
 	}
 
-	// if no patterns matched any files, we're done
-	if len(matchingPatterns) == 0 {
-		return nil
-	}
-
-	// we only use patterns that matched 1+ file(s) because mv returns an error on a pattern
-	// that doesn't match anything.
-	cmd := exec.Command("/bin/sh", "-c", fmt.Sprintf("mv %s %s", strings.Join(matchingPatterns, " "), destinationDir+"/"))
-
-	if _, stderr, err := runCommand(cmd); err != nil {
-		return errors.Wrapf(err, "error moving files from restore staging directory into volume, stderr=%s", stderr)
-	}
-
 	return nil
 }
 
@@ -444,6 +502,24 @@ func (c *podVolumeRestoreController) failRestore(req *arkv1api.PodVolumeRestore,
 	return nil
 }
 
+// restoreFailureMessage returns the message to report in a PodVolumeRestore's
+// status for an error returned by restorePodVolume, explaining the likely
+// cause for restic errors that are commonly due to misconfiguration.
+func restoreFailureMessage(err error) string {
+	msg := errors.Wrap(err, "error restoring volume").Error()
+
+	switch restic.ErrorKind(err) {
+	case restic.ErrRepoNotFound:
+		return "restic repository does not exist: " + msg
+	case restic.ErrAuth:
+		return "restic repository password is wrong, check the repository's credentials secret: " + msg
+	case restic.ErrPermissionDenied:
+		return "restic was denied access to the repository or volume: " + msg
+	default:
+		return msg
+	}
+}
+
 func updatePodVolumeRestorePhaseFunc(phase arkv1api.PodVolumeRestorePhase) func(r *arkv1api.PodVolumeRestore) {
 	return func(r *arkv1api.PodVolumeRestore) {
 		r.Status.Phase = phase
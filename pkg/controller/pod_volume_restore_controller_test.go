@@ -0,0 +1,65 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMoveDirContents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pod-volume-restore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// a snapshot of a PodVolumeBackup with a subPath records the subPath's
+	// absolute path on the node, so restic restores its parent directories
+	// too. Moving the contents of the staged volume directory into the new
+	// volume puts the subPath back in the same subdirectory of the volume,
+	// alongside another pod's subPath that's already been restored to it.
+	stagedVolumeDir := filepath.Join(dir, "restores", "pod-uid", "host_pods", "old-pod-uid", "volumes", "kubernetes.io~empty-dir", "vol-1")
+	require.NoError(t, os.MkdirAll(filepath.Join(stagedVolumeDir, "data", "app-1"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(stagedVolumeDir, "data", "app-1", "db"), []byte("db"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(stagedVolumeDir, "data", "app-1", ".config"), []byte("config"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(stagedVolumeDir, ".hidden"), []byte("hidden"), 0644))
+
+	volumeDir := filepath.Join(dir, "host_pods", "pod-uid", "volumes", "kubernetes.io~empty-dir", "vol-1")
+	require.NoError(t, os.MkdirAll(filepath.Join(volumeDir, "data", "app-2"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(volumeDir, "data", "app-2", "db"), []byte("other db"), 0644))
+
+	require.NoError(t, moveDirContents(stagedVolumeDir, volumeDir))
+
+	expected := map[string]string{
+		"data/app-1/db":      "db",
+		"data/app-1/.config": "config",
+		"data/app-2/db":      "other db",
+		".hidden":            "hidden",
+	}
+	for file, expectedContents := range expected {
+		contents, err := ioutil.ReadFile(filepath.Join(volumeDir, file))
+		require.NoError(t, err)
+		assert.Equal(t, expectedContents, string(contents))
+	}
+
+	_, err = os.Stat(filepath.Join(stagedVolumeDir, "data", "app-1"))
+	assert.True(t, os.IsNotExist(err))
+}
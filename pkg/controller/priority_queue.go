@@ -0,0 +1,299 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// priorityQueue is a workqueue.RateLimitingInterface that hands out the
+// queued item with the highest priority first, and items with the same
+// priority in the order they were added. Like the standard work queue, it
+// never holds an item more than once, and never hands out an item that's
+// still being processed: an item added while it's being processed is
+// queued again once it's done.
+type priorityQueue struct {
+	cond *sync.Cond
+
+	// priority returns an item's priority when it's queued.
+	priority    func(item interface{}) int
+	rateLimiter workqueue.RateLimiter
+
+	// clock times the delays of items added with AddAfter.
+	clock clock.Clock
+
+	// maxLen, if positive, is the number of items that may be waiting to be
+	// processed before deferrable ones, for which deferrable returns true,
+	// are deferred for deferDelay rather than queued. When a deferrable item
+	// is added to a full queue, the queued or added deferrable item with the
+	// lowest priority is deferred, so that the highest priority items stay
+	// queued.
+	maxLen     int
+	deferrable func(item interface{}) bool
+	deferDelay time.Duration
+
+	// lenObserver, if set, is called with the number of items waiting to
+	// be processed whenever it changes, and deferObserver with each item
+	// that's deferred and the number of items waiting.
+	lenObserver   func(n int)
+	deferObserver func(item interface{}, n int)
+
+	items        priorityItems
+	seq          uint64
+	dirty        map[interface{}]struct{}
+	processing   map[interface{}]struct{}
+	shuttingDown bool
+}
+
+// newPriorityQueue returns an empty priorityQueue that orders items using
+// the provided priority function and rate limits them using the default
+// controller rate limiter.
+func newPriorityQueue(priority func(item interface{}) int) *priorityQueue {
+	return &priorityQueue{
+		cond:        sync.NewCond(&sync.Mutex{}),
+		priority:    priority,
+		rateLimiter: workqueue.DefaultControllerRateLimiter(),
+		clock:       clock.RealClock{},
+		dirty:       make(map[interface{}]struct{}),
+		processing:  make(map[interface{}]struct{}),
+	}
+}
+
+// Add marks item as needing processing.
+func (q *priorityQueue) Add(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	if q.shuttingDown {
+		return
+	}
+	if _, ok := q.dirty[item]; ok {
+		return
+	}
+
+	q.dirty[item] = struct{}{}
+	if _, ok := q.processing[item]; ok {
+		return
+	}
+
+	q.push(item)
+}
+
+// push adds item to the heap, unless the queue is full and it's deferred
+// instead. It must be called with the lock held.
+func (q *priorityQueue) push(item interface{}) {
+	q.seq++
+	added := priorityItem{
+		item:       item,
+		priority:   q.priority(item),
+		seq:        q.seq,
+		deferrable: q.maxLen > 0 && q.deferrable != nil && q.deferrable(item),
+	}
+
+	var displaced *priorityItem
+	if added.deferrable && len(q.items) >= q.maxLen {
+		// defer the lowest priority deferrable item, or, if none is lower
+		// than the added one, the added one, which was added last.
+		i := q.lowestDeferrable()
+		if i < 0 || q.items[i].priority >= added.priority {
+			q.deferItem(item)
+			return
+		}
+		removed := heap.Remove(&q.items, i).(priorityItem)
+		displaced = &removed
+	}
+
+	heap.Push(&q.items, added)
+	if displaced != nil {
+		q.deferItem(displaced.item)
+	}
+	q.observeLen()
+	q.cond.Signal()
+}
+
+// lowestDeferrable returns the index in the heap of the deferrable item
+// with the lowest priority, and of those, the one added last, or -1 if
+// there isn't one. It must be called with the lock held.
+func (q *priorityQueue) lowestDeferrable() int {
+	lowest := -1
+	for i, queued := range q.items {
+		if !queued.deferrable {
+			continue
+		}
+		if lowest < 0 || queued.priority < q.items[lowest].priority || (queued.priority == q.items[lowest].priority && queued.seq > q.items[lowest].seq) {
+			lowest = i
+		}
+	}
+	return lowest
+}
+
+// deferItem adds item to the queue again after deferDelay. It must be
+// called with the lock held, and item must not be in the heap.
+func (q *priorityQueue) deferItem(item interface{}) {
+	delete(q.dirty, item)
+	if q.deferObserver != nil {
+		q.deferObserver(item, len(q.items))
+	}
+	q.after(item, q.deferDelay)
+}
+
+// after adds item to the queue once duration has passed on the queue's
+// clock.
+func (q *priorityQueue) after(item interface{}, duration time.Duration) {
+	timer := q.clock.NewTimer(duration)
+	go func() {
+		<-timer.C()
+		q.Add(item)
+	}()
+}
+
+// observeLen reports the number of queued items to the queue's observer,
+// if it has one. It must be called with the lock held.
+func (q *priorityQueue) observeLen() {
+	if q.lenObserver != nil {
+		q.lenObserver(len(q.items))
+	}
+}
+
+// Len returns the number of items waiting to be processed.
+func (q *priorityQueue) Len() int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	return len(q.items)
+}
+
+// Get blocks until it can return the highest priority item to be
+// processed. If shutdown is true, the caller should end their goroutine.
+// Done must be called with the item once it has been processed.
+func (q *priorityQueue) Get() (interface{}, bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	for len(q.items) == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	if len(q.items) == 0 {
+		// we must be shutting down
+		return nil, true
+	}
+
+	item := heap.Pop(&q.items).(priorityItem).item
+	q.observeLen()
+	q.processing[item] = struct{}{}
+	delete(q.dirty, item)
+
+	return item, false
+}
+
+// Done marks item as done processing, and if it has been marked as dirty
+// again while it was being processed, queues it for re-processing.
+func (q *priorityQueue) Done(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	delete(q.processing, item)
+	if _, ok := q.dirty[item]; ok {
+		q.push(item)
+	}
+}
+
+// ShutDown causes the queue to ignore all new items added to it. Once the
+// workers drain the queued items, their calls to Get return shutdown.
+func (q *priorityQueue) ShutDown() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	q.shuttingDown = true
+	q.cond.Broadcast()
+}
+
+func (q *priorityQueue) ShuttingDown() bool {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	return q.shuttingDown
+}
+
+// AddAfter adds item to the queue after the indicated duration has passed.
+func (q *priorityQueue) AddAfter(item interface{}, duration time.Duration) {
+	if q.ShuttingDown() {
+		return
+	}
+
+	if duration <= 0 {
+		q.Add(item)
+		return
+	}
+
+	q.after(item, duration)
+}
+
+// AddRateLimited adds item to the queue after the rate limiter says it's ok.
+func (q *priorityQueue) AddRateLimited(item interface{}) {
+	q.AddAfter(item, q.rateLimiter.When(item))
+}
+
+// Forget stops the rate limiter from tracking item.
+func (q *priorityQueue) Forget(item interface{}) {
+	q.rateLimiter.Forget(item)
+}
+
+// NumRequeues returns how many times item has been requeued.
+func (q *priorityQueue) NumRequeues(item interface{}) int {
+	return q.rateLimiter.NumRequeues(item)
+}
+
+type priorityItem struct {
+	item     interface{}
+	priority int
+	seq      uint64
+
+	// deferrable is whether the item can be deferred when the queue is
+	// full, as of when it was added.
+	deferrable bool
+}
+
+// priorityItems implements heap.Interface, ordering items by descending
+// priority and then by the order in which they were added.
+type priorityItems []priorityItem
+
+func (p priorityItems) Len() int { return len(p) }
+
+func (p priorityItems) Less(i, j int) bool {
+	if p[i].priority != p[j].priority {
+		return p[i].priority > p[j].priority
+	}
+	return p[i].seq < p[j].seq
+}
+
+func (p priorityItems) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+func (p *priorityItems) Push(x interface{}) { *p = append(*p, x.(priorityItem)) }
+
+func (p *priorityItems) Pop() interface{} {
+	old := *p
+	n := len(old)
+	item := old[n-1]
+	*p = old[:n-1]
+	return item
+}
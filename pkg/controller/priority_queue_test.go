@@ -0,0 +1,266 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// drain gets, and marks as done, all of the items in the queue.
+func drain(q *priorityQueue) []interface{} {
+	var items []interface{}
+	for q.Len() > 0 {
+		item, _ := q.Get()
+		q.Done(item)
+		items = append(items, item)
+	}
+	return items
+}
+
+func TestPriorityQueueOrdering(t *testing.T) {
+	tests := []struct {
+		name       string
+		priorities map[string]int
+		added      []string
+		expected   []interface{}
+	}{
+		{
+			name:     "default priorities are processed in FIFO order",
+			added:    []string{"c", "a", "b"},
+			expected: []interface{}{"c", "a", "b"},
+		},
+		{
+			name:       "higher priorities are processed first",
+			priorities: map[string]int{"db": 10, "cache": -1},
+			added:      []string{"logs", "cache", "db", "web"},
+			expected:   []interface{}{"db", "logs", "web", "cache"},
+		},
+		{
+			name:       "items with the same priority are processed in FIFO order",
+			priorities: map[string]int{"db-1": 5, "db-2": 5, "db-3": 5},
+			added:      []string{"web", "db-2", "db-3", "db-1"},
+			expected:   []interface{}{"db-2", "db-3", "db-1", "web"},
+		},
+		{
+			name:       "duplicate items are queued once",
+			priorities: map[string]int{"db": 1},
+			added:      []string{"web", "db", "web", "db"},
+			expected:   []interface{}{"db", "web"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := newPriorityQueue(func(item interface{}) int {
+				return test.priorities[item.(string)]
+			})
+
+			for _, item := range test.added {
+				q.Add(item)
+			}
+
+			assert.Equal(t, test.expected, drain(q))
+		})
+	}
+}
+
+func TestPriorityQueueProcessing(t *testing.T) {
+	q := newPriorityQueue(func(interface{}) int { return 0 })
+
+	q.Add("a")
+	item, shutdown := q.Get()
+	require.False(t, shutdown)
+	require.Equal(t, "a", item)
+
+	// an item that's added while it's being processed isn't handed out
+	// again until it's done
+	q.Add("a")
+	q.Add("b")
+	assert.Equal(t, 1, q.Len())
+
+	item, _ = q.Get()
+	assert.Equal(t, "b", item)
+	q.Done("b")
+	assert.Equal(t, 0, q.Len())
+
+	q.Done("a")
+	assert.Equal(t, []interface{}{"a"}, drain(q))
+}
+
+func TestPriorityQueueLenObserver(t *testing.T) {
+	q := newPriorityQueue(func(interface{}) int { return 0 })
+
+	var lens []int
+	q.lenObserver = func(n int) {
+		lens = append(lens, n)
+	}
+
+	q.Add("a")
+	q.Add("b")
+	q.Add("a")
+	q.Get()
+	q.Get()
+	assert.Equal(t, []int{1, 2, 1, 0}, lens)
+}
+
+func TestPriorityQueueAddAfter(t *testing.T) {
+	q := newPriorityQueue(func(interface{}) int { return 0 })
+	fakeClock := clock.NewFakeClock(time.Now())
+	q.clock = fakeClock
+
+	q.AddAfter("a", 0)
+	assert.Equal(t, 1, q.Len())
+
+	q.AddAfter("b", time.Minute)
+	assert.Equal(t, 1, q.Len())
+	require.True(t, fakeClock.HasWaiters())
+
+	fakeClock.Step(time.Minute)
+	waitForLen(t, q, 2)
+
+	assert.Equal(t, []interface{}{"a", "b"}, drain(q))
+}
+
+// waitForLen waits for n items to be waiting in the queue.
+func waitForLen(t *testing.T, q *priorityQueue, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Len() < n {
+		if time.Now().After(deadline) {
+			require.FailNow(t, "timed out waiting for delayed items to be added")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPriorityQueueMaxLen(t *testing.T) {
+	tests := []struct {
+		name             string
+		priorities       map[string]int
+		nonDeferrable    []string
+		added            []string
+		expectedQueued   []interface{}
+		expectedDeferred []interface{}
+	}{
+		{
+			name:           "items are queued while the queue is within its maximum length",
+			added:          []string{"a", "b"},
+			expectedQueued: []interface{}{"a", "b"},
+		},
+		{
+			name:             "items with the same priority as the queued ones are deferred",
+			added:            []string{"a", "b", "c", "d"},
+			expectedQueued:   []interface{}{"a", "b"},
+			expectedDeferred: []interface{}{"c", "d"},
+		},
+		{
+			name:             "higher priority items displace the lowest priority queued ones",
+			priorities:       map[string]int{"low": 1, "lowest": 0, "mid": 5, "high": 10},
+			added:            []string{"low", "lowest", "high", "mid"},
+			expectedQueued:   []interface{}{"high", "mid"},
+			expectedDeferred: []interface{}{"lowest", "low"},
+		},
+		{
+			name:             "the latest of the lowest priority queued items is displaced",
+			priorities:       map[string]int{"high": 10},
+			added:            []string{"a", "b", "high"},
+			expectedQueued:   []interface{}{"high", "a"},
+			expectedDeferred: []interface{}{"b"},
+		},
+		{
+			name:             "lower priority items are deferred",
+			priorities:       map[string]int{"a": 5, "b": 5, "c": 1},
+			added:            []string{"a", "b", "c"},
+			expectedQueued:   []interface{}{"a", "b"},
+			expectedDeferred: []interface{}{"c"},
+		},
+		{
+			name:             "non-deferrable items are always queued, and never displaced",
+			priorities:       map[string]int{"high": 10},
+			nonDeferrable:    []string{"x", "y", "z"},
+			added:            []string{"x", "y", "a", "z", "high"},
+			expectedQueued:   []interface{}{"x", "y", "z"},
+			expectedDeferred: []interface{}{"a", "high"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := newPriorityQueue(func(item interface{}) int {
+				return test.priorities[item.(string)]
+			})
+			fakeClock := clock.NewFakeClock(time.Now())
+			q.clock = fakeClock
+			q.maxLen = 2
+			q.deferDelay = time.Minute
+			q.deferrable = func(item interface{}) bool {
+				for _, nonDeferrable := range test.nonDeferrable {
+					if item == nonDeferrable {
+						return false
+					}
+				}
+				return true
+			}
+
+			var deferred []interface{}
+			q.deferObserver = func(item interface{}, n int) {
+				assert.True(t, n >= q.maxLen)
+				deferred = append(deferred, item)
+			}
+
+			for _, item := range test.added {
+				q.Add(item)
+			}
+
+			assert.Equal(t, test.expectedDeferred, deferred)
+			assert.Equal(t, test.expectedQueued, drain(q))
+
+			// deferred items are added again after the defer delay.
+			if len(test.expectedDeferred) == 0 {
+				assert.False(t, fakeClock.HasWaiters())
+				return
+			}
+			q.maxLen = 0
+			fakeClock.Step(time.Minute)
+			waitForLen(t, q, len(test.expectedDeferred))
+		})
+	}
+}
+
+func TestPriorityQueueShutDown(t *testing.T) {
+	q := newPriorityQueue(func(interface{}) int { return 0 })
+
+	q.Add("a")
+	q.ShutDown()
+	assert.True(t, q.ShuttingDown())
+
+	// items added after shutting down are ignored
+	q.Add("b")
+	assert.Equal(t, 1, q.Len())
+
+	// queued items are still handed out
+	item, shutdown := q.Get()
+	assert.Equal(t, "a", item)
+	assert.False(t, shutdown)
+
+	_, shutdown = q.Get()
+	assert.True(t, shutdown)
+}
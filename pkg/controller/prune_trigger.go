@@ -0,0 +1,93 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// PruneTrigger decides when a namespace's restic repository should be
+// pruned: after a number of backups to it, or once an interval has passed
+// since it was last pruned.
+type PruneTrigger interface {
+	// BackupCompleted informs the trigger that a backup to the namespace's
+	// repository completed, and returns true if the repository should now
+	// be pruned.
+	BackupCompleted(namespace string) bool
+	// Pruned informs the trigger that the namespace's repository was
+	// pruned.
+	Pruned(namespace string)
+}
+
+type pruneTrigger struct {
+	lock            sync.Mutex
+	backupsPerPrune int
+	interval        time.Duration
+	clock           clock.Clock
+	backups         map[string]int
+	lastPruned      map[string]time.Time
+}
+
+// NewPruneTrigger returns a new PruneTrigger that requests a prune of a
+// namespace's repository after backupsPerPrune backups to it, or on the
+// first backup after interval has passed since it was last pruned (or,
+// if it hasn't been, since its first backup). Either may be zero to
+// disable it.
+func NewPruneTrigger(backupsPerPrune int, interval time.Duration) PruneTrigger {
+	return &pruneTrigger{
+		backupsPerPrune: backupsPerPrune,
+		interval:        interval,
+		clock:           clock.RealClock{},
+		backups:         make(map[string]int),
+		lastPruned:      make(map[string]time.Time),
+	}
+}
+
+func (pt *pruneTrigger) BackupCompleted(namespace string) bool {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+
+	pt.backups[namespace]++
+
+	if pt.backupsPerPrune > 0 && pt.backups[namespace] >= pt.backupsPerPrune {
+		return true
+	}
+
+	if pt.interval > 0 {
+		last, ok := pt.lastPruned[namespace]
+		if !ok {
+			pt.lastPruned[namespace] = pt.clock.Now()
+			return false
+		}
+		if pt.clock.Since(last) >= pt.interval {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (pt *pruneTrigger) Pruned(namespace string) {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+
+	pt.backups[namespace] = 0
+	pt.lastPruned[namespace] = pt.clock.Now()
+}
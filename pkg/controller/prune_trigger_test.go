@@ -0,0 +1,77 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestPruneTriggerBackupCount(t *testing.T) {
+	pt := NewPruneTrigger(3, 0)
+
+	assert.False(t, pt.BackupCompleted("ns-1"))
+	assert.False(t, pt.BackupCompleted("ns-1"))
+	// namespaces are counted separately
+	assert.False(t, pt.BackupCompleted("ns-2"))
+	assert.True(t, pt.BackupCompleted("ns-1"))
+
+	// until the repository is pruned, every backup requests a prune
+	assert.True(t, pt.BackupCompleted("ns-1"))
+
+	pt.Pruned("ns-1")
+	assert.False(t, pt.BackupCompleted("ns-1"))
+	assert.False(t, pt.BackupCompleted("ns-1"))
+	assert.True(t, pt.BackupCompleted("ns-1"))
+
+	assert.False(t, pt.BackupCompleted("ns-2"))
+	assert.True(t, pt.BackupCompleted("ns-2"))
+}
+
+func TestPruneTriggerInterval(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+
+	pt := NewPruneTrigger(0, 24*time.Hour).(*pruneTrigger)
+	pt.clock = fakeClock
+
+	// the first backup starts the interval
+	assert.False(t, pt.BackupCompleted("ns-1"))
+
+	fakeClock.Step(12 * time.Hour)
+	assert.False(t, pt.BackupCompleted("ns-1"))
+
+	fakeClock.Step(12 * time.Hour)
+	assert.True(t, pt.BackupCompleted("ns-1"))
+
+	pt.Pruned("ns-1")
+	fakeClock.Step(time.Hour)
+	assert.False(t, pt.BackupCompleted("ns-1"))
+
+	fakeClock.Step(23 * time.Hour)
+	assert.True(t, pt.BackupCompleted("ns-1"))
+}
+
+func TestPruneTriggerDisabled(t *testing.T) {
+	pt := NewPruneTrigger(0, 0)
+
+	for i := 0; i < 100; i++ {
+		assert.False(t, pt.BackupCompleted("ns-1"))
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+
+	corev1api "k8s.io/api/core/v1"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/restic"
+)
+
+// applyRetention forgets the snapshots of each of the backup's volumes that
+// the retention policy doesn't keep, and returns how many were forgotten.
+// Only snapshots of the same path, i.e. in the volume's snapshot group, with
+// the backup's policy tag, if it has one, are considered. Block devices'
+// snapshots aren't recorded under their path, so they're left alone. Errors
+// are logged rather than returned, since the backup itself succeeded.
+func (c *podVolumeBackupController) applyRetention(ctx context.Context, req *arkv1api.PodVolumeBackup, paths, snapshotIDs map[string]string, volumeModes map[string]corev1api.PersistentVolumeMode, credsFile string, log logrus.FieldLogger) int {
+	if c.retention.IsZero() || len(snapshotIDs) == 0 {
+		return 0
+	}
+
+	// forgetting snapshots needs an exclusive lock of the repository, so
+	// it's left for the next backup while other nodes are using it.
+	release, ok := c.acquireExclusiveLease(podVolumeBackupRepoPrefix(req), req.Spec.Pod.Namespace, log)
+	if !ok {
+		log.Info("Restic repository is in use by another node, not applying the retention policy")
+		return 0
+	}
+	defer release()
+
+	volumes := make([]string, 0, len(snapshotIDs))
+	for volume := range snapshotIDs {
+		volumes = append(volumes, volume)
+	}
+	sort.Strings(volumes)
+
+	forgotten := 0
+	for _, volume := range volumes {
+		if volumeModes[volume] == corev1api.PersistentVolumeBlock {
+			continue
+		}
+
+		volumeLog := log.WithField("volume", volume)
+		forgetCmd := restic.ForgetPolicyCommand(podVolumeBackupRepoPrefix(req), req.Spec.Pod.Namespace, credsFile, restic.WithPolicyTag(nil, req.Spec.Policy), *c.snapshotGroup(paths[volume]), c.retention)
+		n, err := c.forgetByPolicyFunc(ctx, c.resticCommand(forgetCmd))
+		if err != nil {
+			volumeLog.WithError(err).Warn("Error forgetting snapshots outside the retention policy")
+			continue
+		}
+		if n > 0 {
+			volumeLog.Infof("Forgot %d snapshots outside the retention policy", n)
+		}
+		forgotten += n
+	}
+
+	return forgotten
+}
+
+// pruneRepository prunes the namespace's restic repository. Errors are
+// logged rather than returned because the backup that requested the prune
+// has already completed; the next backup requests it again.
+func (c *podVolumeBackupController) pruneRepository(ctx context.Context, repoPrefix, namespace, credsFile string, log logrus.FieldLogger) {
+	release, ok := c.acquireExclusiveLease(repoPrefix, namespace, log)
+	if !ok {
+		log.Info("Restic repository is in use by another node, not pruning it")
+		return
+	}
+	defer release()
+
+	pruneCmd := restic.PruneCommand(repoPrefix, namespace)
+	pruneCmd.PasswordFile = credsFile
+
+	log.Info("Pruning restic repository")
+	if err := c.pruneRepoFunc(ctx, c.resticCommand(pruneCmd)); err != nil {
+		if restic.ErrorKind(err) != restic.ErrImmutableStorage {
+			log.WithError(err).Error("Error pruning restic repository")
+			return
+		}
+
+		// don't retry the prune after every backup when it can't succeed.
+		if c.skipImmutableErrors {
+			log.WithError(err).Warnf("Not pruning restic repository: %s", immutableStorageMessage)
+			c.pruneTrigger.Pruned(namespace)
+			return
+		}
+		log.WithError(err).Errorf("Error pruning restic repository: %s", immutableStorageMessage)
+		return
+	}
+
+	c.pruneTrigger.Pruned(namespace)
+	log.Info("Pruned restic repository")
+}
+
+// acquireExclusiveLease tries to acquire an exclusive lease of a namespace's
+// repository, for maintenance that mustn't run concurrently with backups on
+// other nodes. It returns a function that releases the lease and true if
+// it's acquired or repository leases are disabled. Errors acquiring the
+// lease are logged and reported as the lease not being acquired.
+func (c *podVolumeBackupController) acquireExclusiveLease(repoPrefix, namespace string, log logrus.FieldLogger) (func(), bool) {
+	if c.repoLeaser == nil {
+		return func() {}, true
+	}
+
+	release, acquired, err := c.repoLeaser.TryAcquireExclusive(repoLeaseID(repoPrefix, namespace))
+	if err != nil {
+		log.WithError(err).Warn("Error acquiring restic repository lease")
+		return nil, false
+	}
+	if !acquired {
+		return nil, false
+	}
+
+	return release, true
+}
+
+// repoLeaseID returns the identifier of a namespace's repository that its
+// leases are taken on.
+func repoLeaseID(repoPrefix, namespace string) string {
+	return repoPrefix + "/" + namespace
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/restic"
+)
+
+// snapshotStats returns the total size and file count of the given snapshots.
+// The stats are informational only, so if they can't be retrieved for every
+// snapshot, the error is logged and empty stats are returned. Likewise, if
+// ctx is done before the stats of every snapshot are retrieved, the
+// remaining restic commands aren't run and empty stats are returned.
+func (c *podVolumeBackupController) snapshotStats(ctx context.Context, req *arkv1api.PodVolumeBackup, credsFile string, snapshotIDs map[string]string, log logrus.FieldLogger) restic.SnapshotStats {
+	var total restic.SnapshotStats
+
+	for volume, snapshotID := range snapshotIDs {
+		if ctx.Err() != nil {
+			log.WithError(ctx.Err()).Warn("Stopped getting restic snapshot stats, not recording snapshot size")
+			return restic.SnapshotStats{}
+		}
+
+		statsCmd := c.resticCommand(restic.StatsCommand(podVolumeBackupRepoPrefix(req), req.Spec.Pod.Namespace, credsFile, snapshotID))
+
+		stats, err := c.getSnapshotStatsFunc(ctx, statsCmd)
+		if err != nil {
+			log.WithError(err).WithField("volume", volume).Warn("Error getting restic snapshot stats, not recording snapshot size")
+			return restic.SnapshotStats{}
+		}
+
+		total.TotalSize += stats.TotalSize
+		total.TotalFileCount += stats.TotalFileCount
+	}
+
+	return total
+}
+
+// runRepositoryStats records the stats of the restic repositories that this
+// node has backed up to every repoStatsInterval, until ctx is done.
+func (c *podVolumeBackupController) runRepositoryStats(ctx context.Context) {
+	if !cache.WaitForCacheSync(ctx.Done(), c.cacheSyncWaiters...) {
+		return
+	}
+
+	wait.Until(func() { c.recordRepositoryStats(ctx) }, c.repoStatsInterval, ctx.Done())
+}
+
+// recordRepositoryStats gets the size and number of snapshots of each
+// namespace's restic repository that this node has completed a backup to,
+// and records them as metrics. To limit the load it adds, repositories are
+// checked one at a time, and each check waits for a free backup slot so
+// that it doesn't run in addition to the maximum number of backups.
+func (c *podVolumeBackupController) recordRepositoryStats(ctx context.Context) {
+	pvbs, err := c.podVolumeBackupLister.List(labels.Everything())
+	if err != nil {
+		c.logger.WithError(errors.WithStack(err)).Error("Error listing PodVolumeBackups to get restic repository stats")
+		return
+	}
+
+	// the repository prefix of each namespace's most recent backup.
+	repoPrefixes := make(map[string]string)
+	latest := make(map[string]time.Time)
+	for _, pvb := range pvbs {
+		if pvb.Spec.Node != c.nodeName || pvb.Status.Phase != arkv1api.PodVolumeBackupPhaseCompleted {
+			continue
+		}
+
+		namespace := pvb.Spec.Pod.Namespace
+		if completed := pvb.Status.CompletionTimestamp.Time; repoPrefixes[namespace] == "" || completed.After(latest[namespace]) {
+			repoPrefixes[namespace] = podVolumeBackupRepoPrefix(pvb)
+			latest[namespace] = completed
+		}
+	}
+
+	namespaces := make([]string, 0, len(repoPrefixes))
+	for namespace := range repoPrefixes {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	for _, namespace := range namespaces {
+		log := c.logger.WithFields(logrus.Fields{
+			"repoPrefix": repoPrefixes[namespace],
+			"namespace":  namespace,
+		})
+
+		stats, err := c.repositoryStats(ctx, repoPrefixes[namespace], namespace)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.WithError(err).Warn("Error getting restic repository stats")
+			continue
+		}
+
+		c.metrics.SetResticRepositoryStats(c.nodeName, namespace, stats.TotalSize, stats.SnapshotsCount)
+	}
+}
+
+// repositoryStats gets the stats of a namespace's restic repository once a
+// backup slot is free.
+func (c *podVolumeBackupController) repositoryStats(ctx context.Context, repoPrefix, namespace string) (restic.RepoStats, error) {
+	file, err := c.credentialsFile(namespace)
+	if err != nil {
+		return restic.RepoStats{}, errors.Wrap(err, "error getting restic credentials")
+	}
+
+	if err := c.backupSemaphore.Acquire(ctx, 1); err != nil {
+		return restic.RepoStats{}, errors.Wrap(err, "error acquiring restic backup slot")
+	}
+	defer c.backupSemaphore.Release(1)
+
+	return c.getRepoStatsFunc(ctx, c.resticCommand(restic.RepoStatsCommand(repoPrefix, namespace, file)))
+}
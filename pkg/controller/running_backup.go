@@ -0,0 +1,50 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+)
+
+// RunningBackup describes a PodVolumeBackup that's being processed.
+type RunningBackup struct {
+	// Key is the PodVolumeBackup's namespace/name.
+	Key string
+	// StartTime is when processing of the PodVolumeBackup started.
+	StartTime time.Time
+	// Volume is the volume being backed up, if restic has been started.
+	Volume string
+	// Command is the restic command backing up Volume, with any
+	// credentials redacted.
+	Command string
+}
+
+// RunningBackupsLister lists the PodVolumeBackups that a controller is
+// processing.
+type RunningBackupsLister interface {
+	RunningBackups() []RunningBackup
+}
+
+// runningBackup is the state of a PodVolumeBackup being processed by the
+// pod volume backup controller.
+type runningBackup struct {
+	cancel    context.CancelFunc
+	startTime time.Time
+	volume    string
+	command   string
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/restic"
+	"github.com/heptio/ark/pkg/util/kube"
+)
+
+// verifyBackup runs a restic check of the backup's repository and returns
+// the result. It returns an empty result if verification is disabled.
+func (c *podVolumeBackupController) verifyBackup(ctx context.Context, req *arkv1api.PodVolumeBackup, credsFile string, log logrus.FieldLogger) arkv1api.PodVolumeBackupVerification {
+	if c.verifyReadDataPercent <= 0 {
+		return arkv1api.PodVolumeBackupVerification{}
+	}
+
+	// the backup is left unverified, rather than failed, while other nodes
+	// are using the repository. If periodic verification is enabled, it's
+	// verified then.
+	release, ok := c.acquireExclusiveLease(podVolumeBackupRepoPrefix(req), req.Spec.Pod.Namespace, log)
+	if !ok {
+		log.Info("Restic repository is in use by another node, not verifying it")
+		return arkv1api.PodVolumeBackupVerification{}
+	}
+	defer release()
+
+	return c.checkRepository(ctx, podVolumeBackupRepoPrefix(req), req.Spec.Pod.Namespace, credsFile, log)
+}
+
+// checkRepository runs a restic check of a namespace's repository, reading
+// verifyReadDataPercent percent of its data, and returns the result. The
+// check is killed if ctx is done before it finishes, in which case the
+// repository is left unverified, with an empty result, rather than failed.
+func (c *podVolumeBackupController) checkRepository(ctx context.Context, repoPrefix, namespace, credsFile string, log logrus.FieldLogger) arkv1api.PodVolumeBackupVerification {
+	verification := arkv1api.PodVolumeBackupVerification{
+		ReadDataPercent: c.verifyReadDataPercent,
+	}
+	if verification.ReadDataPercent > 100 {
+		verification.ReadDataPercent = 100
+	}
+
+	verifyCmd := c.resticCommand(restic.VerifyCommand(repoPrefix, namespace, credsFile, c.verifyReadDataPercent))
+	if err := c.verifyRepoFunc(ctx, verifyCmd); err != nil {
+		if ctx.Err() != nil {
+			log.WithError(ctx.Err()).Warn("Stopped verifying restic repository before it finished, leaving it unverified")
+			return arkv1api.PodVolumeBackupVerification{}
+		}
+
+		log.WithError(err).Error("Error verifying restic repository")
+		verification.Phase = arkv1api.PodVolumeBackupVerificationPhaseFailed
+		verification.Message = err.Error()
+		return verification
+	}
+
+	if verification.ReadDataPercent == 100 {
+		verification.Phase = arkv1api.PodVolumeBackupVerificationPhaseVerified
+	} else {
+		verification.Phase = arkv1api.PodVolumeBackupVerificationPhasePartiallyVerified
+	}
+
+	return verification
+}
+
+// runSnapshotVerification verifies up to maxVerifications of the backups
+// that this node has completed every verifyInterval, until ctx is done.
+func (c *podVolumeBackupController) runSnapshotVerification(ctx context.Context) {
+	if !cache.WaitForCacheSync(ctx.Done(), c.cacheSyncWaiters...) {
+		return
+	}
+
+	wait.Until(func() { c.verifySnapshots(ctx) }, c.verifyInterval, ctx.Done())
+}
+
+// verifySnapshots verifies that the snapshots of the backups returned by
+// verificationCandidates are still restorable, and records the result and
+// the time of the verification in their status. Like getting repository
+// stats, backups are verified one at a time, each once a backup slot is
+// free.
+func (c *podVolumeBackupController) verifySnapshots(ctx context.Context) {
+	candidates, err := c.verificationCandidates()
+	if err != nil {
+		c.logger.WithError(err).Error("Error finding PodVolumeBackups to verify")
+		return
+	}
+
+	// restic check verifies a whole repository, so it's only run once per
+	// repository each time backups are verified.
+	repoResults := make(map[string]arkv1api.PodVolumeBackupVerification)
+	for _, pvb := range candidates {
+		log := c.logger.WithField("key", kube.NamespaceAndName(pvb))
+
+		verification, err := c.verifySnapshot(ctx, pvb, repoResults, log)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.WithError(err).Warn("Error verifying PodVolumeBackup's snapshots")
+			continue
+		}
+
+		if _, err := c.patchPodVolumeBackup(pvb.DeepCopy(), func(r *arkv1api.PodVolumeBackup) {
+			r.Status.Verification = verification
+			r.Status.LastVerified = metav1.NewTime(c.clock.Now())
+		}); err != nil {
+			log.WithError(err).Error("Error recording verification result")
+			continue
+		}
+
+		if verification.Phase == arkv1api.PodVolumeBackupVerificationPhaseFailed {
+			c.eventRecorder.Eventf(pvb, corev1api.EventTypeWarning, eventReasonBackupVerificationFailed, "Backup verification failed: %s", verification.Message)
+		}
+	}
+}
+
+// verificationCandidates returns the Completed PodVolumeBackups run by this
+// node that have snapshots and haven't been verified within verifyInterval,
+// least recently verified first, up to maxVerifications of them. Backups
+// that have never been verified come first, oldest first.
+func (c *podVolumeBackupController) verificationCandidates() ([]*arkv1api.PodVolumeBackup, error) {
+	pvbs, err := c.podVolumeBackupLister.List(labels.Everything())
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing PodVolumeBackups")
+	}
+
+	cutoff := c.clock.Now().Add(-c.verifyInterval)
+
+	var candidates []*arkv1api.PodVolumeBackup
+	for _, pvb := range pvbs {
+		if pvb.Spec.Node != c.nodeName || pvb.Status.Phase != arkv1api.PodVolumeBackupPhaseCompleted || pvb.DeletionTimestamp != nil {
+			continue
+		}
+		if podVolumeBackupSnapshotIDs(pvb).Len() == 0 {
+			continue
+		}
+		if !pvb.Status.LastVerified.IsZero() && pvb.Status.LastVerified.Time.After(cutoff) {
+			continue
+		}
+
+		candidates = append(candidates, pvb)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i].Status, candidates[j].Status
+		if !a.LastVerified.Equal(&b.LastVerified) {
+			return a.LastVerified.Before(&b.LastVerified)
+		}
+		if !a.CompletionTimestamp.Equal(&b.CompletionTimestamp) {
+			return a.CompletionTimestamp.Before(&b.CompletionTimestamp)
+		}
+		return kube.NamespaceAndName(candidates[i]) < kube.NamespaceAndName(candidates[j])
+	})
+
+	if c.maxVerifications > 0 && len(candidates) > c.maxVerifications {
+		candidates = candidates[:c.maxVerifications]
+	}
+
+	return candidates, nil
+}
+
+// verifySnapshot checks, once a backup slot is free, that a PodVolumeBackup's
+// snapshots are still in its repository and that the repository passes a
+// restic check. The result of each repository's check is stored in
+// repoResults and reused for the other backups to it. An error is returned
+// only if the backup couldn't be verified.
+func (c *podVolumeBackupController) verifySnapshot(ctx context.Context, pvb *arkv1api.PodVolumeBackup, repoResults map[string]arkv1api.PodVolumeBackupVerification, log logrus.FieldLogger) (arkv1api.PodVolumeBackupVerification, error) {
+	namespace := pvb.Spec.Pod.Namespace
+
+	file, err := c.podVolumeBackupCredentialsFile(pvb)
+	if err != nil {
+		return arkv1api.PodVolumeBackupVerification{}, errors.Wrap(err, "error getting restic credentials")
+	}
+
+	if err := c.backupSemaphore.Acquire(ctx, 1); err != nil {
+		return arkv1api.PodVolumeBackupVerification{}, errors.Wrap(err, "error acquiring restic backup slot")
+	}
+	defer c.backupSemaphore.Release(1)
+
+	snapshotIDs := podVolumeBackupSnapshotIDs(pvb).List()
+	snapshots, err := c.listSnapshotsFunc(c.resticCommand(restic.SnapshotsByIDCommand(podVolumeBackupRepoPrefix(pvb), namespace, file, snapshotIDs)))
+	if err != nil {
+		log.WithError(err).Error("Error listing PodVolumeBackup's snapshots")
+		return arkv1api.PodVolumeBackupVerification{
+			Phase:   arkv1api.PodVolumeBackupVerificationPhaseFailed,
+			Message: errors.Wrap(err, "error listing snapshots").Error(),
+		}, nil
+	}
+
+	if missing := missingSnapshots(snapshotIDs, snapshots); len(missing) > 0 {
+		return arkv1api.PodVolumeBackupVerification{
+			Phase:   arkv1api.PodVolumeBackupVerificationPhaseFailed,
+			Message: fmt.Sprintf("snapshots not found in the restic repository: %s", strings.Join(missing, ", ")),
+		}, nil
+	}
+
+	repo := repoLeaseID(podVolumeBackupRepoPrefix(pvb), namespace)
+	verification, ok := repoResults[repo]
+	if !ok {
+		release, acquired := c.acquireExclusiveLease(podVolumeBackupRepoPrefix(pvb), namespace, log)
+		if !acquired {
+			return arkv1api.PodVolumeBackupVerification{}, errors.New("restic repository is in use by another node")
+		}
+		verification = c.checkRepository(ctx, podVolumeBackupRepoPrefix(pvb), namespace, file, log)
+		release()
+		repoResults[repo] = verification
+	}
+
+	return verification, nil
+}
+
+// missingSnapshots returns the IDs, which may be short IDs, of the snapshots
+// that aren't in snapshots.
+func missingSnapshots(snapshotIDs []string, snapshots []restic.Snapshot) []string {
+	var missing []string
+	for _, id := range snapshotIDs {
+		found := false
+		for _, snapshot := range snapshots {
+			if snapshot.ShortID == id || strings.HasPrefix(snapshot.ID, id) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			missing = append(missing, id)
+		}
+	}
+
+	return missing
+}
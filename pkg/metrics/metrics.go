@@ -0,0 +1,205 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ServerMetrics contains Prometheus metrics for the Ark server.
+type ServerMetrics struct {
+	metrics map[string]prometheus.Collector
+}
+
+const (
+	metricNamespace = "ark"
+
+	podVolumeBackupDurationSeconds = "pod_volume_backup_duration_seconds"
+	podVolumeBackupSuccessTotal    = "pod_volume_backup_success_total"
+	podVolumeBackupFailureTotal    = "pod_volume_backup_failure_total"
+	podVolumeBackupsInProgress     = "pod_volume_backups_in_progress"
+	podVolumeBackupQueueDepth      = "pod_volume_backup_queue_depth"
+	podVolumeBackupInFlightBytes   = "pod_volume_backup_in_flight_bytes"
+	resticRepositorySizeBytes      = "restic_repository_size_bytes"
+	resticRepositorySnapshots      = "restic_repository_snapshots"
+
+	nodeLabel      = "node"
+	namespaceLabel = "namespace"
+	exitCodeLabel  = "exit_code"
+)
+
+// NewPodVolumeMetrics returns new ServerMetrics for the restic server, which
+// runs pod volume backups.
+func NewPodVolumeMetrics() *ServerMetrics {
+	return &ServerMetrics{
+		metrics: map[string]prometheus.Collector{
+			podVolumeBackupDurationSeconds: prometheus.NewHistogramVec(
+				prometheus.HistogramOpts{
+					Namespace: metricNamespace,
+					Name:      podVolumeBackupDurationSeconds,
+					Help:      "Time taken to run the restic backups for a pod volume backup, in seconds",
+					// 1s, 2s, 4s, ... ~4.5h
+					Buckets: prometheus.ExponentialBuckets(1, 2, 15),
+				},
+				[]string{nodeLabel},
+			),
+			podVolumeBackupSuccessTotal: prometheus.NewCounterVec(
+				prometheus.CounterOpts{
+					Namespace: metricNamespace,
+					Name:      podVolumeBackupSuccessTotal,
+					Help:      "Total number of successful pod volume backups",
+				},
+				[]string{nodeLabel},
+			),
+			podVolumeBackupFailureTotal: prometheus.NewCounterVec(
+				prometheus.CounterOpts{
+					Namespace: metricNamespace,
+					Name:      podVolumeBackupFailureTotal,
+					Help:      "Total number of failed pod volume backups",
+				},
+				[]string{nodeLabel, exitCodeLabel},
+			),
+			podVolumeBackupsInProgress: prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Namespace: metricNamespace,
+					Name:      podVolumeBackupsInProgress,
+					Help:      "Number of pod volume backups currently in progress",
+				},
+				[]string{nodeLabel},
+			),
+			podVolumeBackupQueueDepth: prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Namespace: metricNamespace,
+					Name:      podVolumeBackupQueueDepth,
+					Help:      "Number of pod volume backups waiting to be processed by the restic server",
+				},
+				[]string{nodeLabel},
+			),
+			podVolumeBackupInFlightBytes: prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Namespace: metricNamespace,
+					Name:      podVolumeBackupInFlightBytes,
+					Help:      "Total size, in bytes, of the volumes currently being backed up by the restic server",
+				},
+				[]string{nodeLabel},
+			),
+			resticRepositorySizeBytes: prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Namespace: metricNamespace,
+					Name:      resticRepositorySizeBytes,
+					Help:      "Size, in bytes, of the data stored in a namespace's restic repository",
+				},
+				[]string{nodeLabel, namespaceLabel},
+			),
+			resticRepositorySnapshots: prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Namespace: metricNamespace,
+					Name:      resticRepositorySnapshots,
+					Help:      "Number of snapshots in a namespace's restic repository",
+				},
+				[]string{nodeLabel, namespaceLabel},
+			),
+		},
+	}
+}
+
+// RegisterAllMetrics registers all metrics with the default Prometheus registry.
+func (m *ServerMetrics) RegisterAllMetrics() {
+	for _, pm := range m.metrics {
+		prometheus.MustRegister(pm)
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *ServerMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, pm := range m.metrics {
+		pm.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (m *ServerMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, pm := range m.metrics {
+		pm.Collect(ch)
+	}
+}
+
+// ObservePodVolumeBackupDuration records the number of seconds taken to run
+// the restic backups for a pod volume backup.
+func (m *ServerMetrics) ObservePodVolumeBackupDuration(node string, seconds float64) {
+	if h, ok := m.metrics[podVolumeBackupDurationSeconds].(*prometheus.HistogramVec); ok {
+		h.WithLabelValues(node).Observe(seconds)
+	}
+}
+
+// RegisterPodVolumeBackupSuccess records a successful pod volume backup.
+func (m *ServerMetrics) RegisterPodVolumeBackupSuccess(node string) {
+	if c, ok := m.metrics[podVolumeBackupSuccessTotal].(*prometheus.CounterVec); ok {
+		c.WithLabelValues(node).Inc()
+	}
+}
+
+// RegisterPodVolumeBackupFailure records a failed pod volume backup. exitCode
+// is the exit code of the restic command that failed it, or empty if it
+// didn't fail because restic exited with an error.
+func (m *ServerMetrics) RegisterPodVolumeBackupFailure(node, exitCode string) {
+	if c, ok := m.metrics[podVolumeBackupFailureTotal].(*prometheus.CounterVec); ok {
+		c.WithLabelValues(node, exitCode).Inc()
+	}
+}
+
+// PodVolumeBackupStarted increments the number of in-progress pod volume backups.
+func (m *ServerMetrics) PodVolumeBackupStarted(node string) {
+	if g, ok := m.metrics[podVolumeBackupsInProgress].(*prometheus.GaugeVec); ok {
+		g.WithLabelValues(node).Inc()
+	}
+}
+
+// PodVolumeBackupFinished decrements the number of in-progress pod volume backups.
+func (m *ServerMetrics) PodVolumeBackupFinished(node string) {
+	if g, ok := m.metrics[podVolumeBackupsInProgress].(*prometheus.GaugeVec); ok {
+		g.WithLabelValues(node).Dec()
+	}
+}
+
+// SetPodVolumeBackupQueueDepth records the number of pod volume backups
+// waiting to be processed by the restic server on the given node.
+func (m *ServerMetrics) SetPodVolumeBackupQueueDepth(node string, depth int) {
+	if g, ok := m.metrics[podVolumeBackupQueueDepth].(*prometheus.GaugeVec); ok {
+		g.WithLabelValues(node).Set(float64(depth))
+	}
+}
+
+// SetPodVolumeBackupInFlightBytes records the total size of the volumes
+// being backed up by the restic server on the given node.
+func (m *ServerMetrics) SetPodVolumeBackupInFlightBytes(node string, bytes int64) {
+	if g, ok := m.metrics[podVolumeBackupInFlightBytes].(*prometheus.GaugeVec); ok {
+		g.WithLabelValues(node).Set(float64(bytes))
+	}
+}
+
+// SetResticRepositoryStats records the size of the data stored in a
+// namespace's restic repository and its number of snapshots, as reported
+// by the restic server on the given node.
+func (m *ServerMetrics) SetResticRepositoryStats(node, namespace string, sizeBytes, snapshots int64) {
+	if g, ok := m.metrics[resticRepositorySizeBytes].(*prometheus.GaugeVec); ok {
+		g.WithLabelValues(node, namespace).Set(float64(sizeBytes))
+	}
+	if g, ok := m.metrics[resticRepositorySnapshots].(*prometheus.GaugeVec); ok {
+		g.WithLabelValues(node, namespace).Set(float64(snapshots))
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// localBackend is the backend type of repositories whose prefix is a path
+// rather than a URL with a scheme.
+const localBackend = "local"
+
+// BackendOptions holds restic extended options (-o), such as the number of
+// connections to the repository's backend, keyed by option name, e.g.
+// s3.connections. Each option name starts with the type of the backend it
+// applies to.
+type BackendOptions map[string]string
+
+// NewBackendOptions returns the backend options, each of the form
+// <backend>.<name>=<value>. There are no default options, so restic's own
+// defaults are used for any that aren't provided. If an option is provided
+// more than once, its last value is used.
+func NewBackendOptions(values []string) (BackendOptions, error) {
+	options := make(BackendOptions, len(values))
+
+	for _, option := range values {
+		parts := strings.SplitN(option, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, errors.Errorf("backend option %q must be of the form <backend>.<name>=<value>", option)
+		}
+		name, value := parts[0], parts[1]
+
+		if i := strings.Index(name, "."); i <= 0 || i == len(name)-1 {
+			return nil, errors.Errorf("backend option name %q must be of the form <backend>.<name>", name)
+		}
+
+		options[name] = value
+	}
+
+	return options, nil
+}
+
+// For returns the options, of the form <name>=<value> and sorted by name,
+// that apply to the backend of the repository with the provided prefix.
+func (o BackendOptions) For(repoPrefix string) []string {
+	prefix := repoBackend(repoPrefix) + "."
+
+	var options []string
+	for name, value := range o {
+		if strings.HasPrefix(name, prefix) {
+			options = append(options, fmt.Sprintf("%s=%s", name, value))
+		}
+	}
+	sort.Strings(options)
+
+	return options
+}
+
+// repoBackend returns the type of the backend, e.g. s3, gs or azure, of the
+// repository with the provided prefix, taken from the prefix's scheme.
+// Prefixes without a scheme are local repositories.
+func repoBackend(repoPrefix string) string {
+	i := strings.Index(repoPrefix, ":")
+	if i <= 0 || strings.Contains(repoPrefix[:i], "/") {
+		return localBackend
+	}
+
+	return repoPrefix[:i]
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepoBackend(t *testing.T) {
+	tests := map[string]string{
+		"s3:s3.amazonaws.com/bucket/restic":  "s3",
+		"s3:http://minio:9000/bucket/restic": "s3",
+		"gs:bucket:/restic":                  "gs",
+		"azure:container:/restic":            "azure",
+		"rest:http://restic-server:8000/":    "rest",
+		"/srv/restic":                        "local",
+		"restic/repos:a":                     "local",
+		"":                                   "local",
+	}
+
+	for prefix, expected := range tests {
+		assert.Equal(t, expected, repoBackend(prefix), prefix)
+	}
+}
+
+func TestBackendOptionsFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    []string
+		repoPrefix string
+		expected   []string
+	}{
+		{
+			name:       "no options by default",
+			repoPrefix: "s3:s3.amazonaws.com/bucket/restic",
+		},
+		{
+			name:       "options only apply to their backend",
+			options:    []string{"gs.connections=2", "s3.region=us-east-2"},
+			repoPrefix: "gs:bucket:/restic",
+			expected:   []string{"gs.connections=2"},
+		},
+		{
+			name:       "several options for a backend are sorted",
+			options:    []string{"s3.region=us-east-2", "s3.connections=16"},
+			repoPrefix: "s3:s3.amazonaws.com/bucket/restic",
+			expected:   []string{"s3.connections=16", "s3.region=us-east-2"},
+		},
+		{
+			name:       "the last value of a repeated option is used",
+			options:    []string{"azure.connections=4", "azure.connections=2"},
+			repoPrefix: "azure:container:/restic",
+			expected:   []string{"azure.connections=2"},
+		},
+		{
+			name:       "local repositories",
+			options:    []string{"local.layout=default"},
+			repoPrefix: "/srv/restic",
+			expected:   []string{"local.layout=default"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options, err := NewBackendOptions(test.options)
+			require.NoError(t, err)
+
+			assert.Equal(t, test.expected, options.For(test.repoPrefix))
+		})
+	}
+}
+
+func TestNewBackendOptionsInvalid(t *testing.T) {
+	tests := map[string]string{
+		"s3.connections":  `backend option "s3.connections" must be of the form <backend>.<name>=<value>`,
+		"s3.connections=": `backend option "s3.connections=" must be of the form <backend>.<name>=<value>`,
+		"connections=4":   `backend option name "connections" must be of the form <backend>.<name>`,
+		".connections=4":  `backend option name ".connections" must be of the form <backend>.<name>`,
+		"s3.=4":           `backend option name "s3." must be of the form <backend>.<name>`,
+	}
+
+	for option, expectedErr := range tests {
+		_, err := NewBackendOptions([]string{option})
+		assert.EqualError(t, err, expectedErr, option)
+	}
+}
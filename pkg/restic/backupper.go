@@ -58,7 +58,8 @@ func newBackupper(ctx context.Context, repoManager *repositoryManager, podVolume
 			UpdateFunc: func(_, obj interface{}) {
 				pvb := obj.(*arkv1api.PodVolumeBackup)
 
-				if pvb.Status.Phase == arkv1api.PodVolumeBackupPhaseCompleted || pvb.Status.Phase == arkv1api.PodVolumeBackupPhaseFailed {
+				switch pvb.Status.Phase {
+				case arkv1api.PodVolumeBackupPhaseCompleted, arkv1api.PodVolumeBackupPhaseFailed, arkv1api.PodVolumeBackupPhaseCanceled, arkv1api.PodVolumeBackupPhaseCompletedDryRun:
 					b.resultsLock.Lock()
 					b.results[resultsKey(pvb.Spec.Pod.Namespace, pvb.Spec.Pod.Name)] <- pvb
 					b.resultsLock.Unlock()
@@ -120,10 +121,22 @@ ForEachVolume:
 		case res := <-resultsChan:
 			switch res.Status.Phase {
 			case arkv1api.PodVolumeBackupPhaseCompleted:
+				// a volume that was skipped because it was empty has no
+				// snapshot to restore.
+				if res.Status.SnapshotID == "" {
+					delete(volumeSnapshots, res.Spec.Volume)
+					break
+				}
 				volumeSnapshots[res.Spec.Volume] = res.Status.SnapshotID
 			case arkv1api.PodVolumeBackupPhaseFailed:
 				errs = append(errs, errors.Errorf("pod volume backup failed: %s", res.Status.Message))
 				delete(volumeSnapshots, res.Spec.Volume)
+			case arkv1api.PodVolumeBackupPhaseCanceled:
+				errs = append(errs, errors.Errorf("pod volume backup canceled: %s", res.Status.Message))
+				delete(volumeSnapshots, res.Spec.Volume)
+			case arkv1api.PodVolumeBackupPhaseCompletedDryRun:
+				errs = append(errs, errors.New("pod volume backup was not run because the restic server is in dry-run mode"))
+				delete(volumeSnapshots, res.Spec.Volume)
 			}
 		}
 	}
@@ -136,7 +149,7 @@ ForEachVolume:
 }
 
 func newPodVolumeBackup(backup *arkv1api.Backup, pod *corev1api.Pod, volumeName, repoPrefix string) *arkv1api.PodVolumeBackup {
-	return &arkv1api.PodVolumeBackup{
+	pvb := &arkv1api.PodVolumeBackup{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace:    backup.Namespace,
 			GenerateName: backup.Name + "-",
@@ -174,4 +187,23 @@ func newPodVolumeBackup(backup *arkv1api.Backup, pod *corev1api.Pod, volumeName,
 			RepoPrefix: repoPrefix,
 		},
 	}
+
+	// record the volume's definition so the restic server can still find it
+	// on the node if the pod is deleted before the backup is processed.
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == volumeName {
+			pvb.Spec.PodVolumes = []corev1api.Volume{*volume.DeepCopy()}
+			break
+		}
+	}
+
+	if priority, ok := pod.Annotations[BackupPriorityAnnotation]; ok {
+		pvb.Annotations = map[string]string{BackupPriorityAnnotation: priority}
+	}
+
+	if pod.Annotations[ForceRescanAnnotation] == "true" {
+		pvb.Spec.Force = true
+	}
+
+	return pvb
 }
@@ -0,0 +1,58 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+)
+
+func TestNewPodVolumeBackupForceRescan(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:        "annotation set to true",
+			annotations: map[string]string{ForceRescanAnnotation: "true"},
+			expected:    true,
+		},
+		{
+			name:        "annotation set to something else",
+			annotations: map[string]string{ForceRescanAnnotation: "yes"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backup := &arkv1api.Backup{ObjectMeta: metav1.ObjectMeta{Namespace: "heptio-ark", Name: "backup-1"}}
+			pod := &corev1api.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "pod-1", Annotations: test.annotations}}
+
+			pvb := newPodVolumeBackup(backup, pod, "vol-1", "s3:s3.amazonaws.com/bucket")
+			assert.Equal(t, test.expected, pvb.Spec.Force)
+		})
+	}
+}
@@ -0,0 +1,29 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+// minBlockBackupVersion is the first restic version that supports backing
+// up the output of a command, with --stdin-from-command, which is how block
+// devices are backed up.
+var minBlockBackupVersion = Version{0, 17, 0}
+
+// SupportsBlockBackup returns true if the restic version, as output by
+// 'restic version', supports backing up block devices with
+// BlockBackupCommand.
+func SupportsBlockBackup(version string) (bool, error) {
+	return versionAtLeast(version, minBlockBackupVersion)
+}
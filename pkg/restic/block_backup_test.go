@@ -0,0 +1,36 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupportsBlockBackup(t *testing.T) {
+	supported, err := SupportsBlockBackup("restic 0.16.4 compiled with go1.21.6 on linux/amd64")
+	assert.NoError(t, err)
+	assert.False(t, supported)
+
+	supported, err = SupportsBlockBackup("restic 0.17.0 compiled with go1.22.5 on linux/amd64")
+	assert.NoError(t, err)
+	assert.True(t, supported)
+
+	_, err = SupportsBlockBackup("not restic")
+	assert.Error(t, err)
+}
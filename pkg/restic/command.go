@@ -17,50 +17,265 @@ limitations under the License.
 package restic
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+
+	"github.com/pkg/errors"
 )
 
 // Command represents a restic command.
 type Command struct {
 	BaseName     string
+	GlobalFlags  []string
 	Command      string
 	RepoPrefix   string
 	Repo         string
 	PasswordFile string
 	Args         []string
 	ExtraFlags   []string
+
+	// CacheDir is the parent of the restic cache directory for the repo;
+	// each repo gets its own subdirectory so caches aren't shared across
+	// repos. If empty, restic's default cache location is used.
+	CacheDir string
+
+	// NoCache disables restic's local cache, and overrides CacheDir.
+	NoCache bool
+
+	// SharedCache keys the repo's cache directory, under CacheDir, by the
+	// repository's URL, as returned by SharedCacheKey, rather than by Repo,
+	// so that each repository has a single cache, shared by every command
+	// run against it.
+	SharedCache bool
+
+	// Wrapper is a command, with arguments, to run restic under, e.g.
+	// to lower its I/O priority.
+	Wrapper []string
+
+	// Compression is the compression level (off, auto or max) that restic
+	// uses for data it writes. If empty, restic's default is used. It only
+	// takes effect for repositories that support compression.
+	Compression string
+
+	// PackSize is the target size, in MiB, of the pack files restic
+	// writes. If zero, restic's default is used.
+	PackSize int
+
+	// Options are extended options, of the form <name>=<value>, passed to
+	// restic with -o, e.g. the backend options returned by
+	// BackendOptions.For.
+	Options []string
+
+	// PasswordCommand is a command whose output restic uses as the
+	// repository password, as an alternative to PasswordFile.
+	PasswordCommand string
+
+	// Env holds additional environment variables, in KEY=VALUE form, to
+	// run restic with. They're added to the current process's environment,
+	// which holds the object store credentials, rather than replacing it.
+	Env []string
+
+	// RunAs is the user and group to run restic as. If nil, restic runs
+	// as the current process's user.
+	RunAs *RunAs
+
+	// SnapshotGroup is the group of snapshots, for a 'restic snapshots'
+	// command that groups them, that GetSnapshotID gets the ID from.
+	SnapshotGroup *SnapshotGroupKey
 }
 
 // StringSlice returns the command as a slice of strings.
 func (c *Command) StringSlice() []string {
-	var res []string
+	res := append([]string{}, c.Wrapper...)
 	if c.BaseName != "" {
 		res = append(res, c.BaseName)
 	} else {
 		res = append(res, "/restic")
 	}
 
+	res = append(res, c.GlobalFlags...)
+	if c.Compression != "" {
+		res = append(res, fmt.Sprintf("--compression=%s", c.Compression))
+	}
+	if c.PackSize > 0 {
+		res = append(res, fmt.Sprintf("--pack-size=%d", c.PackSize))
+	}
+	if c.NoCache {
+		res = append(res, "--no-cache")
+	} else if c.CacheDir != "" {
+		res = append(res, cacheDirFlag(c.CacheDir, c.cacheSubdir()))
+	}
+	for _, option := range c.Options {
+		res = append(res, "-o", option)
+	}
 	res = append(res, c.Command, repoFlag(c.RepoPrefix, c.Repo))
 	if c.PasswordFile != "" {
 		res = append(res, passwordFlag(c.PasswordFile))
 	}
+	if c.PasswordCommand != "" {
+		res = append(res, fmt.Sprintf("--password-command=%s", c.PasswordCommand))
+	}
 	res = append(res, c.Args...)
 	res = append(res, c.ExtraFlags...)
 
 	return res
 }
 
+// cacheSubdir returns the subdirectory of CacheDir that the repo is cached
+// in.
+func (c *Command) cacheSubdir() string {
+	if c.SharedCache {
+		return filepath.Join(sharedCacheDirName, SharedCacheKey(c.RepoPrefix, c.Repo))
+	}
+
+	return c.Repo
+}
+
 // String returns the command as a string.
 func (c *Command) String() string {
 	return strings.Join(c.StringSlice(), " ")
 }
 
+// RedactedString returns the command as a string, like String, but with
+// the values of the password file and command flags, and of any other flags
+// or extended options (-o) whose names suggest they hold secrets, replaced
+// by <redacted>. It's suitable for recording the command for auditing.
+func (c *Command) RedactedString() string {
+	parts := c.StringSlice()
+
+	for i := range parts {
+		if i > 0 && !strings.Contains(parts[i-1], "=") {
+			// the value of a flag given as a separate argument
+			switch prev := parts[i-1]; {
+			case prev == "-o" || prev == "--option":
+				parts[i] = redactOption(parts[i])
+				continue
+			case strings.HasPrefix(prev, "-") && isSensitive(prev):
+				parts[i] = redacted
+				continue
+			}
+		}
+
+		parts[i] = redactFlag(parts[i])
+	}
+
+	return strings.Join(parts, " ")
+}
+
+const redacted = "<redacted>"
+
+// sensitiveWords are the words that mark a flag or extended option as
+// holding a secret.
+var sensitiveWords = []string{"password", "secret", "token", "key", "credential"}
+
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range sensitiveWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactFlag redacts the value of a --name=value flag if it holds a
+// secret.
+func redactFlag(arg string) string {
+	if !strings.HasPrefix(arg, "-") {
+		return arg
+	}
+
+	parts := strings.SplitN(arg, "=", 2)
+	if len(parts) < 2 {
+		return arg
+	}
+
+	switch {
+	case parts[0] == "-o" || parts[0] == "--option":
+		return parts[0] + "=" + redactOption(parts[1])
+	case isSensitive(parts[0]):
+		return parts[0] + "=" + redacted
+	default:
+		return arg
+	}
+}
+
+// redactOption redacts the value of a restic extended option, of the form
+// name=value, if it holds a secret.
+func redactOption(option string) string {
+	parts := strings.SplitN(option, "=", 2)
+	if len(parts) < 2 || !isSensitive(parts[0]) {
+		return option
+	}
+
+	return parts[0] + "=" + redacted
+}
+
 // Cmd returns an exec.Cmd for the command.
 func (c *Command) Cmd() *exec.Cmd {
 	parts := c.StringSlice()
-	return exec.Command(parts[0], parts[1:]...)
+	return c.withProcAttr(exec.Command(parts[0], parts[1:]...))
+}
+
+// CmdContext returns an exec.Cmd for the command that is killed
+// if the context is done before the command completes.
+func (c *Command) CmdContext(ctx context.Context) *exec.Cmd {
+	parts := c.StringSlice()
+	return c.withProcAttr(exec.CommandContext(ctx, parts[0], parts[1:]...))
+}
+
+// withProcAttr adds the command's additional environment variables, if
+// any, to cmd's environment, and sets the user and group to run it as.
+func (c *Command) withProcAttr(cmd *exec.Cmd) *exec.Cmd {
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	cmd.SysProcAttr = c.RunAs.sysProcAttr()
+	return cmd
+}
+
+// ValidateEnv returns an error if any of the provided environment variables
+// isn't of the form KEY=VALUE with a valid key.
+func ValidateEnv(env []string) error {
+	for _, v := range env {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 {
+			return errors.Errorf("environment variable %q must be of the form KEY=VALUE", v)
+		}
+		if parts[0] == "" || strings.ContainsAny(parts[0], " \t\n") {
+			return errors.Errorf("environment variable %q has an invalid key", v)
+		}
+	}
+
+	return nil
+}
+
+// ioniceClassArgs maps the supported I/O scheduling class names to
+// ionice arguments. Best-effort uses the lowest priority within its
+// class.
+var ioniceClassArgs = map[string][]string{
+	"best-effort": {"-c2", "-n7"},
+	"idle":        {"-c3"},
+}
+
+// IONiceWrapper returns a Command.Wrapper that runs restic under ionice
+// with the named I/O scheduling class ("best-effort" or "idle"). An empty
+// class returns no wrapper.
+func IONiceWrapper(class string) ([]string, error) {
+	if class == "" {
+		return nil, nil
+	}
+
+	args, ok := ioniceClassArgs[class]
+	if !ok {
+		return nil, errors.Errorf("unsupported I/O class %q, must be one of best-effort, idle", class)
+	}
+
+	return append([]string{"ionice"}, args...), nil
 }
 
 func repoFlag(prefix, repo string) string {
@@ -70,3 +285,7 @@ func repoFlag(prefix, repo string) string {
 func passwordFlag(file string) string {
 	return fmt.Sprintf("--password-file=%s", file)
 }
+
+func cacheDirFlag(dir, subdir string) string {
+	return fmt.Sprintf("--cache-dir=%s", filepath.Join(dir, subdir))
+}
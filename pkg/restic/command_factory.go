@@ -2,21 +2,111 @@ package restic
 
 import (
 	"fmt"
+	"path"
+	"regexp"
 	"strings"
+
+	"github.com/pkg/errors"
 )
 
-// BackupCommand returns a Command for running a restic backup.
-func BackupCommand(repoPrefix, repo, passwordFile, path string, tags map[string]string) *Command {
+// BackupCommand returns a Command for running a restic backup. Files matching
+// any of excludes are not backed up, nor, if excludeLargerThan is non-empty,
+// are files larger than that size, as validated by ValidateExcludeLargerThan.
+// If jsonOutput is true, restic will report
+// its progress as JSON messages on stdout. If limitUpload is greater than zero,
+// restic's upload rate is limited to that many KiB/s. If oneFileSystem is
+// true, restic doesn't cross into other filesystems mounted under path. If
+// readConcurrency is greater than zero, restic reads that many files at once.
+// If host is non-empty, it's recorded as the snapshot's host instead of the
+// hostname of the machine running restic. If force is true, restic re-reads
+// every file rather than skipping those unchanged since the parent snapshot.
+func BackupCommand(repoPrefix, repo, passwordFile, path string, tags map[string]string, excludes []string, excludeLargerThan string, jsonOutput bool, limitUpload int, oneFileSystem bool, readConcurrency int, host string, force bool) *Command {
+	extraFlags := backupTagFlags(tags)
+	for _, exclude := range excludes {
+		extraFlags = append(extraFlags, fmt.Sprintf("--exclude=%s", exclude))
+	}
+	if excludeLargerThan != "" {
+		extraFlags = append(extraFlags, fmt.Sprintf("--exclude-larger-than=%s", excludeLargerThan))
+	}
+	if jsonOutput {
+		extraFlags = append(extraFlags, "--json")
+	}
+	if limitUpload > 0 {
+		extraFlags = append(extraFlags, fmt.Sprintf("--limit-upload=%d", limitUpload))
+	}
+	if oneFileSystem {
+		extraFlags = append(extraFlags, "--one-file-system")
+	}
+	if readConcurrency > 0 {
+		extraFlags = append(extraFlags, fmt.Sprintf("--read-concurrency=%d", readConcurrency))
+	}
+	if host != "" {
+		extraFlags = append(extraFlags, fmt.Sprintf("--host=%s", host))
+	}
+	if force {
+		extraFlags = append(extraFlags, "--force")
+	}
+
 	return &Command{
 		Command:      "backup",
 		RepoPrefix:   repoPrefix,
 		Repo:         repo,
 		PasswordFile: passwordFile,
 		Args:         []string{path},
-		ExtraFlags:   backupTagFlags(tags),
+		ExtraFlags:   extraFlags,
 	}
 }
 
+// BlockBackupCommand returns a Command for running a restic backup of the
+// contents of a raw block device, which restic reads from the standard
+// output of cat and stores as a single file named filename. Since restic
+// runs cat itself, a failure to read the device fails the backup rather than
+// creating a truncated snapshot. This requires restic 0.17.0 or later; see
+// SupportsBlockBackup.
+func BlockBackupCommand(repoPrefix, repo, passwordFile, devicePath, filename string, tags map[string]string, jsonOutput bool, limitUpload int, host string) *Command {
+	cmd := BackupCommand(repoPrefix, repo, passwordFile, devicePath, tags, nil, "", jsonOutput, limitUpload, false, 0, host, false)
+	cmd.Args = []string{"cat", devicePath}
+	cmd.ExtraFlags = append(cmd.ExtraFlags, "--stdin-from-command", fmt.Sprintf("--stdin-filename=%s", filename))
+
+	return cmd
+}
+
+// maxExcludePatternLength is the maximum length of a backup exclude pattern.
+const maxExcludePatternLength = 1024
+
+// ValidateExcludePatterns returns an error if any of the provided backup
+// exclude patterns is empty or unreasonably long.
+func ValidateExcludePatterns(patterns []string) error {
+	for i, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" {
+			return errors.Errorf("exclude pattern %d is empty", i)
+		}
+		if len(pattern) > maxExcludePatternLength {
+			return errors.Errorf("exclude pattern %d is longer than %d characters", i, maxExcludePatternLength)
+		}
+	}
+
+	return nil
+}
+
+// excludeLargerThanRegexp matches the sizes accepted by restic's
+// --exclude-larger-than flag: a number of bytes, or of KiB, MiB, GiB or TiB
+// if it's followed by k, m, g or t, in either case.
+var excludeLargerThanRegexp = regexp.MustCompile(`^[0-9]+[kKmMgGtT]?$`)
+
+// ValidateExcludeLargerThan returns an error if the provided size isn't one
+// that restic's --exclude-larger-than flag accepts, e.g. 500M, or is zero.
+func ValidateExcludeLargerThan(size string) error {
+	if !excludeLargerThanRegexp.MatchString(size) {
+		return errors.Errorf("size %q must be a number of bytes, optionally followed by one of the suffixes k, m, g or t", size)
+	}
+	if strings.Trim(strings.TrimRight(size, "kKmMgGtT"), "0") == "" {
+		return errors.Errorf("size %q must be greater than zero", size)
+	}
+
+	return nil
+}
+
 func backupTagFlags(tags map[string]string) []string {
 	var flags []string
 	for k, v := range tags {
@@ -25,27 +115,127 @@ func backupTagFlags(tags map[string]string) []string {
 	return flags
 }
 
-// RestoreCommand returns a Command for running a restic restore.
-func RestoreCommand(repoPrefix, repo, passwordFile, podUID, snapshotID string) *Command {
+// RestoreCommand returns a Command for running a restic restore. If any
+// includes are given, only the files and directories matching them, as
+// returned by RestoreIncludePatterns, are restored.
+func RestoreCommand(repoPrefix, repo, passwordFile, podUID, snapshotID string, includes []string) *Command {
+	extraFlags := []string{fmt.Sprintf("--target=/restores/%s", podUID)}
+	for _, include := range includes {
+		extraFlags = append(extraFlags, fmt.Sprintf("--include=%s", include))
+	}
+
 	return &Command{
 		Command:      "restore",
 		RepoPrefix:   repoPrefix,
 		Repo:         repo,
 		PasswordFile: passwordFile,
 		Args:         []string{snapshotID},
-		ExtraFlags:   []string{fmt.Sprintf("--target=/restores/%s", podUID)},
+		ExtraFlags:   extraFlags,
 	}
 }
 
+// RestoreIncludePatterns returns the restic include patterns that select
+// the provided paths, relative to the root of a volume, from a snapshot of
+// the volume whose directory on the node is named volumeDir. Snapshots
+// record the volume's absolute path on the node it was backed up from, so
+// the patterns are relative, which restic matches at any depth. An error
+// is returned if any path is empty or refers outside of the volume.
+func RestoreIncludePatterns(volumeDir string, paths []string) ([]string, error) {
+	var patterns []string
+	for i, p := range paths {
+		cleaned := path.Clean("/" + p)
+		if strings.TrimSpace(p) == "" || cleaned == "/" {
+			return nil, errors.Errorf("include path %d is empty", i)
+		}
+		for _, elem := range strings.Split(p, "/") {
+			if elem == ".." {
+				return nil, errors.Errorf("include path %d (%s) must not contain '..'", i, p)
+			}
+		}
+
+		patterns = append(patterns, volumeDir+cleaned)
+	}
+
+	return patterns, nil
+}
+
+// CleanSubPath returns the provided subdirectory of a volume, cleaned and
+// relative to the root of the volume. An error is returned if it's empty,
+// is the root of the volume, or refers outside of the volume.
+func CleanSubPath(subPath string) (string, error) {
+	cleaned := path.Clean("/" + subPath)
+	if strings.TrimSpace(subPath) == "" || cleaned == "/" {
+		return "", errors.New("subPath is empty")
+	}
+	for _, elem := range strings.Split(subPath, "/") {
+		if elem == ".." {
+			return "", errors.Errorf("subPath %s must not contain '..'", subPath)
+		}
+	}
+
+	return strings.TrimPrefix(cleaned, "/"), nil
+}
+
 // GetSnapshotCommand returns a Command for running a restic (get) snapshots.
-func GetSnapshotCommand(repoPrefix, repo, passwordFile string, tags map[string]string) *Command {
-	return &Command{
+// restic lists the last snapshot with the tags in each group of snapshots
+// taken from the same host and paths, so in repositories shared by several
+// hosts or paths there may be more than one; if group is non-nil, the
+// snapshots are grouped by its fields, and GetSnapshotID only considers the
+// snapshot in the group it identifies.
+func GetSnapshotCommand(repoPrefix, repo, passwordFile string, tags map[string]string, group *SnapshotGroupKey) *Command {
+	cmd := &Command{
 		Command:      "snapshots",
 		RepoPrefix:   repoPrefix,
 		Repo:         repo,
 		PasswordFile: passwordFile,
 		ExtraFlags:   []string{"--json", "--last", getSnapshotTagFlag(tags)},
 	}
+	if group != nil && group.GroupBy() != "" {
+		cmd.ExtraFlags = append(cmd.ExtraFlags, groupByFlag(group.GroupBy()))
+		cmd.SnapshotGroup = group
+	}
+
+	return cmd
+}
+
+// ListSnapshotsCommand returns a Command for listing a repository's
+// snapshots, with JSON output. If tags is non-empty, only snapshots with all
+// of the given tags are listed. If groupBy is non-empty, e.g. "host,paths",
+// the snapshots are listed in groups, which ListSnapshotGroups returns.
+func ListSnapshotsCommand(repoPrefix, repo, passwordFile string, tags map[string]string, groupBy string) *Command {
+	extraFlags := []string{"--json"}
+	if len(tags) > 0 {
+		extraFlags = append(extraFlags, getSnapshotTagFlag(tags))
+	}
+	if groupBy != "" {
+		extraFlags = append(extraFlags, groupByFlag(groupBy))
+	}
+
+	return &Command{
+		Command:      "snapshots",
+		RepoPrefix:   repoPrefix,
+		Repo:         repo,
+		PasswordFile: passwordFile,
+		ExtraFlags:   extraFlags,
+	}
+}
+
+// SnapshotsByIDCommand returns a Command for listing the snapshots with
+// the given IDs, with JSON output. Snapshots that aren't in the repository
+// aren't listed.
+func SnapshotsByIDCommand(repoPrefix, repo, passwordFile string, snapshotIDs []string) *Command {
+	return &Command{
+		Command:      "snapshots",
+		RepoPrefix:   repoPrefix,
+		Repo:         repo,
+		PasswordFile: passwordFile,
+		Args:         snapshotIDs,
+		ExtraFlags:   []string{"--json"},
+	}
+}
+
+func groupByFlag(groupBy string) string {
+	return fmt.Sprintf("--group-by=%s", groupBy)
 }
 
 func getSnapshotTagFlag(tags map[string]string) string {
@@ -57,6 +247,88 @@ func getSnapshotTagFlag(tags map[string]string) string {
 	return fmt.Sprintf("--tag=%s", strings.Join(tagFilters, ","))
 }
 
+// StatsCommand returns a Command for running a restic stats for a single
+// snapshot, with JSON output.
+func StatsCommand(repoPrefix, repo, passwordFile, snapshotID string) *Command {
+	return &Command{
+		Command:      "stats",
+		RepoPrefix:   repoPrefix,
+		Repo:         repo,
+		PasswordFile: passwordFile,
+		Args:         []string{snapshotID},
+		ExtraFlags:   []string{"--json"},
+	}
+}
+
+// RepoStatsCommand returns a Command for running a restic stats for a whole
+// repository, with JSON output, that reports the size of the data stored in
+// it rather than the size of the files in its snapshots.
+func RepoStatsCommand(repoPrefix, repo, passwordFile string) *Command {
+	return &Command{
+		Command:      "stats",
+		RepoPrefix:   repoPrefix,
+		Repo:         repo,
+		PasswordFile: passwordFile,
+		ExtraFlags:   []string{"--json", "--mode=raw-data"},
+	}
+}
+
+// VerifyCommand returns a Command for running a restic check after a
+// backup, which verifies the integrity of the repository's structure and
+// reads readDataPercent percent of its data to verify that it's intact. If
+// readDataPercent is 100 or more, all of the data is read. Unlike
+// CheckCommand, the repository isn't locked, so that verifying it doesn't
+// block backups of other pods.
+func VerifyCommand(repoPrefix, repo, passwordFile string, readDataPercent int) *Command {
+	extraFlags := []string{"--no-lock"}
+	switch {
+	case readDataPercent >= 100:
+		extraFlags = append(extraFlags, "--read-data")
+	case readDataPercent > 0:
+		extraFlags = append(extraFlags, fmt.Sprintf("--read-data-subset=%d%%", readDataPercent))
+	}
+
+	return &Command{
+		Command:      "check",
+		RepoPrefix:   repoPrefix,
+		Repo:         repo,
+		PasswordFile: passwordFile,
+		ExtraFlags:   extraFlags,
+	}
+}
+
+// CatConfigCommand returns a Command for running a restic cat config, which
+// fails if the repository has not been initialized.
+func CatConfigCommand(repoPrefix, repo, passwordFile string) *Command {
+	return &Command{
+		Command:      "cat",
+		RepoPrefix:   repoPrefix,
+		Repo:         repo,
+		PasswordFile: passwordFile,
+		Args:         []string{"config"},
+	}
+}
+
+// UnlockCommand returns a Command for running a restic unlock, which removes
+// stale locks from the repository, i.e. those held by processes that are no
+// longer running. If removeAll is true, all locks are removed, including
+// those held by running processes, so it must only be used when nothing
+// else can be using the repository.
+func UnlockCommand(repoPrefix, repo, passwordFile string, removeAll bool) *Command {
+	var extraFlags []string
+	if removeAll {
+		extraFlags = append(extraFlags, "--remove-all")
+	}
+
+	return &Command{
+		Command:      "unlock",
+		RepoPrefix:   repoPrefix,
+		Repo:         repo,
+		PasswordFile: passwordFile,
+		ExtraFlags:   extraFlags,
+	}
+}
+
 func InitCommand(repoPrefix, repo string) *Command {
 	return &Command{
 		Command:    "init",
@@ -0,0 +1,625 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandStringSlice(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      *Command
+		expected []string
+	}{
+		{
+			name: "default base name",
+			cmd: &Command{
+				Command:    "check",
+				RepoPrefix: "s3:s3.amazonaws.com/bucket",
+				Repo:       "ns-1",
+			},
+			expected: []string{"/restic", "check", "--repo=s3:s3.amazonaws.com/bucket/ns-1"},
+		},
+		{
+			name: "custom base name and global flags",
+			cmd: &Command{
+				BaseName:     "/usr/local/bin/restic",
+				GlobalFlags:  []string{"--cache-dir=/scratch/restic", "--cleanup-cache"},
+				Command:      "backup",
+				RepoPrefix:   "s3:s3.amazonaws.com/bucket",
+				Repo:         "ns-1",
+				PasswordFile: "/tmp/credentials",
+				Args:         []string{"/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1"},
+				ExtraFlags:   []string{"--json"},
+			},
+			expected: []string{
+				"/usr/local/bin/restic",
+				"--cache-dir=/scratch/restic",
+				"--cleanup-cache",
+				"backup",
+				"--repo=s3:s3.amazonaws.com/bucket/ns-1",
+				"--password-file=/tmp/credentials",
+				"/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1",
+				"--json",
+			},
+		},
+		{
+			name: "cache dir is scoped to the repo",
+			cmd: &Command{
+				CacheDir:   "/scratch/restic-cache",
+				Command:    "snapshots",
+				RepoPrefix: "s3:s3.amazonaws.com/bucket",
+				Repo:       "ns-1",
+			},
+			expected: []string{"/restic", "--cache-dir=/scratch/restic-cache/ns-1", "snapshots", "--repo=s3:s3.amazonaws.com/bucket/ns-1"},
+		},
+		{
+			name: "shared cache dir is keyed by the repo's URL",
+			cmd: &Command{
+				CacheDir:    "/scratch/restic-cache",
+				SharedCache: true,
+				Command:     "snapshots",
+				RepoPrefix:  "s3:s3.amazonaws.com/bucket",
+				Repo:        "ns-1",
+			},
+			expected: []string{"/restic", "--cache-dir=/scratch/restic-cache/shared/" + SharedCacheKey("s3:s3.amazonaws.com/bucket", "ns-1"), "snapshots", "--repo=s3:s3.amazonaws.com/bucket/ns-1"},
+		},
+		{
+			name: "no cache overrides cache dir",
+			cmd: &Command{
+				CacheDir:   "/scratch/restic-cache",
+				NoCache:    true,
+				Command:    "snapshots",
+				RepoPrefix: "s3:s3.amazonaws.com/bucket",
+				Repo:       "ns-1",
+			},
+			expected: []string{"/restic", "--no-cache", "snapshots", "--repo=s3:s3.amazonaws.com/bucket/ns-1"},
+		},
+		{
+			name: "wrapper",
+			cmd: &Command{
+				Wrapper:    []string{"ionice", "-c3"},
+				Command:    "check",
+				RepoPrefix: "s3:s3.amazonaws.com/bucket",
+				Repo:       "ns-1",
+			},
+			expected: []string{"ionice", "-c3", "/restic", "check", "--repo=s3:s3.amazonaws.com/bucket/ns-1"},
+		},
+		{
+			name: "compression",
+			cmd: &Command{
+				GlobalFlags: []string{"--limit-upload=1024"},
+				Compression: "max",
+				Command:     "backup",
+				RepoPrefix:  "s3:s3.amazonaws.com/bucket",
+				Repo:        "ns-1",
+			},
+			expected: []string{"/restic", "--limit-upload=1024", "--compression=max", "backup", "--repo=s3:s3.amazonaws.com/bucket/ns-1"},
+		},
+		{
+			name: "pack size",
+			cmd: &Command{
+				PackSize:   64,
+				Command:    "init",
+				RepoPrefix: "s3:s3.amazonaws.com/bucket",
+				Repo:       "ns-1",
+			},
+			expected: []string{"/restic", "--pack-size=64", "init", "--repo=s3:s3.amazonaws.com/bucket/ns-1"},
+		},
+		{
+			name: "extended options",
+			cmd: &Command{
+				Options:    []string{"s3.connections=16", "s3.region=us-east-2"},
+				Command:    "backup",
+				RepoPrefix: "s3:s3.amazonaws.com/bucket",
+				Repo:       "ns-1",
+			},
+			expected: []string{"/restic", "-o", "s3.connections=16", "-o", "s3.region=us-east-2", "backup", "--repo=s3:s3.amazonaws.com/bucket/ns-1"},
+		},
+		{
+			name: "password command",
+			cmd: &Command{
+				Command:         "snapshots",
+				RepoPrefix:      "s3:s3.amazonaws.com/bucket",
+				Repo:            "ns-1",
+				PasswordCommand: "vault read -field=password secret/restic",
+			},
+			expected: []string{"/restic", "snapshots", "--repo=s3:s3.amazonaws.com/bucket/ns-1", "--password-command=vault read -field=password secret/restic"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.cmd.StringSlice())
+			assert.Equal(t, test.expected, test.cmd.Cmd().Args)
+		})
+	}
+}
+
+func TestCommandRedactedString(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      *Command
+		expected string
+	}{
+		{
+			name: "password file",
+			cmd: &Command{
+				Command:      "backup",
+				RepoPrefix:   "s3:s3.amazonaws.com/bucket",
+				Repo:         "ns-1",
+				PasswordFile: "/tmp/credentials",
+				Args:         []string{"/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1"},
+				ExtraFlags:   []string{"--tag=ns=ns-1,volume=vol-1"},
+			},
+			expected: "/restic backup --repo=s3:s3.amazonaws.com/bucket/ns-1 --password-file=<redacted> /host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1 --tag=ns=ns-1,volume=vol-1",
+		},
+		{
+			name: "password command",
+			cmd: &Command{
+				Command:         "backup",
+				RepoPrefix:      "s3:s3.amazonaws.com/bucket",
+				Repo:            "ns-1",
+				PasswordCommand: "vault read -field=password secret/restic",
+			},
+			expected: "/restic backup --repo=s3:s3.amazonaws.com/bucket/ns-1 --password-command=<redacted>",
+		},
+		{
+			name: "sensitive global flags and extended options",
+			cmd: &Command{
+				GlobalFlags: []string{
+					"--option=azure.account-key=azure-secret",
+					"-o", "s3.secret-access-key=s3-secret",
+					"-o", "s3.region=us-east-1",
+					"--tls-client-cert", "/certs/client.pem",
+					"--key-hint", "key-id",
+				},
+				Command:    "snapshots",
+				RepoPrefix: "s3:s3.amazonaws.com/bucket",
+				Repo:       "ns-1",
+			},
+			expected: "/restic --option=azure.account-key=<redacted> -o s3.secret-access-key=<redacted> -o s3.region=us-east-1 --tls-client-cert /certs/client.pem --key-hint <redacted> snapshots --repo=s3:s3.amazonaws.com/bucket/ns-1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := test.cmd.RedactedString()
+			assert.Equal(t, test.expected, res)
+
+			for _, secret := range []string{"/tmp/credentials", "vault", "azure-secret", "s3-secret", "key-id"} {
+				assert.NotContains(t, res, secret)
+			}
+		})
+	}
+}
+
+func TestCommandEnv(t *testing.T) {
+	cmd := &Command{Command: "snapshots", RepoPrefix: "prefix", Repo: "ns-1"}
+
+	// without additional variables, restic inherits the environment.
+	assert.Nil(t, cmd.Cmd().Env)
+	assert.Nil(t, cmd.CmdContext(context.Background()).Env)
+
+	cmd.Env = []string{"HTTPS_PROXY=http://proxy:3128", "RESTIC_FEATURES=device-id-for-hardlinks"}
+	expected := append(os.Environ(), cmd.Env...)
+	assert.Equal(t, expected, cmd.Cmd().Env)
+	assert.Equal(t, expected, cmd.CmdContext(context.Background()).Env)
+}
+
+func TestValidateEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       []string
+		expectErr bool
+	}{
+		{
+			name: "no variables",
+		},
+		{
+			name: "valid variables",
+			env:  []string{"HTTPS_PROXY=http://proxy:3128", "SSL_CERT_FILE=/certs/ca.pem", "EMPTY=", "WITH_EQUALS=a=b"},
+		},
+		{
+			name:      "missing equals",
+			env:       []string{"HTTPS_PROXY"},
+			expectErr: true,
+		},
+		{
+			name:      "empty key",
+			env:       []string{"=value"},
+			expectErr: true,
+		},
+		{
+			name:      "key with whitespace",
+			env:       []string{"HTTPS PROXY=http://proxy:3128"},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateEnv(test.env)
+			if test.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestIONiceWrapper(t *testing.T) {
+	tests := []struct {
+		class       string
+		expected    []string
+		expectedErr bool
+	}{
+		{class: ""},
+		{class: "best-effort", expected: []string{"ionice", "-c2", "-n7"}},
+		{class: "idle", expected: []string{"ionice", "-c3"}},
+		{class: "realtime", expectedErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.class, func(t *testing.T) {
+			wrapper, err := IONiceWrapper(test.class)
+			if test.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.expected, wrapper)
+		})
+	}
+}
+
+func TestBackupCommandLimitUpload(t *testing.T) {
+	for _, flag := range BackupCommand("prefix", "ns-1", "/tmp/credentials", "/path", nil, nil, "", true, 0, false, 0, "", false).ExtraFlags {
+		assert.False(t, strings.HasPrefix(flag, "--limit-upload"), "unexpected flag %s", flag)
+	}
+
+	assert.Contains(t, BackupCommand("prefix", "ns-1", "/tmp/credentials", "/path", nil, nil, "", true, 1024, false, 0, "", false).ExtraFlags, "--limit-upload=1024")
+}
+
+func TestBackupCommandOneFileSystem(t *testing.T) {
+	assert.NotContains(t, BackupCommand("prefix", "ns-1", "/tmp/credentials", "/path", nil, nil, "", true, 0, false, 0, "", false).ExtraFlags, "--one-file-system")
+	assert.Contains(t, BackupCommand("prefix", "ns-1", "/tmp/credentials", "/path", nil, nil, "", true, 0, true, 0, "", false).ExtraFlags, "--one-file-system")
+}
+
+func TestBackupCommandReadConcurrency(t *testing.T) {
+	for _, flag := range BackupCommand("prefix", "ns-1", "/tmp/credentials", "/path", nil, nil, "", true, 0, false, 0, "", false).ExtraFlags {
+		assert.False(t, strings.HasPrefix(flag, "--read-concurrency"), "unexpected flag %s", flag)
+	}
+
+	assert.Contains(t, BackupCommand("prefix", "ns-1", "/tmp/credentials", "/path", nil, nil, "", true, 0, false, 8, "", false).ExtraFlags, "--read-concurrency=8")
+}
+
+func TestBackupCommandHost(t *testing.T) {
+	for _, flag := range BackupCommand("prefix", "ns-1", "/tmp/credentials", "/path", nil, nil, "", true, 0, false, 0, "", false).ExtraFlags {
+		assert.False(t, strings.HasPrefix(flag, "--host"), "unexpected flag %s", flag)
+	}
+
+	assert.Contains(t, BackupCommand("prefix", "ns-1", "/tmp/credentials", "/path", nil, nil, "", true, 0, false, 0, "cluster-1", false).ExtraFlags, "--host=cluster-1")
+}
+
+func TestBackupCommandForce(t *testing.T) {
+	assert.NotContains(t, BackupCommand("prefix", "ns-1", "/tmp/credentials", "/path", nil, nil, "", true, 0, false, 0, "", false).ExtraFlags, "--force")
+	assert.Contains(t, BackupCommand("prefix", "ns-1", "/tmp/credentials", "/path", nil, nil, "", true, 0, false, 0, "", true).ExtraFlags, "--force")
+}
+
+func TestGetSnapshotCommand(t *testing.T) {
+	cmd := GetSnapshotCommand("prefix", "ns-1", "/tmp/credentials", map[string]string{PodVolumeBackupUIDTag: "pvb-uid"}, nil)
+	assert.Equal(t, []string{"--json", "--last", "--tag=pvb-uid=pvb-uid"}, cmd.ExtraFlags)
+	assert.Nil(t, cmd.SnapshotGroup)
+
+	// snapshots are grouped by the group's fields that are set
+	group := &SnapshotGroupKey{Paths: []string{"/data"}}
+	cmd = GetSnapshotCommand("prefix", "ns-1", "/tmp/credentials", map[string]string{PodVolumeBackupUIDTag: "pvb-uid"}, group)
+	assert.Equal(t, []string{"--json", "--last", "--tag=pvb-uid=pvb-uid", "--group-by=paths"}, cmd.ExtraFlags)
+	assert.Equal(t, group, cmd.SnapshotGroup)
+
+	group = &SnapshotGroupKey{Hostname: "cluster-1", Paths: []string{"/data"}}
+	cmd = GetSnapshotCommand("prefix", "ns-1", "/tmp/credentials", map[string]string{PodVolumeBackupUIDTag: "pvb-uid"}, group)
+	assert.Equal(t, []string{"--json", "--last", "--tag=pvb-uid=pvb-uid", "--group-by=host,paths"}, cmd.ExtraFlags)
+
+	// an empty group doesn't group snapshots
+	cmd = GetSnapshotCommand("prefix", "ns-1", "/tmp/credentials", map[string]string{PodVolumeBackupUIDTag: "pvb-uid"}, &SnapshotGroupKey{})
+	assert.Equal(t, []string{"--json", "--last", "--tag=pvb-uid=pvb-uid"}, cmd.ExtraFlags)
+	assert.Nil(t, cmd.SnapshotGroup)
+}
+
+func TestListSnapshotsCommand(t *testing.T) {
+	cmd := ListSnapshotsCommand("prefix", "ns-1", "/tmp/credentials", nil, "")
+	assert.Equal(t, "snapshots", cmd.Command)
+	assert.Equal(t, []string{"--json"}, cmd.ExtraFlags)
+
+	cmd = ListSnapshotsCommand("prefix", "ns-1", "/tmp/credentials", map[string]string{"pod": "pod-1"}, "")
+	assert.Equal(t, []string{"--json", "--tag=pod=pod-1"}, cmd.ExtraFlags)
+
+	cmd = ListSnapshotsCommand("prefix", "ns-1", "/tmp/credentials", map[string]string{"pod": "pod-1"}, "host,paths")
+	assert.Equal(t, []string{"--json", "--tag=pod=pod-1", "--group-by=host,paths"}, cmd.ExtraFlags)
+
+	// a policy's snapshots can be listed
+	cmd = ListSnapshotsCommand("prefix", "ns-1", "/tmp/credentials", WithPolicyTag(nil, "weekly"), "")
+	assert.Equal(t, []string{"--json", "--tag=policy=weekly"}, cmd.ExtraFlags)
+}
+
+func TestPolicyTagPlumbing(t *testing.T) {
+	tags := WithPolicyTag(map[string]string{PodVolumeBackupUIDTag: "pvb-uid"}, "weekly")
+
+	// the backup tags the snapshot with its policy, as a separate tag
+	backupCmd := BackupCommand("prefix", "ns-1", "/tmp/credentials", "/path", tags, nil, "", true, 0, false, 0, "", false)
+	assert.Contains(t, backupCmd.ExtraFlags, "--tag=policy=weekly")
+	assert.Contains(t, backupCmd.ExtraFlags, "--tag=pvb-uid=pvb-uid")
+
+	// looking up the snapshot's ID requires it to have both tags
+	getCmd := GetSnapshotCommand("prefix", "ns-1", "/tmp/credentials", tags, nil)
+	require.Len(t, getCmd.ExtraFlags, 3)
+	filter := strings.Split(strings.TrimPrefix(getCmd.ExtraFlags[2], "--tag="), ",")
+	sort.Strings(filter)
+	assert.Equal(t, []string{"policy=weekly", "pvb-uid=pvb-uid"}, filter)
+}
+
+func TestSnapshotsByIDCommand(t *testing.T) {
+	cmd := SnapshotsByIDCommand("prefix", "ns-1", "/tmp/credentials", []string{"abcd1234", "ef567890"})
+	assert.Equal(t, "snapshots", cmd.Command)
+	assert.Equal(t, []string{"abcd1234", "ef567890"}, cmd.Args)
+	assert.Equal(t, []string{"--json"}, cmd.ExtraFlags)
+}
+
+func TestBlockBackupCommand(t *testing.T) {
+	cmd := BlockBackupCommand("prefix", "ns-1", "/tmp/credentials", "/host_pods/pod-uid/volumeDevices/kubernetes.io~csi/pv-1", "data.img", map[string]string{"volume": "data"}, true, 100, "node-1")
+	assert.Equal(t, "backup", cmd.Command)
+	assert.Equal(t, []string{"cat", "/host_pods/pod-uid/volumeDevices/kubernetes.io~csi/pv-1"}, cmd.Args)
+	assert.Equal(t, []string{"--tag=volume=data", "--json", "--limit-upload=100", "--host=node-1", "--stdin-from-command", "--stdin-filename=data.img"}, cmd.ExtraFlags)
+}
+
+func TestBackupCommandExcludes(t *testing.T) {
+	tests := []struct {
+		name     string
+		excludes []string
+		expected []string
+	}{
+		{
+			name: "no excludes",
+		},
+		{
+			name:     "multiple excludes",
+			excludes: []string{"/data/cache", "*.tmp"},
+			expected: []string{"--exclude=/data/cache", "--exclude=*.tmp"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var excludeFlags []string
+			for _, flag := range BackupCommand("prefix", "ns-1", "/tmp/credentials", "/path", nil, test.excludes, "", false, 0, false, 0, "", false).ExtraFlags {
+				if strings.HasPrefix(flag, "--exclude") {
+					excludeFlags = append(excludeFlags, flag)
+				}
+			}
+
+			assert.Equal(t, test.expected, excludeFlags)
+		})
+	}
+}
+
+func TestBackupCommandExcludeLargerThan(t *testing.T) {
+	for _, flag := range BackupCommand("prefix", "ns-1", "/tmp/credentials", "/path", nil, nil, "", true, 0, false, 0, "", false).ExtraFlags {
+		assert.False(t, strings.HasPrefix(flag, "--exclude-larger-than"), "unexpected flag %s", flag)
+	}
+
+	assert.Contains(t, BackupCommand("prefix", "ns-1", "/tmp/credentials", "/path", nil, nil, "500M", true, 0, false, 0, "", false).ExtraFlags, "--exclude-larger-than=500M")
+}
+
+func TestValidateExcludeLargerThan(t *testing.T) {
+	for _, size := range []string{"1048576", "500k", "500M", "2g", "1T"} {
+		assert.NoError(t, ValidateExcludeLargerThan(size), size)
+	}
+
+	tests := []struct {
+		size     string
+		expected string
+	}{
+		{size: "", expected: `size "" must be a number of bytes, optionally followed by one of the suffixes k, m, g or t`},
+		{size: "500MB", expected: `size "500MB" must be a number of bytes, optionally followed by one of the suffixes k, m, g or t`},
+		{size: "1.5G", expected: `size "1.5G" must be a number of bytes, optionally followed by one of the suffixes k, m, g or t`},
+		{size: "-1", expected: `size "-1" must be a number of bytes, optionally followed by one of the suffixes k, m, g or t`},
+		{size: "M", expected: `size "M" must be a number of bytes, optionally followed by one of the suffixes k, m, g or t`},
+		{size: "00k", expected: `size "00k" must be greater than zero`},
+	}
+
+	for _, test := range tests {
+		assert.EqualError(t, ValidateExcludeLargerThan(test.size), test.expected, test.size)
+	}
+}
+
+func TestValidateExcludePatterns(t *testing.T) {
+	assert.NoError(t, ValidateExcludePatterns(nil))
+	assert.NoError(t, ValidateExcludePatterns([]string{"/data/cache", "*.tmp"}))
+	assert.EqualError(t, ValidateExcludePatterns([]string{"*.tmp", ""}), "exclude pattern 1 is empty")
+	assert.EqualError(t, ValidateExcludePatterns([]string{" "}), "exclude pattern 0 is empty")
+	assert.EqualError(t, ValidateExcludePatterns([]string{strings.Repeat("a", 1025)}), "exclude pattern 0 is longer than 1024 characters")
+}
+
+func TestRestoreCommand(t *testing.T) {
+	assert.Equal(t,
+		[]string{"/restic", "restore", "--repo=s3:s3.amazonaws.com/bucket/ns-1", "--password-file=/tmp/credentials", "snapshot-1", "--target=/restores/pod-uid"},
+		RestoreCommand("s3:s3.amazonaws.com/bucket", "ns-1", "/tmp/credentials", "pod-uid", "snapshot-1", nil).StringSlice(),
+	)
+	assert.Equal(t,
+		[]string{"/restic", "restore", "--repo=s3:s3.amazonaws.com/bucket/ns-1", "--password-file=/tmp/credentials", "snapshot-1", "--target=/restores/pod-uid", "--include=vol-1/data/db", "--include=vol-1/config.yaml"},
+		RestoreCommand("s3:s3.amazonaws.com/bucket", "ns-1", "/tmp/credentials", "pod-uid", "snapshot-1", []string{"vol-1/data/db", "vol-1/config.yaml"}).StringSlice(),
+	)
+}
+
+func TestRestoreIncludePatterns(t *testing.T) {
+	tests := []struct {
+		name      string
+		paths     []string
+		expected  []string
+		expectErr string
+	}{
+		{
+			name: "no paths",
+		},
+		{
+			name:     "relative paths",
+			paths:    []string{"data/db", "config.yaml"},
+			expected: []string{"vol-1/data/db", "vol-1/config.yaml"},
+		},
+		{
+			name:     "paths are cleaned",
+			paths:    []string{"/data/db/", "./logs//app.log"},
+			expected: []string{"vol-1/data/db", "vol-1/logs/app.log"},
+		},
+		{
+			name:      "empty path",
+			paths:     []string{"data", " "},
+			expectErr: "include path 1 is empty",
+		},
+		{
+			name:      "volume root",
+			paths:     []string{"/"},
+			expectErr: "include path 0 is empty",
+		},
+		{
+			name:      "path outside of the volume",
+			paths:     []string{"../vol-2/data"},
+			expectErr: "include path 0 (../vol-2/data) must not contain '..'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patterns, err := RestoreIncludePatterns("vol-1", test.paths)
+			if test.expectErr != "" {
+				assert.EqualError(t, err, test.expectErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, patterns)
+		})
+	}
+}
+
+func TestCleanSubPath(t *testing.T) {
+	tests := []struct {
+		name      string
+		subPath   string
+		expected  string
+		expectErr string
+	}{
+		{
+			name:     "relative path",
+			subPath:  "data/app-1",
+			expected: "data/app-1",
+		},
+		{
+			name:     "path is cleaned",
+			subPath:  "/data//app-1/./",
+			expected: "data/app-1",
+		},
+		{
+			name:      "empty path",
+			subPath:   " ",
+			expectErr: "subPath is empty",
+		},
+		{
+			name:      "volume root",
+			subPath:   "./",
+			expectErr: "subPath is empty",
+		},
+		{
+			name:      "path outside of the volume",
+			subPath:   "data/../../vol-2",
+			expectErr: "subPath data/../../vol-2 must not contain '..'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subPath, err := CleanSubPath(test.subPath)
+			if test.expectErr != "" {
+				assert.EqualError(t, err, test.expectErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, subPath)
+		})
+	}
+}
+
+func TestUnlockCommand(t *testing.T) {
+	assert.Equal(t,
+		[]string{"/restic", "unlock", "--repo=s3:s3.amazonaws.com/bucket/ns-1", "--password-file=/tmp/credentials"},
+		UnlockCommand("s3:s3.amazonaws.com/bucket", "ns-1", "/tmp/credentials", false).StringSlice(),
+	)
+	assert.Equal(t,
+		[]string{"/restic", "unlock", "--repo=s3:s3.amazonaws.com/bucket/ns-1", "--password-file=/tmp/credentials", "--remove-all"},
+		UnlockCommand("s3:s3.amazonaws.com/bucket", "ns-1", "/tmp/credentials", true).StringSlice(),
+	)
+}
+
+func TestPruneCommand(t *testing.T) {
+	cmd := PruneCommand("s3:s3.amazonaws.com/bucket", "ns-1")
+	cmd.PasswordFile = "/tmp/credentials"
+
+	assert.Equal(t,
+		[]string{"/restic", "prune", "--repo=s3:s3.amazonaws.com/bucket/ns-1", "--password-file=/tmp/credentials"},
+		cmd.StringSlice(),
+	)
+}
+
+func TestVerifyCommand(t *testing.T) {
+	tests := []struct {
+		name            string
+		readDataPercent int
+		expectedFlags   []string
+	}{
+		{
+			name:            "structure only",
+			readDataPercent: 0,
+		},
+		{
+			name:            "data subset",
+			readDataPercent: 10,
+			expectedFlags:   []string{"--read-data-subset=10%"},
+		},
+		{
+			name:            "all data",
+			readDataPercent: 100,
+			expectedFlags:   []string{"--read-data"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expected := append([]string{"/restic", "check", "--repo=s3:s3.amazonaws.com/bucket/ns-1", "--password-file=/tmp/credentials", "--no-lock"}, test.expectedFlags...)
+			assert.Equal(t, expected, VerifyCommand("s3:s3.amazonaws.com/bucket", "ns-1", "/tmp/credentials", test.readDataPercent).StringSlice())
+		})
+	}
+}
@@ -19,12 +19,15 @@ package restic
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
 	corev1listers "k8s.io/client-go/listers/core/v1"
 
 	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
@@ -36,6 +39,42 @@ const (
 
 	podAnnotationPrefix       = "snapshot.ark.heptio.com/"
 	volumesToBackupAnnotation = "backup.ark.heptio.com/backup-volumes"
+
+	// VolumesToExcludeAnnotation is the pod annotation listing, comma-separated,
+	// the names of the pod's volumes that must never be backed up with restic.
+	VolumesToExcludeAnnotation = "backup.ark.heptio.com/volumes-to-exclude"
+
+	// BackupPriorityAnnotation is the pod annotation whose integer value is
+	// the priority of the pod's volume backups. It's copied to the pod's
+	// PodVolumeBackups, and those with higher priorities are processed first
+	// by the restic server on the pod's node.
+	BackupPriorityAnnotation = "backup.ark.heptio.com/backup-priority"
+
+	// ForceRescanAnnotation is the pod annotation that, when set to "true",
+	// makes restic re-read all of the files in the pod's volumes when they're
+	// backed up, rather than skipping those unchanged since the parent
+	// snapshot, e.g. after the repository is suspected to be corrupt. It
+	// sets spec.force on the pod's PodVolumeBackups.
+	ForceRescanAnnotation = "backup.ark.heptio.com/restic-force-rescan"
+
+	// PodVolumeBackupUIDTag is the snapshot tag whose value is the UID of
+	// the PodVolumeBackup that created the snapshot. Since it's unique to
+	// each PodVolumeBackup, filtering on it finds that backup's snapshot
+	// even if other backups of the same volume are running concurrently.
+	PodVolumeBackupUIDTag = "pvb-uid"
+
+	// PolicyTag is the snapshot tag whose value is the policy, e.g. daily
+	// or weekly, of the PodVolumeBackup that created the snapshot, so that
+	// snapshots taken under different policies can be looked up, and have
+	// retention applied, separately.
+	PolicyTag = "policy"
+
+	// BackupsPausedAnnotation is the node annotation that, when set to
+	// "true", stops the restic server on the node from starting new pod
+	// volume backups, e.g. during node maintenance. Backups that are
+	// already running continue, and those requested while backups are
+	// paused are started once the annotation is removed.
+	BackupsPausedAnnotation = "backup.ark.heptio.com/restic-backups-paused"
 )
 
 // PodHasSnapshotAnnotation returns true if the object has an annotation
@@ -84,19 +123,67 @@ func SetPodSnapshotAnnotation(obj metav1.Object, volumeName, snapshotID string)
 }
 
 // GetVolumesToBackup returns a list of volume names to backup for
-// the provided pod.
+// the provided pod. Volumes listed in the pod's volumes-to-exclude
+// annotation are not included.
 func GetVolumesToBackup(obj metav1.Object) []string {
-	annotations := obj.GetAnnotations()
-	if annotations == nil {
+	volumes := annotationList(obj, volumesToBackupAnnotation)
+	if len(volumes) == 0 {
 		return nil
 	}
 
-	backupsValue := annotations[volumesToBackupAnnotation]
-	if backupsValue == "" {
+	excluded := GetVolumesToExclude(obj)
+	if len(excluded) == 0 {
+		return volumes
+	}
+
+	var res []string
+	for _, volume := range volumes {
+		if !excluded.Has(volume) {
+			res = append(res, volume)
+		}
+	}
+
+	return res
+}
+
+// GetVolumesToExclude returns the set of volume names listed in the
+// provided pod's volumes-to-exclude annotation.
+func GetVolumesToExclude(obj metav1.Object) sets.String {
+	return sets.NewString(annotationList(obj, VolumesToExcludeAnnotation)...)
+}
+
+// GetBackupPriority returns the priority in the provided object's
+// backup-priority annotation, or 0 if it's not set.
+func GetBackupPriority(obj metav1.Object) (int, error) {
+	value := obj.GetAnnotations()[BackupPriorityAnnotation]
+	if value == "" {
+		return 0, nil
+	}
+
+	priority, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid %s annotation %q", BackupPriorityAnnotation, value)
+	}
+
+	return priority, nil
+}
+
+// BackupsPaused returns true if the provided node's restic-backups-paused
+// annotation is set to true.
+func BackupsPaused(node metav1.Object) bool {
+	paused, err := strconv.ParseBool(node.GetAnnotations()[BackupsPausedAnnotation])
+	return err == nil && paused
+}
+
+// annotationList returns the comma-separated values of the specified
+// annotation, or nil if it's not set.
+func annotationList(obj metav1.Object, annotation string) []string {
+	value := obj.GetAnnotations()[annotation]
+	if value == "" {
 		return nil
 	}
 
-	return strings.Split(backupsValue, ",")
+	return strings.Split(value, ",")
 }
 
 // SnapshotIdentifier uniquely identifies a restic snapshot
@@ -137,18 +224,83 @@ func GetSnapshotsInBackup(backup *arkv1api.Backup, podVolumeBackupLister arkv1li
 	return res, nil
 }
 
+// GetPodVolumeBackupSummary returns the number of PodVolumeBackups, by
+// phase, associated with a given Ark backup.
+func GetPodVolumeBackupSummary(backup *arkv1api.Backup, podVolumeBackupLister arkv1listers.PodVolumeBackupLister) (arkv1api.PodVolumeBackupSummary, error) {
+	selector, err := labels.Parse(fmt.Sprintf("%s=%s", arkv1api.BackupNameLabel, backup.Name))
+	if err != nil {
+		return arkv1api.PodVolumeBackupSummary{}, errors.WithStack(err)
+	}
+
+	podVolumeBackups, err := podVolumeBackupLister.List(selector)
+	if err != nil {
+		return arkv1api.PodVolumeBackupSummary{}, errors.WithStack(err)
+	}
+
+	return SummarizePodVolumeBackups(podVolumeBackups), nil
+}
+
+// SummarizePodVolumeBackups counts the provided PodVolumeBackups by phase.
+func SummarizePodVolumeBackups(podVolumeBackups []*arkv1api.PodVolumeBackup) arkv1api.PodVolumeBackupSummary {
+	summary := arkv1api.PodVolumeBackupSummary{Total: len(podVolumeBackups)}
+
+	for _, item := range podVolumeBackups {
+		switch item.Status.Phase {
+		case "", arkv1api.PodVolumeBackupPhaseNew:
+			summary.New++
+		case arkv1api.PodVolumeBackupPhaseInProgress, arkv1api.PodVolumeBackupPhaseCanceling:
+			summary.InProgress++
+		case arkv1api.PodVolumeBackupPhaseCompleted, arkv1api.PodVolumeBackupPhaseCompletedDryRun:
+			summary.Completed++
+		case arkv1api.PodVolumeBackupPhaseFailed:
+			summary.Failed++
+		case arkv1api.PodVolumeBackupPhaseCanceled:
+			summary.Canceled++
+		}
+	}
+
+	return summary
+}
+
 // TempCredentialsFile creates a temp file containing a restic
 // encryption key for the given repo and returns its path. The
-// caller should generally call os.Remove() to remove the file
-// when done with it.
-func TempCredentialsFile(secretLister corev1listers.SecretLister, repoName string) (string, error) {
+// file is created in dir, or the default temp directory if dir
+// is empty. The caller should generally call os.Remove() to
+// remove the file when done with it.
+func TempCredentialsFile(secretLister corev1listers.SecretLister, repoName, dir string) (string, error) {
 	secretGetter := NewListerSecretGetter(secretLister)
 	repoKey, err := GetRepositoryKey(secretGetter, repoName)
 	if err != nil {
 		return "", err
 	}
 
-	file, err := ioutil.TempFile("", fmt.Sprintf("%s-%s", CredentialsSecretName, repoName))
+	return writeTempCredentialsFile(dir, fmt.Sprintf("%s-%s", CredentialsSecretName, repoName), repoKey)
+}
+
+// TempSecretCredentialsFile creates a temp file containing the restic
+// repository password from the named secret, as described by
+// GetSecretCredentials, and returns its path and the secret's
+// environment variables. The file is created in dir, or the default temp
+// directory if dir is empty. The caller should generally call os.Remove()
+// to remove the file when done with it.
+func TempSecretCredentialsFile(secretLister corev1listers.SecretLister, namespace, name, dir string) (string, []string, error) {
+	repoKey, env, err := GetSecretCredentials(NewListerSecretGetter(secretLister), namespace, name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	file, err := writeTempCredentialsFile(dir, fmt.Sprintf("%s-%s", namespace, name), repoKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return file, env, nil
+}
+
+// writeTempCredentialsFile writes repoKey to a new temp file, whose name
+// starts with prefix, in dir, and returns its path.
+func writeTempCredentialsFile(dir, prefix string, repoKey []byte) (string, error) {
+	file, err := ioutil.TempFile(dir, prefix)
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
@@ -168,3 +320,23 @@ func TempCredentialsFile(secretLister corev1listers.SecretLister, repoName strin
 
 	return name, nil
 }
+
+// ValidateTempDir returns an error if files can't be created in the
+// provided temp directory. An empty dir, meaning the default temp
+// directory, is not checked.
+func ValidateTempDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	file, err := ioutil.TempFile(dir, "restic-temp-dir-check")
+	if err != nil {
+		return errors.Wrapf(err, "temp directory %s is not writable", dir)
+	}
+
+	// ignore errors since there's nothing we can do and it's a temp file.
+	file.Close()
+	os.Remove(file.Name())
+
+	return nil
+}
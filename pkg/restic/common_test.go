@@ -0,0 +1,257 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+	arkv1listers "github.com/heptio/ark/pkg/generated/listers/ark/v1"
+)
+
+func TestGetVolumesToBackup(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    []string
+	}{
+		{
+			name:     "no annotations",
+			expected: nil,
+		},
+		{
+			name:        "no volumes to back up",
+			annotations: map[string]string{"foo": "bar"},
+			expected:    nil,
+		},
+		{
+			name:        "volumes to back up",
+			annotations: map[string]string{volumesToBackupAnnotation: "data,logs,cache"},
+			expected:    []string{"data", "logs", "cache"},
+		},
+		{
+			name: "excluded volumes are not backed up",
+			annotations: map[string]string{
+				volumesToBackupAnnotation:  "data,logs,cache",
+				VolumesToExcludeAnnotation: "cache,logs",
+			},
+			expected: []string{"data"},
+		},
+		{
+			name: "excluding volumes that aren't backed up has no effect",
+			annotations: map[string]string{
+				volumesToBackupAnnotation:  "data",
+				VolumesToExcludeAnnotation: "scratch",
+			},
+			expected: []string{"data"},
+		},
+		{
+			name: "all volumes excluded",
+			annotations: map[string]string{
+				volumesToBackupAnnotation:  "data,logs",
+				VolumesToExcludeAnnotation: "logs,data",
+			},
+			expected: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &metav1.ObjectMeta{Annotations: test.annotations}
+			assert.Equal(t, test.expected, GetVolumesToBackup(pod))
+		})
+	}
+}
+
+func TestGetVolumesToExclude(t *testing.T) {
+	assert.Empty(t, GetVolumesToExclude(&metav1.ObjectMeta{}))
+
+	excluded := GetVolumesToExclude(&metav1.ObjectMeta{Annotations: map[string]string{VolumesToExcludeAnnotation: "cache,scratch"}})
+	assert.Equal(t, []string{"cache", "scratch"}, excluded.List())
+}
+
+func TestBackupsPaused(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    bool
+	}{
+		{
+			name:     "no annotation",
+			expected: false,
+		},
+		{
+			name:        "paused",
+			annotations: map[string]string{BackupsPausedAnnotation: "true"},
+			expected:    true,
+		},
+		{
+			name:        "not paused",
+			annotations: map[string]string{BackupsPausedAnnotation: "false"},
+			expected:    false,
+		},
+		{
+			name:        "invalid value",
+			annotations: map[string]string{BackupsPausedAnnotation: "yes please"},
+			expected:    false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, BackupsPaused(&metav1.ObjectMeta{Annotations: test.annotations}))
+		})
+	}
+}
+
+func TestGetBackupPriority(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    int
+		expectErr   bool
+	}{
+		{
+			name:     "no annotation",
+			expected: 0,
+		},
+		{
+			name:        "positive priority",
+			annotations: map[string]string{BackupPriorityAnnotation: "10"},
+			expected:    10,
+		},
+		{
+			name:        "negative priority",
+			annotations: map[string]string{BackupPriorityAnnotation: "-5"},
+			expected:    -5,
+		},
+		{
+			name:        "invalid priority",
+			annotations: map[string]string{BackupPriorityAnnotation: "high"},
+			expectErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			priority, err := GetBackupPriority(&metav1.ObjectMeta{Annotations: test.annotations})
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, priority)
+		})
+	}
+}
+
+func newTestPodVolumeBackup(name, backupName string, phase arkv1api.PodVolumeBackupPhase) *arkv1api.PodVolumeBackup {
+	return &arkv1api.PodVolumeBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: arkv1api.DefaultNamespace,
+			Name:      name,
+			Labels:    map[string]string{arkv1api.BackupNameLabel: backupName},
+		},
+		Status: arkv1api.PodVolumeBackupStatus{
+			Phase: phase,
+		},
+	}
+}
+
+func TestGetPodVolumeBackupSummary(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, pvb := range []*arkv1api.PodVolumeBackup{
+		newTestPodVolumeBackup("pvb-1", "backup-1", arkv1api.PodVolumeBackupPhaseCompleted),
+		newTestPodVolumeBackup("pvb-2", "backup-1", arkv1api.PodVolumeBackupPhaseCompleted),
+		newTestPodVolumeBackup("pvb-3", "backup-1", arkv1api.PodVolumeBackupPhaseCompletedDryRun),
+		newTestPodVolumeBackup("pvb-4", "backup-1", arkv1api.PodVolumeBackupPhaseFailed),
+		newTestPodVolumeBackup("pvb-5", "backup-1", arkv1api.PodVolumeBackupPhaseInProgress),
+		newTestPodVolumeBackup("pvb-6", "backup-1", arkv1api.PodVolumeBackupPhaseCanceling),
+		newTestPodVolumeBackup("pvb-7", "backup-1", arkv1api.PodVolumeBackupPhaseCanceled),
+		newTestPodVolumeBackup("pvb-8", "backup-1", arkv1api.PodVolumeBackupPhaseNew),
+		newTestPodVolumeBackup("pvb-9", "backup-1", ""),
+		newTestPodVolumeBackup("pvb-10", "backup-2", arkv1api.PodVolumeBackupPhaseFailed),
+	} {
+		require.NoError(t, indexer.Add(pvb))
+	}
+	lister := arkv1listers.NewPodVolumeBackupLister(indexer)
+
+	summary, err := GetPodVolumeBackupSummary(&arkv1api.Backup{ObjectMeta: metav1.ObjectMeta{Name: "backup-1"}}, lister)
+	require.NoError(t, err)
+	assert.Equal(t, arkv1api.PodVolumeBackupSummary{
+		Total:      9,
+		New:        2,
+		InProgress: 2,
+		Completed:  3,
+		Failed:     1,
+		Canceled:   1,
+	}, summary)
+
+	summary, err = GetPodVolumeBackupSummary(&arkv1api.Backup{ObjectMeta: metav1.ObjectMeta{Name: "backup-3"}}, lister)
+	require.NoError(t, err)
+	assert.Equal(t, arkv1api.PodVolumeBackupSummary{}, summary)
+}
+
+func TestTempCredentialsFile(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	require.NoError(t, indexer.Add(newCredentialsSecret("ns-1", "key-1")))
+	secretLister := corev1listers.NewSecretLister(indexer)
+
+	dir, err := ioutil.TempDir("", "restic-temp-dir")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// the file is created in the provided directory
+	file, err := TempCredentialsFile(secretLister, "ns-1", dir)
+	require.NoError(t, err)
+	assert.Equal(t, dir, filepath.Dir(file))
+	assert.Equal(t, "key-1", readFile(t, file))
+
+	// or the default temp directory
+	file, err = TempCredentialsFile(secretLister, "ns-1", "")
+	require.NoError(t, err)
+	defer os.Remove(file)
+	assert.Equal(t, filepath.Clean(os.TempDir()), filepath.Dir(file))
+
+	_, err = TempCredentialsFile(secretLister, "ns-1", filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestValidateTempDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restic-temp-dir")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, ValidateTempDir(""))
+	assert.NoError(t, ValidateTempDir(dir))
+
+	// the check doesn't leave any files behind
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	assert.Error(t, ValidateTempDir(filepath.Join(dir, "missing")))
+}
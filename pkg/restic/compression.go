@@ -0,0 +1,52 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CompressionLevels are the compression levels supported by restic.
+var CompressionLevels = []string{"off", "auto", "max"}
+
+// minCompressionVersion is the first restic version that supports
+// compressed (version 2) repositories.
+var minCompressionVersion = Version{0, 14, 0}
+
+// ValidateCompression returns an error if level is not empty and not one
+// of CompressionLevels.
+func ValidateCompression(level string) error {
+	if level == "" {
+		return nil
+	}
+
+	for _, l := range CompressionLevels {
+		if level == l {
+			return nil
+		}
+	}
+
+	return errors.Errorf("unsupported compression level %q, must be one of %s", level, strings.Join(CompressionLevels, ", "))
+}
+
+// SupportsCompression returns true if the restic version, as output by
+// 'restic version', supports compression.
+func SupportsCompression(version string) (bool, error) {
+	return versionAtLeast(version, minCompressionVersion)
+}
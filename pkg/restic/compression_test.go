@@ -0,0 +1,61 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateCompression(t *testing.T) {
+	for _, level := range []string{"", "off", "auto", "max"} {
+		assert.NoError(t, ValidateCompression(level))
+	}
+	assert.EqualError(t, ValidateCompression("fast"), `unsupported compression level "fast", must be one of off, auto, max`)
+}
+
+func TestSupportsCompression(t *testing.T) {
+	tests := []struct {
+		version     string
+		expected    bool
+		expectedErr bool
+	}{
+		{version: "restic 0.9.1 compiled with go1.10.3 on linux/amd64", expected: false},
+		{version: "restic 0.13.1 compiled with go1.18 on linux/amd64", expected: false},
+		{version: "restic 0.14.0 compiled with go1.19 on linux/amd64", expected: true},
+		{version: "restic 0.16.4 compiled with go1.21.6 on linux/amd64", expected: true},
+		{version: "restic 1.0.0 compiled with go1.22 on linux/amd64", expected: true},
+		{version: "restic 0.14.0-dev (compiled manually) compiled with go1.19 on linux/amd64", expected: true},
+		{version: "restic 0.13.0-dev (compiled manually) compiled with go1.18 on linux/amd64", expected: false},
+		{version: "not restic", expectedErr: true},
+		{version: "", expectedErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.version, func(t *testing.T) {
+			supported, err := SupportsCompression(test.version)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, supported)
+		})
+	}
+}
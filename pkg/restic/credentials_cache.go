@@ -0,0 +1,166 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"os"
+	"sync"
+
+	corev1listers "k8s.io/client-go/listers/core/v1"
+)
+
+// CredentialsFileCache keeps one temp restic credentials file per
+// repository (namespace), so that backing up many pods in the same
+// namespace doesn't read the secret and write a new file each time.
+// It also keeps one per explicitly referenced credentials secret, along
+// with the secret's environment variables. Entries must be invalidated
+// when the credentials secret changes.
+type CredentialsFileCache struct {
+	secretLister corev1listers.SecretLister
+	dir          string
+
+	// Owner, if set, is made the owner of the credentials files, so that
+	// restic can read them when it's run as another user.
+	Owner *RunAs
+
+	mu    sync.Mutex
+	files map[string]string
+	env   map[string][]string
+
+	// createFunc and createFromSecretFunc are used to create credentials
+	// files. They're fields so they can be replaced in tests.
+	createFunc           func(secretLister corev1listers.SecretLister, repoName, dir string) (string, error)
+	createFromSecretFunc func(secretLister corev1listers.SecretLister, namespace, name, dir string) (string, []string, error)
+}
+
+// NewCredentialsFileCache returns an empty CredentialsFileCache that
+// reads credentials secrets from the given lister and creates
+// credentials files in dir, or the default temp directory if it's empty.
+func NewCredentialsFileCache(secretLister corev1listers.SecretLister, dir string) *CredentialsFileCache {
+	return &CredentialsFileCache{
+		secretLister:         secretLister,
+		dir:                  dir,
+		files:                make(map[string]string),
+		env:                  make(map[string][]string),
+		createFunc:           TempCredentialsFile,
+		createFromSecretFunc: TempSecretCredentialsFile,
+	}
+}
+
+// Get returns the path to the credentials file for the given repo,
+// creating it if it isn't already cached. Callers must not remove
+// the returned file.
+func (c *CredentialsFileCache) Get(repoName string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if file, ok := c.files[repoName]; ok {
+		return file, nil
+	}
+
+	file, err := c.createFunc(c.secretLister, repoName, c.dir)
+	if err != nil {
+		return "", err
+	}
+	if err := c.Owner.Chown(file); err != nil {
+		os.Remove(file)
+		return "", err
+	}
+	c.files[repoName] = file
+
+	return file, nil
+}
+
+// GetForSecret returns the path to the credentials file for the named
+// secret, as described by GetSecretCredentials, creating it if it isn't
+// already cached. The secret's environment variables are returned by Env.
+// Callers must not remove the returned file.
+func (c *CredentialsFileCache) GetForSecret(namespace, name string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := secretCacheKey(namespace, name)
+	if file, ok := c.files[key]; ok {
+		return file, nil
+	}
+
+	file, env, err := c.createFromSecretFunc(c.secretLister, namespace, name, c.dir)
+	if err != nil {
+		return "", err
+	}
+	if err := c.Owner.Chown(file); err != nil {
+		os.Remove(file)
+		return "", err
+	}
+	c.files[key] = file
+	c.env[file] = env
+
+	return file, nil
+}
+
+// Env returns the environment variables of the secret that the cached
+// credentials file at path was created from by GetForSecret, and false if
+// it wasn't created from an explicitly referenced secret.
+func (c *CredentialsFileCache) Env(path string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	env, ok := c.env[path]
+	return env, ok
+}
+
+// Invalidate removes the cached credentials file for the given repo,
+// if any, so the next call to Get re-reads the secret. restic reads
+// the password file when it starts, so removing the file doesn't
+// affect commands that are already running.
+func (c *CredentialsFileCache) Invalidate(repoName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if file, ok := c.files[repoName]; ok {
+		// ignore error since there's nothing we can do and it's a temp file.
+		os.Remove(file)
+		delete(c.files, repoName)
+		delete(c.env, file)
+	}
+}
+
+// InvalidateSecret removes the cached credentials file for the named
+// secret, if any, so the next call to GetForSecret re-reads it.
+func (c *CredentialsFileCache) InvalidateSecret(namespace, name string) {
+	c.Invalidate(secretCacheKey(namespace, name))
+}
+
+// Clear removes all cached credentials files.
+func (c *CredentialsFileCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for repoName, file := range c.files {
+		// ignore error since there's nothing we can do and it's a temp file.
+		os.Remove(file)
+		delete(c.files, repoName)
+		delete(c.env, file)
+	}
+}
+
+// secretCacheKey returns the key of the credentials file for the named
+// secret. Namespace names can't contain slashes, so it can't collide with
+// a repository's key.
+func secretCacheKey(namespace, name string) string {
+	return namespace + "/" + name
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newCredentialsSecret(namespace, key string) *corev1api.Secret {
+	return &corev1api.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      CredentialsSecretName,
+		},
+		Data: map[string][]byte{
+			CredentialsKey: []byte(key),
+		},
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestCredentialsFileCache(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	require.NoError(t, indexer.Add(newCredentialsSecret("ns-1", "key-1")))
+	require.NoError(t, indexer.Add(newCredentialsSecret("ns-2", "key-2")))
+
+	c := NewCredentialsFileCache(corev1listers.NewSecretLister(indexer), "")
+	defer c.Clear()
+
+	var created []string
+	c.createFunc = func(secretLister corev1listers.SecretLister, repoName, dir string) (string, error) {
+		created = append(created, repoName)
+		return TempCredentialsFile(secretLister, repoName, dir)
+	}
+
+	// a miss creates the file
+	ns1File, err := c.Get("ns-1")
+	require.NoError(t, err)
+	assert.Equal(t, "key-1", readFile(t, ns1File))
+	assert.Equal(t, []string{"ns-1"}, created)
+
+	// a hit reuses it
+	file, err := c.Get("ns-1")
+	require.NoError(t, err)
+	assert.Equal(t, ns1File, file)
+	assert.Equal(t, []string{"ns-1"}, created)
+
+	// other namespaces get their own file
+	ns2File, err := c.Get("ns-2")
+	require.NoError(t, err)
+	assert.NotEqual(t, ns1File, ns2File)
+	assert.Equal(t, "key-2", readFile(t, ns2File))
+	assert.Equal(t, []string{"ns-1", "ns-2"}, created)
+
+	// invalidating removes the file, and the next Get picks up the
+	// updated secret
+	require.NoError(t, indexer.Update(newCredentialsSecret("ns-1", "key-1-updated")))
+	c.Invalidate("ns-1")
+
+	_, err = os.Stat(ns1File)
+	assert.True(t, os.IsNotExist(err))
+
+	file, err = c.Get("ns-1")
+	require.NoError(t, err)
+	assert.Equal(t, "key-1-updated", readFile(t, file))
+	assert.Equal(t, []string{"ns-1", "ns-2", "ns-1"}, created)
+
+	// invalidating an uncached namespace is a no-op
+	c.Invalidate("ns-3")
+
+	// clearing removes all files
+	c.Clear()
+	for _, f := range []string{file, ns2File} {
+		_, err = os.Stat(f)
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestCredentialsFileCacheMissingSecret(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	c := NewCredentialsFileCache(corev1listers.NewSecretLister(indexer), "")
+
+	_, err := c.Get("ns-1")
+	assert.Error(t, err)
+	assert.Empty(t, c.files)
+}
+
+func TestCredentialsFileCacheSecret(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	require.NoError(t, indexer.Add(newCredentialsSecret("ns-1", "key-1")))
+
+	secret := &corev1api.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "other-store",
+		},
+		Data: map[string][]byte{
+			CredentialsKey:          []byte("other-key"),
+			"AWS_SECRET_ACCESS_KEY": []byte("secret"),
+			"AWS_ACCESS_KEY_ID":     []byte("id"),
+		},
+	}
+	require.NoError(t, indexer.Add(secret))
+
+	c := NewCredentialsFileCache(corev1listers.NewSecretLister(indexer), "")
+	defer c.Clear()
+
+	var created int
+	c.createFromSecretFunc = func(secretLister corev1listers.SecretLister, namespace, name, dir string) (string, []string, error) {
+		created++
+		return TempSecretCredentialsFile(secretLister, namespace, name, dir)
+	}
+
+	// the secret's password is written to its own file, separate from
+	// the namespace's, and its other keys are its environment variables.
+	secretFile, err := c.GetForSecret("ns-1", "other-store")
+	require.NoError(t, err)
+	assert.Equal(t, "other-key", readFile(t, secretFile))
+	env, ok := c.Env(secretFile)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"AWS_ACCESS_KEY_ID=id", "AWS_SECRET_ACCESS_KEY=secret"}, env)
+
+	file, err := c.GetForSecret("ns-1", "other-store")
+	require.NoError(t, err)
+	assert.Equal(t, secretFile, file)
+	assert.Equal(t, 1, created)
+
+	nsFile, err := c.Get("ns-1")
+	require.NoError(t, err)
+	assert.NotEqual(t, secretFile, nsFile)
+	_, ok = c.Env(nsFile)
+	assert.False(t, ok)
+
+	// invalidating the secret removes its file and environment.
+	c.InvalidateSecret("ns-1", "other-store")
+	_, err = os.Stat(secretFile)
+	assert.True(t, os.IsNotExist(err))
+	_, ok = c.Env(secretFile)
+	assert.False(t, ok)
+
+	_, err = c.GetForSecret("ns-1", "other-store")
+	require.NoError(t, err)
+	assert.Equal(t, 2, created)
+
+	// a secret without a password is an error.
+	require.NoError(t, indexer.Add(&corev1api.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "no-password"},
+		Data:       map[string][]byte{"AWS_ACCESS_KEY_ID": []byte("id")},
+	}))
+	_, err = c.GetForSecret("ns-1", "no-password")
+	assert.Error(t, err)
+	_, err = c.GetForSecret("ns-1", "missing")
+	assert.Error(t, err)
+}
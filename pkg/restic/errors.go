@@ -0,0 +1,278 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// The categories of restic command failure. Use ErrorKind to get the
+// category of an error returned by NewError.
+var (
+	// ErrRepoNotFound means the repository has not been initialized.
+	ErrRepoNotFound = errors.New("restic repository does not exist")
+
+	// ErrRepoLocked means restic could not lock the repository.
+	ErrRepoLocked = errors.New("restic repository is locked")
+
+	// ErrAuth means the repository password was wrong.
+	ErrAuth = errors.New("wrong restic repository password")
+
+	// ErrPermissionDenied means restic was denied access to files or
+	// storage.
+	ErrPermissionDenied = errors.New("restic was denied access")
+
+	// ErrNetwork means restic could not reach the repository's storage.
+	ErrNetwork = errors.New("restic network error")
+
+	// ErrImmutableStorage means the repository's storage refused to delete
+	// or overwrite data because it's immutable, e.g. an S3 bucket with
+	// Object Lock or a storage container with an immutability policy.
+	ErrImmutableStorage = errors.New("restic repository storage is immutable")
+
+	// ErrIncompleteSnapshot means restic created a snapshot, but could not
+	// read all of the files to back up.
+	ErrIncompleteSnapshot = errors.New("restic snapshot is incomplete")
+
+	// ErrRepoExists means restic could not initialize the repository
+	// because it has already been initialized.
+	ErrRepoExists = errors.New("restic repository already exists")
+)
+
+// ExitCodeIncompleteSnapshot is the exit code of a restic backup that
+// created a snapshot, but couldn't read all of the files to back up.
+const ExitCodeIncompleteSnapshot = 3
+
+// Exit codes that restic uses to report specific failures. Versions of
+// restic older than 0.17 exit with 1 for all of these, so stderr is also
+// checked.
+const (
+	exitCodeRepoNotFound = 10
+	exitCodeRepoLocked   = 11
+	exitCodeAuth         = 12
+)
+
+// Error is a failed restic command, along with its category of failure.
+type Error struct {
+	// Kind is the category of failure, i.e. one of the Err* values in
+	// this package, or nil if it's not recognized.
+	Kind error
+
+	// ExitCode is the command's exit code, or -1 if it didn't exit, e.g.
+	// because it couldn't be started.
+	ExitCode int
+
+	// Stderr is the command's stderr output.
+	Stderr string
+
+	// Err is the error returned from running the command.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("stderr=%s: %s", e.Stderr, e.Err)
+}
+
+// NewError returns an *Error for a restic command that returned err and
+// wrote stderr, categorizing it by its exit code and stderr output.
+func NewError(err error, stderr string) *Error {
+	exitCode := -1
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			exitCode = status.ExitStatus()
+		}
+	}
+
+	return &Error{
+		Kind:     errorKind(exitCode, stderr),
+		ExitCode: exitCode,
+		Stderr:   stderr,
+		Err:      err,
+	}
+}
+
+// ErrorKind returns the category of failure of an error returned by
+// NewError, possibly wrapped, or nil if it's not a restic command error
+// or its category is not recognized.
+func ErrorKind(err error) error {
+	if resticErr, ok := errors.Cause(err).(*Error); ok {
+		return resticErr.Kind
+	}
+
+	return nil
+}
+
+// IsRetryable returns true if the provided error from a restic command
+// indicates a transient failure, such as lock contention or a network
+// failure, which may not recur if the command is retried.
+func IsRetryable(err error) bool {
+	switch ErrorKind(err) {
+	case ErrRepoLocked, ErrNetwork:
+		return true
+	default:
+		return false
+	}
+}
+
+func errorKind(exitCode int, stderr string) error {
+	switch exitCode {
+	case exitCodeRepoNotFound:
+		return ErrRepoNotFound
+	case exitCodeRepoLocked:
+		return ErrRepoLocked
+	case exitCodeAuth:
+		return ErrAuth
+	}
+
+	// S3 reports deletes of locked objects as access denied, so check for
+	// immutable storage first.
+	switch {
+	case containsAny(stderr, immutableStorageErrorPatterns):
+		return ErrImmutableStorage
+	case isPermissionDeniedError(stderr):
+		return ErrPermissionDenied
+	case isRepositoryNotFoundError(stderr):
+		return ErrRepoNotFound
+	case containsAny(stderr, repoExistsErrorPatterns):
+		return ErrRepoExists
+	case containsAny(stderr, lockErrorPatterns):
+		return ErrRepoLocked
+	case containsAny(stderr, authErrorPatterns):
+		return ErrAuth
+	case containsAny(stderr, networkErrorPatterns):
+		return ErrNetwork
+	}
+
+	if exitCode == ExitCodeIncompleteSnapshot {
+		return ErrIncompleteSnapshot
+	}
+
+	return nil
+}
+
+// repositoryNotFoundErrorPatterns are substrings of restic's stderr output
+// that indicate the repository has not been initialized.
+var repositoryNotFoundErrorPatterns = []string{
+	"unable to open config file",
+	"is there a repository at the following location?",
+}
+
+// isRepositoryNotFoundError returns true if the provided stderr output from
+// a restic command indicates that the repository does not exist, or false
+// otherwise. restic reports being denied access to a repository's config
+// the same way, so permission errors are not treated as not found.
+func isRepositoryNotFoundError(stderr string) bool {
+	if isPermissionDeniedError(stderr) {
+		return false
+	}
+
+	return containsAny(stderr, repositoryNotFoundErrorPatterns)
+}
+
+// repoExistsErrorPatterns are substrings of restic's stderr output that
+// indicate a repository being initialized already exists.
+var repoExistsErrorPatterns = []string{
+	"config file already exists",
+	"repository master key and config already initialized",
+}
+
+// lockErrorPatterns are substrings of restic's stderr output that indicate
+// the repository could not be locked.
+var lockErrorPatterns = []string{
+	"unable to create lock",
+	"unable to acquire lock",
+	"repository is already locked",
+}
+
+// authErrorPatterns are substrings of restic's stderr output that indicate
+// the repository password was wrong.
+var authErrorPatterns = []string{
+	"wrong password or no key found",
+	"an empty password is not allowed",
+}
+
+// networkErrorPatterns are substrings of restic's stderr output that
+// indicate a network failure.
+var networkErrorPatterns = []string{
+	"connection reset",
+	"connection refused",
+	"i/o timeout",
+	"tls handshake timeout",
+}
+
+// permissionDeniedErrorPatterns are substrings of restic's stderr output
+// that indicate restic was denied access to files or storage.
+var permissionDeniedErrorPatterns = []string{
+	"permission denied",
+	"access denied",
+	"accessdenied",
+	"authorizationfailure",
+}
+
+// immutableStorageErrorPatterns are substrings of restic's stderr output
+// that indicate the repository's storage refused to delete or overwrite an
+// object because it's write-once (WORM).
+var immutableStorageErrorPatterns = []string{
+	// S3 Object Lock
+	"protected by object lock",
+	// MinIO object locking
+	"worm protected",
+	// Azure immutable blob storage
+	"blobimmutableduetopolicy",
+	"blob is immutable",
+	// GCS retention policies and holds
+	"retention policy",
+	"object is under active",
+}
+
+// snapshotNotFoundErrorPatterns are substrings of restic's stderr output
+// that indicate a snapshot ID given to a command doesn't match any
+// snapshot in the repository.
+var snapshotNotFoundErrorPatterns = []string{
+	"no matching id found",
+	"it is not a snapshot id",
+	"no snapshot matched",
+}
+
+// isSnapshotNotFoundError returns true if the provided stderr output from
+// a restic command indicates that a snapshot it was given doesn't exist.
+func isSnapshotNotFoundError(stderr string) bool {
+	return containsAny(stderr, snapshotNotFoundErrorPatterns)
+}
+
+func isPermissionDeniedError(stderr string) bool {
+	return containsAny(stderr, permissionDeniedErrorPatterns)
+}
+
+// containsAny returns true if the provided stderr output contains any of
+// the patterns, ignoring case.
+func containsAny(stderr string, patterns []string) bool {
+	stderr = strings.ToLower(stderr)
+
+	for _, pattern := range patterns {
+		if strings.Contains(stderr, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,273 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name     string
+		stderr   string
+		expected bool
+	}{
+		{
+			name:     "repository locked",
+			stderr:   "unable to create lock in backend: repository is already locked by PID 42 on host-1 by root (UID 0, GID 0)",
+			expected: true,
+		},
+		{
+			name:     "connection reset",
+			stderr:   "Save(<data/6b4b0b9a3c>) returned error, retrying after 552.330144ms: read tcp 10.0.0.1:4242->52.216.0.1:443: read: connection reset by peer",
+			expected: true,
+		},
+		{
+			name:     "network timeout",
+			stderr:   "Get https://bucket.s3.amazonaws.com/: dial tcp: i/o timeout",
+			expected: true,
+		},
+		{
+			name:     "wrong password",
+			stderr:   "Fatal: wrong password or no key found",
+			expected: false,
+		},
+		{
+			name:     "repository does not exist",
+			stderr:   "Fatal: unable to open config file: Stat: The specified key does not exist.\nIs there a repository at the following location?",
+			expected: false,
+		},
+		{
+			name:     "empty stderr",
+			stderr:   "",
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, IsRetryable(NewError(errors.New("exit status 1"), test.stderr)))
+		})
+	}
+}
+
+func TestIsRepositoryNotFoundError(t *testing.T) {
+	tests := []struct {
+		name     string
+		stderr   string
+		expected bool
+	}{
+		{
+			name:     "s3 repository does not exist",
+			stderr:   "Fatal: unable to open config file: Stat: The specified key does not exist.\nIs there a repository at the following location?\ns3:s3.amazonaws.com/bucket/ns-1\n",
+			expected: true,
+		},
+		{
+			name:     "gcs repository does not exist",
+			stderr:   "Fatal: unable to open config file: stat: googleapi: Error 404: No such object: bucket/ns-1/config, notFound\nIs there a repository at the following location?\ngs:bucket:/ns-1\n",
+			expected: true,
+		},
+		{
+			name:     "azure repository does not exist",
+			stderr:   "Fatal: unable to open config file: Stat: storage: service returned error: StatusCode=404, ErrorCode=BlobNotFound, ErrorMessage=The specified blob does not exist.\nIs there a repository at the following location?\nazure:container:/ns-1\n",
+			expected: true,
+		},
+		{
+			name:     "local repository does not exist",
+			stderr:   "Fatal: unable to open config file: stat /tmp/ns-1/config: no such file or directory\nIs there a repository at the following location?\n/tmp/ns-1\n",
+			expected: true,
+		},
+		{
+			name:     "s3 access denied",
+			stderr:   "Fatal: unable to open config file: Stat: Access Denied.\nIs there a repository at the following location?\ns3:s3.amazonaws.com/bucket/ns-1\n",
+			expected: false,
+		},
+		{
+			name:     "wrong password",
+			stderr:   "Fatal: wrong password or no key found\n",
+			expected: false,
+		},
+		{
+			name:     "repository locked",
+			stderr:   "unable to create lock in backend: repository is already locked by PID 42 on host-1 by root (UID 0, GID 0)",
+			expected: false,
+		},
+		{
+			name:     "empty stderr",
+			stderr:   "",
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, isRepositoryNotFoundError(test.stderr))
+		})
+	}
+}
+
+func TestNewError(t *testing.T) {
+	tests := []struct {
+		name     string
+		stderr   string
+		expected error
+	}{
+		{
+			name:     "repository does not exist",
+			stderr:   "Fatal: unable to open config file: Stat: The specified key does not exist.\nIs there a repository at the following location?\ns3:s3.amazonaws.com/bucket/ns-1\n",
+			expected: ErrRepoNotFound,
+		},
+		{
+			name:     "repository locked",
+			stderr:   "unable to create lock in backend: repository is already locked by PID 42 on host-1 by root (UID 0, GID 0)",
+			expected: ErrRepoLocked,
+		},
+		{
+			name:     "wrong password",
+			stderr:   "Fatal: wrong password or no key found",
+			expected: ErrAuth,
+		},
+		{
+			name:     "repository already initialized",
+			stderr:   "Fatal: create repository at s3:s3.amazonaws.com/bucket/ns-1 failed: Fatal: config file already exists\n",
+			expected: ErrRepoExists,
+		},
+		{
+			name:     "unreadable file in volume",
+			stderr:   "error: open /host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1/data.db: permission denied",
+			expected: ErrPermissionDenied,
+		},
+		{
+			name:     "s3 access denied",
+			stderr:   "Fatal: unable to open config file: Stat: Access Denied.\nIs there a repository at the following location?\ns3:s3.amazonaws.com/bucket/ns-1\n",
+			expected: ErrPermissionDenied,
+		},
+		{
+			name:     "s3 object lock",
+			stderr:   "Remove(<snapshot/d3a6c2a1f0>) returned error, retrying after 720.223ms: AccessDenied: Access Denied because object protected by object lock.",
+			expected: ErrImmutableStorage,
+		},
+		{
+			name:     "minio object lock",
+			stderr:   "Remove(<snapshot/d3a6c2a1f0>) returned error: Object is WORM protected and cannot be overwritten",
+			expected: ErrImmutableStorage,
+		},
+		{
+			name:     "azure immutability policy",
+			stderr:   "Remove(<snapshot/d3a6c2a1f0>) returned error: -> github.com/Azure/azure-sdk-for-go/storage.AzureStorageServiceError: storage: service returned error: StatusCode=409, ErrorCode=BlobImmutableDueToPolicy, ErrorMessage=This operation is not permitted as the blob is immutable due to a policy.",
+			expected: ErrImmutableStorage,
+		},
+		{
+			name:     "gcs retention policy",
+			stderr:   "Remove(<snapshot/d3a6c2a1f0>) returned error: googleapi: Error 403: Object 'ns-1/snapshots/d3a6c2a1f0' is subject to bucket's retention policy and cannot be deleted, overwritten or archived until 2018-07-01T12:00:00Z, retentionPolicyNotMet",
+			expected: ErrImmutableStorage,
+		},
+		{
+			name:     "connection refused",
+			stderr:   "Fatal: create repository at s3:minio:9000/bucket/ns-1 failed: Get http://minio:9000/bucket/?location=: dial tcp 10.0.0.2:9000: connect: connection refused",
+			expected: ErrNetwork,
+		},
+		{
+			name:     "unrecognized error",
+			stderr:   "Fatal: invalid id \"abc\": no matching ID found",
+			expected: nil,
+		},
+		{
+			name:     "empty stderr",
+			expected: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewError(errors.New("exit status 1"), test.stderr)
+
+			assert.Equal(t, test.expected, err.Kind)
+			assert.Equal(t, -1, err.ExitCode)
+			assert.Equal(t, test.stderr, err.Stderr)
+			assert.Equal(t, "stderr="+test.stderr+": exit status 1", err.Error())
+		})
+	}
+}
+
+func TestNewErrorExitCode(t *testing.T) {
+	tests := []struct {
+		name             string
+		script           string
+		expectedKind     error
+		expectedExitCode int
+	}{
+		{
+			name:             "repository does not exist",
+			script:           "echo 'Fatal: repository does not exist' >&2; exit 10",
+			expectedKind:     ErrRepoNotFound,
+			expectedExitCode: 10,
+		},
+		{
+			name:             "repository locked",
+			script:           "echo 'Fatal: repo already locked' >&2; exit 11",
+			expectedKind:     ErrRepoLocked,
+			expectedExitCode: 11,
+		},
+		{
+			name:             "wrong password",
+			script:           "echo 'Fatal: incorrect password' >&2; exit 12",
+			expectedKind:     ErrAuth,
+			expectedExitCode: 12,
+		},
+		{
+			name:             "incomplete snapshot",
+			script:           "echo 'Warning: at least one source file could not be read' >&2; exit 3",
+			expectedKind:     ErrIncompleteSnapshot,
+			expectedExitCode: 3,
+		},
+		{
+			name:             "stderr is checked for other exit codes",
+			script:           "echo 'Fatal: wrong password or no key found' >&2; exit 1",
+			expectedKind:     ErrAuth,
+			expectedExitCode: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := exec.Command("sh", "-c", test.script).Output()
+			require.Error(t, err)
+
+			exitErr, ok := err.(*exec.ExitError)
+			require.True(t, ok)
+
+			resticErr := NewError(err, string(exitErr.Stderr))
+			assert.Equal(t, test.expectedKind, resticErr.Kind)
+			assert.Equal(t, test.expectedExitCode, resticErr.ExitCode)
+		})
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	resticErr := NewError(errors.New("exit status 1"), "repository is already locked")
+
+	assert.Equal(t, ErrRepoLocked, ErrorKind(resticErr))
+	assert.Equal(t, ErrRepoLocked, ErrorKind(errors.Wrap(resticErr, "error running restic backup")))
+	assert.Nil(t, ErrorKind(errors.New("some other error")))
+	assert.Nil(t, ErrorKind(nil))
+}
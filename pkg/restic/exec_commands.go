@@ -1,36 +1,293 @@
 package restic
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"os/exec"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
-// GetSnapshotID runs a 'restic snapshots' command to get the ID of the snapshot
-// in the specified repo matching the set of provided tags, or an error if a
-// unique snapshot cannot be identified.
-func GetSnapshotID(repoPrefix, repo, passwordFile string, tags map[string]string) (string, error) {
-	output, err := GetSnapshotCommand(repoPrefix, repo, passwordFile, tags).Cmd().Output()
+// GetSnapshotID runs a 'restic snapshots' command, as returned by
+// GetSnapshotCommand, to get the ID of the snapshot matching its set
+// of tags, and in its snapshot group if it has one, or an error if a
+// unique snapshot cannot be identified. If
+// there isn't exactly one matching snapshot, the error's cause is a
+// *SnapshotCountError. The command is killed if ctx is done before it
+// finishes; if ctx's deadline passed, the error's cause is an *Error of
+// kind ErrNetwork, since the repository's storage couldn't be listed in
+// time.
+func GetSnapshotID(ctx context.Context, snapshotIDCmd *Command) (string, error) {
+	snapshots, err := getSnapshots(ctx, snapshotIDCmd)
 	if err != nil {
+		return "", err
+	}
+
+	if len(snapshots) != 1 {
+		return "", errors.WithStack(&SnapshotCountError{Count: len(snapshots)})
+	}
+
+	return snapshots[0].ShortID, nil
+}
+
+// GetLatestSnapshotID is like GetSnapshotID, but if more than one snapshot
+// matches, it returns the ID of the latest of them rather than an error.
+// If no snapshot matches, the error's cause is a *SnapshotCountError.
+func GetLatestSnapshotID(ctx context.Context, snapshotIDCmd *Command) (string, error) {
+	snapshots, err := getSnapshots(ctx, snapshotIDCmd)
+	if err != nil {
+		return "", err
+	}
+
+	if len(snapshots) == 0 {
+		return "", errors.WithStack(&SnapshotCountError{Count: 0})
+	}
+
+	latest := snapshots[0]
+	for _, snapshot := range snapshots[1:] {
+		if snapshot.Time.After(latest.Time) {
+			latest = snapshot
+		}
+	}
+
+	return latest.ShortID, nil
+}
+
+// getSnapshots runs a 'restic snapshots' command, as returned by
+// GetSnapshotCommand, and returns the snapshots matching its set of tags,
+// and in its snapshot group if it has one.
+func getSnapshots(ctx context.Context, snapshotIDCmd *Command) ([]Snapshot, error) {
+	output, err := snapshotIDCmd.CmdContext(ctx).Output()
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errors.Wrap(&Error{Kind: ErrNetwork, ExitCode: -1, Err: ctx.Err()}, "error running command")
+		}
 		if exitErr, ok := err.(*exec.ExitError); ok {
-			return "", errors.Wrapf(err, "error running command, stderr=%s", exitErr.Stderr)
+			return nil, errors.Wrap(NewError(err, string(exitErr.Stderr)), "error running command")
+		}
+		return nil, errors.Wrap(err, "error running command")
+	}
+
+	if snapshotIDCmd.SnapshotGroup != nil {
+		groups, err := ParseSnapshotGroups(output)
+		if err != nil {
+			return nil, err
 		}
-		return "", errors.Wrap(err, "error running command")
+		return snapshotsInGroup(groups, *snapshotIDCmd.SnapshotGroup), nil
 	}
 
-	type snapshotID struct {
-		ShortID string `json:"short_id"`
+	return ParseSnapshots(output)
+}
+
+// SnapshotCountError means that the number of snapshots matching a set of
+// tags wasn't one.
+type SnapshotCountError struct {
+	// Count is the number of matching snapshots.
+	Count int
+}
+
+func (e *SnapshotCountError) Error() string {
+	return fmt.Sprintf("expected one matching snapshot, got %d", e.Count)
+}
+
+// IsSnapshotNotFound returns true if the provided error, possibly wrapped,
+// is from GetSnapshotID finding no matching snapshot. On eventually
+// consistent object stores, a snapshot that was just taken may not be
+// listed for a while.
+func IsSnapshotNotFound(err error) bool {
+	countErr, ok := errors.Cause(err).(*SnapshotCountError)
+	return ok && countErr.Count == 0
+}
+
+// Snapshot is a restic snapshot, as listed by 'restic snapshots --json'.
+// Tags are in the key=value form that Ark tags snapshots with.
+type Snapshot struct {
+	ID       string    `json:"id"`
+	ShortID  string    `json:"short_id"`
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname"`
+	Paths    []string  `json:"paths"`
+	Tags     []string  `json:"tags"`
+}
+
+// ListSnapshots runs a 'restic snapshots' command, as returned by
+// ListSnapshotsCommand or GetSnapshotCommand, and returns the snapshots
+// it lists.
+func ListSnapshots(snapshotsCmd *Command) ([]Snapshot, error) {
+	output, err := snapshotsCmd.Cmd().Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, errors.Wrap(NewError(err, string(exitErr.Stderr)), "error running command")
+		}
+		return nil, errors.Wrap(err, "error running command")
 	}
 
-	var snapshots []snapshotID
+	return ParseSnapshots(output)
+}
+
+// ParseSnapshots parses the output of 'restic snapshots --json'.
+func ParseSnapshots(output []byte) ([]Snapshot, error) {
+	var snapshots []Snapshot
 	if err := json.Unmarshal(output, &snapshots); err != nil {
-		return "", errors.Wrap(err, "error unmarshalling restic snapshots result")
+		return nil, errors.Wrap(err, "error unmarshalling restic snapshots result")
 	}
 
-	if len(snapshots) != 1 {
-		return "", errors.Errorf("expected one matching snapshot, got %d", len(snapshots))
+	return snapshots, nil
+}
+
+// GetVersion runs 'restic version' using the given restic binary and
+// returns its output, e.g. "restic 0.9.1 compiled with go1.10.3 on linux/amd64".
+func GetVersion(ctx context.Context, resticBinary string) (string, error) {
+	output, err := exec.CommandContext(ctx, resticBinary, "version").Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", errors.Wrapf(err, "error running restic version, stderr=%s", exitErr.Stderr)
+		}
+		return "", errors.Wrap(err, "error running restic version")
 	}
 
-	return snapshots[0].ShortID, nil
+	return strings.TrimSpace(string(output)), nil
+}
+
+// RepositoryExists runs a 'restic cat config' command, as returned by
+// CatConfigCommand, to determine whether its repo has been initialized.
+// An error is returned if this can't be determined, e.g. because the
+// object store is unreachable.
+func RepositoryExists(ctx context.Context, catConfigCmd *Command) (bool, error) {
+	if _, err := catConfigCmd.CmdContext(ctx).Output(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			resticErr := NewError(err, string(exitErr.Stderr))
+			if resticErr.Kind == ErrRepoNotFound {
+				return false, nil
+			}
+			return false, errors.Wrap(resticErr, "error running command")
+		}
+		return false, errors.Wrap(err, "error running command")
+	}
+
+	return true, nil
+}
+
+// SnapshotStats is the output of 'restic stats --json' for a snapshot.
+type SnapshotStats struct {
+	TotalSize      int64 `json:"total_size"`
+	TotalFileCount int64 `json:"total_file_count"`
+}
+
+// GetSnapshotStats runs a 'restic stats' command, as returned by
+// StatsCommand, to get the size and number of files in its snapshot. The
+// command is killed if ctx is done before it finishes.
+func GetSnapshotStats(ctx context.Context, statsCmd *Command) (SnapshotStats, error) {
+	output, err := statsCmd.CmdContext(ctx).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return SnapshotStats{}, errors.Wrap(NewError(err, string(exitErr.Stderr)), "error running command")
+		}
+		return SnapshotStats{}, errors.Wrap(err, "error running command")
+	}
+
+	return ParseSnapshotStats(output)
+}
+
+// ParseSnapshotStats parses the output of 'restic stats --json'.
+func ParseSnapshotStats(output []byte) (SnapshotStats, error) {
+	var stats SnapshotStats
+	if err := json.Unmarshal(output, &stats); err != nil {
+		return SnapshotStats{}, errors.Wrap(err, "error unmarshalling restic stats result")
+	}
+
+	return stats, nil
+}
+
+// RepoStats is the output of 'restic stats --json --mode=raw-data' for a
+// repository. SnapshotsCount is only reported by restic 0.14.0 and later.
+type RepoStats struct {
+	TotalSize      int64 `json:"total_size"`
+	SnapshotsCount int64 `json:"snapshots_count"`
+}
+
+// GetRepoStats runs a 'restic stats' command, as returned by
+// RepoStatsCommand, to get the size of its repository's data and its
+// number of snapshots.
+func GetRepoStats(ctx context.Context, statsCmd *Command) (RepoStats, error) {
+	output, err := statsCmd.CmdContext(ctx).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return RepoStats{}, errors.Wrap(NewError(err, string(exitErr.Stderr)), "error running command")
+		}
+		return RepoStats{}, errors.Wrap(err, "error running command")
+	}
+
+	return ParseRepoStats(output)
+}
+
+// ParseRepoStats parses the output of 'restic stats --json --mode=raw-data'.
+func ParseRepoStats(output []byte) (RepoStats, error) {
+	var stats RepoStats
+	if err := json.Unmarshal(output, &stats); err != nil {
+		return RepoStats{}, errors.Wrap(err, "error unmarshalling restic stats result")
+	}
+
+	return stats, nil
+}
+
+// VerifyRepo runs a 'restic check' command, as returned by VerifyCommand,
+// returning an error if the repository fails the check. The command is
+// killed if ctx is done before it finishes.
+func VerifyRepo(ctx context.Context, verifyCmd *Command) error {
+	if output, err := verifyCmd.CmdContext(ctx).CombinedOutput(); err != nil {
+		return errors.Wrap(NewError(err, string(output)), "error running command")
+	}
+
+	return nil
+}
+
+// InitRepo runs a 'restic init' command, as returned by InitCommand with
+// its PasswordFile set.
+func InitRepo(ctx context.Context, initCmd *Command) error {
+	if output, err := initCmd.CmdContext(ctx).CombinedOutput(); err != nil {
+		return errors.Wrap(NewError(err, string(output)), "error running command")
+	}
+
+	return nil
+}
+
+// PruneRepo runs a 'restic prune' command, as returned by PruneCommand
+// with its PasswordFile set.
+func PruneRepo(ctx context.Context, pruneCmd *Command) error {
+	if output, err := pruneCmd.CmdContext(ctx).CombinedOutput(); err != nil {
+		return errors.Wrap(NewError(err, string(output)), "error running command")
+	}
+
+	return nil
+}
+
+// UnlockRepo runs a 'restic unlock' command, as returned by UnlockCommand.
+func UnlockRepo(unlockCmd *Command) error {
+	if output, err := unlockCmd.Cmd().CombinedOutput(); err != nil {
+		return errors.Wrap(NewError(err, string(output)), "error running command")
+	}
+
+	return nil
+}
+
+// ForgetSnapshot runs a 'restic forget' command, as returned by
+// ForgetCommand with its PasswordFile set. A snapshot that no longer
+// exists, e.g. because it was already forgotten or its repository was
+// removed, is not an error.
+func ForgetSnapshot(ctx context.Context, forgetCmd *Command) error {
+	output, err := forgetCmd.CmdContext(ctx).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+
+	resticErr := NewError(err, string(output))
+	if resticErr.Kind == ErrRepoNotFound || isSnapshotNotFoundError(resticErr.Stderr) {
+		return nil
+	}
+
+	return errors.Wrap(resticErr, "error running command")
 }
@@ -0,0 +1,372 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSnapshotStats(t *testing.T) {
+	tests := []struct {
+		name        string
+		output      string
+		expected    SnapshotStats
+		expectedErr bool
+	}{
+		{
+			name:     "restore-size stats",
+			output:   `{"total_size":10485760,"total_file_count":42}` + "\n",
+			expected: SnapshotStats{TotalSize: 10485760, TotalFileCount: 42},
+		},
+		{
+			name:     "empty snapshot",
+			output:   `{"total_size":0,"total_file_count":0}`,
+			expected: SnapshotStats{},
+		},
+		{
+			name:     "extra fields are ignored",
+			output:   `{"total_size":2048,"total_file_count":3,"total_blob_count":5,"snapshots_count":1}`,
+			expected: SnapshotStats{TotalSize: 2048, TotalFileCount: 3},
+		},
+		{
+			name:        "non-json output",
+			output:      "Stats for the snapshot in restore size mode:\n  Total File Count:   42\n      Total Size:   10.000 MiB\n",
+			expectedErr: true,
+		},
+		{
+			name:        "empty output",
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats, err := ParseSnapshotStats([]byte(test.output))
+			if test.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.expected, stats)
+		})
+	}
+}
+
+func TestParseSnapshots(t *testing.T) {
+	tests := []struct {
+		name        string
+		output      string
+		expected    []Snapshot
+		expectedErr bool
+	}{
+		{
+			name: "tagged snapshots",
+			output: `[{"time":"2018-06-01T12:00:00.123456789Z","tree":"5f3e1d0c","paths":["/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1"],"hostname":"node-1","username":"root","tags":["pod=pod-1","volume=vol-1"],"id":"d3a6c2a1f0e9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3","short_id":"d3a6c2a1"},` +
+				`{"time":"2018-06-02T12:00:00Z","parent":"d3a6c2a1f0e9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3","tree":"6a4f2e1d","paths":["/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1"],"hostname":"cluster-1","tags":["pod=pod-1","volume=vol-1"],"id":"8f1e0b9c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a","short_id":"8f1e0b9c"}]` + "\n",
+			expected: []Snapshot{
+				{
+					ID:       "d3a6c2a1f0e9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3",
+					ShortID:  "d3a6c2a1",
+					Time:     time.Date(2018, 6, 1, 12, 0, 0, 123456789, time.UTC),
+					Hostname: "node-1",
+					Paths:    []string{"/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1"},
+					Tags:     []string{"pod=pod-1", "volume=vol-1"},
+				},
+				{
+					ID:       "8f1e0b9c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a",
+					ShortID:  "8f1e0b9c",
+					Time:     time.Date(2018, 6, 2, 12, 0, 0, 0, time.UTC),
+					Hostname: "cluster-1",
+					Paths:    []string{"/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1"},
+					Tags:     []string{"pod=pod-1", "volume=vol-1"},
+				},
+			},
+		},
+		{
+			name:     "untagged snapshot",
+			output:   `[{"time":"2018-06-01T12:00:00Z","paths":["/data"],"hostname":"node-1","id":"abc123","short_id":"abc123"}]`,
+			expected: []Snapshot{{ID: "abc123", ShortID: "abc123", Time: time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC), Hostname: "node-1", Paths: []string{"/data"}}},
+		},
+		{
+			name:     "no snapshots",
+			output:   "[]\n",
+			expected: []Snapshot{},
+		},
+		{
+			name:        "non-json output",
+			output:      "ID        Time                 Host    Tags\n----------------------------------\n0 snapshots\n",
+			expectedErr: true,
+		},
+		{
+			name:        "empty output",
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			snapshots, err := ParseSnapshots([]byte(test.output))
+			if test.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.expected, snapshots)
+		})
+	}
+}
+
+func TestParseRepoStats(t *testing.T) {
+	tests := []struct {
+		name        string
+		output      string
+		expected    RepoStats
+		expectedErr bool
+	}{
+		{
+			name:     "raw-data stats",
+			output:   `{"total_size":52428800,"total_uncompressed_size":104857600,"compression_ratio":2,"compression_progress":100,"compression_space_saving":50,"total_blob_count":120,"snapshots_count":7}` + "\n",
+			expected: RepoStats{TotalSize: 52428800, SnapshotsCount: 7},
+		},
+		{
+			name:     "restic versions before 0.14.0 don't report a snapshot count",
+			output:   `{"total_size":2048,"total_blob_count":5}`,
+			expected: RepoStats{TotalSize: 2048},
+		},
+		{
+			name:        "non-json output",
+			output:      "Stats in raw-data mode:\nSnapshots processed:   7\n   Total Blob Count:   120\n        Total Size:   50.000 MiB\n",
+			expectedErr: true,
+		},
+		{
+			name:        "empty output",
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats, err := ParseRepoStats([]byte(test.output))
+			if test.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.expected, stats)
+		})
+	}
+}
+
+func TestGetVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restic-version")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	restic := filepath.Join(dir, "restic")
+	require.NoError(t, ioutil.WriteFile(restic, []byte("#!/bin/sh\necho 'restic 0.9.1 compiled with go1.10.3 on linux/amd64'\n"), 0755))
+
+	version, err := GetVersion(context.Background(), restic)
+	assert.NoError(t, err)
+	assert.Equal(t, "restic 0.9.1 compiled with go1.10.3 on linux/amd64", version)
+
+	broken := filepath.Join(dir, "restic-broken")
+	require.NoError(t, ioutil.WriteFile(broken, []byte("#!/bin/sh\necho 'fatal error' >&2\nexit 1\n"), 0755))
+
+	_, err = GetVersion(context.Background(), broken)
+	assert.EqualError(t, err, "error running restic version, stderr=fatal error\n: exit status 1")
+
+	_, err = GetVersion(context.Background(), filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestForgetSnapshot(t *testing.T) {
+	tests := []struct {
+		name        string
+		script      string
+		expectedErr bool
+	}{
+		{
+			name:   "snapshot is forgotten",
+			script: "echo 'removed snapshot abc123'",
+		},
+		{
+			name:   "snapshot that doesn't exist",
+			script: "echo 'Ignoring \"abc123\": no matching ID found for prefix \"abc123\"' >&2; exit 1",
+		},
+		{
+			name:   "repository that doesn't exist",
+			script: "echo 'Fatal: repository does not exist' >&2; exit 10",
+		},
+		{
+			name:        "repository locked",
+			script:      "echo 'Fatal: unable to create lock in backend: repository is already locked' >&2; exit 11",
+			expectedErr: true,
+		},
+		{
+			name:        "unrecognized error",
+			script:      "echo 'Fatal: something went wrong' >&2; exit 1",
+			expectedErr: true,
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "restic-forget")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			restic := filepath.Join(dir, fmt.Sprintf("restic-%d", i))
+			require.NoError(t, ioutil.WriteFile(restic, []byte("#!/bin/sh\n"+test.script+"\n"), 0755))
+
+			cmd := ForgetCommand("s3:s3.amazonaws.com/bucket", "ns-1", "abc123")
+			cmd.BaseName = restic
+
+			err := ForgetSnapshot(context.Background(), cmd)
+			if test.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestListSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restic-snapshots")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	restic := filepath.Join(dir, "restic")
+	cmd := ListSnapshotsCommand("s3:s3.amazonaws.com/bucket", "ns-1", "/tmp/credentials", map[string]string{"pod": "pod-1"}, "")
+	cmd.BaseName = restic
+
+	// no matching snapshots
+	require.NoError(t, ioutil.WriteFile(restic, []byte("#!/bin/sh\necho '[]'\n"), 0755))
+	snapshots, err := ListSnapshots(cmd)
+	assert.NoError(t, err)
+	assert.Empty(t, snapshots)
+
+	require.NoError(t, ioutil.WriteFile(restic, []byte(`#!/bin/sh
+echo '[{"time":"2018-06-01T12:00:00Z","paths":["/data"],"hostname":"node-1","tags":["pod=pod-1"],"id":"abc123","short_id":"abc123"}]'
+`), 0755))
+	snapshots, err = ListSnapshots(cmd)
+	assert.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, "abc123", snapshots[0].ShortID)
+	assert.Equal(t, []string{"pod=pod-1"}, snapshots[0].Tags)
+
+	require.NoError(t, ioutil.WriteFile(restic, []byte("#!/bin/sh\necho 'Fatal: repository does not exist' >&2\nexit 10\n"), 0755))
+	_, err = ListSnapshots(cmd)
+	assert.Error(t, err)
+	assert.Equal(t, ErrRepoNotFound, ErrorKind(err))
+}
+
+func TestGetSnapshotID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restic-snapshot-id")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	restic := filepath.Join(dir, "restic")
+	cmd := GetSnapshotCommand("s3:s3.amazonaws.com/bucket", "ns-1", "/tmp/credentials", map[string]string{"pvb-uid": "uid-1"}, nil)
+	cmd.BaseName = restic
+
+	snapshot := `{"time":"2018-06-01T12:00:00Z","paths":["/data"],"hostname":"node-1","tags":["pvb-uid=uid-1"],"id":"abc123","short_id":"abc123"}`
+
+	require.NoError(t, ioutil.WriteFile(restic, []byte("#!/bin/sh\necho '["+snapshot+"]'\n"), 0755))
+	id, err := GetSnapshotID(context.Background(), cmd)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", id)
+
+	// a snapshot that isn't listed yet
+	require.NoError(t, ioutil.WriteFile(restic, []byte("#!/bin/sh\necho '[]'\n"), 0755))
+	_, err = GetSnapshotID(context.Background(), cmd)
+	assert.EqualError(t, err, "expected one matching snapshot, got 0")
+	assert.True(t, IsSnapshotNotFound(err))
+	assert.True(t, IsSnapshotNotFound(errors.Wrap(err, "error getting snapshot id")))
+
+	require.NoError(t, ioutil.WriteFile(restic, []byte("#!/bin/sh\necho '["+snapshot+","+snapshot+"]'\n"), 0755))
+	_, err = GetSnapshotID(context.Background(), cmd)
+	assert.EqualError(t, err, "expected one matching snapshot, got 2")
+	assert.False(t, IsSnapshotNotFound(err))
+
+	require.NoError(t, ioutil.WriteFile(restic, []byte("#!/bin/sh\necho 'Fatal: repository does not exist' >&2\nexit 10\n"), 0755))
+	_, err = GetSnapshotID(context.Background(), cmd)
+	assert.Error(t, err)
+	assert.Equal(t, ErrRepoNotFound, ErrorKind(err))
+	assert.False(t, IsSnapshotNotFound(err))
+
+	// a repository whose storage doesn't respond in time
+	require.NoError(t, ioutil.WriteFile(restic, []byte("#!/bin/sh\nexec sleep 10\n"), 0755))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = GetSnapshotID(ctx, cmd)
+	assert.True(t, time.Since(start) < 5*time.Second, "restic should be killed when the timeout expires")
+	assert.EqualError(t, err, "error running command: stderr=: context deadline exceeded")
+	assert.Equal(t, ErrNetwork, ErrorKind(err))
+	assert.False(t, IsSnapshotNotFound(err))
+}
+
+func TestGetLatestSnapshotID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restic-latest-snapshot-id")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	restic := filepath.Join(dir, "restic")
+	cmd := GetSnapshotCommand("s3:s3.amazonaws.com/bucket", "ns-1", "/tmp/credentials", map[string]string{"volume-fingerprint": "4-150-1"}, nil)
+	cmd.BaseName = restic
+
+	older := `{"time":"2018-06-01T12:00:00Z","paths":["/data"],"hostname":"node-1","tags":["volume-fingerprint=4-150-1"],"id":"abc123","short_id":"abc123"}`
+	newer := `{"time":"2018-06-02T12:00:00Z","paths":["/data"],"hostname":"node-1","tags":["volume-fingerprint=4-150-1"],"id":"def456","short_id":"def456"}`
+
+	require.NoError(t, ioutil.WriteFile(restic, []byte("#!/bin/sh\necho '["+older+"]'\n"), 0755))
+	id, err := GetLatestSnapshotID(context.Background(), cmd)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", id)
+
+	// two snapshots with the same fingerprint, in either order
+	require.NoError(t, ioutil.WriteFile(restic, []byte("#!/bin/sh\necho '["+older+","+newer+"]'\n"), 0755))
+	id, err = GetLatestSnapshotID(context.Background(), cmd)
+	assert.NoError(t, err)
+	assert.Equal(t, "def456", id)
+
+	require.NoError(t, ioutil.WriteFile(restic, []byte("#!/bin/sh\necho '["+newer+","+older+"]'\n"), 0755))
+	id, err = GetLatestSnapshotID(context.Background(), cmd)
+	assert.NoError(t, err)
+	assert.Equal(t, "def456", id)
+
+	require.NoError(t, ioutil.WriteFile(restic, []byte("#!/bin/sh\necho '[]'\n"), 0755))
+	_, err = GetLatestSnapshotID(context.Background(), cmd)
+	assert.EqualError(t, err, "expected one matching snapshot, got 0")
+	assert.True(t, IsSnapshotNotFound(err))
+
+	require.NoError(t, ioutil.WriteFile(restic, []byte("#!/bin/sh\necho 'Fatal: repository does not exist' >&2\nexit 10\n"), 0755))
+	_, err = GetLatestSnapshotID(context.Background(), cmd)
+	assert.Error(t, err)
+	assert.Equal(t, ErrRepoNotFound, ErrorKind(err))
+	assert.False(t, IsSnapshotNotFound(err))
+}
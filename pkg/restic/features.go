@@ -0,0 +1,99 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import "sort"
+
+// Feature is an optional restic feature that only some restic versions
+// support.
+type Feature string
+
+const (
+	// FeatureCompression is compressed (version 2) repositories.
+	FeatureCompression Feature = "compression"
+
+	// FeaturePackSize is setting the size of the pack files that
+	// snapshots' data is stored in.
+	FeaturePackSize Feature = "pack-size"
+
+	// FeatureReadConcurrency is setting the number of files read
+	// concurrently during a backup.
+	FeatureReadConcurrency Feature = "read-concurrency"
+
+	// FeatureExcludeLargerThan is excluding files larger than a size from
+	// a backup.
+	FeatureExcludeLargerThan Feature = "exclude-larger-than"
+
+	// FeatureBlockBackup is backing up block devices, by backing up the
+	// output of a command.
+	FeatureBlockBackup Feature = "block-backup"
+)
+
+// minExcludeLargerThanVersion is the first restic version that supports
+// excluding files larger than a size from a backup.
+var minExcludeLargerThanVersion = Version{0, 9, 6}
+
+// featureMinVersions is the first restic version that supports each
+// optional feature.
+var featureMinVersions = map[Feature]Version{
+	FeatureCompression:       minCompressionVersion,
+	FeaturePackSize:          minPackSizeVersion,
+	FeatureReadConcurrency:   minReadConcurrencyVersion,
+	FeatureExcludeLargerThan: minExcludeLargerThanVersion,
+	FeatureBlockBackup:       minBlockBackupVersion,
+}
+
+// Features returns all of the optional restic features, sorted by name.
+func Features() []Feature {
+	features := make([]Feature, 0, len(featureMinVersions))
+	for feature := range featureMinVersions {
+		features = append(features, feature)
+	}
+	sort.Slice(features, func(i, j int) bool { return features[i] < features[j] })
+
+	return features
+}
+
+// MinVersion returns the first restic version that supports the feature.
+func (f Feature) MinVersion() Version {
+	return featureMinVersions[f]
+}
+
+// FeatureGates records whether the restic version in use supports each
+// optional feature.
+type FeatureGates map[Feature]bool
+
+// NewFeatureGates returns the FeatureGates for a restic version.
+func NewFeatureGates(version Version) FeatureGates {
+	gates := make(FeatureGates, len(featureMinVersions))
+	for feature, min := range featureMinVersions {
+		gates[feature] = version.AtLeast(min)
+	}
+
+	return gates
+}
+
+// Enabled returns true if the feature is supported. Nil FeatureGates, used
+// when the restic version is unknown, enable every feature, leaving restic
+// to reject any that it doesn't support.
+func (g FeatureGates) Enabled(feature Feature) bool {
+	if g == nil {
+		return true
+	}
+
+	return g[feature]
+}
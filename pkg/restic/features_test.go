@@ -0,0 +1,102 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		output   string
+		expected Version
+	}{
+		{output: "restic 0.9.1 compiled with go1.10.3 on linux/amd64", expected: Version{0, 9, 1}},
+		{output: "restic 0.16.4 compiled with go1.21.6 on linux/arm64", expected: Version{0, 16, 4}},
+		{output: "restic 1.0.0 compiled with go1.22 on linux/amd64", expected: Version{1, 0, 0}},
+		{output: "restic 0.14.0-dev (compiled manually) compiled with go1.19 on linux/amd64", expected: Version{0, 14, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.output, func(t *testing.T) {
+			version, err := ParseVersion(test.output)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, version)
+		})
+	}
+
+	for _, output := range []string{"", "not restic", "restic 0.9 compiled with go1.10.3", "restic version 0.9.1", "restic 99999999999999999999.0.0"} {
+		_, err := ParseVersion(output)
+		assert.Error(t, err, output)
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	min := Version{0, 14, 2}
+
+	assert.True(t, Version{0, 14, 2}.AtLeast(min))
+	assert.True(t, Version{0, 14, 3}.AtLeast(min))
+	assert.True(t, Version{0, 15, 0}.AtLeast(min))
+	assert.True(t, Version{1, 0, 0}.AtLeast(min))
+	assert.False(t, Version{0, 14, 1}.AtLeast(min))
+	assert.False(t, Version{0, 9, 6}.AtLeast(min))
+
+	assert.Equal(t, "0.14.2", min.String())
+}
+
+func TestNewFeatureGates(t *testing.T) {
+	tests := []struct {
+		version  Version
+		expected []Feature
+	}{
+		{version: Version{0, 9, 1}},
+		{version: Version{0, 9, 6}, expected: []Feature{FeatureExcludeLargerThan}},
+		{version: Version{0, 14, 0}, expected: []Feature{FeatureCompression, FeatureExcludeLargerThan, FeaturePackSize}},
+		{version: Version{0, 15, 1}, expected: []Feature{FeatureCompression, FeatureExcludeLargerThan, FeaturePackSize, FeatureReadConcurrency}},
+		{version: Version{0, 17, 0}, expected: Features()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.version.String(), func(t *testing.T) {
+			gates := NewFeatureGates(test.version)
+			assert.Len(t, gates, len(Features()))
+
+			var enabled []Feature
+			for _, feature := range Features() {
+				if gates.Enabled(feature) {
+					enabled = append(enabled, feature)
+				}
+			}
+			assert.Equal(t, test.expected, enabled)
+		})
+	}
+}
+
+func TestFeatureGatesUnknownVersion(t *testing.T) {
+	var gates FeatureGates
+	for _, feature := range Features() {
+		assert.True(t, gates.Enabled(feature), feature)
+	}
+}
+
+func TestFeatureMinVersion(t *testing.T) {
+	assert.Equal(t, Version{0, 9, 6}, FeatureExcludeLargerThan.MinVersion())
+	assert.Equal(t, Version{0, 17, 0}, FeatureBlockBackup.MinVersion())
+}
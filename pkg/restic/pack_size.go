@@ -0,0 +1,52 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"github.com/pkg/errors"
+)
+
+const (
+	// MinPackSize and MaxPackSize are the smallest and largest pack
+	// sizes, in MiB, that restic allows.
+	MinPackSize = 4
+	MaxPackSize = 128
+)
+
+// minPackSizeVersion is the first restic version that supports setting
+// the pack size.
+var minPackSizeVersion = Version{0, 14, 0}
+
+// ValidatePackSize returns an error if size, in MiB, is not zero (restic's
+// default) and not within restic's allowed range.
+func ValidatePackSize(size int) error {
+	if size == 0 {
+		return nil
+	}
+
+	if size < MinPackSize || size > MaxPackSize {
+		return errors.Errorf("pack size must be between %d and %d MiB, got %d", MinPackSize, MaxPackSize, size)
+	}
+
+	return nil
+}
+
+// SupportsPackSize returns true if the restic version, as output by
+// 'restic version', supports setting the pack size.
+func SupportsPackSize(version string) (bool, error) {
+	return versionAtLeast(version, minPackSizeVersion)
+}
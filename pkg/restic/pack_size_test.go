@@ -0,0 +1,45 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePackSize(t *testing.T) {
+	for _, size := range []int{0, 4, 16, 128} {
+		assert.NoError(t, ValidatePackSize(size))
+	}
+	assert.EqualError(t, ValidatePackSize(3), "pack size must be between 4 and 128 MiB, got 3")
+	assert.EqualError(t, ValidatePackSize(129), "pack size must be between 4 and 128 MiB, got 129")
+	assert.EqualError(t, ValidatePackSize(-1), "pack size must be between 4 and 128 MiB, got -1")
+}
+
+func TestSupportsPackSize(t *testing.T) {
+	supported, err := SupportsPackSize("restic 0.13.1 compiled with go1.18 on linux/amd64")
+	assert.NoError(t, err)
+	assert.False(t, supported)
+
+	supported, err = SupportsPackSize("restic 0.14.0 compiled with go1.19 on linux/amd64")
+	assert.NoError(t, err)
+	assert.True(t, supported)
+
+	_, err = SupportsPackSize("not restic")
+	assert.Error(t, err)
+}
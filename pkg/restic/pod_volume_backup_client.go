@@ -0,0 +1,163 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+	arkv1client "github.com/heptio/ark/pkg/generated/clientset/versioned/typed/ark/v1"
+)
+
+// PodVolumeBackupClient creates PodVolumeBackups and waits for the restic
+// servers to run them, for tools that back up pod volumes without an Ark
+// backup.
+type PodVolumeBackupClient interface {
+	// CreateAndWait creates a PodVolumeBackup with the given spec and waits
+	// for it to reach a terminal phase: Completed, CompletedDryRun, Failed
+	// or Canceled. The PodVolumeBackup is returned in whichever of those
+	// phases it reached, so callers must check it. An error is returned if
+	// it can't be created, is deleted, or doesn't finish before ctx is done
+	// or the client's timeout expires.
+	CreateAndWait(ctx context.Context, spec arkv1api.PodVolumeBackupSpec) (*arkv1api.PodVolumeBackup, error)
+}
+
+type podVolumeBackupClient struct {
+	client    arkv1client.PodVolumeBackupsGetter
+	namespace string
+	timeout   time.Duration
+}
+
+// NewPodVolumeBackupClient returns a PodVolumeBackupClient that creates
+// PodVolumeBackups in the given namespace, which must be the namespace the
+// Ark server runs in, and waits up to timeout for each to finish. If
+// timeout is zero, it waits until the context passed to CreateAndWait is
+// done.
+func NewPodVolumeBackupClient(client arkv1client.PodVolumeBackupsGetter, namespace string, timeout time.Duration) PodVolumeBackupClient {
+	return &podVolumeBackupClient{
+		client:    client,
+		namespace: namespace,
+		timeout:   timeout,
+	}
+}
+
+func (c *podVolumeBackupClient) CreateAndWait(ctx context.Context, spec arkv1api.PodVolumeBackupSpec) (*arkv1api.PodVolumeBackup, error) {
+	pvb := &arkv1api.PodVolumeBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:    c.namespace,
+			GenerateName: spec.Pod.Name + "-",
+		},
+		Spec: spec,
+	}
+
+	pvb, err := c.client.PodVolumeBackups(c.namespace).Create(pvb)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	// the API server closes watches after a while, so keep watching from
+	// the last version seen until the backup finishes.
+	resourceVersion := pvb.ResourceVersion
+	for {
+		var done *arkv1api.PodVolumeBackup
+		done, resourceVersion, err = c.watchUntilDone(ctx, pvb.Name, resourceVersion)
+		if err != nil || done != nil {
+			return done, err
+		}
+	}
+}
+
+// watchUntilDone watches the named PodVolumeBackup, starting from the given
+// resource version, and returns it once it's in a terminal phase. If the
+// watch ends first, it returns no PodVolumeBackup and the resource version
+// to resume watching from.
+func (c *podVolumeBackupClient) watchUntilDone(ctx context.Context, name, resourceVersion string) (*arkv1api.PodVolumeBackup, string, error) {
+	w, err := c.client.PodVolumeBackups(c.namespace).Watch(metav1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", name).String(),
+		ResourceVersion: resourceVersion,
+	})
+	if err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+	defer w.Stop()
+
+	// the backup may have finished before the watch started.
+	pvb, err := c.client.PodVolumeBackups(c.namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+	if podVolumeBackupDone(pvb) {
+		return pvb, "", nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, "", errors.Errorf("timed out waiting for PodVolumeBackup %s/%s to finish; it's in phase %s", c.namespace, name, pvb.Status.Phase)
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return nil, pvb.ResourceVersion, nil
+			}
+
+			switch event.Type {
+			case watch.Error:
+				// e.g. the resource version is too old to watch from;
+				// the restarted watch's get catches up.
+				return nil, "", nil
+			case watch.Deleted:
+				return nil, "", errors.Errorf("PodVolumeBackup %s/%s was deleted before it finished", c.namespace, name)
+			}
+
+			updated, ok := event.Object.(*arkv1api.PodVolumeBackup)
+			if !ok || updated.Name != name {
+				continue
+			}
+			pvb = updated
+
+			if podVolumeBackupDone(pvb) {
+				return pvb, "", nil
+			}
+		}
+	}
+}
+
+// podVolumeBackupDone returns true if the PodVolumeBackup is in a terminal
+// phase.
+func podVolumeBackupDone(pvb *arkv1api.PodVolumeBackup) bool {
+	switch pvb.Status.Phase {
+	case arkv1api.PodVolumeBackupPhaseCompleted,
+		arkv1api.PodVolumeBackupPhaseCompletedDryRun,
+		arkv1api.PodVolumeBackupPhaseFailed,
+		arkv1api.PodVolumeBackupPhaseCanceled:
+		return true
+	default:
+		return false
+	}
+}
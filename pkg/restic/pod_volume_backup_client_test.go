@@ -0,0 +1,125 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	core "k8s.io/client-go/testing"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/generated/clientset/versioned/fake"
+)
+
+func TestPodVolumeBackupClientCreateAndWait(t *testing.T) {
+	tests := []struct {
+		name          string
+		createdPhase  arkv1api.PodVolumeBackupPhase
+		phases        []arkv1api.PodVolumeBackupPhase
+		deleted       bool
+		expectedPhase arkv1api.PodVolumeBackupPhase
+		expectedErr   string
+	}{
+		{
+			name:          "backup that completes is returned",
+			phases:        []arkv1api.PodVolumeBackupPhase{arkv1api.PodVolumeBackupPhaseInProgress, arkv1api.PodVolumeBackupPhaseCompleted},
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+		{
+			name:          "backup that fails is returned",
+			phases:        []arkv1api.PodVolumeBackupPhase{arkv1api.PodVolumeBackupPhaseInProgress, arkv1api.PodVolumeBackupPhaseFailed},
+			expectedPhase: arkv1api.PodVolumeBackupPhaseFailed,
+		},
+		{
+			name:          "backup that finishes before it's watched is returned",
+			createdPhase:  arkv1api.PodVolumeBackupPhaseCompleted,
+			expectedPhase: arkv1api.PodVolumeBackupPhaseCompleted,
+		},
+		{
+			name:        "backup that doesn't finish times out",
+			phases:      []arkv1api.PodVolumeBackupPhase{arkv1api.PodVolumeBackupPhaseInProgress},
+			expectedErr: "timed out waiting for PodVolumeBackup heptio-ark/pod-1-abcde to finish; it's in phase InProgress",
+		},
+		{
+			name:        "backup that's deleted is an error",
+			phases:      []arkv1api.PodVolumeBackupPhase{arkv1api.PodVolumeBackupPhaseInProgress},
+			deleted:     true,
+			expectedErr: "PodVolumeBackup heptio-ark/pod-1-abcde was deleted before it finished",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+
+			// the fake clientset doesn't generate names.
+			client.PrependReactor("create", "podvolumebackups", func(action core.Action) (bool, runtime.Object, error) {
+				pvb := action.(core.CreateAction).GetObject().(*arkv1api.PodVolumeBackup)
+				pvb.Name = pvb.GenerateName + "abcde"
+				pvb.Status.Phase = test.createdPhase
+				return false, nil, nil
+			})
+
+			// act as the restic server, moving the backup through the
+			// test's phases once it's created.
+			go func() {
+				pvbs := client.ArkV1().PodVolumeBackups("heptio-ark")
+
+				var pvb *arkv1api.PodVolumeBackup
+				for pvb == nil {
+					pvb, _ = pvbs.Get("pod-1-abcde", metav1.GetOptions{})
+					time.Sleep(10 * time.Millisecond)
+				}
+
+				for _, phase := range test.phases {
+					pvb.Status.Phase = phase
+					pvb, _ = pvbs.Update(pvb)
+				}
+
+				if test.deleted {
+					pvbs.Delete(pvb.Name, nil)
+				}
+			}()
+
+			spec := arkv1api.PodVolumeBackupSpec{
+				Node:       "node-1",
+				Pod:        corev1api.ObjectReference{Kind: "Pod", Namespace: "ns-1", Name: "pod-1", UID: "pod-uid"},
+				Volume:     "vol-1",
+				RepoPrefix: "s3:s3.amazonaws.com/bucket/restic",
+			}
+
+			pvb, err := NewPodVolumeBackupClient(client.ArkV1(), "heptio-ark", time.Second).CreateAndWait(context.Background(), spec)
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "pod-1-abcde", pvb.Name)
+			assert.Equal(t, spec, pvb.Spec)
+			assert.Equal(t, test.expectedPhase, pvb.Status.Phase)
+		})
+	}
+}
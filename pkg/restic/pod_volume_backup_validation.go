@@ -0,0 +1,69 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+)
+
+// ValidatePodVolumeBackup returns the reasons, if any, that a
+// PodVolumeBackup is malformed and can't be backed up, so that it can be
+// rejected when it's created, e.g. by an admission webhook, or failed
+// before it's queued behind other backups. If podLister is non-nil, the
+// PodVolumeBackup's pod must exist, unless the PodVolumeBackup records the
+// definitions of the pod's volumes, which are enough to back them up once
+// the pod has been deleted.
+func ValidatePodVolumeBackup(pvb *arkv1api.PodVolumeBackup, podLister corev1listers.PodLister) []string {
+	var validationErrors []string
+
+	if pvb.Spec.Node == "" {
+		validationErrors = append(validationErrors, "node is required")
+	}
+
+	podRefComplete := true
+	if pvb.Spec.Pod.Namespace == "" {
+		validationErrors = append(validationErrors, "pod namespace is required")
+		podRefComplete = false
+	}
+	if pvb.Spec.Pod.Name == "" {
+		validationErrors = append(validationErrors, "pod name is required")
+		podRefComplete = false
+	}
+
+	if pvb.Spec.Volume == "" && len(pvb.Spec.Volumes) == 0 && pvb.Spec.VolumeSelector == nil {
+		validationErrors = append(validationErrors, "one of volume, volumes or volumeSelector is required")
+	}
+
+	if pvb.Spec.RepoPrefix == "" {
+		validationErrors = append(validationErrors, "repoPrefix is required")
+	}
+
+	if podLister != nil && podRefComplete && len(pvb.Spec.PodVolumes) == 0 {
+		// other errors are transient, and are reported if they recur when
+		// the backup is started.
+		if _, err := podLister.Pods(pvb.Spec.Pod.Namespace).Get(pvb.Spec.Pod.Name); apierrors.IsNotFound(err) {
+			validationErrors = append(validationErrors, fmt.Sprintf("pod %s/%s not found", pvb.Spec.Pod.Namespace, pvb.Spec.Pod.Name))
+		}
+	}
+
+	return validationErrors
+}
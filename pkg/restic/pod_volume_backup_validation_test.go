@@ -0,0 +1,140 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+)
+
+func TestValidatePodVolumeBackup(t *testing.T) {
+	validSpec := func() arkv1api.PodVolumeBackupSpec {
+		return arkv1api.PodVolumeBackupSpec{
+			Node:       "node-1",
+			Pod:        corev1api.ObjectReference{Namespace: "ns-1", Name: "pod-1"},
+			Volume:     "vol-1",
+			RepoPrefix: "s3:s3.amazonaws.com/bucket",
+		}
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(*arkv1api.PodVolumeBackupSpec)
+		expected []string
+	}{
+		{
+			name:   "valid",
+			modify: func(*arkv1api.PodVolumeBackupSpec) {},
+		},
+		{
+			name:     "missing node",
+			modify:   func(s *arkv1api.PodVolumeBackupSpec) { s.Node = "" },
+			expected: []string{"node is required"},
+		},
+		{
+			name:     "missing pod namespace",
+			modify:   func(s *arkv1api.PodVolumeBackupSpec) { s.Pod.Namespace = "" },
+			expected: []string{"pod namespace is required"},
+		},
+		{
+			name:     "missing pod name",
+			modify:   func(s *arkv1api.PodVolumeBackupSpec) { s.Pod.Name = "" },
+			expected: []string{"pod name is required"},
+		},
+		{
+			name:     "no volumes",
+			modify:   func(s *arkv1api.PodVolumeBackupSpec) { s.Volume = "" },
+			expected: []string{"one of volume, volumes or volumeSelector is required"},
+		},
+		{
+			name: "volumes",
+			modify: func(s *arkv1api.PodVolumeBackupSpec) {
+				s.Volume = ""
+				s.Volumes = []string{"vol-1", "vol-2"}
+			},
+		},
+		{
+			name: "volume selector",
+			modify: func(s *arkv1api.PodVolumeBackupSpec) {
+				s.Volume = ""
+				s.VolumeSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"backup": "true"}}
+			},
+		},
+		{
+			name:     "empty repoPrefix",
+			modify:   func(s *arkv1api.PodVolumeBackupSpec) { s.RepoPrefix = "" },
+			expected: []string{"repoPrefix is required"},
+		},
+		{
+			name:     "nonexistent pod",
+			modify:   func(s *arkv1api.PodVolumeBackupSpec) { s.Pod.Name = "pod-2" },
+			expected: []string{"pod ns-1/pod-2 not found"},
+		},
+		{
+			name: "nonexistent pod whose volumes are recorded",
+			modify: func(s *arkv1api.PodVolumeBackupSpec) {
+				s.Pod.Name = "pod-2"
+				s.PodVolumes = []corev1api.Volume{{Name: "vol-1"}}
+			},
+		},
+		{
+			name:   "several problems",
+			modify: func(s *arkv1api.PodVolumeBackupSpec) { *s = arkv1api.PodVolumeBackupSpec{} },
+			expected: []string{
+				"node is required",
+				"pod namespace is required",
+				"pod name is required",
+				"one of volume, volumes or volumeSelector is required",
+				"repoPrefix is required",
+			},
+		},
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	require.NoError(t, indexer.Add(&corev1api.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "pod-1"}}))
+	podLister := corev1listers.NewPodLister(indexer)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pvb := &arkv1api.PodVolumeBackup{Spec: validSpec()}
+			test.modify(&pvb.Spec)
+
+			assert.Equal(t, test.expected, ValidatePodVolumeBackup(pvb, podLister))
+		})
+	}
+}
+
+func TestValidatePodVolumeBackupWithoutPodLister(t *testing.T) {
+	pvb := &arkv1api.PodVolumeBackup{
+		Spec: arkv1api.PodVolumeBackupSpec{
+			Node:       "node-1",
+			Pod:        corev1api.ObjectReference{Namespace: "ns-1", Name: "pod-2"},
+			Volume:     "vol-1",
+			RepoPrefix: "s3:s3.amazonaws.com/bucket",
+		},
+	}
+
+	assert.Empty(t, ValidatePodVolumeBackup(pvb, nil))
+}
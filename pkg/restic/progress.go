@@ -0,0 +1,130 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// BackupStatus is a progress update emitted by 'restic backup --json'.
+type BackupStatus struct {
+	MessageType string  `json:"message_type"`
+	PercentDone float64 `json:"percent_done"`
+	TotalBytes  int64   `json:"total_bytes"`
+	BytesDone   int64   `json:"bytes_done"`
+}
+
+// ParseBackupStatusLine parses a single line of 'restic backup --json'
+// output. It returns false if the line is not a well-formed status
+// message.
+func ParseBackupStatusLine(line []byte) (BackupStatus, bool) {
+	var status BackupStatus
+
+	if err := json.Unmarshal(bytes.TrimSpace(line), &status); err != nil {
+		return BackupStatus{}, false
+	}
+
+	if status.MessageType != "status" {
+		return BackupStatus{}, false
+	}
+
+	return status, true
+}
+
+// BackupSummary is the summary message emitted by 'restic backup --json'
+// once a backup has completed.
+type BackupSummary struct {
+	MessageType         string  `json:"message_type"`
+	FilesNew            int64   `json:"files_new"`
+	FilesChanged        int64   `json:"files_changed"`
+	FilesUnmodified     int64   `json:"files_unmodified"`
+	DirsNew             int64   `json:"dirs_new"`
+	DirsChanged         int64   `json:"dirs_changed"`
+	DirsUnmodified      int64   `json:"dirs_unmodified"`
+	DataAdded           int64   `json:"data_added"`
+	TotalFilesProcessed int64   `json:"total_files_processed"`
+	TotalBytesProcessed int64   `json:"total_bytes_processed"`
+	TotalDuration       float64 `json:"total_duration"`
+	SnapshotID          string  `json:"snapshot_id"`
+}
+
+// ParseBackupSummaryLine parses a single line of 'restic backup --json'
+// output. It returns false if the line is not a well-formed summary
+// message.
+func ParseBackupSummaryLine(line []byte) (BackupSummary, bool) {
+	var summary BackupSummary
+
+	if err := json.Unmarshal(bytes.TrimSpace(line), &summary); err != nil {
+		return BackupSummary{}, false
+	}
+
+	if summary.MessageType != "summary" {
+		return BackupSummary{}, false
+	}
+
+	return summary, true
+}
+
+// ParseBackupSummary returns the last summary message in the provided
+// 'restic backup --json' output, or false if there is none.
+func ParseBackupSummary(output string) (BackupSummary, bool) {
+	lines := strings.Split(output, "\n")
+
+	for i := len(lines) - 1; i >= 0; i-- {
+		if summary, ok := ParseBackupSummaryLine([]byte(lines[i])); ok {
+			return summary, true
+		}
+	}
+
+	return BackupSummary{}, false
+}
+
+// progressWriter is an io.Writer that splits 'restic backup --json'
+// output into lines and invokes a callback for each status message.
+type progressWriter struct {
+	buf      bytes.Buffer
+	onStatus func(BackupStatus)
+}
+
+// NewProgressWriter returns an io.Writer, suitable for use as a restic
+// backup command's stdout, that calls onStatus for every status message
+// written to it. Partial lines are buffered until they're completed by
+// a subsequent write.
+func NewProgressWriter(onStatus func(BackupStatus)) io.Writer {
+	return &progressWriter{onStatus: onStatus}
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+
+	for {
+		idx := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if idx < 0 {
+			break
+		}
+
+		line := w.buf.Next(idx + 1)
+		if status, ok := ParseBackupStatusLine(line); ok {
+			w.onStatus(status)
+		}
+	}
+
+	return len(p), nil
+}
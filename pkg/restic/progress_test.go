@@ -0,0 +1,181 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBackupStatusLine(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		expectedOK bool
+		expected   BackupStatus
+	}{
+		{
+			name:       "status message",
+			line:       `{"message_type":"status","percent_done":0.5,"total_files":10,"files_done":5,"total_bytes":2048,"bytes_done":1024}`,
+			expectedOK: true,
+			expected: BackupStatus{
+				MessageType: "status",
+				PercentDone: 0.5,
+				TotalBytes:  2048,
+				BytesDone:   1024,
+			},
+		},
+		{
+			name:       "status message with trailing newline",
+			line:       "{\"message_type\":\"status\",\"percent_done\":1,\"total_bytes\":10,\"bytes_done\":10}\n",
+			expectedOK: true,
+			expected: BackupStatus{
+				MessageType: "status",
+				PercentDone: 1,
+				TotalBytes:  10,
+				BytesDone:   10,
+			},
+		},
+		{
+			name: "summary message is ignored",
+			line: `{"message_type":"summary","files_new":1,"snapshot_id":"abc123"}`,
+		},
+		{
+			name: "truncated json",
+			line: `{"message_type":"status","percent_do`,
+		},
+		{
+			name: "non-json output",
+			line: "scan finished in 0.253s: 3 files, 1.024 KiB",
+		},
+		{
+			name: "empty line",
+			line: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, ok := ParseBackupStatusLine([]byte(test.line))
+			assert.Equal(t, test.expectedOK, ok)
+			assert.Equal(t, test.expected, status)
+		})
+	}
+}
+
+func TestProgressWriter(t *testing.T) {
+	var statuses []BackupStatus
+	w := NewProgressWriter(func(status BackupStatus) {
+		statuses = append(statuses, status)
+	})
+
+	writes := []string{
+		`{"message_type":"status","total_bytes":100,"bytes_done":10}` + "\n",
+		// a message split across multiple writes
+		`{"message_type":"status",`,
+		`"total_bytes":100,"bytes_done":50}` + "\n" + `not json` + "\n",
+		// multiple messages in a single write
+		`{"message_type":"status","total_bytes":100,"bytes_done":75}` + "\n" + `{"message_type":"status","total_bytes":100,"bytes_done":100}` + "\n",
+		// an incomplete trailing line is never reported
+		`{"message_type":"status","total_bytes":100,"bytes_done":1`,
+	}
+
+	for _, data := range writes {
+		n, err := w.Write([]byte(data))
+		assert.NoError(t, err)
+		assert.Equal(t, len(data), n)
+	}
+
+	var bytesDone []int64
+	for _, status := range statuses {
+		bytesDone = append(bytesDone, status.BytesDone)
+	}
+
+	assert.Equal(t, []int64{10, 50, 75, 100}, bytesDone)
+}
+
+func TestParseBackupSummary(t *testing.T) {
+	tests := []struct {
+		name       string
+		output     string
+		expectedOK bool
+		expected   BackupSummary
+	}{
+		{
+			name: "output of a first backup",
+			output: `{"message_type":"status","percent_done":0,"total_files":1,"total_bytes":4096}
+{"message_type":"status","percent_done":0.5,"total_files":3,"files_done":1,"total_bytes":8192,"bytes_done":4096,"current_files":["/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1/data.db"]}
+{"message_type":"summary","files_new":3,"files_changed":0,"files_unmodified":0,"dirs_new":2,"dirs_changed":0,"dirs_unmodified":0,"data_blobs":3,"tree_blobs":3,"data_added":8704,"total_files_processed":3,"total_bytes_processed":8192,"total_duration":0.438275214,"snapshot_id":"d3a6c2a1"}
+`,
+			expectedOK: true,
+			expected: BackupSummary{
+				MessageType:         "summary",
+				FilesNew:            3,
+				DirsNew:             2,
+				DataAdded:           8704,
+				TotalFilesProcessed: 3,
+				TotalBytesProcessed: 8192,
+				TotalDuration:       0.438275214,
+				SnapshotID:          "d3a6c2a1",
+			},
+		},
+		{
+			name: "output of an incremental backup without a trailing newline",
+			output: `{"message_type":"status","percent_done":1,"total_files":3,"files_done":3,"total_bytes":8192,"bytes_done":8192}
+{"message_type":"summary","files_new":0,"files_changed":1,"files_unmodified":2,"dirs_new":0,"dirs_changed":1,"dirs_unmodified":1,"data_blobs":1,"tree_blobs":2,"data_added":1337,"total_files_processed":3,"total_bytes_processed":8192,"total_duration":0.201837116,"snapshot_id":"8f1e0b9c"}`,
+			expectedOK: true,
+			expected: BackupSummary{
+				MessageType:         "summary",
+				FilesChanged:        1,
+				FilesUnmodified:     2,
+				DirsChanged:         1,
+				DirsUnmodified:      1,
+				DataAdded:           1337,
+				TotalFilesProcessed: 3,
+				TotalBytesProcessed: 8192,
+				TotalDuration:       0.201837116,
+				SnapshotID:          "8f1e0b9c",
+			},
+		},
+		{
+			name: "output without a summary",
+			output: `{"message_type":"status","percent_done":0,"total_files":1,"total_bytes":4096}
+`,
+		},
+		{
+			name: "non-json output",
+			output: `scan [/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1]
+scanned 2 directories, 3 files in 0:00
+[0:00] 100.00%  8.000 KiB / 8.000 KiB  5 / 5 items  0 errors  ETA 0:00
+duration: 0:00
+snapshot d3a6c2a1 saved
+`,
+		},
+		{
+			name: "empty output",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			summary, ok := ParseBackupSummary(test.output)
+			assert.Equal(t, test.expectedOK, ok)
+			assert.Equal(t, test.expected, summary)
+		})
+	}
+}
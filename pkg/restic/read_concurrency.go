@@ -0,0 +1,42 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"github.com/pkg/errors"
+)
+
+// minReadConcurrencyVersion is the first restic version that supports
+// setting the number of files read concurrently during a backup.
+var minReadConcurrencyVersion = Version{0, 15, 0}
+
+// ValidateReadConcurrency returns an error if n, the number of files restic
+// reads concurrently during a backup, is negative. Zero means restic's
+// default.
+func ValidateReadConcurrency(n int) error {
+	if n < 0 {
+		return errors.Errorf("read concurrency must not be negative, got %d", n)
+	}
+
+	return nil
+}
+
+// SupportsReadConcurrency returns true if the restic version, as output by
+// 'restic version', supports setting the backup read concurrency.
+func SupportsReadConcurrency(version string) (bool, error) {
+	return versionAtLeast(version, minReadConcurrencyVersion)
+}
@@ -0,0 +1,354 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// RepoLeaseLabel is the label on the ConfigMaps that hold restic
+	// repository leases.
+	RepoLeaseLabel = "ark.heptio.com/restic-repo-lease"
+
+	// leaseRepoKey is the key, in a lease ConfigMap's data, of the
+	// identifier of the repository it's the lease of.
+	leaseRepoKey = "repository"
+
+	// the prefixes of the keys, in a lease ConfigMap's data, of the shared
+	// and exclusive leases, each followed by its holder's name and with the
+	// time the lease was last renewed as its value.
+	sharedLeasePrefix    = "shared."
+	exclusiveLeasePrefix = "exclusive."
+
+	// maxLeaseReleaseAttempts is the number of times to try to remove a
+	// released lease from its ConfigMap when it's updated concurrently.
+	maxLeaseReleaseAttempts = 3
+)
+
+// RepoLeaser coordinates access to restic repositories between the restic
+// servers on different nodes. Restic's own locks are only held for the
+// duration of a single command, so a prune started on one node fails the
+// backups running on other nodes, and vice versa. Backups take a shared lease
+// of their repository, and maintenance operations, such as prune, take an
+// exclusive one, which can only be held while no other node holds any lease.
+//
+// Each repository's leases are recorded in a ConfigMap, since this version of
+// Kubernetes has no Lease API, and updated with optimistic concurrency. A
+// holder renews its leases until they're released; leases that haven't been
+// renewed within the lease duration, e.g. because their holder's node went
+// away, are ignored and removed. Leases taken by several backups on the same
+// node are counted, and only released once all of them are.
+type RepoLeaser struct {
+	configMaps  corev1client.ConfigMapInterface
+	holder      string
+	duration    time.Duration
+	retryPeriod time.Duration
+	clock       clock.Clock
+	logger      logrus.FieldLogger
+
+	mu     sync.Mutex
+	leases map[string]*heldLease
+}
+
+// heldLease is a lease of a repository held by this RepoLeaser.
+type heldLease struct {
+	exclusive bool
+	count     int
+	stop      chan struct{}
+}
+
+// NewRepoLeaser returns a RepoLeaser that records leases, held by holder,
+// in ConfigMaps using configMaps. Leases expire if they're not renewed
+// within duration, and are renewed three times as often.
+func NewRepoLeaser(configMaps corev1client.ConfigMapInterface, holder string, duration time.Duration, logger logrus.FieldLogger) *RepoLeaser {
+	return &RepoLeaser{
+		configMaps:  configMaps,
+		holder:      holder,
+		duration:    duration,
+		retryPeriod: duration / 3,
+		clock:       clock.RealClock{},
+		logger:      logger,
+		leases:      make(map[string]*heldLease),
+	}
+}
+
+// AcquireShared blocks until this holder has a shared lease of the
+// repository, i.e. until no other holder has an exclusive lease of it, or
+// ctx is done. It returns a function that releases the lease.
+func (l *RepoLeaser) AcquireShared(ctx context.Context, repo string) (func(), error) {
+	for {
+		acquired, err := l.acquire(repo, false)
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			return func() { l.release(repo) }, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(), "error waiting for a shared lease of restic repository %s", repo)
+		case <-l.clock.After(l.retryPeriod):
+		}
+	}
+}
+
+// TryAcquireExclusive tries once to acquire an exclusive lease of the
+// repository, returning a function that releases it and true if it's
+// acquired, or false if any other holder has a lease of the repository or
+// this holder is using it for backups.
+func (l *RepoLeaser) TryAcquireExclusive(repo string) (func(), bool, error) {
+	acquired, err := l.acquire(repo, true)
+	if err != nil || !acquired {
+		return nil, false, err
+	}
+
+	return func() { l.release(repo) }, true, nil
+}
+
+// acquire tries once to acquire a lease of the repository, returning false
+// if it conflicts with a lease held by this or another holder.
+func (l *RepoLeaser) acquire(repo string, exclusive bool) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if held, ok := l.leases[repo]; ok {
+		if exclusive || held.exclusive {
+			return false, nil
+		}
+
+		held.count++
+		return true, nil
+	}
+
+	acquired, err := l.update(repo, exclusive, true)
+	if err != nil || !acquired {
+		return false, err
+	}
+
+	held := &heldLease{exclusive: exclusive, count: 1, stop: make(chan struct{})}
+	l.leases[repo] = held
+	go l.renew(repo, held)
+
+	return true, nil
+}
+
+// release releases one of this holder's leases of the repository, and
+// removes the lease from its ConfigMap once none are left. Errors are
+// logged, since the lease expires if it can't be removed.
+func (l *RepoLeaser) release(repo string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	held, ok := l.leases[repo]
+	if !ok {
+		return
+	}
+
+	held.count--
+	if held.count > 0 {
+		return
+	}
+	delete(l.leases, repo)
+	close(held.stop)
+
+	if err := l.remove(repo, held.exclusive); err != nil {
+		l.logger.WithError(err).WithField("repository", repo).Warn("Error releasing restic repository lease; it will expire")
+	}
+}
+
+// renew renews a held lease every retryPeriod until it's released.
+func (l *RepoLeaser) renew(repo string, held *heldLease) {
+	for {
+		select {
+		case <-held.stop:
+			return
+		case <-l.clock.After(l.retryPeriod):
+		}
+
+		renewed, err := l.renewOnce(repo, held)
+		if err != nil {
+			l.logger.WithError(err).WithField("repository", repo).Warn("Error renewing restic repository lease")
+		} else if !renewed {
+			l.logger.WithField("repository", repo).Debug("Restic repository lease was updated concurrently, renewing it later")
+		}
+	}
+}
+
+// renewOnce renews a held lease unless it's been released. It holds the
+// RepoLeaser's lock so a renewal can't recreate a lease as it's released.
+func (l *RepoLeaser) renewOnce(repo string, held *heldLease) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-held.stop:
+		return true, nil
+	default:
+	}
+
+	return l.update(repo, held.exclusive, false)
+}
+
+// update records in the repository's lease ConfigMap, creating it if needed,
+// that this holder's lease was renewed now, and removes expired leases. When
+// acquiring, it returns false without updating the ConfigMap if the lease
+// conflicts with another holder's. It also returns false if the ConfigMap
+// was created or updated concurrently.
+func (l *RepoLeaser) update(repo string, exclusive, acquiring bool) (bool, error) {
+	now := l.clock.Now()
+	key := leaseKey(l.holder, exclusive)
+
+	configMap, err := l.configMaps.Get(repoLeaseName(repo), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		configMap = &corev1api.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   repoLeaseName(repo),
+				Labels: map[string]string{RepoLeaseLabel: "true"},
+			},
+			Data: map[string]string{
+				leaseRepoKey: repo,
+				key:          now.Format(time.RFC3339Nano),
+			},
+		}
+
+		if _, err := l.configMaps.Create(configMap); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				return false, nil
+			}
+			return false, errors.Wrapf(err, "error creating lease of restic repository %s", repo)
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "error getting lease of restic repository %s", repo)
+	}
+
+	updated := configMap.DeepCopy()
+	if updated.Data == nil {
+		updated.Data = make(map[string]string)
+	}
+	for k, renewed := range configMap.Data {
+		holder, otherExclusive, ok := parseLeaseKey(k)
+		if !ok || k == key {
+			continue
+		}
+
+		if l.expired(renewed, now) {
+			delete(updated.Data, k)
+			continue
+		}
+
+		if acquiring && holder != l.holder && (exclusive || otherExclusive) {
+			return false, nil
+		}
+	}
+	updated.Data[key] = now.Format(time.RFC3339Nano)
+
+	if _, err := l.configMaps.Update(updated); err != nil {
+		if apierrors.IsConflict(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "error updating lease of restic repository %s", repo)
+	}
+
+	return true, nil
+}
+
+// remove removes this holder's lease from the repository's lease ConfigMap.
+func (l *RepoLeaser) remove(repo string, exclusive bool) error {
+	key := leaseKey(l.holder, exclusive)
+
+	for attempt := 1; ; attempt++ {
+		configMap, err := l.configMaps.Get(repoLeaseName(repo), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error getting lease of restic repository %s", repo)
+		}
+
+		if _, ok := configMap.Data[key]; !ok {
+			return nil
+		}
+
+		updated := configMap.DeepCopy()
+		delete(updated.Data, key)
+
+		_, err = l.configMaps.Update(updated)
+		if err == nil {
+			return nil
+		}
+		if !apierrors.IsConflict(err) || attempt >= maxLeaseReleaseAttempts {
+			return errors.Wrapf(err, "error updating lease of restic repository %s", repo)
+		}
+	}
+}
+
+// expired returns true if a lease last renewed at the given time, as
+// recorded in a lease ConfigMap, has expired.
+func (l *RepoLeaser) expired(renewed string, now time.Time) bool {
+	renewTime, err := time.Parse(time.RFC3339Nano, renewed)
+	if err != nil {
+		return true
+	}
+
+	return now.Sub(renewTime) > l.duration
+}
+
+// repoLeaseName returns the name of the ConfigMap holding the repository's
+// leases. Repository identifiers aren't valid object names, so the name
+// is derived from a hash of the identifier.
+func repoLeaseName(repo string) string {
+	return fmt.Sprintf("restic-lease-%x", sha256.Sum256([]byte(repo)))[:len("restic-lease-")+20]
+}
+
+func leaseKey(holder string, exclusive bool) string {
+	if exclusive {
+		return exclusiveLeasePrefix + holder
+	}
+	return sharedLeasePrefix + holder
+}
+
+// parseLeaseKey returns the holder of the lease with the given key in a
+// lease ConfigMap's data, whether it's exclusive, and false if the key
+// isn't that of a lease.
+func parseLeaseKey(key string) (string, bool, bool) {
+	switch {
+	case strings.HasPrefix(key, exclusiveLeasePrefix):
+		return strings.TrimPrefix(key, exclusiveLeasePrefix), true, true
+	case strings.HasPrefix(key, sharedLeasePrefix):
+		return strings.TrimPrefix(key, sharedLeasePrefix), false, true
+	default:
+		return "", false, false
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	arktest "github.com/heptio/ark/pkg/util/test"
+)
+
+// leaseHolders returns the sorted keys of the leases recorded for repo.
+func leaseHolders(configMaps corev1client.ConfigMapInterface, repo string) []string {
+	var keys []string
+	if configMap, err := configMaps.Get(repoLeaseName(repo), metav1.GetOptions{}); err == nil {
+		for key := range configMap.Data {
+			if _, _, ok := parseLeaseKey(key); ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func newTestRepoLeaser(configMaps corev1client.ConfigMapInterface, holder string, now time.Time) *RepoLeaser {
+	leaser := NewRepoLeaser(configMaps, holder, time.Minute, arktest.NewLogger())
+	leaser.clock = clock.NewFakeClock(now)
+	return leaser
+}
+
+func TestRepoLeaserContention(t *testing.T) {
+	var (
+		configMaps = arktest.NewFakeConfigMaps()
+		now        = time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+		node1      = newTestRepoLeaser(configMaps, "node-1", now)
+		node2      = newTestRepoLeaser(configMaps, "node-2", now)
+		repo       = "s3:bucket/ns-1"
+	)
+
+	// an already done context makes AcquireShared try only once.
+	done, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// backups on both nodes share the repository.
+	release1, err := node1.AcquireShared(context.Background(), repo)
+	require.NoError(t, err)
+	release2, err := node2.AcquireShared(context.Background(), repo)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shared.node-1", "shared.node-2"}, leaseHolders(configMaps, repo))
+
+	configMap, err := configMaps.Get(repoLeaseName(repo), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, repo, configMap.Data[leaseRepoKey])
+	assert.Equal(t, "true", configMap.Labels[RepoLeaseLabel])
+
+	// neither node can prune while both are backing up, including the
+	// node holding a shared lease itself.
+	_, acquired, err := node1.TryAcquireExclusive(repo)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+
+	release2()
+	_, acquired, err = node1.TryAcquireExclusive(repo)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+
+	release1()
+	assert.Empty(t, leaseHolders(configMaps, repo))
+
+	// once the backups are done, a node can prune, and other nodes'
+	// backups and prunes must wait for it.
+	releaseExclusive, acquired, err := node1.TryAcquireExclusive(repo)
+	require.NoError(t, err)
+	require.True(t, acquired)
+	assert.Equal(t, []string{"exclusive.node-1"}, leaseHolders(configMaps, repo))
+
+	_, err = node2.AcquireShared(done, repo)
+	assert.Error(t, err)
+	_, acquired, err = node2.TryAcquireExclusive(repo)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+
+	// other repositories are unaffected.
+	releaseOther, err := node2.AcquireShared(done, "s3:bucket/ns-2")
+	require.NoError(t, err)
+	releaseOther()
+
+	releaseExclusive()
+	release2, err = node2.AcquireShared(done, repo)
+	require.NoError(t, err)
+	release2()
+	assert.Empty(t, leaseHolders(configMaps, repo))
+}
+
+func TestRepoLeaserCountsLeasesOnTheSameNode(t *testing.T) {
+	configMaps := arktest.NewFakeConfigMaps()
+	leaser := newTestRepoLeaser(configMaps, "node-1", time.Now())
+	repo := "s3:bucket/ns-1"
+
+	release1, err := leaser.AcquireShared(context.Background(), repo)
+	require.NoError(t, err)
+	release2, err := leaser.AcquireShared(context.Background(), repo)
+	require.NoError(t, err)
+
+	release1()
+	assert.Equal(t, []string{"shared.node-1"}, leaseHolders(configMaps, repo))
+
+	release2()
+	assert.Empty(t, leaseHolders(configMaps, repo))
+}
+
+func TestRepoLeaserIgnoresExpiredLeases(t *testing.T) {
+	configMaps := arktest.NewFakeConfigMaps()
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	repo := "s3:bucket/ns-1"
+
+	// node-2 crashed while pruning, and node-3's backup is still running.
+	_, err := configMaps.Create(&corev1api.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: repoLeaseName(repo)},
+		Data: map[string]string{
+			leaseRepoKey:       repo,
+			"exclusive.node-2": now.Add(-2 * time.Minute).Format(time.RFC3339Nano),
+			"shared.node-3":    now.Add(-30 * time.Second).Format(time.RFC3339Nano),
+		},
+	})
+	require.NoError(t, err)
+
+	leaser := newTestRepoLeaser(configMaps, "node-1", now)
+
+	_, acquired, err := leaser.TryAcquireExclusive(repo)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+
+	release, err := leaser.AcquireShared(context.Background(), repo)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shared.node-1", "shared.node-3"}, leaseHolders(configMaps, repo))
+	release()
+}
+
+func TestRepoLeaserRetriesConflicts(t *testing.T) {
+	configMaps := arktest.NewFakeConfigMaps()
+	repo := "s3:bucket/ns-1"
+
+	// the lease ConfigMap exists, so acquiring a lease updates it, and the
+	// first two updates conflict with concurrent ones.
+	_, err := configMaps.Create(&corev1api.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: repoLeaseName(repo)},
+		Data:       map[string]string{leaseRepoKey: repo},
+	})
+	require.NoError(t, err)
+	configMaps.Conflicts = 2
+
+	leaser := NewRepoLeaser(configMaps, "node-1", time.Minute, arktest.NewLogger())
+	leaser.retryPeriod = time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	release, err := leaser.AcquireShared(ctx, repo)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shared.node-1"}, leaseHolders(configMaps, repo))
+	release()
+}
+
+func TestRepoLeaseName(t *testing.T) {
+	name := repoLeaseName("s3:s3.amazonaws.com/bucket/restic/ns-1")
+	assert.Len(t, name, len("restic-lease-")+20)
+	assert.Equal(t, name, repoLeaseName("s3:s3.amazonaws.com/bucket/restic/ns-1"))
+	assert.NotEqual(t, name, repoLeaseName("s3:s3.amazonaws.com/bucket/restic/ns-2"))
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IsRepoPrefixTemplate returns true if the provided repository prefix is a Go
+// template to be resolved with ResolveRepoPrefix.
+func IsRepoPrefixTemplate(prefix string) bool {
+	return strings.Contains(prefix, "{{")
+}
+
+// ResolveRepoPrefix executes the provided repository prefix, if it's a Go
+// template, against the metadata of the provided pod, e.g.
+// s3:s3.amazonaws.com/bucket/{{.Labels.team}}/restic. Labels and annotations
+// that the pod doesn't have resolve to empty strings. The resolved prefix is
+// validated with ValidateRepoPrefix. Prefixes that aren't templates are
+// returned as-is.
+func ResolveRepoPrefix(prefix string, pod metav1.Object) (string, error) {
+	if !IsRepoPrefixTemplate(prefix) {
+		return prefix, nil
+	}
+
+	tmpl, err := template.New("repoPrefix").Option("missingkey=zero").Parse(prefix)
+	if err != nil {
+		return "", errors.Wrap(err, "error parsing repository prefix template")
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, newPodTemplateData(pod)); err != nil {
+		return "", errors.Wrap(err, "error executing repository prefix template")
+	}
+
+	resolved := buf.String()
+	if err := ValidateRepoPrefix(resolved); err != nil {
+		return "", errors.Wrapf(err, "repository prefix template %s resolved to an invalid prefix", prefix)
+	}
+
+	return resolved, nil
+}
+
+// ValidateRepoPrefix returns an error if the provided repository prefix is
+// empty, contains whitespace or control characters, ends with a slash, or has
+// an empty, "." or ".." path segment, as happens when a template references
+// a label that the pod doesn't have.
+func ValidateRepoPrefix(prefix string) error {
+	if prefix == "" {
+		return errors.New("prefix is empty")
+	}
+
+	for _, r := range prefix {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return errors.Errorf("prefix %q must not contain whitespace or control characters", prefix)
+		}
+	}
+
+	if strings.HasSuffix(prefix, "/") {
+		return errors.Errorf("prefix %s must not end with a slash", prefix)
+	}
+
+	// a URL's scheme separator, e.g. in s3:http://minio:9000/bucket, is
+	// the only place an empty segment is expected.
+	path := strings.Replace(prefix, "://", ":", -1)
+	for _, segment := range strings.Split(path, "/")[1:] {
+		switch segment {
+		case "":
+			return errors.Errorf("prefix %s must not contain empty path segments", prefix)
+		case ".", "..":
+			return errors.Errorf("prefix %s must not contain %q path segments", prefix, segment)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestResolveRepoPrefix(t *testing.T) {
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			Labels: map[string]string{
+				"team":                   "payments",
+				"app.kubernetes.io/name": "postgres",
+			},
+			Annotations: map[string]string{
+				"example.com/cluster": "prod-east",
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		prefix      string
+		expected    string
+		expectedErr string
+	}{
+		{
+			name:     "plain prefixes are used as-is",
+			prefix:   "s3:s3.amazonaws.com/bucket/restic",
+			expected: "s3:s3.amazonaws.com/bucket/restic",
+		},
+		{
+			name:     "templates are resolved from pod metadata",
+			prefix:   `s3:s3.amazonaws.com/bucket/{{index .Annotations "example.com/cluster"}}/{{.Labels.team}}/{{.Namespace}}`,
+			expected: "s3:s3.amazonaws.com/bucket/prod-east/payments/ns-1",
+		},
+		{
+			name:     "label keys with special characters can be used with index",
+			prefix:   `gs:bucket:/restic/{{index .Labels "app.kubernetes.io/name"}}`,
+			expected: "gs:bucket:/restic/postgres",
+		},
+		{
+			name:     "URL schemes are allowed",
+			prefix:   "s3:http://minio:9000/bucket/{{.Labels.team}}",
+			expected: "s3:http://minio:9000/bucket/payments",
+		},
+		{
+			name:        "a missing label in the middle of the prefix is an error",
+			prefix:      "s3:s3.amazonaws.com/bucket/{{.Labels.tier}}/restic",
+			expectedErr: "repository prefix template s3:s3.amazonaws.com/bucket/{{.Labels.tier}}/restic resolved to an invalid prefix: prefix s3:s3.amazonaws.com/bucket//restic must not contain empty path segments",
+		},
+		{
+			name:        "a missing label at the end of the prefix is an error",
+			prefix:      "s3:s3.amazonaws.com/bucket/{{.Labels.tier}}",
+			expectedErr: "repository prefix template s3:s3.amazonaws.com/bucket/{{.Labels.tier}} resolved to an invalid prefix: prefix s3:s3.amazonaws.com/bucket/ must not end with a slash",
+		},
+		{
+			name:        "a template that resolves to nothing is an error",
+			prefix:      "{{.Labels.tier}}",
+			expectedErr: "repository prefix template {{.Labels.tier}} resolved to an invalid prefix: prefix is empty",
+		},
+		{
+			name:        "an invalid template is an error",
+			prefix:      "s3:s3.amazonaws.com/bucket/{{.Labels.team",
+			expectedErr: "error parsing repository prefix template",
+		},
+		{
+			name:        "a template that fails to execute is an error",
+			prefix:      "s3:s3.amazonaws.com/bucket/{{.Missing}}",
+			expectedErr: "error executing repository prefix template",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := ResolveRepoPrefix(test.prefix, pod)
+			if test.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
+
+func TestValidateRepoPrefix(t *testing.T) {
+	tests := []struct {
+		name        string
+		prefix      string
+		expectedErr string
+	}{
+		{
+			name:   "s3",
+			prefix: "s3:s3.amazonaws.com/bucket/restic",
+		},
+		{
+			name:   "s3 with a URL",
+			prefix: "s3:https://minio.example.com:9000/bucket",
+		},
+		{
+			name:   "azure",
+			prefix: "azure:container:/restic",
+		},
+		{
+			name:   "local",
+			prefix: "/srv/restic",
+		},
+		{
+			name:        "empty",
+			prefix:      "",
+			expectedErr: "prefix is empty",
+		},
+		{
+			name:        "whitespace",
+			prefix:      "s3:s3.amazonaws.com/bucket/my team",
+			expectedErr: `prefix "s3:s3.amazonaws.com/bucket/my team" must not contain whitespace or control characters`,
+		},
+		{
+			name:        "control characters",
+			prefix:      "s3:s3.amazonaws.com/bucket/team\n",
+			expectedErr: `prefix "s3:s3.amazonaws.com/bucket/team\n" must not contain whitespace or control characters`,
+		},
+		{
+			name:        "trailing slash",
+			prefix:      "s3:s3.amazonaws.com/bucket/",
+			expectedErr: "prefix s3:s3.amazonaws.com/bucket/ must not end with a slash",
+		},
+		{
+			name:        "empty segment",
+			prefix:      "/srv//restic",
+			expectedErr: "prefix /srv//restic must not contain empty path segments",
+		},
+		{
+			name:        "parent directory segment",
+			prefix:      "s3:s3.amazonaws.com/bucket/../other-bucket",
+			expectedErr: `prefix s3:s3.amazonaws.com/bucket/../other-bucket must not contain ".." path segments`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateRepoPrefix(test.prefix)
+			if test.expectedErr != "" {
+				require.Error(t, err)
+				assert.Equal(t, test.expectedErr, err.Error())
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
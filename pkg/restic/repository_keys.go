@@ -1,6 +1,8 @@
 package restic
 
 import (
+	"sort"
+
 	"github.com/pkg/errors"
 	corev1api "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -82,3 +84,28 @@ func GetRepositoryKey(secretGetter SecretGetter, namespace string) ([]byte, erro
 
 	return key, nil
 }
+
+// GetSecretCredentials returns the repository password, from the
+// CredentialsKey key, and the restic environment variables, in KEY=VALUE
+// form and sorted, from all of the other keys, of the named secret.
+func GetSecretCredentials(secretGetter SecretGetter, namespace, name string) ([]byte, []string, error) {
+	secret, err := secretGetter.GetSecret(namespace, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, found := secret.Data[CredentialsKey]
+	if !found {
+		return nil, nil, errors.Errorf("%q secret is missing data for key %q", name, CredentialsKey)
+	}
+
+	var env []string
+	for k, v := range secret.Data {
+		if k != CredentialsKey {
+			env = append(env, k+"="+string(v))
+		}
+	}
+	sort.Strings(env)
+
+	return key, env, nil
+}
@@ -140,6 +140,13 @@ func getConfig(objectStorageConfig arkv1api.ObjectStorageProviderConfig) config
 	return c
 }
 
+// RepoPrefix returns the prefix of the restic repositories stored in the
+// provided object storage location, which is prepended to a namespace to get
+// the name of that namespace's repository.
+func RepoPrefix(objectStorageConfig arkv1api.ObjectStorageProviderConfig) string {
+	return getConfig(objectStorageConfig).repoPrefix
+}
+
 // NewRepositoryManager constructs a RepositoryManager.
 func NewRepositoryManager(
 	ctx context.Context,
@@ -224,10 +231,14 @@ func (rm *repositoryManager) ensureRepo(name string) error {
 	rm.repoLocker.LockExclusive(name)
 	defer rm.repoLocker.UnlockExclusive(name)
 
-	// init the repo
+	// init the repo. A restic server may have initialized it since it was
+	// listed.
 	cmd := InitCommand(rm.config.repoPrefix, name)
+	if err := errorOnly(rm.exec(cmd)); err != nil && ErrorKind(err) != ErrRepoExists {
+		return err
+	}
 
-	return errorOnly(rm.exec(cmd))
+	return nil
 }
 
 func (rm *repositoryManager) getAllRepos() ([]string, error) {
@@ -324,7 +335,7 @@ func (rm *repositoryManager) Forget(snapshot SnapshotIdentifier) error {
 }
 
 func (rm *repositoryManager) exec(cmd *Command) ([]byte, error) {
-	file, err := TempCredentialsFile(rm.secretsLister, cmd.Repo)
+	file, err := TempCredentialsFile(rm.secretsLister, cmd.Repo, "")
 	if err != nil {
 		return nil, err
 	}
@@ -337,7 +348,7 @@ func (rm *repositoryManager) exec(cmd *Command) ([]byte, error) {
 	rm.log.WithField("repository", cmd.Repo).Debugf("Ran restic command=%q, output=%s", cmd.String(), output)
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
-			return nil, errors.Wrapf(err, "error running command, stderr=%s", exitErr.Stderr)
+			return nil, errors.Wrap(NewError(err, string(exitErr.Stderr)), "error running command")
 		}
 		return nil, errors.Wrap(err, "error running command")
 	}
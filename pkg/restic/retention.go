@@ -0,0 +1,121 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// KeepPolicy is how many of a volume's snapshots are kept when the others
+// are forgotten after it's backed up. A zero KeepPolicy keeps all of them.
+type KeepPolicy struct {
+	// Last is the number of most recent snapshots to keep.
+	Last int
+
+	// Daily is the number of most recent days with snapshots for which
+	// the last snapshot of the day is kept.
+	Daily int
+}
+
+// IsZero returns true if the policy doesn't forget any snapshots.
+func (p KeepPolicy) IsZero() bool {
+	return p.Last == 0 && p.Daily == 0
+}
+
+// ValidateKeepPolicy returns an error if any of the policy's numbers of
+// snapshots to keep is negative.
+func ValidateKeepPolicy(p KeepPolicy) error {
+	if p.Last < 0 {
+		return errors.Errorf("the number of last snapshots to keep must not be negative, got %d", p.Last)
+	}
+	if p.Daily < 0 {
+		return errors.Errorf("the number of daily snapshots to keep must not be negative, got %d", p.Daily)
+	}
+
+	return nil
+}
+
+// ForgetPolicyCommand returns a Command for forgetting the snapshots in a
+// group, e.g. those of a volume's path, that have all of the given tags
+// and that the policy doesn't keep, with JSON output. Snapshots outside
+// the group, or without the tags, are left alone.
+func ForgetPolicyCommand(repoPrefix, repo, passwordFile string, tags map[string]string, group SnapshotGroupKey, policy KeepPolicy) *Command {
+	extraFlags := []string{"--json"}
+	if policy.Last > 0 {
+		extraFlags = append(extraFlags, fmt.Sprintf("--keep-last=%d", policy.Last))
+	}
+	if policy.Daily > 0 {
+		extraFlags = append(extraFlags, fmt.Sprintf("--keep-daily=%d", policy.Daily))
+	}
+	if group.Hostname != "" {
+		extraFlags = append(extraFlags, fmt.Sprintf("--host=%s", group.Hostname))
+	}
+	for _, path := range group.Paths {
+		extraFlags = append(extraFlags, fmt.Sprintf("--path=%s", path))
+	}
+	if len(tags) > 0 {
+		extraFlags = append(extraFlags, getSnapshotTagFlag(tags))
+	}
+	if groupBy := group.GroupBy(); groupBy != "" {
+		extraFlags = append(extraFlags, groupByFlag(groupBy))
+	}
+
+	return &Command{
+		Command:      "forget",
+		RepoPrefix:   repoPrefix,
+		Repo:         repo,
+		PasswordFile: passwordFile,
+		ExtraFlags:   extraFlags,
+	}
+}
+
+// ForgetByPolicy runs a 'restic forget' command, as returned by
+// ForgetPolicyCommand, and returns the number of snapshots it forgot.
+func ForgetByPolicy(ctx context.Context, forgetCmd *Command) (int, error) {
+	output, err := forgetCmd.CmdContext(ctx).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return 0, errors.Wrap(NewError(err, string(exitErr.Stderr)), "error running command")
+		}
+		return 0, errors.Wrap(err, "error running command")
+	}
+
+	return ParseForgetOutput(output)
+}
+
+// ParseForgetOutput parses the output of 'restic forget --json' and returns
+// the number of snapshots that were forgotten.
+func ParseForgetOutput(output []byte) (int, error) {
+	var groups []struct {
+		Remove []Snapshot `json:"remove"`
+	}
+	if err := json.Unmarshal(output, &groups); err != nil {
+		return 0, errors.Wrap(err, "error unmarshalling restic forget result")
+	}
+
+	forgotten := 0
+	for _, group := range groups {
+		forgotten += len(group.Remove)
+	}
+
+	return forgotten, nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateKeepPolicy(t *testing.T) {
+	assert.NoError(t, ValidateKeepPolicy(KeepPolicy{}))
+	assert.NoError(t, ValidateKeepPolicy(KeepPolicy{Last: 5, Daily: 7}))
+	assert.EqualError(t, ValidateKeepPolicy(KeepPolicy{Last: -1}), "the number of last snapshots to keep must not be negative, got -1")
+	assert.EqualError(t, ValidateKeepPolicy(KeepPolicy{Daily: -1}), "the number of daily snapshots to keep must not be negative, got -1")
+
+	assert.True(t, KeepPolicy{}.IsZero())
+	assert.False(t, KeepPolicy{Daily: 1}.IsZero())
+}
+
+func TestForgetPolicyCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     map[string]string
+		group    SnapshotGroupKey
+		policy   KeepPolicy
+		expected []string
+	}{
+		{
+			name:     "keep last",
+			group:    SnapshotGroupKey{Paths: []string{"/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1"}},
+			policy:   KeepPolicy{Last: 5},
+			expected: []string{"--json", "--keep-last=5", "--path=/host_pods/pod-uid/volumes/kubernetes.io~empty-dir/vol-1", "--group-by=paths"},
+		},
+		{
+			name:     "keep daily",
+			group:    SnapshotGroupKey{Paths: []string{"/data"}},
+			policy:   KeepPolicy{Daily: 7},
+			expected: []string{"--json", "--keep-daily=7", "--path=/data", "--group-by=paths"},
+		},
+		{
+			name:     "host, tags and both policies",
+			tags:     map[string]string{PolicyTag: "weekly"},
+			group:    SnapshotGroupKey{Hostname: "cluster-1", Paths: []string{"/data"}},
+			policy:   KeepPolicy{Last: 2, Daily: 7},
+			expected: []string{"--json", "--keep-last=2", "--keep-daily=7", "--host=cluster-1", "--path=/data", "--tag=policy=weekly", "--group-by=host,paths"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmd := ForgetPolicyCommand("s3:s3.amazonaws.com/bucket", "ns-1", "/tmp/credentials", test.tags, test.group, test.policy)
+
+			assert.Equal(t, "forget", cmd.Command)
+			assert.Equal(t, "ns-1", cmd.Repo)
+			assert.Equal(t, "/tmp/credentials", cmd.PasswordFile)
+			assert.Empty(t, cmd.Args)
+			assert.Equal(t, test.expected, cmd.ExtraFlags)
+		})
+	}
+}
+
+func TestParseForgetOutput(t *testing.T) {
+	tests := []struct {
+		name        string
+		output      string
+		expected    int
+		expectedErr bool
+	}{
+		{
+			name:     "snapshots removed",
+			output:   `[{"tags":null,"host":"","paths":["/data"],"keep":[{"id":"c"}],"remove":[{"id":"a"},{"id":"b"}],"reasons":[]}]`,
+			expected: 2,
+		},
+		{
+			name:     "several groups",
+			output:   `[{"paths":["/data"],"keep":[{"id":"c"}],"remove":[{"id":"a"}]},{"paths":["/logs"],"keep":[{"id":"e"}],"remove":[{"id":"d"}]}]`,
+			expected: 2,
+		},
+		{
+			name:     "nothing removed",
+			output:   `[{"paths":["/data"],"keep":[{"id":"c"}],"remove":null}]`,
+			expected: 0,
+		},
+		{
+			name:     "no matching snapshots",
+			output:   "[]\n",
+			expected: 0,
+		},
+		{
+			name:        "non-json output",
+			output:      "Applying Policy: keep 5 latest snapshots\n",
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			forgotten, err := ParseForgetOutput([]byte(test.output))
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, forgotten)
+		})
+	}
+}
+
+func TestForgetByPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restic-forget")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	restic := filepath.Join(dir, "restic")
+	cmd := ForgetPolicyCommand("s3:s3.amazonaws.com/bucket", "ns-1", "/tmp/credentials", nil, SnapshotGroupKey{Paths: []string{"/data"}}, KeepPolicy{Last: 1})
+	cmd.BaseName = restic
+
+	require.NoError(t, ioutil.WriteFile(restic, []byte(`#!/bin/sh
+echo '[{"paths":["/data"],"keep":[{"id":"c"}],"remove":[{"id":"a"},{"id":"b"}]}]'
+`), 0755))
+	forgotten, err := ForgetByPolicy(context.Background(), cmd)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, forgotten)
+
+	require.NoError(t, ioutil.WriteFile(restic, []byte("#!/bin/sh\necho 'unable to create lock in backend: repository is already locked' >&2\nexit 1\n"), 0755))
+	_, err = ForgetByPolicy(context.Background(), cmd)
+	assert.Error(t, err)
+	assert.Equal(t, ErrRepoLocked, ErrorKind(err))
+}
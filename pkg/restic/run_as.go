@@ -0,0 +1,67 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RunAs is the user and group that restic commands are run as, so that
+// restic doesn't have to run as the restic server's own user, usually root.
+type RunAs struct {
+	UID uint32
+	GID uint32
+}
+
+// ParseRunAs parses a numeric user and group given as UID:GID, or as UID
+// alone, in which case the group ID is the same as the user ID.
+func ParseRunAs(value string) (*RunAs, error) {
+	parts := strings.SplitN(value, ":", 2)
+
+	uid, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return nil, errors.Errorf("invalid user %q: must be a numeric UID or UID:GID", value)
+	}
+
+	gid := uid
+	if len(parts) == 2 {
+		if gid, err = strconv.ParseUint(parts[1], 10, 32); err != nil {
+			return nil, errors.Errorf("invalid user %q: must be a numeric UID or UID:GID", value)
+		}
+	}
+
+	return &RunAs{UID: uint32(uid), GID: uint32(gid)}, nil
+}
+
+func (r *RunAs) String() string {
+	return fmt.Sprintf("%d:%d", r.UID, r.GID)
+}
+
+// Chown makes the user and group the owners of the file at path, so that
+// restic can read it. It does nothing if r is nil.
+func (r *RunAs) Chown(path string) error {
+	if r == nil {
+		return nil
+	}
+
+	return errors.WithStack(os.Chown(path, int(r.UID), int(r.GID)))
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRunAs(t *testing.T) {
+	tests := []struct {
+		value       string
+		expected    *RunAs
+		expectedErr string
+	}{
+		{value: "1000", expected: &RunAs{UID: 1000, GID: 1000}},
+		{value: "1000:2000", expected: &RunAs{UID: 1000, GID: 2000}},
+		{value: "0:0", expected: &RunAs{}},
+		{value: "", expectedErr: `invalid user "": must be a numeric UID or UID:GID`},
+		{value: "nobody", expectedErr: `invalid user "nobody": must be a numeric UID or UID:GID`},
+		{value: "1000:", expectedErr: `invalid user "1000:": must be a numeric UID or UID:GID`},
+		{value: "1000:users", expectedErr: `invalid user "1000:users": must be a numeric UID or UID:GID`},
+		{value: "-1", expectedErr: `invalid user "-1": must be a numeric UID or UID:GID`},
+		{value: "4294967296", expectedErr: `invalid user "4294967296": must be a numeric UID or UID:GID`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			res, err := ParseRunAs(test.value)
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
+
+func TestRunAsString(t *testing.T) {
+	assert.Equal(t, "1000:2000", (&RunAs{UID: 1000, GID: 2000}).String())
+}
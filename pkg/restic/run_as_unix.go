@@ -0,0 +1,63 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"os"
+	"syscall"
+)
+
+// sysProcAttr returns the process attributes that run a command as the
+// user and group, or nil if r is nil.
+func (r *RunAs) sysProcAttr() *syscall.SysProcAttr {
+	if r == nil {
+		return nil
+	}
+
+	return &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: r.UID, Gid: r.GID},
+	}
+}
+
+// HasPermission returns true if the permission bits of the file described
+// by info give the user the permissions in perm, e.g. 05 to list a
+// directory. Only the owner, group and other bits are considered, not
+// supplementary groups or ACLs. It returns true if r is nil or is root, or
+// the file's owner isn't known.
+func (r *RunAs) HasPermission(info os.FileInfo, perm os.FileMode) bool {
+	if r == nil || r.UID == 0 {
+		return true
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+
+	mode := info.Mode().Perm()
+	switch {
+	case stat.Uid == r.UID:
+		return (mode>>6)&perm == perm
+	case stat.Gid == r.GID:
+		return (mode>>3)&perm == perm
+	default:
+		return mode&perm == perm
+	}
+}
@@ -0,0 +1,105 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestCommandRunAs(t *testing.T) {
+	cmd := &Command{Command: "backup", RepoPrefix: "s3:s3.amazonaws.com/bucket", Repo: "ns-1"}
+	assert.Nil(t, cmd.Cmd().SysProcAttr)
+	assert.Nil(t, cmd.CmdContext(context.Background()).SysProcAttr)
+
+	cmd.RunAs = &RunAs{UID: 1000, GID: 2000}
+	for _, attr := range []*syscall.SysProcAttr{cmd.Cmd().SysProcAttr, cmd.CmdContext(context.Background()).SysProcAttr} {
+		require.NotNil(t, attr)
+		assert.Equal(t, &syscall.Credential{Uid: 1000, Gid: 2000}, attr.Credential)
+	}
+}
+
+type fakeFileInfo struct {
+	os.FileInfo
+	mode os.FileMode
+	sys  interface{}
+}
+
+func (fi *fakeFileInfo) Mode() os.FileMode { return fi.mode }
+func (fi *fakeFileInfo) Sys() interface{}  { return fi.sys }
+
+func TestRunAsHasPermission(t *testing.T) {
+	dir := func(mode os.FileMode, uid, gid uint32) os.FileInfo {
+		return &fakeFileInfo{mode: os.ModeDir | mode, sys: &syscall.Stat_t{Uid: uid, Gid: gid}}
+	}
+
+	tests := []struct {
+		name     string
+		runAs    *RunAs
+		info     os.FileInfo
+		perm     os.FileMode
+		expected bool
+	}{
+		{name: "no user", info: dir(0700, 0, 0), perm: 05, expected: true},
+		{name: "root", runAs: &RunAs{}, info: dir(0700, 1000, 1000), perm: 05, expected: true},
+		{name: "owner can list", runAs: &RunAs{UID: 1000, GID: 1000}, info: dir(0500, 1000, 0), perm: 05, expected: true},
+		{name: "owner can't list", runAs: &RunAs{UID: 1000, GID: 1000}, info: dir(0177, 1000, 1000), perm: 05, expected: false},
+		{name: "group can list", runAs: &RunAs{UID: 1000, GID: 2000}, info: dir(0750, 0, 2000), perm: 05, expected: true},
+		{name: "group can only traverse", runAs: &RunAs{UID: 1000, GID: 2000}, info: dir(0717, 0, 2000), perm: 05, expected: false},
+		{name: "other can traverse", runAs: &RunAs{UID: 1000, GID: 2000}, info: dir(0711, 0, 0), perm: 01, expected: true},
+		{name: "other can't traverse", runAs: &RunAs{UID: 1000, GID: 2000}, info: dir(0750, 0, 0), perm: 01, expected: false},
+		{name: "unknown owner", runAs: &RunAs{UID: 1000, GID: 2000}, info: &fakeFileInfo{mode: os.ModeDir}, perm: 05, expected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.runAs.HasPermission(test.info, test.perm))
+		})
+	}
+}
+
+func TestCredentialsFileCacheOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("only root can change the owner of files")
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	require.NoError(t, indexer.Add(newCredentialsSecret("ns-1", "key-1")))
+
+	c := NewCredentialsFileCache(corev1listers.NewSecretLister(indexer), "")
+	c.Owner = &RunAs{UID: 1000, GID: 2000}
+	defer c.Clear()
+
+	file, err := c.Get("ns-1")
+	require.NoError(t, err)
+
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	stat := info.Sys().(*syscall.Stat_t)
+	assert.Equal(t, uint32(1000), stat.Uid)
+	assert.Equal(t, uint32(2000), stat.Gid)
+}
@@ -0,0 +1,33 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"os"
+	"syscall"
+)
+
+// sysProcAttr returns nil: commands can't be run as another user on
+// Windows.
+func (r *RunAs) sysProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// HasPermission returns true: file permissions aren't checked on Windows.
+func (r *RunAs) HasPermission(info os.FileInfo, perm os.FileMode) bool {
+	return true
+}
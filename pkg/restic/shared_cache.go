@@ -0,0 +1,63 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// sharedCacheDirName is the subdirectory of a cache directory that holds
+// the caches keyed by repository URL, kept apart from those keyed by
+// namespace.
+const sharedCacheDirName = "shared"
+
+// SharedCacheKey returns the name of the shared cache directory of the
+// repository at repoPrefix/repo: a hash of the repository's URL, without
+// any credentials embedded in it, e.g. in a rest: URL's user info. Backups
+// to the same repository therefore share its cache whichever credentials
+// they use, each repository's cache is kept apart from every other's, and
+// neither credentials nor the URL itself appear in the cache's path.
+func SharedCacheKey(repoPrefix, repo string) string {
+	url := strings.TrimRight(withoutUserInfo(repoPrefix), "/") + "/" + repo
+
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:16])
+}
+
+// withoutUserInfo returns repoPrefix with the user info, i.e. any
+// user:password@ following the scheme of a URL in it, removed.
+func withoutUserInfo(repoPrefix string) string {
+	schemeEnd := strings.Index(repoPrefix, "://")
+	if schemeEnd < 0 {
+		return repoPrefix
+	}
+
+	authorityStart := schemeEnd + len("://")
+	authority := repoPrefix[authorityStart:]
+	if slash := strings.Index(authority, "/"); slash >= 0 {
+		authority = authority[:slash]
+	}
+
+	at := strings.LastIndex(authority, "@")
+	if at < 0 {
+		return repoPrefix
+	}
+
+	return repoPrefix[:authorityStart] + repoPrefix[authorityStart+at+1:]
+}
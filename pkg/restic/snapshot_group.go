@@ -0,0 +1,122 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"encoding/json"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SnapshotGroupKey identifies a group of snapshots, as listed by
+// 'restic snapshots --group-by --json'. Only the fields that snapshots
+// are grouped by are set.
+type SnapshotGroupKey struct {
+	Hostname string   `json:"hostname"`
+	Paths    []string `json:"paths"`
+	Tags     []string `json:"tags"`
+}
+
+// GroupBy returns the value of restic's --group-by flag that groups
+// snapshots by the fields of the key that are set, e.g. "host,paths", or
+// an empty string if none are.
+func (k SnapshotGroupKey) GroupBy() string {
+	var fields []string
+	if k.Hostname != "" {
+		fields = append(fields, "host")
+	}
+	if len(k.Paths) > 0 {
+		fields = append(fields, "paths")
+	}
+	if len(k.Tags) > 0 {
+		fields = append(fields, "tags")
+	}
+
+	return strings.Join(fields, ",")
+}
+
+// Matches returns true if other is the same group as k. Paths and tags are
+// compared regardless of their order, since restic sorts them.
+func (k SnapshotGroupKey) Matches(other SnapshotGroupKey) bool {
+	return k.Hostname == other.Hostname && sameStrings(k.Paths, other.Paths) && sameStrings(k.Tags, other.Tags)
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SnapshotGroup is a group of snapshots, as listed by
+// 'restic snapshots --group-by --json'.
+type SnapshotGroup struct {
+	Key       SnapshotGroupKey `json:"group_key"`
+	Snapshots []Snapshot       `json:"snapshots"`
+}
+
+// ListSnapshotGroups runs a 'restic snapshots' command, as returned by
+// ListSnapshotsCommand with a non-empty groupBy, and returns the groups of
+// snapshots it lists.
+func ListSnapshotGroups(snapshotsCmd *Command) ([]SnapshotGroup, error) {
+	output, err := snapshotsCmd.Cmd().Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, errors.Wrap(NewError(err, string(exitErr.Stderr)), "error running command")
+		}
+		return nil, errors.Wrap(err, "error running command")
+	}
+
+	return ParseSnapshotGroups(output)
+}
+
+// ParseSnapshotGroups parses the output of
+// 'restic snapshots --group-by --json'.
+func ParseSnapshotGroups(output []byte) ([]SnapshotGroup, error) {
+	var groups []SnapshotGroup
+	if err := json.Unmarshal(output, &groups); err != nil {
+		return nil, errors.Wrap(err, "error unmarshalling restic snapshots result")
+	}
+
+	return groups, nil
+}
+
+// snapshotsInGroup returns the snapshots in the group, among groups, whose
+// key matches key, or nil if there isn't one.
+func snapshotsInGroup(groups []SnapshotGroup, key SnapshotGroupKey) []Snapshot {
+	for _, group := range groups {
+		if key.Matches(group.Key) {
+			return group.Snapshots
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotGroupKeyGroupBy(t *testing.T) {
+	assert.Equal(t, "", SnapshotGroupKey{}.GroupBy())
+	assert.Equal(t, "host", SnapshotGroupKey{Hostname: "cluster-1"}.GroupBy())
+	assert.Equal(t, "paths", SnapshotGroupKey{Paths: []string{"/data"}}.GroupBy())
+	assert.Equal(t, "host,paths,tags", SnapshotGroupKey{Hostname: "cluster-1", Paths: []string{"/data"}, Tags: []string{"pod=pod-1"}}.GroupBy())
+}
+
+func TestSnapshotGroupKeyMatches(t *testing.T) {
+	key := SnapshotGroupKey{Hostname: "cluster-1", Paths: []string{"/data", "/logs"}}
+
+	assert.True(t, key.Matches(SnapshotGroupKey{Hostname: "cluster-1", Paths: []string{"/data", "/logs"}}))
+	assert.True(t, key.Matches(SnapshotGroupKey{Hostname: "cluster-1", Paths: []string{"/logs", "/data"}}))
+	assert.False(t, key.Matches(SnapshotGroupKey{Hostname: "cluster-2", Paths: []string{"/data", "/logs"}}))
+	assert.False(t, key.Matches(SnapshotGroupKey{Hostname: "cluster-1", Paths: []string{"/data"}}))
+	assert.False(t, key.Matches(SnapshotGroupKey{Hostname: "cluster-1", Paths: []string{"/data", "/logs"}, Tags: []string{"pod=pod-1"}}))
+
+	// the key's paths aren't reordered
+	assert.Equal(t, []string{"/data", "/logs"}, key.Paths)
+}
+
+func TestParseSnapshotGroups(t *testing.T) {
+	output := `[
+	{"group_key":{"hostname":"node-1","paths":["/data"],"tags":null},"snapshots":[{"time":"2018-06-01T12:00:00Z","paths":["/data"],"hostname":"node-1","id":"abc123","short_id":"abc123"}]},
+	{"group_key":{"hostname":"node-2","paths":["/data"],"tags":null},"snapshots":[{"time":"2018-06-01T13:00:00Z","paths":["/data"],"hostname":"node-2","id":"def456","short_id":"def456"}]}
+]`
+
+	groups, err := ParseSnapshotGroups([]byte(output))
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	assert.Equal(t, SnapshotGroupKey{Hostname: "node-1", Paths: []string{"/data"}}, groups[0].Key)
+	require.Len(t, groups[0].Snapshots, 1)
+	assert.Equal(t, "abc123", groups[0].Snapshots[0].ShortID)
+	assert.Equal(t, "def456", snapshotsInGroup(groups, SnapshotGroupKey{Hostname: "node-2", Paths: []string{"/data"}})[0].ShortID)
+	assert.Nil(t, snapshotsInGroup(groups, SnapshotGroupKey{Hostname: "node-3", Paths: []string{"/data"}}))
+
+	groups, err = ParseSnapshotGroups([]byte("[]\n"))
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+
+	_, err = ParseSnapshotGroups([]byte("0 snapshots\n"))
+	assert.Error(t, err)
+}
+
+func TestListSnapshotGroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restic-snapshot-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	restic := filepath.Join(dir, "restic")
+	cmd := ListSnapshotsCommand("s3:s3.amazonaws.com/bucket", "ns-1", "/tmp/credentials", nil, "host")
+	cmd.BaseName = restic
+
+	require.NoError(t, ioutil.WriteFile(restic, []byte(`#!/bin/sh
+echo '[{"group_key":{"hostname":"node-1","paths":null,"tags":null},"snapshots":[{"time":"2018-06-01T12:00:00Z","paths":["/data"],"hostname":"node-1","id":"abc123","short_id":"abc123"}]}]'
+`), 0755))
+	groups, err := ListSnapshotGroups(cmd)
+	assert.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Equal(t, "node-1", groups[0].Key.Hostname)
+
+	require.NoError(t, ioutil.WriteFile(restic, []byte("#!/bin/sh\necho 'Fatal: repository does not exist' >&2\nexit 10\n"), 0755))
+	_, err = ListSnapshotGroups(cmd)
+	assert.Error(t, err)
+	assert.Equal(t, ErrRepoNotFound, ErrorKind(err))
+}
+
+func TestGetSnapshotIDInGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restic-snapshot-id-group")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	restic := filepath.Join(dir, "restic")
+	cmd := GetSnapshotCommand("s3:s3.amazonaws.com/bucket", "ns-1", "/tmp/credentials", map[string]string{"volume": "data"}, &SnapshotGroupKey{Hostname: "cluster-1", Paths: []string{"/host_pods/uid-1/data"}})
+	cmd.BaseName = restic
+
+	// another host's and another path's snapshots with the same tags are
+	// ignored
+	require.NoError(t, ioutil.WriteFile(restic, []byte(`#!/bin/sh
+echo '[
+{"group_key":{"hostname":"cluster-2","paths":["/host_pods/uid-1/data"],"tags":null},"snapshots":[{"time":"2018-06-01T12:00:00Z","paths":["/host_pods/uid-1/data"],"hostname":"cluster-2","id":"abc123","short_id":"abc123"}]},
+{"group_key":{"hostname":"cluster-1","paths":["/host_pods/uid-2/data"],"tags":null},"snapshots":[{"time":"2018-06-01T12:00:00Z","paths":["/host_pods/uid-2/data"],"hostname":"cluster-1","id":"def456","short_id":"def456"}]},
+{"group_key":{"hostname":"cluster-1","paths":["/host_pods/uid-1/data"],"tags":null},"snapshots":[{"time":"2018-06-01T12:00:00Z","paths":["/host_pods/uid-1/data"],"hostname":"cluster-1","id":"789abc","short_id":"789abc"}]}
+]'
+`), 0755))
+	id, err := GetSnapshotID(context.Background(), cmd)
+	assert.NoError(t, err)
+	assert.Equal(t, "789abc", id)
+
+	// no snapshot in the group
+	require.NoError(t, ioutil.WriteFile(restic, []byte(`#!/bin/sh
+echo '[{"group_key":{"hostname":"cluster-2","paths":["/host_pods/uid-1/data"],"tags":null},"snapshots":[{"time":"2018-06-01T12:00:00Z","paths":["/host_pods/uid-1/data"],"hostname":"cluster-2","id":"abc123","short_id":"abc123"}]}]'
+`), 0755))
+	_, err = GetSnapshotID(context.Background(), cmd)
+	assert.EqualError(t, err, "expected one matching snapshot, got 0")
+	assert.True(t, IsSnapshotNotFound(err))
+
+	require.NoError(t, ioutil.WriteFile(restic, []byte("#!/bin/sh\necho '[]'\n"), 0755))
+	_, err = GetSnapshotID(context.Background(), cmd)
+	assert.True(t, IsSnapshotNotFound(err))
+
+	// ungrouped output can't be parsed as groups
+	require.NoError(t, ioutil.WriteFile(restic, []byte(`#!/bin/sh
+echo '{"id":"abc123"}'
+`), 0755))
+	_, err = GetSnapshotID(context.Background(), cmd)
+	assert.Error(t, err)
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1api "k8s.io/api/core/v1"
+)
+
+// envVarReferenceRegexp matches references to environment variables,
+// $(VAR_NAME), and escaped references, $$(VAR_NAME), as expanded by the
+// kubelet in a volume mount's subPathExpr.
+var envVarReferenceRegexp = regexp.MustCompile(`\$?\$\(([-._a-zA-Z][-._a-zA-Z0-9]*)\)`)
+
+// labelOrAnnotationFieldPathRegexp matches downward API field paths that
+// select a single label or annotation, e.g. metadata.labels['app'].
+var labelOrAnnotationFieldPathRegexp = regexp.MustCompile(`^metadata\.(labels|annotations)\['(.+)'\]$`)
+
+// ResolveSubPathExpr returns the subPath, cleaned by CleanSubPath, that the
+// provided subPathExpr, e.g. logs/$(POD_NAME), resolves to for the pod's
+// volume. As the kubelet does, references to environment variables are
+// expanded using the environment of the pod's container that mounts the
+// volume. Only variables with a value, or whose value comes from a
+// downward API field of the pod, can be resolved from the pod object; an
+// error is returned if the expression references any other variable.
+func ResolveSubPathExpr(expr string, pod *corev1api.Pod, volume string) (string, error) {
+	container := volumeContainer(pod, volume)
+	if container == nil {
+		return "", errors.Errorf("no container of pod %s/%s mounts volume %s", pod.Namespace, pod.Name, volume)
+	}
+
+	resolved, unresolved := expandEnvVarReferences(expr, containerEnv(pod, container))
+	if len(unresolved) > 0 {
+		return "", errors.Errorf("subPathExpr %s references environment variables of container %s whose values can't be resolved: %s; only variables with a value or from a downward API field of the pod are supported", expr, container.Name, strings.Join(unresolved, ", "))
+	}
+
+	subPath, err := CleanSubPath(resolved)
+	if err != nil {
+		return "", errors.Wrapf(err, "subPathExpr %s resolved to an invalid subPath", expr)
+	}
+
+	return subPath, nil
+}
+
+// volumeContainer returns the first of the pod's containers, or failing
+// that its init containers, that mounts the volume, or nil if none do.
+func volumeContainer(pod *corev1api.Pod, volume string) *corev1api.Container {
+	for _, containers := range [][]corev1api.Container{pod.Spec.Containers, pod.Spec.InitContainers} {
+		for i := range containers {
+			for _, mount := range containers[i].VolumeMounts {
+				if mount.Name == volume {
+					return &containers[i]
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// containerEnv returns the values of the container's environment variables
+// that can be resolved from the pod object. Values may reference variables
+// defined before them, as with the kubelet.
+func containerEnv(pod *corev1api.Pod, container *corev1api.Container) map[string]string {
+	env := make(map[string]string, len(container.Env))
+
+	for _, envVar := range container.Env {
+		switch {
+		case envVar.ValueFrom == nil:
+			env[envVar.Name], _ = expandEnvVarReferences(envVar.Value, env)
+		case envVar.ValueFrom.FieldRef != nil:
+			if value, ok := podFieldValue(pod, envVar.ValueFrom.FieldRef.FieldPath); ok {
+				env[envVar.Name] = value
+			}
+		}
+	}
+
+	return env
+}
+
+// podFieldValue returns the value of the pod's field with the provided
+// downward API field path, and false if the field isn't supported.
+func podFieldValue(pod *corev1api.Pod, fieldPath string) (string, bool) {
+	switch fieldPath {
+	case "metadata.name":
+		return pod.Name, true
+	case "metadata.namespace":
+		return pod.Namespace, true
+	case "metadata.uid":
+		return string(pod.UID), true
+	case "spec.nodeName":
+		return pod.Spec.NodeName, true
+	case "spec.serviceAccountName":
+		return pod.Spec.ServiceAccountName, true
+	case "status.hostIP":
+		return pod.Status.HostIP, true
+	case "status.podIP":
+		return pod.Status.PodIP, true
+	}
+
+	if match := labelOrAnnotationFieldPathRegexp.FindStringSubmatch(fieldPath); match != nil {
+		if match[1] == "labels" {
+			return pod.Labels[match[2]], true
+		}
+		return pod.Annotations[match[2]], true
+	}
+
+	return "", false
+}
+
+// expandEnvVarReferences returns s with its references to environment
+// variables replaced by their values in env, and its escaped references
+// unescaped, along with the names of any variables that aren't in env,
+// whose references are left as-is.
+func expandEnvVarReferences(s string, env map[string]string) (string, []string) {
+	var unresolved []string
+
+	expanded := envVarReferenceRegexp.ReplaceAllStringFunc(s, func(reference string) string {
+		if strings.HasPrefix(reference, "$$") {
+			return reference[1:]
+		}
+
+		name := envVarReferenceRegexp.FindStringSubmatch(reference)[1]
+		value, ok := env[name]
+		if !ok {
+			unresolved = append(unresolved, name)
+			return reference
+		}
+		return value
+	})
+
+	return expanded, unresolved
+}
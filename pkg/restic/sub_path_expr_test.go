@@ -0,0 +1,154 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newSubPathExprTestPod(env ...corev1api.EnvVar) *corev1api.Pod {
+	return &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "ns-1",
+			Name:        "app-0",
+			UID:         "pod-uid",
+			Labels:      map[string]string{"app": "web"},
+			Annotations: map[string]string{"example.com/shard": "3"},
+		},
+		Spec: corev1api.PodSpec{
+			NodeName: "node-1",
+			Containers: []corev1api.Container{
+				{
+					Name: "sidecar",
+					Env:  []corev1api.EnvVar{{Name: "POD_NAME", Value: "wrong"}},
+				},
+				{
+					Name:         "app",
+					Env:          env,
+					VolumeMounts: []corev1api.VolumeMount{{Name: "data", MountPath: "/data"}},
+				},
+			},
+		},
+	}
+}
+
+func fieldRefEnvVar(name, fieldPath string) corev1api.EnvVar {
+	return corev1api.EnvVar{
+		Name:      name,
+		ValueFrom: &corev1api.EnvVarSource{FieldRef: &corev1api.ObjectFieldSelector{FieldPath: fieldPath}},
+	}
+}
+
+func TestResolveSubPathExpr(t *testing.T) {
+	tests := []struct {
+		name          string
+		env           []corev1api.EnvVar
+		expr          string
+		volume        string
+		expected      string
+		expectedError string
+	}{
+		{
+			name:     "downward API pod name",
+			env:      []corev1api.EnvVar{fieldRefEnvVar("POD_NAME", "metadata.name")},
+			expr:     "logs/$(POD_NAME)",
+			volume:   "data",
+			expected: "logs/app-0",
+		},
+		{
+			name: "downward API namespace, node name, label and annotation",
+			env: []corev1api.EnvVar{
+				fieldRefEnvVar("NAMESPACE", "metadata.namespace"),
+				fieldRefEnvVar("NODE", "spec.nodeName"),
+				fieldRefEnvVar("APP", "metadata.labels['app']"),
+				fieldRefEnvVar("SHARD", "metadata.annotations['example.com/shard']"),
+			},
+			expr:     "$(NAMESPACE)/$(APP)/$(NODE)/shard-$(SHARD)",
+			volume:   "data",
+			expected: "ns-1/web/node-1/shard-3",
+		},
+		{
+			name: "values referencing earlier variables",
+			env: []corev1api.EnvVar{
+				fieldRefEnvVar("POD_UID", "metadata.uid"),
+				{Name: "DIR", Value: "pods/$(POD_UID)"},
+			},
+			expr:     "$(DIR)/",
+			volume:   "data",
+			expected: "pods/pod-uid",
+		},
+		{
+			name:     "escaped references are kept",
+			env:      []corev1api.EnvVar{{Name: "DIR", Value: "logs"}},
+			expr:     "$(DIR)/$$(DIR)",
+			volume:   "data",
+			expected: "logs/$(DIR)",
+		},
+		{
+			name:          "variables from secrets can't be resolved",
+			env:           []corev1api.EnvVar{{Name: "TENANT", ValueFrom: &corev1api.EnvVarSource{SecretKeyRef: &corev1api.SecretKeySelector{Key: "tenant"}}}},
+			expr:          "$(TENANT)/$(MISSING)",
+			volume:        "data",
+			expectedError: "subPathExpr $(TENANT)/$(MISSING) references environment variables of container app whose values can't be resolved: TENANT, MISSING; only variables with a value or from a downward API field of the pod are supported",
+		},
+		{
+			name:          "unsupported downward API fields can't be resolved",
+			env:           []corev1api.EnvVar{fieldRefEnvVar("LABELS", "metadata.labels")},
+			expr:          "$(LABELS)",
+			volume:        "data",
+			expectedError: "subPathExpr $(LABELS) references environment variables of container app whose values can't be resolved: LABELS; only variables with a value or from a downward API field of the pod are supported",
+		},
+		{
+			name:          "resolved subPath leads outside of the volume",
+			env:           []corev1api.EnvVar{{Name: "DIR", Value: ".."}},
+			expr:          "$(DIR)/etc",
+			volume:        "data",
+			expectedError: "subPathExpr $(DIR)/etc resolved to an invalid subPath: subPath ../etc must not contain '..'",
+		},
+		{
+			name:          "resolved subPath is empty",
+			env:           []corev1api.EnvVar{fieldRefEnvVar("APP", "metadata.labels['missing']")},
+			expr:          "$(APP)",
+			volume:        "data",
+			expectedError: "subPathExpr $(APP) resolved to an invalid subPath: subPath is empty",
+		},
+		{
+			name:          "no container mounts the volume",
+			expr:          "$(POD_NAME)",
+			volume:        "scratch",
+			expectedError: "no container of pod ns-1/app-0 mounts volume scratch",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subPath, err := ResolveSubPathExpr(test.expr, newSubPathExprTestPod(test.env...), test.volume)
+			if test.expectedError != "" {
+				require.EqualError(t, err, test.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, subPath)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// podTemplateData is the data that tag value and repository prefix
+// templates are executed against.
+type podTemplateData struct {
+	Name        string
+	Namespace   string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// ResolveTags returns a copy of tags with each value that is a Go template
+// executed against the metadata of the provided pod, e.g. {{.Labels.app}} or
+// {{index .Annotations "example.com/version"}}. Labels and annotations that
+// the pod doesn't have resolve to empty strings, and tags whose values resolve
+// to empty strings are omitted. Values that aren't templates are used as-is.
+func ResolveTags(tags map[string]string, pod metav1.Object) (map[string]string, error) {
+	if len(tags) == 0 {
+		return tags, nil
+	}
+
+	data := newPodTemplateData(pod)
+
+	resolved := make(map[string]string, len(tags))
+	for key, value := range tags {
+		if !strings.Contains(value, "{{") {
+			resolved[key] = value
+			continue
+		}
+
+		tmpl, err := template.New(key).Option("missingkey=zero").Parse(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing template for tag %s", key)
+		}
+
+		buf := new(bytes.Buffer)
+		if err := tmpl.Execute(buf, data); err != nil {
+			return nil, errors.Wrapf(err, "error executing template for tag %s", key)
+		}
+
+		if buf.Len() == 0 {
+			continue
+		}
+		resolved[key] = buf.String()
+	}
+
+	return resolved, nil
+}
+
+// WithPolicyTag returns a copy of tags with the PolicyTag set to policy, to
+// tag a snapshot with, or look one up by, its policy. If policy is empty,
+// tags is returned as-is.
+func WithPolicyTag(tags map[string]string, policy string) map[string]string {
+	if policy == "" {
+		return tags
+	}
+
+	res := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		res[k] = v
+	}
+	res[PolicyTag] = policy
+
+	return res
+}
+
+// policyRegexp matches valid policies: they're used as tag values in restic
+// tag filters, where commas separate tags.
+var policyRegexp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]{0,61}[A-Za-z0-9])?$`)
+
+// ValidatePolicy returns an error if the provided policy isn't 1-63
+// alphanumeric characters, '-', '_' or '.', starting and ending with an
+// alphanumeric character.
+func ValidatePolicy(policy string) error {
+	if !policyRegexp.MatchString(policy) {
+		return errors.Errorf("policy %q must be 1-63 alphanumeric characters, '-', '_' or '.', and start and end with an alphanumeric character", policy)
+	}
+
+	return nil
+}
+
+func newPodTemplateData(pod metav1.Object) podTemplateData {
+	return podTemplateData{
+		Name:        pod.GetName(),
+		Namespace:   pod.GetNamespace(),
+		Labels:      pod.GetLabels(),
+		Annotations: pod.GetAnnotations(),
+	}
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestResolveTags(t *testing.T) {
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+			Labels: map[string]string{
+				"app":                    "db",
+				"app.kubernetes.io/name": "postgres",
+			},
+			Annotations: map[string]string{
+				"version": "10.4",
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		tags        map[string]string
+		expected    map[string]string
+		expectedErr bool
+	}{
+		{
+			name:     "no tags",
+			tags:     nil,
+			expected: nil,
+		},
+		{
+			name:     "plain values are used as-is",
+			tags:     map[string]string{"backup": "backup-1", "backup-uid": "uid-1"},
+			expected: map[string]string{"backup": "backup-1", "backup-uid": "uid-1"},
+		},
+		{
+			name: "templates are resolved from pod metadata",
+			tags: map[string]string{
+				"backup":  "backup-1",
+				"app":     "{{.Labels.app}}",
+				"version": "v{{.Annotations.version}}",
+				"pod":     "{{.Namespace}}/{{.Name}}",
+				"name":    `{{index .Labels "app.kubernetes.io/name"}}`,
+			},
+			expected: map[string]string{
+				"backup":  "backup-1",
+				"app":     "db",
+				"version": "v10.4",
+				"pod":     "ns-1/pod-1",
+				"name":    "postgres",
+			},
+		},
+		{
+			name: "missing labels and annotations are empty, and empty tags are omitted",
+			tags: map[string]string{
+				"tier":    "{{.Labels.tier}}",
+				"owner":   `{{index .Annotations "owner"}}`,
+				"release": "release-{{.Labels.release}}",
+			},
+			expected: map[string]string{
+				"release": "release-",
+			},
+		},
+		{
+			name:        "invalid template",
+			tags:        map[string]string{"app": "{{.Labels.app"},
+			expectedErr: true,
+		},
+		{
+			name:        "unknown field",
+			tags:        map[string]string{"app": "{{.Spec.NodeName}}"},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tags, err := ResolveTags(test.tags, pod)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, tags)
+		})
+	}
+}
+
+func TestWithPolicyTag(t *testing.T) {
+	tags := map[string]string{"backup": "backup-1", PolicyTag: "daily"}
+
+	// no policy leaves the tags as they are
+	assert.Equal(t, tags, WithPolicyTag(tags, ""))
+
+	// a policy overrides any policy tag, without modifying the original
+	assert.Equal(t, map[string]string{"backup": "backup-1", PolicyTag: "weekly"}, WithPolicyTag(tags, "weekly"))
+	assert.Equal(t, "daily", tags[PolicyTag])
+
+	assert.Equal(t, map[string]string{PolicyTag: "weekly"}, WithPolicyTag(nil, "weekly"))
+}
+
+func TestValidatePolicy(t *testing.T) {
+	for _, policy := range []string{"daily", "weekly", "w", "keep-90d", "tier_1.gold"} {
+		assert.NoError(t, ValidatePolicy(policy), policy)
+	}
+
+	for _, policy := range []string{"", "daily,weekly", "daily weekly", "policy=weekly", "-daily", "daily.", strings.Repeat("a", 64)} {
+		assert.Error(t, ValidatePolicy(policy), policy)
+	}
+}
+
+func TestResolveTagsPodWithoutMetadata(t *testing.T) {
+	pod := &corev1api.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "pod-1"}}
+
+	tags, err := ResolveTags(map[string]string{"app": "{{.Labels.app}}", "backup": "backup-1"}, pod)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"backup": "backup-1"}, tags)
+}
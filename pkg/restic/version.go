@@ -0,0 +1,83 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restic
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+var versionRegexp = regexp.MustCompile(`^restic (\d+)\.(\d+)\.(\d+)`)
+
+// Version is a restic release's major, minor and patch version.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// String returns the version in the form 0.9.1.
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast returns true if v is the same as or later than min.
+func (v Version) AtLeast(min Version) bool {
+	if v.Major != min.Major {
+		return v.Major > min.Major
+	}
+	if v.Minor != min.Minor {
+		return v.Minor > min.Minor
+	}
+	return v.Patch >= min.Patch
+}
+
+// ParseVersion parses the output of 'restic version', e.g. "restic 0.9.1
+// compiled with go1.10.3 on linux/amd64". Any pre-release suffix, such as
+// -dev, is ignored.
+func ParseVersion(output string) (Version, error) {
+	matches := versionRegexp.FindStringSubmatch(output)
+	if matches == nil {
+		return Version{}, errors.Errorf("unable to parse restic version %q", output)
+	}
+
+	var parts [3]int
+	for i := range parts {
+		// the regexp only matches digits, so this can only fail on overflow.
+		n, err := strconv.Atoi(matches[i+1])
+		if err != nil {
+			return Version{}, errors.Wrapf(err, "unable to parse restic version %q", output)
+		}
+		parts[i] = n
+	}
+
+	return Version{Major: parts[0], Minor: parts[1], Patch: parts[2]}, nil
+}
+
+// versionAtLeast returns true if the restic version, as output by
+// 'restic version', is at least min.
+func versionAtLeast(version string, min Version) (bool, error) {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return false, err
+	}
+
+	return v.AtLeast(min), nil
+}
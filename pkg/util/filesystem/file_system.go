@@ -20,6 +20,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 )
 
 // Interface defines methods for interacting with an
@@ -32,6 +33,8 @@ type Interface interface {
 	ReadDir(dirname string) ([]os.FileInfo, error)
 	ReadFile(filename string) ([]byte, error)
 	DirExists(path string) (bool, error)
+	Glob(pattern string) ([]string, error)
+	Walk(root string, walkFn filepath.WalkFunc) error
 }
 
 func NewFileSystem() Interface {
@@ -74,3 +77,11 @@ func (fs *osFileSystem) DirExists(path string) (bool, error) {
 	}
 	return false, err
 }
+
+func (fs *osFileSystem) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+func (fs *osFileSystem) Walk(root string, walkFn filepath.WalkFunc) error {
+	return filepath.Walk(root, walkFn)
+}
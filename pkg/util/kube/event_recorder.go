@@ -0,0 +1,130 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// EventRecorder records Kubernetes events about objects, so that they show
+// up in 'kubectl describe'. Its method matches the corresponding method of
+// client-go's record.EventRecorder.
+type EventRecorder interface {
+	// Eventf records an event of the given type (Normal or Warning) about
+	// object. Errors are logged rather than returned, since events are
+	// informational only.
+	Eventf(object runtime.Object, eventType, reason, messageFmt string, args ...interface{})
+}
+
+type eventRecorder struct {
+	client    corev1client.EventsGetter
+	scheme    *runtime.Scheme
+	component string
+	host      string
+	clock     clock.Clock
+	logger    logrus.FieldLogger
+}
+
+// NewEventRecorder returns an EventRecorder that creates events using the
+// provided client. The scheme is used to get references to objects whose
+// TypeMeta isn't populated, such as those returned from listers.
+func NewEventRecorder(client corev1client.EventsGetter, scheme *runtime.Scheme, component, host string, logger logrus.FieldLogger) EventRecorder {
+	return &eventRecorder{
+		client:    client,
+		scheme:    scheme,
+		component: component,
+		host:      host,
+		clock:     clock.RealClock{},
+		logger:    logger,
+	}
+}
+
+func (r *eventRecorder) Eventf(object runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	ref, err := r.getReference(object)
+	if err != nil {
+		r.logger.WithError(err).Errorf("Error getting reference to object for event with reason %s", reason)
+		return
+	}
+
+	now := metav1.NewTime(r.clock.Now())
+
+	event := &corev1api.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: ref.Namespace,
+			// this matches the naming scheme used by client-go's
+			// record.EventRecorder.
+			Name: fmt.Sprintf("%v.%x", ref.Name, now.UnixNano()),
+		},
+		InvolvedObject: *ref,
+		Reason:         reason,
+		Message:        fmt.Sprintf(messageFmt, args...),
+		Type:           eventType,
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Source: corev1api.EventSource{
+			Component: r.component,
+			Host:      r.host,
+		},
+	}
+
+	if _, err := r.client.Events(ref.Namespace).Create(event); err != nil {
+		r.logger.WithError(err).WithFields(logrus.Fields{
+			"namespace": ref.Namespace,
+			"name":      ref.Name,
+			"reason":    reason,
+		}).Error("Error creating event")
+	}
+}
+
+// getReference returns a reference to the given object. Unlike client-go's
+// reference.GetReference, it gets the object's group and version, as well
+// as its kind, from the scheme if its TypeMeta isn't set, rather than
+// parsing them from its self link.
+func (r *eventRecorder) getReference(object runtime.Object) (*corev1api.ObjectReference, error) {
+	gvk := object.GetObjectKind().GroupVersionKind()
+	if gvk.Empty() {
+		gvks, _, err := r.scheme.ObjectKinds(object)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		gvk = gvks[0]
+	}
+
+	objectMeta, err := meta.Accessor(object)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &corev1api.ObjectReference{
+		Kind:            gvk.Kind,
+		APIVersion:      gvk.GroupVersion().String(),
+		Namespace:       objectMeta.GetNamespace(),
+		Name:            objectMeta.GetName(),
+		UID:             objectMeta.GetUID(),
+		ResourceVersion: objectMeta.GetResourceVersion(),
+	}, nil
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/generated/clientset/versioned/scheme"
+	arktest "github.com/heptio/ark/pkg/util/test"
+)
+
+type fakeEventsGetter struct {
+	events []*corev1api.Event
+}
+
+func (g *fakeEventsGetter) Events(namespace string) corev1client.EventInterface {
+	return &fakeEventInterface{getter: g}
+}
+
+// fakeEventInterface only implements Create; calling any other method
+// panics.
+type fakeEventInterface struct {
+	corev1client.EventInterface
+	getter *fakeEventsGetter
+}
+
+func (i *fakeEventInterface) Create(event *corev1api.Event) (*corev1api.Event, error) {
+	i.getter.events = append(i.getter.events, event)
+	return event, nil
+}
+
+func TestEventRecorder(t *testing.T) {
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	getter := new(fakeEventsGetter)
+
+	recorder := NewEventRecorder(getter, scheme.Scheme, "ark-restic", "node-1", arktest.NewLogger()).(*eventRecorder)
+	recorder.clock = clock.NewFakeClock(now)
+
+	// objects from listers don't have their TypeMeta set, so the kind
+	// must come from the scheme.
+	pvb := &arkv1api.PodVolumeBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "heptio-ark",
+			Name:      "pvb-1",
+			UID:       "pvb-uid",
+		},
+	}
+
+	recorder.Eventf(pvb, corev1api.EventTypeWarning, "BackupFailed", "Backup failed (%s): %s", "Timeout", "restic backup timed out")
+
+	require.Len(t, getter.events, 1)
+	event := getter.events[0]
+
+	assert.Equal(t, "heptio-ark", event.Namespace)
+	assert.Equal(t, "pvb-1.1534077b5c848000", event.Name)
+	assert.Equal(t, corev1api.ObjectReference{
+		Kind:       "PodVolumeBackup",
+		APIVersion: arkv1api.SchemeGroupVersion.String(),
+		Namespace:  "heptio-ark",
+		Name:       "pvb-1",
+		UID:        "pvb-uid",
+	}, event.InvolvedObject)
+	assert.Equal(t, corev1api.EventTypeWarning, event.Type)
+	assert.Equal(t, "BackupFailed", event.Reason)
+	assert.Equal(t, "Backup failed (Timeout): restic backup timed out", event.Message)
+	assert.Equal(t, corev1api.EventSource{Component: "ark-restic", Host: "node-1"}, event.Source)
+	assert.Equal(t, int32(1), event.Count)
+	assert.Equal(t, metav1.NewTime(now), event.FirstTimestamp)
+	assert.Equal(t, metav1.NewTime(now), event.LastTimestamp)
+}
@@ -18,6 +18,8 @@ package kube
 
 import (
 	"fmt"
+	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 
@@ -51,29 +53,192 @@ func EnsureNamespaceExists(namespace *corev1api.Namespace, client corev1client.N
 }
 
 // GetVolumeDirectory gets the name of the directory on the host, under /var/lib/kubelet/pods/<podUID>/volumes/,
-// where the specified volume lives.
+// where the specified volume lives. PVC-backed volumes live in a directory named after their persistent volume;
+// all other volume types, e.g. emptyDir, live in a directory named after the volume. hostPath volumes don't live
+// under the pod's directory, so an error is returned for them; use GetHostPathVolume to get their directory.
 func GetVolumeDirectory(pod *corev1api.Pod, volumeName string, pvcLister corev1listers.PersistentVolumeClaimLister) (string, error) {
-	var volume *corev1api.Volume
+	volume, err := getPodVolume(pod, volumeName)
+	if err != nil {
+		return "", err
+	}
 
-	for _, item := range pod.Spec.Volumes {
-		if item.Name == volumeName {
-			volume = &item
-			break
+	switch {
+	case volume.HostPath != nil:
+		return "", errors.New("hostPath volumes are not in the pod's volumes directory")
+	case volume.PersistentVolumeClaim != nil:
+		pvc, err := pvcLister.PersistentVolumeClaims(pod.Namespace).Get(volume.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			return "", errors.WithStack(err)
 		}
+
+		return pvc.Spec.VolumeName, nil
+	default:
+		return volume.Name, nil
 	}
+}
 
-	if volume == nil {
-		return "", errors.New("volume not found in pod")
+// IsBlockVolume returns true if the specified volume is backed by a PVC whose
+// volume mode is Block, i.e. it's exposed to the pod as a raw block device
+// rather than as a directory. Such volumes live under
+// /var/lib/kubelet/pods/<podUID>/volumeDevices/ instead of volumes/, in a file
+// named after their persistent volume.
+func IsBlockVolume(pod *corev1api.Pod, volumeName string, pvcLister corev1listers.PersistentVolumeClaimLister) (bool, error) {
+	volume, err := getPodVolume(pod, volumeName)
+	if err != nil {
+		return false, err
 	}
 
-	if volume.VolumeSource.PersistentVolumeClaim == nil {
-		return volume.Name, nil
+	if volume.PersistentVolumeClaim == nil {
+		return false, nil
+	}
+
+	pvc, err := pvcLister.PersistentVolumeClaims(pod.Namespace).Get(volume.PersistentVolumeClaim.ClaimName)
+	if err != nil {
+		return false, errors.WithStack(err)
 	}
 
-	pvc, err := pvcLister.PersistentVolumeClaims(pod.Namespace).Get(volume.VolumeSource.PersistentVolumeClaim.ClaimName)
+	return pvc.Spec.VolumeMode != nil && *pvc.Spec.VolumeMode == corev1api.PersistentVolumeBlock, nil
+}
+
+// GetCSIEphemeralVolumeDirectory returns the path, relative to its volume
+// plugin's directory under /var/lib/kubelet/pods/<podUID>/volumes/, of the
+// data of the specified volume and true if it may be a CSI ephemeral volume,
+// or false if it's another type of volume. CSI ephemeral volumes are defined
+// inline in the pod spec rather than by a PVC, and are mounted by their
+// driver at kubernetes.io~csi/<volume-name>/mount. This version of the
+// Kubernetes API predates them, so their csi volume source is dropped when
+// the pod is decoded, and they're recognized by having none of the known
+// volume sources.
+func GetCSIEphemeralVolumeDirectory(pod *corev1api.Pod, volumeName string) (string, bool, error) {
+	volume, err := getPodVolume(pod, volumeName)
 	if err != nil {
-		return "", errors.WithStack(err)
+		return "", false, err
+	}
+
+	if volume.VolumeSource != (corev1api.VolumeSource{}) {
+		return "", false, nil
+	}
+
+	return filepath.Join(volume.Name, "mount"), true, nil
+}
+
+// GetHostPathVolume returns the path on the host of the specified volume and
+// true if it's a hostPath volume, or false if it's another type of volume.
+func GetHostPathVolume(pod *corev1api.Pod, volumeName string) (string, bool, error) {
+	volume, err := getPodVolume(pod, volumeName)
+	if err != nil {
+		return "", false, err
+	}
+
+	if volume.HostPath == nil {
+		return "", false, nil
+	}
+
+	return volume.HostPath.Path, true, nil
+}
+
+// GetVolumePluginDirectory returns the name of the directory, under
+// /var/lib/kubelet/pods/<podUID>/volumes/, of the plugin that mounts the
+// specified volume and true if it can be determined, or false if it can't,
+// e.g. because the volume's type is unknown or its PVC isn't bound to a
+// persistent volume that's found. The plugin of a PVC-backed volume is that
+// of its persistent volume.
+func GetVolumePluginDirectory(pod *corev1api.Pod, volumeName string, pvcLister corev1listers.PersistentVolumeClaimLister, pvLister corev1listers.PersistentVolumeLister) (string, bool, error) {
+	volume, err := getPodVolume(pod, volumeName)
+	if err != nil {
+		return "", false, err
+	}
+
+	switch {
+	case volume.EmptyDir != nil:
+		return "kubernetes.io~empty-dir", true, nil
+	case volume.Secret != nil:
+		return "kubernetes.io~secret", true, nil
+	case volume.ConfigMap != nil:
+		return "kubernetes.io~configmap", true, nil
+	case volume.Projected != nil:
+		return "kubernetes.io~projected", true, nil
+	case volume.DownwardAPI != nil:
+		return "kubernetes.io~downward-api", true, nil
+	case volume.GitRepo != nil:
+		return "kubernetes.io~git-repo", true, nil
+	case volume.NFS != nil:
+		return "kubernetes.io~nfs", true, nil
+	case volume.PersistentVolumeClaim != nil:
+		pvc, err := pvcLister.PersistentVolumeClaims(pod.Namespace).Get(volume.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			return "", false, errors.WithStack(err)
+		}
+		if pvc.Spec.VolumeName == "" {
+			return "", false, nil
+		}
+
+		pv, err := pvLister.Get(pvc.Spec.VolumeName)
+		if apierrors.IsNotFound(err) {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, errors.WithStack(err)
+		}
+
+		dir, ok := persistentVolumePluginDirectory(pv)
+		return dir, ok, nil
+	default:
+		return "", false, nil
+	}
+}
+
+// persistentVolumePluginDirectory returns the name of the directory of the
+// plugin that mounts the persistent volume and true, or false if its
+// plugin isn't known.
+func persistentVolumePluginDirectory(pv *corev1api.PersistentVolume) (string, bool) {
+	source := pv.Spec.PersistentVolumeSource
+
+	switch {
+	case source.AWSElasticBlockStore != nil:
+		return "kubernetes.io~aws-ebs", true
+	case source.GCEPersistentDisk != nil:
+		return "kubernetes.io~gce-pd", true
+	case source.AzureDisk != nil:
+		return "kubernetes.io~azure-disk", true
+	case source.AzureFile != nil:
+		return "kubernetes.io~azure-file", true
+	case source.Cinder != nil:
+		return "kubernetes.io~cinder", true
+	case source.CephFS != nil:
+		return "kubernetes.io~cephfs", true
+	case source.RBD != nil:
+		return "kubernetes.io~rbd", true
+	case source.ISCSI != nil:
+		return "kubernetes.io~iscsi", true
+	case source.FC != nil:
+		return "kubernetes.io~fc", true
+	case source.Glusterfs != nil:
+		return "kubernetes.io~glusterfs", true
+	case source.NFS != nil:
+		return "kubernetes.io~nfs", true
+	case source.Local != nil:
+		return "kubernetes.io~local-volume", true
+	case source.VsphereVolume != nil:
+		return "kubernetes.io~vsphere-volume", true
+	case source.PortworxVolume != nil:
+		return "kubernetes.io~portworx-volume", true
+	case source.CSI != nil:
+		return "kubernetes.io~csi", true
+	case source.FlexVolume != nil:
+		// flex volume drivers are named <vendor>/<driver>.
+		return strings.Replace(source.FlexVolume.Driver, "/", "~", -1), true
+	default:
+		return "", false
+	}
+}
+
+func getPodVolume(pod *corev1api.Pod, volumeName string) (*corev1api.Volume, error) {
+	for i := range pod.Spec.Volumes {
+		if pod.Spec.Volumes[i].Name == volumeName {
+			return &pod.Spec.Volumes[i], nil
+		}
 	}
 
-	return pvc.Spec.VolumeName, nil
+	return nil, errors.New("volume not found in pod")
 }
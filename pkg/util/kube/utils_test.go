@@ -18,6 +18,13 @@ package kube
 
 import (
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
 func TestNamespaceAndName(t *testing.T) {
@@ -27,3 +34,310 @@ func TestNamespaceAndName(t *testing.T) {
 func TestEnsureNamespaceExists(t *testing.T) {
 	//TODO
 }
+
+func newTestPod(volumes ...corev1api.Volume) *corev1api.Pod {
+	return &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "pod-1",
+		},
+		Spec: corev1api.PodSpec{
+			Volumes: volumes,
+		},
+	}
+}
+
+func TestGetVolumeDirectory(t *testing.T) {
+	tests := []struct {
+		name        string
+		volume      corev1api.Volume
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name: "PVC-backed volume uses the persistent volume's name",
+			volume: corev1api.Volume{
+				Name:         "data",
+				VolumeSource: corev1api.VolumeSource{PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "pvc-1"}},
+			},
+			expected: "pv-1",
+		},
+		{
+			name: "PVC-backed volume whose claim doesn't exist",
+			volume: corev1api.Volume{
+				Name:         "data",
+				VolumeSource: corev1api.VolumeSource{PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "missing"}},
+			},
+			expectedErr: true,
+		},
+		{
+			name: "emptyDir volume uses the volume's name",
+			volume: corev1api.Volume{
+				Name:         "scratch",
+				VolumeSource: corev1api.VolumeSource{EmptyDir: &corev1api.EmptyDirVolumeSource{}},
+			},
+			expected: "scratch",
+		},
+		{
+			name: "other volume types use the volume's name",
+			volume: corev1api.Volume{
+				Name:         "config",
+				VolumeSource: corev1api.VolumeSource{ConfigMap: &corev1api.ConfigMapVolumeSource{}},
+			},
+			expected: "config",
+		},
+		{
+			name: "hostPath volume is not in the pod's volumes directory",
+			volume: corev1api.Volume{
+				Name:         "logs",
+				VolumeSource: corev1api.VolumeSource{HostPath: &corev1api.HostPathVolumeSource{Path: "/var/log"}},
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			require.NoError(t, indexer.Add(&corev1api.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "pvc-1"},
+				Spec:       corev1api.PersistentVolumeClaimSpec{VolumeName: "pv-1"},
+			}))
+
+			dir, err := GetVolumeDirectory(newTestPod(test.volume), test.volume.Name, corev1listers.NewPersistentVolumeClaimLister(indexer))
+			if test.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.expected, dir)
+		})
+	}
+
+	_, err := GetVolumeDirectory(newTestPod(), "missing", nil)
+	assert.EqualError(t, err, "volume not found in pod")
+}
+
+func TestIsBlockVolume(t *testing.T) {
+	block := corev1api.PersistentVolumeBlock
+	filesystem := corev1api.PersistentVolumeFilesystem
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, pvc := range []*corev1api.PersistentVolumeClaim{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "block"},
+			Spec:       corev1api.PersistentVolumeClaimSpec{VolumeName: "pv-1", VolumeMode: &block},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "filesystem"},
+			Spec:       corev1api.PersistentVolumeClaimSpec{VolumeName: "pv-2", VolumeMode: &filesystem},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "default"},
+			Spec:       corev1api.PersistentVolumeClaimSpec{VolumeName: "pv-3"},
+		},
+	} {
+		require.NoError(t, indexer.Add(pvc))
+	}
+	pvcLister := corev1listers.NewPersistentVolumeClaimLister(indexer)
+
+	tests := []struct {
+		name        string
+		volume      corev1api.Volume
+		expected    bool
+		expectedErr bool
+	}{
+		{
+			name: "PVC with block volume mode",
+			volume: corev1api.Volume{
+				Name:         "data",
+				VolumeSource: corev1api.VolumeSource{PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "block"}},
+			},
+			expected: true,
+		},
+		{
+			name: "PVC with filesystem volume mode",
+			volume: corev1api.Volume{
+				Name:         "data",
+				VolumeSource: corev1api.VolumeSource{PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "filesystem"}},
+			},
+		},
+		{
+			name: "PVC without a volume mode defaults to filesystem",
+			volume: corev1api.Volume{
+				Name:         "data",
+				VolumeSource: corev1api.VolumeSource{PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "default"}},
+			},
+		},
+		{
+			name: "PVC that doesn't exist",
+			volume: corev1api.Volume{
+				Name:         "data",
+				VolumeSource: corev1api.VolumeSource{PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "missing"}},
+			},
+			expectedErr: true,
+		},
+		{
+			name: "non-PVC volume",
+			volume: corev1api.Volume{
+				Name:         "scratch",
+				VolumeSource: corev1api.VolumeSource{EmptyDir: &corev1api.EmptyDirVolumeSource{}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			block, err := IsBlockVolume(newTestPod(test.volume), test.volume.Name, pvcLister)
+			if test.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.expected, block)
+		})
+	}
+
+	_, err := IsBlockVolume(newTestPod(), "missing", pvcLister)
+	assert.EqualError(t, err, "volume not found in pod")
+}
+
+func TestGetCSIEphemeralVolumeDirectory(t *testing.T) {
+	pod := newTestPod(
+		// a CSI ephemeral volume's csi volume source isn't known to
+		// this version of the API, so it has no volume source.
+		corev1api.Volume{
+			Name: "inline",
+		},
+		corev1api.Volume{
+			Name:         "scratch",
+			VolumeSource: corev1api.VolumeSource{EmptyDir: &corev1api.EmptyDirVolumeSource{}},
+		},
+		corev1api.Volume{
+			Name:         "data",
+			VolumeSource: corev1api.VolumeSource{PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "claim-1"}},
+		},
+	)
+
+	dir, ok, err := GetCSIEphemeralVolumeDirectory(pod, "inline")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "inline/mount", dir)
+
+	for _, volume := range []string{"scratch", "data"} {
+		dir, ok, err = GetCSIEphemeralVolumeDirectory(pod, volume)
+		require.NoError(t, err)
+		assert.False(t, ok)
+		assert.Empty(t, dir)
+	}
+
+	_, _, err = GetCSIEphemeralVolumeDirectory(pod, "missing")
+	assert.Error(t, err)
+}
+
+func TestGetHostPathVolume(t *testing.T) {
+	pod := newTestPod(
+		corev1api.Volume{
+			Name:         "logs",
+			VolumeSource: corev1api.VolumeSource{HostPath: &corev1api.HostPathVolumeSource{Path: "/var/log"}},
+		},
+		corev1api.Volume{
+			Name:         "scratch",
+			VolumeSource: corev1api.VolumeSource{EmptyDir: &corev1api.EmptyDirVolumeSource{}},
+		},
+	)
+
+	path, ok, err := GetHostPathVolume(pod, "logs")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "/var/log", path)
+
+	path, ok, err = GetHostPathVolume(pod, "scratch")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Empty(t, path)
+
+	_, _, err = GetHostPathVolume(pod, "missing")
+	assert.Error(t, err)
+}
+
+func TestGetVolumePluginDirectory(t *testing.T) {
+	pod := newTestPod(
+		corev1api.Volume{
+			Name:         "scratch",
+			VolumeSource: corev1api.VolumeSource{EmptyDir: &corev1api.EmptyDirVolumeSource{}},
+		},
+		corev1api.Volume{
+			Name:         "config",
+			VolumeSource: corev1api.VolumeSource{ConfigMap: &corev1api.ConfigMapVolumeSource{}},
+		},
+		corev1api.Volume{
+			Name:         "data",
+			VolumeSource: corev1api.VolumeSource{PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "claim-1"}},
+		},
+		corev1api.Volume{
+			Name:         "flex",
+			VolumeSource: corev1api.VolumeSource{PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "claim-2"}},
+		},
+		corev1api.Volume{
+			Name:         "unbound",
+			VolumeSource: corev1api.VolumeSource{PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "claim-3"}},
+		},
+		corev1api.Volume{
+			Name:         "unknown",
+			VolumeSource: corev1api.VolumeSource{PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "claim-4"}},
+		},
+		corev1api.Volume{
+			Name:         "missing-pv",
+			VolumeSource: corev1api.VolumeSource{PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "claim-5"}},
+		},
+	)
+
+	pvcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for name, volumeName := range map[string]string{"claim-1": "pv-1", "claim-2": "pv-2", "claim-3": "", "claim-4": "pv-4", "claim-5": "pv-5"} {
+		require.NoError(t, pvcIndexer.Add(&corev1api.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: name},
+			Spec:       corev1api.PersistentVolumeClaimSpec{VolumeName: volumeName},
+		}))
+	}
+	pvcLister := corev1listers.NewPersistentVolumeClaimLister(pvcIndexer)
+
+	pvIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for name, source := range map[string]corev1api.PersistentVolumeSource{
+		"pv-1": {AWSElasticBlockStore: &corev1api.AWSElasticBlockStoreVolumeSource{}},
+		"pv-2": {FlexVolume: &corev1api.FlexPersistentVolumeSource{Driver: "vendor/driver"}},
+		"pv-4": {},
+	} {
+		require.NoError(t, pvIndexer.Add(&corev1api.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       corev1api.PersistentVolumeSpec{PersistentVolumeSource: source},
+		}))
+	}
+	pvLister := corev1listers.NewPersistentVolumeLister(pvIndexer)
+
+	tests := []struct {
+		volume      string
+		expectedDir string
+		expectedOK  bool
+	}{
+		{volume: "scratch", expectedDir: "kubernetes.io~empty-dir", expectedOK: true},
+		{volume: "config", expectedDir: "kubernetes.io~configmap", expectedOK: true},
+		{volume: "data", expectedDir: "kubernetes.io~aws-ebs", expectedOK: true},
+		{volume: "flex", expectedDir: "vendor~driver", expectedOK: true},
+		{volume: "unbound"},
+		{volume: "unknown"},
+		{volume: "missing-pv"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.volume, func(t *testing.T) {
+			dir, ok, err := GetVolumePluginDirectory(pod, test.volume, pvcLister, pvLister)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedOK, ok)
+			assert.Equal(t, test.expectedDir, dir)
+		})
+	}
+
+	_, _, err := GetVolumePluginDirectory(pod, "missing", pvcLister, pvLister)
+	assert.Error(t, err)
+}
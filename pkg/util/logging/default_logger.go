@@ -10,6 +10,7 @@ func DefaultHooks() []logrus.Hook {
 	return []logrus.Hook{
 		&LogLocationHook{},
 		&ErrorLocationHook{},
+		&RedactionHook{},
 	}
 }
 
@@ -0,0 +1,65 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/heptio/ark/pkg/cmd/util/flag"
+)
+
+// Format is the format in which log entries are written.
+type Format string
+
+const (
+	// FormatText writes log entries as logfmt-style key=value pairs.
+	FormatText Format = "text"
+
+	// FormatJSON writes each log entry as a JSON object, with its fields
+	// as keys, for log aggregation systems.
+	FormatJSON Format = "json"
+)
+
+// FormatFlag is a command-line flag for setting the logrus
+// log format.
+type FormatFlag struct {
+	*flag.Enum
+}
+
+// NewFormatFlag constructs a new log format flag, defaulting
+// to FormatText.
+func NewFormatFlag() *FormatFlag {
+	return &FormatFlag{
+		Enum: flag.NewEnum(string(FormatText), string(FormatText), string(FormatJSON)),
+	}
+}
+
+// Parse returns the flag's value as a Format.
+func (f *FormatFlag) Parse() Format {
+	return Format(f.String())
+}
+
+// SetFormat sets the logger's formatter to the one that writes
+// entries in format.
+func SetFormat(logger *logrus.Logger, format Format) {
+	switch format {
+	case FormatJSON:
+		logger.Formatter = new(logrus.JSONFormatter)
+	default:
+		logger.Formatter = new(logrus.TextFormatter)
+	}
+}
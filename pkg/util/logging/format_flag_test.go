@@ -0,0 +1,73 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetFormatFromFlag(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected logrus.Formatter
+	}{
+		{name: "default", expected: new(logrus.TextFormatter)},
+		{name: "text", value: "text", expected: new(logrus.TextFormatter)},
+		{name: "json", value: "json", expected: new(logrus.JSONFormatter)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			formatFlag := NewFormatFlag()
+			if test.value != "" {
+				require.NoError(t, formatFlag.Set(test.value))
+			}
+
+			logger := DefaultLogger(logrus.InfoLevel)
+			SetFormat(logger, formatFlag.Parse())
+
+			assert.IsType(t, test.expected, logger.Formatter)
+		})
+	}
+
+	assert.Error(t, NewFormatFlag().Set("xml"))
+}
+
+func TestJSONFormatIncludesFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := DefaultLogger(logrus.InfoLevel)
+	logger.Out = &buf
+	SetFormat(logger, FormatJSON)
+
+	logger.WithFields(logrus.Fields{
+		"key":    "ns-1/pvb-1",
+		"stdout": "{\"message_type\":\"summary\"}\n",
+	}).Info("Ran restic command")
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "Ran restic command", entry["msg"])
+	assert.Equal(t, "ns-1/pvb-1", entry["key"])
+	assert.Equal(t, "{\"message_type\":\"summary\"}\n", entry["stdout"])
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package test
+
+import (
+	"strconv"
+	"sync"
+
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// FakeConfigMaps is an in-memory ConfigMapInterface that, like the API
+// server, rejects updates of stale versions of a ConfigMap. Only Get,
+// Create and Update are implemented.
+type FakeConfigMaps struct {
+	corev1client.ConfigMapInterface
+
+	mu      sync.Mutex
+	items   map[string]*corev1api.ConfigMap
+	version int
+
+	// Conflicts is the number of updates to reject with a conflict
+	// error regardless of the ConfigMap's version.
+	Conflicts int
+}
+
+func NewFakeConfigMaps() *FakeConfigMaps {
+	return &FakeConfigMaps{items: make(map[string]*corev1api.ConfigMap)}
+}
+
+func (f *FakeConfigMaps) Get(name string, _ metav1.GetOptions) (*corev1api.ConfigMap, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	configMap, ok := f.items[name]
+	if !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, name)
+	}
+	return configMap.DeepCopy(), nil
+}
+
+func (f *FakeConfigMaps) Create(configMap *corev1api.ConfigMap) (*corev1api.ConfigMap, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.items[configMap.Name]; ok {
+		return nil, apierrors.NewAlreadyExists(schema.GroupResource{Resource: "configmaps"}, configMap.Name)
+	}
+	return f.store(configMap), nil
+}
+
+func (f *FakeConfigMaps) Update(configMap *corev1api.ConfigMap) (*corev1api.ConfigMap, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	existing, ok := f.items[configMap.Name]
+	if !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, configMap.Name)
+	}
+	if f.Conflicts > 0 {
+		f.Conflicts--
+		return nil, apierrors.NewConflict(schema.GroupResource{Resource: "configmaps"}, configMap.Name, nil)
+	}
+	if existing.ResourceVersion != configMap.ResourceVersion {
+		return nil, apierrors.NewConflict(schema.GroupResource{Resource: "configmaps"}, configMap.Name, nil)
+	}
+	return f.store(configMap), nil
+}
+
+func (f *FakeConfigMaps) store(configMap *corev1api.ConfigMap) *corev1api.ConfigMap {
+	f.version++
+	stored := configMap.DeepCopy()
+	stored.ResourceVersion = strconv.Itoa(f.version)
+	f.items[stored.Name] = stored
+	return stored.DeepCopy()
+}
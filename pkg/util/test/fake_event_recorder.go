@@ -0,0 +1,42 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package test
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// FakeEventRecorder records events as strings of the form
+// "<type> <reason> <message>".
+type FakeEventRecorder struct {
+	mu     sync.Mutex
+	Events []string
+}
+
+func NewFakeEventRecorder() *FakeEventRecorder {
+	return &FakeEventRecorder{}
+}
+
+func (r *FakeEventRecorder) Eventf(object runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Events = append(r.Events, fmt.Sprintf("%s %s %s", eventType, reason, fmt.Sprintf(messageFmt, args...)))
+}
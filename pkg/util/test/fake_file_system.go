@@ -3,6 +3,7 @@ package test
 import (
 	"io"
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/afero"
 )
@@ -48,6 +49,32 @@ func (fs *FakeFileSystem) DirExists(path string) (bool, error) {
 	return afero.DirExists(fs.fs, path)
 }
 
+func (fs *FakeFileSystem) Glob(pattern string) ([]string, error) {
+	var matches []string
+
+	err := afero.Walk(fs.fs, "/", func(path string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		matched, err := filepath.Match(pattern, path)
+		if err != nil {
+			return err
+		}
+		if matched {
+			matches = append(matches, path)
+		}
+
+		return nil
+	})
+
+	return matches, err
+}
+
+func (fs *FakeFileSystem) Walk(root string, walkFn filepath.WalkFunc) error {
+	return afero.Walk(fs.fs, root, walkFn)
+}
+
 func (fs *FakeFileSystem) WithFile(path string, data []byte) *FakeFileSystem {
 	file, _ := fs.fs.Create(path)
 	file.Write(data)